@@ -176,6 +176,7 @@ type EarthlyAnalytics struct {
 	ExitCode         int                       `json:"exit_code"`
 	CI               string                    `json:"ci_name"`
 	RepoHash         string                    `json:"repo_hash"`
+	ProjectHash      string                    `json:"project_hash"`
 	ExecutionSeconds float64                   `json:"execution_seconds"`
 	Terminal         bool                      `json:"terminal"`
 	Counts           map[string]map[string]int `json:"counts"`
@@ -207,6 +208,28 @@ func saveData(server string, data *EarthlyAnalytics) error {
 	return nil
 }
 
+var (
+	project   string
+	projectMu sync.Mutex
+)
+
+// SetProject records the project declared by the Earthfile being built, which is then
+// reported (hashed) when CollectAnalytics is called.
+func SetProject(p string) {
+	projectMu.Lock()
+	defer projectMu.Unlock()
+	project = p
+}
+
+func getProjectHash() string {
+	projectMu.Lock()
+	defer projectMu.Unlock()
+	if project == "" {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(project)))
+}
+
 // Count increases the global count of (subsystem, key) which then gets reported when CollectAnalytics is called.
 func Count(subsystem, key string) {
 	counts.Count(subsystem, key)
@@ -255,6 +278,7 @@ func CollectAnalytics(ctx context.Context, earthlyServer string, displayErrors b
 			ExitCode:         exitCode,
 			CI:               ciName,
 			RepoHash:         repoHash,
+			ProjectHash:      getProjectHash(),
 			ExecutionSeconds: realtime.Seconds(),
 			Terminal:         isTerminal(),
 			Counts:           countsMap,
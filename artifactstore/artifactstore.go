@@ -0,0 +1,406 @@
+package artifactstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// File is a single file (or symlink) of a stored artifact.
+type File struct {
+	// Path is the slash separated path of the file, relative to the artifact.
+	// It is empty if the artifact is a single file.
+	Path   string      `json:"path,omitempty"`
+	Mode   os.FileMode `json:"mode"`
+	Digest string      `json:"digest,omitempty"`
+	Link   string      `json:"link,omitempty"`
+	Size   int64       `json:"size"`
+}
+
+// Entry records an artifact produced by a build.
+type Entry struct {
+	// ID is the content address of the artifact: the digest of its files.
+	ID        string    `json:"id"`
+	Artifact  string    `json:"artifact"`
+	GitCommit string    `json:"git_commit,omitempty"`
+	Time      time.Time `json:"time"`
+	Dir       bool      `json:"dir"`
+	Files     []File    `json:"files"`
+}
+
+// Size returns the total size of the files of the entry.
+func (e Entry) Size() int64 {
+	var size int64
+	for _, f := range e.Files {
+		size += f.Size
+	}
+	return size
+}
+
+// Store is a content-addressed store of the artifacts saved by builds. File
+// contents are stored once under blobs/, by their sha256 digest, and each
+// saved artifact is recorded under entries/, together with the git commit
+// it was built from.
+type Store struct {
+	dir string
+	now func() time.Time
+}
+
+// Open opens (or creates) the store in the given directory.
+func Open(dir string) (*Store, error) {
+	for _, d := range []string{filepath.Join(dir, "blobs"), filepath.Join(dir, "entries")} {
+		err := os.MkdirAll(d, 0755)
+		if err != nil {
+			return nil, errors.Wrapf(err, "create artifact store %s", d)
+		}
+	}
+	return &Store{
+		dir: dir,
+		now: time.Now,
+	}, nil
+}
+
+// Put stores the file or directory at src as the given artifact. Storing the
+// same contents for the same artifact and commit again only refreshes the
+// time of the existing entry.
+func (s *Store) Put(src, artifact, gitCommit string) (Entry, error) {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return Entry{}, errors.Wrapf(err, "stat %s", src)
+	}
+	e := Entry{
+		Artifact:  artifact,
+		GitCommit: gitCommit,
+		Time:      s.now().UTC(),
+		Dir:       fi.IsDir(),
+	}
+	if !e.Dir {
+		f, err := s.putFile(src, "", fi)
+		if err != nil {
+			return Entry{}, err
+		}
+		e.Files = []File{f}
+	} else {
+		err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			if rel == "." {
+				return nil
+			}
+			f, err := s.putFile(p, filepath.ToSlash(rel), fi)
+			if err != nil {
+				return err
+			}
+			e.Files = append(e.Files, f)
+			return nil
+		})
+		if err != nil {
+			return Entry{}, errors.Wrapf(err, "store artifact %s", artifact)
+		}
+	}
+	e.ID = manifestDigest(e.Dir, e.Files)
+	dt, err := json.Marshal(e)
+	if err != nil {
+		return Entry{}, errors.Wrap(err, "marshal artifact entry")
+	}
+	err = writeFileAtomic(s.entryPath(e), dt)
+	if err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+func (s *Store) putFile(p, rel string, fi os.FileInfo) (File, error) {
+	f := File{
+		Path: rel,
+		Mode: fi.Mode(),
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(p)
+		if err != nil {
+			return File{}, errors.Wrapf(err, "read link %s", p)
+		}
+		f.Link = link
+	case fi.Mode().IsRegular():
+		digest, err := s.putBlob(p)
+		if err != nil {
+			return File{}, err
+		}
+		f.Digest = digest
+		f.Size = fi.Size()
+	case fi.IsDir():
+	default:
+		return File{}, errors.Errorf("unsupported file type %s for %s", fi.Mode().Type(), p)
+	}
+	return f, nil
+}
+
+func (s *Store) putBlob(p string) (string, error) {
+	src, err := os.Open(p)
+	if err != nil {
+		return "", errors.Wrapf(err, "open %s", p)
+	}
+	defer src.Close()
+	tmp, err := ioutil.TempFile(filepath.Join(s.dir, "blobs"), ".blob-*")
+	if err != nil {
+		return "", errors.Wrap(err, "create blob")
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), src)
+	tmp.Close()
+	if err != nil {
+		return "", errors.Wrapf(err, "copy %s", p)
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	blobPath := s.blobPath(digest)
+	if _, err := os.Stat(blobPath); err == nil {
+		return digest, nil
+	}
+	err = os.Rename(tmp.Name(), blobPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "rename blob to %s", blobPath)
+	}
+	return digest, nil
+}
+
+// List returns all the entries of the store, newest first.
+func (s *Store) List() ([]Entry, error) {
+	dir := filepath.Join(s.dir, "entries")
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read dir %s", dir)
+	}
+	entries := make([]Entry, 0, len(fis))
+	for _, fi := range fis {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		dt, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read artifact entry %s", fi.Name())
+		}
+		var e Entry
+		err = json.Unmarshal(dt, &e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse artifact entry %s", fi.Name())
+		}
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// Resolve returns the newest entry matching the ref. The ref is either the
+// (possibly abbreviated) ID of an entry, an artifact name such as +build/app,
+// or an artifact name and a (possibly abbreviated) git commit, separated by
+// @, as in +build/app@1a2b3c4.
+func (s *Store) Resolve(ref string) (Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return Entry{}, err
+	}
+	artifact, commit := ref, ""
+	if i := strings.LastIndex(ref, "@"); i != -1 {
+		artifact, commit = ref[:i], ref[i+1:]
+	}
+	for _, e := range entries {
+		if commit == "" && artifact == e.Artifact {
+			return e, nil
+		}
+		if commit != "" && artifact == e.Artifact && e.GitCommit != "" && strings.HasPrefix(e.GitCommit, commit) {
+			return e, nil
+		}
+	}
+	if commit == "" && len(ref) >= 7 {
+		for _, e := range entries {
+			if strings.HasPrefix(e.ID, ref) {
+				return e, nil
+			}
+		}
+	}
+	return Entry{}, errors.Errorf("no stored artifact matches %s", ref)
+}
+
+// Get writes the artifact of the entry to dest. If the artifact is a single
+// file and dest is an existing directory, or ends with a separator, the file
+// is placed within it.
+func (s *Store) Get(e Entry, dest string) error {
+	if !e.Dir {
+		if len(e.Files) != 1 {
+			return errors.Errorf("invalid artifact entry %s", e.ID)
+		}
+		fi, err := os.Stat(dest)
+		if strings.HasSuffix(dest, string(filepath.Separator)) || strings.HasSuffix(dest, "/") || (err == nil && fi.IsDir()) {
+			dest = filepath.Join(dest, filepath.Base(filepath.FromSlash(e.Artifact)))
+		}
+		return s.getFile(e.Files[0], dest)
+	}
+	err := os.MkdirAll(dest, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "mkdir %s", dest)
+	}
+	for _, f := range e.Files {
+		err = s.getFile(f, filepath.Join(dest, filepath.FromSlash(f.Path)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) getFile(f File, dest string) error {
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "mkdir %s", filepath.Dir(dest))
+	}
+	switch {
+	case f.Mode.IsDir():
+		err = os.MkdirAll(dest, f.Mode.Perm())
+		if err != nil {
+			return errors.Wrapf(err, "mkdir %s", dest)
+		}
+		return nil
+	case f.Link != "":
+		os.Remove(dest)
+		err = os.Symlink(f.Link, dest)
+		if err != nil {
+			return errors.Wrapf(err, "symlink %s", dest)
+		}
+		return nil
+	}
+	src, err := os.Open(s.blobPath(f.Digest))
+	if err != nil {
+		return errors.Wrapf(err, "open blob %s", f.Digest)
+	}
+	defer src.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, f.Mode.Perm())
+	if err != nil {
+		return errors.Wrapf(err, "create %s", dest)
+	}
+	_, err = io.Copy(out, src)
+	if err != nil {
+		out.Close()
+		return errors.Wrapf(err, "write %s", dest)
+	}
+	err = out.Close()
+	if err != nil {
+		return errors.Wrapf(err, "close %s", dest)
+	}
+	return os.Chmod(dest, f.Mode.Perm())
+}
+
+// GCOpt controls which entries are removed by GC.
+type GCOpt struct {
+	// Keep is the number of newest entries kept for each artifact. Zero keeps
+	// all entries.
+	Keep int
+	// OlderThan removes the entries older than this duration, even if they
+	// are within the ones kept. Zero disables removal by age.
+	OlderThan time.Duration
+}
+
+// GC removes the entries which are not retained according to the options,
+// along with the blobs no longer referenced by any entry. It returns the
+// number of entries removed and the number of bytes freed.
+func (s *Store) GC(opt GCOpt) (int, int64, error) {
+	entries, err := s.List()
+	if err != nil {
+		return 0, 0, err
+	}
+	removed := 0
+	perArtifact := make(map[string]int)
+	referenced := make(map[string]bool)
+	cutoff := s.now().Add(-opt.OlderThan)
+	for _, e := range entries {
+		perArtifact[e.Artifact]++
+		if (opt.Keep > 0 && perArtifact[e.Artifact] > opt.Keep) || (opt.OlderThan > 0 && e.Time.Before(cutoff)) {
+			err = os.Remove(s.entryPath(e))
+			if err != nil && !os.IsNotExist(err) {
+				return removed, 0, errors.Wrapf(err, "remove artifact entry %s", e.ID)
+			}
+			removed++
+			continue
+		}
+		for _, f := range e.Files {
+			if f.Digest != "" {
+				referenced[f.Digest] = true
+			}
+		}
+	}
+	blobsDir := filepath.Join(s.dir, "blobs")
+	fis, err := ioutil.ReadDir(blobsDir)
+	if err != nil {
+		return removed, 0, errors.Wrapf(err, "read dir %s", blobsDir)
+	}
+	var freed int64
+	for _, fi := range fis {
+		if referenced[fi.Name()] {
+			continue
+		}
+		err = os.Remove(filepath.Join(blobsDir, fi.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return removed, freed, errors.Wrapf(err, "remove blob %s", fi.Name())
+		}
+		freed += fi.Size()
+	}
+	return removed, freed, nil
+}
+
+func (s *Store) blobPath(digest string) string {
+	return filepath.Join(s.dir, "blobs", digest)
+}
+
+// entryPath returns the path of the entry. Entries of the same artifact built
+// from the same commit with the same contents share the path.
+func (s *Store) entryPath(e Entry) string {
+	h := sha256.Sum256([]byte(e.Artifact + "\x00" + e.GitCommit + "\x00" + e.ID))
+	return filepath.Join(s.dir, "entries", hex.EncodeToString(h[:16])+".json")
+}
+
+// manifestDigest returns the content address of an artifact.
+func manifestDigest(dir bool, files []File) string {
+	h := sha256.New()
+	if dir {
+		io.WriteString(h, "dir\n")
+	}
+	for _, f := range files {
+		io.WriteString(h, f.Path+"\x00"+f.Mode.String()+"\x00"+f.Digest+"\x00"+f.Link+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeFileAtomic(path string, dt []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return errors.Wrapf(err, "create %s", path)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(dt)
+	tmp.Close()
+	if err != nil {
+		return errors.Wrapf(err, "write %s", tmp.Name())
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return errors.Wrapf(err, "rename to %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package artifactstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestPutResolveGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifactstore")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	s, err := Open(filepath.Join(dir, "store"))
+	NoError(t, err)
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	src := filepath.Join(dir, "app")
+	NoError(t, ioutil.WriteFile(src, []byte("v1"), 0755))
+	e1, err := s.Put(src, "+build/app", "1111111111111111111111111111111111111111")
+	NoError(t, err)
+	now = now.Add(time.Hour)
+	NoError(t, ioutil.WriteFile(src, []byte("v2"), 0755))
+	e2, err := s.Put(src, "+build/app", "2222222222222222222222222222222222222222")
+	NoError(t, err)
+	False(t, e1.ID == e2.ID)
+
+	e, err := s.Resolve("+build/app")
+	NoError(t, err)
+	Equal(t, e2.ID, e.ID)
+	e, err = s.Resolve("+build/app@1111111")
+	NoError(t, err)
+	Equal(t, e1.ID, e.ID)
+	e, err = s.Resolve(e1.ID[:12])
+	NoError(t, err)
+	Equal(t, e1.ID, e.ID)
+	_, err = s.Resolve("+build/other")
+	Error(t, err)
+
+	out := filepath.Join(dir, "out") + string(filepath.Separator)
+	NoError(t, s.Get(e1, out))
+	dt, err := ioutil.ReadFile(filepath.Join(out, "app"))
+	NoError(t, err)
+	Equal(t, "v1", string(dt))
+}
+
+func TestPutDirAndGC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifactstore")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	s, err := Open(filepath.Join(dir, "store"))
+	NoError(t, err)
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	src := filepath.Join(dir, "dist")
+	NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0755))
+	NoError(t, ioutil.WriteFile(filepath.Join(src, "sub", "a.txt"), []byte("a"), 0644))
+	for i, content := range []string{"1", "2", "3"} {
+		NoError(t, ioutil.WriteFile(filepath.Join(src, "b.txt"), []byte(content), 0644))
+		_, err = s.Put(src, "+build/dist", string(rune('a'+i)))
+		NoError(t, err)
+		now = now.Add(time.Hour)
+	}
+	entries, err := s.List()
+	NoError(t, err)
+	Equal(t, 3, len(entries))
+	Equal(t, "c", entries[0].GitCommit)
+
+	out := filepath.Join(dir, "out")
+	NoError(t, s.Get(entries[2], out))
+	dt, err := ioutil.ReadFile(filepath.Join(out, "sub", "a.txt"))
+	NoError(t, err)
+	Equal(t, "a", string(dt))
+	dt, err = ioutil.ReadFile(filepath.Join(out, "b.txt"))
+	NoError(t, err)
+	Equal(t, "1", string(dt))
+
+	removed, freed, err := s.GC(GCOpt{Keep: 1})
+	NoError(t, err)
+	Equal(t, 2, removed)
+	Equal(t, int64(2), freed)
+	entries, err = s.List()
+	NoError(t, err)
+	Equal(t, 1, len(entries))
+	NoError(t, s.Get(entries[0], out))
+	dt, err = ioutil.ReadFile(filepath.Join(out, "b.txt"))
+	NoError(t, err)
+	Equal(t, "3", string(dt))
+}
@@ -0,0 +1,68 @@
+package ast
+
+import (
+	"strings"
+)
+
+// argValueFlags are the flags of ARG which take a value, which may be given as
+// a separate word, as in ARG --secret NPM_TOKEN=+secrets/NPM_TOKEN KEY=....
+var argValueFlags = map[string]bool{
+	"--secret": true,
+}
+
+// extractArgFlags removes the flags of ARG commands from the Earthfile, which
+// the grammar knows nothing about, and returns them by the line of the command
+// they belong to. The flags are replaced by spaces, so that the lines and
+// columns of the remaining commands do not change.
+func extractArgFlags(src string) (string, map[int][]string) {
+	lines := strings.Split(src, "\n")
+	flags := make(map[int][]string)
+	continued := false
+	for i, line := range lines {
+		wasContinued := continued
+		continued = strings.HasSuffix(strings.TrimRight(line, " \t\r"), "\\")
+		if wasContinued {
+			continue
+		}
+		start := len(line) - len(strings.TrimLeft(line, " \t"))
+		if !strings.HasPrefix(line[start:], "ARG ") && !strings.HasPrefix(line[start:], "ARG\t") {
+			continue
+		}
+		pos := start + len("ARG")
+		end := pos
+		var words []string
+		expectValue := false
+		for {
+			wordStart, wordEnd := nextWord(line, end)
+			word := line[wordStart:wordEnd]
+			if word == "" || (!expectValue && !strings.HasPrefix(word, "--")) {
+				break
+			}
+			words = append(words, word)
+			expectValue = !expectValue && argValueFlags[word]
+			end = wordEnd
+		}
+		if len(words) == 0 {
+			continue
+		}
+		lines[i] = line[:pos] + strings.Repeat(" ", end-pos) + line[end:]
+		flags[i+1] = words
+	}
+	return strings.Join(lines, "\n"), flags
+}
+
+// nextWord returns the bounds of the whitespace-separated word of line which
+// follows pos. The bounds are equal if there is no such word.
+func nextWord(line string, pos int) (int, int) {
+	for pos < len(line) && (line[pos] == ' ' || line[pos] == '\t') {
+		pos++
+	}
+	end := pos
+	for end < len(line) && line[end] != ' ' && line[end] != '\t' && line[end] != '\r' {
+		end++
+	}
+	if line[pos:end] == "\\" {
+		return pos, pos
+	}
+	return pos, end
+}
@@ -0,0 +1,59 @@
+package ast
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestExtractArgFlags(t *testing.T) {
+	src := "VERSION 0.6\n" +
+		"ARG --host HOST_ARCH=$(uname -m)\n" +
+		"build:\n" +
+		"    ARG --secret NPM_TOKEN=+secrets/NPM_TOKEN --host \\\n" +
+		"        LATEST=$(npm view pkg version)\n" +
+		"    ARG --expr=true IS_ARM=\"$HOST_ARCH\" == aarch64\n" +
+		"    ARG VERSION=--host\n" +
+		"    RUN echo ARG --host\n"
+	out, flags := extractArgFlags(src)
+	Equal(t, "VERSION 0.6\n"+
+		"ARG        HOST_ARCH=$(uname -m)\n"+
+		"build:\n"+
+		"    ARG                                              \\\n"+
+		"        LATEST=$(npm view pkg version)\n"+
+		"    ARG             IS_ARM=\"$HOST_ARCH\" == aarch64\n"+
+		"    ARG VERSION=--host\n"+
+		"    RUN echo ARG --host\n", out)
+	Equal(t, map[int][]string{
+		2: {"--host"},
+		4: {"--secret", "NPM_TOKEN=+secrets/NPM_TOKEN", "--host"},
+		6: {"--expr=true"},
+	}, flags)
+}
+
+func TestParseArgFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-ast")
+	if !NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "Earthfile")
+	src := "VERSION 0.6\n" +
+		"test:\n" +
+		"    LOCALLY\n" +
+		"    ARG --host --secret NPM_TOKEN=+secrets/NPM_TOKEN LATEST=$(npm view pkg version)\n"
+	if !NoError(t, ioutil.WriteFile(path, []byte(src), 0644)) {
+		return
+	}
+	ef, err := Parse(context.Background(), path, false)
+	if !NoError(t, err) {
+		return
+	}
+	cmd := ef.Targets[0].Recipe[1].Command
+	Equal(t, "ARG", cmd.Name)
+	Equal(t, []string{"--host", "--secret", "NPM_TOKEN=+secrets/NPM_TOKEN", "LATEST", "=", "$(npm view pkg version)"}, cmd.Args)
+}
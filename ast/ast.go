@@ -3,6 +3,7 @@ package ast
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"strings"
 
 	"github.com/antlr/antlr4/runtime/Go/antlr"
@@ -19,14 +20,29 @@ func Parse(ctx context.Context, filePath string, enableSourceMap bool) (ef spec.
 		return spec.Earthfile{}, err
 	}
 
+	dt, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return spec.Earthfile{}, errors.Wrapf(err, "read %s", filePath)
+	}
+	src, heredocs, err := extractHeredocs(string(dt))
+	if err != nil {
+		return spec.Earthfile{}, errors.Wrapf(err, "parse %s", filePath)
+	}
+	src, requires, err := extractRequires(src)
+	if err != nil {
+		return spec.Earthfile{}, errors.Wrapf(err, "parse %s", filePath)
+	}
+	src, argFlags := extractArgFlags(src)
+	lines := strings.Split(src, "\n")
+
 	// Convert.
 	errorListener := antlrhandler.NewReturnErrorListener()
 	errorStrategy := antlrhandler.NewReturnErrorStrategy()
-	tree, err := newEarthfileTree(filePath, errorListener, errorStrategy)
+	tree, err := newEarthfileTree(src, errorListener, errorStrategy)
 	if err != nil {
 		return spec.Earthfile{}, err
 	}
-	ef, walkErr := walkTree(newListener(ctx, filePath, enableSourceMap), tree)
+	ef, walkErr := walkTree(newListener(ctx, filePath, lines, heredocs, requires, argFlags, enableSourceMap), tree)
 	if len(errorListener.Errs) > 0 {
 		errString := []string{fmt.Sprintf("lexer error: %s", filePath)}
 		for _, err := range errorListener.Errs {
@@ -64,12 +80,8 @@ func walkTree(l *listener, tree parser.IEarthFileContext) (spec.Earthfile, error
 	return l.Earthfile(), nil
 }
 
-func newEarthfileTree(filename string, errorListener *antlrhandler.ReturnErrorListener, errorStrategy antlr.ErrorStrategy) (parser.IEarthFileContext, error) {
-	input, err := antlr.NewFileStream(filename)
-	if err != nil {
-		return nil, errors.Wrapf(err, "new file stream %s", filename)
-	}
-	lexer := newLexer(input)
+func newEarthfileTree(src string, errorListener *antlrhandler.ReturnErrorListener, errorStrategy antlr.ErrorStrategy) (parser.IEarthFileContext, error) {
+	lexer := newLexer(antlr.NewInputStream(src))
 	lexer.RemoveErrorListeners()
 	lexer.AddErrorListener(errorListener)
 	stream := antlr.NewCommonTokenStream(lexer, 0)
@@ -0,0 +1,61 @@
+package ast
+
+import (
+	"strings"
+
+	"github.com/earthly/earthly/ast/spec"
+)
+
+// docComment returns the doc comment of the statement at the given line
+// (1-based): the comment lines which directly precede it, at the same
+// indentation, without their leading "# ".
+func docComment(lines []string, line int) string {
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	stmt := strings.TrimRight(lines[line-1], "\r")
+	indent := stmt[:len(stmt)-len(strings.TrimLeft(stmt, " \t"))]
+	var doc []string
+	for i := line - 2; i >= 0; i-- {
+		l := strings.TrimRight(lines[i], "\r")
+		if !strings.HasPrefix(l, indent+"#") {
+			break
+		}
+		text := strings.TrimPrefix(l, indent+"#")
+		text = strings.TrimPrefix(text, " ")
+		doc = append([]string{text}, doc...)
+	}
+	return strings.TrimSpace(strings.Join(doc, "\n"))
+}
+
+// deprecation returns the deprecation notice of the doc comment, if any. The
+// message is the rest of the paragraph starting with "Deprecated:", and the
+// optional replacement (such as a target to use instead) is given on a line
+// starting with "Replacement:".
+func deprecation(doc string) *spec.Deprecation {
+	var message []string
+	replacement := ""
+	deprecated, inMessage := false, false
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Deprecated:"):
+			message = append(message, strings.TrimSpace(strings.TrimPrefix(line, "Deprecated:")))
+			deprecated, inMessage = true, true
+		case strings.HasPrefix(line, "Replacement:"):
+			replacement = strings.TrimSpace(strings.TrimPrefix(line, "Replacement:"))
+			inMessage = false
+		case line == "":
+			inMessage = false
+		case inMessage:
+			message = append(message, line)
+		}
+	}
+	if !deprecated {
+		return nil
+	}
+	return &spec.Deprecation{
+		Message:     strings.TrimSpace(strings.Join(message, " ")),
+		Replacement: replacement,
+	}
+}
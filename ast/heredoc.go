@@ -0,0 +1,155 @@
+package ast
+
+import (
+	"strings"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/pkg/errors"
+)
+
+// heredocCommands are the commands which may use here-documents.
+var heredocCommands = map[string]bool{
+	"RUN":  true,
+	"COPY": true,
+}
+
+// heredocMarker is a here-document redirection of a command line, as in
+// <<EOF, <<-EOF or <<"EOF".
+type heredocMarker struct {
+	delimiter string
+	stripTabs bool
+	quoted    bool
+}
+
+// extractHeredocs removes the bodies of the here-documents of RUN and COPY
+// commands from the Earthfile, which the grammar knows nothing about, and
+// returns them by the line of the command they belong to. The lines of the
+// bodies are replaced by empty lines, so that the lines of the remaining
+// commands do not change.
+func extractHeredocs(src string) (string, map[int][]spec.Heredoc, error) {
+	lines := strings.Split(src, "\n")
+	heredocs := make(map[int][]spec.Heredoc)
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimLeft(lines[i], " \t")
+		fields := strings.Fields(trimmed)
+		if len(fields) == 0 || !heredocCommands[fields[0]] {
+			continue
+		}
+		startLine := i + 1
+		indent := lines[i][:len(lines[i])-len(trimmed)]
+		var markers []heredocMarker
+		for {
+			markers = append(markers, findHeredocMarkers(lines[i])...)
+			if !strings.HasSuffix(strings.TrimRight(lines[i], " \t\r"), "\\") || i+1 == len(lines) {
+				break
+			}
+			i++
+		}
+		for _, m := range markers {
+			var body []string
+			terminated := false
+			for i+1 < len(lines) {
+				i++
+				line := strings.TrimRight(lines[i], "\r")
+				lines[i] = ""
+				if strings.TrimSpace(line) == m.delimiter {
+					terminated = true
+					break
+				}
+				line = strings.TrimPrefix(line, indent)
+				if m.stripTabs {
+					line = strings.TrimLeft(line, "\t")
+				}
+				body = append(body, line)
+			}
+			if !terminated {
+				return "", nil, errors.Errorf("line %d: here-document <<%s is not terminated by a line containing %s", startLine, m.delimiter, m.delimiter)
+			}
+			content := ""
+			if len(body) > 0 {
+				content = strings.Join(body, "\n") + "\n"
+			}
+			heredocs[startLine] = append(heredocs[startLine], spec.Heredoc{
+				Delimiter: m.delimiter,
+				Content:   content,
+				Expand:    !m.quoted,
+			})
+		}
+	}
+	return strings.Join(lines, "\n"), heredocs, nil
+}
+
+// findHeredocMarkers returns the here-document redirections of a command
+// line, ignoring those within quotes.
+func findHeredocMarkers(line string) []heredocMarker {
+	var markers []heredocMarker
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\\':
+			i++
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '<' && strings.HasPrefix(line[i:], "<<") && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			m, n := parseHeredocMarker(line[i+2:])
+			if n == 0 {
+				i++
+				continue
+			}
+			markers = append(markers, m)
+			i += 1 + n
+		}
+	}
+	return markers
+}
+
+// parseHeredocMarker parses the part of a here-document redirection after
+// the <<, returning it along with its length, or a length of 0 if s is not a
+// here-document redirection (e.g. a <<< here-string).
+func parseHeredocMarker(s string) (heredocMarker, int) {
+	var m heredocMarker
+	n := 0
+	if strings.HasPrefix(s, "-") {
+		m.stripTabs = true
+		n++
+	}
+	var quote byte
+	if n < len(s) && (s[n] == '\'' || s[n] == '"') {
+		quote = s[n]
+		m.quoted = true
+		n++
+	}
+	start := n
+	for n < len(s) && isHeredocDelimiterChar(s[n], n == start) {
+		n++
+	}
+	if n == start {
+		return heredocMarker{}, 0
+	}
+	m.delimiter = s[start:n]
+	if quote != 0 {
+		if n == len(s) || s[n] != quote {
+			return heredocMarker{}, 0
+		}
+		n++
+	}
+	return m, n
+}
+
+func isHeredocDelimiterChar(ch byte, first bool) bool {
+	switch {
+	case ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z'):
+		return true
+	case (ch >= '0' && ch <= '9') || ch == '.' || ch == '-':
+		return !first
+	default:
+		return false
+	}
+}
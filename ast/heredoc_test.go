@@ -0,0 +1,48 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestExtractHeredocs(t *testing.T) {
+	src := "build:\n" +
+		"    RUN <<EOF\n" +
+		"    set -e\n" +
+		"      echo \"<<NOT\"\n" +
+		"    EOF\n" +
+		"    COPY <<'conf' <<-script /etc/app/\n" +
+		"    port=$PORT\n" +
+		"    conf\n" +
+		"    \techo hi\n" +
+		"    script\n" +
+		"    RUN echo '<<NOT' && cat <<<here\n"
+	out, heredocs, err := extractHeredocs(src)
+	NoError(t, err)
+	Equal(t, "build:\n"+
+		"    RUN <<EOF\n\n\n\n"+
+		"    COPY <<'conf' <<-script /etc/app/\n\n\n\n\n"+
+		"    RUN echo '<<NOT' && cat <<<here\n", out)
+	Equal(t, map[int][]spec.Heredoc{
+		2: {{Delimiter: "EOF", Content: "set -e\n  echo \"<<NOT\"\n", Expand: true}},
+		6: {
+			{Delimiter: "conf", Content: "port=$PORT\n"},
+			{Delimiter: "script", Content: "echo hi\n", Expand: true},
+		},
+	}, heredocs)
+}
+
+func TestExtractHeredocsContinuation(t *testing.T) {
+	out, heredocs, err := extractHeredocs("RUN --no-cache \\\n    python3 <<EOF\nprint(1)\nEOF\nRUN true")
+	NoError(t, err)
+	Equal(t, "RUN --no-cache \\\n    python3 <<EOF\n\n\nRUN true", out)
+	Equal(t, map[int][]spec.Heredoc{1: {{Delimiter: "EOF", Content: "print(1)\n", Expand: true}}}, heredocs)
+}
+
+func TestExtractHeredocsUnterminated(t *testing.T) {
+	_, _, err := extractHeredocs("RUN cat <<EOF\nhello\n")
+	Error(t, err)
+	Contains(t, err.Error(), "not terminated")
+}
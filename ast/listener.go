@@ -36,12 +36,16 @@ type listener struct {
 
 	ctx             context.Context
 	filePath        string
+	lines           []string
+	heredocs        map[int][]spec.Heredoc
+	requires        map[string]*spec.Requires
+	argFlags        map[int][]string
 	enableSourceMap bool
 
 	err error
 }
 
-func newListener(ctx context.Context, filePath string, enableSourceMap bool) *listener {
+func newListener(ctx context.Context, filePath string, lines []string, heredocs map[int][]spec.Heredoc, requires map[string]*spec.Requires, argFlags map[int][]string, enableSourceMap bool) *listener {
 	ef := &spec.Earthfile{}
 	if enableSourceMap {
 		ef.SourceLocation = &spec.SourceLocation{
@@ -51,6 +55,10 @@ func newListener(ctx context.Context, filePath string, enableSourceMap bool) *li
 	return &listener{
 		ctx:             ctx,
 		filePath:        filePath,
+		lines:           lines,
+		heredocs:        heredocs,
+		requires:        requires,
+		argFlags:        argFlags,
 		enableSourceMap: enableSourceMap,
 		ef:              ef,
 	}
@@ -95,6 +103,8 @@ func (l *listener) ExitEarthFile(c *parser.EarthFileContext) {
 
 func (l *listener) EnterTarget(c *parser.TargetContext) {
 	l.target = new(spec.Target)
+	l.target.Docs = docComment(l.lines, c.GetStart().GetLine())
+	l.target.Deprecated = deprecation(l.target.Docs)
 	if l.enableSourceMap {
 		l.target.SourceLocation = &spec.SourceLocation{
 			File:        l.filePath,
@@ -109,6 +119,7 @@ func (l *listener) EnterTarget(c *parser.TargetContext) {
 
 func (l *listener) EnterTargetHeader(c *parser.TargetHeaderContext) {
 	l.target.Name = strings.TrimSuffix(c.GetText(), ":")
+	l.target.Requires = l.requires[l.target.Name]
 }
 
 func (l *listener) ExitTarget(c *parser.TargetContext) {
@@ -121,6 +132,7 @@ func (l *listener) ExitTarget(c *parser.TargetContext) {
 
 func (l *listener) EnterUserCommand(c *parser.UserCommandContext) {
 	l.userCommand = new(spec.UserCommand)
+	l.userCommand.Docs = docComment(l.lines, c.GetStart().GetLine())
 	if l.enableSourceMap {
 		l.userCommand.SourceLocation = &spec.SourceLocation{
 			File:        l.filePath,
@@ -183,6 +195,7 @@ func (l *listener) EnterCommandStmt(c *parser.CommandStmtContext) {
 func (l *listener) ExitCommandStmt(c *parser.CommandStmtContext) {
 	l.command.Args = l.stmtWords
 	l.command.ExecMode = l.execMode
+	l.command.Heredocs = l.heredocs[c.GetStart().GetLine()]
 	l.block().statement.Command = l.command
 	l.command = nil
 }
@@ -251,6 +264,9 @@ func (l *listener) EnterEnvStmt(c *parser.EnvStmtContext) {
 
 func (l *listener) EnterArgStmt(c *parser.ArgStmtContext) {
 	l.command.Name = "ARG"
+	l.stmtWords = append(l.stmtWords, l.argFlags[c.GetStart().GetLine()]...)
+	l.command.Docs = docComment(l.lines, c.GetStart().GetLine())
+	l.command.Deprecated = deprecation(l.command.Docs)
 }
 
 func (l *listener) EnterLabelStmt(c *parser.LabelStmtContext) {
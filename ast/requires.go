@@ -0,0 +1,120 @@
+package ast
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/pkg/errors"
+)
+
+var (
+	targetHeaderRegexp      = regexp.MustCompile(`^([a-z][a-zA-Z0-9.-]*):\s*(#.*)?$`)
+	userCommandHeaderRegexp = regexp.MustCompile(`^[A-Z][A-Z0-9._]*:\s*(#.*)?$`)
+	requiresArgNameRegexp   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// extractRequires removes the REQUIRES declarations from the Earthfile, which
+// the grammar knows nothing about, and returns them by the name of the target
+// they belong to. The lines of the declarations are replaced by empty lines,
+// so that the lines of the remaining commands do not change.
+func extractRequires(src string) (string, map[string]*spec.Requires, error) {
+	lines := strings.Split(src, "\n")
+	requires := make(map[string]*spec.Requires)
+	target := ""
+	inUserCommand := false
+	continued := false
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t\r")
+		wasContinued := continued
+		continued = strings.HasSuffix(line, "\\")
+		if wasContinued || line == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			switch {
+			case targetHeaderRegexp.MatchString(line):
+				target = targetHeaderRegexp.FindStringSubmatch(line)[1]
+				inUserCommand = false
+			case userCommandHeaderRegexp.MatchString(line):
+				target = ""
+				inUserCommand = true
+			case !strings.HasPrefix(line, "#"):
+				target = ""
+				inUserCommand = false
+			}
+		}
+		if fields := strings.Fields(line); fields[0] != "REQUIRES" {
+			continue
+		}
+		startLine := i + 1
+		text := strings.TrimSuffix(line, "\\")
+		lines[i] = ""
+		for continued && i+1 < len(lines) {
+			i++
+			line = strings.TrimRight(lines[i], " \t\r")
+			lines[i] = ""
+			continued = strings.HasSuffix(line, "\\")
+			text += " " + strings.TrimSuffix(line, "\\")
+		}
+		switch {
+		case inUserCommand:
+			return "", nil, errors.Errorf("line %d: REQUIRES is not allowed in user commands", startLine)
+		case target == "":
+			return "", nil, errors.Errorf("line %d: REQUIRES is only allowed in targets", startLine)
+		}
+		r, ok := requires[target]
+		if !ok {
+			r = &spec.Requires{}
+			requires[target] = r
+		}
+		err := parseRequires(strings.Fields(text)[1:], r)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "line %d", startLine)
+		}
+	}
+	return strings.Join(lines, "\n"), requires, nil
+}
+
+// parseRequires parses the flags of a REQUIRES declaration into r, as in
+// REQUIRES --secret=NPM_TOKEN --arg=VERSION --tool=kubectl.
+func parseRequires(words []string, r *spec.Requires) error {
+	if len(words) == 0 {
+		return errors.New("REQUIRES requires at least one of --secret, --arg or --tool")
+	}
+	for i := 0; i < len(words); i++ {
+		name, value := words[i], ""
+		if eq := strings.Index(name, "="); eq != -1 {
+			name, value = name[:eq], name[eq+1:]
+		} else if i+1 < len(words) {
+			i++
+			value = words[i]
+		}
+		if value == "" || strings.HasPrefix(value, "--") {
+			return errors.Errorf("REQUIRES %s requires a value", name)
+		}
+		switch name {
+		case "--secret":
+			r.Secrets = appendUnique(r.Secrets, strings.TrimPrefix(value, "+secrets/"))
+		case "--arg":
+			if !requiresArgNameRegexp.MatchString(value) {
+				return errors.Errorf("REQUIRES --arg: invalid ARG name %q", value)
+			}
+			r.Args = appendUnique(r.Args, value)
+		case "--tool":
+			r.Tools = appendUnique(r.Tools, value)
+		default:
+			return errors.Errorf("REQUIRES: unknown flag %s; valid flags are --secret, --arg and --tool", name)
+		}
+	}
+	return nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,55 @@
+package ast
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestExtractRequires(t *testing.T) {
+	src := "VERSION 0.6\n" +
+		"deploy: # deploys\n" +
+		"    LOCALLY\n" +
+		"    REQUIRES --secret=+secrets/KUBECONFIG --arg VERSION \\\n" +
+		"        --tool=kubectl\n" +
+		"# comment\n" +
+		"    REQUIRES --tool=kubectl --tool helm\n" +
+		"    RUN kubectl apply -f deploy.yaml\n" +
+		"build:\n" +
+		"    RUN echo REQUIRES\n"
+	out, requires, err := extractRequires(src)
+	NoError(t, err)
+	Equal(t, "VERSION 0.6\n"+
+		"deploy: # deploys\n"+
+		"    LOCALLY\n\n\n"+
+		"# comment\n\n"+
+		"    RUN kubectl apply -f deploy.yaml\n"+
+		"build:\n"+
+		"    RUN echo REQUIRES\n", out)
+	Equal(t, map[string]*spec.Requires{
+		"deploy": {
+			Secrets: []string{"KUBECONFIG"},
+			Args:    []string{"VERSION"},
+			Tools:   []string{"kubectl", "helm"},
+		},
+	}, requires)
+}
+
+func TestExtractRequiresErrors(t *testing.T) {
+	tests := map[string]string{
+		"REQUIRES --arg=VERSION\n":                        "only allowed in targets",
+		"DEPLOY:\n    REQUIRES --arg=VERSION\n":           "not allowed in user commands",
+		"deploy:\n    REQUIRES\n":                         "at least one",
+		"deploy:\n    REQUIRES --arg=1VERSION\n":          "invalid ARG name",
+		"deploy:\n    REQUIRES --secret --arg=VERSION\n":  "requires a value",
+		"deploy:\n    REQUIRES --image=alpine\n":          "unknown flag",
+		"build:\n    RUN true\nREQUIRES --tool=kubectl\n": "only allowed in targets",
+	}
+	for src, msg := range tests {
+		_, _, err := extractRequires(src)
+		if Error(t, err, src) {
+			Contains(t, err.Error(), msg, src)
+		}
+	}
+}
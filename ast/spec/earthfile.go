@@ -14,6 +14,24 @@ type Target struct {
 	Name           string          `json:"name"`
 	Recipe         Block           `json:"recipe"`
 	SourceLocation *SourceLocation `json:"sourceLocation,omitempty"`
+	// Docs is the doc comment of the target: the comment lines directly
+	// preceding it.
+	Docs string `json:"docs,omitempty"`
+	// Deprecated is set if the doc comment of the target marks it as
+	// deprecated.
+	Deprecated *Deprecation `json:"deprecated,omitempty"`
+	// Requires is what the target declares it needs from the environment,
+	// via REQUIRES.
+	Requires *Requires `json:"requires,omitempty"`
+}
+
+// Requires is the AST representation of the REQUIRES declarations of a
+// target: the secrets and ARGs it needs to be passed, and the host tools its
+// LOCALLY commands need to find.
+type Requires struct {
+	Secrets []string `json:"secrets,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	Tools   []string `json:"tools,omitempty"`
 }
 
 // UserCommand is the AST representation of an Earthfile user command definition.
@@ -21,6 +39,9 @@ type UserCommand struct {
 	Name           string          `json:"name"`
 	Recipe         Block           `json:"recipe"`
 	SourceLocation *SourceLocation `json:"sourceLocation,omitempty"`
+	// Docs is the doc comment of the user command: the comment lines directly
+	// preceding it.
+	Docs string `json:"docs,omitempty"`
 }
 
 // Version is the AST representation of an Earthfile version definition.
@@ -48,6 +69,33 @@ type Command struct {
 	Args           []string        `json:"args"`
 	ExecMode       bool            `json:"execMode,omitempty"`
 	SourceLocation *SourceLocation `json:"sourceLocation,omitempty"`
+	// Docs is the doc comment of the command: the comment lines directly
+	// preceding it, at the same indentation. It is only set for ARG commands.
+	Docs string `json:"docs,omitempty"`
+	// Deprecated is set if the doc comment of the ARG marks it as deprecated.
+	Deprecated *Deprecation `json:"deprecated,omitempty"`
+	// Heredocs are the here-documents of the command, as in RUN <<EOF, in the
+	// order of their redirections. They are only set for RUN and COPY.
+	Heredocs []Heredoc `json:"heredocs,omitempty"`
+}
+
+// Heredoc is the AST representation of a here-document.
+type Heredoc struct {
+	// Delimiter is the word which ends the here-document, as in EOF.
+	Delimiter string `json:"delimiter"`
+	// Content is the text of the here-document, ending with a newline unless
+	// empty.
+	Content string `json:"content"`
+	// Expand is set unless the delimiter is quoted, as in <<"EOF", in which
+	// case variables are not expanded within the content.
+	Expand bool `json:"expand,omitempty"`
+}
+
+// Deprecation is the AST representation of a deprecation notice, as in the
+// doc comment lines "Deprecated: <message>" and "Replacement: <replacement>".
+type Deprecation struct {
+	Message     string `json:"message"`
+	Replacement string `json:"replacement,omitempty"`
 }
 
 // WithStatement is the AST representation of a with statement.
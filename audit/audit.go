@@ -0,0 +1,200 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Kind is the kind of side effect recorded in the audit log.
+type Kind string
+
+const (
+	// KindPush is an image push to a remote registry.
+	KindPush Kind = "push"
+	// KindRunPush is a RUN --push command being executed.
+	KindRunPush Kind = "run-push"
+	// KindLocalWrite is an artifact being written to the host filesystem.
+	KindLocalWrite Kind = "local-write"
+	// KindLocally is a command executed on the host via LOCALLY.
+	KindLocally Kind = "locally"
+	// KindSecret is a secret being handed out to the build.
+	KindSecret Kind = "secret"
+)
+
+// Entry is a single line of the audit log.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Kind      Kind      `json:"kind"`
+	Target    string    `json:"target,omitempty"`
+	Subject   string    `json:"subject"`
+	PrevHash  string    `json:"prev_hash"`
+	Signature string    `json:"signature,omitempty"`
+}
+
+// Log is an append-only audit log, written as JSON lines. Each entry carries
+// the hash of the line before it, so that removing or reordering entries can
+// be detected. If a key is provided, each entry is additionally signed using
+// HMAC-SHA256.
+type Log struct {
+	mu       sync.Mutex
+	f        *os.File
+	key      []byte
+	prevHash string
+	now      func() time.Time
+}
+
+// NewLog opens (or creates) the audit log at the given path. The key may be
+// empty, in which case entries are chained but not signed.
+func NewLog(path string, key []byte) (*Log, error) {
+	prevHash, err := lastLineHash(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open audit log %s", path)
+	}
+	return &Log{
+		f:        f,
+		key:      key,
+		prevHash: prevHash,
+		now:      time.Now,
+	}, nil
+}
+
+// Record appends an entry to the log. It is safe to call Record on a nil Log,
+// in which case nothing is recorded.
+func (l *Log) Record(kind Kind, target, subject string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := Entry{
+		Time:     l.now().UTC(),
+		Kind:     kind,
+		Target:   target,
+		Subject:  subject,
+		PrevHash: l.prevHash,
+	}
+	line, err := encodeEntry(e, l.key)
+	if err != nil {
+		return err
+	}
+	_, err = l.f.Write(append(line, '\n'))
+	if err != nil {
+		return errors.Wrap(err, "write audit log")
+	}
+	l.prevHash = hashLine(line)
+	return nil
+}
+
+// Close closes the underlying file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Verify checks that the entries read from r form an unbroken chain and, if a
+// key is provided, that every entry carries a valid signature. It returns the
+// number of entries verified.
+func Verify(r io.Reader, key []byte) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	prevHash := ""
+	n := 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		n++
+		var e Entry
+		err := json.Unmarshal(line, &e)
+		if err != nil {
+			return n, errors.Wrapf(err, "entry %d: unmarshal", n)
+		}
+		if e.PrevHash != prevHash {
+			return n, errors.Errorf("entry %d: chain broken, expected previous hash %q but got %q", n, prevHash, e.PrevHash)
+		}
+		if len(key) > 0 {
+			expected, err := encodeEntry(e, key)
+			if err != nil {
+				return n, err
+			}
+			if !bytes.Equal(expected, line) {
+				return n, errors.Errorf("entry %d: invalid signature", n)
+			}
+		}
+		prevHash = hashLine(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return n, errors.Wrap(err, "read audit log")
+	}
+	return n, nil
+}
+
+func encodeEntry(e Entry, key []byte) ([]byte, error) {
+	e.Signature = ""
+	dt, err := json.Marshal(e)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal audit entry")
+	}
+	if len(key) == 0 {
+		return dt, nil
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(dt)
+	e.Signature = hex.EncodeToString(mac.Sum(nil))
+	dt, err = json.Marshal(e)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal audit entry")
+	}
+	return dt, nil
+}
+
+func hashLine(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+func lastLineHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", errors.Wrapf(err, "open audit log %s", path)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var last []byte
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		last = append(last[:0], line...)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", errors.Wrapf(err, "read audit log %s", path)
+	}
+	if last == nil {
+		return "", nil
+	}
+	return hashLine(last), nil
+}
@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+	key := []byte("secret-key")
+
+	l, err := NewLog(path, key)
+	NoError(t, err)
+	NoError(t, l.Record(KindPush, "+docker", "example.com/img:latest"))
+	NoError(t, l.Record(KindSecret, "+build", "mysecret"))
+	NoError(t, l.Close())
+
+	// Reopening continues the chain.
+	l, err = NewLog(path, key)
+	NoError(t, err)
+	NoError(t, l.Record(KindLocalWrite, "+build", "out/app"))
+	NoError(t, l.Close())
+
+	f, err := os.Open(path)
+	NoError(t, err)
+	defer f.Close()
+	n, err := Verify(f, key)
+	NoError(t, err)
+	Equal(t, 3, n)
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	l, err := NewLog(path, nil)
+	NoError(t, err)
+	NoError(t, l.Record(KindLocally, "+deploy", "kubectl apply -f k8s.yaml"))
+	NoError(t, l.Record(KindRunPush, "+deploy", "./release.sh"))
+	NoError(t, l.Close())
+
+	dt, err := ioutil.ReadFile(path)
+	NoError(t, err)
+	tampered := strings.Replace(string(dt), "kubectl apply", "kubectl delete", 1)
+	_, err = Verify(strings.NewReader(tampered), nil)
+	Error(t, err)
+}
+
+func TestNilLog(t *testing.T) {
+	var l *Log
+	NoError(t, l.Record(KindPush, "+docker", "img"))
+	NoError(t, l.Close())
+}
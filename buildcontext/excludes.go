@@ -1,8 +1,12 @@
 package buildcontext
 
 import (
+	"bufio"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/docker/docker/builder/dockerignore"
 	"github.com/earthly/earthly/util/fileutil"
@@ -21,7 +25,15 @@ var ImplicitExcludes = []string{
 	earthlyIgnoreFile,
 }
 
-func readExcludes(dir string) ([]string, error) {
+// ignoreSectionRegexp matches the header of a section of the ignore file which
+// only applies to the given targets, such as [+build +test].
+var ignoreSectionRegexp = regexp.MustCompile(`^\[\s*(\+[a-zA-Z0-9._-]+(\s+\+[a-zA-Z0-9._-]+)*)\s*\]$`)
+
+// gitignoreSyntaxRegexp matches the directive which switches the ignore file
+// to the gitignore pattern syntax.
+var gitignoreSyntaxRegexp = regexp.MustCompile(`^#\s*syntax\s*=\s*gitignore\s*$`)
+
+func readExcludes(dir string, target string) ([]string, error) {
 	var ignoreFile = earthIgnoreFile
 
 	//earthIgnoreFile
@@ -48,9 +60,92 @@ func readExcludes(dir string) ([]string, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "read %s", filePath)
 	}
-	excludes, err := dockerignore.ReadAll(f)
+	defer f.Close()
+	excludes, err := parseExcludes(f, target)
 	if err != nil {
 		return nil, errors.Wrapf(err, "parse %s", filePath)
 	}
 	return append(excludes, ImplicitExcludes...), nil
 }
+
+// ExcludesFromReader parses the contents of an ignore file read from r, and
+// returns the patterns which apply to the given target, along with the
+// implicit excludes.
+func ExcludesFromReader(r io.Reader, target string) ([]string, error) {
+	excludes, err := parseExcludes(r, target)
+	if err != nil {
+		return nil, err
+	}
+	return append(excludes, ImplicitExcludes...), nil
+}
+
+// parseExcludes parses the contents of an ignore file, and returns the
+// patterns which apply to the given target. The patterns before the first
+// section header apply to all the targets, while the ones within a section
+// only apply to the targets listed in its header.
+func parseExcludes(r io.Reader, target string) ([]string, error) {
+	var selected strings.Builder
+	gitignoreSyntax := false
+	inSection := false
+	applies := true
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if m := ignoreSectionRegexp.FindStringSubmatch(trimmed); m != nil {
+			inSection = true
+			applies = false
+			for _, t := range strings.Fields(m[1]) {
+				if t == "+"+target {
+					applies = true
+				}
+			}
+			continue
+		}
+		if !inSection && gitignoreSyntaxRegexp.MatchString(trimmed) {
+			gitignoreSyntax = true
+			continue
+		}
+		if !applies {
+			continue
+		}
+		if gitignoreSyntax {
+			line = gitignoreToDockerignore(line)
+		}
+		selected.WriteString(line)
+		selected.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return dockerignore.ReadAll(strings.NewReader(selected.String()))
+}
+
+// gitignoreToDockerignore translates a gitignore pattern into the equivalent
+// dockerignore pattern. Unlike in dockerignore, a gitignore pattern which
+// does not contain a slash (other than a trailing one) matches at any depth,
+// and a leading slash anchors the pattern to the root of the context.
+func gitignoreToDockerignore(pattern string) string {
+	p := strings.TrimRight(pattern, " \t")
+	if strings.HasSuffix(p, "\\") {
+		// An escaped trailing space is significant.
+		p += " "
+	}
+	if p == "" || strings.HasPrefix(p, "#") {
+		return p
+	}
+	negate := strings.HasPrefix(p, "!")
+	if negate {
+		p = p[1:]
+	}
+	p = strings.TrimSuffix(p, "/")
+	if strings.HasPrefix(p, "/") {
+		p = strings.TrimPrefix(p, "/")
+	} else if !strings.Contains(p, "/") {
+		p = "**/" + p
+	}
+	if negate {
+		p = "!" + p
+	}
+	return p
+}
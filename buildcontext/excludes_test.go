@@ -0,0 +1,43 @@
+package buildcontext
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+const testIgnoreFile = `# common
+node_modules
+!node_modules/keep
+
+[+docs]
+site
+
+[+build +test]
+docs
+*.md
+`
+
+func TestParseExcludesSections(t *testing.T) {
+	for _, tc := range []struct {
+		target   string
+		expected []string
+	}{
+		{"", []string{"node_modules", "!node_modules/keep"}},
+		{"docs", []string{"node_modules", "!node_modules/keep", "site"}},
+		{"test", []string{"node_modules", "!node_modules/keep", "docs", "*.md"}},
+		{"release", []string{"node_modules", "!node_modules/keep"}},
+	} {
+		excludes, err := parseExcludes(strings.NewReader(testIgnoreFile), tc.target)
+		NoError(t, err, tc.target)
+		Equal(t, tc.expected, excludes, tc.target)
+	}
+}
+
+func TestParseExcludesGitignoreSyntax(t *testing.T) {
+	excludes, err := parseExcludes(strings.NewReader(
+		"# syntax=gitignore\n*.log\n!/important.log\n/build/\nnode_modules/\ndocs/**/*.tmp\n"), "")
+	NoError(t, err)
+	Equal(t, []string{"**/*.log", "!important.log", "build", "**/node_modules", "docs/**/*.tmp"}, excludes)
+}
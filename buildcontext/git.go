@@ -24,9 +24,8 @@ import (
 	"github.com/pkg/errors"
 )
 
-const (
-	defaultGitImage = "alpine/git:v2.30.1"
-)
+// DefaultGitImage is the image used for running git commands as part of the build.
+const DefaultGitImage = "alpine/git:v2.30.1"
 
 type gitResolver struct {
 	cleanCollection *cleanup.Collection
@@ -128,8 +127,8 @@ func (gr *gitResolver) resolveGitProject(ctx context.Context, gwClient gwclient.
 	gitRef := ref.GetTag()
 
 	var err error
-	var keyScan string
-	gitURL, subDir, keyScan, err = gr.gitLookup.GetCloneURL(ref.GetGitURL())
+	var auth CloneAuth
+	gitURL, subDir, auth, err = gr.gitLookup.GetCloneURL(ref.GetGitURL())
 	if err != nil {
 		return nil, "", "", errors.Wrap(err, "failed to get url for cloning")
 	}
@@ -143,12 +142,10 @@ func (gr *gitResolver) resolveGitProject(ctx context.Context, gwClient gwclient.
 			llb.WithCustomNamef("[internal] GIT CLONE %s", stringutil.ScrubCredentials(gitURL)),
 			llb.KeepGitDir(),
 		}
-		if keyScan != "" {
-			gitOpts = append(gitOpts, llb.KnownSSHHosts(keyScan))
-		}
+		gitOpts = append(gitOpts, auth.GitOptions()...)
 		gitState := llb.Git(gitURL, gitRef, gitOpts...)
 		opImg := pllb.Image(
-			defaultGitImage, llb.MarkImageInternal, llb.ResolveModePreferLocal,
+			DefaultGitImage, llb.MarkImageInternal, llb.ResolveModePreferLocal,
 			llb.Platform(llbutil.DefaultPlatform()))
 
 		// Get git hash.
@@ -210,9 +207,7 @@ func (gr *gitResolver) resolveGitProject(ctx context.Context, gwClient gwclient.
 			llb.WithCustomNamef("[context %s] git context %s", gitURL, ref.StringCanonical()),
 			llb.KeepGitDir(),
 		}
-		if keyScan != "" {
-			gitOpts = append(gitOpts, llb.KnownSSHHosts(keyScan))
-		}
+		gitOpts = append(gitOpts, auth.GitOptions()...)
 
 		rgp := &resolvedGitProject{
 			hash:     gitHash,
@@ -2,6 +2,9 @@ package buildcontext
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -22,6 +25,7 @@ import (
 	"github.com/earthly/earthly/util/fileutil"
 
 	"github.com/jdxcode/netrc"
+	"github.com/moby/buildkit/client/llb"
 	"github.com/moby/buildkit/util/gitutil"
 )
 
@@ -34,6 +38,8 @@ type gitMatcher struct {
 	protocol gitProtocol
 	password string
 	keyScan  string
+	token    string
+	sshKey   string
 }
 
 type gitProtocol string
@@ -50,7 +56,8 @@ type GitLookup struct {
 	mu            sync.Mutex
 	matchers      []*gitMatcher
 	catchAll      *gitMatcher
-	autoProtocols map[string]gitProtocol // host -> detected protocol type
+	autoProtocols map[string]gitProtocol   // host -> detected protocol type
+	credentials   map[string]*url.Userinfo // secret key -> https credentials
 	sshAuthSock   string
 	console       conslogging.ConsoleLogger
 }
@@ -94,12 +101,45 @@ func NewGitLookup(console conslogging.ConsoleLogger, sshAuthSock string) *GitLoo
 			protocol: autoProtocol,
 		},
 		autoProtocols: map[string]gitProtocol{},
+		credentials:   map[string]*url.Userinfo{},
 		sshAuthSock:   sshAuthSock,
 		console:       console,
 	}
 	return gl
 }
 
+// CloneAuth contains the settings, other than the URL itself, which are
+// needed to authenticate a git clone.
+type CloneAuth struct {
+	// KeyScan contains the known SSH host keys of the git host, if any.
+	KeyScan string
+	// SSHSockID is the ID of the session SSH socket which serves the key
+	// configured for the host. It is empty if the default socket should be used.
+	SSHSockID string
+	// AuthHeaderSecretID is the ID of the session secret which serves the
+	// Authorization header for the https remote, as the buildkit git source
+	// expects it. It is empty if the remote needs no credentials.
+	AuthHeaderSecretID string
+	// CredentialsSecretID is the ID of the session secret which serves the
+	// same credentials in the format of a git credential helper.
+	CredentialsSecretID string
+}
+
+// GitOptions returns the llb git options which apply the auth.
+func (ca CloneAuth) GitOptions() []llb.GitOption {
+	var gitOpts []llb.GitOption
+	if ca.AuthHeaderSecretID != "" {
+		gitOpts = append(gitOpts, llb.AuthHeaderSecret(ca.AuthHeaderSecretID))
+	}
+	if ca.KeyScan != "" {
+		gitOpts = append(gitOpts, llb.KnownSSHHosts(ca.KeyScan))
+	}
+	if ca.SSHSockID != "" {
+		gitOpts = append(gitOpts, llb.MountSSHSock(ca.SSHSockID))
+	}
+	return gitOpts
+}
+
 // ErrNoMatch occurs when no git matcher is found
 var ErrNoMatch = errors.Errorf("no git match found")
 
@@ -164,6 +204,97 @@ func (gl *GitLookup) AddMatcher(name, pattern, sub, user, password, suffix, prot
 	return nil
 }
 
+// SetCredentials configures an access token and/or the path to an SSH private
+// key for the matcher with the given name. The token is used as the https
+// password, and takes precedence over any configured password. The SSH key is
+// served to buildkit via a dedicated session socket (see SSHKeys), rather than
+// via the user's SSH agent.
+func (gl *GitLookup) SetCredentials(name, token, sshKey string) error {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	for _, m := range gl.matchers {
+		if m.name == name {
+			if token != "" && m.protocol == httpProtocol {
+				return errors.Errorf("using a token with http for %s is insecure", name)
+			}
+			m.token = token
+			m.sshKey = sshKey
+			return nil
+		}
+	}
+	return errors.Errorf("no git configuration found for %s", name)
+}
+
+// SSHKeys returns the configured SSH private key paths, keyed by the ID of the
+// session SSH socket which should serve them.
+func (gl *GitLookup) SSHKeys() map[string]string {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	keys := make(map[string]string)
+	for _, m := range gl.matchers {
+		if m.sshKey != "" {
+			keys[sshSockID(m.name)] = m.sshKey
+		}
+	}
+	return keys
+}
+
+func sshSockID(name string) string {
+	return "earthly-git-" + name
+}
+
+const (
+	authHeaderSecretPrefix  = "earthly-git-auth-"
+	credentialsSecretPrefix = "earthly-git-credentials-"
+)
+
+// stripCredentials removes the credentials from the https git URL, and
+// registers them to be served as session secrets instead (see Secret), so
+// that they end up neither in cache keys nor in logs. The IDs of the secrets
+// only depend on the URL without credentials, so that rotating a token does
+// not invalidate the cache.
+func (gl *GitLookup) stripCredentials(gitURL string, auth CloneAuth) (string, CloneAuth) {
+	u, err := url.Parse(gitURL)
+	if err != nil || u.Scheme != "https" || u.User == nil {
+		return gitURL, auth
+	}
+	creds := u.User
+	u.User = nil
+	gitURL = u.String()
+	sum := sha256.Sum256([]byte(gitURL))
+	key := hex.EncodeToString(sum[:8])
+	gl.mu.Lock()
+	gl.credentials[key] = creds
+	gl.mu.Unlock()
+	auth.AuthHeaderSecretID = authHeaderSecretPrefix + key
+	auth.CredentialsSecretID = credentialsSecretPrefix + key
+	return gitURL, auth
+}
+
+// Secret returns the value of the session secret with the given ID, if it is
+// one of the secrets which serve git credentials.
+func (gl *GitLookup) Secret(id string) ([]byte, bool) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	if key := strings.TrimPrefix(id, authHeaderSecretPrefix); key != id {
+		creds, ok := gl.credentials[key]
+		if !ok {
+			return nil, false
+		}
+		password, _ := creds.Password()
+		return []byte("basic " + base64.StdEncoding.EncodeToString([]byte(creds.Username()+":"+password))), true
+	}
+	if key := strings.TrimPrefix(id, credentialsSecretPrefix); key != id {
+		creds, ok := gl.credentials[key]
+		if !ok {
+			return nil, false
+		}
+		password, _ := creds.Password()
+		return []byte(fmt.Sprintf("username=%s\npassword=%s\n", creds.Username(), password)), true
+	}
+	return nil, false
+}
+
 func (gl *GitLookup) hostKeyCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
 	for _, m := range gl.matchers {
 		k, _, _, _, err := ssh.ParseAuthorizedKey([]byte(m.keyScan))
@@ -266,9 +397,9 @@ func (gl *GitLookup) lookupNetRCCredential(host string) (login, password string,
 
 var errMakeCloneURLSubNotSupported = fmt.Errorf("makeCloneURL does not support gitMatcher substitution")
 
-func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string, string, error) {
+func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string, CloneAuth, error) {
 	if m.sub != "" {
-		return "", "", errMakeCloneURLSubNotSupported
+		return "", CloneAuth{}, errMakeCloneURLSubNotSupported
 	}
 
 	var err error
@@ -276,30 +407,42 @@ func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string,
 	user := m.user
 	password := m.password
 	if configuredProtocol == autoProtocol {
-		configuredProtocol, err = gl.detectProtocol(host)
-		if err != nil {
-			return "", "", err
-		}
-		switch configuredProtocol {
-		case sshProtocol:
+		switch {
+		case m.token != "":
+			configuredProtocol = httpsProtocol
+		case m.sshKey != "":
+			configuredProtocol = sshProtocol
 			user = "git"
-		case httpProtocol, httpsProtocol:
-			user = ""
-			password = ""
+		default:
+			configuredProtocol, err = gl.detectProtocol(host)
+			if err != nil {
+				return "", CloneAuth{}, err
+			}
+			switch configuredProtocol {
+			case sshProtocol:
+				user = "git"
+			case httpProtocol, httpsProtocol:
+				user = ""
+				password = ""
+			}
 		}
 	}
 
-	var gitURL, keyScan string
+	var gitURL string
+	var auth CloneAuth
 	switch configuredProtocol {
 	case sshProtocol:
 		gitURL = user + "@" + host + ":" + gitPath
-		keyScan = m.keyScan
-		if keyScan == "" {
-			keyScan, err = loadKnownHosts()
+		auth.KeyScan = m.keyScan
+		if auth.KeyScan == "" {
+			auth.KeyScan, err = loadKnownHosts()
 			if err != nil {
-				return "", "", err
+				return "", CloneAuth{}, err
 			}
 		}
+		if m.sshKey != "" {
+			auth.SSHSockID = sshSockID(m.name)
+		}
 	case httpProtocol:
 		if user != "" || password != "" {
 			gl.console.Warnf("%s has been configured to use basic access authentication with http; this is insecure and will be ignored; use https or ssh authentication instead", host)
@@ -307,6 +450,14 @@ func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string,
 		gitURL = "http://" + host + "/" + gitPath
 	case httpsProtocol:
 		var userAndPass string
+		if m.token != "" {
+			password = m.token
+			if user == "" || user == "git" {
+				// GitHub requires this user for app installation tokens; other
+				// hosts accept any non-empty user alongside a token.
+				user = "x-access-token"
+			}
+		}
 		if user == "" && password == "" {
 			user, password, _ = gl.lookupNetRCCredential(host) // best effort
 		}
@@ -315,23 +466,23 @@ func (gl *GitLookup) makeCloneURL(m *gitMatcher, host, gitPath string) (string,
 		}
 		gitURL = "https://" + userAndPass + host + "/" + gitPath
 	default:
-		return "", "", errors.Errorf("unsupported protocol: %s", configuredProtocol)
+		return "", CloneAuth{}, errors.Errorf("unsupported protocol: %s", configuredProtocol)
 	}
 
-	return gitURL, keyScan, nil
+	return gitURL, auth, nil
 }
 
 // GetCloneURL returns the repo to clone, and a path relative to the repo
 //   "github.com/earthly/earthly"             ---> ("git@github.com/earthly/earthly.git", "")
 //   "github.com/earthly/earthly/examples"    ---> ("git@github.com/earthly/earthly.git", "examples")
 //   "github.com/earthly/earthly/examples/go" ---> ("git@github.com/earthly/earthly.git", "examples/go")
-// Additionally a ssh keyscan might be returned (or an empty string indicating none was configured)
-func (gl *GitLookup) GetCloneURL(path string) (string, string, string, error) {
+// Additionally the auth settings needed for cloning are returned (e.g. a ssh keyscan)
+func (gl *GitLookup) GetCloneURL(path string) (string, string, CloneAuth, error) {
 	gl.mu.Lock()
 	defer gl.mu.Unlock()
 	match, m, err := gl.getGitMatcherByPath(path)
 	if err != nil {
-		return "", "", "", err
+		return "", "", CloneAuth{}, err
 	}
 
 	n := len(match)
@@ -345,32 +496,36 @@ func (gl *GitLookup) GetCloneURL(path string) (string, string, string, error) {
 
 	if m.sub != "" {
 		if !m.re.MatchString(path) {
-			return "", "", "", errors.Errorf("failed to determine git path to clone for %q", path)
+			return "", "", CloneAuth{}, errors.Errorf("failed to determine git path to clone for %q", path)
 		}
 		gitURL := m.re.ReplaceAllString(path, m.sub)
 
-		keyScan := m.keyScan
-		if keyScan == "" {
-			keyScan, err = loadKnownHosts()
+		auth := CloneAuth{KeyScan: m.keyScan}
+		if auth.KeyScan == "" {
+			auth.KeyScan, err = loadKnownHosts()
 			if err != nil {
-				return "", "", "", err
+				return "", "", CloneAuth{}, err
 			}
 		}
-		return gitURL, subPath, keyScan, nil
+		if m.sshKey != "" {
+			auth.SSHSockID = sshSockID(m.name)
+		}
+		return gitURL, subPath, auth, nil
 	}
 
-	gitURL, keyScan, err := gl.makeCloneURL(m, host, gitPath)
+	gitURL, auth, err := gl.makeCloneURL(m, host, gitPath)
 	if err != nil {
-		return "", "", "", err
+		return "", "", CloneAuth{}, err
 	}
-	return gitURL, subPath, keyScan, nil
+	return gitURL, subPath, auth, nil
 }
 
 // ConvertCloneURL takes a url such as https://github.com/user/repo.git or git@github.com:user/repo.git
 // and makes use of configured git credentials and protocol preferences to convert it into the appropriate
 // https or ssh protocol.
-// it also returns a keyScan
-func (gl *GitLookup) ConvertCloneURL(inURL string) (string, string, error) {
+// it also returns the auth settings needed for cloning; https credentials are
+// served via session secrets rather than included in the url
+func (gl *GitLookup) ConvertCloneURL(inURL string) (string, CloneAuth, error) {
 	var host string
 
 	var splitChar string
@@ -381,14 +536,19 @@ func (gl *GitLookup) ConvertCloneURL(inURL string) (string, string, error) {
 	case gitutil.SSHProtocol:
 		splitChar = ":"
 	default:
-		return "", "", errors.Errorf("unsupported git protocol %v", protocol)
+		return "", CloneAuth{}, errors.Errorf("unsupported git protocol %v", protocol)
 	}
 	splits := strings.SplitN(remote, splitChar, 2)
 	host = splits[0]
 	gitPath := splits[1]
 
 	m := gl.getGitMatcherByName(host)
-	return gl.makeCloneURL(m, host, gitPath)
+	gitURL, auth, err := gl.makeCloneURL(m, host, gitPath)
+	if err != nil {
+		return "", CloneAuth{}, err
+	}
+	gitURL, auth = gl.stripCredentials(gitURL, auth)
+	return gitURL, auth, nil
 }
 
 func loadKnownHosts() (string, error) {
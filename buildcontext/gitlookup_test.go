@@ -0,0 +1,27 @@
+package buildcontext
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/conslogging"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestConvertCloneURLCredentials(t *testing.T) {
+	gl := NewGitLookup(conslogging.ConsoleLogger{}, "")
+	NoError(t, gl.SetCredentials("github.com", "s3cr3t", ""))
+	gitURL, auth, err := gl.ConvertCloneURL("https://github.com/earthly/earthly.git")
+	NoError(t, err)
+	Equal(t, "https://github.com/earthly/earthly.git", gitURL)
+	NotEmpty(t, auth.AuthHeaderSecretID)
+	NotContains(t, auth.AuthHeaderSecretID, "s3cr3t")
+
+	header, ok := gl.Secret(auth.AuthHeaderSecretID)
+	True(t, ok)
+	Equal(t, "basic eC1hY2Nlc3MtdG9rZW46czNjcjN0", string(header))
+	creds, ok := gl.Secret(auth.CredentialsSecretID)
+	True(t, ok)
+	Equal(t, "username=x-access-token\npassword=s3cr3t\n", string(creds))
+	_, ok = gl.Secret("earthly-git-auth-unknown")
+	False(t, ok)
+}
@@ -0,0 +1,95 @@
+package buildcontext
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+
+	"github.com/earthly/earthly/util/gitutil"
+	"github.com/earthly/earthly/util/wslutil"
+	"github.com/pkg/errors"
+)
+
+// How local build contexts whose files are checked out with CRLF line endings
+// are handled.
+const (
+	LineEndingsWarn   = "warn"
+	LineEndingsError  = "error"
+	LineEndingsIgnore = "ignore"
+	// LineEndingsNormalize converts the CRLF line endings of text files to
+	// LF when they are sent to buildkitd, rather than warning about them.
+	LineEndingsNormalize = "normalize"
+)
+
+// ValidLineEndings returns whether mode is a valid line endings mode.
+func ValidLineEndings(mode string) bool {
+	switch mode {
+	case "", LineEndingsWarn, LineEndingsError, LineEndingsIgnore, LineEndingsNormalize:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkLineEndings detects local build contexts checked out with CRLF line
+// endings: by git on Windows (core.autocrlf), including checkouts on Windows
+// drives built from WSL2. The files of such contexts differ from those of
+// checkouts on other platforms, and so do the cache keys of the commands
+// which use them.
+func (lr *localResolver) checkLineEndings(ctx context.Context, dir string) error {
+	if lr.lineEndings == LineEndingsIgnore || lr.lineEndings == LineEndingsNormalize {
+		return nil
+	}
+	crlf := false
+	switch {
+	case runtime.GOOS == "windows":
+		crlf = gitutil.ConvertsToCRLF(ctx, dir)
+	case wslutil.IsWSL():
+		absDir, err := filepath.Abs(dir)
+		if err != nil || !wslutil.IsWindowsMount(absDir) {
+			return nil
+		}
+		// The checkout is typically made by the git of Windows, whose config
+		// is not visible from WSL2.
+		crlf = gitutil.ConvertsToCRLF(ctx, dir) || hasCRLF(filepath.Join(dir, "Earthfile"))
+	}
+	if !crlf {
+		return nil
+	}
+	msg := "files in the build context " + dir + " are checked out with CRLF line endings (git core.autocrlf), " +
+		"which changes their contents (and therefore cache keys) compared to checkouts on other platforms. " +
+		"Consider adding \"* text=auto eol=lf\" to .gitattributes, or setting line_endings to normalize in the earthly config"
+	if lr.lineEndings == LineEndingsError {
+		return errors.New(msg)
+	}
+	lr.console.Warnf("Warning: %s.\n", msg)
+	return nil
+}
+
+func hasCRLF(path string) bool {
+	dt, err := ioutil.ReadFile(path)
+	return err == nil && bytes.Contains(dt, []byte("\r\n"))
+}
+
+// warnSlowFilesystem warns about local build contexts which are shared
+// between Windows and WSL2 via a network filesystem, which makes sending them
+// to buildkitd slow.
+func (lr *localResolver) warnSlowFilesystem(dir string) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	switch {
+	case runtime.GOOS == "windows" && wslutil.IsWSLSharePath(absDir):
+		lr.console.Warnf(
+			"Warning: the build context %s is in the filesystem of a WSL2 distribution, which Windows accesses slowly. "+
+				"Consider running earthly within the distribution instead.\n", absDir)
+	case wslutil.IsWSL() && wslutil.IsWindowsMount(absDir):
+		lr.console.Warnf(
+			"Warning: the build context %s is on a Windows drive, which WSL2 accesses slowly. "+
+				"Consider moving it into the filesystem of the distribution (e.g. under ~), "+
+				"or running the Windows build of earthly instead.\n", absDir)
+	}
+}
@@ -2,6 +2,10 @@ package buildcontext
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
 	"path/filepath"
 
 	"github.com/earthly/earthly/analytics"
@@ -13,12 +17,14 @@ import (
 	"github.com/earthly/earthly/util/syncutil/synccache"
 	"github.com/moby/buildkit/client/llb"
 	"github.com/pkg/errors"
+	"github.com/tonistiigi/fsutil"
 )
 
 type localResolver struct {
 	gitMetaCache *synccache.SyncCache // local path -> *gitutil.GitMetadata
 	sessionID    string
 	console      conslogging.ConsoleLogger
+	lineEndings  string
 }
 
 func (lr *localResolver) resolveLocal(ctx context.Context, ref domain.Reference) (*Data, error) {
@@ -44,6 +50,13 @@ func (lr *localResolver) resolveLocal(ctx context.Context, ref domain.Reference)
 				return nil, err
 			}
 		}
+		lr.warnSlowFilesystem(ref.GetLocalPath())
+		if metadata != nil {
+			err := lr.checkLineEndings(ctx, ref.GetLocalPath())
+			if err != nil {
+				return nil, err
+			}
+		}
 		return metadata, nil
 	})
 	if err != nil {
@@ -58,7 +71,7 @@ func (lr *localResolver) resolveLocal(ctx context.Context, ref domain.Reference)
 
 	var buildContextFactory llbfactory.Factory
 	if _, isTarget := ref.(domain.Target); isTarget {
-		excludes, err := readExcludes(ref.GetLocalPath())
+		excludes, err := readExcludes(ref.GetLocalPath(), ref.GetName())
 		if err != nil {
 			return nil, err
 		}
@@ -68,7 +81,7 @@ func (lr *localResolver) resolveLocal(ctx context.Context, ref domain.Reference)
 			llb.SessionID(lr.sessionID),
 			llb.Platform(llbutil.DefaultPlatform()),
 			llb.WithCustomNamef("[context %s] local context %s", ref.GetLocalPath(), ref.GetLocalPath()),
-		)
+		).WithSharedKeyHint(contextSharedKey(ref.GetLocalPath(), metadata, excludes))
 	} else {
 		// Commands don't come with a build context.
 	}
@@ -79,3 +92,55 @@ func (lr *localResolver) resolveLocal(ctx context.Context, ref domain.Reference)
 		GitMetadata:         metadata,
 	}, nil
 }
+
+// contextSharedKey returns the key under which buildkit keeps the snapshot of
+// a local build context, such that a subsequent build only transfers the files
+// which have changed since, even after earthly is restarted. Contexts within a
+// git repository are keyed by the repository and the path within it, so that
+// the snapshot is also reused by other clones of the same repository, such as
+// on other machines sharing a remote buildkit. Targets with different
+// excludes get separate snapshots.
+func contextSharedKey(localPath string, metadata *gitutil.GitMetadata, excludes []string) string {
+	h := sha256.New()
+	if metadata != nil && metadata.GitURL != "" {
+		fmt.Fprintf(h, "git\x00%s\x00%s", metadata.GitURL, metadata.RelDir)
+	} else {
+		absPath, err := filepath.Abs(localPath)
+		if err != nil {
+			absPath = localPath
+		}
+		fmt.Fprintf(h, "path\x00%s", filepath.ToSlash(absPath))
+	}
+	for _, e := range excludes {
+		fmt.Fprintf(h, "\x00%s", e)
+	}
+	return "context-" + hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// ListLocalContext returns the paths of the files within the local build
+// context of the given target, which are sent to buildkit when building it.
+func ListLocalContext(ctx context.Context, target domain.Target) ([]string, error) {
+	if target.IsRemote() {
+		return nil, errors.Errorf("%s is not a local target", target.String())
+	}
+	excludes, err := readExcludes(target.GetLocalPath(), target.GetName())
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	err = fsutil.Walk(ctx, target.GetLocalPath(), &fsutil.WalkOpt{
+		ExcludePatterns: excludes,
+	}, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			files = append(files, filepath.ToSlash(p))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "walk %s", target.GetLocalPath())
+	}
+	return files, nil
+}
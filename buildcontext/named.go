@@ -0,0 +1,48 @@
+package buildcontext
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var namedContextRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// NamedContexts returns the additional named build contexts, which COPY
+// --from-context copies from, as a map of names to absolute directories. The
+// contexts of the config are overridden by those given on the command line,
+// as in vendor=../vendor.
+func NamedContexts(cfg map[string]string, flags []string) (map[string]string, error) {
+	contexts := make(map[string]string, len(cfg)+len(flags))
+	for name, dir := range cfg {
+		contexts[name] = dir
+	}
+	for _, f := range flags {
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.Errorf("invalid build context %s: expected <name>=<path>", f)
+		}
+		contexts[parts[0]] = parts[1]
+	}
+	for name, dir := range contexts {
+		if !namedContextRegexp.MatchString(name) {
+			return nil, errors.Errorf("invalid build context name %q: must match %s", name, namedContextRegexp.String())
+		}
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get abs path for %s", dir)
+		}
+		fi, err := os.Stat(absDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "stat build context %s", name)
+		}
+		if !fi.IsDir() {
+			return nil, errors.Errorf("build context %s (%s) is not a directory", name, dir)
+		}
+		contexts[name] = absDir
+	}
+	return contexts, nil
+}
@@ -0,0 +1,37 @@
+package buildcontext
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestNamedContexts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "named-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	NoError(t, os.MkdirAll(filepath.Join(dir, "vendor"), 0755))
+	NoError(t, os.MkdirAll(filepath.Join(dir, "other"), 0755))
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0644))
+
+	contexts, err := NamedContexts(
+		map[string]string{"vendor": filepath.Join(dir, "other"), "docs": filepath.Join(dir, "other")},
+		[]string{"vendor=" + filepath.Join(dir, "vendor")})
+	NoError(t, err)
+	Equal(t, map[string]string{
+		"vendor": filepath.Join(dir, "vendor"),
+		"docs":   filepath.Join(dir, "other"),
+	}, contexts)
+
+	_, err = NamedContexts(nil, []string{"vendor"})
+	Error(t, err)
+	_, err = NamedContexts(nil, []string{"../x=" + dir})
+	Error(t, err)
+	_, err = NamedContexts(nil, []string{"missing=" + filepath.Join(dir, "missing")})
+	Error(t, err)
+	_, err = NamedContexts(nil, []string{"file=" + filepath.Join(dir, "file")})
+	Error(t, err)
+}
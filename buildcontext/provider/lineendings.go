@@ -0,0 +1,125 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/tonistiigi/fsutil"
+	fstypes "github.com/tonistiigi/fsutil/types"
+)
+
+// textSniffLen is the number of leading bytes of a file which are checked for
+// NUL bytes to tell text files from binary ones, as git does.
+const textSniffLen = 8000
+
+// normalizeLineEndings wraps the map function of a synced dir, such that the
+// sizes of text files with CRLF line endings are those of the files once
+// converted to LF line endings, as sent by crlfFS.
+func normalizeLineEndings(dir string, m func(string, *fstypes.Stat) bool) func(string, *fstypes.Stat) bool {
+	return func(p string, st *fstypes.Stat) bool {
+		if m != nil && !m(p, st) {
+			return false
+		}
+		if !os.FileMode(st.Mode).IsRegular() {
+			return true
+		}
+		crlfs, err := countCRLFs(filepath.Join(dir, filepath.FromSlash(p)))
+		if err == nil {
+			st.Size_ -= crlfs
+		}
+		return true
+	}
+}
+
+// countCRLFs returns the number of CRLF line endings of the file, or 0 if the
+// file is binary.
+func countCRLFs(p string) (int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, errors.Wrapf(err, "open %s", p)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	head, _ := r.Peek(textSniffLen)
+	if bytes.IndexByte(head, 0) != -1 {
+		return 0, nil
+	}
+	var n int64
+	cr := false
+	buf := make([]byte, 32*1024)
+	for {
+		m, err := r.Read(buf)
+		for _, b := range buf[:m] {
+			if cr && b == '\n' {
+				n++
+			}
+			cr = b == '\r'
+		}
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return 0, errors.Wrapf(err, "read %s", p)
+		}
+	}
+}
+
+// crlfFS is a filesystem whose text files are read with their CRLF line
+// endings converted to LF, such that the files, and therefore the cache keys
+// of the commands which use them, are the same whichever line endings they
+// were checked out with.
+type crlfFS struct {
+	fsutil.FS
+}
+
+func (fs crlfFS) Open(p string) (io.ReadCloser, error) {
+	rc, err := fs.FS.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReaderSize(rc, textSniffLen)
+	head, _ := r.Peek(textSniffLen)
+	if bytes.IndexByte(head, 0) != -1 {
+		return readCloser{Reader: r, Closer: rc}, nil
+	}
+	return readCloser{Reader: &crlfReader{r: r}, Closer: rc}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// crlfReader converts CRLF line endings to LF. Lone CRs are kept.
+type crlfReader struct {
+	r *bufio.Reader
+}
+
+func (cr *crlfReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		b, err := cr.r.ReadByte()
+		if err != nil {
+			if n > 0 && err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		if b == '\r' {
+			next, err := cr.r.Peek(1)
+			if err == nil && next[0] == '\n' {
+				continue
+			}
+		}
+		p[n] = b
+		n++
+		if cr.r.Buffered() == 0 && n > 0 {
+			// Don't block on more input once something was read.
+			return n, nil
+		}
+	}
+	return n, nil
+}
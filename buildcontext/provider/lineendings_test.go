@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tonistiigi/fsutil"
+	fstypes "github.com/tonistiigi/fsutil/types"
+)
+
+func TestNormalizeLineEndings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-line-endings")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	binary := append([]byte("bin\r\n\x00"), bytes.Repeat([]byte("\r\n"), 10000)...)
+	large := bytes.Repeat([]byte("0123456789\r\n"), 10000)
+	files := map[string][]byte{
+		"crlf.txt":  []byte("a\r\nb\r\nlone\rcr\r\n"),
+		"lf.txt":    []byte("a\nb\n"),
+		"trailing":  []byte("a\r"),
+		"large.txt": large,
+		"app.bin":   binary,
+	}
+	expected := map[string][]byte{
+		"crlf.txt":  []byte("a\nb\nlone\rcr\n"),
+		"lf.txt":    []byte("a\nb\n"),
+		"trailing":  []byte("a\r"),
+		"large.txt": bytes.Repeat([]byte("0123456789\n"), 10000),
+		"app.bin":   binary,
+	}
+	for name, dt := range files {
+		NoError(t, ioutil.WriteFile(filepath.Join(dir, name), dt, 0644))
+	}
+
+	fs := crlfFS{FS: fsutil.NewFS(dir, &fsutil.WalkOpt{
+		Map: normalizeLineEndings(dir, func(string, *fstypes.Stat) bool { return true }),
+	})}
+	sizes := make(map[string]int64)
+	err = fs.Walk(context.Background(), func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		sizes[p] = fi.Size()
+		return nil
+	})
+	NoError(t, err)
+	for name, dt := range expected {
+		rc, err := fs.Open(name)
+		NoError(t, err)
+		actual, err := ioutil.ReadAll(rc)
+		NoError(t, err)
+		NoError(t, rc.Close())
+		Equal(t, dt, actual, name)
+		Equal(t, int64(len(dt)), sizes[name], name)
+	}
+}
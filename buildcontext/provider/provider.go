@@ -46,6 +46,11 @@ type BuildContextProvider struct {
 	mu   sync.Mutex
 	dirs map[string]SyncedDir
 
+	budget   SizeBudget
+	measured map[string]int64 // dir and include patterns -> size
+
+	normalizeLineEndings bool
+
 	console conslogging.ConsoleLogger
 }
 
@@ -60,11 +65,30 @@ type SyncedDir struct {
 // NewBuildContextProvider creates a new provider for sending build context files from client.
 func NewBuildContextProvider(console conslogging.ConsoleLogger) *BuildContextProvider {
 	return &BuildContextProvider{
-		dirs:    map[string]SyncedDir{},
-		console: console,
+		dirs:     map[string]SyncedDir{},
+		measured: map[string]int64{},
+		console:  console,
 	}
 }
 
+// SetSizeBudget sets the limits on the size of the local build contexts.
+func (bcp *BuildContextProvider) SetSizeBudget(budget SizeBudget) {
+	bcp.mu.Lock()
+	defer bcp.mu.Unlock()
+	bcp.budget = budget
+}
+
+// SetNormalizeLineEndings sets whether the CRLF line endings of the text files
+// of the local build contexts are converted to LF line endings when they are
+// sent, such that checkouts made with either line endings share cache keys.
+// Text files are told apart from binary ones by the absence of NUL bytes
+// among their first 8000 bytes, as git does.
+func (bcp *BuildContextProvider) SetNormalizeLineEndings(normalize bool) {
+	bcp.mu.Lock()
+	defer bcp.mu.Unlock()
+	bcp.normalizeLineEndings = normalize
+}
+
 // AddDirs adds local directories to the context.
 func (bcp *BuildContextProvider) AddDirs(dirs map[string]string) {
 	bcp.mu.Lock()
@@ -168,18 +192,40 @@ func (bcp *BuildContextProvider) handle(method string, stream grpc.ServerStream)
 		}
 	}
 
+	bcp.mu.Lock()
+	normalize := bcp.normalizeLineEndings
+	bcp.mu.Unlock()
+	mapFn := dir.Map
+	if normalize {
+		mapFn = normalizeLineEndings(dir.Dir, dir.Map)
+	}
+
+	err = bcp.checkSizeBudget(stream.Context(), console, dir, &fsutil.WalkOpt{
+		ExcludePatterns: excludes,
+		IncludePatterns: includes,
+		FollowPaths:     followPaths,
+		Map:             mapFn,
+	})
+	if err != nil {
+		return err
+	}
+
 	var doneCh chan error
 	if bcp.doneCh != nil {
 		doneCh = bcp.doneCh
 		bcp.doneCh = nil
 	}
-	err = pr.sendFn(stream, fsutil.NewFS(dir.Dir, &fsutil.WalkOpt{
+	fs := fsutil.NewFS(dir.Dir, &fsutil.WalkOpt{
 		ExcludePatterns:   excludes,
 		IncludePatterns:   includes,
 		FollowPaths:       followPaths,
-		Map:               dir.Map,
+		Map:               mapFn,
 		VerboseProgressCB: verboseProgressCB,
-	}), progress, verboseProgressCB)
+	})
+	if normalize {
+		fs = crlfFS{FS: fs}
+	}
+	err = pr.sendFn(stream, fs, progress, verboseProgressCB)
 	if doneCh != nil {
 		if err != nil {
 			doneCh <- err
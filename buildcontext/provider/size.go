@@ -0,0 +1,164 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/earthly/earthly/conslogging"
+	"github.com/pkg/errors"
+	"github.com/tonistiigi/fsutil"
+)
+
+// maxContextContributors is the number of largest entries listed when a
+// build context exceeds its size budget.
+const maxContextContributors = 5
+
+// SizeBudget contains the limits on the size of a local build context.
+type SizeBudget struct {
+	// Warn is the size, in bytes, above which a warning is printed. Zero
+	// disables the warning.
+	Warn int64
+	// Limit is the size, in bytes, above which the build fails. Zero disables
+	// the limit.
+	Limit int64
+}
+
+func (b SizeBudget) enabled() bool {
+	return b.Warn > 0 || b.Limit > 0
+}
+
+// contextEntry is a top-level file or directory of a build context, along
+// with its total size.
+type contextEntry struct {
+	name  string
+	isDir bool
+	size  int64
+}
+
+// contextSize walks the build context, as it would be sent, and returns its
+// total size along with its largest top-level entries.
+func contextSize(ctx context.Context, dir string, opt *fsutil.WalkOpt) (int64, []contextEntry, error) {
+	var total int64
+	entries := make(map[string]*contextEntry)
+	err := fsutil.Walk(ctx, dir, opt, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		top := strings.SplitN(filepath.ToSlash(p), "/", 2)
+		e, ok := entries[top[0]]
+		if !ok {
+			e = &contextEntry{name: top[0]}
+			entries[top[0]] = e
+		}
+		if len(top) > 1 || fi.IsDir() {
+			e.isDir = true
+		}
+		if fi.Mode().IsRegular() {
+			e.size += fi.Size()
+			total += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "walk %s", dir)
+	}
+	return total, largestEntries(entries, maxContextContributors), nil
+}
+
+// largestEntries returns the n largest entries, in decreasing order of size.
+func largestEntries(entries map[string]*contextEntry, n int) []contextEntry {
+	ret := make([]contextEntry, 0, len(entries))
+	for _, e := range entries {
+		ret = append(ret, *e)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].size != ret[j].size {
+			return ret[i].size > ret[j].size
+		}
+		return ret[i].name < ret[j].name
+	})
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret
+}
+
+// suggestedExcludes returns .earthlyignore entries for the directories which
+// account for at least a quarter of the context each.
+func suggestedExcludes(total int64, largest []contextEntry) []string {
+	var ret []string
+	for _, e := range largest {
+		if e.isDir && e.size*4 >= total {
+			ret = append(ret, e.name+"/")
+		}
+	}
+	return ret
+}
+
+// sizeReport formats the breakdown of a build context which exceeds its
+// budget.
+func sizeReport(dir string, total, budget int64, largest []contextEntry) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "the build context %s is %s, which exceeds %s. Largest entries:\n",
+		dir, humanize.Bytes(uint64(total)), humanize.Bytes(uint64(budget)))
+	for _, e := range largest {
+		name := e.name
+		if e.isDir {
+			name += "/"
+		}
+		fmt.Fprintf(&sb, "\t%-10s %s\n", humanize.Bytes(uint64(e.size)), name)
+	}
+	if suggested := suggestedExcludes(total, largest); len(suggested) != 0 {
+		fmt.Fprintf(&sb, "If these are not needed by the build, consider adding the following to %s:\n",
+			filepath.Join(dir, ".earthlyignore"))
+		for _, s := range suggested {
+			fmt.Fprintf(&sb, "\t%s\n", s)
+		}
+	}
+	return sb.String()
+}
+
+// sizeKey identifies the build context of a dir, as sent with the patterns of
+// opt.
+func sizeKey(dir string, opt *fsutil.WalkOpt) string {
+	return dir + "\x00" + strings.Join(opt.IncludePatterns, "\x00") + "\x01" + strings.Join(opt.ExcludePatterns, "\x00")
+}
+
+// checkSizeBudget measures the build context about to be sent, and compares it
+// against the size budget. Each context is measured and reported once per
+// session. An error is returned if the context exceeds the limit.
+func (bcp *BuildContextProvider) checkSizeBudget(ctx context.Context, console conslogging.ConsoleLogger, dir SyncedDir, opt *fsutil.WalkOpt) error {
+	bcp.mu.Lock()
+	budget := bcp.budget
+	key := sizeKey(dir.Dir, opt)
+	total, measured := bcp.measured[key]
+	bcp.mu.Unlock()
+	if !budget.enabled() {
+		return nil
+	}
+	if measured {
+		if budget.Limit > 0 && total > budget.Limit {
+			return errors.Errorf("the build context %s exceeds %s", dir.Dir, humanize.Bytes(uint64(budget.Limit)))
+		}
+		return nil
+	}
+	total, largest, err := contextSize(ctx, dir.Dir, opt)
+	if err != nil {
+		return err
+	}
+	bcp.mu.Lock()
+	bcp.measured[key] = total
+	bcp.mu.Unlock()
+	if budget.Limit > 0 && total > budget.Limit {
+		return errors.New(sizeReport(dir.Dir, total, budget.Limit, largest))
+	}
+	if budget.Warn > 0 && total > budget.Warn {
+		console.Warnf("Warning: %s", sizeReport(dir.Dir, total, budget.Warn, largest))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package provider
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tonistiigi/fsutil"
+)
+
+func TestContextSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-context-size")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]int{
+		"main.go":                   100,
+		"node_modules/a/index.js":   3000,
+		"node_modules/b/index.js":   2000,
+		"docs/README.md":            400,
+		"ignored/large-file.tar.gz": 10000,
+	}
+	for name, size := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		NoError(t, ioutil.WriteFile(p, make([]byte, size), 0644))
+	}
+
+	total, largest, err := contextSize(context.Background(), dir, &fsutil.WalkOpt{
+		ExcludePatterns: []string{"ignored/"},
+	})
+	NoError(t, err)
+	Equal(t, int64(5500), total)
+	Equal(t, []contextEntry{
+		{name: "node_modules", isDir: true, size: 5000},
+		{name: "docs", isDir: true, size: 400},
+		{name: "main.go", size: 100},
+	}, largest)
+	Equal(t, []string{"node_modules/"}, suggestedExcludes(total, largest))
+
+	report := sizeReport(dir, total, 1000, largest)
+	True(t, strings.Contains(report, "exceeds 1.0 kB"))
+	True(t, strings.Contains(report, "\tnode_modules/\n"))
+}
+
+func TestSizeKey(t *testing.T) {
+	key := sizeKey("/src", &fsutil.WalkOpt{IncludePatterns: []string{"app"}, ExcludePatterns: []string{"node_modules"}})
+	Equal(t, key, sizeKey("/src", &fsutil.WalkOpt{IncludePatterns: []string{"app"}, ExcludePatterns: []string{"node_modules"}}))
+	NotEqual(t, key, sizeKey("/src", &fsutil.WalkOpt{IncludePatterns: []string{"app"}}))
+	NotEqual(t, key, sizeKey("/src", &fsutil.WalkOpt{IncludePatterns: []string{"app", "node_modules"}}))
+	NotEqual(t, key, sizeKey("/other", &fsutil.WalkOpt{IncludePatterns: []string{"app"}, ExcludePatterns: []string{"node_modules"}}))
+}
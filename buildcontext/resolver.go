@@ -10,6 +10,7 @@ import (
 	"github.com/earthly/earthly/cleanup"
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/stdlib"
 	"github.com/earthly/earthly/util/gitutil"
 	"github.com/earthly/earthly/util/llbutil/llbfactory"
 	"github.com/earthly/earthly/util/syncutil/synccache"
@@ -42,9 +43,11 @@ type Data struct {
 type Resolver struct {
 	gr *gitResolver
 	lr *localResolver
+	sr *stdResolver
 
 	parseCache *synccache.SyncCache // local path -> AST
 	console    conslogging.ConsoleLogger
+	workspace  *Workspace
 }
 
 // NewResolver returns a new NewResolver.
@@ -61,11 +64,35 @@ func NewResolver(sessionID string, cleanCollection *cleanup.Collection, gitLooku
 			sessionID:    sessionID,
 			console:      console,
 		},
+		sr: &stdResolver{
+			cleanCollection: cleanCollection,
+			buildFileCache:  synccache.New(),
+		},
 		parseCache: synccache.New(),
 		console:    console,
 	}
 }
 
+// SetWorkspace sets the workspace whose local checkouts replace the remote
+// references to their repositories.
+func (r *Resolver) SetWorkspace(w *Workspace) {
+	r.workspace = w
+}
+
+// SetLineEndings sets how local build contexts whose files are checked out
+// with CRLF line endings are handled: LineEndingsWarn, LineEndingsError or
+// LineEndingsIgnore.
+func (r *Resolver) SetLineEndings(mode string) {
+	r.lr.lineEndings = mode
+}
+
+// InWorkspace returns whether the given remote reference is replaced by a
+// local checkout of the workspace.
+func (r *Resolver) InWorkspace(ref domain.Reference) bool {
+	_, ok := r.workspace.Override(ref)
+	return ok
+}
+
 // Resolve returns resolved context data for a given Earthly reference. If the reference is a target,
 // then the context will include a build context and possibly additional local directories.
 func (r *Resolver) Resolve(ctx context.Context, gwClient gwclient.Client, ref domain.Reference) (*Data, error) {
@@ -75,7 +102,16 @@ func (r *Resolver) Resolve(ctx context.Context, gwClient gwclient.Client, ref do
 	var d *Data
 	var err error
 	localDirs := make(map[string]string)
-	if ref.IsRemote() {
+	if localRef, ok := r.workspace.Override(ref); ok {
+		ref = localRef
+	}
+	if ref.IsRemote() && stdlib.IsLibrary(ref.GetGitURL()) {
+		// Standard library, embedded in the binary.
+		d, err = r.sr.resolveStd(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+	} else if ref.IsRemote() {
 		// Remote.
 		d, err = r.gr.resolveEarthProject(ctx, gwClient, ref)
 		if err != nil {
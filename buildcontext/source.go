@@ -0,0 +1,204 @@
+package buildcontext
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+)
+
+const tarSourcePrefix = "tar://"
+
+// Source is a pre-assembled local build context, as per --context, which
+// replaces the current directory as the base of local targets: either a
+// directory (dir=<path>), or a tarball (tar://<path>, or tar://- for stdin)
+// which is extracted into a temporary directory.
+type Source struct {
+	Dir string
+	Tar string
+}
+
+// ParseSource parses a --context value.
+func ParseSource(s string) (Source, error) {
+	switch {
+	case strings.HasPrefix(s, "dir="):
+		dir := strings.TrimPrefix(s, "dir=")
+		if dir == "" {
+			return Source{}, errors.New("--context dir= requires a path")
+		}
+		return Source{Dir: dir}, nil
+	case strings.HasPrefix(s, tarSourcePrefix):
+		tarPath := strings.TrimPrefix(s, tarSourcePrefix)
+		if tarPath == "" {
+			return Source{}, errors.Errorf("--context %s requires a path, or - for stdin", tarSourcePrefix)
+		}
+		return Source{Tar: tarPath}, nil
+	default:
+		return Source{}, errors.Errorf("invalid --context %s: expected dir=<path>, tar://<path> or tar://-", s)
+	}
+}
+
+// Prepare returns the absolute directory of the build context, extracting the
+// tarball first if needed. The returned function removes the extracted files.
+func (s Source) Prepare(stdin io.Reader) (string, func(), error) {
+	noop := func() {}
+	if s.Dir != "" {
+		dir, err := filepath.Abs(s.Dir)
+		if err != nil {
+			return "", noop, errors.Wrapf(err, "get abs path for %s", s.Dir)
+		}
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return "", noop, errors.Wrap(err, "stat context dir")
+		}
+		if !fi.IsDir() {
+			return "", noop, errors.Errorf("context %s is not a directory", s.Dir)
+		}
+		return dir, noop, nil
+	}
+	r := stdin
+	if s.Tar != "-" {
+		f, err := os.Open(s.Tar)
+		if err != nil {
+			return "", noop, errors.Wrap(err, "open context tarball")
+		}
+		defer f.Close()
+		r = f
+	}
+	dir, err := ioutil.TempDir("", "earthly-context")
+	if err != nil {
+		return "", noop, errors.Wrap(err, "create context dir")
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	err = ExtractTar(r, dir)
+	if err != nil {
+		cleanup()
+		return "", noop, err
+	}
+	return dir, cleanup, nil
+}
+
+// ExtractTar extracts a tarball, optionally gzip compressed, into dir. Entries
+// which would be written outside of dir (via .. or symlinks) are rejected.
+// Entries other than directories, regular files, symlinks and hard links are
+// skipped.
+func ExtractTar(r io.Reader, dir string) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return errors.Wrapf(err, "eval symlinks for %s", dir)
+	}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	var tr *tar.Reader
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "read gzip context")
+		}
+		defer gr.Close()
+		tr = tar.NewReader(gr)
+	} else {
+		tr = tar.NewReader(br)
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read context tarball")
+		}
+		target, err := extractPath(realDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if target == realDir {
+			continue
+		}
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return errors.Wrapf(err, "create parent of %s", hdr.Name)
+		}
+		err = checkInside(realDir, filepath.Dir(target), hdr.Name)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode|0700)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(target, tr, mode)
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, target)
+		case tar.TypeLink:
+			var linkTarget string
+			linkTarget, err = extractPath(realDir, hdr.Linkname)
+			if err == nil {
+				err = os.Link(linkTarget, target)
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "extract %s", hdr.Name)
+		}
+		if hdr.Typeflag != tar.TypeSymlink {
+			_ = os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+		}
+	}
+}
+
+// extractPath returns where a tarball entry is extracted to.
+func extractPath(dir, name string) (string, error) {
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return "", errors.Errorf("context tarball entry %s is outside of the context", name)
+		}
+	}
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+filepath.ToSlash(name)))), nil
+}
+
+// checkInside returns an error if the (existing) directory p resolves to a
+// path outside of dir, via symlinks extracted earlier.
+func checkInside(dir, p, name string) error {
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return errors.Wrapf(err, "eval symlinks for %s", name)
+	}
+	if real != dir && !strings.HasPrefix(real, dir+string(filepath.Separator)) {
+		return errors.Errorf("context tarball entry %s is outside of the context", name)
+	}
+	return nil
+}
+
+func writeFile(p string, r io.Reader, mode os.FileMode) error {
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// WithSourceDir returns the target, with its local path (if any) relative to
+// the directory of a --context source instead of the current directory.
+func WithSourceDir(t domain.Target, dir string) domain.Target {
+	if t.LocalPath == "" || path.IsAbs(t.LocalPath) {
+		return t
+	}
+	t.LocalPath = path.Join(filepath.ToSlash(dir), t.LocalPath)
+	return t
+}
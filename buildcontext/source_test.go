@@ -0,0 +1,117 @@
+package buildcontext
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func makeTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0644, Size: int64(len(e.body)), Linkname: e.linkname}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.body))
+		NoError(t, err)
+	}
+	NoError(t, tw.Close())
+	return &buf
+}
+
+func TestParseSource(t *testing.T) {
+	s, err := ParseSource("tar://-")
+	NoError(t, err)
+	Equal(t, Source{Tar: "-"}, s)
+	s, err = ParseSource("tar://ctx.tar.gz")
+	NoError(t, err)
+	Equal(t, Source{Tar: "ctx.tar.gz"}, s)
+	s, err = ParseSource("dir=/src")
+	NoError(t, err)
+	Equal(t, Source{Dir: "/src"}, s)
+	_, err = ParseSource("/src")
+	Error(t, err)
+	_, err = ParseSource("tar://")
+	Error(t, err)
+}
+
+func TestExtractTar(t *testing.T) {
+	buf := makeTar(t, []tarEntry{
+		{name: "./", typeflag: tar.TypeDir},
+		{name: "Earthfile", typeflag: tar.TypeReg, body: "VERSION 0.6\n"},
+		{name: "sub/main.go", typeflag: tar.TypeReg, body: "package main\n"},
+		{name: "link", typeflag: tar.TypeSymlink, linkname: "sub/main.go"},
+		{name: "hard", typeflag: tar.TypeLink, linkname: "Earthfile"},
+	})
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, err := gw.Write(buf.Bytes())
+	NoError(t, err)
+	NoError(t, gw.Close())
+
+	for _, r := range []*bytes.Buffer{bytes.NewBuffer(buf.Bytes()), &gz} {
+		dir, err := ioutil.TempDir("", "source-test")
+		NoError(t, err)
+		defer os.RemoveAll(dir)
+		NoError(t, ExtractTar(r, dir))
+		dt, err := ioutil.ReadFile(filepath.Join(dir, "sub", "main.go"))
+		NoError(t, err)
+		Equal(t, "package main\n", string(dt))
+		dt, err = ioutil.ReadFile(filepath.Join(dir, "link"))
+		NoError(t, err)
+		Equal(t, "package main\n", string(dt))
+		dt, err = ioutil.ReadFile(filepath.Join(dir, "hard"))
+		NoError(t, err)
+		Equal(t, "VERSION 0.6\n", string(dt))
+	}
+}
+
+func TestExtractTarOutside(t *testing.T) {
+	for _, entries := range [][]tarEntry{
+		{{name: "../evil", typeflag: tar.TypeReg, body: "x"}},
+		{
+			{name: "escape", typeflag: tar.TypeSymlink, linkname: "/tmp"},
+			{name: "escape/evil", typeflag: tar.TypeReg, body: "x"},
+		},
+	} {
+		dir, err := ioutil.TempDir("", "source-test")
+		NoError(t, err)
+		defer os.RemoveAll(dir)
+		err = ExtractTar(makeTar(t, entries), dir)
+		Error(t, err)
+		Contains(t, err.Error(), "outside of the context")
+	}
+}
+
+func TestWithSourceDir(t *testing.T) {
+	target, err := domain.ParseTarget("+build")
+	NoError(t, err)
+	Equal(t, "/ctx", WithSourceDir(target, "/ctx").LocalPath)
+	target, err = domain.ParseTarget("./sub+build")
+	NoError(t, err)
+	Equal(t, "/ctx/sub", WithSourceDir(target, "/ctx").LocalPath)
+	target, err = domain.ParseTarget("/abs+build")
+	NoError(t, err)
+	Equal(t, "/abs", WithSourceDir(target, "/ctx").LocalPath)
+	target, err = domain.ParseTarget("github.com/earthly/earthly+build")
+	NoError(t, err)
+	Equal(t, target, WithSourceDir(target, "/ctx"))
+}
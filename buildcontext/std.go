@@ -0,0 +1,62 @@
+package buildcontext
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/stdlib"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/llbfactory"
+	"github.com/earthly/earthly/util/syncutil/synccache"
+	"github.com/pkg/errors"
+)
+
+// stdResolver resolves references to the standard library embedded in the
+// binary.
+type stdResolver struct {
+	cleanCollection *cleanup.Collection
+	buildFileCache  *synccache.SyncCache // library -> local path of the Earthfile
+}
+
+func (sr *stdResolver) resolveStd(ctx context.Context, ref domain.Reference) (*Data, error) {
+	if ref.GetTag() != "" {
+		return nil, errors.Errorf(
+			"%s: the standard library is versioned together with earthly and cannot be referenced by tag", ref.String())
+	}
+	buildFilePathValue, err := sr.buildFileCache.Do(ctx, ref.GetGitURL(), func(ctx context.Context, _ interface{}) (interface{}, error) {
+		dt, err := stdlib.Earthfile(ref.GetGitURL())
+		if err != nil {
+			return nil, err
+		}
+		earthfileTmpDir, err := ioutil.TempDir(os.TempDir(), "earthly-std")
+		if err != nil {
+			return nil, errors.Wrap(err, "create temp dir for Earthfile")
+		}
+		sr.cleanCollection.Add(func() error {
+			return os.RemoveAll(earthfileTmpDir)
+		})
+		buildFilePath := filepath.Join(earthfileTmpDir, "Earthfile")
+		err = ioutil.WriteFile(buildFilePath, dt, 0600)
+		if err != nil {
+			return nil, errors.Wrapf(err, "write build file to tmp dir at %s", buildFilePath)
+		}
+		return buildFilePath, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Commands don't come with a build context, and library targets have
+	// nothing to copy from.
+	var buildContextFactory llbfactory.Factory
+	if _, isTarget := ref.(domain.Target); isTarget {
+		buildContextFactory = llbfactory.PreconstructedState(llbutil.ScratchWithPlatform())
+	}
+	return &Data{
+		BuildFilePath:       buildFilePathValue.(string),
+		BuildContextFactory: buildContextFactory,
+	}, nil
+}
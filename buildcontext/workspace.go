@@ -0,0 +1,143 @@
+package buildcontext
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// WorkspaceFileName is the name of the workspace file, which is looked up in
+// the current directory and its parents.
+const WorkspaceFileName = "earthly.work"
+
+// Workspace maps remote repositories to local checkouts, so that references
+// to the repositories (such as via IMPORT) are built out of the checkouts
+// instead, during development.
+type Workspace struct {
+	// Path is the path of the workspace file.
+	Path string
+	// Use maps repositories, as in github.com/org/repo, to the absolute path
+	// of their local checkout.
+	Use map[string]string
+
+	repos []string // sorted longest first
+}
+
+type workspaceFile struct {
+	Use map[string]string `yaml:"use"`
+}
+
+// FindWorkspace looks up the workspace file in dir and its parents. It
+// returns an empty path if there is none.
+func FindWorkspace(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errors.Wrapf(err, "get abs path of %s", dir)
+	}
+	for {
+		p := filepath.Join(dir, WorkspaceFileName)
+		_, err := os.Stat(p)
+		if err == nil {
+			return p, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", errors.Wrapf(err, "stat %s", p)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// NewWorkspace returns an empty workspace. path is the workspace file it
+// originates from, if any.
+func NewWorkspace(path string) *Workspace {
+	return &Workspace{Path: path, Use: make(map[string]string)}
+}
+
+// Add maps the repository to the local checkout at dir, which is relative to
+// baseDir. It replaces any previous mapping of the repository.
+func (w *Workspace) Add(repo, dir, baseDir string) error {
+	repo = strings.TrimSuffix(strings.TrimSpace(repo), "/")
+	if repo == "" || dir == "" {
+		return errors.Errorf("invalid mapping of %q to %q", repo, dir)
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(baseDir, filepath.FromSlash(dir))
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return errors.Wrapf(err, "get abs path of %s", dir)
+	}
+	fi, err := os.Stat(absDir)
+	if err != nil {
+		return errors.Wrapf(err, "checkout of %s", repo)
+	}
+	if !fi.IsDir() {
+		return errors.Errorf("checkout %s of %s is not a directory", absDir, repo)
+	}
+	if _, exists := w.Use[repo]; !exists {
+		w.repos = append(w.repos, repo)
+		sort.Slice(w.repos, func(i, j int) bool { return len(w.repos[i]) > len(w.repos[j]) })
+	}
+	w.Use[repo] = absDir
+	return nil
+}
+
+// LoadWorkspace reads the workspace file at the given path. The paths of the
+// checkouts are relative to the directory of the workspace file.
+func LoadWorkspace(p string) (*Workspace, error) {
+	dt, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read workspace file %s", p)
+	}
+	var wf workspaceFile
+	err = yaml.Unmarshal(dt, &wf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse workspace file %s", p)
+	}
+	w := NewWorkspace(p)
+	for repo, dir := range wf.Use {
+		err := w.Add(repo, dir, filepath.Dir(p))
+		if err != nil {
+			return nil, errors.Wrapf(err, "workspace file %s", p)
+		}
+	}
+	return w, nil
+}
+
+// Override returns the local reference which the given remote reference is
+// replaced with, if its repository is part of the workspace. The tag of the
+// reference is ignored: the checkout is used as is.
+func (w *Workspace) Override(ref domain.Reference) (domain.Reference, bool) {
+	if w == nil || !ref.IsRemote() {
+		return ref, false
+	}
+	gitURL := ref.GetGitURL()
+	for _, repo := range w.repos {
+		if gitURL != repo && !strings.HasPrefix(gitURL, repo+"/") {
+			continue
+		}
+		subDir := strings.TrimPrefix(strings.TrimPrefix(gitURL, repo), "/")
+		localPath := filepath.ToSlash(w.Use[repo])
+		if subDir != "" {
+			localPath = path.Join(localPath, subDir)
+		}
+		switch r := ref.(type) {
+		case domain.Target:
+			return domain.Target{LocalPath: localPath, Target: r.Target}, true
+		case domain.Command:
+			return domain.Command{LocalPath: localPath, Command: r.Command}, true
+		}
+	}
+	return ref, false
+}
@@ -0,0 +1,57 @@
+package buildcontext
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workspace-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	NoError(t, err)
+	NoError(t, os.MkdirAll(filepath.Join(dir, "app", "sub"), 0755))
+	NoError(t, os.MkdirAll(filepath.Join(dir, "lib"), 0755))
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, WorkspaceFileName), []byte("use:\n  github.com/org/lib: ./lib\n"), 0644))
+
+	p, err := FindWorkspace(filepath.Join(dir, "app", "sub"))
+	NoError(t, err)
+	Equal(t, filepath.Join(dir, WorkspaceFileName), p)
+	w, err := LoadWorkspace(p)
+	NoError(t, err)
+	Equal(t, map[string]string{"github.com/org/lib": filepath.Join(dir, "lib")}, w.Use)
+
+	libDir := filepath.ToSlash(filepath.Join(dir, "lib"))
+	ref, ok := w.Override(domain.Target{GitURL: "github.com/org/lib", Tag: "v1.0.0", Target: "build"})
+	True(t, ok)
+	Equal(t, domain.Target{LocalPath: libDir, Target: "build"}, ref)
+	ref, ok = w.Override(domain.Command{GitURL: "github.com/org/lib/go", Command: "SETUP"})
+	True(t, ok)
+	Equal(t, domain.Command{LocalPath: libDir + "/go", Command: "SETUP"}, ref)
+	_, ok = w.Override(domain.Target{GitURL: "github.com/org/library", Target: "build"})
+	False(t, ok)
+	_, ok = w.Override(domain.Target{LocalPath: "./lib", Target: "build"})
+	False(t, ok)
+
+	// Overrides replace the mappings of the workspace file.
+	NoError(t, w.Add("github.com/org/lib", filepath.Join(dir, "app"), "."))
+	ref, ok = w.Override(domain.Target{GitURL: "github.com/org/lib", Target: "build"})
+	True(t, ok)
+	Equal(t, domain.Target{LocalPath: filepath.ToSlash(filepath.Join(dir, "app")), Target: "build"}, ref)
+	Error(t, w.Add("github.com/org/lib", filepath.Join(dir, "missing"), "."))
+
+	var noWorkspace *Workspace
+	_, ok = noWorkspace.Override(domain.Target{GitURL: "github.com/org/lib", Target: "build"})
+	False(t, ok)
+
+	NoError(t, ioutil.WriteFile(p, []byte("use:\n  github.com/org/other: ./missing\n"), 0644))
+	_, err = LoadWorkspace(p)
+	Error(t, err)
+}
@@ -0,0 +1,219 @@
+// Package builddiff compares the outputs of two builds of a target, as run by
+// earthly diff, so that the build impact of a change can be reviewed: which
+// steps changed (and so will not be cached), which image layers changed, and
+// which artifact files differ.
+package builddiff
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/pkg/errors"
+)
+
+// Status is the kind of difference of an entry between the two builds.
+type Status string
+
+const (
+	// Added means that the entry exists only in the head build.
+	Added Status = "added"
+	// Removed means that the entry exists only in the base build.
+	Removed Status = "removed"
+	// Changed means that the entry exists in both builds, but differs.
+	Changed Status = "changed"
+)
+
+// Snapshot is the outcome of a build.
+type Snapshot struct {
+	// Steps maps the targets built to their definition: the commands of
+	// their recipe, preceded by the build args they were invoked with.
+	Steps map[string][]string `json:"steps"`
+	// Images maps the tags of the images saved to their layers.
+	Images map[string]Image `json:"images"`
+	// Artifacts maps the paths of the files saved locally, relative to the
+	// root of the build, to their contents.
+	Artifacts map[string]File `json:"artifacts"`
+}
+
+// Image is an image saved by a build.
+type Image struct {
+	// Layers are the digests of the uncompressed layers of the image.
+	Layers []string `json:"layers"`
+	Size   int64    `json:"size"`
+}
+
+// File is a file saved by a build.
+type File struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// NewSnapshot returns an empty snapshot.
+func NewSnapshot() *Snapshot {
+	return &Snapshot{
+		Steps:     make(map[string][]string),
+		Images:    make(map[string]Image),
+		Artifacts: make(map[string]File),
+	}
+}
+
+// AddStep records the definition of a target. A target built several times,
+// such as with different build args, is recorded under a numbered name.
+func (s *Snapshot) AddStep(name string, buildArgs map[string]string, recipe spec.Block) {
+	key := name
+	for i := 2; ; i++ {
+		if _, ok := s.Steps[key]; !ok {
+			break
+		}
+		key = fmt.Sprintf("%s #%d", name, i)
+	}
+	var lines []string
+	names := make([]string, 0, len(buildArgs))
+	for k := range buildArgs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		lines = append(lines, fmt.Sprintf("--%s=%s", k, buildArgs[k]))
+	}
+	s.Steps[key] = append(lines, RecipeLines(recipe)...)
+}
+
+// RecipeLines returns the commands of the block, one per line, with nested
+// blocks indented.
+func RecipeLines(block spec.Block) []string {
+	var lines []string
+	for _, stmt := range block {
+		switch {
+		case stmt.Command != nil:
+			lines = append(lines, commandLine(*stmt.Command))
+			lines = append(lines, heredocLines(*stmt.Command)...)
+		case stmt.With != nil:
+			lines = append(lines, "WITH "+commandLine(stmt.With.Command))
+			lines = append(lines, indent(RecipeLines(stmt.With.Body))...)
+			lines = append(lines, "END")
+		case stmt.If != nil:
+			lines = append(lines, "IF "+strings.Join(stmt.If.Expression, " "))
+			lines = append(lines, indent(RecipeLines(stmt.If.IfBody))...)
+			for _, elseIf := range stmt.If.ElseIf {
+				lines = append(lines, "ELSE IF "+strings.Join(elseIf.Expression, " "))
+				lines = append(lines, indent(RecipeLines(elseIf.Body))...)
+			}
+			if stmt.If.ElseBody != nil {
+				lines = append(lines, "ELSE")
+				lines = append(lines, indent(RecipeLines(*stmt.If.ElseBody))...)
+			}
+			lines = append(lines, "END")
+		case stmt.For != nil:
+			lines = append(lines, "FOR "+strings.Join(stmt.For.Args, " "))
+			lines = append(lines, indent(RecipeLines(stmt.For.Body))...)
+			lines = append(lines, "END")
+		}
+	}
+	return lines
+}
+
+func commandLine(cmd spec.Command) string {
+	if cmd.ExecMode {
+		dt, _ := json.Marshal(cmd.Args)
+		return cmd.Name + " " + string(dt)
+	}
+	return strings.TrimSpace(cmd.Name + " " + strings.Join(cmd.Args, " "))
+}
+
+// heredocLines returns the lines of the here-documents of the command, so that
+// changes to their content show up in the diff.
+func heredocLines(cmd spec.Command) []string {
+	var lines []string
+	for _, h := range cmd.Heredocs {
+		if h.Content != "" {
+			lines = append(lines, strings.Split(strings.TrimSuffix(h.Content, "\n"), "\n")...)
+		}
+		lines = append(lines, h.Delimiter)
+	}
+	return lines
+}
+
+func indent(lines []string) []string {
+	for i, l := range lines {
+		lines[i] = "    " + l
+	}
+	return lines
+}
+
+// AddArtifacts records the files at path, which is either a file or a
+// directory. Missing paths are ignored, as artifacts may be optional.
+func (s *Snapshot) AddArtifacts(root, path string) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return errors.Wrapf(err, "relative path of %s", p)
+		}
+		sum, err := hashFile(p)
+		if err != nil {
+			return err
+		}
+		s.Artifacts[filepath.ToSlash(rel)] = File{Size: info.Size(), SHA256: sum}
+		return nil
+	})
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "open %s", path)
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", errors.Wrapf(err, "read %s", path)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// InspectImage returns the layers of the image with the given tag, as loaded
+// into the local docker daemon.
+func InspectImage(ctx context.Context, tag string) (Image, error) {
+	cmd := exec.CommandContext(ctx, "docker", "image", "inspect", "--format", "{{json .RootFS.Layers}} {{.Size}}", tag)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Image{}, errors.Wrapf(err, "docker image inspect %s: %s", tag, strings.TrimSpace(stderr.String()))
+	}
+	var img Image
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return Image{}, errors.Errorf("unexpected docker image inspect output %q", string(out))
+	}
+	err = json.Unmarshal([]byte(fields[0]), &img.Layers)
+	if err != nil {
+		return Image{}, errors.Wrapf(err, "parse layers of %s", tag)
+	}
+	_, err = fmt.Sscan(fields[1], &img.Size)
+	if err != nil {
+		return Image{}, errors.Wrapf(err, "parse size of %s", tag)
+	}
+	return img, nil
+}
@@ -0,0 +1,106 @@
+package builddiff
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestRecipeLines(t *testing.T) {
+	recipe := spec.Block{
+		{Command: &spec.Command{Name: "FROM", Args: []string{"golang:1.16"}}},
+		{If: &spec.IfStatement{
+			Expression: []string{"[", "-f", "go.sum", "]"},
+			IfBody:     spec.Block{{Command: &spec.Command{Name: "RUN", Args: []string{"go", "mod", "download"}}}},
+		}},
+		{Command: &spec.Command{Name: "ENTRYPOINT", Args: []string{"/app", "--serve"}, ExecMode: true}},
+	}
+	Equal(t, []string{
+		"FROM golang:1.16",
+		"IF [ -f go.sum ]",
+		"    RUN go mod download",
+		"END",
+		`ENTRYPOINT ["/app","--serve"]`,
+	}, RecipeLines(recipe))
+}
+
+func TestAddArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builddiff-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	NoError(t, os.MkdirAll(filepath.Join(dir, "out", "sub"), 0755))
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "out", "app"), []byte("binary"), 0644))
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "out", "sub", "a.txt"), []byte("a"), 0644))
+
+	s := NewSnapshot()
+	NoError(t, s.AddArtifacts(dir, filepath.Join(dir, "out")))
+	NoError(t, s.AddArtifacts(dir, filepath.Join(dir, "missing")))
+	Equal(t, 2, len(s.Artifacts))
+	Equal(t, int64(6), s.Artifacts["out/app"].Size)
+	Equal(t, "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb", s.Artifacts["out/sub/a.txt"].SHA256)
+}
+
+func TestCompare(t *testing.T) {
+	base := NewSnapshot()
+	base.AddStep("+build", map[string]string{"VERSION": "1.0"}, spec.Block{
+		{Command: &spec.Command{Name: "FROM", Args: []string{"golang:1.16"}}},
+		{Command: &spec.Command{Name: "RUN", Args: []string{"go", "build"}}},
+	})
+	base.AddStep("+deps", nil, spec.Block{{Command: &spec.Command{Name: "RUN", Args: []string{"go", "mod", "download"}}}})
+	base.AddStep("+lint", nil, nil)
+	base.Images["app:latest"] = Image{Layers: []string{"sha256:a", "sha256:b"}, Size: 1000}
+	base.Images["tools:latest"] = Image{Layers: []string{"sha256:t"}, Size: 10}
+	base.Artifacts["out/app"] = File{Size: 100, SHA256: "1"}
+	base.Artifacts["out/old.txt"] = File{Size: 5, SHA256: "2"}
+	base.Artifacts["out/same"] = File{Size: 5, SHA256: "3"}
+
+	head := NewSnapshot()
+	head.AddStep("+build", map[string]string{"VERSION": "1.1"}, spec.Block{
+		{Command: &spec.Command{Name: "FROM", Args: []string{"golang:1.16"}}},
+		{Command: &spec.Command{Name: "RUN", Args: []string{"go", "build", "-trimpath"}}},
+	})
+	head.AddStep("+deps", nil, spec.Block{{Command: &spec.Command{Name: "RUN", Args: []string{"go", "mod", "download"}}}})
+	head.AddStep("+test", nil, nil)
+	head.Images["app:latest"] = Image{Layers: []string{"sha256:a", "sha256:c", "sha256:d"}, Size: 1500}
+	head.Images["tools:latest"] = Image{Layers: []string{"sha256:t"}, Size: 10}
+	head.Artifacts["out/app"] = File{Size: 120, SHA256: "4"}
+	head.Artifacts["out/same"] = File{Size: 5, SHA256: "3"}
+
+	r := Compare(base, head)
+	False(t, r.Empty())
+	Equal(t, 1, r.UnchangedSteps)
+	Equal(t, []StepDiff{
+		{Name: "+build", Status: Changed, Lines: []string{"- --VERSION=1.0", "+ --VERSION=1.1", "- RUN go build", "+ RUN go build -trimpath"}},
+		{Name: "+lint", Status: Removed},
+		{Name: "+test", Status: Added},
+	}, r.Steps)
+	Equal(t, []ImageDiff{{Tag: "app:latest", Status: Changed, ChangedLayers: 2, Layers: 3, SizeDelta: 500}}, r.Images)
+	Equal(t, []FileDiff{
+		{Path: "out/app", Status: Changed, SizeDelta: 20},
+		{Path: "out/old.txt", Status: Removed, SizeDelta: -5},
+	}, r.Files)
+
+	var buf bytes.Buffer
+	NoError(t, r.Print(&buf))
+	Equal(t, `Steps: 3 changed, 1 unchanged (cached)
+  changed  +build
+      - --VERSION=1.0
+      + --VERSION=1.1
+      - RUN go build
+      + RUN go build -trimpath
+  removed  +lint
+  added    +test
+Images:
+  changed  app:latest  2 of 3 layers  +500 B
+Files:
+  changed  out/app      +20 B
+  removed  out/old.txt  -5 B
+`, buf.String())
+
+	True(t, Compare(head, head).Empty())
+}
@@ -0,0 +1,213 @@
+package builddiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Report is the difference between two builds.
+type Report struct {
+	// Steps are the targets whose definition differs. They are rebuilt
+	// rather than taken from the cache of the base build.
+	Steps []StepDiff `json:"steps"`
+	// UnchangedSteps is the number of targets whose definition is the same.
+	UnchangedSteps int         `json:"unchangedSteps"`
+	Images         []ImageDiff `json:"images"`
+	Files          []FileDiff  `json:"files"`
+}
+
+// StepDiff is a target whose definition differs.
+type StepDiff struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	// Lines are the lines of the definition which were removed (prefixed by
+	// "- ") or added (prefixed by "+ ").
+	Lines []string `json:"lines,omitempty"`
+}
+
+// ImageDiff is an image whose layers differ.
+type ImageDiff struct {
+	Tag    string `json:"tag"`
+	Status Status `json:"status"`
+	// ChangedLayers is the number of layers of the head image which are not
+	// shared with the base image, out of Layers.
+	ChangedLayers int   `json:"changedLayers"`
+	Layers        int   `json:"layers"`
+	SizeDelta     int64 `json:"sizeDelta"`
+}
+
+// FileDiff is an artifact file whose contents differ.
+type FileDiff struct {
+	Path      string `json:"path"`
+	Status    Status `json:"status"`
+	SizeDelta int64  `json:"sizeDelta"`
+}
+
+// Compare returns the differences between the base and the head builds.
+func Compare(base, head *Snapshot) Report {
+	r := Report{Steps: []StepDiff{}, Images: []ImageDiff{}, Files: []FileDiff{}}
+	for _, name := range sortedKeys(base.Steps, head.Steps) {
+		b, inBase := base.Steps[name]
+		h, inHead := head.Steps[name]
+		switch {
+		case !inBase:
+			r.Steps = append(r.Steps, StepDiff{Name: name, Status: Added})
+		case !inHead:
+			r.Steps = append(r.Steps, StepDiff{Name: name, Status: Removed})
+		default:
+			lines := diffLines(b, h)
+			if len(lines) == 0 {
+				r.UnchangedSteps++
+				continue
+			}
+			r.Steps = append(r.Steps, StepDiff{Name: name, Status: Changed, Lines: lines})
+		}
+	}
+	for _, tag := range sortedKeys(base.Images, head.Images) {
+		b, inBase := base.Images[tag]
+		h, inHead := head.Images[tag]
+		switch {
+		case !inBase:
+			r.Images = append(r.Images, ImageDiff{Tag: tag, Status: Added, ChangedLayers: len(h.Layers), Layers: len(h.Layers), SizeDelta: h.Size})
+		case !inHead:
+			r.Images = append(r.Images, ImageDiff{Tag: tag, Status: Removed, Layers: len(b.Layers), SizeDelta: -b.Size})
+		default:
+			shared := 0
+			for shared < len(b.Layers) && shared < len(h.Layers) && b.Layers[shared] == h.Layers[shared] {
+				shared++
+			}
+			if shared == len(b.Layers) && shared == len(h.Layers) {
+				continue
+			}
+			r.Images = append(r.Images, ImageDiff{Tag: tag, Status: Changed, ChangedLayers: len(h.Layers) - shared, Layers: len(h.Layers), SizeDelta: h.Size - b.Size})
+		}
+	}
+	for _, path := range sortedKeys(base.Artifacts, head.Artifacts) {
+		b, inBase := base.Artifacts[path]
+		h, inHead := head.Artifacts[path]
+		switch {
+		case !inBase:
+			r.Files = append(r.Files, FileDiff{Path: path, Status: Added, SizeDelta: h.Size})
+		case !inHead:
+			r.Files = append(r.Files, FileDiff{Path: path, Status: Removed, SizeDelta: -b.Size})
+		case b.SHA256 != h.SHA256:
+			r.Files = append(r.Files, FileDiff{Path: path, Status: Changed, SizeDelta: h.Size - b.Size})
+		}
+	}
+	return r
+}
+
+// Empty returns whether the builds have no differences.
+func (r Report) Empty() bool {
+	return len(r.Steps) == 0 && len(r.Images) == 0 && len(r.Files) == 0
+}
+
+// Print writes a human readable summary of the report.
+func (r Report) Print(w io.Writer) error {
+	fmt.Fprintf(w, "Steps: %d changed, %d unchanged (cached)\n", len(r.Steps), r.UnchangedSteps)
+	for _, s := range r.Steps {
+		fmt.Fprintf(w, "  %-8s %s\n", s.Status, s.Name)
+		for _, l := range s.Lines {
+			fmt.Fprintf(w, "      %s\n", l)
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if len(r.Images) != 0 {
+		fmt.Fprintf(tw, "Images:\n")
+		for _, img := range r.Images {
+			fmt.Fprintf(tw, "  %s\t%s\t%d of %d layers\t%s\n", img.Status, img.Tag, img.ChangedLayers, img.Layers, sizeDelta(img.SizeDelta))
+		}
+	}
+	if len(r.Files) != 0 {
+		fmt.Fprintf(tw, "Files:\n")
+		for _, f := range r.Files {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", f.Status, f.Path, sizeDelta(f.SizeDelta))
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func sizeDelta(n int64) string {
+	if n < 0 {
+		return "-" + humanize.Bytes(uint64(-n))
+	}
+	return "+" + humanize.Bytes(uint64(n))
+}
+
+// diffLines returns the lines removed from a and added to b, in order, based
+// on their longest common subsequence.
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	return out
+}
+
+func sortedKeys(maps ...interface{}) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(k string) {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	for _, m := range maps {
+		switch m := m.(type) {
+		case map[string][]string:
+			for k := range m {
+				add(k)
+			}
+		case map[string]Image:
+			for k := range m {
+				add(k)
+			}
+		case map[string]File:
+			for k := range m {
+				add(k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,40 @@
+package builder
+
+import (
+	"bytes"
+)
+
+// AnnotationPrefix prefixes the lines of output of commands which are
+// reported in the summary of the build, as in
+// earthly-annotation: drift: infra (prod): Plan: 1 to add, 0 to change, 0 to destroy.
+// The std/terraform library reports the drift of the infrastructure this way.
+const AnnotationPrefix = "earthly-annotation: "
+
+// Annotation is a line of output of a command which is reported in the
+// summary of the build.
+type Annotation struct {
+	// Target is the target of the command.
+	Target string `json:"target"`
+	// Command is the command, as in RUN terraform plan.
+	Command string `json:"command"`
+	Text    string `json:"text"`
+}
+
+// Annotations returns the annotations of the commands run so far, in the
+// order they were output.
+func (b *Builder) Annotations() []Annotation {
+	sm := b.s.sm
+	sm.msgMu.Lock()
+	defer sm.msgMu.Unlock()
+	return append([]Annotation(nil), sm.annotations...)
+}
+
+// parseAnnotation parses a line of output which reports an annotation.
+func parseAnnotation(line []byte) (string, bool) {
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte(AnnotationPrefix)) {
+		return "", false
+	}
+	text := string(bytes.TrimSpace(line[len(AnnotationPrefix):]))
+	return text, text != ""
+}
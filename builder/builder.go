@@ -7,21 +7,32 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/earthly/earthly/artifactstore"
+	"github.com/earthly/earthly/audit"
 	"github.com/earthly/earthly/buildcontext"
 	"github.com/earthly/earthly/buildcontext/provider"
+	"github.com/earthly/earthly/capabilities"
 	"github.com/earthly/earthly/cleanup"
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/earthfile2llb"
+	"github.com/earthly/earthly/imagebudget"
+	"github.com/earthly/earthly/imageindex"
+	"github.com/earthly/earthly/imageverify"
+	"github.com/earthly/earthly/leakscan"
+	"github.com/earthly/earthly/privileged"
 	"github.com/earthly/earthly/states"
+	"github.com/earthly/earthly/util/fileutil"
+	"github.com/earthly/earthly/util/gitutil"
 	"github.com/earthly/earthly/util/gwclientlogger"
 	"github.com/earthly/earthly/util/llbutil"
 	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/retryutil"
 	"github.com/earthly/earthly/variables"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
@@ -50,6 +61,7 @@ type Opt struct {
 	MaxCacheExport         string
 	UseInlineCache         bool
 	SaveInlineCache        bool
+	Compression            Compression
 	ImageResolveMode       llb.ResolveMode
 	CleanCollection        *cleanup.Collection
 	OverridingVars         *variables.Scope
@@ -62,6 +74,33 @@ type Opt struct {
 	Parallelism            *semaphore.Weighted
 	LocalRegistryAddr      string
 	FeatureFlagOverrides   string
+	AuditLog               *audit.Log
+	OnSideEffect           func()
+	LocallyGrants          *capabilities.Grants
+	PrivilegedApprover     *privileged.Approver
+	RegistryRetry          retryutil.Policy
+	ImageIndex             *imageindex.Index
+	CachedImages           imageindex.CachedImages
+	Offline                bool
+	ArtifactStore          *artifactstore.Store
+	PushPolicy             PushPolicy
+	PushFanOut             bool
+	LeakScanner            *leakscan.Scanner
+	ImageBudgets           *imagebudget.History
+	Workspace              *buildcontext.Workspace
+	CacheNamespace         string
+	Mock                   bool
+	SourceDateEpoch        *time.Time
+	Hostname               string
+	DNS                    earthfile2llb.DNSConfig
+	DefaultUser            string
+	RandomSeed             string
+	ArgOverrides           []earthfile2llb.ArgOverride
+	LineEndings            string
+	BuildContexts          map[string]string
+	HasSecret              func(name string) bool
+	GetSecret              func(name string) ([]byte, error)
+	Runners                map[string]earthfile2llb.Runner
 }
 
 // BuildOpt is a collection of build options.
@@ -71,10 +110,15 @@ type BuildOpt struct {
 	PrintSuccess               bool
 	Push                       bool
 	NoOutput                   bool
+	NoLoad                     bool
+	OutputOnly                 []domain.Target
 	OnlyFinalTargetImages      bool
 	OnlyArtifact               *domain.Artifact
 	OnlyArtifactDestPath       string
 	EnableGatewayClientLogging bool
+	// FinalImageTag, if set, is the tag under which the image of the final
+	// target is output, whether or not the target saves an image.
+	FinalImageTag string
 }
 
 // Builder executes Earthly builds.
@@ -82,10 +126,14 @@ type Builder struct {
 	s         *solver
 	opt       Opt
 	resolver  *buildcontext.Resolver
+	verifier  *imageverify.Verifier
 	builtMain bool
 
 	outDirOnce sync.Once
 	outDir     string
+
+	savedLocalMu sync.Mutex
+	savedLocal   []string
 }
 
 // NewBuilder returns a new earthly Builder.
@@ -100,21 +148,44 @@ func NewBuilder(ctx context.Context, opt Opt) (*Builder, error) {
 			attachables:     opt.Attachables,
 			enttlmnts:       opt.Enttlmnts,
 			saveInlineCache: opt.SaveInlineCache,
+			compression:     opt.Compression,
 		},
 		opt:      opt,
 		resolver: nil, // initialized below
+		verifier: imageverify.NewVerifier(),
 	}
 	b.resolver = buildcontext.NewResolver(opt.SessionID, opt.CleanCollection, opt.GitLookup, opt.Console)
+	b.resolver.SetWorkspace(opt.Workspace)
+	b.resolver.SetLineEndings(opt.LineEndings)
 	return b, nil
 }
 
 // BuildTarget executes the build of a given Earthly target.
 func (b *Builder) BuildTarget(ctx context.Context, target domain.Target, opt BuildOpt) (*states.MultiTarget, error) {
-	mts, err := b.convertAndBuild(ctx, target, opt)
+	mtss, err := b.convertAndBuild(ctx, []TargetArgs{{Target: target}}, opt)
 	if err != nil {
 		return nil, err
 	}
-	return mts, nil
+	return mtss[0], nil
+}
+
+// TargetArgs is a target to build, along with its build args.
+type TargetArgs struct {
+	Target domain.Target
+	// OverridingVars are the build args of the target. If nil, those of the
+	// builder are used.
+	OverridingVars *variables.Scope
+}
+
+// BuildTargets executes the builds of several Earthly targets, each with its
+// own build args, as a single build - as if a single target issued a BUILD
+// for each of them. The targets which they have in common are converted and
+// built only once. It returns the states of each target, in order.
+func (b *Builder) BuildTargets(ctx context.Context, targets []TargetArgs, opt BuildOpt) ([]*states.MultiTarget, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("no targets to build")
+	}
+	return b.convertAndBuild(ctx, targets, opt)
 }
 
 // MakeImageAsTarBuilderFun returns a function which can be used to build an image as a tar.
@@ -147,7 +218,8 @@ func (sp *successPrinter) incrementIndex() {
 	sp.printIndex++
 }
 
-func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt BuildOpt) (*states.MultiTarget, error) {
+func (b *Builder) convertAndBuild(ctx context.Context, targets []TargetArgs, opt BuildOpt) ([]*states.MultiTarget, error) {
+	target := targets[0].Target
 	successFun := func(msg string) func() {
 		return func() {
 			if opt.PrintSuccess {
@@ -159,46 +231,36 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 
 	sharedLocalStateCache := earthfile2llb.NewSharedLocalStateCache()
 
-	featureFlagOverrides := b.opt.FeatureFlagOverrides
-
 	destPathWhitelist := make(map[string]bool)
 	manifestLists := make(map[string][]manifest) // parent image -> child images
 	var mts *states.MultiTarget
+	var mtss []*states.MultiTarget
+	isOtherFinal := make(map[string]bool) // sts ID -> final state of a target other than the first
 	depIndex := 0
 	imageIndex := 0
 	dirIndex := 0
 	localImages := make(map[string]string) // local reg pull name -> final name
+	var pushedImages []string              // tags which may have been pushed
+	fanOuts := make(map[string]string)     // tag pushed by copying it -> tag copied
+	scans := newScanCache()
+	var budgetErr error
+	converted := false // whether the targets were converted by an earlier attempt
+	solved := false    // whether the build function of the attempt succeeded
 	bf := func(childCtx context.Context, gwClient gwclient.Client) (*gwclient.Result, error) {
 		if opt.EnableGatewayClientLogging {
 			gwClient = gwclientlogger.New(gwClient)
 		}
 		var err error
-		if !b.builtMain {
-			mts, err = earthfile2llb.Earthfile2LLB(childCtx, target, earthfile2llb.ConvertOpt{
-				GwClient:             gwClient,
-				Resolver:             b.resolver,
-				ImageResolveMode:     b.opt.ImageResolveMode,
-				DockerBuilderFun:     b.MakeImageAsTarBuilderFun(),
-				CleanCollection:      b.opt.CleanCollection,
-				Platform:             opt.Platform,
-				OverridingVars:       b.opt.OverridingVars,
-				BuildContextProvider: b.opt.BuildContextProvider,
-				CacheImports:         b.opt.CacheImports,
-				UseInlineCache:       b.opt.UseInlineCache,
-				UseFakeDep:           b.opt.UseFakeDep,
-				AllowLocally:         !b.opt.Strict,
-				AllowInteractive:     !b.opt.Strict,
-				AllowPrivileged:      opt.AllowPrivileged,
-				ParallelConversion:   b.opt.ParallelConversion,
-				Parallelism:          b.opt.Parallelism,
-				Console:              b.opt.Console,
-				GitLookup:            b.opt.GitLookup,
-				FeatureFlagOverrides: featureFlagOverrides,
-				LocalStateCache:      sharedLocalStateCache,
-			}, true)
+		if !b.builtMain && !converted {
+			mtss, err = b.convertTargets(childCtx, gwClient, targets, opt, sharedLocalStateCache)
 			if err != nil {
 				return nil, err
 			}
+			converted = true
+			mts = mtss[0]
+			for _, other := range mtss[1:] {
+				isOtherFinal[other.Final.ID] = true
+			}
 		}
 		res := gwclient.NewResult()
 		if !b.builtMain {
@@ -219,7 +281,36 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 			res.AddMeta(fmt.Sprintf("%s/export-dir", refPrefix), []byte("true"))
 			res.AddMeta(fmt.Sprintf("%s/final-artifact", refPrefix), []byte("true"))
 		}
+		if !b.builtMain && !opt.NoOutput && opt.FinalImageTag != "" {
+			ref, err := b.stateToRef(childCtx, gwClient, mts.Final.MainState, mts.Final.Platform)
+			if err != nil {
+				return nil, err
+			}
+			config, err := json.Marshal(mts.Final.MainImage)
+			if err != nil {
+				return nil, errors.Wrapf(err, "marshal final image config")
+			}
+			refKey := fmt.Sprintf("image-%d", imageIndex)
+			refPrefix := fmt.Sprintf("ref/%s", refKey)
+			imageIndex++
 
+			localRegPullID := fmt.Sprintf("sess-%s/sp:img%d", gwClient.BuildOpts().SessionID, imageIndex)
+			localImages[localRegPullID] = opt.FinalImageTag
+			if b.opt.LocalRegistryAddr != "" {
+				res.AddMeta(fmt.Sprintf("%s/export-image-local-registry", refPrefix), []byte(localRegPullID))
+			} else {
+				res.AddMeta(fmt.Sprintf("%s/export-image", refPrefix), []byte("true"))
+			}
+			res.AddMeta(fmt.Sprintf("%s/image.name", refPrefix), []byte(opt.FinalImageTag))
+			res.AddMeta(fmt.Sprintf("%s/%s", refPrefix, exptypes.ExporterImageConfigKey), config)
+			res.AddMeta(fmt.Sprintf("%s/image-index", refPrefix), []byte(fmt.Sprintf("%d", imageIndex)))
+			res.AddRef(refKey, ref)
+		}
+
+		var pushTags, pushTargets []string
+		var leakImages []leakImage
+		var budgetImages []budgetImage
+		isPushTag := make(map[string]bool)
 		isMultiPlatform := make(map[string]bool) // DockerTag -> bool
 		for _, sts := range mts.All() {
 			if sts.Platform != nil {
@@ -232,7 +323,7 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 		}
 
 		for _, sts := range mts.All() {
-			if (sts.HasDangling && !b.opt.UseFakeDep) || (b.builtMain && sts.RunPush.HasState) {
+			if (sts.HasDangling && !b.opt.UseFakeDep) || (!b.builtMain && isOtherFinal[sts.ID]) || (b.builtMain && sts.RunPush.HasState) {
 				depRef, err := b.stateToRef(childCtx, gwClient, b.targetPhaseState(sts), sts.Platform)
 				if err != nil {
 					return nil, err
@@ -244,12 +335,41 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 
 			for _, saveImage := range b.targetPhaseImages(sts) {
 				shouldPush := opt.Push && saveImage.Push && !sts.Target.IsRemote() && saveImage.DockerTag != "" && saveImage.DoSave
-				shouldExport := !opt.NoOutput && opt.OnlyArtifact == nil && !(opt.OnlyFinalTargetImages && sts != mts.Final) && saveImage.DockerTag != "" && saveImage.DoSave
+				shouldExport := !opt.NoOutput && !opt.NoLoad && opt.OnlyArtifact == nil && !(opt.OnlyFinalTargetImages && sts != mts.Final) && opt.outputs(sts.Target) && saveImage.DockerTag != "" && saveImage.DoSave
 				useCacheHint := saveImage.CacheHint && b.opt.CacheExport != ""
 				if (!shouldPush && !shouldExport && !useCacheHint) || (!shouldPush && saveImage.HasPushDependencies) {
 					// Short-circuit.
 					continue
 				}
+				if shouldPush && !isPushTag[saveImage.DockerTag] {
+					isPushTag[saveImage.DockerTag] = true
+					pushTags = append(pushTags, saveImage.DockerTag)
+					pushTargets = append(pushTargets, sts.Target.StringCanonical())
+				}
+				// Tags which are copied from another tag, once it has been
+				// pushed, are not pushed by buildkitd.
+				fanOut := b.opt.PushFanOut && shouldPush && saveImage.FanOutFrom != ""
+				if shouldPush && !fanOut && b.opt.LeakScanner != nil {
+					leakImages = append(leakImages, leakImage{
+						tag:      saveImage.DockerTag,
+						platform: sts.Platform,
+						layers:   saveImage.Layers,
+					})
+				}
+				if saveImage.Budget.IsSet() && !fanOut {
+					budgetImages = append(budgetImages, budgetImage{
+						tag:      saveImage.DockerTag,
+						platform: sts.Platform,
+						state:    saveImage.State,
+						budget:   saveImage.Budget,
+					})
+				}
+				if fanOut {
+					fanOuts[saveImage.DockerTag] = saveImage.FanOutFrom
+					if !shouldExport && !useCacheHint {
+						continue
+					}
+				}
 				ref, err := b.stateToRef(childCtx, gwClient, saveImage.State, sts.Platform)
 				if err != nil {
 					return nil, err
@@ -274,7 +394,7 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 						}
 					}
 					res.AddMeta(fmt.Sprintf("%s/image.name", refPrefix), []byte(saveImage.DockerTag))
-					if shouldPush {
+					if shouldPush && !fanOut {
 						res.AddMeta(fmt.Sprintf("%s/export-image-push", refPrefix), []byte("true"))
 						if saveImage.InsecurePush {
 							res.AddMeta(fmt.Sprintf("%s/insecure-push", refPrefix), []byte("true"))
@@ -292,7 +412,7 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 					// (docker load does not support tars with manifest lists).
 
 					// For push.
-					if shouldPush {
+					if shouldPush && !fanOut {
 						refKey := fmt.Sprintf("image-%d", imageIndex)
 						refPrefix := fmt.Sprintf("ref/%s", refKey)
 						imageIndex++
@@ -343,7 +463,8 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 			}
 			performSaveLocals := (!opt.NoOutput &&
 				!opt.OnlyFinalTargetImages &&
-				opt.OnlyArtifact == nil)
+				opt.OnlyArtifact == nil &&
+				opt.outputs(sts.Target))
 
 			if performSaveLocals {
 				for _, saveLocal := range b.targetPhaseArtifacts(sts) {
@@ -377,6 +498,43 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 				}
 			}
 		}
+		// Everything has been built at this point, but nothing has been
+		// pushed yet, as pushing happens when the result is exported.
+		exceeded, err := b.checkBudgets(childCtx, gwClient, scans, budgetImages)
+		if err != nil {
+			return nil, err
+		}
+		if len(exceeded) != 0 {
+			budgetErr = errors.Errorf("images exceed their budgets:\n\t%s", strings.Join(exceeded, "\n\t"))
+			if opt.Push {
+				// Nothing is pushed. Otherwise, the images are still output,
+				// such that they can be inspected, and the build fails after.
+				return nil, budgetErr
+			}
+		}
+		if b.opt.LeakScanner != nil {
+			err = b.scanLeaks(childCtx, gwClient, scans, leakImages)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = b.opt.PushPolicy.verify(pushTags)
+		if err != nil {
+			return nil, err
+		}
+		if len(pushTags) != 0 {
+			b.sideEffect()
+		}
+		// The pushes are recorded before they are attempted, such that
+		// those which fail are recorded too.
+		for i, tag := range pushTags {
+			err = b.opt.AuditLog.Record(audit.KindPush, pushTargets[i], tag)
+			if err != nil {
+				return nil, err
+			}
+		}
+		pushedImages = append(pushedImages, pushTags...)
+		solved = true
 		return res, nil
 	}
 	onImage := func(childCtx context.Context, eg *errgroup.Group, imageName string) (io.WriteCloser, error) {
@@ -438,10 +596,28 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 		}
 		return dockerPullLocalImages(childCtx, b.opt.LocalRegistryAddr, pullMap, b.opt.Console)
 	}
-	err := b.s.buildMainMulti(ctx, bf, onImage, onArtifact, onFinalArtifact, onPull, "main")
+	// The failures to push the images of the build are retried as per the
+	// registry retry policy. The build itself succeeded by then, and is
+	// cached, such that the retries only repeat the exports.
+	err := b.opt.RegistryRetry.Do(ctx, func(err error) bool {
+		return solved && len(pushedImages) != 0 && retryutil.IsTransientRegistryError(err)
+	}, func(ctx context.Context) error {
+		solved = false
+		depIndex, imageIndex, dirIndex = 0, 0, 0
+		localImages = make(map[string]string)
+		manifestLists = make(map[string][]manifest)
+		pushedImages = nil
+		return b.s.buildMainMulti(ctx, bf, onImage, onArtifact, onFinalArtifact, onPull, "main")
+	})
 	if err != nil {
+		b.rollbackPush(ctx, target, pushedImages, nil, err)
 		return nil, errors.Wrapf(err, "build main")
 	}
+	err = b.fanOutPushes(ctx, fanOuts)
+	if err != nil {
+		b.rollbackPush(ctx, target, pushedImages, nil, err)
+		return nil, err
+	}
 	sp.printCurrentSuccess()
 	if outDir == "" {
 		var err error
@@ -462,10 +638,29 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 			}
 		}
 		if hasRunPush {
+			b.sideEffect()
+			for _, sts := range mts.All() {
+				for _, commandStr := range sts.RunPush.CommandStrs {
+					err = b.opt.AuditLog.Record(audit.KindRunPush, sts.Target.StringCanonical(), commandStr)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
 			err = b.s.buildMainMulti(ctx, bf, onImage, onArtifact, onFinalArtifact, onPull, "--push")
 			if err != nil {
+				var commands []string
+				for _, sts := range mts.All() {
+					commands = append(commands, sts.RunPush.CommandStrs...)
+				}
+				b.rollbackPush(ctx, target, pushedImages, commands, err)
 				return nil, errors.Wrapf(err, "build push")
 			}
+			err = b.fanOutPushes(ctx, fanOuts)
+			if err != nil {
+				b.rollbackPush(ctx, target, pushedImages, nil, err)
+				return nil, err
+			}
 		}
 		sp.printCurrentSuccess()
 	}
@@ -473,14 +668,14 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 	if opt.NoOutput {
 		// Nothing.
 	} else if opt.OnlyArtifact != nil {
-		err := b.saveArtifactLocally(ctx, *opt.OnlyArtifact, outDir, opt.OnlyArtifactDestPath, mts.Final.ID, opt, false)
+		err := b.saveArtifactLocally(ctx, *opt.OnlyArtifact, outDir, opt.OnlyArtifactDestPath, mts.Final, opt, false)
 		if err != nil {
 			return nil, err
 		}
 	} else if opt.OnlyFinalTargetImages {
 		for _, saveImage := range mts.Final.SaveImages {
 			shouldPush := opt.Push && saveImage.Push && saveImage.DockerTag != "" && saveImage.DoSave
-			shouldExport := !opt.NoOutput && saveImage.DockerTag != "" && saveImage.DoSave
+			shouldExport := !opt.NoOutput && !opt.NoLoad && saveImage.DockerTag != "" && saveImage.DoSave
 			if !shouldPush && !shouldExport {
 				continue
 			}
@@ -503,7 +698,7 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 			console := b.opt.Console.WithPrefixAndSalt(sts.Target.String(), sts.ID)
 			for _, saveImage := range sts.SaveImages {
 				shouldPush := opt.Push && saveImage.Push && !sts.Target.IsRemote() && saveImage.DockerTag != "" && saveImage.DoSave
-				shouldExport := !opt.NoOutput && saveImage.DockerTag != "" && saveImage.DoSave
+				shouldExport := !opt.NoOutput && !opt.NoLoad && opt.outputs(sts.Target) && saveImage.DockerTag != "" && saveImage.DoSave
 				if !shouldPush && !shouldExport {
 					continue
 				}
@@ -517,13 +712,17 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 					console.Printf("Did not push %s. Use earthly --push to enable pushing\n", saveImage.DockerTag)
 				}
 			}
-			for _, saveLocal := range sts.SaveLocals {
+			saveLocals, pushSaveLocals := sts.SaveLocals, sts.RunPush.SaveLocals
+			if !opt.outputs(sts.Target) {
+				saveLocals, pushSaveLocals = nil, nil
+			}
+			for _, saveLocal := range saveLocals {
 				artifactDir := filepath.Join(outDir, fmt.Sprintf("index-%d", dirIndex))
 				artifact := domain.Artifact{
 					Target:   sts.Target,
 					Artifact: saveLocal.ArtifactPath,
 				}
-				err := b.saveArtifactLocally(ctx, artifact, artifactDir, saveLocal.DestPath, sts.ID, opt, saveLocal.IfExists)
+				err := b.saveArtifactLocally(ctx, artifact, artifactDir, saveLocal.DestPath, sts, opt, saveLocal.IfExists)
 				if err != nil {
 					return nil, err
 				}
@@ -532,13 +731,13 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 
 			if sts.RunPush.HasState {
 				if opt.Push {
-					for _, saveLocal := range sts.RunPush.SaveLocals {
+					for _, saveLocal := range pushSaveLocals {
 						artifactDir := filepath.Join(outDir, fmt.Sprintf("index-%d", dirIndex))
 						artifact := domain.Artifact{
 							Target:   sts.Target,
 							Artifact: saveLocal.ArtifactPath,
 						}
-						err := b.saveArtifactLocally(ctx, artifact, artifactDir, saveLocal.DestPath, sts.ID, opt, saveLocal.IfExists)
+						err := b.saveArtifactLocally(ctx, artifact, artifactDir, saveLocal.DestPath, sts, opt, saveLocal.IfExists)
 						if err != nil {
 							return nil, err
 						}
@@ -566,8 +765,79 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 			return nil, err
 		}
 	}
+	if budgetErr != nil {
+		return nil, budgetErr
+	}
+
+	return mtss, nil
+}
 
-	return mts, nil
+// convertTargets converts the targets to LLB, sharing their visited states, so
+// that the targets which they have in common are converted only once.
+func (b *Builder) convertTargets(ctx context.Context, gwClient gwclient.Client, targets []TargetArgs, opt BuildOpt, localStateCache *earthfile2llb.LocalStateCache) ([]*states.MultiTarget, error) {
+	convertOpt := b.newConvertOpt(gwClient, opt, localStateCache)
+	convertOpt.Visited = states.NewVisitedCollection()
+	convertOpt.SolveCache = states.NewSolveCache()
+	mtss := make([]*states.MultiTarget, 0, len(targets))
+	for _, ta := range targets {
+		targetOpt := convertOpt
+		if ta.OverridingVars != nil {
+			targetOpt.OverridingVars = ta.OverridingVars
+		}
+		mts, err := earthfile2llb.Earthfile2LLB(ctx, ta.Target, targetOpt, true)
+		if err != nil {
+			return nil, err
+		}
+		mtss = append(mtss, mts)
+	}
+	return mtss, nil
+}
+
+func (b *Builder) newConvertOpt(gwClient gwclient.Client, opt BuildOpt, localStateCache *earthfile2llb.LocalStateCache) earthfile2llb.ConvertOpt {
+	return earthfile2llb.ConvertOpt{
+		GwClient:             gwClient,
+		Resolver:             b.resolver,
+		ImageResolveMode:     b.opt.ImageResolveMode,
+		DockerBuilderFun:     b.MakeImageAsTarBuilderFun(),
+		CleanCollection:      b.opt.CleanCollection,
+		Platform:             opt.Platform,
+		OverridingVars:       b.opt.OverridingVars,
+		BuildContextProvider: b.opt.BuildContextProvider,
+		CacheImports:         b.opt.CacheImports,
+		UseInlineCache:       b.opt.UseInlineCache,
+		UseFakeDep:           b.opt.UseFakeDep,
+		AllowLocally:         !b.opt.Strict,
+		AllowInteractive:     !b.opt.Strict,
+		FailOnDeprecated:     b.opt.Strict,
+		AllowPrivileged:      opt.AllowPrivileged,
+		ParallelConversion:   b.opt.ParallelConversion,
+		Parallelism:          b.opt.Parallelism,
+		Console:              b.opt.Console,
+		GitLookup:            b.opt.GitLookup,
+		FeatureFlagOverrides: b.opt.FeatureFlagOverrides,
+		LocalStateCache:      localStateCache,
+		AuditLog:             b.opt.AuditLog,
+		OnSideEffect:         b.opt.OnSideEffect,
+		LocallyGrants:        b.opt.LocallyGrants,
+		PrivilegedApprover:   b.opt.PrivilegedApprover,
+		RegistryRetry:        b.opt.RegistryRetry,
+		ImageIndex:           b.opt.ImageIndex,
+		CachedImages:         b.opt.CachedImages,
+		Offline:              b.opt.Offline,
+		ImageVerifier:        b.verifier,
+		CacheNamespace:       b.opt.CacheNamespace,
+		BuildContexts:        b.opt.BuildContexts,
+		HasSecret:            b.opt.HasSecret,
+		GetSecret:            b.opt.GetSecret,
+		Runners:              b.opt.Runners,
+		Mock:                 b.opt.Mock,
+		SourceDateEpoch:      b.opt.SourceDateEpoch,
+		Hostname:             b.opt.Hostname,
+		DNS:                  b.opt.DNS,
+		DefaultUser:          b.opt.DefaultUser,
+		RandomSeed:           b.opt.RandomSeed,
+		ArgOverrides:         b.opt.ArgOverrides,
+	}
 }
 
 func (b *Builder) targetPhaseState(sts *states.SingleTarget) pllb.State {
@@ -656,8 +926,8 @@ func (b *Builder) outputImageTar(ctx context.Context, saveImage states.SaveImage
 	return nil
 }
 
-func (b *Builder) saveArtifactLocally(ctx context.Context, artifact domain.Artifact, indexOutDir string, destPath string, salt string, opt BuildOpt, ifExists bool) error {
-	console := b.opt.Console.WithPrefixAndSalt(artifact.Target.String(), salt)
+func (b *Builder) saveArtifactLocally(ctx context.Context, artifact domain.Artifact, indexOutDir string, destPath string, sts *states.SingleTarget, opt BuildOpt, ifExists bool) error {
+	console := b.opt.Console.WithPrefixAndSalt(artifact.Target.String(), sts.ID)
 	fromPattern := filepath.Join(indexOutDir, filepath.FromSlash(artifact.Artifact))
 	// Resolve possible wildcards.
 	// TODO: Note that this is not very portable, as the glob is host-platform dependent,
@@ -672,24 +942,31 @@ func (b *Builder) saveArtifactLocally(ctx context.Context, artifact domain.Artif
 		return errors.Errorf("cannot save artifact %s, since it does not exist", artifact.StringCanonical())
 	}
 	isWildcard := strings.ContainsAny(fromPattern, `*?[`)
+	var gitCommit string
+	if b.opt.ArtifactStore != nil {
+		gitCommit = artifactGitCommit(ctx, sts)
+	}
 	for _, from := range fromGlobMatches {
 		fiSrc, err := os.Stat(from)
 		if err != nil {
 			return errors.Wrapf(err, "os stat %s", from)
 		}
 		srcIsDir := fiSrc.IsDir()
-		to := destPath
-		destIsDir := strings.HasSuffix(to, "/")
+		// The destination is expressed in Earthfile (slash) syntax, but needs
+		// to be translated to the host's separators, as on Windows the path
+		// package would not recognize C:\... style paths.
+		to := filepath.FromSlash(destPath)
+		destIsDir := strings.HasSuffix(destPath, "/") || strings.HasSuffix(to, string(filepath.Separator))
 		if artifact.Target.IsLocalExternal() && !filepath.IsAbs(to) {
 			// Place within external dir.
-			to = path.Join(artifact.Target.LocalPath, to)
+			to = filepath.Join(filepath.FromSlash(artifact.Target.LocalPath), to)
 		}
 		if destIsDir {
 			// Place within dest dir.
-			to = path.Join(to, path.Base(from))
+			to = filepath.Join(to, filepath.Base(from))
 		}
 		destExists := false
-		fiDest, err := os.Stat(to)
+		fiDest, err := os.Stat(fileutil.LongPath(to))
 		if err != nil {
 			// Ignore err. Likely dest path does not exist.
 			if isWildcard && !destIsDir {
@@ -708,33 +985,37 @@ func (b *Builder) saveArtifactLocally(ctx context.Context, artifact domain.Artif
 		if destExists {
 			if !srcIsDir {
 				// Remove pre-existing dest file.
-				err = os.Remove(to)
+				err = os.Remove(fileutil.LongPath(to))
 				if err != nil {
 					return errors.Wrapf(err, "rm %s", to)
 				}
 			} else {
 				// Remove pre-existing dest dir.
-				err = os.RemoveAll(to)
+				err = os.RemoveAll(fileutil.LongPath(to))
 				if err != nil {
 					return errors.Wrapf(err, "rm -rf %s", to)
 				}
 			}
 		}
 
-		toDir := path.Dir(to)
-		err = os.MkdirAll(toDir, 0755)
+		toDir := filepath.Dir(to)
+		err = os.MkdirAll(fileutil.LongPath(toDir), 0755)
 		if err != nil {
 			return errors.Wrapf(err, "mkdir all for artifact %s", toDir)
 		}
-		err = os.Link(from, to)
+		err = os.Link(fileutil.LongPath(from), fileutil.LongPath(to))
 		if err != nil {
 			// Hard linking did not work. Try recursive copy.
-			errCopy := reccopy.Copy(from, to)
+			errCopy := reccopy.Copy(fileutil.LongPath(from), fileutil.LongPath(to))
 			if errCopy != nil {
 				return errors.Wrapf(errCopy, "copy artifact %s", from)
 			}
 		}
 
+		b.savedLocalMu.Lock()
+		b.savedLocal = append(b.savedLocal, to)
+		b.savedLocalMu.Unlock()
+
 		// Write to console about this artifact.
 		artifactPath := trimFilePathPrefix(indexOutDir, from, console)
 		artifact2 := domain.Artifact{
@@ -745,6 +1026,16 @@ func (b *Builder) saveArtifactLocally(ctx context.Context, artifact domain.Artif
 		if strings.HasSuffix(destPath, "/") {
 			destPath2 = filepath.Join(destPath2, filepath.Base(artifactPath))
 		}
+		err = b.opt.AuditLog.Record(audit.KindLocalWrite, artifact2.StringCanonical(), destPath2)
+		if err != nil {
+			return err
+		}
+		if b.opt.ArtifactStore != nil {
+			_, err = b.opt.ArtifactStore.Put(from, artifact2.StringCanonical(), gitCommit)
+			if err != nil {
+				return errors.Wrapf(err, "store artifact %s", artifact2.StringCanonical())
+			}
+		}
 		if opt.PrintSuccess {
 			artifactStr := console.PrefixColor().Sprintf("%s", artifact2.StringCanonical())
 			console.Printf("Artifact %s as local %s\n", artifactStr, destPath2)
@@ -753,6 +1044,60 @@ func (b *Builder) saveArtifactLocally(ctx context.Context, artifact domain.Artif
 	return nil
 }
 
+// SavedLocal returns the paths of the host which the build saved artifacts
+// to so far, via SAVE ARTIFACT ... AS LOCAL. The paths of artifacts which are
+// directories are those of the directories.
+func (b *Builder) SavedLocal() []string {
+	b.savedLocalMu.Lock()
+	defer b.savedLocalMu.Unlock()
+	return append([]string(nil), b.savedLocal...)
+}
+
+// sideEffect notifies the caller that the build is about to push, which
+// cannot be undone.
+func (b *Builder) sideEffect() {
+	if b.opt.OnSideEffect != nil {
+		b.opt.OnSideEffect()
+	}
+}
+
+// rollbackPush notifies the rollback hook of a failed push phase, so that the
+// images and commands which may have been pushed can be reverted.
+func (b *Builder) rollbackPush(ctx context.Context, target domain.Target, images, commands []string, buildErr error) {
+	rb := PushRollback{
+		Target:   target.StringCanonical(),
+		Images:   images,
+		Commands: commands,
+		Error:    buildErr.Error(),
+	}
+	err := b.opt.PushPolicy.rollback(ctx, rb)
+	if err != nil {
+		b.opt.Console.Warnf("Warning: %s\n", err.Error())
+	} else if b.opt.PushPolicy.RollbackHook != "" && len(images)+len(commands) != 0 {
+		b.opt.Console.Printf("Push phase failed; executed the push rollback hook for %d images and %d commands\n", len(images), len(commands))
+	}
+}
+
+// artifactGitCommit returns the git commit which the target was built from,
+// if known. No commit is returned for local targets whose working tree has
+// uncommitted changes, as the artifact may not match any commit.
+func artifactGitCommit(ctx context.Context, sts *states.SingleTarget) string {
+	if sts.Target.IsRemote() {
+		return sts.GitCommit
+	}
+	dir := filepath.FromSlash(sts.Target.LocalPath)
+	// Metadata returns partial results alongside errors (e.g. no remote).
+	gitMeta, _ := gitutil.Metadata(ctx, dir)
+	if gitMeta == nil {
+		return ""
+	}
+	dirty, err := gitutil.IsDirty(ctx, dir)
+	if err != nil || dirty {
+		return ""
+	}
+	return gitMeta.Hash
+}
+
 func (b *Builder) tempEarthlyOutDir() (string, error) {
 	var err error
 	b.outDirOnce.Do(func() {
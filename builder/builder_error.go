@@ -2,18 +2,22 @@ package builder
 
 // BuildError contains an BuildError and log
 type BuildError struct {
-	err error
-	log string
+	err          error
+	log          string
+	vertexDigest string
+	vertexName   string
 }
 
-// NewBuildError creates a new BuildError with the additional output log of the command that failed
-func NewBuildError(err error, vertexLog string) error {
-	if vertexLog == "" {
+// NewBuildError creates a new BuildError with the additional output log, digest and name of the vertex that failed
+func NewBuildError(err error, vertexLog, vertexDigest, vertexName string) error {
+	if vertexLog == "" && vertexDigest == "" {
 		return err
 	}
 	return &BuildError{
-		err: err,
-		log: vertexLog,
+		err:          err,
+		log:          vertexLog,
+		vertexDigest: vertexDigest,
+		vertexName:   vertexName,
 	}
 }
 
@@ -34,3 +38,13 @@ func (e *BuildError) Unwrap() error {
 func (e *BuildError) VertexLog() string {
 	return e.log
 }
+
+// VertexDigest returns the digest of the LLB vertex which failed, if known
+func (e *BuildError) VertexDigest() string {
+	return e.vertexDigest
+}
+
+// VertexName returns the name of the LLB vertex which failed, if known
+func (e *BuildError) VertexName() string {
+	return e.vertexName
+}
@@ -0,0 +1,94 @@
+package builder
+
+import (
+	"strconv"
+
+	"github.com/earthly/earthly/buildkitd"
+	"github.com/pkg/errors"
+)
+
+// The compressions of the layers of the exported images.
+const (
+	CompressionGzip         = "gzip"
+	CompressionZstd         = "zstd"
+	CompressionEstargz      = "estargz"
+	CompressionUncompressed = "uncompressed"
+)
+
+// Compression is how the layers of the images exported by a build are
+// compressed. The zero value is the default of buildkitd (gzip).
+type Compression struct {
+	// Type is the compression algorithm, or the lazy-pulling format (estargz).
+	Type string
+	// Level is the compression level. Zero means the default level of the
+	// algorithm.
+	Level int
+	// Force recompresses the layers which are already compressed otherwise,
+	// such as those of base images.
+	Force bool
+}
+
+// maxCompressionLevels are the compression levels of each type of compression,
+// from 1 to max.
+var maxCompressionLevels = map[string]int{
+	CompressionGzip:         9,
+	CompressionZstd:         22,
+	CompressionEstargz:      9,
+	CompressionUncompressed: 0,
+}
+
+// Validate returns an error if the compression is invalid.
+func (c Compression) Validate() error {
+	if c.Type == "" {
+		if c.Level != 0 || c.Force {
+			return errors.New("a compression level and forcing compression require a compression type")
+		}
+		return nil
+	}
+	maxLevel, ok := maxCompressionLevels[c.Type]
+	if !ok {
+		return errors.Errorf("invalid compression %s: must be one of gzip, zstd, estargz or uncompressed", c.Type)
+	}
+	if c.Level < 0 || c.Level > maxLevel {
+		if maxLevel == 0 {
+			return errors.Errorf("compression %s does not have levels", c.Type)
+		}
+		return errors.Errorf("invalid compression level %d: must be between 1 and %d for %s", c.Level, maxLevel, c.Type)
+	}
+	return nil
+}
+
+// RequiredCapability returns the capability buildkitd needs to have for the
+// compression, if any.
+func (c Compression) RequiredCapability() string {
+	switch c.Type {
+	case CompressionZstd:
+		return buildkitd.CapCompressionZstd
+	case CompressionEstargz:
+		return buildkitd.CapCompressionEstargz
+	default:
+		return ""
+	}
+}
+
+// exporterAttrs returns the attributes of the image exporter for the
+// compression.
+func (c Compression) exporterAttrs() map[string]string {
+	attrs := make(map[string]string)
+	if c.Type == "" {
+		return attrs
+	}
+	attrs["compression"] = c.Type
+	if c.Level != 0 {
+		attrs["compression-level"] = strconv.Itoa(c.Level)
+	}
+	if c.Force {
+		attrs["force-compression"] = "true"
+	}
+	if c.Type == CompressionZstd || c.Type == CompressionEstargz {
+		// Docker media types cannot describe zstd layers, and the TOC of
+		// estargz layers is an OCI annotation.
+		attrs["oci-mediatypes"] = "true"
+	}
+	return attrs
+}
@@ -0,0 +1,46 @@
+package builder
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestCompressionValidate(t *testing.T) {
+	for _, tc := range []struct {
+		c     Compression
+		valid bool
+	}{
+		{Compression{}, true},
+		{Compression{Type: "zstd", Level: 19, Force: true}, true},
+		{Compression{Type: "gzip", Level: 9}, true},
+		{Compression{Type: "estargz"}, true},
+		{Compression{Type: "uncompressed"}, true},
+		{Compression{Type: "gzip", Level: 10}, false},
+		{Compression{Type: "zstd", Level: -1}, false},
+		{Compression{Type: "uncompressed", Level: 1}, false},
+		{Compression{Type: "nydus"}, false},
+		{Compression{Level: 3}, false},
+		{Compression{Force: true}, false},
+	} {
+		err := tc.c.Validate()
+		if tc.valid {
+			NoError(t, err, "%+v", tc.c)
+		} else {
+			Error(t, err, "%+v", tc.c)
+		}
+	}
+}
+
+func TestCompressionExporterAttrs(t *testing.T) {
+	Equal(t, map[string]string{}, Compression{}.exporterAttrs())
+	Equal(t, map[string]string{"compression": "gzip", "compression-level": "6"}, Compression{Type: "gzip", Level: 6}.exporterAttrs())
+	Equal(t, map[string]string{
+		"compression":       "zstd",
+		"compression-level": "19",
+		"force-compression": "true",
+		"oci-mediatypes":    "true",
+	}, Compression{Type: "zstd", Level: 19, Force: true}.exporterAttrs())
+	Equal(t, "", Compression{Type: "gzip"}.RequiredCapability())
+	Equal(t, "compression-estargz", Compression{Type: "estargz"}.RequiredCapability())
+}
@@ -0,0 +1,49 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// ResolveImageDigests resolves the given image tags against their registries,
+// bypassing any cached resolution. It is meant to be called after pushing the
+// images, in order to find out the digests they were pushed as.
+func (b *Builder) ResolveImageDigests(ctx context.Context, tags []string) (map[string]string, error) {
+	var mu sync.Mutex
+	digests := make(map[string]string)
+	bf := func(childCtx context.Context, gwClient gwclient.Client) (*gwclient.Result, error) {
+		eg, egCtx := errgroup.WithContext(childCtx)
+		for _, tag := range tags {
+			tag := tag
+			eg.Go(func() error {
+				dgst, _, err := gwClient.ResolveImageConfig(egCtx, tag, llb.ResolveImageConfigOpt{
+					ResolveMode: llb.ResolveModeForcePull.String(),
+					LogName:     fmt.Sprintf("[internal] resolve digest of %s", tag),
+				})
+				if err != nil {
+					return errors.Wrapf(err, "resolve digest of %s", tag)
+				}
+				mu.Lock()
+				digests[tag] = dgst.String()
+				mu.Unlock()
+				return nil
+			})
+		}
+		err := eg.Wait()
+		if err != nil {
+			return nil, err
+		}
+		return gwclient.NewResult(), nil
+	}
+	err := b.s.buildNoExport(ctx, bf, "resolve digests")
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
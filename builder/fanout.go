@@ -0,0 +1,63 @@
+package builder
+
+import (
+	"context"
+	"sort"
+
+	"github.com/docker/distribution/reference"
+	"github.com/earthly/earthly/util/registryutil"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// fanOutPushes pushes the tags which have been saved along with another tag of
+// the same registry, by copying the latter once it has been pushed, rather
+// than pushing the image once per tag. The layers are mounted from the
+// repository of the pushed tag, and are thus only uploaded once. The map is
+// emptied as the tags are pushed.
+func (b *Builder) fanOutPushes(ctx context.Context, fanOuts map[string]string) error {
+	if len(fanOuts) == 0 {
+		return nil
+	}
+	tags := make([]string, 0, len(fanOuts))
+	for tag := range fanOuts {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	client := registryutil.NewClient(nil)
+	eg, egCtx := errgroup.WithContext(ctx)
+	for _, tag := range tags {
+		dstTag, srcTag := tag, fanOuts[tag]
+		eg.Go(func() error {
+			src, err := parseNormalizedRef(srcTag)
+			if err != nil {
+				return err
+			}
+			dst, err := parseNormalizedRef(dstTag)
+			if err != nil {
+				return err
+			}
+			_, err = client.Copy(egCtx, src, dst)
+			if err != nil {
+				return errors.Wrapf(err, "push %s from %s", dstTag, srcTag)
+			}
+			b.opt.Console.Printf("Pushed %s from %s\n", dstTag, srcTag)
+			return nil
+		})
+	}
+	err := eg.Wait()
+	for _, tag := range tags {
+		delete(fanOuts, tag)
+	}
+	return err
+}
+
+// parseNormalizedRef parses an image name, which may omit the registry (as in
+// alpine:3.13), as a registry reference.
+func parseNormalizedRef(imageName string) (registryutil.Ref, error) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return registryutil.Ref{}, errors.Wrapf(err, "parse image name %s", imageName)
+	}
+	return registryutil.ParseRef(reference.TagNameOnly(named).String())
+}
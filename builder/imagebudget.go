@@ -0,0 +1,60 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/earthly/earthly/imagebudget"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// budgetImage is an image whose size and number of files are limited, as per
+// SAVE IMAGE --max-size and --max-files.
+type budgetImage struct {
+	tag      string
+	platform *specs.Platform
+	state    pllb.State
+	budget   imagebudget.Budget
+}
+
+// checkBudgets measures the images, and returns how those which exceed their
+// budgets do, along with what grew since they were last within budget. The
+// usage of the images within budget is recorded, for later builds to compare
+// to.
+func (b *Builder) checkBudgets(ctx context.Context, gwClient gwclient.Client, ls *scanCache, images []budgetImage) ([]string, error) {
+	var exceeded []string
+	checked := make(map[string]bool)
+	for _, img := range images {
+		platform := llbutil.PlatformWithDefaultToString(img.platform)
+		if checked[img.tag+"|"+platform] {
+			continue
+		}
+		checked[img.tag+"|"+platform] = true
+		index, _, err := b.indexState(ctx, gwClient, ls, img.state, img.platform)
+		if err != nil {
+			return nil, err
+		}
+		u := imagebudget.NewUsage()
+		for p, e := range index {
+			u.Add(p, e.Size)
+		}
+		reasons := img.budget.Check(u)
+		if len(reasons) == 0 {
+			if b.opt.ImageBudgets != nil {
+				b.opt.ImageBudgets.Put(img.tag, platform, u)
+			}
+			continue
+		}
+		var last *imagebudget.Usage
+		if b.opt.ImageBudgets != nil {
+			last = b.opt.ImageBudgets.Get(img.tag, platform)
+		}
+		explanation := strings.ReplaceAll(imagebudget.Explain(u, last), "\n\t", "\n\t\t")
+		exceeded = append(exceeded, fmt.Sprintf("%s (%s): %s; %s", img.tag, platform, strings.Join(reasons, " and "), explanation))
+	}
+	return exceeded, nil
+}
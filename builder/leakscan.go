@@ -0,0 +1,135 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/earthly/earthly/leakscan"
+	"github.com/earthly/earthly/states"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// leakImage is an image about to be pushed, whose layers are scanned for
+// leaked secrets.
+type leakImage struct {
+	tag      string
+	platform *specs.Platform
+	layers   []states.Layer
+}
+
+// scanCache holds the states which have been listed and scanned already, so
+// that those which several images share are only walked once.
+type scanCache struct {
+	indexes  map[llb.Output]map[string]leakscan.Entry
+	findings map[llb.Output][]leakscan.Finding
+}
+
+func newScanCache() *scanCache {
+	return &scanCache{
+		indexes:  make(map[llb.Output]map[string]leakscan.Entry),
+		findings: make(map[llb.Output][]leakscan.Finding),
+	}
+}
+
+// scanLeaks scans the files which the layers of the images wrote for leaked
+// secrets, and fails if any is found, pointing at the commands which wrote
+// them. Only the layers produced by the build are scanned, not those of the
+// base images.
+func (b *Builder) scanLeaks(ctx context.Context, gwClient gwclient.Client, ls *scanCache, images []leakImage) error {
+	var leaks []string
+	for _, img := range images {
+		for _, layer := range img.layers {
+			findings, ok := ls.findings[layer.After.Output()]
+			if !ok {
+				before, _, err := b.indexState(ctx, gwClient, ls, layer.Before, img.platform)
+				if err != nil {
+					return err
+				}
+				after, fs, err := b.indexState(ctx, gwClient, ls, layer.After, img.platform)
+				if err != nil {
+					return err
+				}
+				findings, err = b.opt.LeakScanner.ScanFiles(ctx, fs, leakscan.Changed(before, after))
+				if err != nil {
+					return errors.Wrapf(err, "scan %s", layer.Command)
+				}
+				ls.findings[layer.After.Output()] = findings
+			}
+			for _, f := range findings {
+				leaks = append(leaks, fmt.Sprintf("%s: %s, written by %s (%s)", img.tag, f.String(), shortCommand(layer.Command), layer.SourceLocation))
+			}
+		}
+	}
+	if len(leaks) != 0 {
+		return errors.Errorf("refusing to push, as secrets leaked into the images:\n\t%s", strings.Join(leaks, "\n\t"))
+	}
+	return nil
+}
+
+// indexState lists the regular files of the state.
+func (b *Builder) indexState(ctx context.Context, gwClient gwclient.Client, ls *scanCache, state pllb.State, platform *specs.Platform) (map[string]leakscan.Entry, leakscan.FS, error) {
+	if state.Output() == nil {
+		// Scratch.
+		return nil, nil, nil
+	}
+	ref, err := b.stateToRef(ctx, gwClient, state, platform)
+	if err != nil {
+		return nil, nil, err
+	}
+	fs := refFS{ref: ref}
+	if index, ok := ls.indexes[state.Output()]; ok {
+		return index, fs, nil
+	}
+	index, err := leakscan.Index(ctx, fs)
+	if err != nil {
+		return nil, nil, err
+	}
+	ls.indexes[state.Output()] = index
+	return index, fs, nil
+}
+
+// shortCommand returns the command, shortened for reporting it.
+func shortCommand(command string) string {
+	const maxLen = 60
+	command = strings.Join(strings.Fields(command), " ")
+	if len(command) > maxLen {
+		return command[:maxLen-3] + "..."
+	}
+	return command
+}
+
+// refFS is the filesystem of a reference of the gateway.
+type refFS struct {
+	ref gwclient.Reference
+}
+
+func (fs refFS) ReadDir(ctx context.Context, dir string) ([]leakscan.Entry, error) {
+	stats, err := fs.ref.ReadDir(ctx, gwclient.ReadDirRequest{Path: dir})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]leakscan.Entry, 0, len(stats))
+	for _, st := range stats {
+		entries = append(entries, leakscan.Entry{
+			Name:    path.Base(st.Path),
+			Mode:    os.FileMode(st.Mode),
+			Size:    st.Size_,
+			ModTime: st.ModTime,
+		})
+	}
+	return entries, nil
+}
+
+func (fs refFS) ReadFile(ctx context.Context, p string, limit int) ([]byte, error) {
+	return fs.ref.ReadFile(ctx, gwclient.ReadRequest{
+		Filename: p,
+		Range:    &gwclient.FileRange{Length: limit},
+	})
+}
@@ -0,0 +1,145 @@
+package builder
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/earthfile2llb"
+	"github.com/earthly/earthly/states"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// TargetResult is the outcome of one of the targets run by RunTargets.
+type TargetResult struct {
+	Target   domain.Target
+	Err      error
+	Duration time.Duration
+}
+
+// RunTargets executes the given targets as a single build, without outputting
+// any artifacts or images, and returns the result of each target rather than
+// stopping at the first failure. The targets are converted with a shared
+// cache, and the states which they have in common (such as a base image
+// target used by several services) are solved only once, with their outcome
+// attributed to every target which depends on them.
+func (b *Builder) RunTargets(ctx context.Context, targets []domain.Target, opt BuildOpt) ([]TargetResult, error) {
+	results := make([]TargetResult, len(targets))
+	bf := func(childCtx context.Context, gwClient gwclient.Client) (*gwclient.Result, error) {
+		localStateCache := earthfile2llb.NewSharedLocalStateCache()
+		solves := &sharedSolves{gwClient: gwClient, cacheImports: b.opt.CacheImports, m: make(map[digest.Digest]*sharedSolve)}
+		var wg sync.WaitGroup
+		for i, target := range targets {
+			i, target := i, target
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				start := time.Now()
+				err := b.runTarget(childCtx, gwClient, target, opt, localStateCache, solves)
+				results[i] = TargetResult{Target: target, Err: err, Duration: time.Since(start)}
+			}()
+		}
+		wg.Wait()
+		return gwclient.NewResult(), nil
+	}
+	err := b.s.buildNoExport(ctx, bf, "multi")
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (b *Builder) runTarget(ctx context.Context, gwClient gwclient.Client, target domain.Target, opt BuildOpt, localStateCache *earthfile2llb.LocalStateCache, solves *sharedSolves) error {
+	mts, err := earthfile2llb.Earthfile2LLB(ctx, target, b.newConvertOpt(gwClient, opt, localStateCache), true)
+	if err != nil {
+		return err
+	}
+	var stss []*states.SingleTarget
+	for _, sts := range mts.All() {
+		if sts == mts.Final || sts.HasDangling {
+			stss = append(stss, sts)
+		}
+	}
+	errs := make([]error, len(stss))
+	var wg sync.WaitGroup
+	for i, sts := range stss {
+		i, sts := i, sts
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = solves.solve(ctx, sts, b.opt.NoCache)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sharedSolves evaluates states, solving each distinct state only once.
+type sharedSolves struct {
+	gwClient     gwclient.Client
+	cacheImports *states.CacheImports
+
+	mu sync.Mutex
+	m  map[digest.Digest]*sharedSolve
+}
+
+type sharedSolve struct {
+	done chan struct{}
+	err  error
+}
+
+func (ss *sharedSolves) solve(ctx context.Context, sts *states.SingleTarget, noCache bool) error {
+	state := sts.MainState
+	if noCache {
+		state = state.SetMarshalDefaults(llb.IgnoreCache)
+	}
+	def, err := state.Marshal(ctx, llb.Platform(llbutil.PlatformWithDefault(sts.Platform)))
+	if err != nil {
+		return errors.Wrapf(err, "marshal %s", sts.Target.String())
+	}
+	if len(def.Def) == 0 {
+		return nil
+	}
+	// The last op of a definition is its root, whose digest covers the whole
+	// graph.
+	key := digest.FromBytes(def.Def[len(def.Def)-1])
+	ss.mu.Lock()
+	s, ok := ss.m[key]
+	if !ok {
+		s = &sharedSolve{done: make(chan struct{})}
+		ss.m[key] = s
+	}
+	ss.mu.Unlock()
+	if ok {
+		select {
+		case <-s.done:
+			return s.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	var coes []gwclient.CacheOptionsEntry
+	for ci := range ss.cacheImports.AsMap() {
+		coes = append(coes, gwclient.CacheOptionsEntry{Type: "registry", Attrs: map[string]string{"ref": ci}})
+	}
+	_, s.err = ss.gwClient.Solve(ctx, gwclient.SolveRequest{
+		Definition:   def.ToPB(),
+		CacheImports: coes,
+		Evaluate:     true,
+	})
+	if s.err != nil {
+		s.err = errors.Wrapf(s.err, "build %s", sts.Target.String())
+	}
+	close(s.done)
+	return s.err
+}
@@ -0,0 +1,35 @@
+package builder
+
+import (
+	"path/filepath"
+
+	"github.com/earthly/earthly/domain"
+)
+
+// outputs returns whether the images and artifacts of the target are output
+// locally, as per OutputOnly. All targets are output if OutputOnly is empty.
+func (opt BuildOpt) outputs(target domain.Target) bool {
+	if len(opt.OutputOnly) == 0 {
+		return true
+	}
+	for _, t := range opt.OutputOnly {
+		if sameTarget(t, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameTarget returns whether the two targets refer to the same target, the
+// local ones being compared by the absolute path of their directory.
+func sameTarget(a, b domain.Target) bool {
+	if a.GetName() != b.GetName() {
+		return false
+	}
+	if a.IsRemote() || b.IsRemote() {
+		return a.IsRemote() && b.IsRemote() && a.StringCanonical() == b.StringCanonical()
+	}
+	absA, errA := filepath.Abs(filepath.FromSlash(a.GetLocalPath()))
+	absB, errB := filepath.Abs(filepath.FromSlash(b.GetLocalPath()))
+	return errA == nil && errB == nil && absA == absB
+}
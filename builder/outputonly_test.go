@@ -0,0 +1,26 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestBuildOptOutputs(t *testing.T) {
+	parse := func(s string) domain.Target {
+		target, err := domain.ParseTarget(s)
+		NoError(t, err)
+		return target
+	}
+	True(t, BuildOpt{}.outputs(parse("+all")))
+
+	opt := BuildOpt{OutputOnly: []domain.Target{parse("+image"), parse("./sub+docs"), parse("github.com/org/repo:main+lib")}}
+	True(t, opt.outputs(parse("+image")))
+	True(t, opt.outputs(parse("./+image")))
+	False(t, opt.outputs(parse("+all")))
+	False(t, opt.outputs(parse("./sub+image")))
+	True(t, opt.outputs(parse("./sub/+docs")))
+	True(t, opt.outputs(parse("github.com/org/repo:main+lib")))
+	False(t, opt.outputs(parse("github.com/org/repo:v1+lib")))
+}
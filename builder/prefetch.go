@@ -0,0 +1,134 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/earthfile2llb"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Prefetch converts the given targets and pulls every image and git
+// repository they reference into the buildkit cache, without executing any
+// of their commands. Remote Earthfiles referenced by the targets are fetched
+// as part of the conversion. Image references resolved along the way are
+// recorded into the image index, if one is configured, so that the targets
+// can subsequently be built in offline mode. Targets which cannot be converted
+// without executing some of their commands, such as LOCALLY targets or those
+// using $(...) in an ARG, are skipped with a warning. It returns the sources
+// fetched.
+func (b *Builder) Prefetch(ctx context.Context, targets []domain.Target, opt BuildOpt) ([]string, error) {
+	var fetched []string
+	bf := func(childCtx context.Context, gwClient gwclient.Client) (*gwclient.Result, error) {
+		localStateCache := earthfile2llb.NewSharedLocalStateCache()
+		sources := make(map[string][]byte)
+		for _, target := range targets {
+			convertOpt := b.newConvertOpt(gwClient, opt, localStateCache)
+			convertOpt.NoExecution = true
+			mts, err := earthfile2llb.Earthfile2LLB(childCtx, target, convertOpt, true)
+			if errors.Is(err, earthfile2llb.ErrExecutionRequired) {
+				b.opt.Console.Warnf("Warning: not prefetching %s, as it cannot be converted without executing commands: %s\n", target.String(), err.Error())
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, sts := range mts.All() {
+				platform := llbutil.PlatformWithDefault(sts.Platform)
+				for _, state := range []pllb.State{sts.MainState, sts.ArtifactsState} {
+					def, err := state.Marshal(childCtx, llb.Platform(platform))
+					if err != nil {
+						return nil, errors.Wrapf(err, "marshal %s", sts.Target.String())
+					}
+					err = collectPrefetchSources(def, sources)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+
+		var mu sync.Mutex
+		eg, egCtx := errgroup.WithContext(childCtx)
+		for name, opDt := range sources {
+			name, opDt := name, opDt
+			eg.Go(func() error {
+				def, err := sourceDefinition(opDt)
+				if err != nil {
+					return err
+				}
+				_, err = gwClient.Solve(egCtx, gwclient.SolveRequest{
+					Definition: def,
+					Evaluate:   true,
+				})
+				if err != nil {
+					return errors.Wrapf(err, "prefetch %s", name)
+				}
+				mu.Lock()
+				fetched = append(fetched, name)
+				mu.Unlock()
+				return nil
+			})
+		}
+		err := eg.Wait()
+		if err != nil {
+			return nil, err
+		}
+		return gwclient.NewResult(), nil
+	}
+	err := b.s.buildNoExport(ctx, bf, "prefetch")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(fetched)
+	return fetched, nil
+}
+
+// collectPrefetchSources adds the image and git source ops of the given
+// definition to sources, keyed by a human readable name.
+func collectPrefetchSources(def *llb.Definition, sources map[string][]byte) error {
+	for _, dt := range def.Def {
+		var op pb.Op
+		err := op.Unmarshal(dt)
+		if err != nil {
+			return errors.Wrap(err, "unmarshal llb op")
+		}
+		src := op.GetSource()
+		if src == nil {
+			continue
+		}
+		if !strings.HasPrefix(src.Identifier, "docker-image://") && !strings.HasPrefix(src.Identifier, "git://") {
+			continue
+		}
+		name := src.Identifier
+		if op.Platform != nil {
+			name = fmt.Sprintf("%s (%s/%s)", name, op.Platform.OS, op.Platform.Architecture)
+		}
+		sources[name] = dt
+	}
+	return nil
+}
+
+// sourceDefinition wraps a single marshalled source op into a definition
+// which can be solved on its own.
+func sourceDefinition(opDt []byte) (*pb.Definition, error) {
+	final := pb.Op{
+		Inputs: []*pb.Input{{Digest: digest.FromBytes(opDt), Index: 0}},
+	}
+	finalDt, err := final.Marshal()
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal llb op")
+	}
+	return &pb.Definition{Def: [][]byte{opDt, finalDt}}, nil
+}
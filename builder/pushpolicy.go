@@ -0,0 +1,102 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+)
+
+// PushPolicy guards the push phase of a build. Pushes are verified before
+// any of them takes place, and a rollback hook is notified if the push phase
+// fails part way.
+type PushPolicy struct {
+	// ProtectedTags are patterns of image tags (e.g. *:latest, or
+	// registry.example.com/prod/*) which earthly refuses to push. A * matches
+	// any sequence of characters.
+	ProtectedTags []string
+	// RollbackHook is a command executed via sh -c if the push phase fails
+	// after pushing may have started. The images and commands involved are
+	// passed to it as JSON via stdin.
+	RollbackHook string
+}
+
+// PushRollback is passed to the rollback hook.
+type PushRollback struct {
+	Target string `json:"target"`
+	// Images are the tags which may have been pushed.
+	Images []string `json:"images"`
+	// Commands are the RUN --push commands which may have been executed.
+	Commands []string `json:"commands,omitempty"`
+	Error    string   `json:"error"`
+}
+
+// verify returns an error if any of the tags about to be pushed is protected.
+func (p PushPolicy) verify(tags []string) error {
+	var protected []string
+	for _, tag := range tags {
+		for _, pattern := range p.ProtectedTags {
+			if matchTagPattern(pattern, tag) {
+				protected = append(protected, tag)
+				break
+			}
+		}
+	}
+	if len(protected) != 0 {
+		return errors.Errorf("refusing to push protected tags %s; nothing has been pushed", strings.Join(protected, ", "))
+	}
+	return nil
+}
+
+// rollback runs the rollback hook, if any.
+func (p PushPolicy) rollback(ctx context.Context, rb PushRollback) error {
+	if p.RollbackHook == "" || (len(rb.Images) == 0 && len(rb.Commands) == 0) {
+		return nil
+	}
+	dt, err := json.Marshal(rb)
+	if err != nil {
+		return errors.Wrap(err, "marshal push rollback")
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", p.RollbackHook)
+	cmd.Stdin = bytes.NewReader(dt)
+	cmd.Env = append(os.Environ(), "EARTHLY_PUSH_ROLLBACK=true")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "push rollback hook: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// matchTagPattern returns true if the image tag matches the pattern. Both are
+// compared in their familiar form, with an implicit :latest tag made
+// explicit, so that alpine, alpine:latest and docker.io/library/alpine are
+// considered the same.
+func matchTagPattern(pattern, tag string) bool {
+	parts := strings.Split(normalizeTag(pattern), "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(normalizeTag(tag))
+}
+
+func normalizeTag(tag string) string {
+	if strings.Contains(tag, "*") {
+		// Patterns are not valid references; only strip the default registry.
+		return strings.TrimPrefix(strings.TrimPrefix(tag, "docker.io/"), "library/")
+	}
+	named, err := reference.ParseNormalizedNamed(tag)
+	if err != nil {
+		return tag
+	}
+	return reference.FamiliarString(reference.TagNameOnly(named))
+}
@@ -0,0 +1,32 @@
+package builder
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestMatchTagPattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern  string
+		tag      string
+		expected bool
+	}{
+		{"*:latest", "alpine", true},
+		{"*:latest", "ghcr.io/org/app:latest", true},
+		{"*:latest", "ghcr.io/org/app:v1", false},
+		{"alpine", "docker.io/library/alpine:latest", true},
+		{"registry.example.com/prod/*", "registry.example.com/prod/app:v1", true},
+		{"registry.example.com/prod/*", "registry.example.com/dev/app:v1", false},
+		{"*/app:v*", "ghcr.io/org/app:v1.2", true},
+	} {
+		Equal(t, tc.expected, matchTagPattern(tc.pattern, tc.tag), tc.pattern+" "+tc.tag)
+	}
+}
+
+func TestPushPolicyVerify(t *testing.T) {
+	p := PushPolicy{ProtectedTags: []string{"*:latest"}}
+	NoError(t, p.verify([]string{"ghcr.io/org/app:v1"}))
+	Error(t, p.verify([]string{"ghcr.io/org/app:v1", "ghcr.io/org/app"}))
+	NoError(t, PushPolicy{}.verify([]string{"ghcr.io/org/app"}))
+}
@@ -37,6 +37,7 @@ type solver struct {
 	cacheExport     string
 	maxCacheExport  string
 	saveInlineCache bool
+	compression     Compression
 }
 
 func (s *solver) solveDockerTar(ctx context.Context, state pllb.State, platform specs.Platform, img *image.Image, dockerTag string, outFile string) error {
@@ -98,7 +99,7 @@ func (s *solver) solveDockerTar(ctx context.Context, state pllb.State, platform
 	}()
 	err = eg.Wait()
 	if err != nil {
-		return NewBuildError(err, vertexFailureOutput)
+		return s.buildError(err, vertexFailureOutput)
 	}
 	return nil
 }
@@ -128,7 +129,37 @@ func (s *solver) buildMainMulti(ctx context.Context, bf gwclient.BuildFunc, onIm
 	})
 	err = eg.Wait()
 	if err != nil {
-		return NewBuildError(err, vertexFailureOutput)
+		return s.buildError(err, vertexFailureOutput)
+	}
+	return nil
+}
+
+func (s *solver) buildNoExport(ctx context.Context, bf gwclient.BuildFunc, phaseText string) error {
+	solveOpt, err := s.newSolveOptMain()
+	if err != nil {
+		return errors.Wrap(err, "new solve opt")
+	}
+	ch := make(chan *client.SolveStatus)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		var err error
+		_, err = s.bkClient.Build(ctx, *solveOpt, "", bf, ch)
+		if err != nil {
+			return errors.Wrap(err, "bkClient.Build")
+		}
+		return nil
+	})
+	var vertexFailureOutput string
+	eg.Go(func() error {
+		var err error
+		vertexFailureOutput, err = s.sm.monitorProgress(ctx, ch, phaseText, false)
+		return err
+	})
+	err = eg.Wait()
+	if err != nil {
+		return s.buildError(err, vertexFailureOutput)
 	}
 	return nil
 }
@@ -162,11 +193,18 @@ func (s *solver) solveMain(ctx context.Context, state pllb.State, platform specs
 	})
 	err = eg.Wait()
 	if err != nil {
-		return NewBuildError(err, vertexFailureOutput)
+		return s.buildError(err, vertexFailureOutput)
 	}
 	return nil
 }
 
+// buildError wraps the error of a build with the output, digest and name of
+// the vertex which failed, if any.
+func (s *solver) buildError(err error, vertexFailureOutput string) error {
+	digest, name := s.sm.failedVertex()
+	return NewBuildError(err, vertexFailureOutput, digest, name)
+}
+
 func (s *solver) newSolveOptDocker(img *image.Image, dockerTag string, w io.WriteCloser) (*client.SolveOpt, error) {
 	imgJSON, err := json.Marshal(img)
 	if err != nil {
@@ -214,7 +252,7 @@ func (s *solver) newSolveOptMulti(ctx context.Context, eg *errgroup.Group, onIma
 		Exports: []client.ExportEntry{
 			{
 				Type:  client.ExporterEarthly,
-				Attrs: map[string]string{},
+				Attrs: s.compression.exporterAttrs(),
 				Output: func(md map[string]string) (io.WriteCloser, error) {
 					if md["export-image"] != "true" {
 						return nil, nil
@@ -49,6 +49,8 @@ type vertexMonitor struct {
 	openLine            []byte
 	lastOpenLineUpdate  time.Time
 	lastOpenLineSkipped bool
+	// Line of output that has not yet been scanned for test reports.
+	partialLine []byte
 }
 
 func (vm *vertexMonitor) printHeader() {
@@ -223,6 +225,8 @@ type solverMonitor struct {
 	startTime                   time.Time
 	noOutputTicker              *time.Ticker
 	noOutputTick                time.Duration
+	testReports                 []TestReport
+	annotations                 []Annotation
 	errVertex                   *vertexMonitor
 
 	mu             sync.Mutex
@@ -304,6 +308,16 @@ Loop:
 	return failedVertexOutput, nil
 }
 
+// failedVertex returns the digest and name of the vertex which failed, if any.
+func (sm *solverMonitor) failedVertex() (string, string) {
+	sm.msgMu.Lock()
+	defer sm.msgMu.Unlock()
+	if sm.errVertex == nil || sm.errVertex.vertex == nil {
+		return "", ""
+	}
+	return sm.errVertex.vertex.Digest.String(), sm.errVertex.vertex.Name
+}
+
 func (sm *solverMonitor) processStatus(ss *client.SolveStatus) error {
 	sm.msgMu.Lock()
 	defer sm.msgMu.Unlock()
@@ -379,6 +393,7 @@ func (sm *solverMonitor) processStatus(ss *client.SolveStatus) error {
 		if !vm.headerPrinted {
 			sm.printHeader(vm)
 		}
+		sm.collectReports(vm, logLine.Data)
 		err := sm.printOutput(vm, logLine.Data)
 		if err != nil {
 			return err
@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/earthly/earthly/debugger/common"
+)
+
+// maxPartialLineSize is the size above which an unterminated line of output is
+// no longer considered as a possible test report.
+const maxPartialLineSize = 64 * 1024
+
+// TestReport is the outcome of the failed tests of a RUN --junit command, as
+// reported by the debugger in its output.
+type TestReport struct {
+	// Target is the target of the command.
+	Target string `json:"target"`
+	// Command is the command, as in RUN --junit=report.xml go test.
+	Command string `json:"command"`
+	common.TestReport
+}
+
+// TestReports returns the test reports of the commands run so far, in the
+// order they completed.
+func (b *Builder) TestReports() []TestReport {
+	sm := b.s.sm
+	sm.msgMu.Lock()
+	defer sm.msgMu.Unlock()
+	return append([]TestReport(nil), sm.testReports...)
+}
+
+// collectReports collects the test reports and the annotations of the output
+// of the vertex, which may end with a partial line, completed by its next
+// output.
+func (sm *solverMonitor) collectReports(vm *vertexMonitor, output []byte) {
+	dt := output
+	if len(vm.partialLine) != 0 {
+		dt = append(vm.partialLine, output...)
+	}
+	for {
+		nl := bytes.IndexByte(dt, '\n')
+		if nl == -1 {
+			break
+		}
+		report, ok := parseTestReport(dt[:nl])
+		if ok {
+			sm.testReports = append(sm.testReports, TestReport{
+				Target:     vm.targetStr,
+				Command:    vm.operation,
+				TestReport: report,
+			})
+		}
+		text, ok := parseAnnotation(dt[:nl])
+		if ok {
+			sm.annotations = append(sm.annotations, Annotation{
+				Target:  vm.targetStr,
+				Command: vm.operation,
+				Text:    text,
+			})
+		}
+		dt = dt[nl+1:]
+	}
+	if len(dt) == 0 || len(dt) > maxPartialLineSize {
+		vm.partialLine = nil
+		return
+	}
+	vm.partialLine = append([]byte(nil), dt...)
+}
+
+// parseTestReport parses a line of output of the debugger which reports the
+// outcome of the tests of a command.
+func parseTestReport(line []byte) (common.TestReport, bool) {
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte(common.TestReportPrefix)) {
+		return common.TestReport{}, false
+	}
+	var report common.TestReport
+	err := json.Unmarshal(line[len(common.TestReportPrefix):], &report)
+	if err != nil {
+		return common.TestReport{}, false
+	}
+	return report, true
+}
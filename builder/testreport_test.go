@@ -0,0 +1,39 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/debugger/common"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestCollectReports(t *testing.T) {
+	sm := &solverMonitor{}
+	vm := &vertexMonitor{targetStr: "+test", operation: "RUN --junit=report.xml go test"}
+	sm.collectReports(vm, []byte("ok\nearthly-test-report: {\"flaky\":[\"p.TestB\"],"))
+	Equal(t, 0, len(sm.testReports))
+	sm.collectReports(vm, []byte("\"attempts\":2}\r\nearthly-test-report: not json\ndone"))
+	Equal(t, []TestReport{{
+		Target:     "+test",
+		Command:    "RUN --junit=report.xml go test",
+		TestReport: common.TestReport{Flaky: []string{"p.TestB"}, Attempts: 2},
+	}}, sm.testReports)
+	Equal(t, "done", string(vm.partialLine))
+
+	sm.collectReports(vm, []byte("\nearthly-annotation: drift: infra (prod): Plan: 1 to add, 0 to change, 0 to destroy.\n"))
+	Equal(t, []Annotation{{
+		Target:  "+test",
+		Command: "RUN --junit=report.xml go test",
+		Text:    "drift: infra (prod): Plan: 1 to add, 0 to change, 0 to destroy.",
+	}}, sm.annotations)
+}
+
+func TestParseTestReport(t *testing.T) {
+	report, ok := parseTestReport([]byte(`earthly-test-report: {"failed":["TestC"],"quarantined":["TestD"],"attempts":3}`))
+	True(t, ok)
+	Equal(t, common.TestReport{Failed: []string{"TestC"}, Quarantined: []string{"TestD"}, Attempts: 3}, report)
+
+	_, ok = parseTestReport([]byte(`go test: earthly-test-report: {}`))
+	False(t, ok)
+}
@@ -0,0 +1,123 @@
+package buildkitd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/pkg/errors"
+)
+
+// BinfmtImage is the image used to inspect and install the binfmt_misc
+// handlers needed to emulate foreign platforms.
+var BinfmtImage = "tonistiigi/binfmt:qemu-v6.0.0"
+
+// binfmtSmokeTestImage is a small multi-platform image used to verify that
+// emulation works once the handlers are registered.
+const binfmtSmokeTestImage = "busybox:1.33"
+
+// ErrRootlessBinfmt is returned when binfmt_misc handlers are missing, but cannot be
+// installed because the docker daemon runs in rootless mode.
+var ErrRootlessBinfmt = errors.New("cannot install binfmt_misc handlers via a rootless docker daemon")
+
+type binfmtStatus struct {
+	Supported []string `json:"supported"`
+	Emulators []string `json:"emulators"`
+}
+
+// MissingEmulators returns the platforms, out of the ones provided, which the
+// docker host can neither execute natively nor via a registered binfmt_misc
+// handler.
+func MissingEmulators(ctx context.Context, platformStrs []string) ([]string, error) {
+	status, err := getBinfmtStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	supported := make(map[string]bool)
+	for _, s := range status.Supported {
+		p, err := platforms.Parse(s)
+		if err != nil {
+			continue
+		}
+		supported[platforms.Format(platforms.Normalize(p))] = true
+	}
+	var missing []string
+	for _, s := range platformStrs {
+		p, err := platforms.Parse(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse platform %s", s)
+		}
+		if !supported[platforms.Format(platforms.Normalize(p))] {
+			missing = append(missing, s)
+		}
+	}
+	return missing, nil
+}
+
+// InstallEmulators registers the qemu-user-static binfmt_misc handlers for the
+// given platforms. The handlers are registered in the kernel of the docker
+// host, and are therefore visible to the buildkit container too.
+func InstallEmulators(ctx context.Context, platformStrs []string) error {
+	rootless, err := isRootlessDocker(ctx)
+	if err != nil {
+		return err
+	}
+	if rootless {
+		return ErrRootlessBinfmt
+	}
+	arches := make([]string, 0, len(platformStrs))
+	for _, s := range platformStrs {
+		p, err := platforms.Parse(s)
+		if err != nil {
+			return errors.Wrapf(err, "parse platform %s", s)
+		}
+		arches = append(arches, platforms.Normalize(p).Architecture)
+	}
+	cmd := exec.CommandContext(ctx,
+		"docker", "run", "--rm", "--privileged", BinfmtImage,
+		"--install", strings.Join(arches, ","))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "install binfmt_misc handlers: %s", string(output))
+	}
+	return nil
+}
+
+// VerifyEmulator runs a small container for the given platform, to check that
+// it can actually be executed. It returns the machine hardware name reported
+// by the container.
+func VerifyEmulator(ctx context.Context, platformStr string) (string, error) {
+	cmd := exec.CommandContext(ctx,
+		"docker", "run", "--rm", fmt.Sprintf("--platform=%s", platformStr),
+		binfmtSmokeTestImage, "uname", "-m")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "run %s container: %s", platformStr, strings.TrimSpace(string(output)))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// RootlessBinfmtInstructions returns instructions for installing binfmt_misc
+// handlers on hosts where they cannot be installed via docker.
+func RootlessBinfmtInstructions() string {
+	return "Rootless docker cannot register binfmt_misc handlers. Ask an administrator to run\n" +
+		"\tsudo docker run --rm --privileged " + BinfmtImage + " --install all\n" +
+		"or install the qemu-user-static and binfmt-support packages provided by your distribution.\n"
+}
+
+func getBinfmtStatus(ctx context.Context) (*binfmtStatus, error) {
+	cmd := exec.CommandContext(ctx, "docker", "run", "--rm", "--privileged", BinfmtImage)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "get binfmt_misc status")
+	}
+	var status binfmtStatus
+	err = json.Unmarshal(output, &status)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse binfmt_misc status %s", string(output))
+	}
+	return &status, nil
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/util/cliutil"
 	"github.com/earthly/earthly/util/fileutil"
+	"github.com/earthly/earthly/util/wslutil"
 	"github.com/moby/buildkit/client"
 	_ "github.com/moby/buildkit/client/connhelper/dockercontainer" // Load "docker-container://" helper.
 	"github.com/pkg/errors"
@@ -53,6 +54,11 @@ func NewClient(ctx context.Context, console conslogging.ConsoleLogger, image, co
 		if err != nil {
 			return nil, errors.Wrap(err, "start provided buildkit")
 		}
+		err = checkVersionSkew(ctx, console, bkClient, settings.BuildkitAddress, settings.ClientVersion)
+		if err != nil {
+			bkClient.Close()
+			return nil, err
+		}
 
 		return bkClient, nil
 	}
@@ -69,6 +75,11 @@ func NewClient(ctx context.Context, console conslogging.ConsoleLogger, image, co
 	if err != nil {
 		return nil, errors.Wrap(err, "new buildkit client")
 	}
+	err = checkVersionSkew(ctx, console, bkClient, address, settings.ClientVersion)
+	if err != nil {
+		bkClient.Close()
+		return nil, err
+	}
 	return bkClient, nil
 }
 
@@ -169,6 +180,22 @@ func MaybeRestart(ctx context.Context, console conslogging.ConsoleLogger, image,
 		availableImageID = "" // Will cause equality to fail and force a restart.
 		// Keep going anyway.
 	}
+	hostPlatform := HostPlatform(ctx)
+	imagePlatform, err := getImagePlatform(ctx, image)
+	if err == nil && imagePlatform != hostPlatform && supportsPlatform(ctx, hostPlatform) {
+		// The image runs via emulation (e.g. it was pulled by an amd64 earthly
+		// running under Rosetta). Pull the native variant, if there is one.
+		console.
+			WithPrefix("buildkitd").
+			Printf("Image runs as %s via emulation, on a %s host\n", imagePlatform, hostPlatform)
+		err = MaybePull(ctx, console, image)
+		if err == nil {
+			availableImageID, err = GetAvailableImageID(ctx, image)
+			if err != nil {
+				availableImageID = ""
+			}
+		}
+	}
 	console.
 		WithPrefix("buildkitd").
 		VerbosePrintf("Comparing running container image (%q) with available image (%q)\n", containerImageID, availableImageID)
@@ -314,7 +341,7 @@ func Start(ctx context.Context, console conslogging.ConsoleLogger, image, contai
 					if err != nil {
 						return errors.Wrap(err, "start buildkitd")
 					}
-					args = append(args, "-v", fmt.Sprintf("%s:/etc/ca.pem", caPath))
+					args = append(args, "-v", fmt.Sprintf("%s:/etc/ca.pem", daemonPath(ctx, caPath)))
 				}
 
 				if settings.ServerTLSCert != "" {
@@ -322,7 +349,7 @@ func Start(ctx context.Context, console conslogging.ConsoleLogger, image, contai
 					if err != nil {
 						return errors.Wrap(err, "start buildkitd")
 					}
-					args = append(args, "-v", fmt.Sprintf("%s:/etc/cert.pem", certPath))
+					args = append(args, "-v", fmt.Sprintf("%s:/etc/cert.pem", daemonPath(ctx, certPath)))
 				}
 
 				if settings.ServerTLSKey != "" {
@@ -330,14 +357,15 @@ func Start(ctx context.Context, console conslogging.ConsoleLogger, image, contai
 					if err != nil {
 						return errors.Wrap(err, "start buildkitd")
 					}
-					args = append(args, "-v", fmt.Sprintf("%s:/etc/key.pem", keyPath))
+					args = append(args, "-v", fmt.Sprintf("%s:/etc/key.pem", daemonPath(ctx, keyPath)))
 				}
 			}
 		}
 	}
 
-	if supportsPlatform(ctx) {
-		args = append(args, platformFlag())
+	platform := HostPlatform(ctx)
+	if supportsPlatform(ctx, platform) {
+		args = append(args, fmt.Sprintf("--platform=%s", platform))
 	}
 
 	if settings.CniMtu > 0 {
@@ -352,6 +380,15 @@ func Start(ctx context.Context, console conslogging.ConsoleLogger, image, contai
 		args = append(args, "-e", fmt.Sprintf("GIT_URL_INSTEAD_OF=%s", settings.GitURLInsteadOf))
 	}
 
+	if settings.GPUs != "" {
+		// The NVIDIA Container Toolkit only injects the libraries of the
+		// driver which are needed for the given capabilities.
+		args = append(args,
+			"--gpus", settings.GPUs,
+			"-e", "NVIDIA_DRIVER_CAPABILITIES=compute,utility",
+			"-e", "EARTHLY_GPUS=true")
+	}
+
 	// Apply reset.
 	if reset {
 		args = append(args, "-e", "EARTHLY_RESET_TMP_DIR=true")
@@ -518,18 +555,19 @@ func checkConnection(ctx context.Context, address string, opts ...client.ClientO
 
 // MaybePull checks whether an image is available locally and pulls it if it is not.
 func MaybePull(ctx context.Context, console conslogging.ConsoleLogger, image string) error {
-	cmd := exec.CommandContext(ctx, "docker", "image", "inspect", image)
-	_, err := cmd.CombinedOutput()
-	if err == nil {
+	platform := HostPlatform(ctx)
+	withPlatform := supportsPlatform(ctx, platform)
+	imagePlatform, err := getImagePlatform(ctx, image)
+	if err == nil && (!withPlatform || imagePlatform == platform) {
 		// We found the image locally - no need to pull.
 		return nil
 	}
 	args := []string{"pull"}
-	if supportsPlatform(ctx) {
-		args = append(args, platformFlag())
+	if withPlatform {
+		args = append(args, fmt.Sprintf("--platform=%s", platform))
 	}
 	args = append(args, image)
-	cmd = exec.CommandContext(ctx, "docker", args...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
 	console.
 		WithPrefix("buildkitd-pull").
 		Printf("Pulling buildkitd image...\n")
@@ -678,24 +716,16 @@ func isRootlessDocker(ctx context.Context) (bool, error) {
 	return strings.Contains(string(output), "rootless"), nil
 }
 
-func supportsPlatform(ctx context.Context) bool {
+func supportsPlatform(ctx context.Context, platform string) bool {
 	// We can't run scratch, but the error is different depending on whether
 	// --platform is supported or not. This is faster than attempting to run
 	// an actual image which may require downloading.
 	cmd := exec.CommandContext(ctx,
-		"docker", "run", "--rm", platformFlag(), "scratch")
+		"docker", "run", "--rm", fmt.Sprintf("--platform=%s", platform), "scratch")
 	output, _ := cmd.CombinedOutput()
 	return bytes.Contains(output, []byte("Unable to find image"))
 }
 
-func platformFlag() string {
-	arch := runtime.GOARCH
-	if runtime.GOARCH == "arm" {
-		arch = "arm/v7"
-	}
-	return fmt.Sprintf("--platform=linux/%s", arch)
-}
-
 func isContainerRunning(ctx context.Context, containerName string) (bool, error) {
 	cmd := exec.CommandContext(
 		ctx, "docker", "inspect", "--format={{.State.Running}}", containerName)
@@ -777,6 +807,16 @@ func makeTLSPath(path string) (string, error) {
 	return fullPath, nil
 }
 
+// daemonPath returns the path of a file of the host, as seen by the docker
+// daemon for bind mounts. Windows files are seen via /mnt/<drive> by a docker
+// daemon running in a WSL2 distribution, without Docker Desktop.
+func daemonPath(ctx context.Context, path string) string {
+	if runtime.GOOS == "windows" && wslutil.DaemonIsWSL(ctx) {
+		path, _ = wslutil.ToWSLPath(path)
+	}
+	return path
+}
+
 func addRequiredOpts(settings Settings, opts ...client.ClientOpt) ([]client.ClientOpt, error) {
 	if !settings.UseTCP || !settings.UseTLS {
 		return opts, nil
@@ -0,0 +1,169 @@
+package buildkitd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/earthly/earthly/conslogging"
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+)
+
+// The labels earthly/buildkitd advertises on its workers (see
+// buildkitd.toml.template), which describe the version of earthly it was built
+// with and what it supports.
+const (
+	// VersionLabel is the version of earthly the buildkitd image was built with.
+	VersionLabel = "dev.earthly.version"
+	// ProtocolLabel is the protocol version spoken by buildkitd.
+	ProtocolLabel = "dev.earthly.protocol"
+	// MinClientProtocolLabel is the oldest protocol version of the earthly CLI
+	// that buildkitd still supports.
+	MinClientProtocolLabel = "dev.earthly.min-client-protocol"
+	// CapabilitiesLabel is the comma-separated list of optional capabilities
+	// of buildkitd.
+	CapabilitiesLabel = "dev.earthly.capabilities"
+)
+
+const (
+	// ProtocolVersion is the protocol version spoken by this earthly CLI. It is
+	// bumped whenever the CLI starts relying on a change of earthly/buildkitd
+	// that older daemons do not have, and must match the protocol advertised in
+	// buildkitd.toml.template.
+	ProtocolVersion = 1
+	// MinProtocolVersion is the oldest protocol version of buildkitd that this
+	// earthly CLI still supports.
+	MinProtocolVersion = 1
+)
+
+// The optional capabilities of buildkitd.
+const (
+	// CapInteractiveDebugger is the capability of running the interactive
+	// debugger, which requires the earth_debugger binary in the buildkitd
+	// image.
+	CapInteractiveDebugger = "interactive-debugger"
+	// CapCompressionZstd is the capability of exporting images with zstd
+	// compressed layers.
+	CapCompressionZstd = "compression-zstd"
+	// CapCompressionEstargz is the capability of exporting images with
+	// estargz layers, which can be lazily pulled.
+	CapCompressionEstargz = "compression-estargz"
+)
+
+// Info describes a buildkitd daemon, as advertised by its worker labels.
+type Info struct {
+	// Known is false if the daemon does not advertise any earthly labels, such
+	// as a daemon which predates them, or one which is not earthly/buildkitd.
+	Known             bool
+	Version           string
+	Protocol          int
+	MinClientProtocol int
+	Capabilities      map[string]bool
+}
+
+// ParseInfo parses the labels of a buildkitd worker.
+func ParseInfo(labels map[string]string) (Info, error) {
+	info := Info{Capabilities: make(map[string]bool)}
+	protocol, ok := labels[ProtocolLabel]
+	if !ok {
+		return info, nil
+	}
+	info.Known = true
+	info.Version = labels[VersionLabel]
+	var err error
+	info.Protocol, err = strconv.Atoi(protocol)
+	if err != nil {
+		return Info{}, errors.Wrapf(err, "parse label %s", ProtocolLabel)
+	}
+	info.MinClientProtocol = info.Protocol
+	if minClient, ok := labels[MinClientProtocolLabel]; ok {
+		info.MinClientProtocol, err = strconv.Atoi(minClient)
+		if err != nil {
+			return Info{}, errors.Wrapf(err, "parse label %s", MinClientProtocolLabel)
+		}
+	}
+	for _, c := range strings.Split(labels[CapabilitiesLabel], ",") {
+		c = strings.TrimSpace(c)
+		if c != "" {
+			info.Capabilities[c] = true
+		}
+	}
+	return info, nil
+}
+
+// GetInfo returns the info of the buildkitd daemon, as advertised by its
+// workers.
+func GetInfo(ctx context.Context, bkClient *client.Client) (Info, error) {
+	workers, err := bkClient.ListWorkers(ctx)
+	if err != nil {
+		return Info{}, errors.Wrap(err, "list buildkitd workers")
+	}
+	for _, w := range workers {
+		info, err := ParseInfo(w.Labels)
+		if err != nil {
+			return Info{}, errors.Wrapf(err, "worker %s", w.ID)
+		}
+		if info.Known {
+			return info, nil
+		}
+	}
+	return Info{Capabilities: make(map[string]bool)}, nil
+}
+
+// Check returns an error if the daemon cannot be used by this earthly CLI, of
+// the given version, explaining which side needs to be upgraded.
+func (info Info) Check(address, clientVersion string) error {
+	if !info.Known {
+		return nil
+	}
+	if info.Protocol < MinProtocolVersion {
+		return errors.Errorf(
+			"buildkitd at %s (earthly %s, protocol %d) is too old for earthly %s, which requires protocol %d or newer: upgrade the buildkitd to earthly/buildkitd:%s, or use earthly %s",
+			address, info.Version, info.Protocol, clientVersion, MinProtocolVersion, clientVersion, info.Version)
+	}
+	if ProtocolVersion < info.MinClientProtocol {
+		return errors.Errorf(
+			"earthly %s (protocol %d) is too old for buildkitd at %s (earthly %s), which requires protocol %d or newer: upgrade earthly to %s or newer",
+			clientVersion, ProtocolVersion, address, info.Version, info.MinClientProtocol, info.Version)
+	}
+	return nil
+}
+
+// Has returns whether the daemon has the capability. Daemons which do not
+// advertise their capabilities are assumed to have all of them.
+func (info Info) Has(capability string) bool {
+	return !info.Known || info.Capabilities[capability]
+}
+
+// String returns a short description of the daemon, for logs.
+func (info Info) String() string {
+	if !info.Known {
+		return "unknown version"
+	}
+	return fmt.Sprintf("earthly %s, protocol %d", info.Version, info.Protocol)
+}
+
+// checkVersionSkew fails early if the daemon the client is connected to is
+// incompatible with this earthly CLI, rather than letting the build fail later
+// with gRPC errors.
+func checkVersionSkew(ctx context.Context, console conslogging.ConsoleLogger, bkClient *client.Client, address, clientVersion string) error {
+	info, err := GetInfo(ctx, bkClient)
+	if err != nil {
+		return err
+	}
+	console.
+		WithPrefix("buildkitd").
+		VerbosePrintf("Connected to buildkitd at %s (%s)\n", address, info)
+	err = info.Check(address, clientVersion)
+	if err != nil {
+		return err
+	}
+	if info.Known && info.Version != clientVersion && !IsLocal(address) {
+		console.
+			WithPrefix("buildkitd").
+			Printf("Note: buildkitd at %s runs earthly %s, while this is earthly %s\n", address, info.Version, clientVersion)
+	}
+	return nil
+}
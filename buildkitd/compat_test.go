@@ -0,0 +1,53 @@
+package buildkitd
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseInfo(t *testing.T) {
+	info, err := ParseInfo(map[string]string{"org.mobyproject.buildkit.worker.executor": "oci"})
+	NoError(t, err)
+	False(t, info.Known)
+	True(t, info.Has(CapInteractiveDebugger))
+	NoError(t, info.Check("tcp://buildkit:8372", "v0.6.0"))
+
+	info, err = ParseInfo(map[string]string{
+		VersionLabel:      "v0.6.0",
+		ProtocolLabel:     "1",
+		CapabilitiesLabel: "foo, bar",
+	})
+	NoError(t, err)
+	True(t, info.Known)
+	Equal(t, "v0.6.0", info.Version)
+	Equal(t, 1, info.MinClientProtocol)
+	True(t, info.Has("bar"))
+	False(t, info.Has(CapInteractiveDebugger))
+	Equal(t, "earthly v0.6.0, protocol 1", info.String())
+
+	_, err = ParseInfo(map[string]string{ProtocolLabel: "one"})
+	Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		info     Info
+		expected string
+	}{
+		{Info{Known: true, Version: "v0.6.0", Protocol: ProtocolVersion, MinClientProtocol: ProtocolVersion}, ""},
+		{Info{Known: true, Version: "v0.5.0", Protocol: MinProtocolVersion - 1, MinClientProtocol: MinProtocolVersion - 1},
+			"buildkitd at tcp://buildkit:8372 (earthly v0.5.0, protocol 0) is too old for earthly v0.6.0, which requires protocol 1 or newer: upgrade the buildkitd to earthly/buildkitd:v0.6.0, or use earthly v0.5.0"},
+		{Info{Known: true, Version: "v0.9.0", Protocol: ProtocolVersion + 1, MinClientProtocol: ProtocolVersion + 1},
+			"earthly v0.6.0 (protocol 1) is too old for buildkitd at tcp://buildkit:8372 (earthly v0.9.0), which requires protocol 2 or newer: upgrade earthly to v0.9.0 or newer"},
+		{Info{Known: true, Version: "v0.9.0", Protocol: ProtocolVersion + 1, MinClientProtocol: ProtocolVersion}, ""},
+	}
+	for _, tt := range tests {
+		err := tt.info.Check("tcp://buildkit:8372", "v0.6.0")
+		if tt.expected == "" {
+			NoError(t, err)
+		} else {
+			EqualError(t, err, tt.expected)
+		}
+	}
+}
@@ -25,11 +25,23 @@ execute() {
     fi
 
     start_dockerd
+    if [ "${EARTHLY_STREAM_LOGS:-}" = "true" ]; then
+        stream_logs
+    fi
+    create_volumes
+    restore_containers
     load_images
     if [ "$EARTHLY_START_COMPOSE" = "true" ]; then
         # shellcheck disable=SC2086
         docker_compose_cmd up -d $EARTHLY_COMPOSE_SERVICES
     fi
+    if ! await_services; then
+        if [ "$EARTHLY_START_COMPOSE" = "true" ]; then
+            docker_compose_cmd down --remove-orphans
+        fi
+        stop_dockerd
+        exit 1
+    fi
 
     shift
     export EARTHLY_WITH_DOCKER=1
@@ -41,10 +53,108 @@ execute() {
     if [ "$EARTHLY_START_COMPOSE" = "true" ]; then
         docker_compose_cmd down --remove-orphans
     fi
+    if [ -n "${stream_logs_pid:-}" ]; then
+        kill "$stream_logs_pid" >/dev/null 2>&1 || true
+    fi
     stop_dockerd
     return "$exit_code"
 }
 
+# Runs the setup command of WITH DOCKER --setup, and snapshots the resulting
+# state of dockerd into $EARTHLY_DOCKERD_SNAPSHOT, along with the list of the
+# containers running by then, to be restored by execute.
+snapshot() {
+    start_dockerd
+    load_images
+    if [ "$EARTHLY_START_COMPOSE" = "true" ]; then
+        # shellcheck disable=SC2086
+        docker_compose_cmd up -d $EARTHLY_COMPOSE_SERVICES
+    fi
+    if ! await_services; then
+        stop_dockerd
+        exit 1
+    fi
+
+    shift
+    export EARTHLY_WITH_DOCKER=1
+    set +e
+    "$@"
+    exit_code="$?"
+    set -e
+    if [ "$exit_code" != "0" ]; then
+        stop_dockerd
+        return "$exit_code"
+    fi
+
+    echo "Snapshotting docker state..."
+    mkdir -p "$EARTHLY_DOCKERD_SNAPSHOT"
+    docker ps -q >"$EARTHLY_DOCKERD_SNAPSHOT/running"
+    kill_dockerd
+    # shellcheck disable=SC2046
+    tar $(tar_xattrs_flags) -C "$EARTHLY_DOCKERD_DATA_ROOT" -cf "$EARTHLY_DOCKERD_SNAPSHOT/docker.tar" .
+    rm -rf "$EARTHLY_DOCKERD_DATA_ROOT"
+    echo "...done"
+}
+
+# Starts the containers which were running when the snapshot restored by
+# start_dockerd was taken.
+restore_containers() {
+    if [ -z "${EARTHLY_DOCKERD_RESTORE:-}" ]; then
+        return 0
+    fi
+    for id in $(cat "$EARTHLY_DOCKERD_RESTORE/running"); do
+        docker start "$id" >/dev/null || (stop_dockerd; exit 1)
+    done
+}
+
+# The overlay storage driver keeps state in extended attributes, which are
+# preserved by GNU tar only when asked to.
+tar_xattrs_flags() {
+    if tar --help 2>&1 | grep -q -- --xattrs-include; then
+        echo "--xattrs --xattrs-include=* --numeric-owner"
+    else
+        echo "--numeric-owner"
+    fi
+}
+
+# Streams the logs of the containers as they start, each line prefixed with
+# the name of its container, as per WITH DOCKER --stream-logs.
+stream_logs() {
+    docker events --filter type=container --filter event=start --format '{{.Actor.Attributes.name}} {{.Time}}' | \
+        while read -r name since; do
+            docker logs --follow --since "$since" "$name" 2>&1 | prefix_lines "$name" &
+        done &
+    stream_logs_pid="$!"
+}
+
+prefix_lines() {
+    while IFS= read -r line || [ -n "$line" ]; do
+        printf '[%s] %s\n' "$1" "$line"
+    done
+}
+
+# Creates the persistent volumes of WITH DOCKER --volume, as docker volumes
+# bound to the cache mounts of /var/earthly/volumes. The contents of a volume
+# unused for longer than its max age (in seconds, 0 if none) are discarded.
+create_volumes() {
+    now="$(date +%s)"
+    for v in ${EARTHLY_VOLUMES:-}; do
+        name="${v%%:*}"
+        max_age="${v#*:}"
+        dir="/var/earthly/volumes/$name"
+        if [ "$max_age" -gt 0 ] && [ -f "$dir/.earthly-last-used" ]; then
+            last_used="$(cat "$dir/.earthly-last-used")"
+            if [ "$((now - last_used))" -gt "$max_age" ]; then
+                echo "Volume $name unused for more than ${max_age}s, discarding its contents"
+                rm -rf "$dir/data"
+            fi
+        fi
+        mkdir -p "$dir/data"
+        echo "$now" >"$dir/.earthly-last-used"
+        docker volume create --driver local --opt type=none --opt o=bind --opt device="$dir/data" "$name" >/dev/null || (stop_dockerd; exit 1)
+    done
+}
+
 start_dockerd() {
     # Use a specific IP range to avoid collision with host dockerd (we need to also connect to host
     # docker containers for the debugger).
@@ -67,6 +177,12 @@ EOF
     # Start with a rm -rf to make sure a previous interrupted build did not leave its state around.
     rm -rf "$EARTHLY_DOCKERD_DATA_ROOT"
     mkdir -p "$EARTHLY_DOCKERD_DATA_ROOT"
+    if [ -n "${EARTHLY_DOCKERD_RESTORE:-}" ]; then
+        echo "Restoring docker state..."
+        # shellcheck disable=SC2046
+        tar $(tar_xattrs_flags) -C "$EARTHLY_DOCKERD_DATA_ROOT" -xf "$EARTHLY_DOCKERD_RESTORE/docker.tar"
+        echo "...done"
+    fi
     dockerd --data-root="$EARTHLY_DOCKERD_DATA_ROOT" --bip=172.20.0.1/16 >/var/log/docker.log 2>&1 &
     dockerd_pid="$!"
     i=1
@@ -95,6 +211,12 @@ EOF
 }
 
 stop_dockerd() {
+    kill_dockerd
+    # Wipe dockerd data when done.
+    rm -rf "$EARTHLY_DOCKERD_DATA_ROOT"
+}
+
+kill_dockerd() {
     dockerd_pid="$(cat /var/run/docker.pid)"
     timeout=30
     if [ -n "$dockerd_pid" ]; then
@@ -108,8 +230,26 @@ stop_dockerd() {
             i=$((i+1))
         done
     fi
-    # Wipe dockerd data when done.
-    rm -rf "$EARTHLY_DOCKERD_DATA_ROOT"
+}
+
+# Waits for the ports and URLs of WITH DOCKER --wait-for-port and
+# --wait-for-http, printing the logs of the containers if they are not ready in
+# time.
+await_services() {
+    if [ -z "${EARTHLY_AWAIT_PORTS:-}${EARTHLY_AWAIT_URLS:-}" ]; then
+        return 0
+    fi
+    # No globbing of the URLs, which may contain ? and *.
+    set -f
+    set -- --timeout "$EARTHLY_AWAIT_TIMEOUT"
+    for p in $EARTHLY_AWAIT_PORTS; do
+        set -- "$@" --port "$p"
+    done
+    for u in $EARTHLY_AWAIT_URLS; do
+        set -- "$@" --http "$u"
+    done
+    set +f
+    /usr/bin/earthly-await "$@"
 }
 
 load_images() {
@@ -132,6 +272,11 @@ case "$1" in
         execute "$@"
         exit "$?"
         ;;
+
+    snapshot)
+        snapshot "$@"
+        exit "$?"
+        ;;
     
     *)
         echo "Invalid command $1"
@@ -1,6 +1,6 @@
 #!/bin/sh
 set -e
-echo "starting earthly-buildkit with EARTHLY_GIT_HASH=$EARTHLY_GIT_HASH BUILDKIT_BASE_IMAGE=$BUILDKIT_BASE_IMAGE"
+echo "starting earthly-buildkit with EARTHLY_VERSION=$EARTHLY_VERSION EARTHLY_GIT_HASH=$EARTHLY_GIT_HASH BUILDKIT_BASE_IMAGE=$BUILDKIT_BASE_IMAGE"
 
 KERNEL="generic"
 if uname -a | grep -wiq "microsoft"; then
@@ -56,6 +56,28 @@ fi
 rm -rf "$EARTHLY_TMP_DIR/dind"
 mkdir -p "$EARTHLY_TMP_DIR/dind"
 
+# Collect the NVIDIA driver files injected by the NVIDIA Container Toolkit, which are mounted
+# by RUN --gpus.
+rm -rf "$EARTHLY_TMP_DIR/gpu"
+mkdir -p "$EARTHLY_TMP_DIR/gpu/bin" "$EARTHLY_TMP_DIR/gpu/lib64"
+if [ "$EARTHLY_GPUS" = "true" ]; then
+    for f in /usr/bin/nvidia-smi /usr/bin/nvidia-debugdump /usr/bin/nvidia-persistenced /usr/bin/nvidia-cuda-mps-control /usr/bin/nvidia-cuda-mps-server; do
+        if [ -e "$f" ]; then
+            cp -a "$f" "$EARTHLY_TMP_DIR/gpu/bin/"
+        fi
+    done
+    for dir in /usr/lib/x86_64-linux-gnu /usr/lib/aarch64-linux-gnu /usr/lib64 /usr/lib; do
+        for f in "$dir"/libcuda.so* "$dir"/libnvidia-*.so* "$dir"/libnvcuvid.so* "$dir"/libcudadebugger.so*; do
+            if [ -e "$f" ]; then
+                cp -a "$f" "$EARTHLY_TMP_DIR/gpu/lib64/"
+            fi
+        done
+    done
+    if ! ls /dev/nvidia* >/dev/null 2>&1; then
+        echo "Warning: EARTHLY_GPUS is set, but no NVIDIA devices are available. Is the NVIDIA Container Toolkit installed?"
+    fi
+fi
+
 # setup git credentials and config
 i=0
 while true
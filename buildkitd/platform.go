@@ -0,0 +1,95 @@
+package buildkitd
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// archPlatforms maps the architectures reported by docker info and uname -m
+// to the platforms of the buildkitd image.
+var archPlatforms = map[string]string{
+	"x86_64":  "linux/amd64",
+	"amd64":   "linux/amd64",
+	"aarch64": "linux/arm64",
+	"arm64":   "linux/arm64",
+	"armv7l":  "linux/arm/v7",
+	"armhf":   "linux/arm/v7",
+}
+
+// archToPlatform returns the platform of the buildkitd image which runs
+// natively on the given architecture.
+func archToPlatform(arch string) (string, error) {
+	p, ok := archPlatforms[strings.TrimSpace(arch)]
+	if !ok {
+		return "", errors.Errorf("unsupported architecture %q", arch)
+	}
+	return p, nil
+}
+
+// HostPlatform returns the platform of the buildkitd image which runs natively
+// on the docker host. This is the architecture of the docker daemon, and not
+// that of this binary: an amd64 build of earthly running under Rosetta on
+// Apple Silicon still uses the arm64 image. It falls back to the architecture
+// of this binary if the one of the docker daemon cannot be determined.
+func HostPlatform(ctx context.Context) string {
+	cmd := exec.CommandContext(ctx, "docker", "info", "--format={{.Architecture}}")
+	output, err := cmd.Output()
+	if err == nil {
+		p, err := archToPlatform(string(output))
+		if err == nil {
+			return p
+		}
+	}
+	p, err := archToPlatform(runtime.GOARCH)
+	if err != nil {
+		return "linux/" + runtime.GOARCH
+	}
+	return p
+}
+
+// IsTranslated returns whether this binary runs under Rosetta translation, as
+// an amd64 build on Apple Silicon.
+func IsTranslated(ctx context.Context) bool {
+	if runtime.GOOS != "darwin" || runtime.GOARCH != "amd64" {
+		return false
+	}
+	output, err := exec.CommandContext(ctx, "sysctl", "-n", "sysctl.proc_translated").Output()
+	return err == nil && strings.TrimSpace(string(output)) == "1"
+}
+
+// getImagePlatform returns the platform of an image available locally.
+func getImagePlatform(ctx context.Context, image string) (string, error) {
+	cmd := exec.CommandContext(ctx,
+		"docker", "image", "inspect", "--format={{.Os}}/{{.Architecture}}{{if .Variant}}/{{.Variant}}{{end}}", image)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "get platform of image %s", image)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// VerifyNative checks that the buildkitd container runs natively, rather than
+// via emulation, which is several times slower. It returns the machine
+// hardware name reported by the container.
+func VerifyNative(ctx context.Context, containerName string) (string, error) {
+	cmd := exec.CommandContext(ctx, "docker", "exec", containerName, "uname", "-m")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "run uname in %s: %s", containerName, strings.TrimSpace(string(output)))
+	}
+	machine := strings.TrimSpace(string(output))
+	hostPlatform := HostPlatform(ctx)
+	p, err := archToPlatform(machine)
+	if err != nil {
+		return "", err
+	}
+	if p != hostPlatform {
+		return "", errors.Errorf(
+			"buildkitd runs as %s via emulation, on a %s host; run earthly prune --reset to restart it natively", p, hostPlatform)
+	}
+	return machine, nil
+}
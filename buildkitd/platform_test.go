@@ -0,0 +1,22 @@
+package buildkitd
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestArchToPlatform(t *testing.T) {
+	for arch, expected := range map[string]string{
+		"x86_64\n": "linux/amd64",
+		"aarch64":  "linux/arm64",
+		"arm64":    "linux/arm64",
+		"armv7l":   "linux/arm/v7",
+	} {
+		p, err := archToPlatform(arch)
+		NoError(t, err)
+		Equal(t, expected, p)
+	}
+	_, err := archToPlatform("s390x")
+	Error(t, err)
+}
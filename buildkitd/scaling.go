@@ -0,0 +1,88 @@
+package buildkitd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/moby/buildkit/util/grpcerrors"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+)
+
+// ScalingEvent is the kind of event published to a scaling hook.
+type ScalingEvent string
+
+const (
+	// ScalingEventRequested is published before earthly connects to a buildkit
+	// worker.
+	ScalingEventRequested ScalingEvent = "requested"
+	// ScalingEventReleased is published after the build has finished using the worker.
+	ScalingEventReleased ScalingEvent = "released"
+	// ScalingEventWorkerLost is published when the connection to a worker has been
+	// lost mid-build.
+	ScalingEventWorkerLost ScalingEvent = "worker-lost"
+)
+
+// Demand describes the use of a buildkit worker by a single build. It carries
+// neither the queue depth of the worker nor the resources which the build
+// needs, as earthly knows neither.
+type Demand struct {
+	Event     ScalingEvent `json:"event"`
+	BuildID   string       `json:"build_id"`
+	Host      string       `json:"buildkit_host"`
+	Target    string       `json:"target"`
+	Platforms []string     `json:"platforms,omitempty"`
+}
+
+// ScalingHook notifies an external command of the use of buildkit workers by
+// builds, such as to keep track of which workers of a self-hosted fleet are in
+// use. The demand is passed to the command as JSON via stdin.
+type ScalingHook struct {
+	command string
+}
+
+// NewScalingHook returns a new ScalingHook which executes the given command
+// via sh -c. It returns nil if the command is empty.
+func NewScalingHook(command string) *ScalingHook {
+	if command == "" {
+		return nil
+	}
+	return &ScalingHook{command: command}
+}
+
+// Publish executes the hook command for the given demand. It is safe to call
+// Publish on a nil ScalingHook, in which case nothing is published.
+func (h *ScalingHook) Publish(ctx context.Context, d Demand) error {
+	if h == nil {
+		return nil
+	}
+	dt, err := json.Marshal(d)
+	if err != nil {
+		return errors.Wrap(err, "marshal demand")
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.command)
+	cmd.Stdin = bytes.NewReader(dt)
+	cmd.Env = append(os.Environ(), "EARTHLY_SCALING_EVENT="+string(d.Event))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "scaling hook %s: %s", d.Event, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// IsWorkerLost returns true if the error indicates that the connection to the
+// buildkit worker has gone away.
+func IsWorkerLost(err error) bool {
+	if err == nil {
+		return false
+	}
+	if grpcerrors.Code(err) == codes.Unavailable {
+		return true
+	}
+	return strings.Contains(err.Error(), "transport is closing") ||
+		strings.Contains(err.Error(), "connection reset by peer")
+}
@@ -29,6 +29,12 @@ type Settings struct {
 	UseTCP               bool
 	UseTLS               bool
 	VolumeName           string
+	// GPUs are the GPUs made available to the daemon, for RUN --gpus, as per
+	// docker run --gpus.
+	GPUs string
+	// ClientVersion is the version of the earthly CLI, which is checked for
+	// compatibility with the daemon.
+	ClientVersion string `hash:"ignore"`
 }
 
 // Hash returns a secure hash of the settings.
@@ -0,0 +1,158 @@
+// Package capabilities implements the declarations of the host access which
+// LOCALLY targets need, and their acceptance by the user. The declarations are
+// advisory: they are not a sandbox, and the commands of a LOCALLY target keep
+// full access to the host whatever they declare.
+package capabilities
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Kind is the kind of host resource a LOCALLY target may declare it uses.
+type Kind string
+
+const (
+	// KindFS is the use of a path on the host filesystem (and everything under it).
+	KindFS Kind = "fs"
+	// KindNetwork is the use of the network.
+	KindNetwork Kind = "network"
+	// KindDocker is the use of the host's docker daemon.
+	KindDocker Kind = "docker"
+)
+
+// Capability is a single host resource.
+type Capability struct {
+	Kind Kind
+	// Path is only set for KindFS. It is always absolute.
+	Path string
+}
+
+// String returns the capability in the same format accepted by Parse.
+func (c Capability) String() string {
+	if c.Kind == KindFS {
+		return fmt.Sprintf("%s=%s", c.Kind, c.Path)
+	}
+	return string(c.Kind)
+}
+
+// Covers returns true if accepting c implies accepting other.
+func (c Capability) Covers(other Capability) bool {
+	if c.Kind != other.Kind {
+		return false
+	}
+	if c.Kind != KindFS {
+		return true
+	}
+	rel, err := filepath.Rel(c.Path, other.Path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// Parse parses a capability of the form fs=<path>, network or docker. Relative
+// paths are resolved against baseDir.
+func Parse(s string, baseDir string) (Capability, error) {
+	parts := strings.SplitN(s, "=", 2)
+	kind := Kind(parts[0])
+	switch kind {
+	case KindFS:
+		if len(parts) != 2 || parts[1] == "" {
+			return Capability{}, errors.Errorf("capability %s requires a path, e.g. fs=./out", s)
+		}
+		p := parts[1]
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(baseDir, p)
+		}
+		return Capability{Kind: KindFS, Path: filepath.Clean(p)}, nil
+	case KindNetwork, KindDocker:
+		if len(parts) != 1 {
+			return Capability{}, errors.Errorf("capability %s does not take a value", kind)
+		}
+		return Capability{Kind: kind}, nil
+	default:
+		return Capability{}, errors.Errorf("unknown capability %s; valid capabilities are fs=<path>, network and docker", s)
+	}
+}
+
+// PromptFun asks the user whether to accept a capability declared by a target.
+type PromptFun func(target string, c Capability) (bool, error)
+
+// Grants keeps track of which declared capabilities the user has accepted.
+type Grants struct {
+	mu      sync.Mutex
+	granted []Capability
+	enforce bool
+	prompt  PromptFun
+}
+
+// NewGrants returns a new Grants, pre-populated with the given accepted
+// capabilities. In enforcing mode, every LOCALLY target must declare its
+// capabilities and anything not already accepted fails without prompting. The
+// prompt fun may be nil, in which case capabilities which have not been
+// accepted fail.
+func NewGrants(granted []Capability, enforce bool, prompt PromptFun) *Grants {
+	return &Grants{
+		granted: append([]Capability{}, granted...),
+		enforce: enforce,
+		prompt:  prompt,
+	}
+}
+
+// Check verifies that every declared capability of the target has been
+// accepted, prompting the user where possible. Accepting via the prompt is
+// remembered for the remainder of the session. Targets which declare nothing
+// are only rejected in enforcing mode. It is safe to call Check on a nil
+// Grants, in which case everything is accepted.
+func (g *Grants) Check(target string, declared []Capability) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(declared) == 0 {
+		if g.enforce {
+			return errors.Errorf(
+				"LOCALLY in %s does not declare the host access it needs, which is required in enforcing mode; "+
+					"declare it via LOCALLY --declare-fs, --declare-network or --declare-docker", target)
+		}
+		return nil
+	}
+	var denied []string
+	for _, c := range declared {
+		if g.isGranted(c) {
+			continue
+		}
+		if !g.enforce && g.prompt != nil {
+			ok, err := g.prompt(target, c)
+			if err != nil {
+				return err
+			}
+			if ok {
+				g.granted = append(g.granted, c)
+				continue
+			}
+		}
+		denied = append(denied, c.String())
+	}
+	if len(denied) > 0 {
+		return errors.Errorf(
+			"LOCALLY in %s declares host access which has not been accepted: %s; use --locally-accept to accept it",
+			target, strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+func (g *Grants) isGranted(c Capability) bool {
+	for _, gc := range g.granted {
+		if gc.Covers(c) {
+			return true
+		}
+	}
+	return false
+}
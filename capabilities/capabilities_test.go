@@ -0,0 +1,62 @@
+package capabilities
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func mustParse(t *testing.T, s string) Capability {
+	c, err := Parse(s, "/work")
+	NoError(t, err)
+	return c
+}
+
+func TestParse(t *testing.T) {
+	Equal(t, Capability{Kind: KindFS, Path: "/work/out"}, mustParse(t, "fs=./out"))
+	Equal(t, Capability{Kind: KindFS, Path: "/tmp"}, mustParse(t, "fs=/tmp/"))
+	Equal(t, Capability{Kind: KindNetwork}, mustParse(t, "network"))
+	Equal(t, Capability{Kind: KindDocker}, mustParse(t, "docker"))
+
+	_, err := Parse("fs", "/work")
+	Error(t, err)
+	_, err = Parse("network=yes", "/work")
+	Error(t, err)
+	_, err = Parse("gpu", "/work")
+	Error(t, err)
+}
+
+func TestCovers(t *testing.T) {
+	True(t, mustParse(t, "fs=/work").Covers(mustParse(t, "fs=/work/out/bin")))
+	True(t, mustParse(t, "fs=/work").Covers(mustParse(t, "fs=/work")))
+	False(t, mustParse(t, "fs=/work").Covers(mustParse(t, "fs=/workspace")))
+	False(t, mustParse(t, "fs=/work/out").Covers(mustParse(t, "fs=/work")))
+	False(t, mustParse(t, "network").Covers(mustParse(t, "docker")))
+}
+
+func TestCheck(t *testing.T) {
+	declared := []Capability{mustParse(t, "fs=./out"), mustParse(t, "network")}
+
+	g := NewGrants([]Capability{mustParse(t, "fs=/work"), mustParse(t, "network")}, true, nil)
+	NoError(t, g.Check("+deploy", declared))
+	Error(t, g.Check("+deploy", nil))
+
+	g = NewGrants(nil, true, nil)
+	Error(t, g.Check("+deploy", declared))
+
+	g = NewGrants(nil, false, nil)
+	NoError(t, g.Check("+deploy", nil))
+	Error(t, g.Check("+deploy", declared))
+
+	prompts := 0
+	g = NewGrants(nil, false, func(target string, c Capability) (bool, error) {
+		prompts++
+		return true, nil
+	})
+	NoError(t, g.Check("+deploy", declared))
+	NoError(t, g.Check("+deploy", declared))
+	Equal(t, 2, prompts)
+
+	var nilGrants *Grants
+	NoError(t, nilGrants.Check("+deploy", declared))
+}
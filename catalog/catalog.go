@@ -0,0 +1,231 @@
+// Package catalog indexes the targets of the Earthfiles of a set of
+// repositories, along with their doc comments, into a local catalog which
+// earthly catalog search queries.
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/earthly/earthly/ast"
+	"github.com/earthly/earthly/earthfiledoc"
+	"github.com/earthly/earthly/util/stringutil"
+	"github.com/pkg/errors"
+)
+
+// Entry is a target of the catalog.
+type Entry struct {
+	// Repo is the repository of the target, as in github.com/org/repo.
+	Repo string `json:"repo"`
+	// Path is the slash separated directory of the Earthfile of the target,
+	// relative to the repository. It is empty for the root of the repository.
+	Path   string `json:"path,omitempty"`
+	Target string `json:"target"`
+	Docs   string `json:"docs,omitempty"`
+	// Args are the names of the ARGs of the target.
+	Args []string `json:"args,omitempty"`
+}
+
+// Ref returns the reference of the target, as in
+// github.com/org/repo/path+target.
+func (e Entry) Ref() string {
+	return path.Join(e.Repo, e.Path) + "+" + e.Target
+}
+
+// Summary returns the first sentence of the doc comment of the target, or its
+// first line if the sentence spans several lines.
+func (e Entry) Summary() string {
+	line := strings.SplitN(strings.TrimSpace(e.Docs), "\n", 2)[0]
+	if i := strings.Index(line, ". "); i != -1 {
+		return line[:i+1]
+	}
+	return line
+}
+
+// Catalog is the index of the targets of a set of repositories.
+type Catalog struct {
+	// Indexed is the time each repository was last indexed at.
+	Indexed map[string]time.Time `json:"indexed"`
+	Entries []Entry              `json:"entries"`
+}
+
+// Load reads the catalog at the given path. A catalog which does not exist
+// yet is empty.
+func Load(p string) (*Catalog, error) {
+	c := &Catalog{Indexed: make(map[string]time.Time)}
+	dt, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", p)
+	}
+	err = json.Unmarshal(dt, c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %s", p)
+	}
+	if c.Indexed == nil {
+		c.Indexed = make(map[string]time.Time)
+	}
+	return c, nil
+}
+
+// Save writes the catalog to the given path.
+func (c *Catalog) Save(p string) error {
+	dt, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal catalog")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".catalog-*")
+	if err != nil {
+		return errors.Wrapf(err, "create %s", p)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(dt)
+	tmp.Close()
+	if err != nil {
+		return errors.Wrapf(err, "write %s", tmp.Name())
+	}
+	err = os.Rename(tmp.Name(), p)
+	if err != nil {
+		return errors.Wrapf(err, "rename to %s", p)
+	}
+	return nil
+}
+
+// Replace replaces the entries of the repository with the given ones.
+func (c *Catalog) Replace(repo string, entries []Entry, indexed time.Time) {
+	kept := c.Entries[:0]
+	for _, e := range c.Entries {
+		if e.Repo != repo {
+			kept = append(kept, e)
+		}
+	}
+	c.Entries = append(kept, entries...)
+	sort.SliceStable(c.Entries, func(i, j int) bool {
+		return c.Entries[i].Ref() < c.Entries[j].Ref()
+	})
+	c.Indexed[repo] = indexed
+}
+
+// Clone clones the default branch of the repository at cloneURL into dir,
+// without its history. env are additional environment variables for git,
+// such as GIT_SSH_COMMAND.
+func Clone(ctx context.Context, cloneURL, dir string, env []string) error {
+	cmd := exec.CommandContext(ctx, "git", "clone", "-q", "--depth=1", cloneURL, dir)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "clone %s: %s",
+			stringutil.ScrubCredentials(cloneURL), stringutil.ScrubCredentials(strings.TrimSpace(string(output))))
+	}
+	return nil
+}
+
+// IndexDir returns the entries of the targets of all the Earthfiles within
+// dir, which is a checkout of the given repository. Hidden directories are
+// skipped. Earthfiles which fail to parse are reported via warnf, and
+// skipped.
+func IndexDir(ctx context.Context, repo, dir string, warnf func(format string, args ...interface{})) ([]Entry, error) {
+	var entries []Entry
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if p != dir && strings.HasPrefix(fi.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Name() != "Earthfile" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filepath.Dir(p))
+		if err != nil {
+			return errors.Wrapf(err, "rel %s", p)
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		ef, err := ast.Parse(ctx, p, false)
+		if err != nil {
+			warnf("Skipping %s: %s\n", path.Join(repo, rel, "Earthfile"), err.Error())
+			return nil
+		}
+		for _, t := range earthfiledoc.New(ef).Targets {
+			e := Entry{
+				Repo:   repo,
+				Path:   rel,
+				Target: strings.TrimPrefix(t.Name, "+"),
+				Docs:   t.Docs,
+			}
+			for _, arg := range t.Args {
+				e.Args = append(e.Args, arg.Name)
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "index %s", repo)
+	}
+	return entries, nil
+}
+
+// Search returns the entries which match all the words of the query, in the
+// reference, doc comment or ARGs of the target, case-insensitively. Matches
+// in the name of the target rank first, then matches in the doc comment. An
+// empty query matches all entries.
+func (c *Catalog) Search(query string) []Entry {
+	words := strings.Fields(strings.ToLower(query))
+	type result struct {
+		entry Entry
+		score int
+	}
+	var results []result
+	for _, e := range c.Entries {
+		score, ok := matchScore(e, words)
+		if ok {
+			results = append(results, result{entry: e, score: score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+	entries := make([]Entry, 0, len(results))
+	for _, r := range results {
+		entries = append(entries, r.entry)
+	}
+	return entries
+}
+
+func matchScore(e Entry, words []string) (int, bool) {
+	target := strings.ToLower(e.Target)
+	docs := strings.ToLower(e.Docs)
+	ref := strings.ToLower(e.Ref())
+	args := strings.ToLower(strings.Join(e.Args, " "))
+	score := 0
+	for _, w := range words {
+		switch {
+		case strings.Contains(target, w):
+			score += 3
+		case strings.Contains(docs, w):
+			score += 2
+		case strings.Contains(ref, w) || strings.Contains(args, w):
+			score++
+		default:
+			return 0, false
+		}
+	}
+	return score, true
+}
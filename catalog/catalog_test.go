@@ -0,0 +1,67 @@
+package catalog
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestIndexDirAndSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"Earthfile": "VERSION 0.6\n\n" +
+			"# Generates the Go stubs of the protobuf definitions.\n" +
+			"protos:\n    FROM alpine\n    ARG PROTOC_VERSION\n\n" +
+			"build:\n    FROM alpine\n",
+		"services/api/Earthfile": "VERSION 0.6\n\n" +
+			"# Builds the API server image. Pushed on main.\n" +
+			"docker:\n    FROM alpine\n",
+		"broken/Earthfile":   "VERSION 0.6\nbuild:\n  RUN <<EOF\n",
+		".hidden/Earthfile":  "VERSION 0.6\nhidden:\n    FROM alpine\n",
+		"services/README.md": "not an Earthfile",
+	}
+	for p, content := range files {
+		p = filepath.Join(dir, filepath.FromSlash(p))
+		NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+	var warnings int
+	entries, err := IndexDir(context.Background(), "github.com/org/repo", dir, func(format string, args ...interface{}) {
+		warnings++
+	})
+	NoError(t, err)
+	Equal(t, 1, warnings)
+	Equal(t, []Entry{
+		{Repo: "github.com/org/repo", Target: "protos", Docs: "Generates the Go stubs of the protobuf definitions.", Args: []string{"PROTOC_VERSION"}},
+		{Repo: "github.com/org/repo", Target: "build"},
+		{Repo: "github.com/org/repo", Path: "services/api", Target: "docker", Docs: "Builds the API server image. Pushed on main."},
+	}, entries)
+
+	p := filepath.Join(dir, "catalog.json")
+	c, err := Load(p)
+	NoError(t, err)
+	c.Replace("github.com/org/repo", entries, time.Now())
+	NoError(t, c.Save(p))
+	c, err = Load(p)
+	NoError(t, err)
+	Equal(t, 3, len(c.Entries))
+
+	results := c.Search("protobuf STUBS")
+	Equal(t, 1, len(results))
+	Equal(t, "github.com/org/repo+protos", results[0].Ref())
+	results = c.Search("api")
+	Equal(t, 1, len(results))
+	Equal(t, "github.com/org/repo/services/api+docker", results[0].Ref())
+	Equal(t, "Builds the API server image.", results[0].Summary())
+	Equal(t, 3, len(c.Search("")))
+
+	c.Replace("github.com/org/repo", entries[:1], time.Now())
+	Equal(t, 1, len(c.Search("")))
+}
@@ -0,0 +1,231 @@
+// Package checksums writes a manifest of the SHA256 checksums of the
+// artifacts which a build saves locally, in the format of sha256sum, and
+// signs it via the cosign or minisign CLIs, as requested by
+// earthly --checksums.
+package checksums
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ToolCosign signs the manifest via sigstore cosign sign-blob.
+	ToolCosign = "cosign"
+	// ToolMinisign signs the manifest via minisign.
+	ToolMinisign = "minisign"
+)
+
+// Write writes the manifest of the checksums of the given files, and of the
+// files within the given directories, to the given path. The files are
+// listed relative to the directory of the manifest, sorted, such that the
+// manifest can be verified via sha256sum -c from there. The manifest itself
+// and its signatures are never listed.
+func Write(p string, paths []string) error {
+	dir := filepath.Dir(p)
+	skip := map[string]bool{}
+	for _, s := range []string{p, p + ".sig", p + ".minisig"} {
+		abs, err := filepath.Abs(s)
+		if err != nil {
+			return errors.Wrapf(err, "abs %s", s)
+		}
+		skip[abs] = true
+	}
+	sums := make(map[string]string)
+	for _, root := range paths {
+		err := filepath.Walk(root, func(f string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			abs, err := filepath.Abs(f)
+			if err != nil {
+				return errors.Wrapf(err, "abs %s", f)
+			}
+			if skip[abs] {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, f)
+			if err != nil {
+				return errors.Wrapf(err, "rel %s", f)
+			}
+			sum, err := fileSum(f)
+			if err != nil {
+				return err
+			}
+			sums[filepath.ToSlash(rel)] = sum
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "checksum %s", root)
+		}
+	}
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", sums[name], name)
+	}
+	err := ioutil.WriteFile(p, buf.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrapf(err, "write %s", p)
+	}
+	return nil
+}
+
+func fileSum(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", errors.Wrapf(err, "open %s", p)
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", errors.Wrapf(err, "read %s", p)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Signer describes how the manifest is signed.
+type Signer struct {
+	// Tool is either ToolCosign or ToolMinisign.
+	Tool string
+	// Key is the name of the secret holding the private key.
+	Key string
+	// Password is the name of the secret holding the password of the
+	// private key, if it is encrypted.
+	Password string
+}
+
+// ParseSigner parses the value of earthly --checksums-sign, which has the
+// form <tool>:key=<secret>[,password=<secret>], as in
+// cosign:key=COSIGN_KEY,password=COSIGN_PASSWORD.
+func ParseSigner(s string) (Signer, error) {
+	tool, opts := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		tool, opts = s[:i], s[i+1:]
+	}
+	if tool != ToolCosign && tool != ToolMinisign {
+		return Signer{}, errors.Errorf("invalid signer %q: the tool must be %s or %s", s, ToolCosign, ToolMinisign)
+	}
+	signer := Signer{Tool: tool}
+	if opts != "" {
+		for _, kv := range strings.Split(opts, ",") {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 || parts[1] == "" {
+				return Signer{}, errors.Errorf("invalid signer %q: expected <key>=<value>, got %q", s, kv)
+			}
+			name := strings.TrimPrefix(parts[1], "+secrets/")
+			switch parts[0] {
+			case "key":
+				signer.Key = name
+			case "password":
+				signer.Password = name
+			default:
+				return Signer{}, errors.Errorf("invalid signer %q: unknown option %s", s, parts[0])
+			}
+		}
+	}
+	if signer.Key == "" {
+		return Signer{}, errors.Errorf("invalid signer %q: the key secret is required, as in %s:key=<secret>", s, tool)
+	}
+	return signer, nil
+}
+
+// SignatureFile returns the path of the detached signature of the manifest
+// at p: p.sig, holding the base64 encoded signature, for cosign, and
+// p.minisig for minisign.
+func (s Signer) SignatureFile(p string) string {
+	if s.Tool == ToolMinisign {
+		return p + ".minisig"
+	}
+	return p + ".sig"
+}
+
+// RunFunc runs a command with the given stdin and additional environment,
+// returning its combined output.
+type RunFunc func(ctx context.Context, stdin []byte, env []string, name string, args ...string) ([]byte, error)
+
+// ExecRun runs commands found in the PATH.
+func ExecRun(ctx context.Context, stdin []byte, env []string, name string, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, errors.Errorf("%s is required to sign the checksums, but it was not found in the PATH", name)
+	}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.Bytes(), err
+}
+
+// Sign writes the detached signature of the manifest at p, with the private
+// key of the secret, and returns its path. The key is staged in a private
+// temporary file for the duration of the signing only, and its password is
+// passed via the environment (cosign) or stdin (minisign), never as an
+// argument. A trailing newline of the password is ignored.
+func (s Signer) Sign(ctx context.Context, p string, getSecret func(name string) ([]byte, error), run RunFunc) (string, error) {
+	key, err := getSecret(s.Key)
+	if err != nil {
+		return "", errors.Wrapf(err, "signing key %s", s.Key)
+	}
+	var password []byte
+	if s.Password != "" {
+		password, err = getSecret(s.Password)
+		if err != nil {
+			return "", errors.Wrapf(err, "signing key password %s", s.Password)
+		}
+		password = bytes.TrimRight(password, "\r\n")
+	}
+	dir, err := ioutil.TempDir("", "earthly-checksums")
+	if err != nil {
+		return "", errors.Wrap(err, "create signing key dir")
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "signing.key")
+	err = ioutil.WriteFile(keyFile, key, 0600)
+	if err != nil {
+		return "", errors.Wrap(err, "write signing key")
+	}
+
+	sig := s.SignatureFile(p)
+	var stdin []byte
+	var env, args []string
+	switch s.Tool {
+	case ToolCosign:
+		env = []string{"COSIGN_PASSWORD=" + string(password)}
+		args = []string{"sign-blob", "--yes", "--key", keyFile, "--output-signature", sig, p}
+	case ToolMinisign:
+		args = []string{"-S", "-s", keyFile, "-m", p, "-x", sig}
+		if s.Password == "" {
+			args = append(args, "-W")
+		} else {
+			stdin = append(password, '\n')
+		}
+	}
+	out, err := run(ctx, stdin, env, s.Tool, args...)
+	if err != nil {
+		return "", errors.Wrapf(err, "%s failed to sign %s: %s", s.Tool, p, strings.TrimSpace(string(out)))
+	}
+	return sig, nil
+}
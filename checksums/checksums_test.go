@@ -0,0 +1,82 @@
+package checksums
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checksums")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	dist := filepath.Join(dir, "dist")
+	NoError(t, os.MkdirAll(filepath.Join(dist, "docs"), 0755))
+	NoError(t, ioutil.WriteFile(filepath.Join(dist, "app"), []byte("app"), 0755))
+	NoError(t, ioutil.WriteFile(filepath.Join(dist, "docs", "README"), []byte("readme"), 0644))
+	NoError(t, ioutil.WriteFile(filepath.Join(dist, "SHA256SUMS.sig"), []byte("stale"), 0644))
+
+	p := filepath.Join(dist, "SHA256SUMS")
+	NoError(t, Write(p, []string{filepath.Join(dist, "app"), dist}))
+	dt, err := ioutil.ReadFile(p)
+	NoError(t, err)
+	Equal(t, "a172cedcae47474b615c54d510a5d84a8dea3032e958587430b413538be3f333  app\n"+
+		"711a6108ba2ce6ca93dd47d6817f2361db10d8ab6eec89460b2dfc2c325efabe  docs/README\n", string(dt))
+}
+
+func TestParseSigner(t *testing.T) {
+	s, err := ParseSigner("cosign:key=+secrets/COSIGN_KEY,password=COSIGN_PASSWORD")
+	NoError(t, err)
+	Equal(t, Signer{Tool: ToolCosign, Key: "COSIGN_KEY", Password: "COSIGN_PASSWORD"}, s)
+	Equal(t, "dist/SHA256SUMS.sig", s.SignatureFile("dist/SHA256SUMS"))
+	s, err = ParseSigner("minisign:key=MINISIGN_KEY")
+	NoError(t, err)
+	Equal(t, Signer{Tool: ToolMinisign, Key: "MINISIGN_KEY"}, s)
+	Equal(t, "dist/SHA256SUMS.minisig", s.SignatureFile("dist/SHA256SUMS"))
+	for _, bad := range []string{"", "gpg:key=K", "cosign", "cosign:key", "cosign:password=P", "minisign:key=K,foo=bar"} {
+		_, err = ParseSigner(bad)
+		Error(t, err, bad)
+	}
+}
+
+func TestSign(t *testing.T) {
+	secrets := map[string]string{"KEY": "private key", "PASSWORD": "hunter2\n"}
+	getSecret := func(name string) ([]byte, error) {
+		return []byte(secrets[name]), nil
+	}
+	var calls []string
+	var stdins []string
+	run := func(ctx context.Context, stdin []byte, env []string, name string, args ...string) ([]byte, error) {
+		for i, arg := range args {
+			if strings.HasSuffix(arg, "signing.key") {
+				dt, err := ioutil.ReadFile(arg)
+				NoError(t, err)
+				Equal(t, "private key", string(dt))
+				args[i] = "KEYFILE"
+			}
+		}
+		calls = append(calls, strings.Join(append(append(env, name), args...), " "))
+		stdins = append(stdins, string(stdin))
+		return nil, nil
+	}
+
+	sig, err := Signer{Tool: ToolCosign, Key: "KEY", Password: "PASSWORD"}.Sign(context.Background(), "SHA256SUMS", getSecret, run)
+	NoError(t, err)
+	Equal(t, "SHA256SUMS.sig", sig)
+	sig, err = Signer{Tool: ToolMinisign, Key: "KEY", Password: "PASSWORD"}.Sign(context.Background(), "SHA256SUMS", getSecret, run)
+	NoError(t, err)
+	Equal(t, "SHA256SUMS.minisig", sig)
+	_, err = Signer{Tool: ToolMinisign, Key: "KEY"}.Sign(context.Background(), "SHA256SUMS", getSecret, run)
+	NoError(t, err)
+	Equal(t, []string{
+		"COSIGN_PASSWORD=hunter2 cosign sign-blob --yes --key KEYFILE --output-signature SHA256SUMS.sig SHA256SUMS",
+		"minisign -S -s KEYFILE -m SHA256SUMS -x SHA256SUMS.minisig",
+		"minisign -S -s KEYFILE -m SHA256SUMS -x SHA256SUMS.minisig -W",
+	}, calls)
+	Equal(t, []string{"", "hunter2\n", ""}, stdins)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// awaitName is the name the debugger is invoked as by the commands of WITH
+// DOCKER, to wait for the containers they start.
+const awaitName = "earthly-await"
+
+// awaitLogLines is how many lines of the logs of each container are printed if
+// the wait fails.
+const awaitLogLines = 100
+
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// runAwait waits for ports to accept connections and for URLs to respond with
+// a non-error status, as in --port localhost:5432 --http
+// http://localhost:8080/health --timeout 1m. If they do not in time, the logs
+// of the docker containers are printed.
+func runAwait(args []string) error {
+	fs := flag.NewFlagSet(awaitName, flag.ContinueOnError)
+	var ports, urls, containers stringSlice
+	fs.Var(&ports, "port", "A host:port to wait for to accept connections (may be repeated)")
+	fs.Var(&urls, "http", "A URL to wait for to respond with a non-error status (may be repeated)")
+	fs.Var(&containers, "container", "A container whose logs are printed on failure, instead of those of all containers (may be repeated)")
+	timeout := fs.Duration("timeout", time.Minute, "How long to wait for")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 && len(urls) == 0 {
+		return errors.New("nothing to wait for: specify --port or --http")
+	}
+	deadline := time.Now().Add(*timeout)
+	for _, p := range ports {
+		err = awaitUntil(deadline, func() error {
+			conn, err := net.DialTimeout("tcp", p, time.Second)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		})
+		if err != nil {
+			printContainerLogs(containers)
+			return errors.Wrapf(err, "port %s not ready after %s", p, *timeout)
+		}
+		fmt.Fprintf(os.Stderr, "%s is ready\n", p)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, u := range urls {
+		err = awaitUntil(deadline, func() error {
+			resp, err := client.Get(u)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				return errors.Errorf("status %s", resp.Status)
+			}
+			return nil
+		})
+		if err != nil {
+			printContainerLogs(containers)
+			return errors.Wrapf(err, "%s not ready after %s", u, *timeout)
+		}
+		fmt.Fprintf(os.Stderr, "%s is ready\n", u)
+	}
+	return nil
+}
+
+// awaitUntil retries check until it succeeds, or the deadline passes, in which
+// case the last error is returned.
+func awaitUntil(deadline time.Time, check func() error) error {
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// printContainerLogs prints the status and the end of the logs of the
+// containers, or of all containers if none are specified.
+func printContainerLogs(containers []string) {
+	if len(containers) == 0 {
+		out, err := exec.Command("docker", "ps", "--all", "--format", "{{.Names}}").Output()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: list containers: %v\n", awaitName, err)
+			return
+		}
+		containers = strings.Fields(string(out))
+	}
+	for _, c := range containers {
+		status, _ := exec.Command("docker", "inspect", "--format", "{{.State.Status}} (exit code {{.State.ExitCode}})", c).Output()
+		fmt.Fprintf(os.Stderr, "==== Logs of container %s: %s ====\n", c, strings.TrimSpace(string(status)))
+		cmd := exec.Command("docker", "logs", "--tail", fmt.Sprintf("%d", awaitLogLines), c)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		_ = cmd.Run()
+		fmt.Fprintf(os.Stderr, "==== End of logs of container %s ====\n", c)
+	}
+}
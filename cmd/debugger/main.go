@@ -8,6 +8,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"time"
 
 	"github.com/earthly/earthly/conslogging"
@@ -187,11 +188,44 @@ func getSettings(path string) (*common.DebuggerSettings, error) {
 func main() {
 	args := os.Args[1:]
 
+	if filepath.Base(os.Args[0]) == awaitName {
+		err := runAwait(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", awaitName, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if args[0] == "--version" {
 		fmt.Printf("version: %v-%v\n", Version, GitSha)
 		return
 	}
 
+	if args[0] == "--sandbox" {
+		err := runSandboxed(args[1:])
+		fmt.Fprintf(os.Stderr, "earth_debugger: %v\n", err)
+		os.Exit(1)
+	}
+
+	if args[0] == "--services" {
+		exitCode, err := runWithServices(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "earth_debugger: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(exitCode)
+	}
+
+	if args[0] == "--rerun-failed" {
+		exitCode, err := runWithReruns(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "earth_debugger: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(exitCode)
+	}
+
 	forceInteractive := false
 	if args[0] == "--force" {
 		args = args[1:]
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/earthly/earthly/debugger/common"
+	"github.com/earthly/earthly/util/junitutil"
+
+	"github.com/pkg/errors"
+)
+
+// runWithReruns runs the command, and if tests failed according to the JUnit
+// reports it writes, reruns it up to the given number of times while tests
+// remain failed, as per RUN --rerun-failed. The names of the failed tests are
+// passed to the reruns as EARTHLY_FAILED_TESTS, so that they can run only
+// those. Tests which pass when rerun are flaky: the command succeeds unless a
+// test which is not quarantined failed every attempt.
+func runWithReruns(args []string) (int, error) {
+	if len(args) < 3 || args[1] != "--" {
+		return 0, errors.New("usage: --rerun-failed <spec> -- cmd args...")
+	}
+	var spec common.RerunSpec
+	err := json.Unmarshal([]byte(args[0]), &spec)
+	if err != nil {
+		return 0, errors.Wrap(err, "unmarshal rerun spec")
+	}
+	cmdArgs := args[2:]
+	exitCode, err := runAttempt(cmdArgs, nil)
+	if err != nil || exitCode == 0 {
+		return exitCode, err
+	}
+	failed, err := reportedFailures(spec.Reports)
+	if err != nil {
+		return 0, err
+	}
+	if len(failed) == 0 {
+		fmt.Fprintf(os.Stderr, "earthly: the command failed, but no failed test was found in %s\n", strings.Join(spec.Reports, ", "))
+		return exitCode, nil
+	}
+
+	report := common.TestReport{Attempts: 1}
+	var flaky []junitutil.TestCase
+	otherFailure := false
+	for rerun := 1; rerun <= spec.Reruns && len(failed) != 0; rerun++ {
+		err = removeReports(spec.Reports)
+		if err != nil {
+			return 0, err
+		}
+		fmt.Fprintf(os.Stderr, "earthly: rerunning %d failed tests (rerun %d of %d): %s\n",
+			len(failed), rerun, spec.Reruns, strings.Join(testIDs(failed), " "))
+		env := []string{
+			fmt.Sprintf("EARTHLY_FAILED_TESTS=%s", strings.Join(testNames(failed), " ")),
+			fmt.Sprintf("EARTHLY_RERUN=%d", rerun),
+		}
+		exitCode, err = runAttempt(cmdArgs, env)
+		if err != nil {
+			return 0, err
+		}
+		report.Attempts++
+		if exitCode == 0 {
+			flaky = append(flaky, failed...)
+			failed = nil
+			break
+		}
+		again, err := reportedFailures(spec.Reports)
+		if err != nil {
+			return 0, err
+		}
+		if len(again) == 0 {
+			// The command failed for another reason than its tests.
+			otherFailure = true
+			break
+		}
+		stillFailed := intersectTests(failed, again)
+		// The tests which failed only now passed before: they are flaky too.
+		flaky = append(flaky, subtractTests(failed, stillFailed)...)
+		flaky = append(flaky, subtractTests(again, stillFailed)...)
+		failed = stillFailed
+	}
+	for _, tc := range failed {
+		if isQuarantined(spec.Quarantine, tc) {
+			report.Quarantined = append(report.Quarantined, tc.ID())
+		} else {
+			report.Failed = append(report.Failed, tc.ID())
+		}
+	}
+	report.Flaky = testIDs(subtractTests(uniqueTests(flaky), failed))
+	printTestReport(report)
+	if len(report.Failed) != 0 || otherFailure {
+		return exitCode, nil
+	}
+	return 0, nil
+}
+
+func runAttempt(args []string, env []string) (int, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return 0, errors.Wrapf(err, "run %s", args[0])
+		}
+		return exitErr.ExitCode(), nil
+	}
+	return 0, nil
+}
+
+// reportedFailures returns the failed tests of the JUnit reports matching the
+// patterns.
+func reportedFailures(patterns []string) ([]junitutil.TestCase, error) {
+	var failures []junitutil.TestCase
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid junit report pattern %s", pattern)
+		}
+		for _, m := range matches {
+			dt, err := ioutil.ReadFile(m)
+			if err != nil {
+				return nil, errors.Wrapf(err, "read %s", m)
+			}
+			f, err := junitutil.Failures(dt)
+			if err != nil {
+				return nil, errors.Wrapf(err, "read %s", m)
+			}
+			failures = append(failures, f...)
+		}
+	}
+	return uniqueTests(failures), nil
+}
+
+// removeReports removes the JUnit reports of the previous attempt, so that
+// they are not mistaken for those of the next one.
+func removeReports(patterns []string) error {
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, m := range matches {
+			err := os.Remove(m)
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "remove %s", m)
+			}
+		}
+	}
+	return nil
+}
+
+func printTestReport(report common.TestReport) {
+	if len(report.Flaky) != 0 {
+		fmt.Fprintf(os.Stderr, "earthly: flaky tests, which passed when rerun: %s\n", strings.Join(report.Flaky, " "))
+	}
+	if len(report.Quarantined) != 0 {
+		fmt.Fprintf(os.Stderr, "earthly: quarantined tests, which failed every attempt: %s\n", strings.Join(report.Quarantined, " "))
+	}
+	if len(report.Failed) != 0 {
+		fmt.Fprintf(os.Stderr, "earthly: tests which failed every attempt: %s\n", strings.Join(report.Failed, " "))
+	}
+	dt, err := json.Marshal(report)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s%s\n", common.TestReportPrefix, dt)
+}
+
+func isQuarantined(patterns []string, tc junitutil.TestCase) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, tc.Name); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, tc.ID()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueTests(tcs []junitutil.TestCase) []junitutil.TestCase {
+	seen := make(map[junitutil.TestCase]bool)
+	var ret []junitutil.TestCase
+	for _, tc := range tcs {
+		if !seen[tc] {
+			seen[tc] = true
+			ret = append(ret, tc)
+		}
+	}
+	return ret
+}
+
+func intersectTests(a, b []junitutil.TestCase) []junitutil.TestCase {
+	inB := make(map[junitutil.TestCase]bool)
+	for _, tc := range b {
+		inB[tc] = true
+	}
+	var ret []junitutil.TestCase
+	for _, tc := range a {
+		if inB[tc] {
+			ret = append(ret, tc)
+		}
+	}
+	return ret
+}
+
+func subtractTests(a, b []junitutil.TestCase) []junitutil.TestCase {
+	inB := make(map[junitutil.TestCase]bool)
+	for _, tc := range b {
+		inB[tc] = true
+	}
+	var ret []junitutil.TestCase
+	for _, tc := range a {
+		if !inB[tc] {
+			ret = append(ret, tc)
+		}
+	}
+	return ret
+}
+
+func testIDs(tcs []junitutil.TestCase) []string {
+	ids := make([]string, 0, len(tcs))
+	for _, tc := range tcs {
+		ids = append(ids, tc.ID())
+	}
+	return ids
+}
+
+// testNames returns the unique names of the tests, without their class names,
+// as most test runners select tests by name.
+func testNames(tcs []junitutil.TestCase) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, tc := range tcs {
+		if !seen[tc.Name] {
+			seen[tc.Name] = true
+			names = append(names, tc.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,183 @@
+// +build linux
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/earthly/earthly/debugger/common"
+	"github.com/pkg/errors"
+)
+
+// Not defined by the syscall package.
+const (
+	prSetNoNewPrivs      = 38
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+	capabilityVersion3   = 0x20080522
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// runSandboxed applies the security options of a RUN command, as in
+// --cap-drop=NET_RAW --no-new-privileges -- cmd args..., to the current
+// process, and then executes the command in its place. It only returns if that
+// fails.
+func runSandboxed(args []string) error {
+	fs := flag.NewFlagSet("sandbox", flag.ContinueOnError)
+	capDrop := fs.String("cap-drop", "", "")
+	capKeep := fs.String("cap-keep", "", "")
+	noNewPrivs := fs.Bool("no-new-privileges", false, "")
+	appArmor := fs.String("apparmor", "", "")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	cmdArgs := fs.Args()
+	if len(cmdArgs) == 0 {
+		return errors.New("no command to run")
+	}
+	keepSet := false
+	fs.Visit(func(f *flag.Flag) {
+		keepSet = keepSet || f.Name == "cap-keep"
+	})
+
+	drop := make(map[int]bool)
+	if *capDrop != "" {
+		for _, name := range strings.Split(*capDrop, ",") {
+			n, err := common.ParseCapability(name)
+			if err != nil {
+				return err
+			}
+			drop[n] = true
+		}
+	}
+	if keepSet {
+		keep := make(map[int]bool)
+		if *capKeep != "" {
+			for _, name := range strings.Split(*capKeep, ",") {
+				n, err := common.ParseCapability(name)
+				if err != nil {
+					return err
+				}
+				keep[n] = true
+			}
+		}
+		for n := 0; n <= lastCap(); n++ {
+			if !keep[n] {
+				drop[n] = true
+			}
+		}
+	}
+
+	if *appArmor != "" {
+		err := setAppArmorExecProfile(*appArmor)
+		if err != nil {
+			return err
+		}
+	}
+	if len(drop) != 0 {
+		err := dropCapabilities(drop)
+		if err != nil {
+			return err
+		}
+	}
+	if *noNewPrivs {
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0)
+		if errno != 0 {
+			return errors.Wrap(errno, "set no_new_privs")
+		}
+	}
+
+	path, err := exec.LookPath(cmdArgs[0])
+	if err != nil {
+		return errors.Wrapf(err, "look up %s", cmdArgs[0])
+	}
+	return errors.Wrapf(syscall.Exec(path, cmdArgs, os.Environ()), "execute %s", path)
+}
+
+// lastCap returns the number of the last capability supported by the kernel.
+func lastCap() int {
+	last := len(common.Capabilities) - 1
+	dt, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return last
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(dt)))
+	if err != nil {
+		return last
+	}
+	return n
+}
+
+// dropCapabilities removes the capabilities from the bounding set, so that the
+// command cannot regain them on exec, and from the current sets.
+func dropCapabilities(drop map[int]bool) error {
+	// Requires CAP_SETPCAP, and thus must happen before the current sets are
+	// changed.
+	for n := range drop {
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP, uintptr(n), 0, 0, 0, 0)
+		if errno != 0 && errno != syscall.EINVAL {
+			return errors.Wrapf(errno, "drop capability %s from the bounding set", capabilityName(n))
+		}
+	}
+	// Unsupported by old kernels, which have no ambient capabilities anyway.
+	_, _, _ = syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0)
+
+	hdr := capHeader{version: capabilityVersion3}
+	var data [2]capData
+	_, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return errors.Wrap(errno, "get capabilities")
+	}
+	for n := range drop {
+		mask := ^(uint32(1) << (uint(n) % 32))
+		d := &data[n/32]
+		d.effective &= mask
+		d.permitted &= mask
+		d.inheritable &= mask
+	}
+	_, _, errno = syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return errors.Wrap(errno, "set capabilities")
+	}
+	return nil
+}
+
+func capabilityName(n int) string {
+	if n < len(common.Capabilities) {
+		return common.Capabilities[n]
+	}
+	return strconv.Itoa(n)
+}
+
+// setAppArmorExecProfile makes the command confined by the AppArmor profile
+// once executed, as done by aa-exec.
+func setAppArmorExecProfile(profile string) error {
+	attr := "/proc/self/attr/apparmor/exec"
+	if _, err := os.Stat(attr); err != nil {
+		// Kernels older than 5.1.
+		attr = "/proc/self/attr/exec"
+	}
+	err := ioutil.WriteFile(attr, []byte("exec "+profile), 0)
+	if err != nil {
+		return errors.Wrapf(err, "change to AppArmor profile %s", profile)
+	}
+	return nil
+}
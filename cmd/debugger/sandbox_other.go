@@ -0,0 +1,10 @@
+// +build !linux
+
+package main
+
+import "github.com/pkg/errors"
+
+// runSandboxed is only supported within the Linux containers of RUN commands.
+func runSandboxed(args []string) error {
+	return errors.New("sandboxing is only supported on Linux")
+}
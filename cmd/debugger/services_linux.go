@@ -0,0 +1,322 @@
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/earthly/earthly/debugger/common"
+	"github.com/pkg/errors"
+)
+
+// serviceStopTimeout is how long services are given to exit gracefully once
+// the command is done.
+const serviceStopTimeout = 10 * time.Second
+
+// serviceDevices are created in the root filesystems of the services, which
+// do not have a /dev of their own.
+var serviceDevices = []struct {
+	path  string
+	major uint32
+	minor uint32
+}{
+	{"/dev/null", 1, 3},
+	{"/dev/zero", 1, 5},
+	{"/dev/random", 1, 8},
+	{"/dev/urandom", 1, 9},
+}
+
+type service struct {
+	spec    common.ServiceSpec
+	cmd     *exec.Cmd
+	logPath string
+	exited  chan struct{}
+	err     error
+}
+
+// runWithServices runs the command alongside the services, as in
+// <services JSON> -- cmd args..., and returns its exit code. The services are
+// started in the root filesystems mounted for them, share the network of the
+// command, and are waited for to accept connections on their ports. Their logs
+// are printed if the command fails.
+func runWithServices(args []string) (int, error) {
+	if len(args) < 3 || args[1] != "--" {
+		return 0, errors.New("usage: --services <services> -- cmd args...")
+	}
+	var specs []common.ServiceSpec
+	err := json.Unmarshal([]byte(args[0]), &specs)
+	if err != nil {
+		return 0, errors.Wrap(err, "unmarshal services")
+	}
+	var services []*service
+	defer func() {
+		for _, svc := range services {
+			svc.stop()
+		}
+	}()
+	for _, spec := range specs {
+		svc, err := startService(spec)
+		if err != nil {
+			return 0, err
+		}
+		services = append(services, svc)
+	}
+	for _, svc := range services {
+		err := svc.waitReady()
+		if err != nil {
+			printServiceLogs(services)
+			return 0, err
+		}
+	}
+
+	cmd := exec.Command(args[2], args[3:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		printServiceLogs(services)
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return 0, errors.Wrapf(err, "run %s", args[2])
+		}
+		return exitErr.ExitCode(), nil
+	}
+	return 0, nil
+}
+
+func startService(spec common.ServiceSpec) (*service, error) {
+	err := prepareServiceRoot(spec.Root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "prepare root of service %s", spec.Name)
+	}
+	logPath := spec.Root + ".log"
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create log of service %s", spec.Name)
+	}
+	defer logFile.Close()
+	cmdPath, err := lookPathIn(spec.Root, spec.Args[0], spec.Env)
+	if err != nil {
+		return nil, errors.Wrapf(err, "service %s", spec.Name)
+	}
+	cmd := &exec.Cmd{Path: cmdPath, Args: spec.Args}
+	cmd.Env = spec.Env
+	cmd.Dir = spec.WorkingDir
+	if cmd.Dir == "" {
+		cmd.Dir = "/"
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Chroot:  spec.Root,
+		Setpgid: true,
+	}
+	if spec.User != "" {
+		cred, err := lookupCredential(spec.Root, spec.User)
+		if err != nil {
+			return nil, errors.Wrapf(err, "service %s", spec.Name)
+		}
+		cmd.SysProcAttr.Credential = cred
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, errors.Wrapf(err, "start service %s", spec.Name)
+	}
+	svc := &service{spec: spec, cmd: cmd, logPath: logPath, exited: make(chan struct{})}
+	go func() {
+		svc.err = cmd.Wait()
+		close(svc.exited)
+	}()
+	return svc, nil
+}
+
+// prepareServiceRoot creates the devices and temporary directories the
+// service may need in its root filesystem.
+func prepareServiceRoot(root string) error {
+	for _, dir := range []string{"dev", "dev/shm", "tmp", "run"} {
+		err := os.MkdirAll(filepath.Join(root, dir), 0755)
+		if err != nil {
+			return err
+		}
+	}
+	for _, dir := range []string{"dev/shm", "tmp"} {
+		err := os.Chmod(filepath.Join(root, dir), 01777)
+		if err != nil {
+			return err
+		}
+	}
+	for _, d := range serviceDevices {
+		p := filepath.Join(root, d.path)
+		_ = os.Remove(p)
+		dev := int((d.major << 8) | d.minor)
+		err := syscall.Mknod(p, syscall.S_IFCHR|0666, dev)
+		if err != nil {
+			return errors.Wrapf(err, "create %s", d.path)
+		}
+		err = os.Chmod(p, 0666)
+		if err != nil {
+			return err
+		}
+	}
+	// So that the service resolves names as the command does.
+	for _, f := range []string{"/etc/hosts", "/etc/resolv.conf"} {
+		dt, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		_ = ioutil.WriteFile(filepath.Join(root, f), dt, 0644)
+	}
+	return nil
+}
+
+// lookPathIn resolves the executable of the service within its root
+// filesystem, via the PATH of its environment.
+func lookPathIn(root, name string, env []string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	pathEnv := "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "PATH=") {
+			pathEnv = strings.TrimPrefix(kv, "PATH=")
+		}
+	}
+	for _, dir := range filepath.SplitList(pathEnv) {
+		p := filepath.Join(dir, name)
+		// Lstat, as symlinks are relative to the root filesystem.
+		fi, err := os.Lstat(filepath.Join(root, p))
+		if err == nil && !fi.IsDir() {
+			return p, nil
+		}
+	}
+	return "", errors.Errorf("executable %s not found in %s", name, pathEnv)
+}
+
+// lookupCredential resolves the user, as in postgres, 999 or 999:999, via the
+// /etc/passwd and /etc/group of the root filesystem.
+func lookupCredential(root, user string) (*syscall.Credential, error) {
+	name, group := user, ""
+	if i := strings.IndexByte(user, ':'); i != -1 {
+		name, group = user[:i], user[i+1:]
+	}
+	uid, gid, err := lookupID(filepath.Join(root, "etc/passwd"), name)
+	if err != nil {
+		return nil, err
+	}
+	if group != "" {
+		gid, _, err = lookupID(filepath.Join(root, "etc/group"), group)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &syscall.Credential{Uid: uid, Gid: gid}, nil
+}
+
+// lookupID returns the ID of the named entry of /etc/passwd or /etc/group,
+// and the primary group of users. Numeric names are IDs already.
+func lookupID(file, name string) (uint32, uint32, error) {
+	if n, err := strconv.ParseUint(name, 10, 32); err == nil {
+		id := uint32(n)
+		gid := id
+		if _, g, err := lookupEntry(file, func(fields []string) bool { return fields[2] == name }); err == nil {
+			gid = g
+		}
+		return id, gid, nil
+	}
+	return lookupEntry(file, func(fields []string) bool { return fields[0] == name })
+}
+
+func lookupEntry(file string, match func(fields []string) bool) (uint32, uint32, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), ":")
+		if len(fields) < 3 || !match(fields) {
+			continue
+		}
+		id, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "parse %s", file)
+		}
+		gid := id
+		if len(fields) >= 4 {
+			if g, err := strconv.ParseUint(fields[3], 10, 32); err == nil {
+				gid = g
+			}
+		}
+		return uint32(id), uint32(gid), nil
+	}
+	return 0, 0, errors.Errorf("no such entry in %s", file)
+}
+
+// waitReady waits for the service to accept connections on its ports.
+func (svc *service) waitReady() error {
+	deadline := time.Now().Add(svc.spec.ReadyTimeout)
+	for _, port := range svc.spec.Ports {
+		addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+		for {
+			select {
+			case <-svc.exited:
+				return errors.Errorf("service %s exited before it was ready: %v", svc.spec.Name, svc.err)
+			default:
+			}
+			conn, err := net.DialTimeout("tcp", addr, time.Second)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				return errors.Errorf("service %s not ready after %s: port %d does not accept connections", svc.spec.Name, svc.spec.ReadyTimeout, port)
+			}
+			time.Sleep(250 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// stop terminates the service and its children, and kills them if they do
+// not exit in time.
+func (svc *service) stop() {
+	select {
+	case <-svc.exited:
+		return
+	default:
+	}
+	_ = syscall.Kill(-svc.cmd.Process.Pid, syscall.SIGTERM)
+	select {
+	case <-svc.exited:
+	case <-time.After(serviceStopTimeout):
+		_ = syscall.Kill(-svc.cmd.Process.Pid, syscall.SIGKILL)
+		<-svc.exited
+	}
+}
+
+func printServiceLogs(services []*service) {
+	for _, svc := range services {
+		f, err := os.Open(svc.logPath)
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			fmt.Fprintf(os.Stderr, "[service %s] %s\n", svc.spec.Name, s.Text())
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,11 @@
+// +build !linux
+
+package main
+
+import "github.com/pkg/errors"
+
+// runWithServices is only supported within the Linux containers of RUN
+// commands.
+func runWithServices(args []string) (int, error) {
+	return 0, errors.New("services are only supported on Linux")
+}
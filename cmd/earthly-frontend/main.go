@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/earthly/earthly/frontend"
+	"github.com/moby/buildkit/frontend/gateway/grpcclient"
+	"github.com/moby/buildkit/util/appcontext"
+)
+
+var (
+	// Version is the version of the frontend
+	Version string
+
+	// GitSha is the git sha used to build the frontend
+	GitSha string
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--version" {
+		fmt.Printf("earthly-frontend version %s %s\n", Version, GitSha)
+		return
+	}
+	err := grpcclient.RunFromEnvironment(appcontext.Context(), frontend.Build)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
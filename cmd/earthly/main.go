@@ -8,6 +8,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -21,12 +22,15 @@ import (
 	"regexp"
 	"runtime"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"text/tabwriter"
 	"time"
 
+	"github.com/alessio/shellescape"
 	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
 	"github.com/joho/godotenv"
@@ -50,27 +54,62 @@ import (
 	"golang.org/x/term"
 
 	"github.com/earthly/earthly/analytics"
+	"github.com/earthly/earthly/artifactstore"
 	"github.com/earthly/earthly/ast"
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/audit"
 	"github.com/earthly/earthly/autocomplete"
 	"github.com/earthly/earthly/buildcontext"
 	"github.com/earthly/earthly/buildcontext/provider"
+	"github.com/earthly/earthly/builddiff"
 	"github.com/earthly/earthly/builder"
 	"github.com/earthly/earthly/buildkitd"
+	"github.com/earthly/earthly/capabilities"
+	"github.com/earthly/earthly/catalog"
+	"github.com/earthly/earthly/checksums"
 	"github.com/earthly/earthly/cleanup"
 	"github.com/earthly/earthly/config"
 	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/coverage"
 	debuggercommon "github.com/earthly/earthly/debugger/common"
 	"github.com/earthly/earthly/debugger/terminal"
+	"github.com/earthly/earthly/devenv"
+	"github.com/earthly/earthly/diagnostics"
 	"github.com/earthly/earthly/docker2earthly"
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/earthfile2llb"
+	"github.com/earthly/earthly/earthfiledoc"
+	"github.com/earthly/earthly/gitops"
+	"github.com/earthly/earthly/imagebudget"
+	"github.com/earthly/earthly/imageindex"
+	"github.com/earthly/earthly/imageverify"
+	"github.com/earthly/earthly/leakscan"
+	"github.com/earthly/earthly/lint"
+	"github.com/earthly/earthly/mock"
+	"github.com/earthly/earthly/monorepo"
+	"github.com/earthly/earthly/preview"
+	"github.com/earthly/earthly/privileged"
+	"github.com/earthly/earthly/releaser"
+	"github.com/earthly/earthly/remotesource"
 	"github.com/earthly/earthly/secretsclient"
+	"github.com/earthly/earthly/selftest"
 	"github.com/earthly/earthly/states"
+	"github.com/earthly/earthly/states/dedup"
+	"github.com/earthly/earthly/targetpicker"
+	"github.com/earthly/earthly/telemetry"
+	"github.com/earthly/earthly/upgrade"
 	"github.com/earthly/earthly/util/cliutil"
 	"github.com/earthly/earthly/util/fileutil"
+	"github.com/earthly/earthly/util/githubapp"
+	"github.com/earthly/earthly/util/gitutil"
 	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/registryutil"
+	"github.com/earthly/earthly/util/retryutil"
+	"github.com/earthly/earthly/util/semverutil"
 	"github.com/earthly/earthly/util/termutil"
+	"github.com/earthly/earthly/util/wslutil"
 	"github.com/earthly/earthly/variables"
+	"github.com/earthly/earthly/vm"
 )
 
 const (
@@ -88,6 +127,9 @@ type earthlyApp struct {
 	cfg         *config.Config
 	sessionID   string
 	commandName string
+	// sideEffects is set once the current build attempt pushed or ran a
+	// LOCALLY command, after which it is not retried on another host.
+	sideEffects int32
 	cliFlags
 }
 
@@ -99,9 +141,28 @@ type cliFlags struct {
 	artifactMode              bool
 	imageMode                 bool
 	pull                      bool
+	offline                   bool
+	workspacePath             string
+	importOverrides           cli.StringSlice
+	mockPath                  string
+	mockRecordPath            string
+	mock                      *mock.Config
+	mockRecorder              *mock.Recorder
+	sourceDateEpochStr        string
+	sourceDateEpoch           *time.Time
+	hostname                  string
+	defaultUser               string
+	dnsServers                cli.StringSlice
+	dnsSearch                 cli.StringSlice
+	addHosts                  cli.StringSlice
+	randomSeed                string
 	push                      bool
 	ci                        bool
 	noOutput                  bool
+	noLoad                    bool
+	outputOnly                cli.StringSlice
+	contextSource             string
+	buildContexts             cli.StringSlice
 	noCache                   bool
 	pruneAll                  bool
 	pruneReset                bool
@@ -116,6 +177,9 @@ type cliFlags struct {
 	maxRemoteCache            bool
 	saveInlineCache           bool
 	useInlineCache            bool
+	compression               string
+	compressionLevel          int
+	forceCompression          bool
 	configPath                string
 	gitUsernameOverride       string
 	gitPasswordOverride       string
@@ -126,6 +190,8 @@ type cliFlags struct {
 	homebrewSource            string
 	bootstrapNoBuildkit       bool
 	bootstrapWithAutocomplete bool
+	bootstrapPlatforms        cli.StringSlice
+	prefetchAll               bool
 	email                     string
 	token                     string
 	password                  string
@@ -137,6 +203,7 @@ type cliFlags struct {
 	registrationPublicKey     string
 	dockerfilePath            string
 	earthfilePath             string
+	bakePath                  string
 	earthfileFinalImage       string
 	expiry                    string
 	termsConditionsPrivacy    bool
@@ -145,12 +212,94 @@ type cliFlags struct {
 	enableSourceMap           bool
 	configDryRun              bool
 	strict                    bool
+	buildTimeout              time.Duration
 	conversionParllelism      int
 	debuggerHost              string
 	certPath                  string
 	keyPath                   string
 	disableAnalytics          bool
 	featureFlagOverrides      string
+	auditLogPath              string
+	auditLogKeyPath           string
+	locallyAccepted           cli.StringSlice
+	locallyEnforce            bool
+	approvePrivileged         bool
+	privilegedAllowlist       string
+	testReportPath            string
+	contextSizeLimitMb        int
+	artifactStore             bool
+	checksumsPath             string
+	checksumsSign             string
+	scanLeaks                 bool
+	scanLeaksAllow            cli.StringSlice
+	artifactGCKeep            int
+	artifactGCOlderThan       time.Duration
+	gitOpsRepo                string
+	gitOpsBranch              string
+	gitOpsFiles               cli.StringSlice
+	gitOpsPR                  bool
+	releaseVersion            string
+	releaseTarget             string
+	releaseAssets             cli.StringSlice
+	releaseProvider           string
+	releaseRemote             string
+	releaseDryRun             bool
+	releaseRestart            bool
+	changelogFrom             string
+	changelogTo               string
+	changelogOutput           string
+	coverageOutput            string
+	coverageFailUnder         float64
+	multiPattern              string
+	multiSince                string
+	multiShared               cli.StringSlice
+	multiResults              []monorepo.Result
+	multiTargets              []domain.Target
+	selftestRun               string
+	selftestCacheNamespace    string
+	selftestCases             []selftest.Case
+	diffBaseRef               string
+	diffHeadRef               string
+	diffBaseBuildArgs         cli.StringSlice
+	diffHeadBuildArgs         cli.StringSlice
+	diffOutput                string
+	diffRoot                  string
+	diffSnapshot              *builddiff.Snapshot
+	devImageTag               string
+	devContainerJSON          bool
+	devNoRun                  bool
+	devShell                  string
+	devPorts                  cli.StringSlice
+	upComposeFrom             string
+	previewPR                 int
+	previewManifest           string
+	previewListenAddr         string
+	previewWebhookSecret      string
+	promoteVerify             string
+	promoteForce              bool
+	registryGCKeepLast        int
+	registryGCMatch           cli.StringSlice
+	registryGCDryRun          bool
+	vmCPUs                    int
+	vmMemoryGB                int
+	vmDiskGB                  int
+	otherTargets              []string
+	otherTargetFlagArgs       [][]string
+	selectTarget              bool
+	lintDisable               cli.StringSlice
+	lintListRules             bool
+	lintShellCheck            bool
+	docMarkdown               bool
+	catalogJSON               bool
+	runnerPort                int
+	runnerIdentityFile        string
+	runnerWorkdir             string
+	runnerNoCheck             bool
+	errorCategory             string
+	panicStack                string
+	bugReportOutput           string
+	upgradeChannel            string
+	upgradeVersion            string
 }
 
 var (
@@ -162,8 +311,14 @@ var (
 
 	// GitSha contains the git sha used to build this app
 	GitSha string
+
+	// ReleasePublicKey is the base64 encoded ed25519 public key which the
+	// checksums of releases are signed with, as verified by earthly upgrade.
+	ReleasePublicKey string
 )
 
+var runnerNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 func profhandler() {
 	addr := "127.0.0.1:6060"
 	fmt.Printf("listening for pprof on %s\n", addr)
@@ -250,6 +405,9 @@ func main() {
 		displayErrors := app.verbose
 		analytics.CollectAnalytics(ctxTimeout, app.apiServer, displayErrors, Version, getPlatform(), GitSha, app.commandName, exitCode, time.Since(startTime))
 	}
+	if app.cfg != nil && app.cfg.Global.TelemetryEnabled {
+		app.recordTelemetry(ctx)
+	}
 	os.Exit(exitCode)
 }
 
@@ -349,6 +507,85 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       "Force pull any referenced Docker images",
 			Destination: &app.pull,
 		},
+		&cli.BoolFlag{
+			Name:        "offline",
+			EnvVars:     []string{"EARTHLY_OFFLINE"},
+			Usage:       "Fail fast if the build requires network access, resolving images only from previous builds or earthly prefetch",
+			Destination: &app.offline,
+		},
+		&cli.StringFlag{
+			Name:        "workspace",
+			EnvVars:     []string{"EARTHLY_WORKSPACE"},
+			Usage:       wrap("The earthly.work file mapping remote repositories to local checkouts, or off", "(by default, it is looked up in the current directory and its parents)"),
+			Destination: &app.workspacePath,
+		},
+		&cli.StringSliceFlag{
+			Name:        "import-override",
+			EnvVars:     []string{"EARTHLY_IMPORT_OVERRIDE"},
+			Usage:       wrap("Build references to a remote repository out of a local path, as in github.com/org/lib=../lib", "(takes precedence over the workspace; may be repeated)"),
+			Destination: &app.importOverrides,
+		},
+		&cli.StringFlag{
+			Name:        "mock",
+			EnvVars:     []string{"EARTHLY_MOCK"},
+			Usage:       wrap("Run in mock mode, stubbing remote imports and secrets as per the given mock file", "and recording pushes instead of performing them"),
+			Destination: &app.mockPath,
+		},
+		&cli.StringFlag{
+			Name:        "mock-record",
+			EnvVars:     []string{"EARTHLY_MOCK_RECORD"},
+			Usage:       "Write the pushes and secrets recorded in mock mode to the given JSON file",
+			Destination: &app.mockRecordPath,
+		},
+		&cli.StringFlag{
+			Name:        "source-date-epoch",
+			EnvVars:     []string{"EARTHLY_SOURCE_DATE_EPOCH", "SOURCE_DATE_EPOCH"},
+			Usage:       wrap("Pin the clock of the build to the given unix timestamp, exported as SOURCE_DATE_EPOCH to RUN commands", "and used as the creation time of saved images"),
+			Destination: &app.sourceDateEpochStr,
+		},
+		&cli.StringFlag{
+			Name:        "hostname",
+			EnvVars:     []string{"EARTHLY_HOSTNAME"},
+			Usage:       "The hostname of the containers of RUN commands",
+			Destination: &app.hostname,
+		},
+		&cli.StringFlag{
+			Name:    "default-user",
+			EnvVars: []string{"EARTHLY_DEFAULT_USER"},
+			Usage: wrap("The non-root user, as in 1000:1000, targets switch to after they start FROM an image which runs as root, ",
+				"unless they switch back via USER. Overrides default_user of the config"),
+			Destination: &app.defaultUser,
+		},
+		&cli.StringSliceFlag{
+			Name:    "dns",
+			EnvVars: []string{"EARTHLY_DNS"},
+			Usage:   wrap("The IP of a DNS server of the containers of RUN commands, instead of those of buildkitd", "(may be repeated)"),
+			Value:   &app.dnsServers,
+		},
+		&cli.StringSliceFlag{
+			Name:    "dns-search",
+			EnvVars: []string{"EARTHLY_DNS_SEARCH"},
+			Usage:   wrap("A DNS search domain of the containers of RUN commands", "(may be repeated; requires --dns)"),
+			Value:   &app.dnsSearch,
+		},
+		&cli.StringSliceFlag{
+			Name:    "add-host",
+			EnvVars: []string{"EARTHLY_ADD_HOST"},
+			Usage:   wrap("An entry of /etc/hosts of the containers of RUN commands, as in host:ip", "(may be repeated)"),
+			Value:   &app.addHosts,
+		},
+		&cli.StringFlag{
+			Name:        "random-seed",
+			EnvVars:     []string{"EARTHLY_RANDOM_SEED"},
+			Usage:       wrap("Seed the randomization of RUN commands with the given integer,", "exported as EARTHLY_RANDOM_SEED and PYTHONHASHSEED"),
+			Destination: &app.randomSeed,
+		},
+		&cli.BoolFlag{
+			Name:        "select",
+			EnvVars:     []string{"EARTHLY_SELECT"},
+			Usage:       "Pick the target to build from a list of the targets of the Earthfile",
+			Destination: &app.selectTarget,
+		},
 		&cli.BoolFlag{
 			Name:        "push",
 			EnvVars:     []string{"EARTHLY_PUSH"},
@@ -367,6 +604,30 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       wrap("Do not output artifacts or images", "(using --push is still allowed)"),
 			Destination: &app.noOutput,
 		},
+		&cli.BoolFlag{
+			Name:        "no-load",
+			EnvVars:     []string{"EARTHLY_NO_LOAD"},
+			Usage:       wrap("Do not load images into the local docker daemon", "(images are pushed directly from the cache when using --push)"),
+			Destination: &app.noLoad,
+		},
+		&cli.StringSliceFlag{
+			Name:    "output-only",
+			EnvVars: []string{"EARTHLY_OUTPUT_ONLY"},
+			Usage:   wrap("Only output the artifacts and images of the given target", "(may be repeated; using --push is still allowed for the others)"),
+			Value:   &app.outputOnly,
+		},
+		&cli.StringFlag{
+			Name:        "context",
+			EnvVars:     []string{"EARTHLY_CONTEXT"},
+			Usage:       wrap("Use a pre-assembled context for local targets instead of the current directory", "(dir=<path>, tar://<path>, or tar://- to read a tarball from stdin)"),
+			Destination: &app.contextSource,
+		},
+		&cli.StringSliceFlag{
+			Name:    "build-context",
+			EnvVars: []string{"EARTHLY_BUILD_CONTEXT"},
+			Usage:   wrap("An additional named build context, as in vendor=../vendor, which COPY --from-context copies from", "(may be repeated)"),
+			Value:   &app.buildContexts,
+		},
 		&cli.BoolFlag{
 			Name:        "no-cache",
 			EnvVars:     []string{"EARTHLY_NO_CACHE"},
@@ -510,6 +771,24 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       wrap("Attempt to use any inline cache that may have been previously pushed ", "uses image tags referenced by SAVE IMAGE --push or SAVE IMAGE --cache-from", "*experimental*"),
 			Destination: &app.useInlineCache,
 		},
+		&cli.StringFlag{
+			Name:        "compression",
+			EnvVars:     []string{"EARTHLY_COMPRESSION"},
+			Usage:       wrap("The compression of the layers of the images exported: gzip, zstd, estargz (for lazy pulling) or uncompressed ", "(zstd and estargz require support from buildkitd)"),
+			Destination: &app.compression,
+		},
+		&cli.IntFlag{
+			Name:        "compression-level",
+			EnvVars:     []string{"EARTHLY_COMPRESSION_LEVEL"},
+			Usage:       "The compression level of the layers of the images exported (1-9 for gzip and estargz, 1-22 for zstd)",
+			Destination: &app.compressionLevel,
+		},
+		&cli.BoolFlag{
+			Name:        "force-compression",
+			EnvVars:     []string{"EARTHLY_FORCE_COMPRESSION"},
+			Usage:       "Recompress the layers of the images exported which are compressed otherwise, such as those of base images",
+			Destination: &app.forceCompression,
+		},
 		&cli.BoolFlag{
 			Name:        "interactive",
 			Aliases:     []string{"i"},
@@ -553,12 +832,113 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       "Disallow usage of features that may create unrepeatable builds",
 			Destination: &app.strict,
 		},
+		&cli.DurationFlag{
+			Name:        "timeout",
+			EnvVars:     []string{"EARTHLY_TIMEOUT"},
+			Usage:       "Fail the build if it does not complete within the given duration, e.g. 1h",
+			Destination: &app.buildTimeout,
+		},
 		&cli.IntFlag{
 			Name:        "conversion-parallelism",
 			EnvVars:     []string{"EARTHLY_CONVERSION_PARALLELISM"},
 			Usage:       "Set the conversion parallelism, which speeds up the use of IF, WITH DOCKER --load, FROM DOCKERFILE and others. A value of 0 disables the feature *experimental*",
 			Destination: &app.conversionParllelism,
 		},
+		&cli.StringSliceFlag{
+			Name:    "locally-accept",
+			EnvVars: []string{"EARTHLY_LOCALLY_ACCEPT"},
+			Usage: wrap("Accept the host access declared by LOCALLY targets, without prompting. ",
+				"Valid values are fs=<path>, network and docker. The declarations are advisory: LOCALLY commands are not sandboxed"),
+			Destination: &app.locallyAccepted,
+		},
+		&cli.BoolFlag{
+			Name:        "locally-enforce",
+			EnvVars:     []string{"EARTHLY_LOCALLY_ENFORCE"},
+			Usage:       "Require LOCALLY targets to declare the host access they need, and fail on any declaration not accepted via --locally-accept, without prompting",
+			Destination: &app.locallyEnforce,
+		},
+		&cli.BoolFlag{
+			Name:    "approve-privileged",
+			EnvVars: []string{"EARTHLY_APPROVE_PRIVILEGED"},
+			Usage: wrap("Allow privileged commands and host mounts, but require each of them to be approved, ",
+				"either interactively or via the privileged allowlist"),
+			Destination: &app.approvePrivileged,
+		},
+		&cli.StringFlag{
+			Name:    "privileged-allowlist",
+			EnvVars: []string{"EARTHLY_PRIVILEGED_ALLOWLIST"},
+			Usage: wrap("Path to a signed allowlist of the targets and commands approved to run privileged or mount host paths. ",
+				"Implies --approve-privileged"),
+			Destination: &app.privilegedAllowlist,
+		},
+		&cli.StringFlag{
+			Name:    "test-report",
+			EnvVars: []string{"EARTHLY_TEST_REPORT"},
+			Usage: wrap("Path to write the flaky, failed and quarantined tests of the RUN --junit commands to, ",
+				"as JSON"),
+			Destination: &app.testReportPath,
+		},
+		&cli.IntFlag{
+			Name:        "context-size-limit-mb",
+			EnvVars:     []string{"EARTHLY_CONTEXT_SIZE_LIMIT_MB"},
+			Usage:       "Fail the build when a local build context exceeds this size, in MB. Overrides context_size_limit_mb of the config",
+			Destination: &app.contextSizeLimitMb,
+		},
+		&cli.StringFlag{
+			Name:        "gitops-repo",
+			EnvVars:     []string{"EARTHLY_GITOPS_REPO"},
+			Usage:       "A git repository (e.g. github.com/org/deploy) in which to update the digests of the images pushed, after a successful --push",
+			Destination: &app.gitOpsRepo,
+		},
+		&cli.StringFlag{
+			Name:        "gitops-branch",
+			EnvVars:     []string{"EARTHLY_GITOPS_BRANCH"},
+			Usage:       "The branch of the --gitops-repo to update",
+			Value:       "main",
+			Destination: &app.gitOpsBranch,
+		},
+		&cli.StringSliceFlag{
+			Name:    "gitops-file",
+			EnvVars: []string{"EARTHLY_GITOPS_FILES"},
+			Usage:   "A kustomization.yaml or Helm values file of the --gitops-repo in which to update the image digests",
+			Value:   &app.gitOpsFiles,
+		},
+		&cli.BoolFlag{
+			Name:        "gitops-pr",
+			EnvVars:     []string{"EARTHLY_GITOPS_PR"},
+			Usage:       "Open a pull request against the --gitops-branch rather than pushing to it (GitHub only)",
+			Destination: &app.gitOpsPR,
+		},
+		&cli.BoolFlag{
+			Name:        "artifact-store",
+			EnvVars:     []string{"EARTHLY_ARTIFACT_STORE"},
+			Usage:       "Keep a copy of every artifact saved locally in the content-addressed artifact store (see earthly artifact)",
+			Destination: &app.artifactStore,
+		},
+		&cli.StringFlag{
+			Name:        "checksums",
+			EnvVars:     []string{"EARTHLY_CHECKSUMS"},
+			Usage:       "Write the SHA256 checksums of all the artifacts saved locally by the build to this file (e.g. dist/SHA256SUMS), in the format of sha256sum",
+			Destination: &app.checksumsPath,
+		},
+		&cli.StringFlag{
+			Name:        "checksums-sign",
+			EnvVars:     []string{"EARTHLY_CHECKSUMS_SIGN"},
+			Usage:       "Sign the --checksums file with a key held by a secret, as in cosign:key=<secret>[,password=<secret>] or minisign:key=<secret>[,password=<secret>]",
+			Destination: &app.checksumsSign,
+		},
+		&cli.BoolFlag{
+			Name:        "scan-leaks",
+			EnvVars:     []string{"EARTHLY_SCAN_LEAKS"},
+			Usage:       "Scan the layers of the images for leaked secrets before pushing them, and refuse to push if any is found",
+			Destination: &app.scanLeaks,
+		},
+		&cli.StringSliceFlag{
+			Name:    "scan-leaks-allow",
+			EnvVars: []string{"EARTHLY_SCAN_LEAKS_ALLOW"},
+			Usage:   "A pattern of the paths of files in images which --scan-leaks does not scan, as in /app/testdata/*",
+			Value:   &app.scanLeaksAllow,
+		},
 		&cli.BoolFlag{
 			EnvVars:     []string{"EARTHLY_DISABLE_ANALYTICS", "DO_NOT_TRACK"},
 			Usage:       "Disable collection of analytics",
@@ -571,6 +951,18 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Destination: &app.featureFlagOverrides,
 			Hidden:      true, // used for feature-flipping from ./earthly dev script
 		},
+		&cli.StringFlag{
+			Name:        "audit-log",
+			EnvVars:     []string{"EARTHLY_AUDIT_LOG"},
+			Usage:       "Append a record of every push, local file write, LOCALLY command and secret access to this file, as JSON lines",
+			Destination: &app.auditLogPath,
+		},
+		&cli.StringFlag{
+			Name:        "audit-log-key",
+			EnvVars:     []string{"EARTHLY_AUDIT_LOG_KEY"},
+			Usage:       "Path to a file containing a key used to sign audit log entries (HMAC-SHA256)",
+			Destination: &app.auditLogKeyPath,
+		},
 	}
 
 	app.cliApp.Commands = []*cli.Command{
@@ -596,6 +988,11 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 					Usage:       "Add earthly autocompletions",
 					Destination: &app.bootstrapWithAutocomplete,
 				},
+				&cli.StringSliceFlag{
+					Name:        "platform",
+					Usage:       "Install and verify emulation support for the given foreign platform (can be specified multiple times)",
+					Destination: &app.bootstrapPlatforms,
+				},
 			},
 		},
 		{
@@ -644,6 +1041,29 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 				},
 			},
 		},
+		{
+			Name:  "bake2earthly",
+			Usage: "Convert a docker-bake file into an Earthfile *experimental*",
+			Description: `Converts the groups and targets of a docker-bake file (in HCL or JSON) into an Earthfile.
+	Each bake target becomes a target building its Dockerfile via FROM DOCKERFILE, each group a target
+	building its targets, and each variable a global ARG.`,
+			UsageText: "earthly [options] bake2earthly [--bake <path>] [--earthfile <path>]",
+			Action:    app.actionBake2Earthly,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "bake",
+					Usage:       "Path to the docker-bake file input",
+					Value:       "docker-bake.hcl",
+					Destination: &app.bakePath,
+				},
+				&cli.StringFlag{
+					Name:        "earthfile",
+					Usage:       "Path to earthfile output, or - for stdout",
+					Value:       "Earthfile",
+					Destination: &app.earthfilePath,
+				},
+			},
+		},
 		{
 			Name:  "org",
 			Usage: "Earthly organization administration *experimental*",
@@ -893,74 +1313,716 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			},
 		},
 		{
-			Name:        "prune",
-			Usage:       "Prune Earthly build cache",
-			Description: "Prune Earthly build cache",
-			Action:      app.actionPrune,
+			Name:  "audit",
+			Usage: "Inspect Earthly audit logs",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "verify",
+					Usage:     "Verify that an audit log has not been tampered with",
+					UsageText: "earthly [options] audit verify [<path>]",
+					Action:    app.actionAuditVerify,
+				},
+			},
+		},
+		{
+			Name:  "artifact",
+			Usage: "Inspect and retrieve artifacts kept in the local artifact store",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "ls",
+					Usage:     "List the stored artifacts, newest first",
+					UsageText: "earthly [options] artifact ls [<artifact>]",
+					Action:    app.actionArtifactLs,
+				},
+				{
+					Name:      "get",
+					Usage:     "Write a stored artifact to the given destination",
+					UsageText: "earthly [options] artifact get <id>|<artifact>[@<git-commit>] <dest>",
+					Action:    app.actionArtifactGet,
+				},
+				{
+					Name:      "gc",
+					Usage:     "Remove old artifacts from the store",
+					UsageText: "earthly [options] artifact gc [--keep <n>] [--older-than <duration>]",
+					Action:    app.actionArtifactGC,
+					Flags: []cli.Flag{
+						&cli.IntFlag{
+							Name:        "keep",
+							Usage:       "The number of newest versions kept of each artifact; 0 keeps all versions",
+							Value:       10,
+							Destination: &app.artifactGCKeep,
+						},
+						&cli.DurationFlag{
+							Name:        "older-than",
+							Usage:       "Also remove the artifacts stored longer ago than this duration (e.g. 720h)",
+							Destination: &app.artifactGCOlderThan,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "registry",
+			Usage: "Maintain the images pushed to registries",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "gc",
+					Usage: "Delete stale tags, such as those pushed by dev builds",
+					Description: `Deletes the tags of the given repositories (by default, those of the registry_gc_repositories config)
+	 which match --match, except for the --keep-last most recent ones. Images which are also referred to by a tag
+	 which is kept are not deleted.`,
+					UsageText: "earthly [options] registry gc --match <pattern> [--keep-last <n>] [--dry-run] [<repository>...]",
+					Action:    app.actionRegistryGC,
+					Flags: []cli.Flag{
+						&cli.StringSliceFlag{
+							Name:        "match",
+							Usage:       "A glob pattern (e.g. dev-*) of the tags to delete; may be repeated",
+							Destination: &app.registryGCMatch,
+						},
+						&cli.IntFlag{
+							Name:        "keep-last",
+							Usage:       "The number of most recent matching tags kept in each repository",
+							Value:       20,
+							Destination: &app.registryGCKeepLast,
+						},
+						&cli.BoolFlag{
+							Name:        "dry-run",
+							Usage:       "Only print the tags which would be deleted",
+							Destination: &app.registryGCDryRun,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "vm",
+			Usage: "Manage the Linux VM which runs docker and buildkitd on macOS, when the vm config is enabled",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "start",
+					Usage:     "Start the VM, creating it first if needed",
+					UsageText: "earthly [options] vm start",
+					Action:    app.actionVMStart,
+				},
+				{
+					Name:      "stop",
+					Usage:     "Stop the VM; its disk, and the cache it holds, are kept",
+					UsageText: "earthly [options] vm stop",
+					Action:    app.actionVMStop,
+				},
+				{
+					Name:      "resize",
+					Usage:     "Change the CPUs, memory or disk of the VM, restarting it if needed",
+					UsageText: "earthly [options] vm resize [--cpus <n>] [--memory <gb>] [--disk <gb>]",
+					Action:    app.actionVMResize,
+					Flags: []cli.Flag{
+						&cli.IntFlag{
+							Name:        "cpus",
+							Usage:       "The number of CPUs of the VM",
+							Destination: &app.vmCPUs,
+						},
+						&cli.IntFlag{
+							Name:        "memory",
+							Usage:       "The memory of the VM, in Gigabytes",
+							Destination: &app.vmMemoryGB,
+						},
+						&cli.IntFlag{
+							Name:        "disk",
+							Usage:       "The size of the disk of the VM, in Gigabytes; disks can only grow",
+							Destination: &app.vmDiskGB,
+						},
+					},
+				},
+				{
+					Name:      "status",
+					Usage:     "Print the status and resources of the VM",
+					UsageText: "earthly [options] vm status",
+					Action:    app.actionVMStatus,
+				},
+			},
+		},
+		{
+			Name:  "preview",
+			Usage: "Manage the ephemeral preview environments of pull requests",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "create",
+					Usage: "Deploy the preview environment of a pull request",
+					Description: `Builds the preview target (by default, +preview) with --push and the build arg PREVIEW_ID=pr-<n>,
+	 and then renders its manifest template and applies it to the namespace of the pull request, in the cluster of the
+	 preview_kube_context config. Running it again updates the environment.`,
+					UsageText: "earthly [options] preview create --pr <n> [--manifest <path>] [<target-ref>] [--<build-arg-key>=<build-arg-value>...]",
+					Action:    app.actionPreviewCreate,
+					Flags: []cli.Flag{
+						&cli.IntFlag{
+							Name:        "pr",
+							Usage:       "The number of the pull request",
+							Destination: &app.previewPR,
+						},
+						&cli.StringFlag{
+							Name:        "manifest",
+							Usage:       "The manifest template, relative to the directory of the Earthfile",
+							Value:       preview.DefaultManifest,
+							Destination: &app.previewManifest,
+						},
+					},
+				},
+				{
+					Name:      "destroy",
+					Usage:     "Delete the preview environment of a pull request",
+					UsageText: "earthly [options] preview destroy --pr <n>",
+					Action:    app.actionPreviewDestroy,
+					Flags: []cli.Flag{
+						&cli.IntFlag{
+							Name:        "pr",
+							Usage:       "The number of the pull request",
+							Destination: &app.previewPR,
+						},
+					},
+				},
+				{
+					Name:  "listen",
+					Usage: "Destroy preview environments as their pull requests are closed",
+					Description: `Listens for the pull_request webhooks of GitHub, and destroys the preview environment of each pull
+	 request which is closed, whether merged or not.`,
+					UsageText: "earthly [options] preview listen [--addr <address>] --webhook-secret <secret>",
+					Action:    app.actionPreviewListen,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:        "addr",
+							Usage:       "The address to listen on",
+							Value:       ":8080",
+							Destination: &app.previewListenAddr,
+						},
+						&cli.StringFlag{
+							Name:        "webhook-secret",
+							EnvVars:     []string{"EARTHLY_PREVIEW_WEBHOOK_SECRET"},
+							Usage:       "The secret of the webhook, which its payloads are signed with",
+							Destination: &app.previewWebhookSecret,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "doc",
+			Usage: "Document the targets, ARGs and user commands of an Earthfile",
+			Description: `Prints the doc comments of the targets, ARGs and user commands of an Earthfile, or of a single target.
+	 Doc comments are the comment lines directly above a target, user command or ARG.`,
+			UsageText: "earthly [options] doc [--markdown] [<earthfile-dir>|+<target-name>|<earthfile-dir>+<target-name>]",
+			Action:    app.actionDoc,
 			Flags: []cli.Flag{
 				&cli.BoolFlag{
-					Name:        "all",
-					Aliases:     []string{"a"},
-					EnvVars:     []string{"EARTHLY_PRUNE_ALL"},
-					Usage:       "Prune all cache",
-					Destination: &app.pruneAll,
+					Name:        "markdown",
+					Usage:       "Output Markdown instead of text for the terminal",
+					Destination: &app.docMarkdown,
 				},
-				&cli.BoolFlag{
-					Name:        "reset",
-					EnvVars:     []string{"EARTHLY_PRUNE_RESET"},
-					Usage:       "Reset cache entirely by wiping cache dir",
-					Destination: &app.pruneReset,
+			},
+		},
+		{
+			Name:  "catalog",
+			Usage: "Discover the targets of the repositories of an organization",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "update",
+					Usage: "Index the targets of the configured repositories into the local catalog",
+					Description: `Fetches the given repositories (by default, those of the catalog config), as builds resolve them,
+	 and indexes the targets of all their Earthfiles, along with their doc comments, into the local catalog.`,
+					UsageText: "earthly [options] catalog update [<repo>...]",
+					Action:    app.actionCatalogUpdate,
+				},
+				{
+					Name:      "search",
+					Usage:     "Search the local catalog for targets",
+					UsageText: "earthly [options] catalog search [--json] [<word>...]",
+					Action:    app.actionCatalogSearch,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:        "json",
+							Usage:       "Output the matching targets as JSON",
+							Destination: &app.catalogJSON,
+						},
+					},
 				},
 			},
 		},
 		{
-			Name:   "config",
-			Usage:  "Edits your Earthly configuration file",
-			Action: app.actionConfig,
-			UsageText: `This command takes a path, and a value and sets it in your configuration file.
-
-	 As the configuration file is YAML, the key must be a valid key within the file. You can specify sub-keys by using "." to separate levels.
-	 If the sub-key you wish to use has a "." in it, you can quote that subsection, like this: git."github.com".
-
-	 Values must be valid YAML, and also be deserializable into the key you wish to assign them to.
-	 This means you can set higher level objects using a compact style, or single values with simple values.
-
-	 Only one key/value can be set per invocation.
-
-	 To get help with a specific key, do "config [key] --help". Or, visit https://docs.earthly.dev/earthly-config for more details.`,
-			Description: `Set your cache size:
-
-	config global.cache_size_mb 1234
-
-Set additional buildkit args, using a YAML array:
-
-	config global.buildkit_additional_args ['userns', '--host']
-
-Set a key containing a period:
-		
-	config git."example.com".password hunter2
-
-Set up a whole custom git repository for a server called example.com, using a single-line YAML literal:
-	* which stores git repos under /var/git/repos/name-of-repo.git
-	* allows access over ssh
-	* using port 2222
-	* sets the username to git
-	* is recognized to earthly as example.com/name-of-repo
-
-	config git "{example: {pattern: 'example.com/([^/]+)', substitute: 'ssh://git@example.com:2222/var/git/repos/\$1.git', auth: ssh}}"
-			`,
+			Name:  "runner",
+			Usage: "Manage the remote runners of LOCALLY --runner targets",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "add",
+					Usage: "Register a remote runner",
+					Description: `Registers the host as a runner of the given name in the earthly config, after checking that it
+	 can be connected to over SSH without a password prompt.`,
+					UsageText: "earthly [options] runner add [--port <port>] [--identity-file <path>] [--workdir <dir>] [--no-check] <name> <user@host>",
+					Action:    app.actionRunnerAdd,
+					Flags: []cli.Flag{
+						&cli.IntFlag{
+							Name:        "port",
+							Usage:       "The SSH port of the runner, if not 22",
+							Destination: &app.runnerPort,
+						},
+						&cli.StringFlag{
+							Name:        "identity-file",
+							Usage:       "The private key used for connecting to the runner",
+							Destination: &app.runnerIdentityFile,
+						},
+						&cli.StringFlag{
+							Name:        "workdir",
+							Usage:       "The directory of the runner under which targets execute, relative to the home directory of the SSH user",
+							Destination: &app.runnerWorkdir,
+						},
+						&cli.BoolFlag{
+							Name:        "no-check",
+							Usage:       "Do not connect to the runner before registering it",
+							Destination: &app.runnerNoCheck,
+						},
+					},
+				},
+				{
+					Name:      "ls",
+					Usage:     "List the registered runners",
+					UsageText: "earthly [options] runner ls",
+					Action:    app.actionRunnerList,
+				},
+				{
+					Name:      "check",
+					Usage:     "Connect to a registered runner, and report its platform",
+					UsageText: "earthly [options] runner check <name>",
+					Action:    app.actionRunnerCheck,
+				},
+			},
+		},
+		{
+			Name:  "lint",
+			Usage: "Check an Earthfile for common mistakes",
+			Description: `Checks the Earthfile for common mistakes, such as cache hazards: RUN commands and images whose
+	 cached results depend on the state of the network or on the time of the build, which a rebuild from scratch
+	 would not reproduce. Exits with an error if any issue is found.`,
+			UsageText: "earthly [options] lint [--disable <rule>] [--list-rules] [--shellcheck] [<earthfile-dir>]",
+			Action:    app.actionLint,
 			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  "disable",
+					Usage: "Do not check the given rule (may be repeated)",
+					Value: &app.lintDisable,
+				},
 				&cli.BoolFlag{
-					Name:        "dry-run",
-					Usage:       "Print the changed config file to the console instead of writing it out",
-					Destination: &app.configDryRun,
+					Name:        "list-rules",
+					Usage:       "List the rules, instead of checking the Earthfile",
+					Destination: &app.lintListRules,
+				},
+				&cli.BoolFlag{
+					Name:        "shellcheck",
+					Usage:       "Also run shellcheck over the scripts of RUN commands (requires shellcheck to be installed)",
+					Destination: &app.lintShellCheck,
 				},
 			},
 		},
-	}
-
-	app.cliApp.Before = app.before
-	return app
+		{
+			Name:  "bug-report",
+			Usage: "Generate a bug report from the latest diagnostics bundle",
+			Description: `Generates a bug report, in Markdown, from the latest diagnostics bundle saved when earthly crashed,
+	 or from the given bundle. If no bundle was saved, the report describes the current environment.`,
+			UsageText: "earthly [options] bug-report [--output <path>] [<bundle-path>]",
+			Action:    app.actionBugReport,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "output",
+					Usage:       "Write the report to the given path instead of stdout",
+					Destination: &app.bugReportOutput,
+				},
+			},
+		},
+		{
+			Name:  "upgrade",
+			Usage: "Upgrade (or downgrade) earthly to the latest release of a channel, or to a given version",
+			Description: `Replaces the earthly binary with the latest release of the channel, or with the given version,
+	 after verifying the signature of the release. The buildkitd image pinned in the config is updated too.`,
+			UsageText: "earthly [options] upgrade [--channel stable|prerelease] [--version <version>]",
+			Action:    app.actionUpgrade,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "channel",
+					Usage:       "The release channel: stable or prerelease",
+					Value:       upgrade.ChannelStable,
+					Destination: &app.upgradeChannel,
+				},
+				&cli.StringFlag{
+					Name:        "version",
+					Usage:       "The version to upgrade or downgrade to, as in v0.6.1, instead of the latest release of the channel",
+					Destination: &app.upgradeVersion,
+				},
+			},
+		},
+		{
+			Name:  "telemetry",
+			Usage: "Inspect the anonymous usage metrics recorded locally",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "show",
+					Usage:     "Print the usage metrics which are pending to be sent, exactly as they would be sent",
+					UsageText: "earthly [options] telemetry show",
+					Action:    app.actionTelemetryShow,
+				},
+			},
+		},
+		{
+			Name:  "context",
+			Usage: "Inspect local build contexts",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "ls",
+					Usage:     "List the files of the build context which are sent when building a target",
+					UsageText: "earthly [options] context ls [+<target-name>]",
+					Action:    app.actionContextLs,
+				},
+			},
+		},
+		{
+			Name:  "multi",
+			Usage: "Operate on the targets of many Earthfiles at once",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "run",
+					Usage: "Run a target of every Earthfile matching a pattern, as a single build",
+					Description: `Discovers the Earthfiles matching --pattern and runs the given target of each of them as a single build,
+	 deduplicating their shared dependencies, and reports the result of each one.`,
+					UsageText: "earthly [options] multi run --pattern <glob> [--since <git-ref>] [--shared <path>...] +<target-name> [--<build-arg-key>=<build-arg-value>...]",
+					Action:    app.actionMultiRun,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:        "pattern",
+							Usage:       "A glob pattern of the Earthfiles, such as ./services/*/Earthfile",
+							Destination: &app.multiPattern,
+						},
+						&cli.StringFlag{
+							Name:        "since",
+							Usage:       "Only run the target of the Earthfiles whose directory changed since this git ref",
+							Destination: &app.multiSince,
+						},
+						&cli.StringSliceFlag{
+							Name:        "shared",
+							Usage:       "A path whose changes affect every Earthfile, when using --since; may be repeated",
+							Destination: &app.multiShared,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "selftest",
+			Usage: "Run the self-tests of Earthfiles",
+			Description: `Runs the selftest and selftest-* targets of the Earthfiles in the given directories (by default, the current one)
+	 as a single build, with their cache mounts isolated from those of regular builds, and reports the result of each one.
+	 Targets named selftest-fail-* pass only if they fail.`,
+			UsageText: "earthly [options] selftest [--run <substring>] [--cache-namespace <name>] [<dir>...] [--<build-arg-key>=<build-arg-value>...]",
+			Action:    app.actionSelftest,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "run",
+					Usage:       "Only run the self-tests whose target name contains this substring",
+					Destination: &app.selftestRun,
+				},
+				&cli.StringFlag{
+					Name:        "cache-namespace",
+					Usage:       "The namespace of the cache mounts of the self-tests; a fresh one is used for each run by default",
+					Destination: &app.selftestCacheNamespace,
+				},
+			},
+		},
+		{
+			Name:  "diff",
+			Usage: "Compare the outputs of two builds of a target",
+			Description: `Builds a target twice, at two git refs and/or with two sets of build args, and reports the differences
+	 between the builds: the targets whose definition changed (and which are therefore not cached), the layers of the images
+	 and the artifact files saved locally. By default, both builds use the working tree.`,
+			UsageText: "earthly [options] diff [--base-ref <git-ref>] [--head-ref <git-ref>] [--base-build-arg <key>=<value>...] [--head-build-arg <key>=<value>...] [--output <path>] <target-ref> [--<build-arg-key>=<build-arg-value>...]",
+			Action:    app.actionDiff,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "base-ref",
+					Usage:       "The git ref to build the base from",
+					Destination: &app.diffBaseRef,
+				},
+				&cli.StringFlag{
+					Name:        "head-ref",
+					Usage:       "The git ref to build the head from",
+					Destination: &app.diffHeadRef,
+				},
+				&cli.StringSliceFlag{
+					Name:        "base-build-arg",
+					Usage:       "A build arg of the base build only",
+					Destination: &app.diffBaseBuildArgs,
+				},
+				&cli.StringSliceFlag{
+					Name:        "head-build-arg",
+					Usage:       "A build arg of the head build only",
+					Destination: &app.diffHeadBuildArgs,
+				},
+				&cli.StringFlag{
+					Name:        "output",
+					Usage:       "Also write the differences to the given JSON file",
+					Destination: &app.diffOutput,
+				},
+			},
+		},
+		{
+			Name:  "dev",
+			Usage: "Start a development environment from the image of a target",
+			Description: `Builds the image of a target and starts it as an interactive container, with the directory of the
+	 Earthfile mounted at the WORKDIR of the image (or /workspace) and the ports it EXPOSEs forwarded to the host.
+	 With --devcontainer, also writes a .devcontainer/devcontainer.json, so that IDEs can open the same environment.`,
+			UsageText: "earthly [options] dev [--shell <path>] [--port <port>...] [--devcontainer] [--no-run] <target-ref> [--<build-arg-key>=<build-arg-value>...]",
+			Action:    app.actionDev,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "shell",
+					Usage:       "The command run in the container, instead of the entrypoint of the image; empty to run the entrypoint",
+					Value:       "/bin/sh",
+					Destination: &app.devShell,
+				},
+				&cli.StringSliceFlag{
+					Name:        "port",
+					Usage:       "A port to forward, in addition to those exposed by the image, as in 3000 or 53/udp",
+					Destination: &app.devPorts,
+				},
+				&cli.BoolFlag{
+					Name:        "devcontainer",
+					Usage:       "Write a .devcontainer/devcontainer.json describing the environment",
+					Destination: &app.devContainerJSON,
+				},
+				&cli.BoolFlag{
+					Name:        "no-run",
+					Usage:       "Only build the image, without starting the container",
+					Destination: &app.devNoRun,
+				},
+			},
+		},
+		{
+			Name:  "up",
+			Usage: "Run a target as a local service",
+			Description: `Builds the image of a target and runs it, with the ports it EXPOSEs published to the host, streaming its
+	 logs until it exits or until Ctrl-C, at which point it is stopped and removed. If the target (or the target given
+	 via --compose-from) has WITH DOCKER commands with --compose, the compose services they bring up are started first,
+	 on a network the target joins, and are torn down along with it.`,
+			UsageText: "earthly [options] up [--port <port>...] [--compose-from <target-ref>] <target-ref> [--<build-arg-key>=<build-arg-value>...]",
+			Action:    app.actionUp,
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:        "port",
+					Usage:       "A port to publish, in addition to those exposed by the image, as in 3000 or 53/udp",
+					Destination: &app.devPorts,
+				},
+				&cli.StringFlag{
+					Name:        "compose-from",
+					Usage:       "The target whose WITH DOCKER --compose services are started along with the target, such as its integration tests",
+					Destination: &app.upComposeFrom,
+				},
+			},
+		},
+		{
+			Name:  "promote",
+			Usage: "Copy an already built image to another registry or tag, without rebuilding it",
+			Description: `Copies the image of <src-ref>, by digest, to <dst-ref>, along with the signatures, attestations and SBOMs
+	 attached to it via cosign. The image is copied directly between the registries, and is tagged as <dst-ref>
+	 only once everything has been copied.`,
+			UsageText: "earthly [options] promote [--verify <policy>] [--force] <src-ref> <dst-ref>",
+			Action:    app.actionPromote,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "verify",
+					Usage:       "Verify the image against the given policy (as for FROM --verify) before and after copying it",
+					Destination: &app.promoteVerify,
+				},
+				&cli.BoolFlag{
+					Name:        "force",
+					Usage:       "Overwrite the signatures, attestations and SBOMs which already exist at the destination",
+					Destination: &app.promoteForce,
+				},
+			},
+		},
+		{
+			Name:        "prefetch",
+			Usage:       "Pull the images and git sources referenced by targets into the cache",
+			Description: "Pull the images and git sources referenced by targets into the cache",
+			UsageText:   "earthly [options] prefetch [--all] [+<target-name>...]",
+			Action:      app.actionPrefetch,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "all",
+					Aliases:     []string{"a"},
+					Usage:       "Prefetch every target of the Earthfile in the current directory",
+					Destination: &app.prefetchAll,
+				},
+			},
+		},
+		{
+			Name:  "changelog",
+			Usage: "Print the changelog of a range of commits, grouped by conventional commit type",
+			Description: `Lists the breaking changes, features, fixes and other changes made between two git refs,
+	 according to their conventional commit messages, in Markdown.`,
+			UsageText: "earthly [options] changelog [--from <ref>] [--to <ref>] [--output <path>]",
+			Action:    app.actionChangelog,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "from",
+					Usage:       "The ref the changelog starts from, exclusive (by default, the latest version tag)",
+					Destination: &app.changelogFrom,
+				},
+				&cli.StringFlag{
+					Name:        "to",
+					Usage:       "The ref the changelog ends at, inclusive",
+					Value:       "HEAD",
+					Destination: &app.changelogTo,
+				},
+				&cli.StringFlag{
+					Name:        "output",
+					Usage:       "Write the changelog to this file, rather than to stdout",
+					Destination: &app.changelogOutput,
+				},
+			},
+		},
+		{
+			Name:  "coverage",
+			Usage: "Merge the coverage reports saved by test targets",
+			Description: `Merges Go cover profiles, lcov tracefiles or Cobertura reports, such as those saved by several
+	 test targets via SAVE ARTIFACT ... AS LOCAL coverage/, into a single report, and prints its coverage.
+	 Without arguments, the reports of the coverage directory are merged.`,
+			UsageText: "earthly [options] coverage [--output <path>] [--fail-under <percent>] [<report-path-or-glob>...]",
+			Action:    app.actionCoverage,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "output",
+					Usage:       "Write the merged report to this file (by default, coverage/merged with the extension of the format)",
+					Destination: &app.coverageOutput,
+				},
+				&cli.Float64Flag{
+					Name:        "fail-under",
+					Usage:       "Fail if the merged coverage is below this percentage",
+					Destination: &app.coverageFailUnder,
+				},
+			},
+		},
+		{
+			Name:  "release",
+			Usage: "Tag, build, push and publish the next release",
+			Description: `Computes the next version out of the conventional commits since the previous version tag,
+	 tags it, builds the +release target with --push (passing the RELEASE_VERSION and RELEASE_TAG build args),
+	 pushes the tag and creates the GitHub or GitLab release, attaching the given assets.
+	 If a step fails, running the command again resumes the release from that step.`,
+			UsageText: "earthly [options] release [--version <version>] [--target <target>] [--asset <glob>...]",
+			Action:    app.actionRelease,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "version",
+					Usage:       "The version to release, rather than the one computed out of the conventional commits",
+					Destination: &app.releaseVersion,
+				},
+				&cli.StringFlag{
+					Name:        "target",
+					Usage:       "The target which builds and pushes the release",
+					Value:       "+release",
+					Destination: &app.releaseTarget,
+				},
+				&cli.StringSliceFlag{
+					Name:        "asset",
+					Usage:       "A glob pattern of the files attached to the release, such as dist/*; may be repeated",
+					Destination: &app.releaseAssets,
+				},
+				&cli.StringFlag{
+					Name:        "provider",
+					Usage:       "Where the release is created: github, gitlab, or none (by default, detected out of the remote URL)",
+					Destination: &app.releaseProvider,
+				},
+				&cli.StringFlag{
+					Name:        "remote",
+					Usage:       "The git remote the tag is pushed to",
+					Value:       "origin",
+					Destination: &app.releaseRemote,
+				},
+				&cli.BoolFlag{
+					Name:        "dry-run",
+					Usage:       "Only print the version which would be released, and its release notes",
+					Destination: &app.releaseDryRun,
+				},
+				&cli.BoolFlag{
+					Name:        "restart",
+					Usage:       "Discard the progress of a previous, failed release, rather than resuming it",
+					Destination: &app.releaseRestart,
+				},
+			},
+		},
+		{
+			Name:        "prune",
+			Usage:       "Prune Earthly build cache",
+			Description: "Prune Earthly build cache",
+			Action:      app.actionPrune,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "all",
+					Aliases:     []string{"a"},
+					EnvVars:     []string{"EARTHLY_PRUNE_ALL"},
+					Usage:       "Prune all cache",
+					Destination: &app.pruneAll,
+				},
+				&cli.BoolFlag{
+					Name:        "reset",
+					EnvVars:     []string{"EARTHLY_PRUNE_RESET"},
+					Usage:       "Reset cache entirely by wiping cache dir",
+					Destination: &app.pruneReset,
+				},
+			},
+		},
+		{
+			Name:   "config",
+			Usage:  "Edits your Earthly configuration file",
+			Action: app.actionConfig,
+			UsageText: `This command takes a path, and a value and sets it in your configuration file.
+
+	 As the configuration file is YAML, the key must be a valid key within the file. You can specify sub-keys by using "." to separate levels.
+	 If the sub-key you wish to use has a "." in it, you can quote that subsection, like this: git."github.com".
+
+	 Values must be valid YAML, and also be deserializable into the key you wish to assign them to.
+	 This means you can set higher level objects using a compact style, or single values with simple values.
+
+	 Only one key/value can be set per invocation.
+
+	 To get help with a specific key, do "config [key] --help". Or, visit https://docs.earthly.dev/earthly-config for more details.`,
+			Description: `Set your cache size:
+
+	config global.cache_size_mb 1234
+
+Set additional buildkit args, using a YAML array:
+
+	config global.buildkit_additional_args ['userns', '--host']
+
+Set a key containing a period:
+		
+	config git."example.com".password hunter2
+
+Set up a whole custom git repository for a server called example.com, using a single-line YAML literal:
+	* which stores git repos under /var/git/repos/name-of-repo.git
+	* allows access over ssh
+	* using port 2222
+	* sets the username to git
+	* is recognized to earthly as example.com/name-of-repo
+
+	config git "{example: {pattern: 'example.com/([^/]+)', substitute: 'ssh://git@example.com:2222/var/git/repos/\$1.git', auth: ssh}}"
+			`,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "dry-run",
+					Usage:       "Print the changed config file to the console instead of writing it out",
+					Destination: &app.configDryRun,
+				},
+			},
+		},
+	}
+
+	app.cliApp.Before = app.before
+	return app
 }
 
 func wrap(s ...string) string {
@@ -1014,10 +2076,36 @@ func (app *earthlyApp) before(context *cli.Context) error {
 		return err
 	}
 
+	// Only the names of the flags are recorded, never their values.
+	for _, name := range context.LocalFlagNames() {
+		telemetry.Feature("--" + name)
+	}
+
 	// command line option overrides the config which overrides the default value
 	if !context.IsSet("buildkit-image") && app.cfg.Global.BuildkitImage != "" {
 		app.buildkitdImage = app.cfg.Global.BuildkitImage
 	}
+	if !context.IsSet("audit-log") && app.cfg.Global.AuditLog != "" {
+		app.auditLogPath = app.cfg.Global.AuditLog
+	}
+	if !context.IsSet("audit-log-key") && app.cfg.Global.AuditLogKey != "" {
+		app.auditLogKeyPath = app.cfg.Global.AuditLogKey
+	}
+	if !context.IsSet("default-user") && app.cfg.Global.DefaultUser != "" {
+		app.defaultUser = app.cfg.Global.DefaultUser
+	}
+	if !context.IsSet("privileged-allowlist") && app.cfg.Global.PrivilegedAllowlist != "" {
+		app.privilegedAllowlist = app.cfg.Global.PrivilegedAllowlist
+	}
+	if !context.IsSet("context-size-limit-mb") {
+		app.contextSizeLimitMb = app.cfg.Global.ContextSizeLimitMb
+	}
+	if !context.IsSet("artifact-store") {
+		app.artifactStore = app.cfg.Global.ArtifactStore
+	}
+	if !buildcontext.ValidLineEndings(app.cfg.Global.LineEndings) {
+		return errors.Errorf("invalid line_endings %s: expected warn, error, ignore or normalize", app.cfg.Global.LineEndings)
+	}
 
 	var addrs addresses
 	switch app.cfg.Global.BuildkitScheme {
@@ -1041,8 +2129,10 @@ func (app *earthlyApp) before(context *cli.Context) error {
 
 	app.buildkitdSettings.AdditionalArgs = app.cfg.Global.BuildkitAdditionalArgs
 	app.buildkitdSettings.AdditionalConfig = app.cfg.Global.BuildkitAdditionalConfig
+	app.buildkitdSettings.GPUs = app.cfg.Global.BuildkitGPUs
 	app.buildkitdSettings.Timeout = time.Duration(app.cfg.Global.BuildkitRestartTimeoutS) * time.Second
 	app.buildkitdSettings.Debug = app.debug
+	app.buildkitdSettings.ClientVersion = Version
 	app.buildkitdSettings.BuildkitAddress = addrs.buildkit
 	app.buildkitdSettings.DebuggerAddress = app.debuggerHost
 	app.buildkitdSettings.LocalRegistryAddress = addrs.localRegistry
@@ -1055,6 +2145,13 @@ func (app *earthlyApp) before(context *cli.Context) error {
 	}
 	app.buildkitdSettings.CniMtu = app.cfg.Global.CniMtu
 
+	if app.cfg.Global.VM && context.Args().First() != "vm" {
+		err = app.useVM(context)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Make a small attempt to check if we are not bootstrapped. If not, then do that before we do anything else.
 	isBootstrapCmd := false
 	for _, f := range context.Args().Slice() {
@@ -1450,8 +2547,9 @@ func (app *earthlyApp) insertZSHCompleteEntry() error {
 
 func (app *earthlyApp) run(ctx context.Context, args []string) int {
 	rpcRegex := regexp.MustCompile(`(?U)rpc error: code = .+ desc = `)
-	err := app.cliApp.RunContext(ctx, args)
+	err := app.runRecover(ctx, args)
 	if err != nil {
+		app.errorCategory = errorCategory(err)
 		ie, isInterpereterError := earthfile2llb.GetInterpreterError(err)
 
 		var failedOutput string
@@ -1502,7 +2600,7 @@ func (app *earthlyApp) run(ctx context.Context, args []string) int {
 				app.console.Warnf(
 					"It seems that buildkitd is shutting down or it has crashed. " +
 						"You can report crashes at https://github.com/earthly/earthly/issues/new.")
-				app.printCrashLogs(ctx)
+				app.printCrashLogs(ctx, err)
 				return 7
 			}
 		} else if errors.Is(err, buildkitd.ErrBuildkitCrashed) {
@@ -1510,15 +2608,18 @@ func (app *earthlyApp) run(ctx context.Context, args []string) int {
 			app.console.Warnf(
 				"It seems that buildkitd is shutting down or it has crashed. " +
 					"You can report crashes at https://github.com/earthly/earthly/issues/new.")
-			app.printCrashLogs(ctx)
+			app.printCrashLogs(ctx, err)
 			return 7
 		} else if errors.Is(err, buildkitd.ErrBuildkitStartFailure) {
 			app.console.Warnf("Error: %v\n", err)
 			app.console.Warnf(
 				"It seems that buildkitd had an issue. " +
 					"You can report crashes at https://github.com/earthly/earthly/issues/new.")
-			app.printCrashLogs(ctx)
+			app.printCrashLogs(ctx, err)
 			return 6
+		} else if app.panicStack != "" {
+			app.console.Warnf("Error: %v\n%s\n", err, app.panicStack)
+			app.printCrashLogs(ctx, err)
 		} else if isInterpereterError {
 			app.console.Warnf("Error: %s\n", ie.Error())
 		} else {
@@ -1532,18 +2633,77 @@ func (app *earthlyApp) run(ctx context.Context, args []string) int {
 	return 0
 }
 
-func (app *earthlyApp) printCrashLogs(ctx context.Context) {
+// errorCategory returns the category of the error, as reported by telemetry.
+// It never includes the error message, which may contain user data.
+func errorCategory(err error) string {
+	var buildErr *builder.BuildError
+	_, isInterpreterError := earthfile2llb.GetInterpreterError(err)
+	switch {
+	case errors.Is(err, context.Canceled) || strings.Contains(err.Error(), context.Canceled.Error()):
+		return "canceled"
+	case errors.Is(err, buildkitd.ErrBuildkitCrashed):
+		return "buildkit-crashed"
+	case errors.Is(err, buildkitd.ErrBuildkitStartFailure):
+		return "buildkit-start-failure"
+	case strings.Contains(err.Error(), "security.insecure is not allowed"):
+		return "privileged-not-allowed"
+	case strings.Contains(err.Error(), "failed to fetch remote"):
+		return "git-fetch"
+	case errors.As(err, &buildErr):
+		return "build"
+	case isInterpreterError:
+		return "earthfile"
+	}
+	return "other"
+}
+
+func (app *earthlyApp) recordTelemetry(ctx context.Context) {
+	ctxTimeout, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	endpoint := app.cfg.Global.TelemetryEndpoint
+	if endpoint == "" {
+		endpoint = app.apiServer + "/telemetry"
+	}
+	err := telemetry.Record(ctxTimeout, telemetry.Run{
+		Command:       app.commandName,
+		ErrorCategory: app.errorCategory,
+	}, telemetry.RecordOpt{
+		Path:     filepath.Join(cliutil.GetEarthlyDir(), "telemetry.json"),
+		Endpoint: endpoint,
+		Version:  Version,
+		Platform: fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+	})
+	if err != nil && app.verbose {
+		app.console.Warnf("Warning: could not record telemetry: %v\n", err)
+	}
+}
+
+// runRecover runs the app, turning panics into errors. The stack trace of the
+// panic is kept in app.panicStack.
+func (app *earthlyApp) runRecover(ctx context.Context, args []string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			app.panicStack = string(debug.Stack())
+			err = errors.Errorf("earthly panicked: %v", r)
+		}
+	}()
+	return app.cliApp.RunContext(ctx, args)
+}
+
+func (app *earthlyApp) printCrashLogs(ctx context.Context, crashErr error) {
 	app.console.PrintBar(color.New(color.FgHiRed), "System Info", "")
 	fmt.Fprintf(os.Stderr, "version: %s\n", Version)
 	fmt.Fprintf(os.Stderr, "build-sha: %s\n", GitSha)
 	fmt.Fprintf(os.Stderr, "platform: %s\n", getPlatform())
 
+	bundle := app.newDiagnosticsBundle(crashErr)
 	dockerVersion, err := buildkitd.GetDockerVersion(ctx)
 	if err != nil {
 		app.console.Warnf("failed querying docker version: %s\n", err.Error())
 	} else {
 		app.console.PrintBar(color.New(color.FgHiRed), "Docker Version", "")
 		fmt.Fprintln(os.Stderr, dockerVersion)
+		bundle.DockerVersion = dockerVersion
 	}
 
 	logs, err := buildkitd.GetLogs(ctx, app.containerName, app.buildkitdSettings)
@@ -1552,36 +2712,224 @@ func (app *earthlyApp) printCrashLogs(ctx context.Context) {
 	} else {
 		app.console.PrintBar(color.New(color.FgHiRed), "Buildkit Logs", "")
 		fmt.Fprintln(os.Stderr, logs)
+		bundle.BuildkitLogs = diagnostics.Tail(logs, diagnostics.LogsTailLines)
 	}
-}
 
-func isEarthlyBinary(path string) bool {
-	// apply heuristics to see if binary is a version of earthly
-	data, err := ioutil.ReadFile(path)
+	path, err := diagnostics.Save(filepath.Join(cliutil.GetEarthlyDir(), "diagnostics"), bundle)
 	if err != nil {
-		return false
-	}
-	if !bytes.Contains(data, []byte("docs.earthly.dev")) {
-		return false
-	}
-	if !bytes.Contains(data, []byte("api.earthly.dev")) {
-		return false
-	}
-	if !bytes.Contains(data, []byte("Earthfile")) {
-		return false
+		app.console.Warnf("failed saving diagnostics bundle: %s\n", err.Error())
+		return
 	}
-	return true
+	app.console.Printf("A diagnostics bundle, with secrets redacted, was saved to %s.\n"+
+		"Run earthly bug-report to include it in a bug report.\n", path)
 }
 
-func symlinkEarthlyToEarth() error {
-	binPath, err := os.Executable()
-	if err != nil {
-		return errors.Wrap(err, "failed to get current executable path")
+// newDiagnosticsBundle returns a diagnostics bundle of the error, without the
+// docker version and buildkitd logs, which are queried separately.
+func (app *earthlyApp) newDiagnosticsBundle(crashErr error) diagnostics.Bundle {
+	bundle := diagnostics.Bundle{
+		Time:     time.Now().UTC(),
+		Version:  Version,
+		GitSHA:   GitSha,
+		Platform: getPlatform(),
+		Command:  app.commandName,
+		Panic:    app.panicStack,
+	}
+	if crashErr != nil {
+		bundle.Error = crashErr.Error()
+		var buildErr *builder.BuildError
+		if errors.As(crashErr, &buildErr) {
+			bundle.FailedVertexDigest = buildErr.VertexDigest()
+			bundle.FailedVertexName = buildErr.VertexName()
+		}
 	}
-
-	baseName := path.Base(binPath)
-	if baseName != "earthly" {
-		return nil
+	if app.configPath != "" {
+		yamlData, err := ioutil.ReadFile(app.configPath)
+		if err == nil {
+			cfg, err := diagnostics.RedactConfig(yamlData)
+			if err != nil {
+				cfg = "<unparsable config omitted>"
+			}
+			bundle.Config = cfg
+		}
+	}
+	return bundle
+}
+
+func (app *earthlyApp) actionBugReport(c *cli.Context) error {
+	app.commandName = "bugReport"
+	if c.NArg() > 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	var bundle diagnostics.Bundle
+	path := c.Args().First()
+	if path == "" {
+		latest, ok, err := diagnostics.Latest(filepath.Join(cliutil.GetEarthlyDir(), "diagnostics"))
+		if err != nil {
+			return err
+		}
+		if ok {
+			path = latest
+		}
+	}
+	if path != "" {
+		var err error
+		bundle, err = diagnostics.Load(path)
+		if err != nil {
+			return err
+		}
+		app.console.Printf("Using the diagnostics bundle %s\n", path)
+	} else {
+		// No failure was recorded: report the current environment.
+		bundle = app.newDiagnosticsBundle(nil)
+		bundle.Command = ""
+		dockerVersion, err := buildkitd.GetDockerVersion(c.Context)
+		if err == nil {
+			bundle.DockerVersion = dockerVersion
+		}
+		logs, err := buildkitd.GetLogs(c.Context, app.containerName, app.buildkitdSettings)
+		if err == nil {
+			bundle.BuildkitLogs = diagnostics.Tail(logs, diagnostics.LogsTailLines)
+		}
+		bundle.Redact()
+	}
+
+	out := io.Writer(os.Stdout)
+	if app.bugReportOutput != "" {
+		f, err := os.Create(app.bugReportOutput)
+		if err != nil {
+			return errors.Wrapf(err, "create %s", app.bugReportOutput)
+		}
+		defer f.Close()
+		out = f
+	}
+	err := bundle.WriteMarkdown(out)
+	if err != nil {
+		return err
+	}
+	app.console.Printf("Review the report for any remaining sensitive information, then include it in a new issue at https://github.com/earthly/earthly/issues/new\n")
+	return nil
+}
+
+func (app *earthlyApp) actionUpgrade(c *cli.Context) error {
+	app.commandName = "upgrade"
+	if c.NArg() != 0 {
+		return errors.New("invalid number of arguments provided")
+	}
+	if ReleasePublicKey == "" {
+		return errors.New("this build of earthly cannot verify the signatures of releases; please upgrade it the way it was installed")
+	}
+	publicKey, err := upgrade.ParsePublicKey(ReleasePublicKey)
+	if err != nil {
+		return err
+	}
+	client := upgrade.NewClient()
+	releases, err := client.ListReleases(c.Context)
+	if err != nil {
+		return err
+	}
+	release, err := upgrade.Select(releases, app.upgradeChannel, app.upgradeVersion)
+	if err != nil {
+		return err
+	}
+	if release.Tag == Version {
+		app.console.Printf("earthly is already at %s\n", Version)
+		return nil
+	}
+	action := "Upgrading"
+	current, currentErr := semverutil.Parse(Version)
+	target, targetErr := semverutil.Parse(release.Tag)
+	if currentErr == nil && targetErr == nil && semverutil.Compare(target, current) < 0 {
+		action = "Downgrading"
+	}
+	app.console.Printf("%s earthly from %s to %s\n", action, Version, release.Tag)
+	binary, err := client.DownloadBinary(c.Context, release, publicKey)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "find the earthly binary")
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return errors.Wrap(err, "find the earthly binary")
+	}
+	err = upgrade.ReplaceBinary(exe, binary)
+	if err != nil {
+		return errors.Wrap(err, "replace the earthly binary (does it need to be run as root?)")
+	}
+	app.console.Printf("Replaced %s with earthly %s\n", exe, release.Tag)
+	app.upgradeBuildkitdImage(c, release.Tag)
+	return nil
+}
+
+// upgradeBuildkitdImage updates the buildkitd image pinned in the config to
+// that of the new version of earthly, and pulls the image, so that the next
+// build does not wait for it. Failures only print warnings, as the binary has
+// already been replaced.
+func (app *earthlyApp) upgradeBuildkitdImage(c *cli.Context, newTag string) {
+	image := DefaultBuildkitdImage
+	if app.cfg.Global.BuildkitImage != "" {
+		pinned, ok := upgrade.BuildkitdImage(app.cfg.Global.BuildkitImage, Version, newTag)
+		if !ok {
+			app.console.Warnf("Warning: buildkit_image is set to %s in the config, which may need updating\n", app.cfg.Global.BuildkitImage)
+			return
+		}
+		inConfig, err := config.ReadConfigFile(app.configPath, c.IsSet("config"))
+		if err == nil {
+			var outConfig []byte
+			outConfig, err = config.UpsertConfig(inConfig, "global.buildkit_image", pinned)
+			if err == nil {
+				err = config.WriteConfigFile(app.configPath, outConfig)
+			}
+		}
+		if err != nil {
+			app.console.Warnf("Warning: could not update buildkit_image in the config to %s: %v\n", pinned, err)
+			return
+		}
+		app.console.Printf("Updated buildkit_image in the config to %s\n", pinned)
+		image = pinned
+	} else {
+		newImage, ok := upgrade.BuildkitdImage(image, Version, newTag)
+		if !ok {
+			return
+		}
+		image = newImage
+	}
+	err := buildkitd.MaybePull(c.Context, app.console, image)
+	if err != nil {
+		app.console.Warnf("Warning: could not pull %s: %v\n", image, err)
+	}
+}
+
+func isEarthlyBinary(path string) bool {
+	// apply heuristics to see if binary is a version of earthly
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	if !bytes.Contains(data, []byte("docs.earthly.dev")) {
+		return false
+	}
+	if !bytes.Contains(data, []byte("api.earthly.dev")) {
+		return false
+	}
+	if !bytes.Contains(data, []byte("Earthfile")) {
+		return false
+	}
+	return true
+}
+
+func symlinkEarthlyToEarth() error {
+	binPath, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "failed to get current executable path")
+	}
+
+	baseName := path.Base(binPath)
+	if baseName != "earthly" {
+		return nil
 	}
 
 	earthPath := path.Join(path.Dir(binPath), "earth")
@@ -1662,6 +3010,10 @@ func (app *earthlyApp) bootstrap(c *cli.Context) error {
 		console.Printf("You may have to restart your shell for autocomplete to get initialized (e.g. run \"exec $SHELL\")\n")
 	}
 
+	if buildkitd.IsTranslated(c.Context) {
+		console.Warnf("Warning: earthly runs under Rosetta translation. Install the darwin-arm64 build of earthly for better performance.\n")
+	}
+
 	err = symlinkEarthlyToEarth()
 	if err != nil {
 		console.Warnf("Warning: %s\n", err.Error())
@@ -1688,12 +3040,53 @@ func (app *earthlyApp) bootstrap(c *cli.Context) error {
 			return errors.Wrap(err, "bootstrap new buildkitd client")
 		}
 		defer bkClient.Close()
+
+		if buildkitd.IsLocal(app.buildkitdSettings.BuildkitAddress) {
+			machine, err := buildkitd.VerifyNative(c.Context, app.containerName)
+			if err != nil {
+				console.Warnf("Warning: %s\n", err.Error())
+			} else {
+				console.Printf("Verified buildkitd runs natively (%s)\n", machine)
+			}
+		}
+
+		if len(app.bootstrapPlatforms.Value()) > 0 {
+			err = app.bootstrapEmulation(c.Context, console, app.bootstrapPlatforms.Value())
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	console.Printf("Bootstrapping successful.\n")
 	return nil
 }
 
+func (app *earthlyApp) bootstrapEmulation(ctx context.Context, console conslogging.ConsoleLogger, platformStrs []string) error {
+	missing, err := buildkitd.MissingEmulators(ctx, platformStrs)
+	if err != nil {
+		return errors.Wrap(err, "detect emulation support")
+	}
+	if len(missing) > 0 {
+		console.Printf("Installing emulation support for %s\n", strings.Join(missing, ", "))
+		err = buildkitd.InstallEmulators(ctx, missing)
+		if errors.Is(err, buildkitd.ErrRootlessBinfmt) {
+			console.Warnf("Emulation support is missing for %s.\n%s", strings.Join(missing, ", "), buildkitd.RootlessBinfmtInstructions())
+			return errors.Wrap(err, "install emulation support")
+		} else if err != nil {
+			return errors.Wrap(err, "install emulation support")
+		}
+	}
+	for _, p := range platformStrs {
+		machine, err := buildkitd.VerifyEmulator(ctx, p)
+		if err != nil {
+			return errors.Wrapf(err, "verify emulation support for %s", p)
+		}
+		console.Printf("Verified %s (reports %s)\n", p, machine)
+	}
+	return nil
+}
+
 func promptInput(question string) string {
 	fmt.Printf("%s", question)
 	rbuf := bufio.NewReader(os.Stdin)
@@ -2405,6 +3798,50 @@ func (app *earthlyApp) actionDebugAst(c *cli.Context) error {
 	return nil
 }
 
+func (app *earthlyApp) actionAuditVerify(c *cli.Context) error {
+	app.commandName = "auditVerify"
+	if c.NArg() > 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	path := app.auditLogPath
+	if c.NArg() == 1 {
+		path = c.Args().First()
+	}
+	if path == "" {
+		return errors.New("no audit log specified")
+	}
+	key, err := app.readAuditLogKey()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "open audit log %s", path)
+	}
+	defer f.Close()
+	n, err := audit.Verify(f, key)
+	if err != nil {
+		return errors.Wrapf(err, "verify audit log %s", path)
+	}
+	if key == nil {
+		app.console.Printf("Verified the chain of %d entries in %s (signatures not checked, no key provided)\n", n, path)
+	} else {
+		app.console.Printf("Verified %d signed entries in %s\n", n, path)
+	}
+	return nil
+}
+
+func (app *earthlyApp) readAuditLogKey() ([]byte, error) {
+	if app.auditLogKeyPath == "" {
+		return nil, nil
+	}
+	key, err := ioutil.ReadFile(app.auditLogKeyPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read audit log key %s", app.auditLogKeyPath)
+	}
+	return bytes.TrimSpace(key), nil
+}
+
 func (app *earthlyApp) actionPrune(c *cli.Context) error {
 	app.commandName = "prune"
 	if c.NArg() != 0 {
@@ -2458,117 +3895,1635 @@ func (app *earthlyApp) actionPrune(c *cli.Context) error {
 	return nil
 }
 
-func (app *earthlyApp) actionDocker(c *cli.Context) error {
-	app.commandName = "docker"
-
-	dir := filepath.Dir(app.dockerfilePath)
-	earthfilePath := filepath.Join(dir, "Earthfile")
-	if fileutil.FileExists(earthfilePath) {
-		return errors.Errorf("earthfile already exists; please delete it if you wish to continue")
+func (app *earthlyApp) actionArtifactLs(c *cli.Context) error {
+	app.commandName = "artifactLs"
+	if c.NArg() > 1 {
+		return errors.New("invalid number of arguments provided")
 	}
-	defer os.Remove(earthfilePath)
-
-	err := docker2earthly.Docker2Earthly(app.dockerfilePath, earthfilePath, app.earthfileFinalImage)
+	store, err := app.openArtifactStore()
 	if err != nil {
 		return err
 	}
-
-	fmt.Fprintf(os.Stderr, "Warning: earthly does not support all dockerfile commands and is highly experimental as a result, use with caution.\n")
-
-	app.imageMode = false
-	app.artifactMode = false
-	app.interactiveDebugging = true
-	flagArgs := []string{}
-	nonFlagArgs := []string{"+build"}
-
-	return app.actionBuildImp(c, flagArgs, nonFlagArgs)
-}
-
-func (app *earthlyApp) actionDocker2Earthly(c *cli.Context) error {
-	app.commandName = "docker2earthly"
-	err := docker2earthly.Docker2Earthly(app.dockerfilePath, app.earthfilePath, app.earthfileFinalImage)
+	entries, err := store.List()
 	if err != nil {
 		return err
 	}
-	fmt.Fprintf(os.Stderr, "An Earthfile has been generated; to run it use: earthly +build; then run with docker run -ti %s\n", app.earthfileFinalImage)
-	return nil
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\tARTIFACT\tGIT COMMIT\tSTORED\tSIZE\n")
+	for _, e := range entries {
+		if c.NArg() == 1 && e.Artifact != c.Args().First() {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			e.ID[:12], e.Artifact, shortCommit(e.GitCommit), e.Time.Local().Format(time.RFC3339), humanize.Bytes(uint64(e.Size())))
+	}
+	return w.Flush()
 }
 
-func (app *earthlyApp) actionConfig(c *cli.Context) error {
-	app.commandName = "config"
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+func (app *earthlyApp) actionArtifactGet(c *cli.Context) error {
+	app.commandName = "artifactGet"
 	if c.NArg() != 2 {
 		return errors.New("invalid number of arguments provided")
 	}
-
-	args := c.Args().Slice()
-	inConfig, err := config.ReadConfigFile(app.configPath, c.IsSet("config"))
+	store, err := app.openArtifactStore()
 	if err != nil {
-		return errors.Wrap(err, "read config")
+		return err
 	}
-
-	outConfig, err := config.UpsertConfig(inConfig, args[0], args[1])
+	e, err := store.Resolve(c.Args().Get(0))
 	if err != nil {
-		return errors.Wrap(err, "upsert config")
+		return err
 	}
-
-	if app.configDryRun {
-		fmt.Println(string(outConfig))
-		return nil
+	err = store.Get(e, c.Args().Get(1))
+	if err != nil {
+		return errors.Wrapf(err, "get artifact %s", e.Artifact)
 	}
+	app.console.Printf("Artifact %s (%s) as local %s\n", e.Artifact, e.ID[:12], c.Args().Get(1))
+	return nil
+}
 
-	err = config.WriteConfigFile(app.configPath, outConfig)
+func (app *earthlyApp) actionArtifactGC(c *cli.Context) error {
+	app.commandName = "artifactGC"
+	if c.NArg() != 0 {
+		return errors.New("invalid arguments")
+	}
+	store, err := app.openArtifactStore()
 	if err != nil {
-		return errors.Wrap(err, "write config")
+		return err
 	}
-
+	removed, freed, err := store.GC(artifactstore.GCOpt{
+		Keep:      app.artifactGCKeep,
+		OlderThan: app.artifactGCOlderThan,
+	})
+	if err != nil {
+		return err
+	}
+	app.console.Printf("Removed %d stored artifacts, freeing %s\n", removed, humanize.Bytes(uint64(freed)))
 	return nil
 }
 
-func (app *earthlyApp) actionBuild(c *cli.Context) error {
-	app.commandName = "build"
-
-	if app.ci {
-		app.useInlineCache = true
-		app.noOutput = true
-		app.strict = true
-		if app.remoteCache == "" && app.push {
-			app.saveInlineCache = true
-		}
-
-		if app.interactiveDebugging {
-			return errors.New("unable to use --ci flag in combination with --interactive flag")
-		}
+func (app *earthlyApp) actionRegistryGC(c *cli.Context) error {
+	app.commandName = "registryGC"
+	if len(app.registryGCMatch.Value()) == 0 {
+		return errors.New("--match is required")
 	}
-	if app.imageMode && app.artifactMode {
-		return errors.New("both image and artifact modes cannot be active at the same time")
+	if app.registryGCKeepLast < 0 {
+		return errors.New("--keep-last must not be negative")
 	}
-	if (app.imageMode && app.noOutput) || (app.artifactMode && app.noOutput) {
-		if app.ci {
-			app.noOutput = false
-		} else {
-			return errors.New("cannot use --no-output with image or artifact modes")
+	repos := c.Args().Slice()
+	if len(repos) == 0 {
+		repos = app.cfg.Global.RegistryGCRepositories
+	}
+	if len(repos) == 0 {
+		return errors.New("no repositories given, nor configured via registry_gc_repositories")
+	}
+	client := registryutil.NewClient(nil)
+	verb := "Deleted"
+	if app.registryGCDryRun {
+		verb = "Would delete"
+	}
+	for _, repo := range repos {
+		ref, err := registryutil.ParseRef(repo)
+		if err != nil {
+			return err
+		}
+		res, err := client.GC(c.Context, ref, registryutil.GCOpt{
+			Match:    app.registryGCMatch.Value(),
+			KeepLast: app.registryGCKeepLast,
+			DryRun:   app.registryGCDryRun,
+		})
+		for _, tag := range res.Deleted {
+			app.console.Printf("%s %s/%s:%s\n", verb, ref.Host, ref.Repo, tag)
+		}
+		for _, tag := range res.Shared {
+			app.console.VerbosePrintf("Kept %s/%s:%s, as its image is referred to by a tag which is kept\n", ref.Host, ref.Repo, tag)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "gc %s/%s", ref.Host, ref.Repo)
 		}
+		app.console.Printf("%s/%s: %s %d tags, kept %d\n", ref.Host, ref.Repo, strings.ToLower(verb), len(res.Deleted), len(res.Kept)+len(res.Shared))
 	}
+	return nil
+}
 
-	flagArgs, nonFlagArgs, err := variables.ParseFlagArgsWithNonFlags(c.Args().Slice())
-	if err != nil {
-		return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+func (app *earthlyApp) vmConfig() vm.Config {
+	return vm.Config{
+		Type:     app.cfg.Global.VMType,
+		CPUs:     app.cfg.Global.VMCPUs,
+		MemoryGB: app.cfg.Global.VMMemoryGb,
+		DiskGB:   app.cfg.Global.VMDiskGb,
 	}
-
-	return app.actionBuildImp(c, flagArgs, nonFlagArgs)
 }
 
-// warnIfArgContainsBuildArg will issue a warning if a flag is incorrectly prefixed with build-arg.
-// TODO this check should be replaced with a warning if an arg was given but never used.
-func (app *earthlyApp) warnIfArgContainsBuildArg(flagArgs []string) {
-	for _, flag := range flagArgs {
-		if strings.HasPrefix(flag, "build-arg=") || strings.HasPrefix(flag, "buildarg=") {
-			app.console.Warnf("Found a flag named %q; flags after the build target should be specified as --KEY=VAL\n", flag)
-		}
+// useVM points docker, and therefore buildkitd, to the VM, starting it if
+// needed. An explicit DOCKER_HOST takes precedence.
+func (app *earthlyApp) useVM(c *cli.Context) error {
+	if runtime.GOOS != "darwin" {
+		return errors.New("the vm config is only supported on macOS")
+	}
+	if os.Getenv("DOCKER_HOST") != "" {
+		app.console.VerbosePrintf("DOCKER_HOST is set, not using the earthly VM\n")
+		return nil
+	}
+	i, err := vm.Get(c.Context)
+	if err != nil {
+		return err
+	}
+	if i.Status != vm.StatusRunning {
+		app.console.WithPrefix("vm").Printf("Starting the earthly VM...\n")
+		i, err = vm.Start(c.Context, app.vmConfig())
+		if err != nil {
+			return err
+		}
+	}
+	return os.Setenv("DOCKER_HOST", i.DockerHost())
+}
+
+func (app *earthlyApp) actionVMStart(c *cli.Context) error {
+	app.commandName = "vmStart"
+	i, err := vm.Start(c.Context, app.vmConfig())
+	if err != nil {
+		return err
+	}
+	app.console.Printf("The earthly VM is running. Its docker daemon is available at %s\n", i.DockerHost())
+	if !app.cfg.Global.VM {
+		app.console.Printf("Run earthly config global.vm true to use it for builds\n")
+	}
+	return nil
+}
+
+func (app *earthlyApp) actionVMStop(c *cli.Context) error {
+	app.commandName = "vmStop"
+	return vm.Stop(c.Context)
+}
+
+func (app *earthlyApp) actionVMResize(c *cli.Context) error {
+	app.commandName = "vmResize"
+	if app.vmCPUs < 0 || app.vmMemoryGB < 0 || app.vmDiskGB < 0 {
+		return errors.New("--cpus, --memory and --disk must not be negative")
+	}
+	return vm.Resize(c.Context, vm.Config{CPUs: app.vmCPUs, MemoryGB: app.vmMemoryGB, DiskGB: app.vmDiskGB})
+}
+
+func (app *earthlyApp) actionVMStatus(c *cli.Context) error {
+	app.commandName = "vmStatus"
+	i, err := vm.Get(c.Context)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Status: %s\n", i.Status)
+	if i.Status == vm.StatusMissing {
+		return nil
+	}
+	fmt.Printf("Type:   %s\n", i.VMType)
+	fmt.Printf("CPUs:   %d\n", i.CPUs)
+	fmt.Printf("Memory: %dGB\n", i.Memory>>30)
+	fmt.Printf("Disk:   %dGB\n", i.Disk>>30)
+	fmt.Printf("Docker: %s\n", i.DockerHost())
+	return nil
+}
+
+// openArtifactStore opens the local artifact store, kept in ~/.earthly.
+func (app *earthlyApp) openArtifactStore() (*artifactstore.Store, error) {
+	earthlyDir, err := cliutil.GetOrCreateEarthlyDir()
+	if err != nil {
+		return nil, err
+	}
+	return artifactstore.Open(filepath.Join(earthlyDir, "artifacts"))
+}
+
+func (app *earthlyApp) actionContextLs(c *cli.Context) error {
+	app.commandName = "contextLs"
+	if c.NArg() > 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	target := domain.Target{LocalPath: "."}
+	if c.NArg() == 1 {
+		var err error
+		target, err = domain.ParseTarget(c.Args().First())
+		if err != nil {
+			return errors.Wrapf(err, "parse target name %s", c.Args().First())
+		}
+	}
+	files, err := buildcontext.ListLocalContext(c.Context, target)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		fmt.Println(f)
+	}
+	return nil
+}
+
+func (app *earthlyApp) actionDoc(c *cli.Context) error {
+	app.commandName = "doc"
+	if c.NArg() > 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	dir := "."
+	targetName := ""
+	if c.NArg() == 1 {
+		arg := c.Args().First()
+		if strings.Contains(arg, "+") {
+			target, err := domain.ParseTarget(arg)
+			if err != nil {
+				return errors.Wrapf(err, "parse target name %s", arg)
+			}
+			if target.IsRemote() || target.IsImportReference() {
+				return errors.Errorf("doc is only supported for local targets: %s", arg)
+			}
+			dir = target.LocalPath
+			targetName = target.Target
+		} else {
+			dir = arg
+		}
+	}
+	earthfilePath := filepath.Join(dir, "Earthfile")
+	ef, err := ast.Parse(c.Context, earthfilePath, false)
+	if err != nil {
+		return errors.Wrapf(err, "parse %s", earthfilePath)
+	}
+	doc := earthfiledoc.New(ef)
+	title := earthfilePath
+	if targetName != "" {
+		var ok bool
+		doc, ok = doc.Target(targetName)
+		if !ok {
+			return errors.Errorf("target %s not found in %s", targetName, earthfilePath)
+		}
+		title = "+" + targetName
+	}
+	if app.docMarkdown {
+		return doc.WriteMarkdown(os.Stdout, title)
+	}
+	return doc.WriteText(os.Stdout)
+}
+
+func (app *earthlyApp) actionCatalogUpdate(c *cli.Context) error {
+	app.commandName = "catalogUpdate"
+	repos := c.Args().Slice()
+	if len(repos) == 0 {
+		repos = app.cfg.Catalog.Repos
+	}
+	if len(repos) == 0 {
+		return errors.New("no repositories to index: pass them as arguments, or configure catalog.repos")
+	}
+	catalogPath, err := app.catalogPath()
+	if err != nil {
+		return err
+	}
+	cat, err := catalog.Load(catalogPath)
+	if err != nil {
+		return err
+	}
+	workspace, err := app.loadWorkspace(c.Context)
+	if err != nil {
+		return err
+	}
+	secretsMap, err := processSecrets(app.secrets.Value(), app.secretFiles.Value(), nil)
+	if err != nil {
+		return err
+	}
+	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	if err != nil {
+		return errors.Wrap(err, "failed to create secretsclient")
+	}
+	gitLookup := buildcontext.NewGitLookup(app.console, app.sshAuthSock)
+	err = app.updateGitLookupConfig(c.Context, gitLookup, secretsMap, sc)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, repo := range repos {
+		repo = strings.TrimSuffix(repo, "/")
+		entries, err := app.indexCatalogRepo(c.Context, workspace, gitLookup, repo)
+		if err != nil {
+			app.console.Warnf("Failed to index %s: %s\n", repo, err.Error())
+			failed = append(failed, repo)
+			continue
+		}
+		cat.Replace(repo, entries, time.Now())
+		app.console.Printf("Indexed %d targets of %s\n", len(entries), repo)
+	}
+	err = cat.Save(catalogPath)
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("failed to index %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// indexCatalogRepo returns the catalog entries of the targets of the
+// repository, which is resolved as builds resolve it: out of the workspace
+// or remote sources, if it is mapped there, or else cloned via git.
+func (app *earthlyApp) indexCatalogRepo(ctx context.Context, workspace *buildcontext.Workspace, gitLookup *buildcontext.GitLookup, repo string) ([]catalog.Entry, error) {
+	if local, ok := workspace.Override(domain.Target{GitURL: repo, Target: "base"}); ok {
+		return catalog.IndexDir(ctx, repo, local.GetLocalPath(), app.console.Warnf)
+	}
+	if app.offline {
+		return nil, errors.New("the repository cannot be cloned in --offline mode")
+	}
+	cloneURL, subDir, auth, err := gitLookup.GetCloneURL(repo)
+	if err != nil {
+		return nil, errors.Wrapf(err, "lookup %s", repo)
+	}
+	var env []string
+	if keyPath, ok := gitLookup.SSHKeys()[auth.SSHSockID]; ok {
+		env = append(env, "GIT_SSH_COMMAND=ssh -i "+shellescape.Quote(keyPath)+" -o IdentitiesOnly=yes")
+	}
+	dir, err := ioutil.TempDir("", "earthly-catalog")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp dir")
+	}
+	defer os.RemoveAll(dir)
+	err = catalog.Clone(ctx, cloneURL, dir, env)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.IndexDir(ctx, repo, filepath.Join(dir, filepath.FromSlash(subDir)), app.console.Warnf)
+}
+
+func (app *earthlyApp) actionCatalogSearch(c *cli.Context) error {
+	app.commandName = "catalogSearch"
+	catalogPath, err := app.catalogPath()
+	if err != nil {
+		return err
+	}
+	cat, err := catalog.Load(catalogPath)
+	if err != nil {
+		return err
+	}
+	if len(cat.Indexed) == 0 {
+		return errors.Errorf("the catalog is empty; run %s catalog update first", c.App.Name)
+	}
+	entries := cat.Search(strings.Join(c.Args().Slice(), " "))
+	if app.catalogJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TARGET\tDESCRIPTION\n")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\n", e.Ref(), e.Summary())
+	}
+	return w.Flush()
+}
+
+func (app *earthlyApp) actionRunnerAdd(c *cli.Context) error {
+	app.commandName = "runnerAdd"
+	if c.NArg() != 2 {
+		return errors.New("invalid number of arguments provided")
+	}
+	name, host := c.Args().Get(0), c.Args().Get(1)
+	if !runnerNameRegexp.MatchString(name) {
+		return errors.Errorf("invalid runner name %q: only letters, digits, - and _ are allowed", name)
+	}
+	rc := struct {
+		Host         string `json:"host"`
+		Port         int    `json:"port,omitempty"`
+		IdentityFile string `json:"identity_file,omitempty"`
+		Workdir      string `json:"workdir,omitempty"`
+	}{
+		Host:         host,
+		Port:         app.runnerPort,
+		IdentityFile: app.runnerIdentityFile,
+		Workdir:      app.runnerWorkdir,
+	}
+	if !app.runnerNoCheck {
+		r := earthfile2llb.Runner{Host: rc.Host, Port: rc.Port, Workdir: rc.Workdir}
+		if rc.IdentityFile != "" {
+			r.IdentityFile = fileutil.ExpandPath(rc.IdentityFile)
+		}
+		info, err := r.Probe(c.Context)
+		if err != nil {
+			return errors.Wrapf(err, "check runner %s; pass --no-check to register it anyway", name)
+		}
+		app.console.Printf("Runner %s: %s\n", name, runnerPlatform(info))
+	}
+
+	// JSON is valid YAML.
+	value, err := json.Marshal(rc)
+	if err != nil {
+		return errors.Wrap(err, "marshal runner")
+	}
+	inConfig, err := config.ReadConfigFile(app.configPath, c.IsSet("config"))
+	if err != nil {
+		return errors.Wrap(err, "read config")
+	}
+	outConfig, err := config.UpsertConfig(inConfig, "runners."+name, string(value))
+	if err != nil {
+		return errors.Wrap(err, "upsert config")
+	}
+	err = config.WriteConfigFile(app.configPath, outConfig)
+	if err != nil {
+		return errors.Wrap(err, "write config")
+	}
+	app.console.Printf("Registered runner %s; use it via LOCALLY --runner=%s\n", name, name)
+	return nil
+}
+
+func (app *earthlyApp) actionRunnerList(c *cli.Context) error {
+	app.commandName = "runnerList"
+	names := make([]string, 0, len(app.cfg.Runners))
+	for name := range app.cfg.Runners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tHOST\tWORKDIR\n")
+	for _, name := range names {
+		rc := app.cfg.Runners[name]
+		host := rc.Host
+		if rc.Port != 0 {
+			host = fmt.Sprintf("%s:%d", host, rc.Port)
+		}
+		workdir := rc.Workdir
+		if workdir == "" {
+			workdir = "earthly-runner"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, host, workdir)
+	}
+	return w.Flush()
+}
+
+func (app *earthlyApp) actionRunnerCheck(c *cli.Context) error {
+	app.commandName = "runnerCheck"
+	if c.NArg() != 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	name := c.Args().First()
+	r, ok := app.runners()[name]
+	if !ok {
+		return errors.Errorf("runner %s is not registered; register it via %s runner add", name, c.App.Name)
+	}
+	info, err := r.Probe(c.Context)
+	if err != nil {
+		return errors.Wrapf(err, "check runner %s", name)
+	}
+	fmt.Printf("%s\n", runnerPlatform(info))
+	return nil
+}
+
+// runnerPlatform describes the platform of a runner, as in
+// Darwin/arm64, Xcode 15.2.
+func runnerPlatform(info earthfile2llb.RunnerInfo) string {
+	platform := fmt.Sprintf("%s/%s", info.OS, info.Arch)
+	switch {
+	case info.Xcode != "":
+		platform += ", " + info.Xcode
+	case info.OS == "Darwin":
+		platform += ", Xcode not installed"
+	}
+	return platform
+}
+
+func (app *earthlyApp) catalogPath() (string, error) {
+	earthlyDir, err := cliutil.GetOrCreateEarthlyDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(earthlyDir, "catalog.json"), nil
+}
+
+func (app *earthlyApp) actionLint(c *cli.Context) error {
+	app.commandName = "lint"
+	if c.NArg() > 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	rules := lint.Rules()
+	if app.lintListRules {
+		for _, r := range rules {
+			fmt.Printf("%-20s %s\n", r.Name, r.Description)
+		}
+		return nil
+	}
+	known := make(map[string]bool)
+	for _, r := range rules {
+		known[r.Name] = true
+	}
+	disabled := make(map[string]bool)
+	for _, name := range app.lintDisable.Value() {
+		if !known[name] {
+			return errors.Errorf("unknown lint rule %s", name)
+		}
+		disabled[name] = true
+	}
+	enabled := make([]lint.Rule, 0, len(rules))
+	for _, r := range rules {
+		if !disabled[r.Name] {
+			enabled = append(enabled, r)
+		}
+	}
+	dir := "."
+	if c.NArg() == 1 {
+		dir = c.Args().First()
+	}
+	earthfilePath := filepath.Join(dir, "Earthfile")
+	ef, err := ast.Parse(c.Context, earthfilePath, true)
+	if err != nil {
+		return errors.Wrapf(err, "parse %s", earthfilePath)
+	}
+	issues := lint.Lint(ef, enabled)
+	if app.lintShellCheck {
+		shellIssues, err := lint.ShellCheck(c.Context, ef)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, shellIssues...)
+		sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	}
+	for _, issue := range issues {
+		in := ""
+		if issue.Target != "" {
+			in = fmt.Sprintf(" (in %s)", issue.Target)
+		}
+		fmt.Printf("%s:%s%s\n", earthfilePath, issue.String(), in)
+	}
+	if len(issues) > 0 {
+		return errors.Errorf("%d issue(s) found in %s", len(issues), earthfilePath)
+	}
+	return nil
+}
+
+func (app *earthlyApp) actionTelemetryShow(c *cli.Context) error {
+	app.commandName = "telemetryShow"
+	if c.NArg() != 0 {
+		return errors.New("invalid number of arguments provided")
+	}
+	if !app.cfg.Global.TelemetryEnabled {
+		app.console.Printf("Telemetry is disabled. It can be enabled via the telemetry_enabled config setting.\n")
+	}
+	r, err := telemetry.Load(filepath.Join(cliutil.GetEarthlyDir(), "telemetry.json"))
+	if err != nil {
+		return err
+	}
+	dt, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal telemetry report")
+	}
+	fmt.Println(string(dt))
+	return nil
+}
+
+func (app *earthlyApp) actionPrefetch(c *cli.Context) error {
+	app.commandName = "prefetch"
+	if app.offline {
+		return errors.New("prefetch cannot be used in --offline mode")
+	}
+	flagArgs, nonFlagArgs, err := variables.ParseFlagArgsWithNonFlags(c.Args().Slice())
+	if err != nil {
+		return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+	}
+	var targets []domain.Target
+	if app.prefetchAll {
+		if len(nonFlagArgs) != 0 {
+			return errors.New("--all cannot be combined with explicit targets")
+		}
+		names, err := earthfile2llb.GetTargets("Earthfile")
+		if err != nil {
+			return errors.Wrap(err, "get targets of ./Earthfile")
+		}
+		for _, name := range names {
+			targets = append(targets, domain.Target{LocalPath: ".", Target: name})
+		}
+	} else {
+		for _, targetName := range nonFlagArgs {
+			target, err := domain.ParseTarget(targetName)
+			if err != nil {
+				return errors.Wrapf(err, "parse target name %s", targetName)
+			}
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return errors.Errorf("no target reference provided. Try %s prefetch +<target-name>", c.App.Name)
+	}
+	return app.buildWithFailover(c, flagArgs, []string{targets[0].String()}, targets)
+}
+
+func (app *earthlyApp) actionChangelog(c *cli.Context) error {
+	app.commandName = "changelog"
+	if c.NArg() != 0 {
+		return errors.New("invalid arguments")
+	}
+	from := app.changelogFrom
+	if from == "" {
+		tag, _, err := gitutil.LatestVersionTag(c.Context, ".")
+		if err != nil {
+			return err
+		}
+		from = tag
+	}
+	commits, err := gitutil.Log(c.Context, ".", from, app.changelogTo)
+	if err != nil {
+		return err
+	}
+	changelog := gitutil.NewChangelog(commits).Markdown()
+	if app.changelogOutput == "" {
+		fmt.Print(changelog)
+		return nil
+	}
+	err = os.MkdirAll(filepath.Dir(app.changelogOutput), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "create dir for %s", app.changelogOutput)
+	}
+	err = ioutil.WriteFile(app.changelogOutput, []byte(changelog), 0644)
+	if err != nil {
+		return errors.Wrapf(err, "write %s", app.changelogOutput)
+	}
+	return nil
+}
+
+func (app *earthlyApp) actionCoverage(c *cli.Context) error {
+	app.commandName = "coverage"
+	if app.coverageFailUnder < 0 || app.coverageFailUnder > 100 {
+		return errors.New("--fail-under must be a percentage between 0 and 100")
+	}
+	inputs, err := coverage.Inputs(c.Args().Slice())
+	if err != nil {
+		return err
+	}
+	report, err := coverage.ReadFiles(inputs)
+	if err != nil {
+		return err
+	}
+	output := app.coverageOutput
+	if output == "" {
+		output = filepath.Join(coverage.DefaultDir, coverage.MergedName+report.Extension())
+	}
+	dt, err := report.Bytes()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(output), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "create dir for %s", output)
+	}
+	err = ioutil.WriteFile(output, dt, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "write %s", output)
+	}
+	app.console.Printf("Merged %d %s coverage reports into %s: %s\n", len(inputs), report.Format, output, report.Summary())
+	if c.IsSet("fail-under") {
+		return report.CheckThreshold(app.coverageFailUnder)
+	}
+	return nil
+}
+
+func (app *earthlyApp) actionRelease(c *cli.Context) error {
+	app.commandName = "release"
+	if c.NArg() != 0 {
+		return errors.New("invalid arguments")
+	}
+	opt := releaser.Opt{
+		Dir:     ".",
+		Version: app.releaseVersion,
+		Remote:  app.releaseRemote,
+		Assets:  app.releaseAssets.Value(),
+		DryRun:  app.releaseDryRun,
+		Restart: app.releaseRestart,
+		Build: func(ctx context.Context, version, tag, changelog string) error {
+			app.push = true
+			flagArgs := []string{"RELEASE_VERSION=" + version, "RELEASE_TAG=" + tag, "RELEASE_CHANGELOG=" + changelog}
+			return app.buildWithFailover(c, flagArgs, []string{app.releaseTarget}, nil)
+		},
+	}
+	if app.releaseProvider != "none" && !app.releaseDryRun {
+		remoteURL, err := releaser.RemoteURL(c.Context, opt.Dir, app.releaseRemote)
+		if err != nil {
+			return err
+		}
+		token := os.Getenv("GITHUB_TOKEN")
+		if app.releaseProvider == "gitlab" || (app.releaseProvider == "" && strings.Contains(remoteURL, "gitlab")) {
+			token = os.Getenv("GITLAB_TOKEN")
+		}
+		opt.Host, err = releaser.NewHost(remoteURL, app.releaseProvider, token)
+		if err != nil {
+			return err
+		}
+	}
+	return releaser.Run(c.Context, app.console, opt)
+}
+
+func (app *earthlyApp) actionMultiRun(c *cli.Context) error {
+	app.commandName = "multiRun"
+	if app.multiPattern == "" {
+		return errors.New("--pattern is required")
+	}
+	flagArgs, nonFlagArgs, err := variables.ParseFlagArgsWithNonFlags(c.Args().Slice())
+	if err != nil {
+		return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+	}
+	if len(nonFlagArgs) != 1 || !strings.HasPrefix(nonFlagArgs[0], "+") {
+		return errors.Errorf("a single target name is required. Try %s multi run --pattern <glob> +<target-name>", c.App.Name)
+	}
+	targetName := nonFlagArgs[0]
+	services, err := monorepo.Discover(app.multiPattern)
+	if err != nil {
+		return err
+	}
+	var changed map[string]bool
+	if app.multiSince != "" {
+		files, err := monorepo.ChangedFiles(c.Context, app.multiSince)
+		if err != nil {
+			return err
+		}
+		changed = monorepo.Changed(services, files, app.multiShared.Value())
+	}
+	app.multiResults = nil
+	app.multiTargets = nil
+	for _, svc := range services {
+		result := monorepo.Result{Service: svc, Target: targetName}
+		if changed != nil && !changed[svc.Dir] {
+			result.Skipped = true
+			app.multiResults = append(app.multiResults, result)
+			continue
+		}
+		ref := svc.Dir
+		switch {
+		case ref == ".":
+			ref = ""
+		case !strings.HasPrefix(ref, "/") && !strings.HasPrefix(ref, "."):
+			ref = "./" + ref
+		}
+		target, err := domain.ParseTarget(ref + targetName)
+		if err != nil {
+			return errors.Wrapf(err, "parse target name %s", ref+targetName)
+		}
+		app.multiResults = append(app.multiResults, result)
+		app.multiTargets = append(app.multiTargets, target)
+	}
+	if len(app.multiTargets) == 0 {
+		app.console.Printf("No Earthfile changed since %s\n", app.multiSince)
+		return monorepo.PrintMatrix(os.Stdout, app.multiResults)
+	}
+	return app.buildWithFailover(c, flagArgs, []string{app.multiTargets[0].String()}, nil)
+}
+
+func (app *earthlyApp) actionSelftest(c *cli.Context) error {
+	app.commandName = "selftest"
+	flagArgs, dirs, err := variables.ParseFlagArgsWithNonFlags(c.Args().Slice())
+	if err != nil {
+		return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+	}
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	app.selftestCases, err = selftest.Discover(c.Context, dirs, app.selftestRun)
+	if err != nil {
+		return err
+	}
+	if app.selftestCacheNamespace == "" {
+		app.selftestCacheNamespace = selftest.NewCacheNamespace()
+	}
+	app.console.VerbosePrintf("Running %d self-tests in cache namespace %s\n", len(app.selftestCases), app.selftestCacheNamespace)
+	return app.buildWithFailover(c, flagArgs, []string{app.selftestCases[0].Target.String()}, nil)
+}
+
+func (app *earthlyApp) actionDev(c *cli.Context) error {
+	app.commandName = "dev"
+	flagArgs, nonFlagArgs, err := variables.ParseFlagArgsWithNonFlags(c.Args().Slice())
+	if err != nil {
+		return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+	}
+	if len(nonFlagArgs) != 1 {
+		return errors.Errorf("a single target is required. Try %s dev +<target-name>", c.App.Name)
+	}
+	if app.push {
+		return errors.New("--push cannot be used with earthly dev")
+	}
+	target, err := domain.ParseTarget(nonFlagArgs[0])
+	if err != nil {
+		return errors.Wrapf(err, "parse target name %s", nonFlagArgs[0])
+	}
+	if target.IsRemote() {
+		return errors.Errorf("earthly dev requires a local target, as its directory is mounted in the container: %s", target)
+	}
+	var extraPorts []devenv.Port
+	for _, s := range app.devPorts.Value() {
+		p, err := devenv.ParsePort(s)
+		if err != nil {
+			return err
+		}
+		extraPorts = append(extraPorts, p)
+	}
+	contextDir, err := filepath.Abs(target.GetLocalPath())
+	if err != nil {
+		return errors.Wrapf(err, "get absolute path of %s", target.GetLocalPath())
+	}
+
+	app.imageMode = true
+	app.artifactMode = false
+	app.devImageTag = devenv.ImageTag(target, contextDir)
+	err = app.buildWithFailover(c, flagArgs, nonFlagArgs, nil)
+	if err != nil {
+		return err
+	}
+	cfg, err := devenv.InspectImage(c.Context, app.devImageTag)
+	if err != nil {
+		return err
+	}
+	if app.devContainerJSON {
+		path, err := devenv.WriteDevContainer(contextDir, devenv.NewDevContainer(target, app.devImageTag, cfg, extraPorts))
+		if err != nil {
+			return err
+		}
+		app.console.Printf("Wrote %s\n", path)
+	}
+	if app.devNoRun {
+		return nil
+	}
+	args := devenv.RunArgs(app.devImageTag, cfg, devenv.RunOpt{
+		ContextDir: contextDir,
+		Name:       devenv.ContainerName(target, contextDir),
+		Shell:      app.devShell,
+		ExtraPorts: extraPorts,
+	})
+	app.console.Printf("Starting %s in %s\n", app.devImageTag, cfg.Workspace())
+	return devenv.Run(c.Context, args)
+}
+
+func (app *earthlyApp) actionUp(c *cli.Context) error {
+	app.commandName = "up"
+	flagArgs, nonFlagArgs, err := variables.ParseFlagArgsWithNonFlags(c.Args().Slice())
+	if err != nil {
+		return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+	}
+	if len(nonFlagArgs) != 1 {
+		return errors.Errorf("a single target is required. Try %s up +<target-name>", c.App.Name)
+	}
+	if app.push {
+		return errors.New("--push cannot be used with earthly up")
+	}
+	target, err := domain.ParseTarget(nonFlagArgs[0])
+	if err != nil {
+		return errors.Wrapf(err, "parse target name %s", nonFlagArgs[0])
+	}
+	if target.IsRemote() {
+		return errors.Errorf("earthly up requires a local target: %s", target)
+	}
+	var extraPorts []devenv.Port
+	for _, s := range app.devPorts.Value() {
+		p, err := devenv.ParsePort(s)
+		if err != nil {
+			return err
+		}
+		extraPorts = append(extraPorts, p)
+	}
+	contextDir, err := filepath.Abs(target.GetLocalPath())
+	if err != nil {
+		return errors.Wrapf(err, "get absolute path of %s", target.GetLocalPath())
+	}
+	composeTarget := target
+	if app.upComposeFrom != "" {
+		composeTarget, err = domain.ParseTarget(app.upComposeFrom)
+		if err != nil {
+			return errors.Wrapf(err, "parse target name %s", app.upComposeFrom)
+		}
+		if composeTarget.IsRemote() {
+			return errors.Errorf("--compose-from requires a local target: %s", composeTarget)
+		}
+	}
+	composeDir, err := filepath.Abs(composeTarget.GetLocalPath())
+	if err != nil {
+		return errors.Wrapf(err, "get absolute path of %s", composeTarget.GetLocalPath())
+	}
+	compose, err := devenv.ComposeOfTarget(c.Context, filepath.Join(composeDir, "Earthfile"), composeTarget.GetName())
+	if err != nil {
+		return err
+	}
+
+	app.imageMode = true
+	app.artifactMode = false
+	app.devImageTag = devenv.ImageTag(target, contextDir)
+	err = app.buildWithFailover(c, flagArgs, nonFlagArgs, nil)
+	if err != nil {
+		return err
+	}
+	cfg, err := devenv.InspectImage(c.Context, app.devImageTag)
+	if err != nil {
+		return err
+	}
+	opt := devenv.UpOpt{
+		ContextDir: composeDir,
+		Name:       devenv.UpName(target, contextDir),
+		ExtraPorts: extraPorts,
+		Compose:    compose,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+	}
+	for _, p := range devenv.Ports(cfg, extraPorts) {
+		app.console.Printf("Publishing port %s of %s\n", p, target)
+	}
+	if !compose.Empty() {
+		app.console.Printf("Starting the compose services of %s\n", composeTarget)
+	}
+	app.console.Printf("Running %s (press Ctrl-C to stop)\n", target)
+	return devenv.Up(c.Context, app.devImageTag, cfg, opt)
+}
+
+func (app *earthlyApp) previewOpt() preview.Opt {
+	return preview.Opt{
+		KubeContext:     app.cfg.Global.PreviewKubeContext,
+		NamespacePrefix: app.cfg.Global.PreviewNamespacePrefix,
+	}
+}
+
+func (app *earthlyApp) actionPreviewCreate(c *cli.Context) error {
+	app.commandName = "previewCreate"
+	if app.previewPR <= 0 {
+		return errors.New("the number of the pull request is required. Try --pr <n>")
+	}
+	flagArgs, nonFlagArgs, err := variables.ParseFlagArgsWithNonFlags(c.Args().Slice())
+	if err != nil {
+		return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+	}
+	if len(nonFlagArgs) > 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	targetName := "+" + preview.DefaultTarget
+	if len(nonFlagArgs) == 1 {
+		targetName = nonFlagArgs[0]
+	}
+	target, err := domain.ParseTarget(targetName)
+	if err != nil {
+		return errors.Wrapf(err, "parse target name %s", targetName)
+	}
+	if target.IsRemote() {
+		return errors.Errorf("earthly preview requires a local target, as its manifest template is read from its directory: %s", target)
+	}
+	manifestPath := filepath.Join(target.GetLocalPath(), app.previewManifest)
+	tmpl, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return errors.Wrapf(err, "read manifest template %s", manifestPath)
+	}
+	opt := app.previewOpt()
+	data := opt.DataOf(app.previewPR)
+	manifests, err := preview.Render(manifestPath, tmpl, data)
+	if err != nil {
+		return err
+	}
+
+	app.push = true
+	flagArgs = append(flagArgs, fmt.Sprintf("--%s=%s", preview.IDArg, data.ID))
+	err = app.buildWithFailover(c, flagArgs, []string{target.String()}, nil)
+	if err != nil {
+		return err
+	}
+	err = preview.Apply(c.Context, opt, app.previewPR, manifests)
+	if err != nil {
+		return err
+	}
+	app.console.Printf("Deployed the preview environment of pull request #%d to namespace %s\n", app.previewPR, data.Namespace)
+	return nil
+}
+
+func (app *earthlyApp) actionPreviewDestroy(c *cli.Context) error {
+	app.commandName = "previewDestroy"
+	if c.NArg() != 0 {
+		return errors.New("invalid number of arguments provided")
+	}
+	if app.previewPR <= 0 {
+		return errors.New("the number of the pull request is required. Try --pr <n>")
+	}
+	opt := app.previewOpt()
+	err := preview.Destroy(c.Context, opt, app.previewPR)
+	if err != nil {
+		return err
+	}
+	app.console.Printf("Destroyed the preview environment of pull request #%d (namespace %s)\n", app.previewPR, opt.Namespace(app.previewPR))
+	return nil
+}
+
+func (app *earthlyApp) actionPreviewListen(c *cli.Context) error {
+	app.commandName = "previewListen"
+	if c.NArg() != 0 {
+		return errors.New("invalid number of arguments provided")
+	}
+	if app.previewWebhookSecret == "" {
+		return errors.New("a webhook secret is required. Try --webhook-secret or EARTHLY_PREVIEW_WEBHOOK_SECRET")
+	}
+	opt := app.previewOpt()
+	server := &http.Server{
+		Addr: app.previewListenAddr,
+		Handler: &preview.WebhookHandler{
+			Secret: []byte(app.previewWebhookSecret),
+			Destroy: func(ctx context.Context, pr int) error {
+				return preview.Destroy(ctx, opt, pr)
+			},
+			Logf: app.console.Printf,
+		},
+	}
+	go func() {
+		<-c.Context.Done()
+		server.Close()
+	}()
+	app.console.Printf("Listening for pull request webhooks on %s\n", app.previewListenAddr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		return errors.Wrapf(err, "listen on %s", app.previewListenAddr)
+	}
+	return nil
+}
+
+func (app *earthlyApp) actionDiff(c *cli.Context) error {
+	app.commandName = "diff"
+	flagArgs, nonFlagArgs, err := variables.ParseFlagArgsWithNonFlags(c.Args().Slice())
+	if err != nil {
+		return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+	}
+	if len(nonFlagArgs) != 1 {
+		return errors.Errorf("a single target is required. Try %s diff --base-ref main +<target-name>", c.App.Name)
+	}
+	if app.push {
+		return errors.New("--push cannot be used with earthly diff")
+	}
+	if app.diffBaseRef == "" && app.diffHeadRef == "" && len(app.diffBaseBuildArgs.Value()) == 0 && len(app.diffHeadBuildArgs.Value()) == 0 {
+		return errors.New("nothing to compare: specify --base-ref, --head-ref, --base-build-arg or --head-build-arg")
+	}
+	target, err := domain.ParseTarget(nonFlagArgs[0])
+	if err != nil {
+		return errors.Wrapf(err, "parse target name %s", nonFlagArgs[0])
+	}
+	sides := []struct {
+		name      string
+		ref       string
+		buildArgs []string
+	}{
+		{"base", app.diffBaseRef, app.diffBaseBuildArgs.Value()},
+		{"head", app.diffHeadRef, app.diffHeadBuildArgs.Value()},
+	}
+	var snapshots []*builddiff.Snapshot
+	for _, side := range sides {
+		sideTarget := target
+		app.diffRoot, err = filepath.Abs(".")
+		if err != nil {
+			return errors.Wrap(err, "get current directory")
+		}
+		if side.ref != "" {
+			if target.IsRemote() {
+				sideTarget.Tag = side.ref
+			} else {
+				dir, remove, err := gitutil.AddWorktree(c.Context, ".", side.ref)
+				if err != nil {
+					return errors.Wrapf(err, "check out %s", side.ref)
+				}
+				defer func() {
+					err := remove()
+					if err != nil {
+						app.console.Warnf("Failed to remove the worktree of %s: %v\n", side.ref, err)
+					}
+				}()
+				app.diffRoot = dir
+				if !filepath.IsAbs(sideTarget.LocalPath) {
+					sideTarget.LocalPath = filepath.Join(dir, sideTarget.LocalPath)
+				}
+			}
+		}
+		from := "the working tree"
+		if side.ref != "" {
+			from = side.ref
+		}
+		app.console.Printf("Building the %s from %s\n", side.name, from)
+		app.diffSnapshot = builddiff.NewSnapshot()
+		err = app.buildWithFailover(c, append(append([]string{}, flagArgs...), side.buildArgs...), []string{sideTarget.String()}, nil)
+		if err != nil {
+			return errors.Wrapf(err, "build %s", side.name)
+		}
+		snapshots = append(snapshots, app.diffSnapshot)
+	}
+	app.diffSnapshot = nil
+	report := builddiff.Compare(snapshots[0], snapshots[1])
+	if report.Empty() {
+		fmt.Println("No differences")
+	} else {
+		err = report.Print(os.Stdout)
+		if err != nil {
+			return err
+		}
+	}
+	if app.diffOutput == "" {
+		return nil
+	}
+	f, err := os.Create(app.diffOutput)
+	if err != nil {
+		return errors.Wrapf(err, "create %s", app.diffOutput)
+	}
+	defer f.Close()
+	err = report.WriteJSON(f)
+	if err != nil {
+		return errors.Wrapf(err, "write %s", app.diffOutput)
+	}
+	return f.Close()
+}
+
+func (app *earthlyApp) actionPromote(c *cli.Context) error {
+	app.commandName = "promote"
+	if c.NArg() != 2 {
+		return errors.Errorf("invalid arguments. Try %s promote <src-ref> <dst-ref>", c.App.Name)
+	}
+	src, err := registryutil.ParseRef(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	dst, err := registryutil.ParseRef(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	if dst.IsDigest() {
+		return errors.Errorf("the destination %s must be a tag", dst.String())
+	}
+	var policy *imageverify.Policy
+	if app.promoteVerify != "" {
+		p, err := imageverify.ParsePolicy(app.promoteVerify)
+		if err != nil {
+			return err
+		}
+		policy = &p
+	}
+	client := registryutil.NewClient(nil)
+	m, err := client.GetManifest(c.Context, src, registryutil.AllMediaTypes)
+	if err != nil {
+		return errors.Wrapf(err, "get manifest of %s", src.String())
+	}
+	srcDigest := src.WithReference(m.Digest)
+	dstDigest := dst.WithReference(m.Digest)
+	verifier := imageverify.NewVerifier()
+	if policy != nil {
+		err = verifier.Verify(c.Context, srcDigest.String(), *policy)
+		if err != nil {
+			return err
+		}
+	}
+	app.console.Printf("Promoting %s to %s\n", srcDigest.String(), dst.String())
+	_, err = client.Copy(c.Context, srcDigest, dstDigest)
+	if err != nil {
+		return errors.Wrapf(err, "copy %s", srcDigest.String())
+	}
+	attached, err := client.CopyAttached(c.Context, src, dst, m.Digest, app.promoteForce)
+	if err != nil {
+		return err
+	}
+	for _, tag := range attached {
+		app.console.Printf("Copied %s\n", tag)
+	}
+	if policy != nil {
+		err = verifier.Verify(c.Context, dstDigest.String(), *policy)
+		if err != nil {
+			return errors.Wrap(err, "the promoted image does not pass verification")
+		}
+	}
+	err = client.PutManifest(c.Context, dst, m)
+	if err != nil {
+		return errors.Wrapf(err, "tag %s", dst.String())
+	}
+	app.console.Printf("Promoted %s as %s\n", m.Digest, dst.String())
+	return nil
+}
+
+func (app *earthlyApp) actionDocker(c *cli.Context) error {
+	app.commandName = "docker"
+
+	dir := filepath.Dir(app.dockerfilePath)
+	earthfilePath := filepath.Join(dir, "Earthfile")
+	if fileutil.FileExists(earthfilePath) {
+		return errors.Errorf("earthfile already exists; please delete it if you wish to continue")
+	}
+	defer os.Remove(earthfilePath)
+
+	err := docker2earthly.Docker2Earthly(app.dockerfilePath, earthfilePath, app.earthfileFinalImage)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Warning: earthly does not support all dockerfile commands and is highly experimental as a result, use with caution.\n")
+
+	app.imageMode = false
+	app.artifactMode = false
+	app.interactiveDebugging = true
+	flagArgs := []string{}
+	nonFlagArgs := []string{"+build"}
+
+	return app.actionBuildImp(c, flagArgs, nonFlagArgs, nil)
+}
+
+func (app *earthlyApp) actionDocker2Earthly(c *cli.Context) error {
+	app.commandName = "docker2earthly"
+	err := docker2earthly.Docker2Earthly(app.dockerfilePath, app.earthfilePath, app.earthfileFinalImage)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "An Earthfile has been generated; to run it use: earthly +build; then run with docker run -ti %s\n", app.earthfileFinalImage)
+	return nil
+}
+
+func (app *earthlyApp) actionBake2Earthly(c *cli.Context) error {
+	app.commandName = "bake2earthly"
+	if c.NArg() != 0 {
+		return errors.New("invalid number of arguments provided")
+	}
+	err := docker2earthly.Bake2Earthly(app.bakePath, app.earthfilePath)
+	if err != nil {
+		return err
+	}
+	if app.earthfilePath != "-" {
+		app.console.Printf("An Earthfile has been generated from %s; review it, then run it with: earthly +default\n", app.bakePath)
+	}
+	return nil
+}
+
+func (app *earthlyApp) actionConfig(c *cli.Context) error {
+	app.commandName = "config"
+	if c.NArg() != 2 {
+		return errors.New("invalid number of arguments provided")
+	}
+
+	args := c.Args().Slice()
+	inConfig, err := config.ReadConfigFile(app.configPath, c.IsSet("config"))
+	if err != nil {
+		return errors.Wrap(err, "read config")
+	}
+
+	outConfig, err := config.UpsertConfig(inConfig, args[0], args[1])
+	if err != nil {
+		return errors.Wrap(err, "upsert config")
+	}
+
+	if app.configDryRun {
+		fmt.Println(string(outConfig))
+		return nil
+	}
+
+	err = config.WriteConfigFile(app.configPath, outConfig)
+	if err != nil {
+		return errors.Wrap(err, "write config")
+	}
+
+	return nil
+}
+
+func (app *earthlyApp) actionBuild(c *cli.Context) error {
+	app.commandName = "build"
+
+	if app.ci {
+		app.useInlineCache = true
+		app.noOutput = true
+		app.strict = true
+		if app.remoteCache == "" && app.push {
+			app.saveInlineCache = true
+		}
+
+		if app.interactiveDebugging {
+			return errors.New("unable to use --ci flag in combination with --interactive flag")
+		}
+	}
+	if app.imageMode && app.artifactMode {
+		return errors.New("both image and artifact modes cannot be active at the same time")
+	}
+	if (app.imageMode && app.noOutput) || (app.artifactMode && app.noOutput) {
+		if app.ci {
+			app.noOutput = false
+		} else {
+			return errors.New("cannot use --no-output with image or artifact modes")
+		}
+	}
+	if app.imageMode && app.noLoad {
+		return errors.New("cannot use --no-load with image mode")
+	}
+	if (app.imageMode || app.artifactMode) && len(app.outputOnly.Value()) != 0 {
+		return errors.New("cannot use --output-only with image or artifact modes")
+	}
+
+	flagArgs, nonFlagArgs, err := variables.ParseFlagArgsWithNonFlags(c.Args().Slice())
+	if err != nil {
+		return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+	}
+	if app.selectTarget && len(nonFlagArgs) != 0 {
+		return errors.New("--select cannot be used when a target is specified")
+	}
+	if len(nonFlagArgs) == 0 && !app.artifactMode && (app.selectTarget || (targetpicker.IsInteractive() && fileutil.FileExists("Earthfile"))) {
+		if !targetpicker.IsInteractive() {
+			return errors.New("--select requires an interactive terminal")
+		}
+		targetName, err := app.pickTarget(c.Context)
+		if err != nil {
+			return err
+		}
+		nonFlagArgs = []string{targetName}
+	}
+	if len(nonFlagArgs) > 1 && !app.imageMode && !app.artifactMode {
+		// Multiple targets, as in earthly +a --FOO=1 +b: each target takes the
+		// build args which follow it.
+		var groups [][]string
+		nonFlagArgs, groups, err = variables.ParseFlagArgsPerNonFlag(c.Args().Slice())
+		if err != nil {
+			return errors.Wrapf(err, "parse args %s", strings.Join(c.Args().Slice(), " "))
+		}
+		app.otherTargets = nonFlagArgs[1:]
+		app.otherTargetFlagArgs = groups[1:]
+		return app.buildWithFailover(c, groups[0], nonFlagArgs[:1], nil)
+	}
+
+	return app.buildWithFailover(c, flagArgs, nonFlagArgs, nil)
+}
+
+// pickTarget lets the user pick the target to build, among those of the
+// Earthfile in the current directory and of the local Earthfiles it imports.
+func (app *earthlyApp) pickTarget(ctx context.Context) (string, error) {
+	entries, err := targetpicker.List(ctx, ".")
+	if err != nil {
+		return "", err
+	}
+	entry, ok, err := targetpicker.Pick(os.Stdin, os.Stderr, entries)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", errors.New("no target picked")
+	}
+	app.console.Printf("Building %s\n", entry.Target)
+	return entry.Target, nil
+}
+
+// buildWithFailover runs the build, retrying it on the configured failover
+// buildkit hosts if the connection to the current host is lost mid-build,
+// before anything was pushed or any LOCALLY command ran, as those would be
+// repeated by the retry. The overall build deadline (--timeout) applies across
+// all attempts. If prefetchTargets is non-nil, the images of these targets are
+// prefetched rather than built.
+func (app *earthlyApp) buildWithFailover(c *cli.Context, flagArgs, nonFlagArgs []string, prefetchTargets []domain.Target) error {
+	if app.buildTimeout != 0 {
+		ctx, cancel := context.WithTimeout(c.Context, app.buildTimeout)
+		defer cancel()
+		c.Context = ctx
+	}
+	failoverHosts := app.cfg.Global.BuildkitFailoverHosts
+	for {
+		atomic.StoreInt32(&app.sideEffects, 0)
+		err := app.actionBuildImp(c, flagArgs, nonFlagArgs, prefetchTargets)
+		if err != nil && errors.Is(c.Context.Err(), context.DeadlineExceeded) {
+			return errors.Wrapf(err, "build did not complete within the %s deadline (--timeout)", app.buildTimeout)
+		}
+		if err == nil || len(failoverHosts) == 0 || !buildkitd.IsWorkerLost(err) {
+			return err
+		}
+		lostHost := app.buildkitdSettings.BuildkitAddress
+		if atomic.LoadInt32(&app.sideEffects) != 0 {
+			return errors.Wrapf(err, "lost connection to buildkit at %s; not retrying the build on %s, as it already pushed or ran LOCALLY commands, which the retry would repeat",
+				lostHost, failoverHosts[0])
+		}
+		pubErr := buildkitd.NewScalingHook(app.cfg.Global.BuildkitScalingHook).Publish(c.Context, buildkitd.Demand{
+			Event:   buildkitd.ScalingEventWorkerLost,
+			BuildID: app.sessionID,
+			Host:    lostHost,
+		})
+		if pubErr != nil {
+			app.console.Warnf("Warning: %s\n", pubErr.Error())
+		}
+		app.buildkitdSettings.BuildkitAddress = failoverHosts[0]
+		failoverHosts = failoverHosts[1:]
+		app.console.Warnf("Lost connection to buildkit at %s (%s); retrying the build on %s\n",
+			lostHost, err.Error(), app.buildkitdSettings.BuildkitAddress)
+	}
+}
+
+// loadWorkspace loads the workspace file given via --workspace, or found in
+// the current directory or its parents, and applies the --import-override
+// flags on top of it. It returns nil if there is neither, or if workspaces
+// are turned off and there are no overrides.
+func (app *earthlyApp) loadWorkspace(ctx context.Context) (*buildcontext.Workspace, error) {
+	var w *buildcontext.Workspace
+	if len(app.cfg.RemoteSources) > 0 {
+		earthlyDir, err := cliutil.GetOrCreateEarthlyDir()
+		if err != nil {
+			return nil, err
+		}
+		f := &remotesource.Fetcher{
+			Dir:     filepath.Join(earthlyDir, "remote-sources"),
+			Offline: app.offline,
+		}
+		w = buildcontext.NewWorkspace("")
+		for _, repo := range sortedRemoteSources(app.cfg.RemoteSources) {
+			rs := app.cfg.RemoteSources[repo]
+			dir, err := f.Fetch(ctx, remotesource.Source{Repo: repo, URL: rs.URL, SHA256: rs.SHA256})
+			if err != nil {
+				return nil, errors.Wrap(err, "remote_sources")
+			}
+			err = w.Add(repo, dir, "")
+			if err != nil {
+				return nil, errors.Wrap(err, "remote_sources")
+			}
+			app.console.VerbosePrintf("Remote source: using %s for %s\n", rs.URL, repo)
+		}
+	}
+	p := app.workspacePath
+	if p == "" {
+		var err error
+		p, err = buildcontext.FindWorkspace(".")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p != "" && p != "off" {
+		ws, err := buildcontext.LoadWorkspace(p)
+		if err != nil {
+			return nil, err
+		}
+		if w == nil {
+			w = ws
+		} else {
+			w.Path = ws.Path
+			for repo, dir := range ws.Use {
+				err := w.Add(repo, dir, "")
+				if err != nil {
+					return nil, errors.Wrapf(err, "workspace file %s", p)
+				}
+			}
+		}
+		for _, repo := range sortedKeys(ws.Use) {
+			app.console.Printf("Workspace %s: using %s for %s\n", p, ws.Use[repo], repo)
+		}
+	}
+	if app.mock != nil {
+		if w == nil {
+			w = buildcontext.NewWorkspace("")
+		}
+		for _, repo := range sortedKeys(app.mock.Imports) {
+			err := w.Add(repo, app.mock.Imports[repo], app.mock.Dir)
+			if err != nil {
+				return nil, errors.Wrapf(err, "mock file %s", app.mockPath)
+			}
+			app.console.VerbosePrintf("Mock: using %s for %s\n", w.Use[strings.TrimSuffix(repo, "/")], repo)
+		}
+	}
+	overrides := app.importOverrides.Value()
+	if len(overrides) == 0 {
+		return w, nil
+	}
+	if w == nil {
+		w = buildcontext.NewWorkspace("")
+	}
+	for _, o := range overrides {
+		repo, dir, ok := variables.ParseKeyValue(o)
+		if !ok || !strings.Contains(repo, "/") {
+			return nil, errors.Errorf("invalid --import-override %q, expected <repository>=<path>, as in github.com/org/lib=../lib", o)
+		}
+		err := w.Add(repo, dir, ".")
+		if err != nil {
+			return nil, errors.Wrapf(err, "--import-override %s", o)
+		}
+		app.console.Printf("Import override: using %s for %s\n", w.Use[strings.TrimSuffix(repo, "/")], repo)
+	}
+	return w, nil
+}
+
+func sortedRemoteSources(m map[string]config.RemoteSourceConfig) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// loadImageIndex loads the index of previously resolved images, used for
+// resolving images in offline mode.
+func (app *earthlyApp) loadImageIndex() (*imageindex.Index, error) {
+	earthlyDir, err := cliutil.GetOrCreateEarthlyDir()
+	if err != nil {
+		return nil, err
+	}
+	return imageindex.Load(filepath.Join(earthlyDir, "image-index.json"))
+}
+
+// loadImageBudgets loads the usage of the images in the last builds in which
+// they were within their SAVE IMAGE budgets.
+func (app *earthlyApp) loadImageBudgets() (*imagebudget.History, error) {
+	earthlyDir, err := cliutil.GetOrCreateEarthlyDir()
+	if err != nil {
+		return nil, err
+	}
+	return imagebudget.Load(filepath.Join(earthlyDir, "image-budgets.json"))
+}
+
+// warnIfArgContainsBuildArg will issue a warning if a flag is incorrectly prefixed with build-arg.
+// TODO this check should be replaced with a warning if an arg was given but never used.
+func (app *earthlyApp) warnIfArgContainsBuildArg(flagArgs []string) {
+	for _, flag := range flagArgs {
+		if strings.HasPrefix(flag, "build-arg=") || strings.HasPrefix(flag, "buildarg=") {
+			app.console.Warnf("Found a flag named %q; flags after the build target should be specified as --KEY=VAL\n", flag)
+		}
+	}
+}
+
+// dnsConfig returns the name resolution of RUN commands, as per the flags.
+func (app *earthlyApp) dnsConfig() earthfile2llb.DNSConfig {
+	return earthfile2llb.DNSConfig{
+		Nameservers:   app.dnsServers.Value(),
+		SearchDomains: app.dnsSearch.Value(),
+		ExtraHosts:    app.addHosts.Value(),
+	}
+}
+
+// exportCompression returns the compression of the layers of the images
+// exported, as per the flags.
+func (app *earthlyApp) exportCompression() builder.Compression {
+	return builder.Compression{
+		Type:  app.compression,
+		Level: app.compressionLevel,
+		Force: app.forceCompression,
+	}
+}
+
+func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []string, prefetchTargets []domain.Target) error {
+	app.warnIfArgContainsBuildArg(flagArgs)
+	err := app.exportCompression().Validate()
+	if err != nil {
+		return err
+	}
+	err = app.dnsConfig().Validate()
+	if err != nil {
+		return err
+	}
+	err = earthfile2llb.ValidateDefaultUser(app.defaultUser)
+	if err != nil {
+		return err
+	}
+	flagArgs, argOverrides, err := extractArgOverrides(flagArgs)
+	if err != nil {
+		return err
+	}
+	otherTargetFlagArgs := make([][]string, 0, len(app.otherTargetFlagArgs))
+	for _, otherFlagArgs := range app.otherTargetFlagArgs {
+		otherFlagArgs, otherArgOverrides, err := extractArgOverrides(otherFlagArgs)
+		if err != nil {
+			return err
+		}
+		otherTargetFlagArgs = append(otherTargetFlagArgs, otherFlagArgs)
+		argOverrides = append(argOverrides, otherArgOverrides...)
+	}
+	for _, ao := range argOverrides {
+		err := earthfile2llb.ValidateArgOverride(c.Context, ao)
+		if err != nil {
+			return err
+		}
+	}
+	if app.mockPath != "" {
+		var err error
+		app.mock, err = mock.Load(app.mockPath)
+		if err != nil {
+			return err
+		}
+		app.mockRecorder = mock.NewRecorder()
+		if app.push {
+			app.console.Warnf("--push has no effect in mock mode: pushes are recorded instead\n")
+			app.push = false
+		}
+	} else if app.mockRecordPath != "" {
+		return errors.New("--mock-record requires --mock")
+	}
+	if app.sourceDateEpochStr != "" {
+		sec, err := strconv.ParseInt(app.sourceDateEpochStr, 10, 64)
+		if err != nil || sec < 0 {
+			return errors.Errorf("invalid --source-date-epoch %s: must be a unix timestamp in seconds", app.sourceDateEpochStr)
+		}
+		t := time.Unix(sec, 0).UTC()
+		app.sourceDateEpoch = &t
+	}
+	if app.randomSeed != "" {
+		_, err := strconv.ParseUint(app.randomSeed, 10, 32)
+		if err != nil {
+			return errors.Errorf("invalid --random-seed %s: must be an integer between 0 and 4294967295", app.randomSeed)
+		}
+	}
+	if app.offline {
+		switch {
+		case app.pull:
+			return errors.New("--pull cannot be used in --offline mode")
+		case app.push:
+			return errors.New("--push cannot be used in --offline mode")
+		case app.remoteCache != "":
+			return errors.New("--remote-cache cannot be used in --offline mode")
+		}
 	}
-}
-func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []string) error {
-	app.warnIfArgContainsBuildArg(flagArgs)
 	var target domain.Target
 	var artifact domain.Artifact
 	destPath := "./"
@@ -2581,7 +5536,7 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 			cli.ShowAppHelp(c)
 			return errors.Errorf("invalid arguments %s", strings.Join(nonFlagArgs, " "))
 		}
-		targetName := nonFlagArgs[0]
+		targetName := wslutil.HostRef(nonFlagArgs[0])
 		var err error
 		target, err = domain.ParseTarget(targetName)
 		if err != nil {
@@ -2596,9 +5551,9 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 			cli.ShowAppHelp(c)
 			return errors.Errorf("invalid arguments %s", strings.Join(nonFlagArgs, " "))
 		}
-		artifactName := nonFlagArgs[0]
+		artifactName := wslutil.HostRef(nonFlagArgs[0])
 		if len(nonFlagArgs) == 2 {
-			destPath = nonFlagArgs[1]
+			destPath = wslutil.HostPath(nonFlagArgs[1])
 		}
 		var err error
 		artifact, err = domain.ParseArtifact(artifactName)
@@ -2615,18 +5570,94 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 			cli.ShowAppHelp(c)
 			return errors.Errorf("invalid arguments %s", strings.Join(nonFlagArgs, " "))
 		}
-		targetName := nonFlagArgs[0]
+		targetName := wslutil.HostRef(nonFlagArgs[0])
 		var err error
 		target, err = domain.ParseTarget(targetName)
 		if err != nil {
 			return errors.Wrapf(err, "parse target name %s", targetName)
 		}
 	}
+	otherTargets := make([]domain.Target, 0, len(app.otherTargets))
+	for _, targetName := range app.otherTargets {
+		otherTarget, err := domain.ParseTarget(targetName)
+		if err != nil {
+			return errors.Wrapf(err, "parse target name %s", targetName)
+		}
+		otherTargets = append(otherTargets, otherTarget)
+	}
+	outputOnly := make([]domain.Target, 0, len(app.outputOnly.Value()))
+	for _, targetName := range app.outputOnly.Value() {
+		t, err := domain.ParseTarget(targetName)
+		if err != nil {
+			return errors.Wrapf(err, "parse --output-only target name %s", targetName)
+		}
+		outputOnly = append(outputOnly, t)
+	}
+	if app.contextSource != "" {
+		source, err := buildcontext.ParseSource(app.contextSource)
+		if err != nil {
+			return err
+		}
+		if app.interactiveDebugging && source.Tar == "-" {
+			return errors.New("--context tar://- cannot be used with --interactive, as stdin is used for the context")
+		}
+		contextDir, cleanup, err := source.Prepare(os.Stdin)
+		if err != nil {
+			return errors.Wrapf(err, "prepare context %s", app.contextSource)
+		}
+		defer cleanup()
+		target = buildcontext.WithSourceDir(target, contextDir)
+		artifact.Target = buildcontext.WithSourceDir(artifact.Target, contextDir)
+		for i := range otherTargets {
+			otherTargets[i] = buildcontext.WithSourceDir(otherTargets[i], contextDir)
+		}
+		for i := range outputOnly {
+			outputOnly[i] = buildcontext.WithSourceDir(outputOnly[i], contextDir)
+		}
+	}
+	buildContexts, err := buildcontext.NamedContexts(app.cfg.BuildContexts, app.buildContexts.Value())
+	if err != nil {
+		return err
+	}
+	scalingHook := buildkitd.NewScalingHook(app.cfg.Global.BuildkitScalingHook)
+	demand := buildkitd.Demand{
+		BuildID:   app.sessionID,
+		Host:      app.buildkitdSettings.BuildkitAddress,
+		Target:    target.String(),
+		Platforms: app.platformsStr.Value(),
+	}
+	demand.Event = buildkitd.ScalingEventRequested
+	err = scalingHook.Publish(c.Context, demand)
+	if err != nil {
+		app.console.Warnf("Warning: %s\n", err.Error())
+	}
+	defer func() {
+		demand.Event = buildkitd.ScalingEventReleased
+		err := scalingHook.Publish(context.Background(), demand)
+		if err != nil {
+			app.console.Warnf("Warning: %s\n", err.Error())
+		}
+	}()
+
 	bkClient, err := buildkitd.NewClient(c.Context, app.console, app.buildkitdImage, app.containerName, app.buildkitdSettings)
 	if err != nil {
 		return errors.Wrap(err, "build new buildkitd client")
 	}
 	defer bkClient.Close()
+	compression := app.exportCompression()
+	if app.interactiveDebugging || compression.RequiredCapability() != "" {
+		bkInfo, err := buildkitd.GetInfo(c.Context, bkClient)
+		if err != nil {
+			return errors.Wrap(err, "get buildkitd info")
+		}
+		if app.interactiveDebugging && !bkInfo.Has(buildkitd.CapInteractiveDebugger) {
+			app.console.Warnf("Warning: buildkitd (%s) does not support the interactive debugger; continuing without it\n", bkInfo)
+			app.interactiveDebugging = false
+		}
+		if capability := compression.RequiredCapability(); capability != "" && !bkInfo.Has(capability) {
+			return errors.Errorf("buildkitd at %s (%s) does not support %s compression", app.buildkitdSettings.BuildkitAddress, bkInfo, compression.Type)
+		}
+	}
 	isLocal := buildkitd.IsLocal(app.buildkitdSettings.BuildkitAddress)
 
 	bkIP, err := buildkitd.GetContainerIP(c.Context, app.containerName, app.buildkitdSettings)
@@ -2676,6 +5707,31 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
 
+	var checksumsSigner *checksums.Signer
+	if app.checksumsSign != "" {
+		if app.checksumsPath == "" {
+			return errors.New("--checksums-sign requires --checksums")
+		}
+		signer, err := checksums.ParseSigner(app.checksumsSign)
+		if err != nil {
+			return err
+		}
+		checksumsSigner = &signer
+	}
+
+	var auditLog *audit.Log
+	if app.auditLogPath != "" {
+		key, err := app.readAuditLogKey()
+		if err != nil {
+			return err
+		}
+		auditLog, err = audit.NewLog(app.auditLogPath, key)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+	}
+
 	localhostProvider, err := localhostprovider.NewLocalhostProvider()
 	if err != nil {
 		return errors.Wrap(err, "failed to create localhostprovider")
@@ -2690,31 +5746,98 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 	defaultLocalDirs["earthly-cache"] = cacheLocalDir
 	buildContextProvider := provider.NewBuildContextProvider(app.console)
 	buildContextProvider.AddDirs(defaultLocalDirs)
+	buildContextProvider.SetNormalizeLineEndings(app.cfg.Global.LineEndings == buildcontext.LineEndingsNormalize)
+	buildContextProvider.SetSizeBudget(provider.SizeBudget{
+		Warn:  int64(app.cfg.Global.ContextSizeWarnMb) * 1024 * 1024,
+		Limit: int64(app.contextSizeLimitMb) * 1024 * 1024,
+	})
+	var leakScanner *leakscan.Scanner
+	var onSecret func(name string, data []byte)
+	if app.scanLeaks {
+		leakScanner = leakscan.NewScanner(app.scanLeaksAllow.Value())
+		for name, data := range secretsMap {
+			if name != debuggercommon.DebuggerSettingsSecretsKey {
+				leakScanner.AddSecret(name, data)
+			}
+		}
+		// Shared secrets are only known once they are fetched.
+		onSecret = leakScanner.AddSecret
+	} else if len(app.scanLeaksAllow.Value()) != 0 {
+		return errors.New("--scan-leaks-allow requires --scan-leaks")
+	}
+	gitLookup := buildcontext.NewGitLookup(app.console, app.sshAuthSock)
+	if app.mock == nil {
+		// In mock mode, remote repositories are stubbed, so no git credentials
+		// are needed.
+		err = app.updateGitLookupConfig(c.Context, gitLookup, secretsMap, sc)
+		if err != nil {
+			return err
+		}
+	}
+
+	secretProvider := llbutil.NewSecretProvider(sc, secretsMap, auditLog, onSecret, gitLookup.Secret)
+	if app.mock != nil {
+		secretProvider = llbutil.NewMockSecretProvider(secretsMap, func(name string) []byte {
+			return app.mock.Secret(app.mockRecorder, name)
+		}, auditLog)
+	} else if app.offline {
+		secretProvider = llbutil.NewOfflineSecretProvider(secretsMap, auditLog, onSecret, gitLookup.Secret)
+	}
 	attachables := []session.Attachable{
-		llbutil.NewSecretProvider(sc, secretsMap),
+		secretProvider,
 		authprovider.NewDockerAuthProvider(os.Stderr),
 		buildContextProvider,
 		localhostProvider,
 	}
 
-	gitLookup := buildcontext.NewGitLookup(app.console, app.sshAuthSock)
-	err = app.updateGitLookupConfig(gitLookup)
-	if err != nil {
-		return err
-	}
-
+	var sshAgentConfigs []sshprovider.AgentConfig
 	if app.sshAuthSock != "" {
-		ssh, err := sshprovider.NewSSHAgentProvider([]sshprovider.AgentConfig{{
+		sshAgentConfigs = append(sshAgentConfigs, sshprovider.AgentConfig{
 			Paths: []string{app.sshAuthSock},
-		}})
+		})
+	}
+	for id, keyPath := range gitLookup.SSHKeys() {
+		sshAgentConfigs = append(sshAgentConfigs, sshprovider.AgentConfig{
+			ID:    id,
+			Paths: []string{keyPath},
+		})
+	}
+	if len(sshAgentConfigs) > 0 {
+		ssh, err := sshprovider.NewSSHAgentProvider(sshAgentConfigs)
 		if err != nil {
 			return errors.Wrap(err, "ssh agent provider")
 		}
 		attachables = append(attachables, ssh)
 	}
 
+	cwd, err := os.Getwd()
+	if err != nil {
+		return errors.Wrap(err, "get working directory")
+	}
+	var acceptedCaps []capabilities.Capability
+	for _, a := range app.locallyAccepted.Value() {
+		ac, err := capabilities.Parse(a, cwd)
+		if err != nil {
+			return errors.Wrapf(err, "parse --locally-accept %s", a)
+		}
+		acceptedCaps = append(acceptedCaps, ac)
+	}
+	var promptCapability capabilities.PromptFun
+	if termutil.IsTTY() {
+		promptCapability = func(target string, c capabilities.Capability) (bool, error) {
+			answer := promptInput(fmt.Sprintf("LOCALLY in %s declares that it uses %s on the host (this is not enforced). Continue? [y/N]: ", target, c))
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			return answer == "y" || answer == "yes", nil
+		}
+	}
+	locallyGrants := capabilities.NewGrants(acceptedCaps, app.locallyEnforce, promptCapability)
+	privilegedApprover, err := app.newPrivilegedApprover()
+	if err != nil {
+		return err
+	}
+
 	var enttlmnts []entitlements.Entitlement
-	if app.allowPrivileged {
+	if app.allowPrivileged || privilegedApprover != nil {
 		enttlmnts = append(enttlmnts, entitlements.EntitlementSecurityInsecure)
 	}
 	cleanCollection := cleanup.NewCollection()
@@ -2747,6 +5870,19 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		return errors.Wrap(err, "parse build args")
 	}
 	overridingVars = variables.CombineScopes(overridingVars, dotEnvVars)
+	var otherTargetArgs []builder.TargetArgs
+	for i, otherTarget := range otherTargets {
+		otherBuildArgs := append([]string{}, app.buildArgs.Value()...)
+		otherBuildArgs = append(otherBuildArgs, otherTargetFlagArgs[i]...)
+		otherVars, err := variables.ParseCommandLineArgs(otherBuildArgs)
+		if err != nil {
+			return errors.Wrapf(err, "parse build args of %s", otherTarget.String())
+		}
+		otherTargetArgs = append(otherTargetArgs, builder.TargetArgs{
+			Target:         otherTarget,
+			OverridingVars: variables.CombineScopes(otherVars, dotEnvVars),
+		})
+	}
 	imageResolveMode := llb.ResolveModePreferLocal
 	if app.pull {
 		imageResolveMode = llb.ResolveModeForcePull
@@ -2765,6 +5901,42 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 			cacheExport = app.remoteCache
 		}
 	}
+	imageIndex, err := app.loadImageIndex()
+	if err != nil {
+		return err
+	}
+	var cachedImages imageindex.CachedImages
+	if app.offline {
+		cachedImages, err = imageindex.LoadCachedImages(c.Context, bkClient)
+		if err != nil {
+			return err
+		}
+	}
+	imageBudgets, err := app.loadImageBudgets()
+	if err != nil {
+		return err
+	}
+	workspace, err := app.loadWorkspace(c.Context)
+	if err != nil {
+		return err
+	}
+	var artifactStore *artifactstore.Store
+	if app.artifactStore {
+		artifactStore, err = app.openArtifactStore()
+		if err != nil {
+			return err
+		}
+	}
+	defer func() {
+		err := imageIndex.Save()
+		if err != nil {
+			app.console.Warnf("Warning: %s\n", err.Error())
+		}
+		err = imageBudgets.Save()
+		if err != nil {
+			app.console.Warnf("Warning: %s\n", err.Error())
+		}
+	}()
 	var parallelism *semaphore.Weighted
 	if app.conversionParllelism != 0 {
 		parallelism = semaphore.NewWeighted(int64(app.conversionParllelism))
@@ -2789,6 +5961,7 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		MaxCacheExport:         maxCacheExport,
 		UseInlineCache:         app.useInlineCache,
 		SaveInlineCache:        app.saveInlineCache,
+		Compression:            compression,
 		SessionID:              app.sessionID,
 		ImageResolveMode:       imageResolveMode,
 		CleanCollection:        cleanCollection,
@@ -2802,6 +5975,42 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		Parallelism:            parallelism,
 		LocalRegistryAddr:      localRegistryAddr,
 		FeatureFlagOverrides:   app.featureFlagOverrides,
+		AuditLog:               auditLog,
+		OnSideEffect:           func() { atomic.StoreInt32(&app.sideEffects, 1) },
+		LocallyGrants:          locallyGrants,
+		PrivilegedApprover:     privilegedApprover,
+		ImageIndex:             imageIndex,
+		CachedImages:           cachedImages,
+		ImageBudgets:           imageBudgets,
+		Offline:                app.offline,
+		ArtifactStore:          artifactStore,
+		Workspace:              workspace,
+		CacheNamespace:         app.selftestCacheNamespace,
+		Mock:                   app.mock != nil,
+		SourceDateEpoch:        app.sourceDateEpoch,
+		Hostname:               app.hostname,
+		DNS:                    app.dnsConfig(),
+		DefaultUser:            app.defaultUser,
+		RandomSeed:             app.randomSeed,
+		ArgOverrides:           argOverrides,
+		LineEndings:            app.cfg.Global.LineEndings,
+		BuildContexts:          buildContexts,
+		HasSecret:              app.hasSecretFun(secretsMap, sc),
+		GetSecret:              app.getSecretFun(secretsMap, sc),
+		Runners:                app.runners(),
+		PushPolicy: builder.PushPolicy{
+			ProtectedTags: app.cfg.Global.PushProtectedTags,
+			RollbackHook:  app.cfg.Global.PushRollbackHook,
+		},
+		PushFanOut:  app.cfg.Global.PushFanOut,
+		LeakScanner: leakScanner,
+		RegistryRetry: retryutil.Policy{
+			Retries: app.cfg.Global.RegistryRetries,
+			Delay:   time.Duration(app.cfg.Global.RegistryRetryDelayS) * time.Second,
+			OnRetry: func(attempt int, err error, delay time.Duration) {
+				app.console.Warnf("Registry request failed (attempt %d): %s; retrying in %s\n", attempt, err.Error(), delay)
+			},
+		},
 	}
 	b, err := builder.NewBuilder(c.Context, builderOpts)
 	if err != nil {
@@ -2815,7 +6024,10 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		PrintSuccess:               true,
 		Push:                       app.push,
 		NoOutput:                   app.noOutput,
+		NoLoad:                     app.noLoad,
+		OutputOnly:                 outputOnly,
 		OnlyFinalTargetImages:      app.imageMode,
+		FinalImageTag:              app.devImageTag,
 		Platform:                   platformsSlice[0],
 		EnableGatewayClientLogging: app.debug,
 
@@ -2827,13 +6039,438 @@ func (app *earthlyApp) actionBuildImp(c *cli.Context, flagArgs, nonFlagArgs []st
 		buildOpts.OnlyArtifact = &artifact
 		buildOpts.OnlyArtifactDestPath = destPath
 	}
-	_, err = b.BuildTarget(c.Context, target, buildOpts)
+	if prefetchTargets != nil {
+		fetched, err := b.Prefetch(c.Context, prefetchTargets, buildOpts)
+		if err != nil {
+			return errors.Wrap(err, "prefetch")
+		}
+		for _, name := range fetched {
+			app.console.Printf("Prefetched %s\n", name)
+		}
+		return nil
+	}
+	if app.multiTargets != nil {
+		return app.runMulti(c.Context, b, buildOpts)
+	}
+	if app.selftestCases != nil {
+		return app.runSelftest(c.Context, b, buildOpts)
+	}
+	defer app.reportTests(b)
+	defer printAnnotations(b)
+	var mts *states.MultiTarget
+	if len(otherTargetArgs) != 0 {
+		targetArgs := append([]builder.TargetArgs{{Target: target, OverridingVars: overridingVars}}, otherTargetArgs...)
+		mtss, err := b.BuildTargets(c.Context, targetArgs, buildOpts)
+		if err != nil {
+			return errors.Wrap(err, "build targets")
+		}
+		printTargetsSummary(mtss)
+		mts = mtss[0]
+	} else {
+		mts, err = b.BuildTarget(c.Context, target, buildOpts)
+		if err != nil {
+			return errors.Wrap(err, "build target")
+		}
+	}
+	printPrivilegedApprovals(privilegedApprover)
+	if app.checksumsPath != "" {
+		err = app.writeChecksums(c.Context, b, checksumsSigner, app.getSecretFun(secretsMap, sc))
+		if err != nil {
+			return err
+		}
+	}
+	if app.mock != nil {
+		return app.recordMock(mts)
+	}
+	if app.diffSnapshot != nil {
+		return app.snapshotDiff(c.Context, mts)
+	}
+	if app.push && app.gitOpsRepo != "" {
+		err = app.updateGitOps(c.Context, b, gitLookup, target, mts)
+		if err != nil {
+			return errors.Wrap(err, "gitops")
+		}
+	}
+	return nil
+}
+
+// writeChecksums writes the --checksums file, covering the artifacts which
+// the build saved locally, and signs it if requested.
+func (app *earthlyApp) writeChecksums(ctx context.Context, b *builder.Builder, signer *checksums.Signer, getSecret func(name string) ([]byte, error)) error {
+	err := checksums.Write(app.checksumsPath, b.SavedLocal())
+	if err != nil {
+		return errors.Wrap(err, "checksums")
+	}
+	app.console.Printf("Wrote the checksums of the local artifacts to %s\n", app.checksumsPath)
+	if signer == nil {
+		return nil
+	}
+	sig, err := signer.Sign(ctx, app.checksumsPath, getSecret, checksums.ExecRun)
+	if err != nil {
+		return errors.Wrap(err, "sign checksums")
+	}
+	app.console.Printf("Signed %s via %s as %s\n", app.checksumsPath, signer.Tool, sig)
+	return nil
+}
+
+// recordMock records the pushes which the build would have performed, and
+// reports them along with the stubbed secrets.
+func (app *earthlyApp) recordMock(mts *states.MultiTarget) error {
+	for _, sts := range mts.All() {
+		if sts.Target.IsRemote() {
+			continue
+		}
+		target := sts.Target.StringCanonical()
+		for _, saveImage := range append(sts.SaveImages, sts.RunPush.SaveImages...) {
+			if saveImage.Push && saveImage.DoSave && saveImage.DockerTag != "" {
+				app.mockRecorder.PushImage(target, saveImage.DockerTag)
+			}
+		}
+		for _, commandStr := range sts.RunPush.CommandStrs {
+			app.mockRecorder.RunPush(target, commandStr)
+		}
+	}
+	rec := app.mockRecorder.Record()
+	rec.Print(os.Stderr)
+	if app.mockRecordPath == "" {
+		return nil
+	}
+	f, err := os.Create(app.mockRecordPath)
+	if err != nil {
+		return errors.Wrapf(err, "create mock record %s", app.mockRecordPath)
+	}
+	defer f.Close()
+	err = rec.WriteJSON(f)
+	if err != nil {
+		return errors.Wrapf(err, "write mock record %s", app.mockRecordPath)
+	}
+	return f.Close()
+}
+
+// snapshotDiff records the definitions of the local targets of the build, and
+// the images and artifacts which they saved, for earthly diff.
+func (app *earthlyApp) snapshotDiff(ctx context.Context, mts *states.MultiTarget) error {
+	earthfiles := make(map[string]spec.Earthfile)
+	for _, sts := range mts.All() {
+		if sts.Target.IsRemote() {
+			continue
+		}
+		dir, err := filepath.Abs(sts.Target.LocalPath)
+		if err != nil {
+			return errors.Wrapf(err, "abs path of %s", sts.Target.LocalPath)
+		}
+		ef, ok := earthfiles[dir]
+		if !ok {
+			ef, err = ast.Parse(ctx, filepath.Join(dir, "Earthfile"), false)
+			if err != nil {
+				return err
+			}
+			earthfiles[dir] = ef
+		}
+		recipe := ef.BaseRecipe
+		for _, t := range ef.Targets {
+			if t.Name == sts.Target.Target {
+				recipe = t.Recipe
+			}
+		}
+		buildArgs := make(map[string]string)
+		for _, ba := range sts.TargetInput().BuildArgs {
+			if !dedup.BuiltinVariables[ba.Name] {
+				buildArgs[ba.Name] = ba.ConstantValue
+			}
+		}
+		rel, err := filepath.Rel(app.diffRoot, dir)
+		if err != nil {
+			return errors.Wrapf(err, "relative path of %s", dir)
+		}
+		name := "+" + sts.Target.Target
+		if rel != "." {
+			name = "./" + filepath.ToSlash(rel) + name
+		}
+		app.diffSnapshot.AddStep(name, buildArgs, recipe)
+
+		for _, saveImage := range sts.SaveImages {
+			if !saveImage.DoSave || saveImage.DockerTag == "" || app.noOutput || app.noLoad {
+				continue
+			}
+			img, err := builddiff.InspectImage(ctx, saveImage.DockerTag)
+			if err != nil {
+				app.console.Warnf("Not comparing image %s: %v\n", saveImage.DockerTag, err)
+				continue
+			}
+			app.diffSnapshot.Images[saveImage.DockerTag] = img
+		}
+		for _, saveLocal := range sts.SaveLocals {
+			path := filepath.FromSlash(saveLocal.DestPath)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			if strings.HasSuffix(saveLocal.DestPath, "/") {
+				path = filepath.Join(path, filepath.Base(saveLocal.ArtifactPath))
+			}
+			err = app.diffSnapshot.AddArtifacts(app.diffRoot, path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runMulti runs the targets of earthly multi run and prints the result of
+// each of them.
+func (app *earthlyApp) runMulti(ctx context.Context, b *builder.Builder, buildOpts builder.BuildOpt) error {
+	targetResults, err := b.RunTargets(ctx, app.multiTargets, buildOpts)
+	if err != nil {
+		return errors.Wrap(err, "multi run")
+	}
+	var failed []string
+	i := 0
+	for j := range app.multiResults {
+		r := &app.multiResults[j]
+		if r.Skipped {
+			continue
+		}
+		r.Err, r.Duration = targetResults[i].Err, targetResults[i].Duration
+		i++
+		if r.Err != nil {
+			app.console.Warnf("%s%s failed: %s\n", r.Service.Dir, r.Target, r.Err.Error())
+			failed = append(failed, r.Service.Dir)
+		}
+	}
+	err = monorepo.PrintMatrix(os.Stdout, app.multiResults)
+	if err != nil {
+		return err
+	}
+	if len(failed) != 0 {
+		return errors.Errorf("%s failed for %s", app.multiResults[0].Target, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// runSelftest runs the targets of earthly selftest and reports the result of
+// each of them.
+func (app *earthlyApp) runSelftest(ctx context.Context, b *builder.Builder, buildOpts builder.BuildOpt) error {
+	targets := make([]domain.Target, 0, len(app.selftestCases))
+	for _, tc := range app.selftestCases {
+		targets = append(targets, tc.Target)
+	}
+	targetResults, err := b.RunTargets(ctx, targets, buildOpts)
+	if err != nil {
+		return errors.Wrap(err, "selftest")
+	}
+	results := make([]selftest.Result, 0, len(targetResults))
+	failed := 0
+	for i, tr := range targetResults {
+		r := selftest.Result{Case: app.selftestCases[i], Err: tr.Err, Duration: tr.Duration}
+		if !r.Passed() {
+			failed++
+		}
+		results = append(results, r)
+	}
+	err = selftest.PrintReport(os.Stdout, results)
 	if err != nil {
-		return errors.Wrap(err, "build target")
+		return err
+	}
+	if failed != 0 {
+		return errors.Errorf("%d of %d self-tests failed", failed, len(results))
 	}
 	return nil
 }
 
+// extractArgOverrides separates the overrides of the ARGs of specific targets,
+// passed as --set <target-ref>.<arg-name>=<value>, from the build args.
+func extractArgOverrides(flagArgs []string) ([]string, []earthfile2llb.ArgOverride, error) {
+	var buildArgs []string
+	var overrides []earthfile2llb.ArgOverride
+	for _, flagArg := range flagArgs {
+		value := strings.TrimPrefix(flagArg, "set=")
+		if value == flagArg || !strings.Contains(strings.SplitN(value, "=", 2)[0], "+") {
+			// Not an override, but possibly a build arg named set.
+			buildArgs = append(buildArgs, flagArg)
+			continue
+		}
+		ao, err := earthfile2llb.ParseArgOverride(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		overrides = append(overrides, ao)
+	}
+	return buildArgs, overrides, nil
+}
+
+// newPrivilegedApprover returns the approver of the privileged commands and
+// host mounts of the build, or nil if they are not subject to approval.
+func (app *earthlyApp) newPrivilegedApprover() (*privileged.Approver, error) {
+	if !app.approvePrivileged && app.privilegedAllowlist == "" {
+		return nil, nil
+	}
+	var allowlist *privileged.Allowlist
+	if app.privilegedAllowlist != "" {
+		if app.cfg.Global.PrivilegedAllowlistKey == "" {
+			return nil, errors.New("a privileged allowlist requires privileged_allowlist_key to be configured, to verify its signature")
+		}
+		publicKey, err := privileged.ParsePublicKey(app.cfg.Global.PrivilegedAllowlistKey)
+		if err != nil {
+			return nil, err
+		}
+		allowlist, err = privileged.LoadAllowlist(app.privilegedAllowlist, publicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var prompt privileged.PromptFun
+	if termutil.IsTTY() {
+		prompt = func(r privileged.Request) (bool, error) {
+			answer := promptInput(fmt.Sprintf("%s in %s requires %s. Allow? [y/N]: ", r.Command, r.Target, r.Reason))
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			return answer == "y" || answer == "yes", nil
+		}
+	}
+	return privileged.NewApprover(allowlist, prompt), nil
+}
+
+// printPrivilegedApprovals lists the privileged commands and host mounts
+// approved during the build.
+func printPrivilegedApprovals(approver *privileged.Approver) {
+	approved := approver.Approved()
+	if len(approved) == 0 {
+		return
+	}
+	fmt.Printf("Approved privileged commands:\n")
+	for _, r := range approved {
+		fmt.Printf("  %s\n", r)
+	}
+}
+
+// reportTests lists the flaky, failed and quarantined tests of the RUN --junit
+// commands of the build, whether it succeeded or not, and writes them to the
+// test report if requested.
+func (app *earthlyApp) reportTests(b *builder.Builder) {
+	reports := b.TestReports()
+	printTests := func(title string, tests func(builder.TestReport) []string) {
+		printedTitle := false
+		for _, r := range reports {
+			if len(tests(r)) == 0 {
+				continue
+			}
+			if !printedTitle {
+				fmt.Printf("%s:\n", title)
+				printedTitle = true
+			}
+			fmt.Printf("  %s: %s\n", r.Target, strings.Join(tests(r), " "))
+		}
+	}
+	printTests("Flaky tests, which passed when rerun", func(r builder.TestReport) []string { return r.Flaky })
+	printTests("Quarantined tests, which failed every attempt", func(r builder.TestReport) []string { return r.Quarantined })
+	printTests("Tests which failed every attempt", func(r builder.TestReport) []string { return r.Failed })
+	if app.testReportPath == "" {
+		return
+	}
+	if reports == nil {
+		reports = []builder.TestReport{}
+	}
+	dt, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		app.console.Warnf("Failed to marshal the test report: %v\n", err)
+		return
+	}
+	err = ioutil.WriteFile(app.testReportPath, append(dt, '\n'), 0644)
+	if err != nil {
+		app.console.Warnf("Failed to write the test report to %s: %v\n", app.testReportPath, err)
+	}
+}
+
+// printAnnotations lists the annotations which the commands of the build
+// reported, such as the drift detected by std/terraform, whether the build
+// succeeded or not.
+func printAnnotations(b *builder.Builder) {
+	annotations := b.Annotations()
+	if len(annotations) == 0 {
+		return
+	}
+	fmt.Printf("Annotations:\n")
+	for _, a := range annotations {
+		fmt.Printf("  %s: %s\n", a.Target, a.Text)
+	}
+}
+
+// printTargetsSummary prints the images and artifacts output by each of the
+// targets built together.
+func printTargetsSummary(mtss []*states.MultiTarget) {
+	join := func(items []string) string {
+		if len(items) == 0 {
+			return "-"
+		}
+		return strings.Join(items, ", ")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TARGET\tIMAGES\tARTIFACTS\n")
+	for _, mts := range mtss {
+		var images, artifacts []string
+		for _, saveImage := range mts.Final.SaveImages {
+			if saveImage.DockerTag != "" && saveImage.DoSave {
+				images = append(images, saveImage.DockerTag)
+			}
+		}
+		for _, saveLocal := range mts.Final.SaveLocals {
+			artifacts = append(artifacts, saveLocal.DestPath)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", mts.Final.Target.StringCanonical(), join(images), join(artifacts))
+	}
+	w.Flush()
+}
+
+// updateGitOps updates the digests of the images pushed by the build in the
+// files of the GitOps repository.
+func (app *earthlyApp) updateGitOps(ctx context.Context, b *builder.Builder, gitLookup *buildcontext.GitLookup, target domain.Target, mts *states.MultiTarget) error {
+	if len(app.gitOpsFiles.Value()) == 0 {
+		return errors.New("--gitops-repo requires at least one --gitops-file")
+	}
+	var tags []string
+	seen := make(map[string]bool)
+	for _, sts := range mts.All() {
+		if sts.Target.IsRemote() {
+			continue
+		}
+		for _, saveImage := range sts.SaveImages {
+			if saveImage.Push && saveImage.DoSave && saveImage.DockerTag != "" && !seen[saveImage.DockerTag] {
+				seen[saveImage.DockerTag] = true
+				tags = append(tags, saveImage.DockerTag)
+			}
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	digests, err := b.ResolveImageDigests(ctx, tags)
+	if err != nil {
+		return err
+	}
+	cloneURL, _, auth, err := gitLookup.GetCloneURL(app.gitOpsRepo)
+	if err != nil {
+		return errors.Wrapf(err, "lookup %s", app.gitOpsRepo)
+	}
+	opt := gitops.Opt{
+		CloneURL:    cloneURL,
+		Branch:      app.gitOpsBranch,
+		Files:       app.gitOpsFiles.Value(),
+		PullRequest: app.gitOpsPR,
+		Token:       os.Getenv("GITHUB_TOKEN"),
+		Source:      target.StringCanonical(),
+	}
+	if u, err := url.Parse(cloneURL); err == nil && u.User != nil {
+		// Prefer the token configured for the host, if any.
+		if password, ok := u.User.Password(); ok {
+			opt.Token = password
+		}
+	}
+	if keyPath, ok := gitLookup.SSHKeys()[auth.SSHSockID]; ok {
+		opt.Env = append(opt.Env, "GIT_SSH_COMMAND=ssh -i "+shellescape.Quote(keyPath)+" -o IdentitiesOnly=yes")
+	}
+	return gitops.Update(ctx, app.console, opt, digests)
+}
+
 func (app *earthlyApp) hasSSHKeys() bool {
 	if app.sshAuthSock == "" {
 		return false
@@ -2853,7 +6490,7 @@ func (app *earthlyApp) hasSSHKeys() bool {
 	return len(keys) > 0
 }
 
-func (app *earthlyApp) updateGitLookupConfig(gitLookup *buildcontext.GitLookup) error {
+func (app *earthlyApp) updateGitLookupConfig(ctx context.Context, gitLookup *buildcontext.GitLookup, secretsMap map[string][]byte, sc secretsclient.Client) error {
 	for k, v := range app.cfg.Git {
 		if k == "github" || k == "gitlab" || k == "bitbucket" {
 			app.console.Warnf("git configuration for %q found, did you mean %q?\n", k, k+".com")
@@ -2876,10 +6513,161 @@ func (app *earthlyApp) updateGitLookupConfig(gitLookup *buildcontext.GitLookup)
 		if err != nil {
 			return errors.Wrap(err, "gitlookup")
 		}
+		token, err := app.gitToken(ctx, k, v, secretsMap, sc)
+		if err != nil {
+			return err
+		}
+		sshKey := v.SSHKey
+		if sshKey != "" {
+			sshKey = fileutil.ExpandPath(sshKey)
+		}
+		if token != "" || sshKey != "" {
+			err = gitLookup.SetCredentials(k, token, sshKey)
+			if err != nil {
+				return errors.Wrap(err, "gitlookup")
+			}
+		}
 	}
 	return nil
 }
 
+// gitToken returns the access token configured for the given git host, if
+// any. The token is either set in the config directly, read from an earthly
+// secret, or minted on the fly for a GitHub App installation.
+func (app *earthlyApp) gitToken(ctx context.Context, host string, gc config.GitConfig, secretsMap map[string][]byte, sc secretsclient.Client) (string, error) {
+	var sources []string
+	if gc.Token != "" {
+		sources = append(sources, "token")
+	}
+	if gc.TokenSecret != "" {
+		sources = append(sources, "token_secret")
+	}
+	if gc.GitHubAppID != 0 {
+		sources = append(sources, "github_app_id")
+	}
+	if len(sources) > 1 {
+		return "", errors.Errorf("git configuration for %s sets more than one of %s", host, strings.Join(sources, ", "))
+	}
+	switch {
+	case gc.Token != "":
+		return gc.Token, nil
+	case gc.TokenSecret != "":
+		secretName := strings.TrimPrefix(gc.TokenSecret, "+secrets/")
+		if !strings.Contains(secretName, "/") {
+			v, ok := secretsMap[secretName]
+			if !ok {
+				return "", errors.Errorf("secret %s, configured as the git token for %s, was not provided via --secret", secretName, host)
+			}
+			return strings.TrimSpace(string(v)), nil
+		}
+		if app.offline {
+			return "", errors.Errorf("the git token for %s cannot be fetched from the secrets server in --offline mode", host)
+		}
+		v, err := sc.Get(secretName)
+		if err != nil {
+			return "", errors.Wrapf(err, "lookup git token for %s", host)
+		}
+		return strings.TrimSpace(string(v)), nil
+	case gc.GitHubAppID != 0:
+		if gc.GitHubAppInstallationID == 0 || gc.GitHubAppKey == "" {
+			return "", errors.Errorf("git configuration for %s requires github_app_installation_id and github_app_key alongside github_app_id", host)
+		}
+		if app.offline {
+			return "", errors.Errorf("a GitHub App token for %s cannot be minted in --offline mode", host)
+		}
+		keyPEM, err := ioutil.ReadFile(fileutil.ExpandPath(gc.GitHubAppKey))
+		if err != nil {
+			return "", errors.Wrapf(err, "read github app key for %s", host)
+		}
+		ghApp, err := githubapp.New(gc.GitHubAppID, gc.GitHubAppInstallationID, keyPEM)
+		if err != nil {
+			return "", errors.Wrapf(err, "github app for %s", host)
+		}
+		if host != "github.com" {
+			// GitHub Enterprise Server serves its API under /api/v3.
+			ghApp.APIURL = "https://" + host + "/api/v3"
+		}
+		token, err := ghApp.InstallationToken(ctx)
+		if err != nil {
+			return "", errors.Wrapf(err, "mint github app token for %s", host)
+		}
+		return token, nil
+	default:
+		return "", nil
+	}
+}
+
+// runners returns the runners of LOCALLY --runner, as configured.
+func (app *earthlyApp) runners() map[string]earthfile2llb.Runner {
+	runners := make(map[string]earthfile2llb.Runner, len(app.cfg.Runners))
+	for name, rc := range app.cfg.Runners {
+		r := earthfile2llb.Runner{
+			Host:    rc.Host,
+			Port:    rc.Port,
+			Workdir: rc.Workdir,
+		}
+		if rc.IdentityFile != "" {
+			r.IdentityFile = fileutil.ExpandPath(rc.IdentityFile)
+		}
+		runners[name] = r
+	}
+	return runners
+}
+
+// hasSecretFun returns a function which returns whether a secret is available
+// to the build: passed via --secret, --secret-file or .env, or, for shared
+// secrets (org/name), stored in the secrets server. In mock mode, all secrets
+// are available.
+func (app *earthlyApp) hasSecretFun(secretsMap map[string][]byte, sc secretsclient.Client) func(name string) bool {
+	return func(name string) bool {
+		if _, ok := secretsMap[name]; ok {
+			return true
+		}
+		switch {
+		case app.mock != nil:
+			return true
+		case !strings.Contains(name, "/"):
+			return false
+		}
+		if _, ok := secretsMap["/"+name]; ok {
+			return true
+		}
+		if app.offline {
+			return false
+		}
+		_, err := sc.Get("/" + name)
+		return err == nil
+	}
+}
+
+// getSecretFun returns a function which returns the value of a secret of the
+// build, looked up like hasSecretFun does. In mock mode, all secrets are
+// empty.
+func (app *earthlyApp) getSecretFun(secretsMap map[string][]byte, sc secretsclient.Client) func(name string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		if v, ok := secretsMap[name]; ok {
+			return v, nil
+		}
+		switch {
+		case app.mock != nil:
+			return []byte{}, nil
+		case !strings.Contains(name, "/"):
+			return nil, errors.Errorf("secret %s not found; pass it via --secret or --secret-file", name)
+		}
+		if v, ok := secretsMap["/"+name]; ok {
+			return v, nil
+		}
+		if app.offline {
+			return nil, errors.Errorf("secret %s not found; shared secrets are not available in --offline mode", name)
+		}
+		v, err := sc.Get("/" + name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get secret %s", name)
+		}
+		return v, nil
+	}
+}
+
 func processSecrets(secrets, secretFiles []string, dotEnvMap map[string]string) (map[string][]byte, error) {
 	finalSecrets := make(map[string][]byte)
 	for k, v := range dotEnvMap {
@@ -66,6 +66,33 @@ type GlobalConfig struct {
 	ServerTLSCert            string   `yaml:"buildkitd_tlscert"          help:"The path to the server cert for verification. Relative paths are interpreted as relative to ~/.earthly. Only used when Earthly manages buildkit."`
 	ServerTLSKey             string   `yaml:"buildkitd_tlskey"           help:"The path to the server key for verification. Relative paths are interpreted as relative to ~/.earthly. Only used when Earthly manages buildkit."`
 	TLSEnabled               bool     `yaml:"tls_enabled"                help:"If TLS should be used to communicate with Buildkit. Only honored when BuildkitScheme is 'tcp'."`
+	AuditLog                 string   `yaml:"audit_log"                  help:"If set, a record of every push, local file write, LOCALLY command and secret access is appended to this file."`
+	AuditLogKey              string   `yaml:"audit_log_key"              help:"The path to a file containing a key used to sign audit log entries."`
+	DefaultUser              string   `yaml:"default_user"               help:"The non-root user, as in 1000:1000, targets switch to after they start FROM an image which runs as root."`
+	PrivilegedAllowlist      string   `yaml:"privileged_allowlist"       help:"The path to a signed allowlist of the targets and commands approved to run privileged or mount host paths."`
+	PrivilegedAllowlistKey   string   `yaml:"privileged_allowlist_key"   help:"The base64 encoded ed25519 public key the privileged allowlist is signed with."`
+	BuildkitScalingHook      string   `yaml:"buildkit_scaling_hook"      help:"A command which is notified (via stdin, as JSON) when a build requests or releases a remote buildkit worker."`
+	BuildkitFailoverHosts    []string `yaml:"buildkit_failover_hosts"    help:"Remote buildkit hosts to retry the build on, in order, if the connection to the current one is lost before anything was pushed or run LOCALLY."`
+	RegistryRetries          int      `yaml:"registry_retries"           help:"How many times to retry transient registry failures when resolving image metadata and pushing images. Pulls are not retried."`
+	RegistryRetryDelayS      int      `yaml:"registry_retry_delay_s"     help:"How long to wait before the first registry retry, in seconds. The delay doubles with each retry."`
+	ContextSizeWarnMb        int      `yaml:"context_size_warn_mb"       help:"Print a warning, along with the largest contributors, when a local build context exceeds this size, in Megabytes. 0 disables the warning."`
+	ContextSizeLimitMb       int      `yaml:"context_size_limit_mb"      help:"Fail the build when a local build context exceeds this size, in Megabytes. 0 disables the limit."`
+	ArtifactStore            bool     `yaml:"artifact_store"             help:"Keep a copy of every artifact saved locally in the content-addressed artifact store in ~/.earthly/artifacts."`
+	PushProtectedTags        []string `yaml:"push_protected_tags"        help:"Patterns of image tags (e.g. *:latest) which earthly refuses to push. The tags are verified before anything is pushed."`
+	PushFanOut               bool     `yaml:"push_fan_out"               help:"Push the image of SAVE IMAGE --push only once per registry, and copy its other names in that registry from it, via the earthly client rather than buildkitd. Not used for --insecure pushes."`
+	PushRollbackHook         string   `yaml:"push_rollback_hook"         help:"A command which is executed (with the images and commands involved passed via stdin, as JSON) when the push phase fails part way."`
+	RegistryGCRepositories   []string `yaml:"registry_gc_repositories"   help:"The repositories (e.g. registry.example.com/org/app) cleaned up by earthly registry gc when none are given."`
+	TelemetryEnabled         bool     `yaml:"telemetry_enabled"          help:"Opt in to recording anonymous usage metrics (counts of commands, features used and error categories), which are sent daily to telemetry_endpoint."`
+	TelemetryEndpoint        string   `yaml:"telemetry_endpoint"         help:"The URL the usage metrics are sent to, such as a self-hosted collector. Defaults to that of the Earthly API server."`
+	PreviewKubeContext       string   `yaml:"preview_kube_context"       help:"The kubectl context of the cluster which earthly preview deploys the preview environments of pull requests to. Defaults to the current context."`
+	PreviewNamespacePrefix   string   `yaml:"preview_namespace_prefix"   help:"The prefix of the namespaces of the preview environments, which are named <prefix>pr-<n>."`
+	BuildkitGPUs             string   `yaml:"buildkit_gpus"              help:"The GPUs made available to buildkitd for RUN --gpus, as per docker run --gpus (e.g. all). Requires the NVIDIA Container Toolkit."`
+	VM                       bool     `yaml:"vm"                         help:"Run docker, and in turn buildkitd, in a Linux VM managed by earthly (via lima), instead of Docker Desktop. macOS only."`
+	VMType                   string   `yaml:"vm_type"                    help:"The type of the VM: vz (Virtualization.framework, macOS 13 and later) or qemu. Defaults to vz."`
+	VMCPUs                   int      `yaml:"vm_cpus"                    help:"The number of CPUs of the VM. Defaults to half of those of the host."`
+	VMMemoryGb               int      `yaml:"vm_memory_gb"               help:"The memory of the VM, in Gigabytes. Defaults to 8."`
+	VMDiskGb                 int      `yaml:"vm_disk_gb"                 help:"The size of the disk of the VM, which holds the cache of buildkitd, in Gigabytes. Defaults to 100."`
+	LineEndings              string   `yaml:"line_endings"               help:"How local build contexts checked out with CRLF line endings (git core.autocrlf on Windows, including from WSL2) are handled: warn (the default), error, ignore or normalize, which converts the CRLF line endings of text files to LF when they are sent to buildkitd."`
 
 	// Obsolete.
 	CachePath    string `yaml:"cache_path"    help:" *Deprecated* The path to keep Earthly's cache."`
@@ -85,12 +112,49 @@ type GitConfig struct {
 	User       string `yaml:"user"       help:"The https username to use when auth is set to https. This setting is ignored when auth is ssh."`
 	Password   string `yaml:"password"   help:"The https password to use when auth is set to https. This setting is ignored when auth is ssh."`
 	KeyScan    string `yaml:"serverkey"  help:"SSH fingerprints, like you would add in your known hosts file, or get from ssh-keyscan."`
+
+	// these are used for per-host credentials
+	Token                   string `yaml:"token"                      help:"An access token to use as the https password. Implies https when auth is not set."`
+	TokenSecret             string `yaml:"token_secret"               help:"The ID of an earthly secret containing the access token, instead of storing it in the config."`
+	SSHKey                  string `yaml:"ssh_key"                    help:"The path to an SSH private key used for this host instead of the SSH agent. Implies ssh when auth is not set."`
+	GitHubAppID             int64  `yaml:"github_app_id"              help:"The ID of a GitHub App used to mint installation tokens for this host."`
+	GitHubAppInstallationID int64  `yaml:"github_app_installation_id" help:"The ID of the installation of the GitHub App in the organization."`
+	GitHubAppKey            string `yaml:"github_app_key"             help:"The path to the PEM encoded private key of the GitHub App."`
 }
 
 // Config contains user's configuration values from ~/earthly/config.yml
 type Config struct {
 	Global GlobalConfig         `yaml:"global" help:"Global configuration object. Requires YAML literal to set directly."`
 	Git    map[string]GitConfig `yaml:"git"    help:"Git configuration object. Requires YAML literal to set directly."`
+
+	RemoteSources map[string]RemoteSourceConfig `yaml:"remote_sources" help:"Repositories resolved from HTTPS tarballs or OCI artifacts instead of git. Requires YAML literal to set directly."`
+
+	BuildContexts map[string]string `yaml:"build_contexts" help:"Additional named build contexts, as a map of names to directories, which COPY --from-context copies from. Requires YAML literal to set directly."`
+
+	Catalog CatalogConfig `yaml:"catalog" help:"Catalog configuration object. Requires YAML literal to set directly."`
+
+	Runners map[string]RunnerConfig `yaml:"runners" help:"Remote hosts which LOCALLY --runner targets execute on over SSH, by name. Requires YAML literal to set directly."`
+}
+
+// RunnerConfig contains the configuration of a remote runner of LOCALLY
+// targets.
+type RunnerConfig struct {
+	Host         string `yaml:"host"          help:"The SSH destination of the runner, as in user@host."`
+	Port         int    `yaml:"port"          help:"The SSH port of the runner, if not 22."`
+	IdentityFile string `yaml:"identity_file" help:"The private key used for connecting to the runner, if not the default one."`
+	Workdir      string `yaml:"workdir"       help:"The directory of the runner under which targets execute, relative to the home directory of the SSH user (earthly-runner by default)."`
+}
+
+// CatalogConfig contains the configuration of earthly catalog.
+type CatalogConfig struct {
+	Repos []string `yaml:"repos" help:"The repositories indexed by earthly catalog update, as in github.com/org/repo."`
+}
+
+// RemoteSourceConfig contains the source of a repository which is fetched
+// from an HTTPS tarball or an OCI artifact, instead of via git.
+type RemoteSourceConfig struct {
+	URL    string `yaml:"url"    help:"The https:// URL of a tarball, or the oci:// reference of an artifact, containing the Earthfiles of the repository."`
+	SHA256 string `yaml:"sha256" help:"The sha256 checksum of the tarball, or the digest of the OCI artifact manifest."`
 }
 
 // ParseConfigFile parse config data
@@ -103,6 +167,10 @@ func ParseConfigFile(yamlData []byte) (*Config, error) {
 			// LocalRegistryHost:       fmt.Sprintf("tcp://127.0.0.1:%d", DefaultLocalRegistryPort), // TODO: Uncomment when feature is ready.
 			BuildkitScheme:          DefaultBuildkitScheme,
 			BuildkitRestartTimeoutS: 60,
+			RegistryRetries:         3,
+			RegistryRetryDelayS:     1,
+			ContextSizeWarnMb:       500,
+			PreviewNamespacePrefix:  "preview-",
 			BuildkitAdditionalArgs:  []string{},
 			TLSCA:                   DefaultCA,
 			ClientTLSCert:           DefaultClientTLSCert,
@@ -0,0 +1,336 @@
+// Package coverage merges the coverage reports saved by several test targets,
+// as run by earthly coverage: Go cover profiles, lcov tracefiles and Cobertura
+// XML reports are merged into a single report of the same format, whose
+// coverage can be gated against a threshold.
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The formats of coverage reports.
+const (
+	FormatGo        = "go"
+	FormatLcov      = "lcov"
+	FormatCobertura = "cobertura"
+)
+
+// DefaultDir is where test targets are expected to save their coverage
+// reports, via SAVE ARTIFACT ... AS LOCAL coverage/, so that earthly coverage
+// merges them without further arguments.
+const DefaultDir = "coverage"
+
+// MergedName is the name of the merged report, without its extension, which
+// is not itself merged when it is in DefaultDir.
+const MergedName = "merged"
+
+// Block is a block of code whose coverage is tracked: a range of statements
+// of a Go cover profile, or a single line (with StartLine == EndLine and no
+// columns) of the other formats.
+type Block struct {
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+}
+
+// Count is the coverage of a block.
+type Count struct {
+	// Statements is the number of statements of the block.
+	Statements int
+	// Hits is how many times the block was run.
+	Hits int64
+}
+
+// File is the coverage of a source file.
+type File struct {
+	// Package is the package of the file, as in Cobertura reports.
+	Package string
+	// Class is the class of the file, as in Cobertura reports.
+	Class  string
+	Blocks map[Block]Count
+}
+
+// Report is a coverage report.
+type Report struct {
+	Format string
+	// Mode is the mode of a Go cover profile: set, count or atomic.
+	Mode string
+	// Sources are the directories the files of a Cobertura report are
+	// relative to.
+	Sources []string
+	Files   map[string]*File
+}
+
+func newReport(format string) *Report {
+	return &Report{Format: format, Files: make(map[string]*File)}
+}
+
+func (r *Report) file(name string) *File {
+	f, ok := r.Files[name]
+	if !ok {
+		f = &File{Blocks: make(map[Block]Count)}
+		r.Files[name] = f
+	}
+	return f
+}
+
+// add records hits of a block, which are added up with those already recorded,
+// unless only whether blocks were run is recorded (Go set mode).
+func (r *Report) add(file string, b Block, statements int, hits int64) {
+	f := r.file(file)
+	c := f.Blocks[b]
+	c.Statements = statements
+	if r.Mode == "set" {
+		if hits > 0 {
+			c.Hits = 1
+		}
+	} else {
+		c.Hits += hits
+	}
+	f.Blocks[b] = c
+}
+
+// DetectFormat returns the format of a coverage report.
+func DetectFormat(dt []byte) (string, error) {
+	trimmed := bytes.TrimSpace(dt)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("mode:")):
+		return FormatGo, nil
+	case bytes.HasPrefix(trimmed, []byte("<")) && bytes.Contains(trimmed, []byte("<coverage")):
+		return FormatCobertura, nil
+	case bytes.HasPrefix(trimmed, []byte("TN:")) || bytes.HasPrefix(trimmed, []byte("SF:")):
+		return FormatLcov, nil
+	default:
+		return "", errors.New("unknown coverage format: expected a Go cover profile, an lcov tracefile or a Cobertura report")
+	}
+}
+
+// Parse parses a coverage report of any of the supported formats.
+func Parse(dt []byte) (*Report, error) {
+	format, err := DetectFormat(dt)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatGo:
+		return parseGo(dt)
+	case FormatLcov:
+		return parseLcov(dt)
+	default:
+		return parseCobertura(dt)
+	}
+}
+
+// Merge merges the reports, which need to be of the same format (and of the
+// same mode, for Go cover profiles).
+func Merge(reports []*Report) (*Report, error) {
+	if len(reports) == 0 {
+		return nil, errors.New("no coverage report to merge")
+	}
+	merged := newReport(reports[0].Format)
+	merged.Mode = reports[0].Mode
+	for _, r := range reports {
+		if r.Format != merged.Format {
+			return nil, errors.Errorf("cannot merge %s and %s coverage reports", merged.Format, r.Format)
+		}
+		if r.Mode != merged.Mode {
+			return nil, errors.Errorf("cannot merge Go cover profiles of modes %s and %s", merged.Mode, r.Mode)
+		}
+		for _, s := range r.Sources {
+			if !containsString(merged.Sources, s) {
+				merged.Sources = append(merged.Sources, s)
+			}
+		}
+		for name, f := range r.Files {
+			mf := merged.file(name)
+			if mf.Package == "" {
+				mf.Package = f.Package
+			}
+			if mf.Class == "" {
+				mf.Class = f.Class
+			}
+			for b, c := range f.Blocks {
+				merged.add(name, b, c.Statements, c.Hits)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// Covered returns the number of statements of the report which were run, and
+// its total number of statements. For lcov and Cobertura, statements are
+// lines.
+func (r *Report) Covered() (int, int) {
+	covered, total := 0, 0
+	for _, f := range r.Files {
+		for _, c := range f.Blocks {
+			total += c.Statements
+			if c.Hits > 0 {
+				covered += c.Statements
+			}
+		}
+	}
+	return covered, total
+}
+
+// Percent returns the percentage of statements of the report which were run.
+// A report without statements is fully covered.
+func (r *Report) Percent() float64 {
+	covered, total := r.Covered()
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// Bytes returns the report, in its format.
+func (r *Report) Bytes() ([]byte, error) {
+	switch r.Format {
+	case FormatGo:
+		return r.goBytes(), nil
+	case FormatLcov:
+		return r.lcovBytes(), nil
+	case FormatCobertura:
+		return r.coberturaBytes()
+	default:
+		return nil, errors.Errorf("unknown coverage format %s", r.Format)
+	}
+}
+
+// Extension returns the extension of the files of the format of the report.
+func (r *Report) Extension() string {
+	switch r.Format {
+	case FormatGo:
+		return ".out"
+	case FormatLcov:
+		return ".info"
+	default:
+		return ".xml"
+	}
+}
+
+// Inputs returns the coverage reports to merge: those matching the patterns,
+// or those of DefaultDir (except the merged report) if there are none.
+func Inputs(patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		matches, err := filepath.Glob(filepath.Join(DefaultDir, "*"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "list %s", DefaultDir)
+		}
+		var inputs []string
+		for _, m := range matches {
+			base := filepath.Base(m)
+			if strings.TrimSuffix(base, filepath.Ext(base)) == MergedName {
+				continue
+			}
+			if fi, err := os.Stat(m); err == nil && fi.Mode().IsRegular() {
+				inputs = append(inputs, m)
+			}
+		}
+		if len(inputs) == 0 {
+			return nil, errors.Errorf("no coverage report found in %s", DefaultDir)
+		}
+		return inputs, nil
+	}
+	var inputs []string
+	for _, p := range patterns {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %s", p)
+		}
+		if len(matches) == 0 {
+			return nil, errors.Errorf("no coverage report matches %s", p)
+		}
+		inputs = append(inputs, matches...)
+	}
+	sort.Strings(inputs)
+	return inputs, nil
+}
+
+// ReadFiles reads and merges the coverage reports.
+func ReadFiles(paths []string) (*Report, error) {
+	reports := make([]*Report, 0, len(paths))
+	for _, p := range paths {
+		dt, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", p)
+		}
+		r, err := Parse(dt)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %s", p)
+		}
+		reports = append(reports, r)
+	}
+	return Merge(reports)
+}
+
+// CheckThreshold returns an error if the coverage of the report is below the
+// given percentage.
+func (r *Report) CheckThreshold(min float64) error {
+	if p := r.Percent(); p < min {
+		return errors.Errorf("coverage %s is below the threshold of %s", formatPercent(p), formatPercent(min))
+	}
+	return nil
+}
+
+// Summary returns the coverage of the report, as in 83.4% of 1200 statements.
+func (r *Report) Summary() string {
+	_, total := r.Covered()
+	unit := "statements"
+	if r.Format != FormatGo {
+		unit = "lines"
+	}
+	return fmt.Sprintf("%s of %d %s in %d files", formatPercent(r.Percent()), total, unit, len(r.Files))
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func formatPercent(p float64) string {
+	return fmt.Sprintf("%.1f%%", p)
+}
+
+func (r *Report) sortedFiles() []string {
+	names := make([]string, 0, len(r.Files))
+	for name := range r.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *File) sortedBlocks() []Block {
+	blocks := make([]Block, 0, len(f.Blocks))
+	for b := range f.Blocks {
+		blocks = append(blocks, b)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		a, b := blocks[i], blocks[j]
+		if a.StartLine != b.StartLine {
+			return a.StartLine < b.StartLine
+		}
+		if a.StartCol != b.StartCol {
+			return a.StartCol < b.StartCol
+		}
+		if a.EndLine != b.EndLine {
+			return a.EndLine < b.EndLine
+		}
+		return a.EndCol < b.EndCol
+	})
+	return blocks
+}
@@ -0,0 +1,140 @@
+package coverage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestMergeGo(t *testing.T) {
+	a, err := Parse([]byte("mode: count\nexample.com/app/a.go:3.10,5.2 2 1\nexample.com/app/a.go:7.10,9.2 1 0\n"))
+	NoError(t, err)
+	b, err := Parse([]byte("mode: count\nexample.com/app/a.go:7.10,9.2 1 3\nexample.com/app/b.go:1.1,2.2 3 0\n"))
+	NoError(t, err)
+	merged, err := Merge([]*Report{a, b})
+	NoError(t, err)
+	covered, total := merged.Covered()
+	Equal(t, 3, covered)
+	Equal(t, 6, total)
+	Equal(t, 50.0, merged.Percent())
+	dt, err := merged.Bytes()
+	NoError(t, err)
+	Equal(t, "mode: count\n"+
+		"example.com/app/a.go:3.10,5.2 2 1\n"+
+		"example.com/app/a.go:7.10,9.2 1 3\n"+
+		"example.com/app/b.go:1.1,2.2 3 0\n", string(dt))
+	Equal(t, "50.0% of 6 statements in 2 files", merged.Summary())
+	NoError(t, merged.CheckThreshold(50))
+	Error(t, merged.CheckThreshold(50.1))
+}
+
+func TestMergeGoSet(t *testing.T) {
+	a, err := Parse([]byte("mode: set\na.go:1.1,2.2 1 1\n"))
+	NoError(t, err)
+	b, err := Parse([]byte("mode: set\na.go:1.1,2.2 1 1\n"))
+	NoError(t, err)
+	merged, err := Merge([]*Report{a, b})
+	NoError(t, err)
+	Equal(t, int64(1), merged.Files["a.go"].Blocks[Block{1, 1, 2, 2}].Hits)
+
+	c, err := Parse([]byte("mode: count\na.go:1.1,2.2 1 1\n"))
+	NoError(t, err)
+	_, err = Merge([]*Report{a, c})
+	Error(t, err)
+}
+
+func TestMergeLcov(t *testing.T) {
+	a, err := Parse([]byte("TN:\nSF:src/a.js\nFN:1,f\nDA:1,1\nDA:2,0\nLF:2\nLH:1\nend_of_record\n"))
+	NoError(t, err)
+	b, err := Parse([]byte("SF:src/a.js\nDA:2,4\nend_of_record\nSF:src/b.js\nDA:1,0\nend_of_record\n"))
+	NoError(t, err)
+	merged, err := Merge([]*Report{a, b})
+	NoError(t, err)
+	dt, err := merged.Bytes()
+	NoError(t, err)
+	Equal(t, "TN:\n"+
+		"SF:src/a.js\nDA:1,1\nDA:2,4\nLF:2\nLH:2\nend_of_record\n"+
+		"SF:src/b.js\nDA:1,0\nLF:1\nLH:0\nend_of_record\n", string(dt))
+	Equal(t, ".info", merged.Extension())
+
+	_, err = Parse([]byte("SF:a.js\nDA:x,1\n"))
+	Error(t, err)
+}
+
+func TestMergeCobertura(t *testing.T) {
+	a, err := Parse([]byte(`<?xml version="1.0" ?>
+<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">
+<coverage line-rate="0.5" version="1.9">
+	<sources><source>/src</source></sources>
+	<packages>
+		<package name="app">
+			<classes>
+				<class name="app.a" filename="app/a.py" line-rate="0.5">
+					<methods/>
+					<lines><line number="1" hits="1"/><line number="2" hits="0"/></lines>
+				</class>
+			</classes>
+		</package>
+	</packages>
+</coverage>`))
+	NoError(t, err)
+	b, err := Parse([]byte(`<coverage><packages><package name="app"><classes>
+		<class name="app.a" filename="app/a.py"><lines><line number="2" hits="2"/></lines></class>
+		<class name="app.b" filename="app/b.py"><lines><line number="1" hits="0"/></lines></class>
+	</classes></package></packages></coverage>`))
+	NoError(t, err)
+	merged, err := Merge([]*Report{a, b})
+	NoError(t, err)
+	covered, total := merged.Covered()
+	Equal(t, 2, covered)
+	Equal(t, 3, total)
+	dt, err := merged.Bytes()
+	NoError(t, err)
+	roundTrip, err := Parse(dt)
+	NoError(t, err)
+	Equal(t, merged, roundTrip)
+	True(t, strings.Contains(string(dt), `<coverage line-rate="0.6667" lines-covered="2" lines-valid="3">`))
+	True(t, strings.Contains(string(dt), `<class name="app.b" filename="app/b.py" line-rate="0.0000">`))
+}
+
+func TestMergeMixedFormats(t *testing.T) {
+	a, err := Parse([]byte("mode: set\na.go:1.1,2.2 1 1\n"))
+	NoError(t, err)
+	b, err := Parse([]byte("SF:a.js\nDA:1,1\nend_of_record\n"))
+	NoError(t, err)
+	_, err = Merge([]*Report{a, b})
+	Error(t, err)
+
+	_, err = Parse([]byte("not a coverage report"))
+	Error(t, err)
+}
+
+func TestInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coverage")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	NoError(t, err)
+	NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	_, err = Inputs(nil)
+	Error(t, err)
+	NoError(t, os.MkdirAll(filepath.Join(DefaultDir, "sub"), 0755))
+	for _, name := range []string{"unit.out", "integration.out", "merged.out"} {
+		NoError(t, ioutil.WriteFile(filepath.Join(DefaultDir, name), []byte("mode: set\n"), 0644))
+	}
+	inputs, err := Inputs(nil)
+	NoError(t, err)
+	Equal(t, []string{filepath.Join(DefaultDir, "integration.out"), filepath.Join(DefaultDir, "unit.out")}, inputs)
+
+	inputs, err = Inputs([]string{filepath.Join(DefaultDir, "u*.out")})
+	NoError(t, err)
+	Equal(t, []string{filepath.Join(DefaultDir, "unit.out")}, inputs)
+	_, err = Inputs([]string{"missing/*.out"})
+	Error(t, err)
+}
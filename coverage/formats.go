@@ -0,0 +1,264 @@
+package coverage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// parseGo parses a Go cover profile, as output by go test -coverprofile.
+func parseGo(dt []byte) (*Report, error) {
+	r := newReport(FormatGo)
+	s := bufio.NewScanner(bytes.NewReader(dt))
+	s.Buffer(nil, 1024*1024)
+	lineNum := 0
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		lineNum++
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "mode:") {
+			mode := strings.TrimSpace(strings.TrimPrefix(line, "mode:"))
+			if r.Mode != "" && r.Mode != mode {
+				return nil, errors.Errorf("line %d: conflicting mode %s", lineNum, mode)
+			}
+			r.Mode = mode
+			continue
+		}
+		file, b, statements, hits, err := parseGoBlock(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNum)
+		}
+		r.add(file, b, statements, hits)
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "read cover profile")
+	}
+	switch r.Mode {
+	case "set", "count", "atomic":
+	default:
+		return nil, errors.Errorf("invalid cover profile mode %q", r.Mode)
+	}
+	return r, nil
+}
+
+// parseGoBlock parses a block of a Go cover profile, as in
+// example.com/pkg/file.go:12.2,14.16 3 1.
+func parseGoBlock(line string) (string, Block, int, int64, error) {
+	invalid := errors.Errorf("invalid cover profile block %q", line)
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return "", Block{}, 0, 0, invalid
+	}
+	colon := strings.LastIndexByte(fields[0], ':')
+	if colon <= 0 {
+		return "", Block{}, 0, 0, invalid
+	}
+	file := fields[0][:colon]
+	var b Block
+	_, err := fmt.Sscanf(fields[0][colon+1:], "%d.%d,%d.%d", &b.StartLine, &b.StartCol, &b.EndLine, &b.EndCol)
+	if err != nil {
+		return "", Block{}, 0, 0, invalid
+	}
+	statements, err := strconv.Atoi(fields[1])
+	if err != nil || statements < 0 {
+		return "", Block{}, 0, 0, invalid
+	}
+	hits, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || hits < 0 {
+		return "", Block{}, 0, 0, invalid
+	}
+	return file, b, statements, hits, nil
+}
+
+func (r *Report) goBytes() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "mode: %s\n", r.Mode)
+	for _, name := range r.sortedFiles() {
+		f := r.Files[name]
+		for _, b := range f.sortedBlocks() {
+			c := f.Blocks[b]
+			fmt.Fprintf(&buf, "%s:%d.%d,%d.%d %d %d\n", name, b.StartLine, b.StartCol, b.EndLine, b.EndCol, c.Statements, c.Hits)
+		}
+	}
+	return buf.Bytes()
+}
+
+// parseLcov parses the line coverage of an lcov tracefile. Function and branch
+// records are ignored.
+func parseLcov(dt []byte) (*Report, error) {
+	r := newReport(FormatLcov)
+	s := bufio.NewScanner(bytes.NewReader(dt))
+	s.Buffer(nil, 1024*1024)
+	file := ""
+	lineNum := 0
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		lineNum++
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			file = strings.TrimPrefix(line, "SF:")
+			r.file(file)
+		case strings.HasPrefix(line, "DA:"):
+			if file == "" {
+				return nil, errors.Errorf("line %d: DA record outside of a source file record", lineNum)
+			}
+			fields := strings.Split(strings.TrimPrefix(line, "DA:"), ",")
+			if len(fields) < 2 {
+				return nil, errors.Errorf("line %d: invalid DA record %q", lineNum, line)
+			}
+			n, err := strconv.Atoi(fields[0])
+			if err != nil || n <= 0 {
+				return nil, errors.Errorf("line %d: invalid DA record %q", lineNum, line)
+			}
+			hits, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil || hits < 0 {
+				return nil, errors.Errorf("line %d: invalid DA record %q", lineNum, line)
+			}
+			r.add(file, Block{StartLine: n, EndLine: n}, 1, hits)
+		case line == "end_of_record":
+			file = ""
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "read lcov tracefile")
+	}
+	return r, nil
+}
+
+func (r *Report) lcovBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("TN:\n")
+	for _, name := range r.sortedFiles() {
+		f := r.Files[name]
+		fmt.Fprintf(&buf, "SF:%s\n", name)
+		covered := 0
+		for _, b := range f.sortedBlocks() {
+			c := f.Blocks[b]
+			fmt.Fprintf(&buf, "DA:%d,%d\n", b.StartLine, c.Hits)
+			if c.Hits > 0 {
+				covered++
+			}
+		}
+		fmt.Fprintf(&buf, "LF:%d\nLH:%d\nend_of_record\n", len(f.Blocks), covered)
+	}
+	return buf.Bytes()
+}
+
+type coberturaLine struct {
+	Number int   `xml:"number,attr"`
+	Hits   int64 `xml:"hits,attr"`
+}
+
+type coberturaClass struct {
+	Name     string          `xml:"name,attr"`
+	Filename string          `xml:"filename,attr"`
+	LineRate string          `xml:"line-rate,attr"`
+	Lines    []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaPackage struct {
+	Name     string           `xml:"name,attr"`
+	LineRate string           `xml:"line-rate,attr"`
+	Classes  []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaReport struct {
+	XMLName      xml.Name           `xml:"coverage"`
+	LineRate     string             `xml:"line-rate,attr"`
+	LinesCovered int                `xml:"lines-covered,attr"`
+	LinesValid   int                `xml:"lines-valid,attr"`
+	Version      string             `xml:"version,attr,omitempty"`
+	Sources      []string           `xml:"sources>source,omitempty"`
+	Packages     []coberturaPackage `xml:"packages>package"`
+}
+
+// parseCobertura parses the line coverage of the classes of a Cobertura
+// report. Method and branch details are ignored.
+func parseCobertura(dt []byte) (*Report, error) {
+	var cr coberturaReport
+	err := xml.Unmarshal(dt, &cr)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse cobertura report")
+	}
+	r := newReport(FormatCobertura)
+	r.Sources = cr.Sources
+	for _, p := range cr.Packages {
+		for _, c := range p.Classes {
+			if c.Filename == "" {
+				return nil, errors.Errorf("class %s of package %s has no filename", c.Name, p.Name)
+			}
+			f := r.file(c.Filename)
+			f.Package = p.Name
+			f.Class = c.Name
+			for _, l := range c.Lines {
+				r.add(c.Filename, Block{StartLine: l.Number, EndLine: l.Number}, 1, l.Hits)
+			}
+		}
+	}
+	return r, nil
+}
+
+func (r *Report) coberturaBytes() ([]byte, error) {
+	covered, total := r.Covered()
+	cr := coberturaReport{
+		LineRate:     lineRate(covered, total),
+		LinesCovered: covered,
+		LinesValid:   total,
+		Sources:      r.Sources,
+	}
+	packageIndexes := make(map[string]int)
+	for _, name := range r.sortedFiles() {
+		f := r.Files[name]
+		class := coberturaClass{Name: f.Class, Filename: name}
+		if class.Name == "" {
+			class.Name = name
+		}
+		fileCovered := 0
+		for _, b := range f.sortedBlocks() {
+			c := f.Blocks[b]
+			class.Lines = append(class.Lines, coberturaLine{Number: b.StartLine, Hits: c.Hits})
+			if c.Hits > 0 {
+				fileCovered++
+			}
+		}
+		class.LineRate = lineRate(fileCovered, len(f.Blocks))
+		i, ok := packageIndexes[f.Package]
+		if !ok {
+			i = len(cr.Packages)
+			packageIndexes[f.Package] = i
+			cr.Packages = append(cr.Packages, coberturaPackage{Name: f.Package})
+		}
+		cr.Packages[i].Classes = append(cr.Packages[i].Classes, class)
+	}
+	for i, p := range cr.Packages {
+		pCovered, pTotal := 0, 0
+		for _, c := range p.Classes {
+			for _, l := range c.Lines {
+				pTotal++
+				if l.Hits > 0 {
+					pCovered++
+				}
+			}
+		}
+		cr.Packages[i].LineRate = lineRate(pCovered, pTotal)
+	}
+	dt, err := xml.MarshalIndent(cr, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal cobertura report")
+	}
+	return append([]byte(xml.Header), append(dt, '\n')...), nil
+}
+
+func lineRate(covered, total int) string {
+	if total == 0 {
+		return "1"
+	}
+	return strconv.FormatFloat(float64(covered)/float64(total), 'f', 4, 64)
+}
@@ -0,0 +1,25 @@
+package common
+
+import "time"
+
+// ServiceSpec is a service the debugger runs alongside a command, as per
+// RUN --service.
+type ServiceSpec struct {
+	// Name is the name of the service, which its logs are prefixed with.
+	Name string `json:"name"`
+	// Root is where the root filesystem of the service is mounted.
+	Root string `json:"root"`
+	// Args are the entrypoint and command of the service.
+	Args []string `json:"args"`
+	// Env are the environment variables of the service, as in KEY=value.
+	Env []string `json:"env,omitempty"`
+	// WorkingDir is the working directory of the service, within its root.
+	WorkingDir string `json:"workingDir,omitempty"`
+	// User is the user the service runs as, as in postgres or 999:999.
+	User string `json:"user,omitempty"`
+	// Ports are the TCP ports the service is ready once it accepts
+	// connections on.
+	Ports []int `json:"ports,omitempty"`
+	// ReadyTimeout is how long the service is waited for to be ready.
+	ReadyTimeout time.Duration `json:"readyTimeout"`
+}
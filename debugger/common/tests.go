@@ -0,0 +1,31 @@
+package common
+
+// TestReportPrefix prefixes the line of output via which the debugger reports
+// the outcome of the tests of a command, for earthly to collect.
+const TestReportPrefix = "earthly-test-report: "
+
+// RerunSpec is how the debugger reruns the failed tests of a command, as per
+// RUN --junit and --rerun-failed.
+type RerunSpec struct {
+	// Reports are the glob patterns of the JUnit reports written by the
+	// command.
+	Reports []string `json:"reports"`
+	// Reruns is how many times the failed tests are rerun.
+	Reruns int `json:"reruns,omitempty"`
+	// Quarantine are the glob patterns of the tests whose failures do not
+	// fail the command.
+	Quarantine []string `json:"quarantine,omitempty"`
+}
+
+// TestReport is the outcome of the failed tests of a command.
+type TestReport struct {
+	// Flaky are the tests which failed, and then passed when rerun.
+	Flaky []string `json:"flaky,omitempty"`
+	// Failed are the tests which failed every attempt.
+	Failed []string `json:"failed,omitempty"`
+	// Quarantined are the tests which failed every attempt, but are
+	// quarantined.
+	Quarantined []string `json:"quarantined,omitempty"`
+	// Attempts is how many times the command was run.
+	Attempts int `json:"attempts"`
+}
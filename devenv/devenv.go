@@ -0,0 +1,254 @@
+// Package devenv turns build targets into development environments, as run by
+// earthly dev: the image of the target is started as an interactive container,
+// with the build context mounted and the ports it EXPOSEs forwarded, or is
+// described in a devcontainer.json for IDEs. It also runs targets as local
+// services, along with the compose services they are tested with, as run by
+// earthly up.
+package devenv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+)
+
+// DefaultWorkspace is where the build context is mounted, if the image of the
+// target does not set a WORKDIR.
+const DefaultWorkspace = "/workspace"
+
+// DevContainerPath is the path of the devcontainer.json, relative to the
+// build context.
+var DevContainerPath = filepath.Join(".devcontainer", "devcontainer.json")
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// ImageTag returns the tag the image of the dev environment of the target is
+// saved as.
+func ImageTag(target domain.Target, contextDir string) string {
+	return fmt.Sprintf("earthly-dev/%s:latest", name(target, contextDir))
+}
+
+// ContainerName returns the name of the container of the dev environment of
+// the target.
+func ContainerName(target domain.Target, contextDir string) string {
+	return fmt.Sprintf("earthly-dev-%s", name(target, contextDir))
+}
+
+func name(target domain.Target, contextDir string) string {
+	n := strings.ToLower(fmt.Sprintf("%s-%s", filepath.Base(contextDir), target.GetName()))
+	n = invalidNameChars.ReplaceAllString(n, "-")
+	return strings.Trim(n, "-._")
+}
+
+// ImageConfig is the part of the config of an image which matters to the
+// dev environment.
+type ImageConfig struct {
+	WorkingDir   string
+	ExposedPorts []Port
+}
+
+// Workspace returns where the build context is mounted in the container.
+func (cfg ImageConfig) Workspace() string {
+	if cfg.WorkingDir == "" {
+		return DefaultWorkspace
+	}
+	return cfg.WorkingDir
+}
+
+// Port is a port exposed by the image.
+type Port struct {
+	Number   int
+	Protocol string
+}
+
+// ParsePort parses a port, as in 8080 or 53/udp.
+func ParsePort(s string) (Port, error) {
+	number, protocol := s, "tcp"
+	if i := strings.IndexByte(s, '/'); i != -1 {
+		number, protocol = s[:i], strings.ToLower(s[i+1:])
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n <= 0 || n > 65535 {
+		return Port{}, errors.Errorf("invalid port %q", s)
+	}
+	switch protocol {
+	case "tcp", "udp", "sctp":
+	default:
+		return Port{}, errors.Errorf("invalid protocol of port %q", s)
+	}
+	return Port{Number: n, Protocol: protocol}, nil
+}
+
+// String returns the port as expected by docker run -p.
+func (p Port) String() string {
+	if p.Protocol == "tcp" {
+		return strconv.Itoa(p.Number)
+	}
+	return fmt.Sprintf("%d/%s", p.Number, p.Protocol)
+}
+
+// ParseImageConfig parses the config of an image, as output by docker image
+// inspect --format '{{json .Config}}'.
+func ParseImageConfig(dt []byte) (ImageConfig, error) {
+	var raw struct {
+		WorkingDir   string
+		ExposedPorts map[string]struct{}
+	}
+	err := json.Unmarshal(dt, &raw)
+	if err != nil {
+		return ImageConfig{}, errors.Wrap(err, "unmarshal image config")
+	}
+	cfg := ImageConfig{WorkingDir: raw.WorkingDir}
+	for s := range raw.ExposedPorts {
+		p, err := ParsePort(s)
+		if err != nil {
+			return ImageConfig{}, err
+		}
+		cfg.ExposedPorts = append(cfg.ExposedPorts, p)
+	}
+	sortPorts(cfg.ExposedPorts)
+	return cfg, nil
+}
+
+// InspectImage returns the config of an image of the local docker daemon.
+func InspectImage(ctx context.Context, tag string) (ImageConfig, error) {
+	cmd := exec.CommandContext(ctx, "docker", "image", "inspect", "--format", "{{json .Config}}", tag)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return ImageConfig{}, errors.Wrapf(err, "docker image inspect %s: %s", tag, strings.TrimSpace(stderr.String()))
+	}
+	return ParseImageConfig(out)
+}
+
+// RunOpt are the options of the container of a dev environment.
+type RunOpt struct {
+	// ContextDir is the build context, which is mounted in the container.
+	ContextDir string
+	// Name is the name of the container.
+	Name string
+	// Shell is the command run in the container, instead of the entrypoint of
+	// the image. If empty, the entrypoint of the image is run.
+	Shell string
+	// ExtraPorts are forwarded in addition to those exposed by the image.
+	ExtraPorts []Port
+}
+
+// RunArgs returns the args of docker which start the dev environment.
+func RunArgs(tag string, cfg ImageConfig, opt RunOpt) []string {
+	args := []string{
+		"run", "--rm", "-it",
+		"--name", opt.Name,
+		"-v", fmt.Sprintf("%s:%s", opt.ContextDir, cfg.Workspace()),
+		"-w", cfg.Workspace(),
+	}
+	for _, p := range Ports(cfg, opt.ExtraPorts) {
+		args = append(args, "-p", fmt.Sprintf("%d:%s", p.Number, p))
+	}
+	if opt.Shell != "" {
+		args = append(args, "--entrypoint", opt.Shell)
+	}
+	return append(args, tag)
+}
+
+// Run starts the dev environment, attached to the terminal, and returns once
+// it exits.
+func Run(ctx context.Context, args []string) error {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return errors.Wrap(err, "docker run")
+	}
+	return nil
+}
+
+// Ports returns the ports exposed by the image and the extra ports, without
+// duplicates.
+func Ports(cfg ImageConfig, extra []Port) []Port {
+	seen := make(map[Port]bool)
+	var ports []Port
+	for _, p := range append(append([]Port{}, cfg.ExposedPorts...), extra...) {
+		if !seen[p] {
+			seen[p] = true
+			ports = append(ports, p)
+		}
+	}
+	sortPorts(ports)
+	return ports
+}
+
+func sortPorts(ports []Port) {
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Number != ports[j].Number {
+			return ports[i].Number < ports[j].Number
+		}
+		return ports[i].Protocol < ports[j].Protocol
+	})
+}
+
+// DevContainer is a devcontainer.json, as read by IDEs such as VS Code.
+type DevContainer struct {
+	Name              string `json:"name"`
+	Image             string `json:"image"`
+	InitializeCommand string `json:"initializeCommand"`
+	WorkspaceMount    string `json:"workspaceMount"`
+	WorkspaceFolder   string `json:"workspaceFolder"`
+	ForwardPorts      []int  `json:"forwardPorts,omitempty"`
+	OverrideCommand   bool   `json:"overrideCommand"`
+}
+
+// NewDevContainer returns the devcontainer.json of the dev environment of the
+// target. The image is rebuilt by the IDE via earthly dev --no-run before the
+// container is started, so that it stays up to date with the Earthfile.
+func NewDevContainer(target domain.Target, tag string, cfg ImageConfig, extraPorts []Port) DevContainer {
+	dc := DevContainer{
+		Name:              fmt.Sprintf("earthly +%s", target.GetName()),
+		Image:             tag,
+		InitializeCommand: fmt.Sprintf("earthly dev --no-run +%s", target.GetName()),
+		WorkspaceMount:    fmt.Sprintf("source=${localWorkspaceFolder},target=%s,type=bind", cfg.Workspace()),
+		WorkspaceFolder:   cfg.Workspace(),
+		OverrideCommand:   true,
+	}
+	for _, p := range Ports(cfg, extraPorts) {
+		// Only TCP ports can be forwarded by IDEs.
+		if p.Protocol == "tcp" {
+			dc.ForwardPorts = append(dc.ForwardPorts, p.Number)
+		}
+	}
+	return dc
+}
+
+// WriteDevContainer writes the devcontainer.json to the build context, and
+// returns its path.
+func WriteDevContainer(contextDir string, dc DevContainer) (string, error) {
+	path := filepath.Join(contextDir, DevContainerPath)
+	dt, err := json.MarshalIndent(dc, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "marshal devcontainer.json")
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", errors.Wrapf(err, "create dir %s", filepath.Dir(path))
+	}
+	err = ioutil.WriteFile(path, append(dt, '\n'), 0644)
+	if err != nil {
+		return "", errors.Wrapf(err, "write %s", path)
+	}
+	return path, nil
+}
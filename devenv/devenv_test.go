@@ -0,0 +1,81 @@
+package devenv
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestImageTag(t *testing.T) {
+	target := domain.Target{LocalPath: ".", Target: "Dev_Env"}
+	Equal(t, "earthly-dev/my-app-dev_env:latest", ImageTag(target, "/home/user/My App"))
+	Equal(t, "earthly-dev-my-app-dev_env", ContainerName(target, "/home/user/My App"))
+}
+
+func TestParsePort(t *testing.T) {
+	p, err := ParsePort("8080")
+	NoError(t, err)
+	Equal(t, Port{Number: 8080, Protocol: "tcp"}, p)
+	Equal(t, "8080", p.String())
+
+	p, err = ParsePort("53/UDP")
+	NoError(t, err)
+	Equal(t, Port{Number: 53, Protocol: "udp"}, p)
+	Equal(t, "53/udp", p.String())
+
+	_, err = ParsePort("http")
+	Error(t, err)
+	_, err = ParsePort("70000")
+	Error(t, err)
+	_, err = ParsePort("80/icmp")
+	Error(t, err)
+}
+
+func TestParseImageConfig(t *testing.T) {
+	cfg, err := ParseImageConfig([]byte(`{"WorkingDir":"/src","ExposedPorts":{"9090/tcp":{},"53/udp":{},"8080/tcp":{}}}`))
+	NoError(t, err)
+	Equal(t, "/src", cfg.Workspace())
+	Equal(t, []Port{{53, "udp"}, {8080, "tcp"}, {9090, "tcp"}}, cfg.ExposedPorts)
+
+	cfg, err = ParseImageConfig([]byte(`{"WorkingDir":"","ExposedPorts":null}`))
+	NoError(t, err)
+	Equal(t, DefaultWorkspace, cfg.Workspace())
+	Empty(t, cfg.ExposedPorts)
+}
+
+func TestRunArgs(t *testing.T) {
+	cfg := ImageConfig{ExposedPorts: []Port{{8080, "tcp"}, {53, "udp"}}}
+	args := RunArgs("earthly-dev/app-dev:latest", cfg, RunOpt{
+		ContextDir: "/home/user/app",
+		Name:       "earthly-dev-app-dev",
+		Shell:      "/bin/bash",
+		ExtraPorts: []Port{{3000, "tcp"}, {8080, "tcp"}},
+	})
+	Equal(t, []string{
+		"run", "--rm", "-it",
+		"--name", "earthly-dev-app-dev",
+		"-v", "/home/user/app:/workspace",
+		"-w", "/workspace",
+		"-p", "53:53/udp",
+		"-p", "3000:3000",
+		"-p", "8080:8080",
+		"--entrypoint", "/bin/bash",
+		"earthly-dev/app-dev:latest",
+	}, args)
+}
+
+func TestNewDevContainer(t *testing.T) {
+	target := domain.Target{LocalPath: ".", Target: "dev"}
+	cfg := ImageConfig{WorkingDir: "/src", ExposedPorts: []Port{{8080, "tcp"}, {53, "udp"}}}
+	dc := NewDevContainer(target, "earthly-dev/app-dev:latest", cfg, nil)
+	Equal(t, DevContainer{
+		Name:              "earthly +dev",
+		Image:             "earthly-dev/app-dev:latest",
+		InitializeCommand: "earthly dev --no-run +dev",
+		WorkspaceMount:    "source=${localWorkspaceFolder},target=/src,type=bind",
+		WorkspaceFolder:   "/src",
+		ForwardPorts:      []int{8080},
+		OverrideCommand:   true,
+	}, dc)
+}
@@ -0,0 +1,276 @@
+package devenv
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/earthly/earthly/ast"
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+)
+
+// stopTimeout is how long the containers are given to exit gracefully when
+// earthly up is interrupted.
+const stopTimeout = 10 * time.Second
+
+// Compose is the compose definition of the services a target runs with, as
+// declared by the --compose and --service flags of its WITH DOCKER commands.
+type Compose struct {
+	Files    []string
+	Services []string
+}
+
+// Empty returns whether the target does not run with any compose services.
+func (c Compose) Empty() bool {
+	return len(c.Files) == 0
+}
+
+// withDockerValueFlags are the flags of WITH DOCKER which take a value.
+var withDockerValueFlags = map[string]bool{
+	"compose":       true,
+	"service":       true,
+	"load":          true,
+	"platform":      true,
+	"build-arg":     true,
+	"pull":          true,
+	"wait-for-port": true,
+	"wait-for-http": true,
+	"wait-timeout":  true,
+	"volume":        true,
+	"setup":         true,
+}
+
+// UpName returns the name of the container of the target run by earthly up,
+// and of the compose project of its services.
+func UpName(target domain.Target, contextDir string) string {
+	return fmt.Sprintf("earthly-up-%s", name(target, contextDir))
+}
+
+// ComposeOfTarget returns the compose definition of the target of the
+// Earthfile.
+func ComposeOfTarget(ctx context.Context, earthfilePath, targetName string) (Compose, error) {
+	ef, err := ast.Parse(ctx, earthfilePath, false)
+	if err != nil {
+		return Compose{}, errors.Wrapf(err, "parse %s", earthfilePath)
+	}
+	for _, t := range ef.Targets {
+		if t.Name == targetName {
+			return ComposeOf(t.Recipe)
+		}
+	}
+	return Compose{}, errors.Errorf("target %s not found in %s", targetName, earthfilePath)
+}
+
+// ComposeOf returns the compose definition of the WITH DOCKER commands of the
+// recipe, including those nested in IF and FOR blocks.
+func ComposeOf(recipe spec.Block) (Compose, error) {
+	var c Compose
+	err := addCompose(&c, recipe)
+	return c, err
+}
+
+func addCompose(c *Compose, block spec.Block) error {
+	for _, stmt := range block {
+		switch {
+		case stmt.With != nil:
+			if stmt.With.Command.Name == "DOCKER" {
+				err := addWithDockerFlags(c, stmt.With.Command.Args)
+				if err != nil {
+					return err
+				}
+			}
+			err := addCompose(c, stmt.With.Body)
+			if err != nil {
+				return err
+			}
+		case stmt.If != nil:
+			blocks := []spec.Block{stmt.If.IfBody}
+			for _, elseIf := range stmt.If.ElseIf {
+				blocks = append(blocks, elseIf.Body)
+			}
+			if stmt.If.ElseBody != nil {
+				blocks = append(blocks, *stmt.If.ElseBody)
+			}
+			for _, b := range blocks {
+				err := addCompose(c, b)
+				if err != nil {
+					return err
+				}
+			}
+		case stmt.For != nil:
+			err := addCompose(c, stmt.For.Body)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func addWithDockerFlags(c *Compose, args []string) error {
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "--") {
+			continue
+		}
+		name := strings.TrimPrefix(args[i], "--")
+		value := ""
+		if eq := strings.IndexByte(name, '='); eq != -1 {
+			name, value = name[:eq], name[eq+1:]
+		} else if withDockerValueFlags[name] {
+			if i+1 == len(args) {
+				return errors.Errorf("WITH DOCKER flag --%s requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		if strings.Contains(value, "$") && (name == "compose" || name == "service") {
+			return errors.Errorf("WITH DOCKER --%s %s: ARGs are not supported by earthly up", name, value)
+		}
+		switch name {
+		case "compose":
+			if !containsString(c.Files, value) {
+				c.Files = append(c.Files, value)
+			}
+		case "service":
+			if !containsString(c.Services, value) {
+				c.Services = append(c.Services, value)
+			}
+		}
+	}
+	return nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// UpOpt are the options of the services run by earthly up.
+type UpOpt struct {
+	// ContextDir is the directory of the Earthfile declaring the compose
+	// services, which compose files are relative to.
+	ContextDir string
+	// Name is the name of the container of the target, and of the compose
+	// project of its services.
+	Name string
+	// ExtraPorts are published in addition to those exposed by the image.
+	ExtraPorts []Port
+	// Compose is the definition of the services the target runs with.
+	Compose Compose
+	// Stdout and Stderr receive the logs of the services.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// ComposeProject returns the name of the compose project of the services.
+func (opt UpOpt) ComposeProject() string {
+	return strings.ReplaceAll(opt.Name, ".", "-")
+}
+
+// ComposeArgs returns the args of docker which run the compose subcommand
+// on the services.
+func (opt UpOpt) ComposeArgs(subcommand ...string) []string {
+	args := []string{"compose", "--project-name", opt.ComposeProject()}
+	for _, f := range opt.Compose.Files {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(opt.ContextDir, f)
+		}
+		args = append(args, "--file", f)
+	}
+	return append(args, subcommand...)
+}
+
+// UpArgs returns the args of docker which run the image of the target, with
+// its ports published. The container joins the default network of the
+// compose services, if any, so that it can reach them by name.
+func UpArgs(tag string, cfg ImageConfig, opt UpOpt) []string {
+	args := []string{"run", "--rm", "--name", opt.Name}
+	if !opt.Compose.Empty() {
+		args = append(args, "--network", opt.ComposeProject()+"_default")
+	}
+	for _, p := range Ports(cfg, opt.ExtraPorts) {
+		args = append(args, "-p", fmt.Sprintf("%d:%s", p.Number, p))
+	}
+	return append(args, tag)
+}
+
+// Up runs the image of the target, and its compose services if any, streaming
+// their logs until the target exits or ctx is cancelled (such as on Ctrl-C),
+// and then tears everything down.
+func Up(ctx context.Context, tag string, cfg ImageConfig, opt UpOpt) (retErr error) {
+	if !opt.Compose.Empty() {
+		upArgs := append([]string{"up", "--detach"}, opt.Compose.Services...)
+		err := docker(ctx, opt, opt.ComposeArgs(upArgs...)...)
+		if err != nil {
+			return errors.Wrap(err, "start compose services")
+		}
+		defer func() {
+			// Tear down even if ctx has been cancelled.
+			err := docker(context.Background(), opt, opt.ComposeArgs("down", "--timeout", fmt.Sprintf("%d", int(stopTimeout.Seconds())))...)
+			if err != nil && retErr == nil {
+				retErr = errors.Wrap(err, "stop compose services")
+			}
+		}()
+		logsCtx, cancelLogs := context.WithCancel(ctx)
+		defer cancelLogs()
+		logsArgs := append([]string{"logs", "--follow"}, opt.Compose.Services...)
+		logs := exec.CommandContext(logsCtx, "docker", opt.ComposeArgs(logsArgs...)...)
+		logs.Stdout = opt.Stdout
+		logs.Stderr = opt.Stderr
+		err = logs.Start()
+		if err != nil {
+			return errors.Wrap(err, "stream compose logs")
+		}
+		defer logs.Wait()
+	}
+
+	// The container is not bound to ctx, so that it can be stopped gracefully
+	// rather than killed.
+	cmd := exec.Command("docker", UpArgs(tag, cfg, opt)...)
+	cmd.Stdout = opt.Stdout
+	cmd.Stderr = opt.Stderr
+	err := cmd.Start()
+	if err != nil {
+		return errors.Wrap(err, "docker run")
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return errors.Wrapf(err, "%s exited", tag)
+		}
+		return nil
+	case <-ctx.Done():
+		// The container may already be exiting, as Ctrl-C is also forwarded
+		// to it by docker run, in which case stopping it fails harmlessly.
+		_ = docker(context.Background(), opt, "stop", "--time", fmt.Sprintf("%d", int(stopTimeout.Seconds())), opt.Name)
+		<-done
+		return nil
+	}
+}
+
+// docker runs a docker command to completion, with its output going to the
+// logs of the services.
+func docker(ctx context.Context, opt UpOpt, args ...string) error {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = opt.Stdout
+	cmd.Stderr = opt.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return errors.Wrapf(err, "docker %s", strings.Join(args, " "))
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package devenv
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestComposeOf(t *testing.T) {
+	withDocker := func(args ...string) spec.Statement {
+		return spec.Statement{With: &spec.WithStatement{
+			Command: spec.Command{Name: "DOCKER", Args: args},
+			Body:    spec.Block{{Command: &spec.Command{Name: "RUN", Args: []string{"./integration-test"}}}},
+		}}
+	}
+	recipe := spec.Block{
+		{Command: &spec.Command{Name: "FROM", Args: []string{"earthly/dind:alpine"}}},
+		withDocker("--load", "app:latest=+app", "--compose", "docker-compose.yml", "--service=db"),
+		{If: &spec.IfStatement{
+			Expression: []string{"true"},
+			IfBody: spec.Block{
+				withDocker("--compose=docker-compose.yml", "--allow-privileged", "--service", "cache"),
+			},
+		}},
+	}
+	c, err := ComposeOf(recipe)
+	NoError(t, err)
+	Equal(t, Compose{Files: []string{"docker-compose.yml"}, Services: []string{"db", "cache"}}, c)
+
+	c, err = ComposeOf(spec.Block{withDocker("--load", "app:latest=+app")})
+	NoError(t, err)
+	True(t, c.Empty())
+
+	_, err = ComposeOf(spec.Block{withDocker("--compose", "$COMPOSE_FILE")})
+	Error(t, err)
+	_, err = ComposeOf(spec.Block{withDocker("--compose")})
+	Error(t, err)
+}
+
+func TestUpArgs(t *testing.T) {
+	target := domain.Target{LocalPath: ".", Target: "app"}
+	opt := UpOpt{
+		ContextDir: "/home/user/app",
+		Name:       UpName(target, "/home/user/app"),
+		ExtraPorts: []Port{{9000, "tcp"}},
+		Compose:    Compose{Files: []string{"docker-compose.yml", "/etc/compose/extra.yml"}, Services: []string{"db"}},
+	}
+	Equal(t, []string{
+		"compose", "--project-name", "earthly-up-app-app",
+		"--file", "/home/user/app/docker-compose.yml",
+		"--file", "/etc/compose/extra.yml",
+		"up", "--detach", "db",
+	}, opt.ComposeArgs("up", "--detach", "db"))
+	Equal(t, []string{
+		"run", "--rm", "--name", "earthly-up-app-app",
+		"--network", "earthly-up-app-app_default",
+		"-p", "8080:8080",
+		"-p", "9000:9000",
+		"earthly-dev/app-app:latest",
+	}, UpArgs("earthly-dev/app-app:latest", ImageConfig{ExposedPorts: []Port{{8080, "tcp"}}}, opt))
+
+	opt.Compose = Compose{}
+	Equal(t, []string{
+		"run", "--rm", "--name", "earthly-up-app-app",
+		"-p", "9000:9000",
+		"earthly-dev/app-app:latest",
+	}, UpArgs("earthly-dev/app-app:latest", ImageConfig{}, opt))
+}
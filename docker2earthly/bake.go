@@ -0,0 +1,531 @@
+package docker2earthly
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/earthly/earthly/util/fileutil"
+	"github.com/pkg/errors"
+)
+
+// BakeFile is the definition of the groups and targets of a docker-bake file.
+type BakeFile struct {
+	// Variables maps the names of the variables to their defaults.
+	Variables map[string]string
+	Groups    map[string][]string
+	Targets   map[string]*BakeTarget
+	// Order is the names of the variables, groups and targets, in the order
+	// they are defined in.
+	Order []string
+}
+
+// BakeTarget is a target of a docker-bake file.
+type BakeTarget struct {
+	Context    string
+	Dockerfile string
+	// Target is the stage of the Dockerfile to build.
+	Target    string
+	Args      map[string]string
+	Labels    map[string]string
+	Tags      []string
+	Platforms []string
+	CacheFrom []string
+	Inherits  []string
+	// Unsupported is the attributes of the target which cannot be converted.
+	Unsupported []string
+}
+
+// bakeTargetAttrs are the attributes of bake targets which are converted.
+var bakeTargetAttrs = map[string]bool{
+	"context":    true,
+	"dockerfile": true,
+	"target":     true,
+	"args":       true,
+	"labels":     true,
+	"tags":       true,
+	"platforms":  true,
+	"cache-from": true,
+	"inherits":   true,
+}
+
+// ParseBake parses a docker-bake file, either in HCL or, if its name ends
+// with .json, in JSON.
+func ParseBake(name string, dt []byte) (*BakeFile, error) {
+	var blocks []bakeBlock
+	if strings.HasSuffix(name, ".json") {
+		var err error
+		blocks, err = bakeJSONBlocks(dt)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %s", name)
+		}
+	} else {
+		var err error
+		blocks, err = parseBakeHCL(string(dt))
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %s", name)
+		}
+	}
+	bf := &BakeFile{
+		Variables: make(map[string]string),
+		Groups:    make(map[string][]string),
+		Targets:   make(map[string]*BakeTarget),
+	}
+	seen := make(map[string]bool)
+	for _, b := range blocks {
+		if len(b.labels) != 1 {
+			return nil, errors.Errorf("line %d: %s block must have exactly one label", b.line, b.typ)
+		}
+		name := b.labels[0]
+		if b.typ == "variable" || b.typ == "group" || b.typ == "target" {
+			if seen[name] {
+				return nil, errors.Errorf("line %d: %s is defined more than once", b.line, name)
+			}
+			seen[name] = true
+			bf.Order = append(bf.Order, name)
+		}
+		var err error
+		switch b.typ {
+		case "variable":
+			bf.Variables[name], err = stringAttr(b.attrs, "default")
+		case "group":
+			bf.Groups[name], err = listAttr(b.attrs, "targets")
+		case "target":
+			bf.Targets[name], err = newBakeTarget(b.attrs)
+		default:
+			err = errors.Errorf("%s blocks are not supported", b.typ)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s %q", b.typ, name)
+		}
+	}
+	return bf, nil
+}
+
+// bakeJSONBlocks converts the JSON format of docker-bake files to blocks, in
+// the order of their names.
+func bakeJSONBlocks(dt []byte) ([]bakeBlock, error) {
+	var doc map[string]map[string]map[string]interface{}
+	err := json.Unmarshal(dt, &doc)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []bakeBlock
+	for _, typ := range []string{"variable", "group", "target"} {
+		names := make([]string, 0, len(doc[typ]))
+		for name := range doc[typ] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			attrs := doc[typ][name]
+			if attrs == nil {
+				attrs = make(map[string]interface{})
+			}
+			blocks = append(blocks, bakeBlock{typ: typ, labels: []string{name}, attrs: attrs})
+		}
+		delete(doc, typ)
+	}
+	for typ := range doc {
+		return nil, errors.Errorf("%s blocks are not supported", typ)
+	}
+	return blocks, nil
+}
+
+func newBakeTarget(attrs map[string]interface{}) (*BakeTarget, error) {
+	t := &BakeTarget{}
+	var err error
+	if t.Context, err = stringAttr(attrs, "context"); err != nil {
+		return nil, err
+	}
+	if t.Dockerfile, err = stringAttr(attrs, "dockerfile"); err != nil {
+		return nil, err
+	}
+	if t.Target, err = stringAttr(attrs, "target"); err != nil {
+		return nil, err
+	}
+	if t.Args, err = mapAttr(attrs, "args"); err != nil {
+		return nil, err
+	}
+	if t.Labels, err = mapAttr(attrs, "labels"); err != nil {
+		return nil, err
+	}
+	if t.Tags, err = listAttr(attrs, "tags"); err != nil {
+		return nil, err
+	}
+	if t.Platforms, err = listAttr(attrs, "platforms"); err != nil {
+		return nil, err
+	}
+	if t.CacheFrom, err = listAttr(attrs, "cache-from"); err != nil {
+		return nil, err
+	}
+	if t.Inherits, err = listAttr(attrs, "inherits"); err != nil {
+		return nil, err
+	}
+	for name := range attrs {
+		if !bakeTargetAttrs[name] {
+			t.Unsupported = append(t.Unsupported, name)
+		}
+	}
+	sort.Strings(t.Unsupported)
+	return t, nil
+}
+
+func scalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64, bool:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+func stringAttr(attrs map[string]interface{}, name string) (string, error) {
+	v, ok := attrs[name]
+	if !ok || v == nil {
+		return "", nil
+	}
+	s, ok := scalar(v)
+	if !ok {
+		return "", errors.Errorf("%s must be a string", name)
+	}
+	return s, nil
+}
+
+func listAttr(attrs map[string]interface{}, name string) ([]string, error) {
+	v, ok := attrs[name]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s must be a list", name)
+	}
+	ret := make([]string, 0, len(items))
+	for _, item := range items {
+		s, ok := scalar(item)
+		if !ok {
+			return nil, errors.Errorf("%s must be a list of strings", name)
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+// mapAttr returns the map attribute, without its null values, which bake
+// takes from the environment instead.
+func mapAttr(attrs map[string]interface{}, name string) (map[string]string, error) {
+	v, ok := attrs[name]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	items, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s must be a map", name)
+	}
+	ret := make(map[string]string)
+	for k, item := range items {
+		if item == nil {
+			continue
+		}
+		s, ok := scalar(item)
+		if !ok {
+			return nil, errors.Errorf("%s must be a map of strings", name)
+		}
+		ret[k] = s
+	}
+	return ret, nil
+}
+
+// resolve returns the target with the attributes of the targets it inherits
+// from merged in, those of later targets taking precedence.
+func (bf *BakeFile) resolve(name string, visiting map[string]bool) (*BakeTarget, error) {
+	t, ok := bf.Targets[name]
+	if !ok {
+		return nil, errors.Errorf("target %q not found", name)
+	}
+	if visiting[name] {
+		return nil, errors.Errorf("target %q inherits from itself", name)
+	}
+	visiting[name] = true
+	defer delete(visiting, name)
+	merged := &BakeTarget{Args: make(map[string]string), Labels: make(map[string]string)}
+	for _, parentName := range t.Inherits {
+		parent, err := bf.resolve(parentName, visiting)
+		if err != nil {
+			return nil, err
+		}
+		merged.merge(parent)
+	}
+	merged.merge(t)
+	merged.Inherits = nil
+	return merged, nil
+}
+
+func (t *BakeTarget) merge(other *BakeTarget) {
+	if other.Context != "" {
+		t.Context = other.Context
+	}
+	if other.Dockerfile != "" {
+		t.Dockerfile = other.Dockerfile
+	}
+	if other.Target != "" {
+		t.Target = other.Target
+	}
+	for k, v := range other.Args {
+		t.Args[k] = v
+	}
+	for k, v := range other.Labels {
+		t.Labels[k] = v
+	}
+	if other.Tags != nil {
+		t.Tags = other.Tags
+	}
+	if other.Platforms != nil {
+		t.Platforms = other.Platforms
+	}
+	if other.CacheFrom != nil {
+		t.CacheFrom = other.CacheFrom
+	}
+	for _, attr := range other.Unsupported {
+		if !containsString(t.Unsupported, attr) {
+			t.Unsupported = append(t.Unsupported, attr)
+		}
+	}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidTargetNameChars matches the characters which Earthfile target names
+// cannot contain.
+var invalidTargetNameChars = regexp.MustCompile(`[^a-zA-Z0-9.-]`)
+
+// earthlyTargetName returns the Earthfile target name of a bake target or
+// group.
+func earthlyTargetName(name string) string {
+	name = invalidTargetNameChars.ReplaceAllString(name, "-")
+	if name == "" || name[0] < 'a' || name[0] > 'z' {
+		name = "t-" + name
+	}
+	return name
+}
+
+// WriteEarthfile writes the Earthfile equivalent to the bake file. Each bake
+// target becomes a target building its Dockerfile via FROM DOCKERFILE, and each
+// group a target building its targets. Variables become global ARGs.
+func (bf *BakeFile) WriteEarthfile(w io.Writer, source string) error {
+	var ew errWriter
+	ew.w = w
+	ew.printf("# This Earthfile was generated from %s using bake2earthly\n", source)
+	ew.printf("# the conversion is done on a best-effort basis\n")
+	ew.printf("# and might not follow best practices, please\n")
+	ew.printf("# visit http://docs.earthly.dev for Earthfile guides\n")
+	for _, name := range bf.Order {
+		if v, ok := bf.Variables[name]; ok {
+			ew.printf("ARG %s=%s\n", name, quoteArg(v))
+		}
+	}
+
+	names := make(map[string]string)
+	for _, name := range bf.Order {
+		if _, ok := bf.Variables[name]; ok {
+			continue
+		}
+		earthlyName := earthlyTargetName(name)
+		for other, otherEarthlyName := range names {
+			if otherEarthlyName == earthlyName {
+				return errors.Errorf("%q and %q both convert to target +%s", other, name, earthlyName)
+			}
+		}
+		names[name] = earthlyName
+	}
+	resolved := make(map[string]*BakeTarget)
+	for name := range bf.Targets {
+		t, err := bf.resolve(name, make(map[string]bool))
+		if err != nil {
+			return err
+		}
+		resolved[name] = t
+		if len(t.Platforms) > 0 {
+			imageName := names[name] + "-image"
+			for other, otherEarthlyName := range names {
+				if otherEarthlyName == imageName {
+					return errors.Errorf("%q conflicts with the per-platform target of %q", other, name)
+				}
+			}
+		}
+	}
+
+	for _, name := range bf.Order {
+		if targets, ok := bf.Groups[name]; ok {
+			ew.printf("\n%s:\n", names[name])
+			for _, target := range targets {
+				if _, ok := names[target]; !ok {
+					return errors.Errorf("group %q: %q not found", name, target)
+				}
+				ew.printf("    BUILD +%s\n", names[target])
+			}
+			continue
+		}
+		t, ok := resolved[name]
+		if !ok {
+			continue
+		}
+		imageTarget := names[name]
+		if len(t.Platforms) > 0 {
+			// A target only builds a single platform, so it is built for all
+			// of them by another target.
+			imageTarget += "-image"
+			ew.printf("\n%s:\n    BUILD", names[name])
+			for _, p := range t.Platforms {
+				ew.printf(" --platform=%s", p)
+			}
+			ew.printf(" +%s\n", imageTarget)
+		}
+		ew.printf("\n%s:\n", imageTarget)
+		for _, attr := range t.Unsupported {
+			ew.printf("    # The bake attribute %q is not supported and was ignored.\n", attr)
+		}
+		err := writeFromDockerfile(&ew, t)
+		if err != nil {
+			return errors.Wrapf(err, "target %q", name)
+		}
+		for _, k := range sortedKeys(t.Labels) {
+			ew.printf("    LABEL %s=%s\n", quoteArg(k), quoteArg(t.Labels[k]))
+		}
+		var cacheFrom []string
+		for _, c := range t.CacheFrom {
+			ref := strings.TrimPrefix(c, "type=registry,ref=")
+			if strings.Contains(ref, "=") {
+				ew.printf("    # The cache source %q is not supported and was ignored.\n", c)
+				continue
+			}
+			cacheFrom = append(cacheFrom, ref)
+		}
+		if len(t.Tags) > 0 || len(cacheFrom) > 0 {
+			ew.printf("    SAVE IMAGE")
+			if len(t.Tags) > 0 {
+				ew.printf(" --push")
+			}
+			for _, ref := range cacheFrom {
+				ew.printf(" --cache-from=%s", quoteArg(ref))
+			}
+			for _, tag := range t.Tags {
+				ew.printf(" %s", quoteArg(tag))
+			}
+			ew.printf("\n")
+		}
+	}
+	return ew.err
+}
+
+func writeFromDockerfile(ew *errWriter, t *BakeTarget) error {
+	context := t.Context
+	if context == "" {
+		context = "."
+	}
+	if strings.Contains(context, "://") || strings.HasPrefix(context, "git@") || strings.Contains(context, ":") {
+		return errors.Errorf("context %q is not a local directory, which is not supported", context)
+	}
+	ew.printf("    FROM DOCKERFILE")
+	if t.Dockerfile != "" && t.Dockerfile != "Dockerfile" {
+		ew.printf(" -f %s", quoteArg(localPath(path.Join(context, t.Dockerfile))))
+	}
+	if t.Target != "" {
+		ew.printf(" --target %s", quoteArg(t.Target))
+	}
+	for _, k := range sortedKeys(t.Args) {
+		ew.printf(" --build-arg %s", quoteArg(k+"="+t.Args[k]))
+	}
+	ew.printf(" %s\n", quoteArg(localPath(context)))
+	return nil
+}
+
+// localPath returns the path, prefixed with ./ if it is relative, so that
+// Earthly does not mistake it for a reference.
+func localPath(p string) string {
+	p = path.Clean(p)
+	if p == "." || path.IsAbs(p) || strings.HasPrefix(p, "../") || p == ".." {
+		return p
+	}
+	return "./" + p
+}
+
+// quoteArg quotes s as a single Earthfile argument, if needed. Backslashes
+// are kept as is, as they escape the literal "${" of bake.
+func quoteArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// errWriter is a writer which keeps the first error, so that it is checked
+// once.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
+
+// Bake2Earthly converts a docker-bake file into an Earthfile. An error is
+// returned if the Earthfile already exists.
+func Bake2Earthly(bakePath, earthfilePath string) error {
+	if earthfilePath != "-" && fileutil.FileExists(earthfilePath) {
+		return errors.Errorf("earthfile already exists; please delete it if you wish to continue")
+	}
+	dt, err := ioutil.ReadFile(bakePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %q", bakePath)
+	}
+	bf, err := ParseBake(bakePath, dt)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer
+	if earthfilePath == "-" {
+		out2 := bufio.NewWriter(os.Stdout)
+		defer out2.Flush()
+		out = out2
+	} else {
+		out2, err := os.Create(earthfilePath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create Earthfile under %q", earthfilePath)
+		}
+		defer out2.Close()
+		out = out2
+	}
+	return bf.WriteEarthfile(out, filepath.Base(bakePath))
+}
@@ -0,0 +1,115 @@
+package docker2earthly
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+const testBakeHCL = `# Build the app and its docs.
+variable "TAG" {
+  default = "latest"
+}
+
+group "default" {
+  targets = ["app", "docs"]
+}
+
+target "_common" {
+  args = {
+    GO_VERSION = "1.16"
+    PROXY = null
+  }
+  output = ["type=docker"]
+}
+
+target "app" {
+  inherits = ["_common"]
+  context = "app"
+  dockerfile = "build/Dockerfile"
+  target = "release"
+  tags = ["docker.io/org/app:${TAG}", "docker.io/org/app:$${literal}"]
+  platforms = ["linux/amd64", "linux/arm64"]
+  labels = {
+    "org.opencontainers.image.title" = "my app"
+  }
+  cache-from = ["type=registry,ref=docker.io/org/app:cache", "type=local,src=/tmp/cache"]
+}
+
+/* The docs are
+   single platform. */
+target "docs" {
+  tags = ["docker.io/org/docs:${TAG}"]
+}
+`
+
+func TestBake2Earthly(t *testing.T) {
+	bf, err := ParseBake("docker-bake.hcl", []byte(testBakeHCL))
+	NoError(t, err)
+	Equal(t, []string{"TAG", "default", "_common", "app", "docs"}, bf.Order)
+	Equal(t, map[string]string{"GO_VERSION": "1.16"}, bf.Targets["_common"].Args)
+
+	var buf bytes.Buffer
+	err = bf.WriteEarthfile(&buf, "docker-bake.hcl")
+	NoError(t, err)
+	Equal(t, `# This Earthfile was generated from docker-bake.hcl using bake2earthly
+# the conversion is done on a best-effort basis
+# and might not follow best practices, please
+# visit http://docs.earthly.dev for Earthfile guides
+ARG TAG=latest
+
+default:
+    BUILD +app
+    BUILD +docs
+
+t--common:
+    # The bake attribute "output" is not supported and was ignored.
+    FROM DOCKERFILE --build-arg GO_VERSION=1.16 .
+
+app:
+    BUILD --platform=linux/amd64 --platform=linux/arm64 +app-image
+
+app-image:
+    # The bake attribute "output" is not supported and was ignored.
+    FROM DOCKERFILE -f ./app/build/Dockerfile --target release --build-arg GO_VERSION=1.16 ./app
+    LABEL org.opencontainers.image.title="my app"
+    # The cache source "type=local,src=/tmp/cache" is not supported and was ignored.
+    SAVE IMAGE --push --cache-from=docker.io/org/app:cache docker.io/org/app:${TAG} docker.io/org/app:\${literal}
+
+docs:
+    FROM DOCKERFILE .
+    SAVE IMAGE --push docker.io/org/docs:${TAG}
+`, buf.String())
+}
+
+func TestParseBakeJSON(t *testing.T) {
+	bf, err := ParseBake("docker-bake.json", []byte(`{
+  "group": {"default": {"targets": ["app"]}},
+  "target": {"app": {"context": ".", "args": {"N": 1}}}
+}`))
+	NoError(t, err)
+	Equal(t, []string{"default", "app"}, bf.Order)
+	Equal(t, []string{"app"}, bf.Groups["default"])
+	Equal(t, map[string]string{"N": "1"}, bf.Targets["app"].Args)
+}
+
+func TestParseBakeErrors(t *testing.T) {
+	tests := []string{
+		`target "app" { tags = [join(",", TAGS)] }`,
+		`target "app" { tags = ["${upper(TAG)}"] }`,
+		`function "f" {}`,
+		`target "app" { context = "." `,
+		`target "app" {}
+target "app" {}`,
+	}
+	for _, tt := range tests {
+		_, err := ParseBake("docker-bake.hcl", []byte(tt))
+		Error(t, err, tt)
+	}
+	bf, err := ParseBake("docker-bake.hcl", []byte(`target "a" { inherits = ["b"] }
+target "b" { inherits = ["a"] }`))
+	NoError(t, err)
+	var buf bytes.Buffer
+	Error(t, bf.WriteEarthfile(&buf, "docker-bake.hcl"))
+}
@@ -0,0 +1,326 @@
+package docker2earthly
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// The HCL format of docker-bake files is parsed by hand, as only its subset
+// used by bake is needed: blocks with labels, attributes, and literal values
+// (strings, numbers, bools, lists and maps). Interpolations are limited to
+// references to variables, as in "${TAG}", which Earthly expands the same way.
+// Functions and other expressions are not supported.
+
+type bakeTokenKind int
+
+const (
+	bakeTokenEOF bakeTokenKind = iota
+	bakeTokenNewline
+	bakeTokenIdent
+	bakeTokenString
+	bakeTokenNumber
+	bakeTokenPunct
+)
+
+type bakeToken struct {
+	kind bakeTokenKind
+	text string
+	line int
+}
+
+// bakeBlock is a block of a bake file, as in target "app" { ... }.
+type bakeBlock struct {
+	typ    string
+	labels []string
+	attrs  map[string]interface{}
+	line   int
+}
+
+// interpolationRegexp matches the interpolations which are supported.
+var interpolationRegexp = regexp.MustCompile(`^\$\{[A-Za-z_][A-Za-z0-9_]*\}$`)
+
+func lexBakeHCL(src string) ([]bakeToken, error) {
+	var toks []bakeToken
+	line := 1
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case r == '\n':
+			toks = append(toks, bakeToken{kind: bakeTokenNewline, line: line})
+			line++
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '#' || (r == '/' && i+1 < len(rs) && rs[i+1] == '/'):
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			j := i + 2
+			for j+1 < len(rs) && !(rs[j] == '*' && rs[j+1] == '/') {
+				if rs[j] == '\n' {
+					line++
+				}
+				j++
+			}
+			if j+1 >= len(rs) {
+				return nil, errors.Errorf("line %d: unterminated comment", line)
+			}
+			i = j + 2
+		case strings.ContainsRune("={}[],:", r):
+			toks = append(toks, bakeToken{kind: bakeTokenPunct, text: string(r), line: line})
+			i++
+		case r == '"':
+			s, n, err := lexBakeString(rs[i:], line)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, bakeToken{kind: bakeTokenString, text: s, line: line})
+			i += n
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_' || rs[j] == '-') {
+				j++
+			}
+			toks = append(toks, bakeToken{kind: bakeTokenIdent, text: string(rs[i:j]), line: line})
+			i = j
+		case unicode.IsDigit(r) || r == '-':
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			toks = append(toks, bakeToken{kind: bakeTokenNumber, text: string(rs[i:j]), line: line})
+			i = j
+		case r == '<' && i+1 < len(rs) && rs[i+1] == '<':
+			return nil, errors.Errorf("line %d: heredoc strings are not supported", line)
+		default:
+			return nil, errors.Errorf("line %d: unexpected %q (functions and expressions are not supported)", line, r)
+		}
+	}
+	toks = append(toks, bakeToken{kind: bakeTokenEOF, line: line})
+	return toks, nil
+}
+
+// lexBakeString lexes the quoted string at the start of rs, and returns its
+// value, with interpolations kept as is, and its length.
+func lexBakeString(rs []rune, line int) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(rs); i++ {
+		switch rs[i] {
+		case '"':
+			return sb.String(), i + 1, nil
+		case '\n':
+			return "", 0, errors.Errorf("line %d: unterminated string", line)
+		case '\\':
+			if i+1 == len(rs) {
+				return "", 0, errors.Errorf("line %d: unterminated string", line)
+			}
+			i++
+			switch rs[i] {
+			case 'n':
+				sb.WriteRune('\n')
+			case 't':
+				sb.WriteRune('\t')
+			default:
+				sb.WriteRune(rs[i])
+			}
+		case '$':
+			if i+2 < len(rs) && rs[i+1] == '$' && rs[i+2] == '{' {
+				// An escaped "${", which is literal.
+				sb.WriteString(`\${`)
+				i += 2
+				continue
+			}
+			if i+1 < len(rs) && rs[i+1] == '{' {
+				j := i + 2
+				for j < len(rs) && rs[j] != '}' && rs[j] != '\n' {
+					j++
+				}
+				if j == len(rs) || rs[j] != '}' {
+					return "", 0, errors.Errorf("line %d: unterminated interpolation", line)
+				}
+				interp := string(rs[i : j+1])
+				if !interpolationRegexp.MatchString(interp) {
+					return "", 0, errors.Errorf("line %d: unsupported interpolation %s: only references to variables are supported", line, interp)
+				}
+				sb.WriteString(interp)
+				i = j
+				continue
+			}
+			sb.WriteRune('$')
+		default:
+			sb.WriteRune(rs[i])
+		}
+	}
+	return "", 0, errors.Errorf("line %d: unterminated string", line)
+}
+
+type bakeParser struct {
+	toks []bakeToken
+	pos  int
+}
+
+func parseBakeHCL(src string) ([]bakeBlock, error) {
+	toks, err := lexBakeHCL(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &bakeParser{toks: toks}
+	var blocks []bakeBlock
+	for {
+		p.skipNewlines()
+		tok := p.next()
+		if tok.kind == bakeTokenEOF {
+			return blocks, nil
+		}
+		if tok.kind != bakeTokenIdent {
+			return nil, p.unexpected(tok)
+		}
+		if p.peekPunct("=") {
+			return nil, errors.Errorf("line %d: top-level attribute %s is not supported", tok.line, tok.text)
+		}
+		block := bakeBlock{typ: tok.text, attrs: make(map[string]interface{}), line: tok.line}
+		for p.peek().kind == bakeTokenString {
+			block.labels = append(block.labels, p.next().text)
+		}
+		if tok := p.next(); tok.kind != bakeTokenPunct || tok.text != "{" {
+			return nil, p.unexpected(tok)
+		}
+		err := p.blockBody(&block)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+}
+
+func (p *bakeParser) blockBody(block *bakeBlock) error {
+	for {
+		p.skipNewlines()
+		tok := p.next()
+		if tok.text == "}" && tok.kind == bakeTokenPunct {
+			return nil
+		}
+		if tok.kind != bakeTokenIdent {
+			return p.unexpected(tok)
+		}
+		if !p.peekPunct("=") {
+			return errors.Errorf("line %d: nested block %s is not supported", tok.line, tok.text)
+		}
+		p.next()
+		value, err := p.expr()
+		if err != nil {
+			return err
+		}
+		block.attrs[tok.text] = value
+	}
+}
+
+func (p *bakeParser) expr() (interface{}, error) {
+	p.skipNewlines()
+	tok := p.next()
+	switch tok.kind {
+	case bakeTokenString, bakeTokenNumber:
+		return tok.text, nil
+	case bakeTokenIdent:
+		switch tok.text {
+		case "true", "false":
+			return tok.text, nil
+		case "null":
+			return nil, nil
+		}
+		// A reference to a variable.
+		return "${" + tok.text + "}", nil
+	case bakeTokenPunct:
+		switch tok.text {
+		case "[":
+			return p.list()
+		case "{":
+			return p.object()
+		}
+	}
+	return nil, p.unexpected(tok)
+}
+
+func (p *bakeParser) list() ([]interface{}, error) {
+	var values []interface{}
+	for {
+		p.skipNewlines()
+		if p.peekPunct("]") {
+			p.next()
+			return values, nil
+		}
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		p.skipNewlines()
+		if p.peekPunct(",") {
+			p.next()
+		}
+	}
+}
+
+func (p *bakeParser) object() (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for {
+		p.skipNewlines()
+		tok := p.next()
+		if tok.text == "}" && tok.kind == bakeTokenPunct {
+			return values, nil
+		}
+		if tok.kind != bakeTokenIdent && tok.kind != bakeTokenString {
+			return nil, p.unexpected(tok)
+		}
+		if sep := p.next(); sep.kind != bakeTokenPunct || (sep.text != "=" && sep.text != ":") {
+			return nil, p.unexpected(sep)
+		}
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		values[tok.text] = value
+		if p.peekPunct(",") {
+			p.next()
+		}
+	}
+}
+
+func (p *bakeParser) peek() bakeToken {
+	return p.toks[p.pos]
+}
+
+func (p *bakeParser) peekPunct(text string) bool {
+	tok := p.peek()
+	return tok.kind == bakeTokenPunct && tok.text == text
+}
+
+func (p *bakeParser) next() bakeToken {
+	tok := p.toks[p.pos]
+	if tok.kind != bakeTokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *bakeParser) skipNewlines() {
+	for p.peek().kind == bakeTokenNewline {
+		p.pos++
+	}
+}
+
+func (p *bakeParser) unexpected(tok bakeToken) error {
+	switch tok.kind {
+	case bakeTokenEOF:
+		return errors.Errorf("line %d: unexpected end of file", tok.line)
+	case bakeTokenNewline:
+		return errors.Errorf("line %d: unexpected newline", tok.line)
+	default:
+		return errors.Errorf("line %d: unexpected %q", tok.line, tok.text)
+	}
+}
@@ -0,0 +1,30 @@
+package earthfile2llb
+
+import (
+	"fmt"
+
+	"github.com/earthly/earthly/privileged"
+)
+
+// approvePrivileged requires the run to be approved if it runs privileged,
+// or mounts paths of the host of buildkitd.
+func (c *Converter) approvePrivileged(opts ConvertRunOpts, commandStr string) error {
+	var reasons []string
+	if (opts.Privileged && !opts.privilegedImplied) || opts.Security.requiresPrivileged() || opts.GPUs != "" {
+		reasons = append(reasons, "privileged mode")
+	}
+	for _, source := range hostMountSources(opts.Mounts) {
+		reasons = append(reasons, fmt.Sprintf("a host mount of %s", source))
+	}
+	for _, reason := range reasons {
+		err := c.opt.PrivilegedApprover.Approve(privileged.Request{
+			Target:  c.mts.Final.Target.StringCanonical(),
+			Command: commandStr,
+			Reason:  reason,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/privileged"
+	"github.com/earthly/earthly/states"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApprovePrivileged(t *testing.T) {
+	c := &Converter{
+		opt: ConvertOpt{PrivilegedApprover: privileged.NewApprover(nil, nil)},
+		mts: &states.MultiTarget{Final: &states.SingleTarget{Target: domain.Target{LocalPath: ".", Target: "test"}}},
+	}
+	// WITH DOCKER runs privileged for the sake of dockerd only.
+	assert.NoError(t, c.approvePrivileged(ConvertRunOpts{Privileged: true, privilegedImplied: true}, "WITH DOCKER RUN go test"))
+	err := c.approvePrivileged(ConvertRunOpts{Privileged: true}, "WITH DOCKER RUN --privileged go test")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "requires privileged mode")
+}
@@ -0,0 +1,49 @@
+package earthfile2llb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleArgExpressionFlags(t *testing.T) {
+	ctx := context.Background()
+	local := &Interpreter{local: true}
+	err := local.handleArg(ctx, spec.Command{Name: "ARG", Args: []string{"--host", "--secret", "TOKEN=+secrets/token", "USER", "=", "$(whoami)"}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ARG --host cannot be combined with --secret")
+
+	nonLocal := &Interpreter{}
+	err = nonLocal.handleArg(ctx, spec.Command{Name: "ARG", Args: []string{"--host", "USER", "=", "$(whoami)"}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ARG --host can only be used in LOCALLY targets")
+
+	err = local.handleArg(ctx, spec.Command{Name: "ARG", Args: []string{"--expr", "--host", "IS_MAIN", "=", "\"$BRANCH\"", "==", "main"}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ARG --expr cannot be combined with --host or --secret")
+	err = nonLocal.handleArg(ctx, spec.Command{Name: "ARG", Args: []string{"--expr", "IS_MAIN"}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ARG --expr requires an expression as the default value")
+}
+
+func TestProcessNonConstantBuildArgFlags(t *testing.T) {
+	ctx := context.Background()
+	c := &Converter{locallyShell: "/bin/sh"}
+	_, _, err := c.processNonConstantBuildArgFunc(ctx, ExpressionOpts{Host: true, Secrets: []string{"TOKEN=+secrets/token"}})("USER", "whoami")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ARG --host cannot be combined with --secret")
+	_, _, err = c.processNonConstantBuildArgFunc(ctx, ExpressionOpts{})("USER", "whoami")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "declare it via ARG --host")
+	_, _, err = c.processNonConstantBuildArgFunc(ctx, ExpressionOpts{BuildArg: true})("USER", "whoami")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the value of --build-arg USER")
+	assert.Contains(t, err.Error(), "pass that arg on via --build-arg USER=$<arg>")
+
+	c = &Converter{}
+	_, _, err = c.processNonConstantBuildArgFunc(ctx, ExpressionOpts{Host: true})("USER", "whoami")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ARG --host can only be used in LOCALLY targets")
+}
@@ -0,0 +1,165 @@
+package earthfile2llb
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/earthly/earthly/ast"
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/variables"
+	"github.com/pkg/errors"
+)
+
+// ArgOverride is the value of an ARG of a specific target, set from the
+// command line as in --set +build.GOFLAGS=-race. Wherever the target is
+// invoked within the build, the value takes precedence over the build args
+// which it is invoked with.
+type ArgOverride struct {
+	Target domain.Target
+	Name   string
+	Value  string
+}
+
+// ParseArgOverride parses an arg override of the form
+// <target-ref>.<arg-name>=<value>.
+func ParseArgOverride(s string) (ArgOverride, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return ArgOverride{}, errors.Errorf("invalid --set %s: expected <target-ref>.<arg-name>=<value>", s)
+	}
+	ref := parts[0]
+	// Target names may contain dots, but arg names may not.
+	i := strings.LastIndex(ref, ".")
+	if i == -1 || i < strings.LastIndex(ref, "+") || i == len(ref)-1 {
+		return ArgOverride{}, errors.Errorf("invalid --set %s: expected <target-ref>.<arg-name>=<value>", s)
+	}
+	target, err := domain.ParseTarget(ref[:i])
+	if err != nil {
+		return ArgOverride{}, errors.Wrapf(err, "invalid --set %s", s)
+	}
+	if target.IsImportReference() {
+		return ArgOverride{}, errors.Errorf("invalid --set %s: import references are not supported", s)
+	}
+	return ArgOverride{Target: target, Name: ref[i+1:], Value: parts[1]}, nil
+}
+
+// String returns the override in the form it is parsed from.
+func (ao ArgOverride) String() string {
+	return ao.Target.String() + "." + ao.Name + "=" + ao.Value
+}
+
+// Matches returns whether the override addresses the given target.
+func (ao ArgOverride) Matches(target domain.Target) bool {
+	if ao.Target.Target != target.Target {
+		return false
+	}
+	if ao.Target.IsRemote() {
+		return ao.Target.GitURL == target.GitURL && (ao.Target.Tag == "" || ao.Target.Tag == target.Tag)
+	}
+	return !target.IsRemote() && path.Clean(ao.Target.LocalPath) == path.Clean(target.LocalPath)
+}
+
+// ValidateArgOverride checks that the local target addressed by the override
+// exists and declares the ARG. Overrides of remote targets are only checked
+// once the targets are converted.
+func ValidateArgOverride(ctx context.Context, ao ArgOverride) error {
+	if ao.Target.IsRemote() {
+		return nil
+	}
+	ef, err := ast.Parse(ctx, filepath.Join(ao.Target.LocalPath, "Earthfile"), false)
+	if err != nil {
+		return errors.Wrapf(err, "invalid --set %s", ao.String())
+	}
+	return checkArgOverride(ao, ef)
+}
+
+func checkArgOverride(ao ArgOverride, ef spec.Earthfile) error {
+	for _, t := range ef.Targets {
+		if t.Name == ao.Target.Target {
+			if blockDeclaresArg(ef.BaseRecipe, ao.Name) || blockDeclaresArg(t.Recipe, ao.Name) {
+				return nil
+			}
+			return errors.Errorf("invalid --set %s: %s does not declare ARG %s", ao.String(), ao.Target.String(), ao.Name)
+		}
+	}
+	return errors.Errorf("invalid --set %s: target %s not found", ao.String(), ao.Target.String())
+}
+
+// applyArgOverrides returns the overriding vars of the target, with the values
+// of the overrides which address it.
+func applyArgOverrides(overrides []ArgOverride, target domain.Target, ef spec.Earthfile, overridingVars *variables.Scope) (*variables.Scope, error) {
+	ret := overridingVars
+	for _, ao := range overrides {
+		if !ao.Matches(target) {
+			continue
+		}
+		err := checkArgOverride(ao, ef)
+		if err != nil {
+			return nil, err
+		}
+		if ret == overridingVars {
+			ret = variables.NewScope()
+			if overridingVars != nil {
+				ret = overridingVars.Clone()
+			}
+		}
+		ret.AddInactive(ao.Name, ao.Value)
+	}
+	return ret, nil
+}
+
+// blockDeclaresArg returns whether the block declares the ARG, including
+// within nested blocks.
+func blockDeclaresArg(block spec.Block, name string) bool {
+	for _, stmt := range block {
+		switch {
+		case stmt.Command != nil:
+			if commandDeclaresArg(*stmt.Command, name) {
+				return true
+			}
+		case stmt.With != nil:
+			if blockDeclaresArg(stmt.With.Body, name) {
+				return true
+			}
+		case stmt.If != nil:
+			if blockDeclaresArg(stmt.If.IfBody, name) {
+				return true
+			}
+			for _, elseIf := range stmt.If.ElseIf {
+				if blockDeclaresArg(elseIf.Body, name) {
+					return true
+				}
+			}
+			if stmt.If.ElseBody != nil && blockDeclaresArg(*stmt.If.ElseBody, name) {
+				return true
+			}
+		case stmt.For != nil:
+			if blockDeclaresArg(stmt.For.Body, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func commandDeclaresArg(cmd spec.Command, name string) bool {
+	if cmd.Name != "ARG" {
+		return false
+	}
+	for i := 0; i < len(cmd.Args); i++ {
+		arg := cmd.Args[i]
+		if arg == "--secret" {
+			// Skip the value of the flag.
+			i++
+			continue
+		}
+		if strings.HasPrefix(arg, "--") {
+			continue
+		}
+		return strings.SplitN(arg, "=", 2)[0] == name
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/variables"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseArgOverride(t *testing.T) {
+	ao, err := ParseArgOverride("+build.GOFLAGS=-race")
+	assert.NoError(t, err)
+	assert.Equal(t, "build", ao.Target.Target)
+	assert.Equal(t, "GOFLAGS", ao.Name)
+	assert.Equal(t, "-race", ao.Value)
+	assert.Equal(t, "+build.GOFLAGS=-race", ao.String())
+
+	ao, err = ParseArgOverride("./services/api+build.linux.TAGS=a=b")
+	assert.NoError(t, err)
+	assert.Equal(t, "build.linux", ao.Target.Target)
+	assert.Equal(t, "./services/api", ao.Target.LocalPath)
+	assert.Equal(t, "TAGS", ao.Name)
+	assert.Equal(t, "a=b", ao.Value)
+
+	for _, s := range []string{"+build", "+build.GOFLAGS", "+build.=x", "./a.b+build=x"} {
+		_, err = ParseArgOverride(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestArgOverrideMatches(t *testing.T) {
+	ao, err := ParseArgOverride("./services/api+build.GOFLAGS=-race")
+	assert.NoError(t, err)
+	assert.True(t, ao.Matches(domain.Target{LocalPath: "services/api", Target: "build"}))
+	assert.False(t, ao.Matches(domain.Target{LocalPath: ".", Target: "build"}))
+	assert.False(t, ao.Matches(domain.Target{LocalPath: "./services/api", Target: "test"}))
+
+	ao, err = ParseArgOverride("github.com/org/lib+build.GOFLAGS=-race")
+	assert.NoError(t, err)
+	assert.True(t, ao.Matches(domain.Target{GitURL: "github.com/org/lib", Tag: "main", Target: "build"}))
+	assert.False(t, ao.Matches(domain.Target{GitURL: "github.com/org/other", Target: "build"}))
+}
+
+func TestApplyArgOverrides(t *testing.T) {
+	ef := spec.Earthfile{
+		BaseRecipe: spec.Block{
+			{Command: &spec.Command{Name: "ARG", Args: []string{"VERSION=1.0"}}},
+		},
+		Targets: []spec.Target{{
+			Name: "build",
+			Recipe: spec.Block{
+				{If: &spec.IfStatement{IfBody: spec.Block{
+					{Command: &spec.Command{Name: "ARG", Args: []string{"GOFLAGS", "=", ""}}},
+				}}},
+			},
+		}},
+	}
+	target := domain.Target{LocalPath: ".", Target: "build"}
+	parse := func(s string) ArgOverride {
+		ao, err := ParseArgOverride(s)
+		assert.NoError(t, err)
+		return ao
+	}
+
+	vars := variables.NewScope()
+	vars.AddInactive("GOFLAGS", "-v")
+	ret, err := applyArgOverrides([]ArgOverride{parse("+build.GOFLAGS=-race"), parse("+build.VERSION=2.0"), parse("+test.GOFLAGS=-x")}, target, ef, vars)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"GOFLAGS": "-race", "VERSION": "2.0"}, ret.AllValueMap())
+	assert.Equal(t, map[string]string{"GOFLAGS": "-v"}, vars.AllValueMap())
+
+	_, err = applyArgOverrides([]ArgOverride{parse("+build.MISSING=x")}, target, ef, nil)
+	assert.Error(t, err)
+	assert.Nil(t, checkArgOverride(parse("+build.VERSION=2.0"), ef))
+	assert.Error(t, checkArgOverride(parse("+test.VERSION=2.0"), ef))
+}
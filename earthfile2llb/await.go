@@ -0,0 +1,60 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+)
+
+const (
+	// awaitPath is where the debugger is made available to the commands of
+	// WITH DOCKER as earthly-await, so that they can wait for the containers
+	// they start.
+	awaitPath = "/usr/bin/earthly-await"
+	// defaultAwaitTimeout is how long WITH DOCKER waits for ports and URLs,
+	// unless specified otherwise.
+	defaultAwaitTimeout = time.Minute
+)
+
+// validateAwait returns an error if the ports, as in localhost:5432, or the
+// URLs waited for via WITH DOCKER --wait-for-port and --wait-for-http are
+// invalid.
+func validateAwait(ports, urls []string) error {
+	for _, p := range ports {
+		_, port, err := net.SplitHostPort(p)
+		if err != nil || port == "" {
+			return errors.Errorf("invalid --wait-for-port %s: must be <host>:<port>", p)
+		}
+	}
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return errors.Errorf("invalid --wait-for-http %s: must be an http or https URL", u)
+		}
+		if strings.ContainsAny(u, " \t\"") {
+			return errors.Errorf("invalid --wait-for-http %s: must not contain spaces or quotes", u)
+		}
+	}
+	return nil
+}
+
+// awaitParams returns the parameters of the dockerd wrapper which make it
+// wait for the ports and URLs before running the command.
+func awaitParams(opt WithDockerOpt) []string {
+	return []string{
+		fmt.Sprintf("EARTHLY_AWAIT_PORTS=\"%s\"", strings.Join(opt.WaitForPorts, " ")),
+		fmt.Sprintf("EARTHLY_AWAIT_URLS=\"%s\"", strings.Join(opt.WaitForHTTP, " ")),
+		fmt.Sprintf("EARTHLY_AWAIT_TIMEOUT=\"%s\"", opt.WaitTimeout),
+	}
+}
+
+// awaitRunOpt makes the debugger available as earthly-await.
+func awaitRunOpt() llb.RunOption {
+	return pllb.AddMount(awaitPath, pllb.Scratch(), llb.HostBind(), llb.SourcePath(debuggerPath), llb.Readonly)
+}
@@ -0,0 +1,28 @@
+package earthfile2llb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAwait(t *testing.T) {
+	assert.NoError(t, validateAwait([]string{"localhost:5432", "db:3306"}, []string{"http://localhost:8080/health?ready=1"}))
+	assert.Error(t, validateAwait([]string{"5432"}, nil))
+	assert.Error(t, validateAwait([]string{"localhost:"}, nil))
+	assert.Error(t, validateAwait(nil, []string{"localhost:8080/health"}))
+	assert.Error(t, validateAwait(nil, []string{"ftp://localhost/file"}))
+}
+
+func TestAwaitParams(t *testing.T) {
+	assert.Equal(t, []string{
+		`EARTHLY_AWAIT_PORTS="localhost:5432 localhost:6379"`,
+		`EARTHLY_AWAIT_URLS="http://localhost:8080/health"`,
+		`EARTHLY_AWAIT_TIMEOUT="1m30s"`,
+	}, awaitParams(WithDockerOpt{
+		WaitForPorts: []string{"localhost:5432", "localhost:6379"},
+		WaitForHTTP:  []string{"http://localhost:8080/health"},
+		WaitTimeout:  90 * time.Second,
+	}))
+}
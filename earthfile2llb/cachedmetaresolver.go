@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/retryutil"
 	"github.com/earthly/earthly/util/syncutil/synccache"
 	"github.com/moby/buildkit/client/llb"
 	"github.com/opencontainers/go-digest"
@@ -25,14 +26,16 @@ type cachedMetaResolverEntry struct {
 type CachedMetaResolver struct {
 	metaResolver llb.ImageMetaResolver
 	cache        *synccache.SyncCache // cachedMetaResolverKey -> cachedMetaResolverEntry
+	retry        retryutil.Policy
 }
 
 // NewCachedMetaResolver creates a new cached meta resolver based on an underlying meta resolver
-// which needs to be provided.
-func NewCachedMetaResolver(metaResolver llb.ImageMetaResolver) *CachedMetaResolver {
+// which needs to be provided. Transient registry failures are retried according to the given policy.
+func NewCachedMetaResolver(metaResolver llb.ImageMetaResolver, retry retryutil.Policy) *CachedMetaResolver {
 	return &CachedMetaResolver{
 		metaResolver: metaResolver,
 		cache:        synccache.New(),
+		retry:        retry,
 	}
 }
 
@@ -43,7 +46,13 @@ func (cmr *CachedMetaResolver) ResolveImageConfig(ctx context.Context, ref strin
 		platform: llbutil.PlatformToString(opt.Platform),
 	}
 	value, err := cmr.cache.Do(ctx, key, func(ctx context.Context, _ interface{}) (interface{}, error) {
-		dgst, config, err := cmr.metaResolver.ResolveImageConfig(ctx, ref, opt)
+		var dgst digest.Digest
+		var config []byte
+		err := cmr.retry.Do(ctx, retryutil.IsTransientRegistryError, func(ctx context.Context) error {
+			var err error
+			dgst, config, err = cmr.metaResolver.ResolveImageConfig(ctx, ref, opt)
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
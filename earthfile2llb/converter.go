@@ -15,10 +15,15 @@ import (
 	"strings"
 	"time"
 
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/audit"
 	"github.com/earthly/earthly/buildcontext"
+	"github.com/earthly/earthly/capabilities"
 	"github.com/earthly/earthly/debugger/common"
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/features"
+	"github.com/earthly/earthly/imagebudget"
+	"github.com/earthly/earthly/imageverify"
 	"github.com/earthly/earthly/states"
 	"github.com/earthly/earthly/states/dedup"
 	"github.com/earthly/earthly/states/image"
@@ -26,6 +31,7 @@ import (
 	"github.com/earthly/earthly/util/llbutil"
 	"github.com/earthly/earthly/util/llbutil/llbfactory"
 	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/stringutil"
 	"github.com/earthly/earthly/variables"
 
 	"github.com/alessio/shellescape"
@@ -61,6 +67,7 @@ const (
 	runCmd                               // "RUN"
 	saveArtifactCmd                      // "SAVE ARTIFACT"
 	saveImageCmd                         // "SAVE IMAGE"
+	shellCmd                             // "SHELL"
 	userCmd                              // "USER"
 	volumeCmd                            // "VOLUME"
 	workdirCmd                           // "WORKDIR"
@@ -78,6 +85,22 @@ type Converter struct {
 	ranSave             bool
 	cmdSet              bool
 	ftrs                *features.Features
+	locallyShell        string
+	// runner is the runner which the LOCALLY target executes on, as per
+	// LOCALLY --runner, if any, and runnerDir the directory of the target on
+	// the runner.
+	runner     *Runner
+	runnerName string
+	runnerDir  string
+	// runnerKeychain is whether a keychain was imported into the directory
+	// of the target on the runner, as per LOCALLY --keychain.
+	runnerKeychain bool
+	// nonRootUser is the user of the target, as per USER --non-root, which
+	// the target may not switch from to root.
+	nonRootUser string
+	// streamMounts are the mounts of the artifacts copied via COPY --stream,
+	// which are added to every RUN command, until the next FROM.
+	streamMounts []llb.RunOption
 }
 
 // NewConverter constructs a new converter for a given earthly target.
@@ -86,13 +109,16 @@ func NewConverter(ctx context.Context, target domain.Target, bc *buildcontext.Da
 		sts.LocalDirs[k] = v
 	}
 	sts.HasDangling = opt.HasDangling
+	if target.IsRemote() && bc.GitMetadata != nil {
+		sts.GitCommit = bc.GitMetadata.Hash
+	}
 	mts := &states.MultiTarget{
 		Final:   sts,
 		Visited: opt.Visited,
 	}
 	sts.AddOverridingVarsAsBuildArgInputs(opt.OverridingVars)
 	vc := variables.NewCollection(opt.Console,
-		target, llbutil.PlatformWithDefault(opt.Platform), bc.GitMetadata, opt.OverridingVars,
+		target, llbutil.PlatformWithDefault(opt.Platform), bc.GitMetadata, ftrs, opt.OverridingVars,
 		opt.GlobalImports)
 	return &Converter{
 		gitMeta:             bc.GitMetadata,
@@ -106,7 +132,7 @@ func NewConverter(ctx context.Context, target domain.Target, bc *buildcontext.Da
 }
 
 // From applies the earthly FROM command.
-func (c *Converter) From(ctx context.Context, imageName string, platform *specs.Platform, allowPrivileged bool, buildArgs []string) error {
+func (c *Converter) From(ctx context.Context, imageName string, platform *specs.Platform, allowPrivileged bool, buildArgs []string, verify *imageverify.Policy) error {
 	err := c.checkAllowed(fromCmd)
 	if err != nil {
 		return err
@@ -117,9 +143,16 @@ func (c *Converter) From(ctx context.Context, imageName string, platform *specs.
 	if err != nil {
 		return err
 	}
+	err = c.checkWindowsBackend(platform)
+	if err != nil {
+		return err
+	}
 	c.setPlatform(platform)
 	if strings.Contains(imageName, "+") {
 		// Target-based FROM.
+		if verify != nil {
+			return errors.New("--verify is only supported when FROM an image")
+		}
 		return c.fromTarget(ctx, imageName, platform, allowPrivileged, buildArgs)
 	}
 
@@ -127,10 +160,31 @@ func (c *Converter) From(ctx context.Context, imageName string, platform *specs.
 	if len(buildArgs) != 0 {
 		return errors.New("--build-arg not supported in non-target FROM")
 	}
-	return c.fromClassical(ctx, imageName, platform, false)
+	if verify != nil {
+		policy, err := c.resolveVerifyKey(*verify)
+		if err != nil {
+			return err
+		}
+		verify = &policy
+	}
+	return c.fromClassical(ctx, imageName, platform, false, verify)
+}
+
+// resolveVerifyKey makes the key path of the policy relative to the Earthfile.
+// Remote Earthfiles may only reference keys by URI (e.g. a KMS), as their
+// files are not available on the host.
+func (c *Converter) resolveVerifyKey(policy imageverify.Policy) (imageverify.Policy, error) {
+	if policy.Key == "" || strings.Contains(policy.Key, "://") || filepath.IsAbs(policy.Key) {
+		return policy, nil
+	}
+	if c.mts.Final.Target.IsRemote() {
+		return imageverify.Policy{}, errors.Errorf("FROM --verify key %s of remote target %s must be a URI", policy.Key, c.mts.Final.Target.String())
+	}
+	policy.Key = filepath.Join(filepath.FromSlash(c.mts.Final.Target.LocalPath), policy.Key)
+	return policy, nil
 }
 
-func (c *Converter) fromClassical(ctx context.Context, imageName string, platform *specs.Platform, local bool) error {
+func (c *Converter) fromClassical(ctx context.Context, imageName string, platform *specs.Platform, local bool, verify *imageverify.Policy) error {
 	var prefix string
 	if local {
 		// local mode uses a fake image containing /bin/true
@@ -141,7 +195,7 @@ func (c *Converter) fromClassical(ctx context.Context, imageName string, platfor
 	}
 	plat := llbutil.PlatformWithDefault(platform)
 	state, img, envVars, err := c.internalFromClassical(
-		ctx, imageName, plat,
+		ctx, imageName, plat, verify,
 		llb.WithCustomNamef("%sFROM %s", prefix, imageName))
 	if err != nil {
 		return err
@@ -149,7 +203,12 @@ func (c *Converter) fromClassical(ctx context.Context, imageName string, platfor
 	c.mts.Final.MainState = state
 	c.mts.Final.MainImage = img
 	c.mts.Final.RanFromLike = true
+	c.mts.Final.Layers = nil
+	c.streamMounts = nil
 	c.varCollection.ResetEnvVars(envVars)
+	if !local {
+		c.applyUserPolicy(true)
+	}
 	return nil
 }
 
@@ -173,6 +232,8 @@ func (c *Converter) fromTarget(ctx context.Context, targetName string, platform
 	saveImage := relevantDepState.LastSaveImage()
 	// Pass on dep state over to this state.
 	c.mts.Final.MainState = relevantDepState.MainState
+	c.mts.Final.Layers = append([]states.Layer(nil), relevantDepState.Layers...)
+	c.streamMounts = nil
 	for dirKey, dirValue := range relevantDepState.LocalDirs {
 		c.mts.Final.LocalDirs[dirKey] = dirValue
 	}
@@ -180,7 +241,9 @@ func (c *Converter) fromTarget(ctx context.Context, targetName string, platform
 	c.mts.Final.MainImage = saveImage.Image.Clone()
 	c.mts.Final.RanFromLike = mts.Final.RanFromLike
 	c.mts.Final.RanInteractive = mts.Final.RanInteractive
+	c.mts.Final.RunShell = mts.Final.RunShell
 	c.setPlatform(mts.Final.Platform)
+	c.applyUserPolicy(false)
 	return nil
 }
 
@@ -293,7 +356,7 @@ func (c *Converter) FromDockerfile(ctx context.Context, contextPath string, dfPa
 		BuildContextFactory = data.BuildContextFactory
 	}
 	overriding, err := variables.ParseArgs(
-		buildArgs, c.processNonConstantBuildArgFunc(ctx), c.varCollection)
+		buildArgs, c.processNonConstantBuildArgFunc(ctx, ExpressionOpts{BuildArg: true}), c.varCollection)
 	if err != nil {
 		return err
 	}
@@ -328,12 +391,15 @@ func (c *Converter) FromDockerfile(ctx context.Context, contextPath string, dfPa
 	c.mts.Final.MainState = state2
 	c.mts.Final.MainImage = img2
 	c.mts.Final.RanFromLike = true
+	c.mts.Final.Layers = nil
+	c.streamMounts = nil
 	c.varCollection.ResetEnvVars(envVars)
+	c.applyUserPolicy(true)
 	return nil
 }
 
 // Locally applies the earthly Locally command.
-func (c *Converter) Locally(ctx context.Context, workdirPath string, platform *specs.Platform) error {
+func (c *Converter) Locally(ctx context.Context, workdirPath string, platform *specs.Platform, declared []capabilities.Capability, shell string, runner string, keychain, keychainPassword string) error {
 	err := c.checkAllowed(locallyCmd)
 	if err != nil {
 		return err
@@ -341,11 +407,51 @@ func (c *Converter) Locally(ctx context.Context, workdirPath string, platform *s
 	if !c.opt.AllowLocally {
 		return errors.New("LOCALLY cannot be used when --strict is specified or otherwise implied")
 	}
+	err = c.checkExecution("LOCALLY")
+	if err != nil {
+		return err
+	}
 	if !path.IsAbs(workdirPath) {
 		return errors.New("workdirPath must be absolute")
 	}
+	err = c.opt.LocallyGrants.Check(c.mts.Final.Target.StringCanonical(), declared)
+	if err != nil {
+		return err
+	}
+	c.locallyShell, err = parseLocallyShell(shell)
+	if err != nil {
+		return err
+	}
+	if runner == "" && (keychain != "" || keychainPassword != "") {
+		return errors.New("LOCALLY --keychain requires --runner")
+	}
+	if keychain == "" && keychainPassword != "" {
+		return errors.New("LOCALLY --keychain-password requires --keychain")
+	}
+	if runner != "" {
+		if shell != "" && shell != locallyShellSh {
+			return errors.Errorf("LOCALLY --runner does not support --shell=%s", shell)
+		}
+		c.locallyShell = locallyShellSh
+		c.runner, err = c.lookupRunner(runner)
+		if err != nil {
+			return err
+		}
+		c.runnerName = runner
+		c.runnerDir = c.runner.targetDir(c.mts.Final.Target)
+		// The inputs and outputs of the target are shuttled through a local
+		// directory of its own.
+		workdirPath, err = ioutil.TempDir("", "earthly-runner")
+		if err != nil {
+			return errors.Wrap(err, "create runner dir")
+		}
+		stagingDir := workdirPath
+		c.opt.CleanCollection.Add(func() error {
+			return os.RemoveAll(stagingDir)
+		})
+	}
 
-	err = c.fromClassical(ctx, "scratch", platform, true)
+	err = c.fromClassical(ctx, "scratch", platform, true, nil)
 	if err != nil {
 		return err
 	}
@@ -353,6 +459,9 @@ func (c *Converter) Locally(ctx context.Context, workdirPath string, platform *s
 	// reset WORKDIR to current directory where Earthfile is
 	c.mts.Final.MainState = c.mts.Final.MainState.Dir(workdirPath)
 	c.mts.Final.MainImage.Config.WorkingDir = workdirPath
+	if keychain != "" {
+		return c.importRunnerKeychain(keychain, keychainPassword)
+	}
 	return nil
 }
 
@@ -377,6 +486,13 @@ func (c *Converter) CopyArtifactLocal(ctx context.Context, artifactName string,
 	// Grab the artifacts state in the dep states, after we've built it.
 	relevantDepState := mts.Final
 
+	var runnerDest string
+	if c.runner != nil {
+		runnerDest, err = runnerRelPath(dest)
+		if err != nil {
+			return err
+		}
+	}
 	finalArgs := []string{localhost.SendFileMagicStr}
 	if isDir {
 		finalArgs = append(finalArgs, "--dir")
@@ -396,6 +512,9 @@ func (c *Converter) CopyArtifactLocal(ctx context.Context, artifactName string,
 			dest),
 	}
 	c.mts.Final.MainState = c.mts.Final.MainState.Run(opts...).Root()
+	if c.runner != nil {
+		c.pushToRunner(runnerDest)
+	}
 	err = c.forceExecution(ctx, c.mts.Final.MainState)
 	if err != nil {
 		return err
@@ -440,7 +559,7 @@ func (c *Converter) CopyArtifact(ctx context.Context, artifactName string, dest
 }
 
 // CopyClassical applies the earthly COPY command, with classical args.
-func (c *Converter) CopyClassical(ctx context.Context, srcs []string, dest string, isDir bool, keepTs bool, keepOwn bool, chown string) error {
+func (c *Converter) CopyClassical(ctx context.Context, srcs []string, dest string, isDir bool, keepTs bool, keepOwn bool, chown string, ifExists bool) error {
 	err := c.checkAllowed(copyCmd)
 	if err != nil {
 		return err
@@ -459,11 +578,12 @@ func (c *Converter) CopyClassical(ctx context.Context, srcs []string, dest strin
 	c.mts.Final.MainState = llbutil.CopyOp(
 		srcState,
 		srcs,
-		c.mts.Final.MainState, dest, true, isDir, keepTs, c.copyOwner(keepOwn, chown), false, false,
+		c.mts.Final.MainState, dest, true, isDir, keepTs, c.copyOwner(keepOwn, chown), ifExists, false,
 		llb.WithCustomNamef(
-			"%sCOPY %s%s %s",
+			"%sCOPY %s%s%s %s",
 			c.vertexPrefix(false, false),
 			strIf(isDir, "--dir "),
+			strIf(ifExists, "--if-exists "),
 			strings.Join(srcs, " "),
 			dest))
 	return nil
@@ -478,6 +598,7 @@ type ConvertRunOpts struct {
 	Secrets         []string
 	WithEntrypoint  bool
 	WithShell       bool
+	Shell           []string // RUN --shell; the shell of the target if empty
 	Privileged      bool
 	Push            bool
 	Transient       bool
@@ -485,11 +606,24 @@ type ConvertRunOpts struct {
 	NoCache         bool
 	Interactive     bool
 	InteractiveKeep bool
+	ProcessMemory   int64
+	Timeout         time.Duration
+	Retries         int
+	RetryDelay      time.Duration
+	DNS             DNSConfig
+	Security        RunSecurity
+	GPUs            string
+	Services        []Service
+	Tests           common.RerunSpec
 
 	// Internal.
 	shellWrap    shellWrapFun
 	extraRunOpts []llb.RunOption
 	statePrep    func(context.Context, pllb.State) (pllb.State, error)
+	// privilegedImplied is set when the command runs privileged for the sake
+	// of earthly itself, such as for the dockerd of WITH DOCKER, rather than
+	// because it asks to.
+	privilegedImplied bool
 }
 
 // Run applies the earthly RUN command.
@@ -500,6 +634,9 @@ func (c *Converter) Run(ctx context.Context, opts ConvertRunOpts) error {
 	}
 	c.nonSaveCommand()
 
+	if opts.Timeout == 0 {
+		opts.Timeout = c.opt.RunTimeout
+	}
 	_, err = c.internalRun(ctx, opts)
 	return err
 }
@@ -510,6 +647,14 @@ func (c *Converter) RunExitCode(ctx context.Context, opts ConvertRunOpts) (int,
 	if err != nil {
 		return 0, err
 	}
+	err = c.checkExecution("the exit code of a command")
+	if err != nil {
+		return 0, err
+	}
+	err = c.checkRunnerResult(opts)
+	if err != nil {
+		return 0, err
+	}
 	c.nonSaveCommand()
 
 	var exitCodeFile string
@@ -571,6 +716,14 @@ func (c *Converter) RunExpression(ctx context.Context, expressionName string, op
 	if err != nil {
 		return "", err
 	}
+	err = c.checkExecution("the output of a command")
+	if err != nil {
+		return "", err
+	}
+	err = c.checkRunnerResult(opts)
+	if err != nil {
+		return "", err
+	}
 	c.nonSaveCommand()
 
 	var outputFile string
@@ -720,6 +873,13 @@ func (c *Converter) SaveArtifactFromLocal(ctx context.Context, saveFrom, saveTo
 	if err != nil {
 		return err
 	}
+	if c.runner != nil {
+		saveFrom, err = runnerRelPath(saveFrom)
+		if err != nil {
+			return err
+		}
+		c.pullFromRunner(saveFrom)
+	}
 	src, err := filepath.Abs(saveFrom)
 	if err != nil {
 		return err
@@ -760,7 +920,7 @@ func (c *Converter) SaveArtifactFromLocal(ctx context.Context, saveFrom, saveTo
 }
 
 // SaveImage applies the earthly SAVE IMAGE command.
-func (c *Converter) SaveImage(ctx context.Context, imageNames []string, pushImages bool, insecurePush bool, cacheHint bool, cacheFrom []string) error {
+func (c *Converter) SaveImage(ctx context.Context, imageNames []string, pushImages bool, insecurePush bool, cacheHint bool, cacheFrom []string, budget imagebudget.Budget) error {
 	err := c.checkAllowed(saveImageCmd)
 	if err != nil {
 		return err
@@ -773,32 +933,55 @@ func (c *Converter) SaveImage(ctx context.Context, imageNames []string, pushImag
 		imageNames = []string{""}
 		justCacheHint = true
 	}
+	registryTags := make(map[string]string) // registry -> first tag pushed to it
 	for _, imageName := range imageNames {
+		imageName, err = c.expandImageName(imageName)
+		if err != nil {
+			return err
+		}
+		var fanOutFrom string
+		if pushImages && !insecurePush && imageName != "" {
+			fanOutFrom = fanOutSource(registryTags, imageName)
+		}
+		img := c.mts.Final.MainImage.Clone()
+		if img.Config.Labels == nil {
+			img.Config.Labels = make(map[string]string)
+		}
+		for k, v := range ociLabels(c.gitMeta, img.Config.Labels) {
+			img.Config.Labels[k] = v
+		}
+		img.Created = c.opt.SourceDateEpoch
 		if c.mts.Final.RunPush.HasState {
 			// SAVE IMAGE --push when it comes before any RUN --push should be treated as if they are in the main state,
 			// since thats their only dependency. It will still be marked as a push.
 			c.mts.Final.RunPush.SaveImages = append(c.mts.Final.RunPush.SaveImages,
 				states.SaveImage{
 					State:               c.mts.Final.RunPush.State,
-					Image:               c.mts.Final.MainImage.Clone(), // We can get away with this because no Image details can vary in a --push. This should be fixed before then.
+					Image:               img, // We can get away with this because no Image details can vary in a --push. This should be fixed before then.
 					DockerTag:           imageName,
 					Push:                pushImages,
 					InsecurePush:        insecurePush,
 					CacheHint:           cacheHint,
 					HasPushDependencies: true,
 					DoSave:              c.opt.DoSaves || c.opt.ForceSaveImage,
+					FanOutFrom:          fanOutFrom,
+					Layers:              c.mts.Final.Layers,
+					Budget:              budget,
 				})
 		} else {
 			c.mts.Final.SaveImages = append(c.mts.Final.SaveImages,
 				states.SaveImage{
 					State:               c.mts.Final.MainState,
-					Image:               c.mts.Final.MainImage.Clone(),
+					Image:               img,
 					DockerTag:           imageName,
 					Push:                pushImages,
 					InsecurePush:        insecurePush,
 					CacheHint:           cacheHint,
 					HasPushDependencies: false,
 					DoSave:              c.opt.DoSaves || c.opt.ForceSaveImage,
+					FanOutFrom:          fanOutFrom,
+					Layers:              c.mts.Final.Layers,
+					Budget:              budget,
 				})
 		}
 
@@ -814,25 +997,79 @@ func (c *Converter) SaveImage(ctx context.Context, imageNames []string, pushImag
 	return nil
 }
 
+// fanOutSource returns the tag which the image is copied from when pushed, if
+// another tag of the same SAVE IMAGE is pushed to the same registry. Otherwise,
+// it records the tag as the one pushed to its registry.
+func fanOutSource(registryTags map[string]string, imageName string) string {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return ""
+	}
+	registry := reference.Domain(named)
+	if src, ok := registryTags[registry]; ok {
+		return src
+	}
+	registryTags[registry] = imageName
+	return ""
+}
+
 // Build applies the earthly BUILD command.
-func (c *Converter) Build(ctx context.Context, fullTargetName string, platform *specs.Platform, allowPrivileged bool, buildArgs []string) error {
+func (c *Converter) Build(ctx context.Context, fullTargetName string, platform *specs.Platform, allowPrivileged, nativeBuild bool, timeout time.Duration, buildArgs []string, importArgs []string) error {
 	err := c.checkAllowed(buildCmd)
 	if err != nil {
 		return err
 	}
 	c.nonSaveCommand()
-	_, err = c.buildTarget(ctx, fullTargetName, platform, allowPrivileged, buildArgs, true, buildCmd)
-	return err
+	target, opt, propagateBuildArgs, err := c.prepBuildTarget(ctx, fullTargetName, platform, allowPrivileged, buildArgs, true, buildCmd)
+	if err != nil {
+		return err
+	}
+	opt.NativeBuild = nativeBuild
+	opt.RunTimeout = timeout
+	mts, err := c.buildPreparedTarget(ctx, fullTargetName, target, opt, propagateBuildArgs, buildCmd)
+	if err != nil {
+		return err
+	}
+	return c.importArgs(fullTargetName, mts, importArgs)
+}
+
+// importArgs declares the given args in the current target, with the values
+// which they have at the end of the referenced target. Both the args and the
+// env vars of the referenced target may be imported. As with any other ARG,
+// an overriding value takes precedence over the imported one.
+func (c *Converter) importArgs(fullTargetName string, mts *states.MultiTarget, names []string) error {
+	for _, name := range names {
+		if _, found := c.varCollection.GetActive(name); found {
+			return errors.Errorf("cannot import arg %s from %s: it is already declared in the current target", name, fullTargetName)
+		}
+		value, found := mts.Final.VarCollection.GetActive(name)
+		if !found {
+			return errors.Errorf("cannot import arg %s: it is not declared in %s", name, fullTargetName)
+		}
+		// The value has already been computed, and is never evaluated again.
+		effective, err := c.varCollection.DeclareArg(name, value, false, nil)
+		if err != nil {
+			return err
+		}
+		c.mts.Final.AddBuildArgInput(dedup.BuildArgInput{
+			Name:          name,
+			DefaultValue:  value,
+			ConstantValue: effective,
+		})
+	}
+	return nil
 }
 
 // BuildAsync applies the earthly BUILD command asynchronously.
-func (c *Converter) BuildAsync(ctx context.Context, fullTargetName string, platform *specs.Platform, allowPrivileged bool, buildArgs []string, cmdT cmdType) chan error {
+func (c *Converter) BuildAsync(ctx context.Context, fullTargetName string, platform *specs.Platform, allowPrivileged, nativeBuild bool, timeout time.Duration, buildArgs []string, cmdT cmdType) chan error {
 	errChan := make(chan error, 1)
 	target, opt, _, err := c.prepBuildTarget(ctx, fullTargetName, platform, allowPrivileged, buildArgs, true, cmdT)
 	if err != nil {
 		errChan <- err
 		return errChan
 	}
+	opt.NativeBuild = nativeBuild
+	opt.RunTimeout = timeout
 	go func() {
 		err := c.opt.Parallelism.Acquire(ctx, 1)
 		if err != nil {
@@ -881,12 +1118,21 @@ func (c *Converter) Workdir(ctx context.Context, workdirPath string) error {
 }
 
 // User applies the USER command.
-func (c *Converter) User(ctx context.Context, user string) error {
+func (c *Converter) User(ctx context.Context, user string, nonRoot bool) error {
 	err := c.checkAllowed(userCmd)
 	if err != nil {
 		return err
 	}
 	c.nonSaveCommand()
+	if nonRoot {
+		user, err = c.nonRootUserOf(user)
+		if err != nil {
+			return err
+		}
+		c.nonRootUser = user
+	} else if c.nonRootUser != "" && isRootUser(user) {
+		return errors.Errorf("cannot switch to user %s, as the target runs as non-root user %s (USER --non-root)", user, c.nonRootUser)
+	}
 	c.mts.Final.MainState = c.mts.Final.MainState.User(user)
 	c.mts.Final.MainImage.Config.User = user
 	return nil
@@ -959,13 +1205,13 @@ func (c *Converter) Env(ctx context.Context, envKey string, envValue string) err
 }
 
 // Arg applies the ARG command.
-func (c *Converter) Arg(ctx context.Context, argKey string, defaultArgValue string, global bool) error {
+func (c *Converter) Arg(ctx context.Context, argKey string, defaultArgValue string, global bool, exprOpts ExpressionOpts) error {
 	err := c.checkAllowed(argCmd)
 	if err != nil {
 		return err
 	}
 	c.nonSaveCommand()
-	effective, err := c.varCollection.DeclareArg(argKey, defaultArgValue, global, c.processNonConstantBuildArgFunc((ctx)))
+	effective, err := c.varCollection.DeclareArg(argKey, defaultArgValue, global, c.processNonConstantBuildArgFunc(ctx, exprOpts))
 	if err != nil {
 		return err
 	}
@@ -1013,24 +1259,36 @@ func (c *Converter) Label(ctx context.Context, labels map[string]string) error {
 }
 
 // GitClone applies the GIT CLONE command.
-func (c *Converter) GitClone(ctx context.Context, gitURL string, branch string, dest string, keepTs bool) error {
+func (c *Converter) GitClone(ctx context.Context, gitURL string, auth buildcontext.CloneAuth, opts ConvertGitCloneOpts) error {
 	err := c.checkAllowed(gitCloneCmd)
 	if err != nil {
 		return err
 	}
+	if c.opt.Offline {
+		return errors.Errorf("GIT CLONE %s is not possible in --offline mode", stringutil.ScrubCredentials(gitURL))
+	}
 	c.nonSaveCommand()
-	gitOpts := []llb.GitOption{
-		llb.WithCustomNamef(
-			"%sGIT CLONE (--branch %s) %s", c.vertexPrefixWithURL(gitURL), branch, gitURL),
-		llb.KeepGitDir(),
+	var gitState pllb.State
+	if opts.scripted() {
+		gitState, err = c.scriptedGitClone(ctx, gitURL, auth, opts)
+		if err != nil {
+			return err
+		}
+	} else {
+		gitOpts := []llb.GitOption{
+			llb.WithCustomNamef(
+				"%sGIT CLONE (--branch %s) %s", c.vertexPrefixWithURL(gitURL), opts.Branch, stringutil.ScrubCredentials(gitURL)),
+			llb.KeepGitDir(),
+		}
+		gitOpts = append(gitOpts, auth.GitOptions()...)
+		gitState = pllb.Git(gitURL, opts.Branch, gitOpts...)
 	}
-	gitState := pllb.Git(gitURL, branch, gitOpts...)
 	c.mts.Final.MainState = llbutil.CopyOp(
-		gitState, []string{"."}, c.mts.Final.MainState, dest, false, false, keepTs,
+		gitState, []string{"."}, c.mts.Final.MainState, opts.Dest, false, false, opts.KeepTs,
 		c.mts.Final.MainImage.Config.User, false, false,
 		llb.WithCustomNamef(
 			"%sCOPY GIT CLONE (--branch %s) %s TO %s", c.vertexPrefix(false, false),
-			branch, gitURL, dest))
+			opts.Branch, stringutil.ScrubCredentials(gitURL), opts.Dest))
 	return nil
 }
 
@@ -1040,6 +1298,9 @@ func (c *Converter) WithDockerRun(ctx context.Context, args []string, opt WithDo
 	if err != nil {
 		return err
 	}
+	if llbutil.IsWindows(c.mts.Final.Platform) {
+		return errors.New("WITH DOCKER is not supported when building Windows images")
+	}
 	c.nonSaveCommand()
 	wdr := &withDockerRun{
 		c: c,
@@ -1053,6 +1314,9 @@ func (c *Converter) WithDockerRunLocal(ctx context.Context, args []string, opt W
 	if err != nil {
 		return err
 	}
+	if c.runner != nil {
+		return errors.New("WITH DOCKER is not supported in LOCALLY --runner targets")
+	}
 	c.nonSaveCommand()
 	wdrl := &withDockerRunLocal{
 		c: c,
@@ -1117,7 +1381,7 @@ func (c *Converter) EnterScopeDo(ctx context.Context, command domain.Command, ba
 	}
 
 	overriding, err := variables.ParseArgs(
-		buildArgs, c.processNonConstantBuildArgFunc(ctx), c.varCollection)
+		buildArgs, c.processNonConstantBuildArgFunc(ctx, ExpressionOpts{BuildArg: true}), c.varCollection)
 	if err != nil {
 		return err
 	}
@@ -1138,6 +1402,11 @@ func (c *Converter) StackString() string {
 	return c.varCollection.StackString()
 }
 
+// DeclaredArgs returns the names of the args declared in the current target.
+func (c *Converter) DeclaredArgs() []string {
+	return c.varCollection.SortedDeclaredArgs()
+}
+
 // FinalizeStates returns the LLB states.
 func (c *Converter) FinalizeStates(ctx context.Context) (*states.MultiTarget, error) {
 	c.markFakeDeps()
@@ -1158,6 +1427,15 @@ func (c *Converter) ExpandArgs(word string) string {
 	return c.varCollection.Expand(word)
 }
 
+// CheckExpansion returns an error if the word references undefined ARGs or
+// ENVs, when strict expansion is enabled via VERSION --strict-expansion.
+func (c *Converter) CheckExpansion(word string) error {
+	if !c.ftrs.StrictExpansion {
+		return nil
+	}
+	return c.varCollection.CheckDefined(word)
+}
+
 func (c *Converter) prepBuildTarget(ctx context.Context, fullTargetName string, platform *specs.Platform, allowPrivileged bool, buildArgs []string, isDangling bool, cmdT cmdType) (domain.Target, ConvertOpt, bool, error) {
 	relTarget, err := domain.ParseTarget(fullTargetName)
 	if err != nil {
@@ -1176,7 +1454,7 @@ func (c *Converter) prepBuildTarget(ctx context.Context, fullTargetName string,
 	}
 	target := targetRef.(domain.Target)
 
-	overriding, err := variables.ParseArgs(buildArgs, c.processNonConstantBuildArgFunc(ctx), c.varCollection)
+	overriding, err := variables.ParseArgs(buildArgs, c.processNonConstantBuildArgFunc(ctx, ExpressionOpts{BuildArg: true}), c.varCollection)
 	if err != nil {
 		return domain.Target{}, ConvertOpt{}, false, errors.Wrap(err, "parse build args")
 	}
@@ -1205,6 +1483,18 @@ func (c *Converter) prepBuildTarget(ctx context.Context, fullTargetName string,
 		// Contradiction allowed. You can BUILD another target with different platform.
 		opt.Platform = platform
 	}
+	opt.NativeBuild = false
+	opt.RunTimeout = 0
+	if platform != nil {
+		// An explicit platform always wins over cross-compilation.
+		opt.CrossPlatform = nil
+	}
+	if c.opt.NativeBuild && cmdT == copyCmd && platform == nil {
+		// The artifact is only a build input of the final image: build it on the
+		// native platform and let the toolchains cross-compile for ours.
+		opt.CrossPlatform = opt.Platform
+		opt.Platform = c.nativePlatform()
+	}
 	return target, opt, propagateBuildArgs, nil
 }
 
@@ -1213,6 +1503,10 @@ func (c *Converter) buildTarget(ctx context.Context, fullTargetName string, plat
 	if err != nil {
 		return nil, err
 	}
+	return c.buildPreparedTarget(ctx, fullTargetName, target, opt, propagateBuildArgs, cmdT)
+}
+
+func (c *Converter) buildPreparedTarget(ctx context.Context, fullTargetName string, target domain.Target, opt ConvertOpt, propagateBuildArgs bool, cmdT cmdType) (*states.MultiTarget, error) {
 	mts, err := Earthfile2LLB(ctx, target, opt, false)
 	if err != nil {
 		return nil, errors.Wrapf(err, "earthfile2llb for %s", fullTargetName)
@@ -1264,6 +1558,15 @@ func (c *Converter) buildTarget(ctx context.Context, fullTargetName string, plat
 
 func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.State, error) {
 	isInteractive := (opts.Interactive || opts.InteractiveKeep)
+	if isInteractive && opts.Timeout != 0 {
+		return pllb.State{}, errors.New("--timeout not supported in interactive mode")
+	}
+	if isInteractive && opts.Retries != 0 {
+		return pllb.State{}, errors.New("--retries not supported in interactive mode")
+	}
+	if isInteractive && len(opts.Tests.Reports) != 0 {
+		return pllb.State{}, errors.New("--junit not supported in interactive mode")
+	}
 	if !c.opt.AllowInteractive && isInteractive {
 		return pllb.State{}, errors.New("interactive options are not allowed, when --strict is specified or otherwise implied")
 	}
@@ -1286,9 +1589,46 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 		if opts.Push {
 			return pllb.State{}, errors.New("--push not supported with LOCALLY")
 		}
+		if opts.ProcessMemory != 0 {
+			return pllb.State{}, errors.New("--process-memory not supported with LOCALLY")
+		}
+		if !opts.DNS.Empty() {
+			return pllb.State{}, errors.New("--dns, --dns-search and --add-host not supported with LOCALLY")
+		}
+		if !opts.Security.Empty() {
+			return pllb.State{}, errors.New("--cap-add, --cap-drop and --security-opt not supported with LOCALLY")
+		}
+		if opts.GPUs != "" {
+			return pllb.State{}, errors.New("--gpus not supported with LOCALLY")
+		}
+		if len(opts.Services) != 0 {
+			return pllb.State{}, errors.New("--service not supported with LOCALLY")
+		}
+		if len(opts.Tests.Reports) != 0 {
+			return pllb.State{}, errors.New("--junit not supported with LOCALLY")
+		}
+		if (opts.Timeout != 0 || opts.Retries != 0) && c.locallyShell != locallyShellSh {
+			return pllb.State{}, errors.Errorf("--timeout and --retries not supported with LOCALLY --shell=%s", c.locallyShell)
+		}
 		if opts.Transient {
 			return pllb.State{}, errors.New("Transient run not supported with LOCALLY")
 		}
+		if len(opts.Shell) != 0 {
+			return pllb.State{}, errors.New("--shell not supported with LOCALLY; use LOCALLY --shell instead")
+		}
+		if c.locallyShell != locallyShellSh && opts.shellWrap != nil {
+			return pllb.State{}, errors.Errorf("%s not supported with LOCALLY --shell=%s", opts.CommandName, c.locallyShell)
+		}
+	}
+	isWindows := !opts.Locally && llbutil.IsWindows(c.mts.Final.Platform)
+	if isWindows {
+		err := checkWindowsRunOpts(opts)
+		if err != nil {
+			return pllb.State{}, err
+		}
+		if len(c.mts.Final.RunShell) != 0 {
+			return pllb.State{}, errors.New("SHELL is not supported when building Windows images")
+		}
 	}
 	if opts.shellWrap == nil {
 		opts.shellWrap = withShellAndEnvVars
@@ -1306,24 +1646,41 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 	}
 
 	runOpts := opts.extraRunOpts[:]
-	if opts.Privileged {
+	if opts.Privileged || opts.Security.requiresPrivileged() || opts.GPUs != "" {
+		// The devices of the GPUs are only available to privileged commands.
 		runOpts = append(runOpts, llb.Security(llb.SecurityModeInsecure))
 	}
-	mountRunOpts, err := parseMounts(opts.Mounts, c.mts.Final.Target, c.targetInputActiveOnly(), c.cacheContext)
+	mountRunOpts, err := parseMounts(opts.Mounts, c.mts.Final.Target, c.targetInputActiveOnly(), c.cacheContext, c.opt.CacheNamespace)
 	if err != nil {
 		return pllb.State{}, errors.Wrap(err, "parse mounts")
 	}
 	runOpts = append(runOpts, mountRunOpts...)
+	runOpts = append(runOpts, c.streamMounts...)
+	var serviceSpecs []common.ServiceSpec
+	if len(opts.Services) != 0 {
+		var serviceRunOpts []llb.RunOption
+		serviceSpecs, serviceRunOpts, err = c.services(ctx, opts.Services)
+		if err != nil {
+			return pllb.State{}, err
+		}
+		runOpts = append(runOpts, serviceRunOpts...)
+	}
 	commandStr := fmt.Sprintf(
-		"%s %s%s%s%s%s%s",
+		"%s %s%s%s%s%s%s%s%s",
 		opts.CommandName, // e.g. "RUN", "IF", "FOR", "ARG"
 		strIf(opts.Privileged, "--privileged "),
+		strIf(opts.Timeout != 0, fmt.Sprintf("--timeout=%s ", opts.Timeout)),
+		strIf(opts.Retries != 0, fmt.Sprintf("--retries=%d ", opts.Retries)),
 		strIf(opts.Push, "--push "),
 		strIf(opts.NoCache, "--no-cache "),
 		strIf(opts.Interactive, "--interactive "),
 		strIf(opts.InteractiveKeep, "--interactive-keep "),
 		strings.Join(opts.Args, " "))
 	runOpts = append(runOpts, llb.WithCustomNamef("%s%s", c.vertexPrefix(opts.Locally, isInteractive), commandStr))
+	err = c.approvePrivileged(opts, commandStr)
+	if err != nil {
+		return pllb.State{}, err
+	}
 
 	var extraEnvVars []string
 	// Secrets.
@@ -1353,12 +1710,41 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 			return pllb.State{}, errors.Errorf("secret definition %s not supported. Must start with +secrets/ or be an empty string", secretKeyValue)
 		}
 	}
+	if c.opt.CrossPlatform != nil && !opts.Locally {
+		for _, kv := range crossCompileEnv(*c.opt.CrossPlatform) {
+			extraEnvVars = append(extraEnvVars, fmt.Sprintf("%s=%s", kv[0], shellescape.Quote(kv[1])))
+		}
+	}
+	if !opts.Locally {
+		for _, kv := range determinismEnv(c.opt.SourceDateEpoch, c.opt.RandomSeed) {
+			extraEnvVars = append(extraEnvVars, fmt.Sprintf("%s=%s", kv[0], shellescape.Quote(kv[1])))
+		}
+		if c.opt.Hostname != "" {
+			runOpts = append(runOpts, llb.Hostname(c.opt.Hostname))
+		}
+		dns := c.opt.DNS.merge(opts.DNS)
+		if isWindows {
+			// Windows containers do not read /etc/resolv.conf.
+			dns.Nameservers, dns.SearchDomains = nil, nil
+		}
+		dnsRunOpts, err := dns.runOpts()
+		if err != nil {
+			return pllb.State{}, err
+		}
+		runOpts = append(runOpts, dnsRunOpts...)
+		if opts.GPUs != "" {
+			runOpts = append(runOpts, gpuRunOpt())
+			extraEnvVars = append(extraEnvVars, gpuEnv(opts.GPUs)...)
+		}
+	}
 	// Build args.
+	var rawEnvVars [][2]string
 	for _, buildArgName := range c.varCollection.SortedActiveVariables() {
 		ba, _ := c.varCollection.GetActive(buildArgName)
 		extraEnvVars = append(extraEnvVars, fmt.Sprintf("%s=%s", buildArgName, shellescape.Quote(ba)))
+		rawEnvVars = append(rawEnvVars, [2]string{buildArgName, ba})
 	}
-	if !opts.Locally {
+	if !opts.Locally && !isWindows {
 		// Debugger.
 		secretOpts := []llb.SecretOption{
 			llb.SecretID(common.DebuggerSettingsSecretsKey),
@@ -1374,13 +1760,62 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 		}
 	}
 	// Shell and debugger wrap.
-	prependDebugger := !opts.Locally
-	finalArgs = opts.shellWrap(finalArgs, extraEnvVars, opts.WithShell, prependDebugger, isInteractive)
-	if opts.Locally {
-		// buildkit-hack in order to run locally, we prepend the command with a magic UUID.
-		finalArgs = append(
-			[]string{localhost.RunOnLocalHostMagicStr},
-			finalArgs...)
+	if opts.Locally && c.locallyShell != locallyShellSh {
+		finalArgs, err = c.locallyScriptArgs(c.locallyShell, finalArgs, rawEnvVars, opts.WithShell)
+		if err != nil {
+			return pllb.State{}, err
+		}
+	} else if isWindows {
+		// cmd.exe expands variables when a line is parsed, so build args are
+		// passed via the environment rather than via set.
+		for _, kv := range rawEnvVars {
+			runOpts = append(runOpts, llb.AddEnv(kv[0], kv[1]))
+		}
+		finalArgs = withWindowsShell(finalArgs, opts.WithShell)
+	} else {
+		prependDebugger := !opts.Locally
+		var shell []string
+		if opts.WithShell {
+			shell = c.runShell(opts)
+		}
+		finalArgs = opts.shellWrap(finalArgs, extraEnvVars, shell, prependDebugger, isInteractive)
+		if opts.ProcessMemory != 0 {
+			// Buildkit does not expose cgroup memory limits, so the memory of
+			// each of the command's processes is limited instead.
+			finalArgs = withProcessMemoryLimit(finalArgs, opts.ProcessMemory)
+		}
+		if opts.Timeout != 0 {
+			finalArgs = withTimeout(finalArgs, opts.Timeout)
+		}
+		if opts.Retries != 0 {
+			finalArgs = withRetries(finalArgs, opts.Retries, opts.RetryDelay)
+		}
+		if len(opts.Tests.Reports) != 0 {
+			finalArgs, err = withReruns(finalArgs, opts.Tests)
+			if err != nil {
+				return pllb.State{}, err
+			}
+		}
+		if !opts.Security.Empty() {
+			finalArgs = withSandbox(finalArgs, opts.Security, opts.Privileged)
+		}
+		if len(serviceSpecs) != 0 {
+			// Outermost, so that the services outlive timeouts and retries,
+			// and are not subject to the sandbox of the command.
+			finalArgs, err = withServices(finalArgs, serviceSpecs)
+			if err != nil {
+				return pllb.State{}, err
+			}
+		}
+		if opts.Locally {
+			if c.runner != nil {
+				finalArgs = c.runnerArgs(finalArgs)
+			}
+			// buildkit-hack in order to run locally, we prepend the command with a magic UUID.
+			finalArgs = append(
+				[]string{localhost.RunOnLocalHostMagicStr},
+				finalArgs...)
+		}
 	}
 
 	runOpts = append(runOpts, llb.Args(finalArgs))
@@ -1452,6 +1887,15 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 		c.mts.Final.MainState = state.Run(runOpts...).Root()
 
 		if opts.Locally {
+			// Recorded before the command runs, such that commands which
+			// fail or are interrupted part way are recorded too.
+			if c.opt.OnSideEffect != nil {
+				c.opt.OnSideEffect()
+			}
+			err = c.opt.AuditLog.Record(audit.KindLocally, c.mts.Final.Target.StringCanonical(), commandStr)
+			if err != nil {
+				return pllb.State{}, err
+			}
 			err = c.forceExecution(ctx, c.mts.Final.MainState)
 			if err != nil {
 				return pllb.State{}, err
@@ -1463,6 +1907,10 @@ func (c *Converter) internalRun(ctx context.Context, opts ConvertRunOpts) (pllb.
 }
 
 func (c *Converter) forceExecution(ctx context.Context, state pllb.State) error {
+	err := c.checkExecution("executing the commands")
+	if err != nil {
+		return err
+	}
 	ref, err := llbutil.StateToRef(ctx, c.opt.GwClient, state, c.opt.Platform, c.opt.CacheImports.AsMap())
 	if err != nil {
 		return errors.Wrap(err, "run locally state to ref")
@@ -1476,7 +1924,20 @@ func (c *Converter) forceExecution(ctx context.Context, state pllb.State) error
 	return nil
 }
 
+// checkExecution fails if the conversion may not execute commands, as when
+// prefetching. what describes what the commands are executed for.
+func (c *Converter) checkExecution(what string) error {
+	if c.opt.NoExecution {
+		return errors.Wrapf(ErrExecutionRequired, "%s", what)
+	}
+	return nil
+}
+
 func (c *Converter) readArtifact(ctx context.Context, mts *states.MultiTarget, artifact domain.Artifact) ([]byte, error) {
+	err := c.checkExecution(fmt.Sprintf("reading %s", artifact.String()))
+	if err != nil {
+		return nil, err
+	}
 	if mts.Final.ArtifactsState.Output() == nil {
 		// ArtifactsState is scratch - no artifact has been copied.
 		return nil, errors.Errorf("artifact %s not found; no SAVE ARTIFACT command was issued in %s", artifact.String(), artifact.Target.String())
@@ -1494,8 +1955,11 @@ func (c *Converter) readArtifact(ctx context.Context, mts *states.MultiTarget, a
 	return artDt, nil
 }
 
-func (c *Converter) internalFromClassical(ctx context.Context, imageName string, platform specs.Platform, opts ...llb.ImageOption) (pllb.State, *image.Image, *variables.Scope, error) {
+func (c *Converter) internalFromClassical(ctx context.Context, imageName string, platform specs.Platform, verify *imageverify.Policy, opts ...llb.ImageOption) (pllb.State, *image.Image, *variables.Scope, error) {
 	if imageName == "scratch" {
+		if verify != nil {
+			return pllb.State{}, nil, nil, errors.New("FROM scratch cannot be verified")
+		}
 		// FROM scratch
 		img := image.NewImage()
 		img.OS = platform.OS
@@ -1518,6 +1982,10 @@ func (c *Converter) internalFromClassical(ctx context.Context, imageName string,
 			LogName:     logName,
 		})
 	if err != nil {
+		if looksLikeWindowsImage(imageName) && !llbutil.IsWindows(&platform) {
+			return pllb.State{}, nil, nil, errors.Wrapf(err,
+				"resolve image config for %s (this looks like a Windows image; did you forget FROM --platform=windows/amd64?)", imageName)
+		}
 		return pllb.State{}, nil, nil, errors.Wrapf(err, "resolve image config for %s", imageName)
 	}
 	var img image.Image
@@ -1531,6 +1999,16 @@ func (c *Converter) internalFromClassical(ctx context.Context, imageName string,
 			return pllb.State{}, nil, nil, errors.Wrapf(err, "reference add digest %v for %s", dgst, imageName)
 		}
 	}
+	if verify != nil {
+		if dgst == "" {
+			return pllb.State{}, nil, nil, errors.Errorf("cannot verify %s: its digest could not be resolved", imageName)
+		}
+		err = c.opt.ImageVerifier.Verify(ctx, ref.String(), *verify)
+		if err != nil {
+			return pllb.State{}, nil, nil, err
+		}
+		c.opt.Console.VerbosePrintf("Verified %s against policy %s\n", ref.String(), verify.String())
+	}
 	allOpts := append(opts, llb.Platform(platform), c.opt.ImageResolveMode)
 	state := pllb.Image(ref.String(), allOpts...)
 	state, img2, envVars := c.applyFromImage(state, &img)
@@ -1538,6 +2016,8 @@ func (c *Converter) internalFromClassical(ctx context.Context, imageName string,
 }
 
 func (c *Converter) applyFromImage(state pllb.State, img *image.Image) (pllb.State, *image.Image, *variables.Scope) {
+	// The creation time of the base image does not carry over.
+	img.Created = nil
 	// Reset variables.
 	ev := variables.ParseEnvVars(img.Config.Env)
 	for _, name := range ev.SortedActive() {
@@ -1566,18 +2046,76 @@ func (c *Converter) applyFromImage(state pllb.State, img *image.Image) (pllb.Sta
 	return state, img, ev
 }
 
+// recordLayer records the command as a layer of the target if it wrote to
+// the filesystem of the target, given the state before it.
+func (c *Converter) recordLayer(command string, sl *spec.SourceLocation, before pllb.State) {
+	after := c.mts.Final.MainState
+	if after.Output() == before.Output() {
+		return
+	}
+	var location string
+	if sl != nil {
+		location = fmt.Sprintf("%s line %d:%d", sl.File, sl.StartLine, sl.StartColumn)
+	}
+	c.mts.Final.Layers = append(c.mts.Final.Layers, states.Layer{
+		Command:        command,
+		SourceLocation: location,
+		Before:         before,
+		After:          after,
+	})
+}
+
 func (c *Converter) nonSaveCommand() {
 	if c.ranSave {
 		c.mts.Final.HasDangling = true
 	}
 }
 
-func (c *Converter) processNonConstantBuildArgFunc(ctx context.Context) variables.ProcessNonConstantVariableFunc {
+// ExpressionOpts are the options of the $(...) expressions which compute the
+// values of build args.
+type ExpressionOpts struct {
+	// Host runs the expression on the host, as per ARG --host, rather than in
+	// the container of the target. It is only allowed in LOCALLY targets.
+	Host bool
+	// Secrets are the secrets made available to the expression, as per ARG
+	// --secret. None are available otherwise.
+	Secrets []string
+	// BuildArg is set for the expressions of --build-arg values, which cannot
+	// opt in to run on the host.
+	BuildArg bool
+}
+
+// processNonConstantBuildArgFunc returns the function computing the values of
+// build args given as $(...) expressions. Expressions run in the container of
+// the target, and are cached like RUN commands, unless they run on the host.
+// There is no container in LOCALLY targets, and expressions only run on the
+// host there when explicitly opted in via ARG --host, such that merely
+// passing a build arg never executes anything on the host.
+func (c *Converter) processNonConstantBuildArgFunc(ctx context.Context, exprOpts ExpressionOpts) variables.ProcessNonConstantVariableFunc {
 	return func(name string, expression string) (string, int, error) {
+		locally := c.locallyShell != ""
+		if locally && exprOpts.BuildArg {
+			return "", 0, errors.Errorf(
+				"the value of --build-arg %s is computed via %s, which cannot run in a LOCALLY target; "+
+					"declare an ARG --host with this expression first, and pass that arg on via --build-arg %s=$<arg> instead", name, expression, name)
+		}
+		if locally && !exprOpts.Host {
+			return "", 0, errors.Errorf(
+				"the value of %s is computed via %s, which cannot run in a container in a LOCALLY target; "+
+					"declare it via ARG --host %s=%s to run it on the host", name, expression, name, expression)
+		}
+		if exprOpts.Host && !locally {
+			return "", 0, errors.New("ARG --host can only be used in LOCALLY targets")
+		}
+		if exprOpts.Host && len(exprOpts.Secrets) != 0 {
+			return "", 0, errors.New("ARG --host cannot be combined with --secret, as secrets are not available to commands run on the host")
+		}
 		opts := ConvertRunOpts{
 			CommandName: fmt.Sprintf("ARG %s = RUN", name),
 			Args:        strings.Split(expression, " "),
-			Transient:   true,
+			Locally:     exprOpts.Host,
+			Secrets:     exprOpts.Secrets,
+			Transient:   !exprOpts.Host,
 		}
 		output, err := c.RunExpression(ctx, name, opts)
 		if err != nil {
@@ -1644,6 +2182,7 @@ func (c *Converter) imageVertexPrefix(id string) string {
 }
 
 func (c *Converter) vertexPrefixWithURL(url string) string {
+	url = stringutil.ScrubCredentials(url)
 	return fmt.Sprintf("[%s(%s) %s] ", c.mts.Final.Target.String(), url, url)
 }
 
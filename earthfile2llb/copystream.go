@@ -0,0 +1,57 @@
+package earthfile2llb
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// streamMountPath returns the absolute path at which a streamed artifact is
+// mounted. A dest ending in / is a directory, in which the artifact is
+// mounted under its own name.
+func streamMountPath(artifactPath, dest, workdir string) string {
+	p := dest
+	if strings.HasSuffix(dest, "/") {
+		p = path.Join(dest, path.Base(artifactPath))
+	}
+	if !path.IsAbs(p) {
+		p = path.Join("/", workdir, p)
+	}
+	return path.Clean(p)
+}
+
+// CopyArtifactStream applies the COPY --stream command. Rather than being
+// copied into the image, the artifact is mounted read-only into every
+// subsequent RUN command of the target, such that large artifacts are
+// neither duplicated into a new layer, nor exported along with the image.
+func (c *Converter) CopyArtifactStream(ctx context.Context, artifactName string, dest string, platform *specs.Platform, allowPrivileged bool, buildArgs []string) error {
+	err := c.checkAllowed(copyCmd)
+	if err != nil {
+		return err
+	}
+	c.nonSaveCommand()
+	artifact, err := domain.ParseArtifact(artifactName)
+	if err != nil {
+		return errors.Wrapf(err, "parse artifact name %s", artifactName)
+	}
+	if strings.ContainsAny(artifact.Artifact, "*?[") {
+		return errors.Errorf("COPY --stream does not support wildcards: %s", artifactName)
+	}
+	mts, err := c.buildTarget(ctx, artifact.Target.String(), platform, allowPrivileged, buildArgs, false, copyCmd)
+	if err != nil {
+		return errors.Wrapf(err, "apply build %s", artifact.Target.String())
+	}
+	mountPath := streamMountPath(artifact.Artifact, dest, c.mts.Final.MainImage.Config.WorkingDir)
+	if mountPath == "/" {
+		return errors.Errorf("COPY --stream cannot mount %s at /", artifactName)
+	}
+	c.streamMounts = append(c.streamMounts, pllb.AddMount(
+		mountPath, mts.Final.ArtifactsState, llb.SourcePath(artifact.Artifact), llb.Readonly))
+	return nil
+}
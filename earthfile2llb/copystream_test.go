@@ -0,0 +1,15 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamMountPath(t *testing.T) {
+	assert.Equal(t, "/data", streamMountPath("dataset", "/data", "/app"))
+	assert.Equal(t, "/app/data", streamMountPath("dataset", "data", "/app"))
+	assert.Equal(t, "/app/data/dataset", streamMountPath("out/dataset", "data/", "/app"))
+	assert.Equal(t, "/app/dataset", streamMountPath("dataset", "./", "/app"))
+	assert.Equal(t, "/data", streamMountPath("dataset", "data", ""))
+}
@@ -0,0 +1,69 @@
+package earthfile2llb
+
+import (
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/earthly/earthly/util/llbutil"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// crossToolchain holds the naming conventions of various toolchains for a
+// given architecture.
+type crossToolchain struct {
+	rustTarget string // Rust target triple.
+	gnuPrefix  string // GNU cross-compiler prefix, as used by Debian/Ubuntu packages.
+	nodeArch   string // Node.js / npm architecture name.
+}
+
+var crossToolchains = map[string]crossToolchain{
+	"amd64":   {rustTarget: "x86_64-unknown-linux-gnu", gnuPrefix: "x86_64-linux-gnu", nodeArch: "x64"},
+	"arm64":   {rustTarget: "aarch64-unknown-linux-gnu", gnuPrefix: "aarch64-linux-gnu", nodeArch: "arm64"},
+	"arm/v7":  {rustTarget: "armv7-unknown-linux-gnueabihf", gnuPrefix: "arm-linux-gnueabihf", nodeArch: "arm"},
+	"arm/v6":  {rustTarget: "arm-unknown-linux-gnueabihf", gnuPrefix: "arm-linux-gnueabihf", nodeArch: "arm"},
+	"386":     {rustTarget: "i686-unknown-linux-gnu", gnuPrefix: "i686-linux-gnu", nodeArch: "ia32"},
+	"ppc64le": {rustTarget: "powerpc64le-unknown-linux-gnu", gnuPrefix: "powerpc64le-linux-gnu", nodeArch: "ppc64"},
+	"s390x":   {rustTarget: "s390x-unknown-linux-gnu", gnuPrefix: "s390x-linux-gnu", nodeArch: "s390x"},
+	"riscv64": {rustTarget: "riscv64gc-unknown-linux-gnu", gnuPrefix: "riscv64-linux-gnu", nodeArch: "riscv64"},
+}
+
+// crossCompileEnv returns the environment variables which instruct common
+// toolchains (Go, Rust, Node.js and C via TARGET_CC) to produce binaries for
+// the given platform, regardless of the platform they run on.
+func crossCompileEnv(platform specs.Platform) [][2]string {
+	platform = platforms.Normalize(platform)
+	env := [][2]string{
+		{"GOOS", platform.OS},
+		{"GOARCH", platform.Architecture},
+	}
+	if platform.Architecture == "arm" && platform.Variant != "" {
+		env = append(env, [2]string{"GOARM", strings.TrimPrefix(platform.Variant, "v")})
+	}
+	arch := platform.Architecture
+	if platform.Variant != "" {
+		arch = arch + "/" + platform.Variant
+	}
+	tc, ok := crossToolchains[arch]
+	if platform.OS == "linux" && ok {
+		env = append(env,
+			[2]string{"CARGO_BUILD_TARGET", tc.rustTarget},
+			[2]string{"TARGET_CC", tc.gnuPrefix + "-gcc"},
+			[2]string{"npm_config_arch", tc.nodeArch},
+		)
+	}
+	env = append(env, [2]string{"npm_config_platform", platform.OS})
+	return env
+}
+
+// nativePlatform returns the platform that the buildkit workers execute on
+// without emulation.
+func (c *Converter) nativePlatform() *specs.Platform {
+	for _, w := range c.opt.GwClient.BuildOpts().Workers {
+		if len(w.Platforms) > 0 {
+			p := platforms.Normalize(w.Platforms[0])
+			return &p
+		}
+	}
+	p := llbutil.DefaultPlatform()
+	return &p
+}
@@ -0,0 +1,26 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrossCompileEnvArm64(t *testing.T) {
+	env := crossCompileEnv(specs.Platform{OS: "linux", Architecture: "arm64"})
+	assert.Equal(t, [][2]string{
+		{"GOOS", "linux"},
+		{"GOARCH", "arm64"},
+		{"CARGO_BUILD_TARGET", "aarch64-unknown-linux-gnu"},
+		{"TARGET_CC", "aarch64-linux-gnu-gcc"},
+		{"npm_config_arch", "arm64"},
+		{"npm_config_platform", "linux"},
+	}, env)
+}
+
+func TestCrossCompileEnvArmV7(t *testing.T) {
+	env := crossCompileEnv(specs.Platform{OS: "linux", Architecture: "arm", Variant: "v7"})
+	assert.Contains(t, env, [2]string{"GOARM", "7"})
+	assert.Contains(t, env, [2]string{"CARGO_BUILD_TARGET", "armv7-unknown-linux-gnueabihf"})
+}
@@ -0,0 +1,168 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/variables"
+	"github.com/pkg/errors"
+)
+
+// DeprecationNotice is the use of a deprecated target, or of a deprecated ARG
+// of a target, within a build.
+type DeprecationNotice struct {
+	// Target is the target which is deprecated, or whose ARG is.
+	Target domain.Target
+	// Arg is the name of the deprecated ARG, if it is an ARG which is
+	// deprecated rather than the target.
+	Arg         string
+	Message     string
+	Replacement string
+}
+
+// String returns the notice as printed in the warnings of the build.
+func (dn DeprecationNotice) String() string {
+	var sb strings.Builder
+	if dn.Arg != "" {
+		fmt.Fprintf(&sb, "ARG %s of %s is deprecated", dn.Arg, dn.Target.StringCanonical())
+	} else {
+		fmt.Fprintf(&sb, "target %s is deprecated", dn.Target.StringCanonical())
+	}
+	if dn.Message != "" {
+		fmt.Fprintf(&sb, ": %s", dn.Message)
+	}
+	if dn.Replacement != "" {
+		fmt.Fprintf(&sb, " (replacement: %s)", dn.Replacement)
+	}
+	return sb.String()
+}
+
+// deprecationLog records the notices which have been reported, so that each
+// is reported once per build.
+type deprecationLog struct {
+	mu       sync.Mutex
+	reported map[string]bool
+}
+
+func newDeprecationLog() *deprecationLog {
+	return &deprecationLog{reported: make(map[string]bool)}
+}
+
+// firstReport returns whether the notice has not been reported yet, and
+// records it as reported.
+func (dl *deprecationLog) firstReport(dn DeprecationNotice) bool {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	key := dn.String()
+	if dl.reported[key] {
+		return false
+	}
+	dl.reported[key] = true
+	return true
+}
+
+// checkDeprecations warns about the use of the target if it is deprecated,
+// and about the deprecated ARGs of the target which are given a value. In
+// strict mode (opt.FailOnDeprecated), it fails instead.
+func checkDeprecations(target domain.Target, ef spec.Earthfile, overridingVars *variables.Scope, opt ConvertOpt) error {
+	notices := deprecationNotices(target, ef, overridingVars)
+	if len(notices) == 0 {
+		return nil
+	}
+	if opt.FailOnDeprecated {
+		return errors.Errorf("%s, which is not allowed when --strict is specified or otherwise implied", notices[0].String())
+	}
+	for _, dn := range notices {
+		if opt.deprecations.firstReport(dn) {
+			opt.Console.Warnf("Warning: %s\n", dn.String())
+		}
+	}
+	return nil
+}
+
+// deprecationNotices returns the notices of the use of the target with the
+// given overriding vars.
+func deprecationNotices(target domain.Target, ef spec.Earthfile, overridingVars *variables.Scope) []DeprecationNotice {
+	var t *spec.Target
+	for i := range ef.Targets {
+		if ef.Targets[i].Name == target.Target {
+			t = &ef.Targets[i]
+			break
+		}
+	}
+	if t == nil {
+		return nil
+	}
+	var notices []DeprecationNotice
+	if t.Deprecated != nil {
+		notices = append(notices, DeprecationNotice{
+			Target:      target,
+			Message:     t.Deprecated.Message,
+			Replacement: t.Deprecated.Replacement,
+		})
+	}
+	if overridingVars == nil {
+		return notices
+	}
+	args := make(map[string]*spec.Deprecation)
+	collectDeprecatedArgs(ef.BaseRecipe, args)
+	collectDeprecatedArgs(t.Recipe, args)
+	names := make([]string, 0, len(args))
+	for name := range args {
+		if _, ok := overridingVars.GetAny(name); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		notices = append(notices, DeprecationNotice{
+			Target:      target,
+			Arg:         name,
+			Message:     args[name].Message,
+			Replacement: args[name].Replacement,
+		})
+	}
+	return notices
+}
+
+// collectDeprecatedArgs adds the deprecated ARGs declared by the block,
+// including within nested blocks, to args.
+func collectDeprecatedArgs(block spec.Block, args map[string]*spec.Deprecation) {
+	for _, stmt := range block {
+		switch {
+		case stmt.Command != nil:
+			if stmt.Command.Name != "ARG" || stmt.Command.Deprecated == nil {
+				continue
+			}
+			cmdArgs := stmt.Command.Args
+			for i := 0; i < len(cmdArgs); i++ {
+				if cmdArgs[i] == "--secret" {
+					// Skip the value of the flag.
+					i++
+					continue
+				}
+				if strings.HasPrefix(cmdArgs[i], "--") {
+					continue
+				}
+				args[strings.SplitN(cmdArgs[i], "=", 2)[0]] = stmt.Command.Deprecated
+				break
+			}
+		case stmt.With != nil:
+			collectDeprecatedArgs(stmt.With.Body, args)
+		case stmt.If != nil:
+			collectDeprecatedArgs(stmt.If.IfBody, args)
+			for _, elseIf := range stmt.If.ElseIf {
+				collectDeprecatedArgs(elseIf.Body, args)
+			}
+			if stmt.If.ElseBody != nil {
+				collectDeprecatedArgs(*stmt.If.ElseBody, args)
+			}
+		case stmt.For != nil:
+			collectDeprecatedArgs(stmt.For.Body, args)
+		}
+	}
+}
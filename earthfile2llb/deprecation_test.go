@@ -0,0 +1,59 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/variables"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeprecationNotices(t *testing.T) {
+	ef := spec.Earthfile{
+		BaseRecipe: spec.Block{
+			{Command: &spec.Command{Name: "ARG", Args: []string{"REGISTRY=docker.io"}, Deprecated: &spec.Deprecation{Message: "Set IMAGE_PREFIX instead."}}},
+		},
+		Targets: []spec.Target{
+			{
+				Name:       "old",
+				Deprecated: &spec.Deprecation{Message: "Renamed.", Replacement: "+new"},
+				Recipe: spec.Block{
+					{If: &spec.IfStatement{IfBody: spec.Block{
+						{Command: &spec.Command{Name: "ARG", Args: []string{"--secret", "TOKEN=+secrets/token", "FLAGS", "=", "$(./flags.sh)"}, Deprecated: &spec.Deprecation{}}},
+					}}},
+					{Command: &spec.Command{Name: "ARG", Args: []string{"VERSION"}}},
+				},
+			},
+			{Name: "new"},
+		},
+	}
+	old := domain.Target{LocalPath: "./lib", Target: "old"}
+	notices := deprecationNotices(old, ef, nil)
+	assert.Equal(t, []DeprecationNotice{{Target: old, Message: "Renamed.", Replacement: "+new"}}, notices)
+	assert.Equal(t, "target ./lib+old is deprecated: Renamed. (replacement: +new)", notices[0].String())
+
+	vars := variables.NewScope()
+	vars.AddInactive("VERSION", "1.0")
+	vars.AddInactive("FLAGS", "-x")
+	vars.AddInactive("REGISTRY", "ghcr.io")
+	notices = deprecationNotices(old, ef, vars)
+	assert.Equal(t, 3, len(notices))
+	assert.Equal(t, "ARG FLAGS of ./lib+old is deprecated", notices[1].String())
+	assert.Equal(t, "ARG REGISTRY of ./lib+old is deprecated: Set IMAGE_PREFIX instead.", notices[2].String())
+
+	newTarget := domain.Target{LocalPath: "./lib", Target: "new"}
+	assert.Equal(t, 0, len(deprecationNotices(newTarget, ef, nil)))
+	notices = deprecationNotices(newTarget, ef, vars)
+	assert.Equal(t, 1, len(notices))
+	assert.Equal(t, "REGISTRY", notices[0].Arg)
+}
+
+func TestDeprecationLog(t *testing.T) {
+	dl := newDeprecationLog()
+	dn := DeprecationNotice{Target: domain.Target{LocalPath: ".", Target: "old"}}
+	assert.True(t, dl.firstReport(dn))
+	assert.False(t, dl.firstReport(dn))
+	dn.Arg = "FLAGS"
+	assert.True(t, dl.firstReport(dn))
+}
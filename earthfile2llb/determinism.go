@@ -0,0 +1,24 @@
+package earthfile2llb
+
+import (
+	"strconv"
+	"time"
+)
+
+// determinismEnv returns the environment variables which pin the clock and
+// the randomization of the tools run within a build, so that repeated builds
+// produce the same outputs.
+func determinismEnv(sourceDateEpoch *time.Time, randomSeed string) [][2]string {
+	var env [][2]string
+	if sourceDateEpoch != nil {
+		// See https://reproducible-builds.org/specs/source-date-epoch/.
+		env = append(env, [2]string{"SOURCE_DATE_EPOCH", strconv.FormatInt(sourceDateEpoch.Unix(), 10)})
+	}
+	if randomSeed != "" {
+		env = append(env,
+			[2]string{"EARTHLY_RANDOM_SEED", randomSeed},
+			[2]string{"PYTHONHASHSEED", randomSeed},
+		)
+	}
+	return env
+}
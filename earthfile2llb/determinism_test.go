@@ -0,0 +1,19 @@
+package earthfile2llb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeterminismEnv(t *testing.T) {
+	assert.Equal(t, 0, len(determinismEnv(nil, "")))
+
+	epoch := time.Unix(1609459200, 0)
+	assert.Equal(t, [][2]string{
+		{"SOURCE_DATE_EPOCH", "1609459200"},
+		{"EARTHLY_RANDOM_SEED", "42"},
+		{"PYTHONHASHSEED", "42"},
+	}, determinismEnv(&epoch, "42"))
+}
@@ -0,0 +1,110 @@
+package earthfile2llb
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+)
+
+// DNSConfig customizes the name resolution of the containers of RUN commands.
+type DNSConfig struct {
+	// Nameservers are the IPs of the DNS servers, which replace those of
+	// buildkitd.
+	Nameservers []string
+	// SearchDomains are the search domains of the DNS resolver.
+	SearchDomains []string
+	// ExtraHosts are additional entries of /etc/hosts, as in host:ip.
+	ExtraHosts []string
+}
+
+// Empty returns whether the config does not customize anything.
+func (d DNSConfig) Empty() bool {
+	return len(d.Nameservers) == 0 && len(d.SearchDomains) == 0 && len(d.ExtraHosts) == 0
+}
+
+// Validate returns an error if the config is invalid.
+func (d DNSConfig) Validate() error {
+	for _, ns := range d.Nameservers {
+		if net.ParseIP(ns) == nil {
+			return errors.Errorf("invalid DNS server %s: must be an IP", ns)
+		}
+	}
+	for _, domain := range d.SearchDomains {
+		if domain == "" || strings.ContainsAny(domain, " \t\n") {
+			return errors.Errorf("invalid DNS search domain %q", domain)
+		}
+	}
+	if len(d.SearchDomains) != 0 && len(d.Nameservers) == 0 {
+		// The resolv.conf of buildkitd is replaced as a whole.
+		return errors.New("DNS search domains require DNS servers to be set too")
+	}
+	for _, h := range d.ExtraHosts {
+		_, _, err := parseExtraHost(h)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge returns the config with the settings of other added to it, such as
+// those of a single RUN command to the global ones.
+func (d DNSConfig) merge(other DNSConfig) DNSConfig {
+	return DNSConfig{
+		Nameservers:   append(append([]string{}, d.Nameservers...), other.Nameservers...),
+		SearchDomains: append(append([]string{}, d.SearchDomains...), other.SearchDomains...),
+		ExtraHosts:    append(append([]string{}, d.ExtraHosts...), other.ExtraHosts...),
+	}
+}
+
+// runOpts returns the options of the RUN commands which apply the config.
+func (d DNSConfig) runOpts() ([]llb.RunOption, error) {
+	err := d.Validate()
+	if err != nil {
+		return nil, err
+	}
+	var runOpts []llb.RunOption
+	for _, h := range d.ExtraHosts {
+		host, ip, _ := parseExtraHost(h)
+		runOpts = append(runOpts, llb.AddExtraHost(host, ip))
+	}
+	if len(d.Nameservers) != 0 {
+		resolvConf := pllb.Scratch().File(
+			pllb.Mkfile("/resolv.conf", 0644, d.resolvConf()),
+			llb.WithCustomName("[internal] resolv.conf"))
+		runOpts = append(runOpts, pllb.AddMount("/etc/resolv.conf", resolvConf, llb.SourcePath("/resolv.conf"), llb.Readonly))
+	}
+	return runOpts, nil
+}
+
+// resolvConf returns the /etc/resolv.conf of the config.
+func (d DNSConfig) resolvConf() []byte {
+	var buf bytes.Buffer
+	for _, ns := range d.Nameservers {
+		fmt.Fprintf(&buf, "nameserver %s\n", ns)
+	}
+	if len(d.SearchDomains) != 0 {
+		fmt.Fprintf(&buf, "search %s\n", strings.Join(d.SearchDomains, " "))
+	}
+	return buf.Bytes()
+}
+
+// parseExtraHost parses an extra hosts entry, as in host:ip. The IP may be an
+// IPv6 address, which contains colons itself.
+func parseExtraHost(s string) (string, net.IP, error) {
+	i := strings.IndexByte(s, ':')
+	if i <= 0 {
+		return "", nil, errors.Errorf("invalid extra host %s: must be host:ip", s)
+	}
+	host, ipStr := s[:i], s[i+1:]
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return "", nil, errors.Errorf("invalid IP %s of extra host %s", ipStr, host)
+	}
+	return host, ip, nil
+}
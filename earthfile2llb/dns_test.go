@@ -0,0 +1,42 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSConfigValidate(t *testing.T) {
+	assert.NoError(t, DNSConfig{}.Validate())
+	assert.NoError(t, DNSConfig{
+		Nameservers:   []string{"10.0.0.2", "fd00::53"},
+		SearchDomains: []string{"corp.example.com"},
+		ExtraHosts:    []string{"db.internal:10.0.1.5", "v6.internal:fd00::1"},
+	}.Validate())
+	assert.Error(t, DNSConfig{Nameservers: []string{"dns.example.com"}}.Validate())
+	assert.Error(t, DNSConfig{SearchDomains: []string{"corp.example.com"}}.Validate())
+	assert.Error(t, DNSConfig{Nameservers: []string{"10.0.0.2"}, SearchDomains: []string{"a b"}}.Validate())
+	assert.Error(t, DNSConfig{ExtraHosts: []string{"db.internal"}}.Validate())
+	assert.Error(t, DNSConfig{ExtraHosts: []string{":10.0.1.5"}}.Validate())
+	assert.Error(t, DNSConfig{ExtraHosts: []string{"db.internal:nope"}}.Validate())
+}
+
+func TestDNSConfigMerge(t *testing.T) {
+	global := DNSConfig{Nameservers: []string{"10.0.0.2"}, ExtraHosts: []string{"a:10.0.0.3"}}
+	merged := global.merge(DNSConfig{SearchDomains: []string{"corp.example.com"}, ExtraHosts: []string{"b:10.0.0.4"}})
+	assert.Equal(t, DNSConfig{
+		Nameservers:   []string{"10.0.0.2"},
+		SearchDomains: []string{"corp.example.com"},
+		ExtraHosts:    []string{"a:10.0.0.3", "b:10.0.0.4"},
+	}, merged)
+	assert.Equal(t, []string{"a:10.0.0.3"}, global.ExtraHosts)
+	assert.True(t, DNSConfig{}.merge(DNSConfig{}).Empty())
+}
+
+func TestDNSConfigResolvConf(t *testing.T) {
+	d := DNSConfig{
+		Nameservers:   []string{"10.0.0.2", "10.0.0.3"},
+		SearchDomains: []string{"corp.example.com", "example.com"},
+	}
+	assert.Equal(t, "nameserver 10.0.0.2\nnameserver 10.0.0.3\nsearch corp.example.com example.com\n", string(d.resolvConf()))
+}
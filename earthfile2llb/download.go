@@ -0,0 +1,143 @@
+package earthfile2llb
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/stringutil"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// downloadImage is the image which runs the downloads that require an
+	// Authorization header, which buildkit's http source cannot send.
+	downloadImage = "alpine:3.13"
+	// downloadRetries is the number of times a failed download is retried.
+	downloadRetries = 3
+	// downloadAuthSecretPath is where the secret holding the value of the
+	// Authorization header is mounted.
+	downloadAuthSecretPath = "/run/secrets/earthly-download-auth"
+)
+
+var sha256HexRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// isDownloadURL returns true if the COPY source is an http(s) URL.
+func isDownloadURL(src string) bool {
+	return strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://")
+}
+
+// parseDownloadChecksum parses a checksum of the form sha256:<hex>, or just
+// <hex>, and returns the hex digest.
+func parseDownloadChecksum(checksum string) (string, error) {
+	hex := strings.ToLower(strings.TrimPrefix(checksum, "sha256:"))
+	if !sha256HexRegexp.MatchString(hex) {
+		return "", errors.Errorf("invalid checksum %s; expected sha256:<64 hex digits>", checksum)
+	}
+	return hex, nil
+}
+
+// downloadFilename returns the name of the file that a download is saved as,
+// which is the last element of the URL path.
+func downloadFilename(downloadURL string) string {
+	u, err := url.Parse(downloadURL)
+	if err != nil {
+		return "download"
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." || name == "" {
+		return "download"
+	}
+	return name
+}
+
+// downloadScript returns the shell script which downloads the URL into
+// outPath, with the Authorization header read from the mounted secret,
+// retrying transient failures, and verifies its sha256 checksum.
+func downloadScript(downloadURL, sha256Hex, outPath string) string {
+	// The header is read at runtime, so that the secret is not part of the command.
+	headerArg := fmt.Sprintf(` --header "Authorization: $(cat %s)"`, downloadAuthSecretPath)
+	quotedURL := shellescape.Quote(downloadURL)
+	outPath = shellescape.Quote(outPath)
+	scrubbedURL := shellescape.Quote(stringutil.ScrubCredentials(downloadURL))
+	return strings.Join([]string{
+		"set -e",
+		"attempt=0",
+		fmt.Sprintf("until wget -q%s -O %s %s; do", headerArg, outPath, quotedURL),
+		"  attempt=$((attempt+1))",
+		fmt.Sprintf("  if [ $attempt -gt %d ]; then echo \"earthly: download of \"%s\" failed\" >&2; exit 1; fi", downloadRetries, scrubbedURL),
+		"  sleep $((attempt*attempt))",
+		"done",
+		fmt.Sprintf("actual=$(sha256sum %s | cut -d ' ' -f 1)", outPath),
+		fmt.Sprintf("if [ \"$actual\" != %s ]; then", sha256Hex),
+		fmt.Sprintf("  echo \"earthly: checksum mismatch for \"%s\": expected sha256:%s, got sha256:$actual\" >&2", scrubbedURL, sha256Hex),
+		"  exit 1",
+		"fi",
+	}, "\n")
+}
+
+// CopyDownload applies the COPY command for a file downloaded via http(s),
+// whose sha256 checksum must match the given one. The download is cached by
+// its checksum, regardless of the URL it is downloaded from. The value of the
+// authSecret, if specified, is sent as the Authorization header.
+func (c *Converter) CopyDownload(ctx context.Context, downloadURL, checksum, authSecret, dest string, keepTs bool, keepOwn bool, chown string) error {
+	err := c.checkAllowed(copyCmd)
+	if err != nil {
+		return err
+	}
+	sha256Hex, err := parseDownloadChecksum(checksum)
+	if err != nil {
+		return err
+	}
+	c.nonSaveCommand()
+	filename := downloadFilename(downloadURL)
+	var downloadState pllb.State
+	if authSecret == "" {
+		downloadState = pllb.HTTP(
+			downloadURL,
+			llb.Checksum(digest.NewDigestFromEncoded(digest.SHA256, sha256Hex)),
+			llb.Filename(filename),
+			llb.Chmod(0644),
+			llb.WithCustomNamef(
+				"%sDOWNLOAD %s", c.vertexPrefix(false, false), stringutil.ScrubCredentials(downloadURL)))
+	} else {
+		if c.opt.Offline {
+			return errors.Errorf("COPY --auth-secret %s is not possible in --offline mode", stringutil.ScrubCredentials(downloadURL))
+		}
+		downloadState = c.downloadWithAuth(downloadURL, sha256Hex, filename, authSecret)
+	}
+	c.mts.Final.MainState = llbutil.CopyOp(
+		downloadState, []string{"/" + filename},
+		c.mts.Final.MainState, dest, false, false, keepTs, c.copyOwner(keepOwn, chown), false, false,
+		llb.WithCustomNamef(
+			"%sCOPY --checksum sha256:%s %s %s",
+			c.vertexPrefix(false, false), sha256Hex, stringutil.ScrubCredentials(downloadURL), dest))
+	return nil
+}
+
+// downloadWithAuth returns the state holding the file downloaded via wget, in
+// a container, which sends the value of the authSecret as the Authorization
+// header.
+func (c *Converter) downloadWithAuth(downloadURL, sha256Hex, filename, authSecret string) pllb.State {
+	outPath := path.Join("/out", filename)
+	opImg := pllb.Image(
+		downloadImage, llb.MarkImageInternal, llb.ResolveModePreferLocal,
+		llb.Platform(llbutil.DefaultPlatform()))
+	runOpts := []llb.RunOption{
+		llb.Args([]string{"/bin/sh", "-c", downloadScript(downloadURL, sha256Hex, outPath)}),
+		llb.AddSecret(downloadAuthSecretPath,
+			llb.SecretID(strings.TrimPrefix(authSecret, "+secrets/")),
+			llb.SecretFileOpt(0, 0, 0444)),
+		llb.WithCustomNamef(
+			"%sDOWNLOAD %s", c.vertexPrefix(false, false), stringutil.ScrubCredentials(downloadURL)),
+	}
+	return opImg.Run(runOpts...).AddMount("/out", llbutil.ScratchWithPlatform())
+}
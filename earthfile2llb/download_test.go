@@ -0,0 +1,27 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDownloadChecksum(t *testing.T) {
+	hex := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	for _, checksum := range []string{hex, "sha256:" + hex} {
+		actual, err := parseDownloadChecksum(checksum)
+		assert.NoError(t, err)
+		assert.Equal(t, hex, actual)
+	}
+	_, err := parseDownloadChecksum("sha256:abc")
+	assert.Error(t, err)
+	_, err = parseDownloadChecksum("md5:" + hex)
+	assert.Error(t, err)
+}
+
+func TestDownloadFilename(t *testing.T) {
+	assert.Equal(t, "go1.16.linux-amd64.tar.gz", downloadFilename("https://golang.org/dl/go1.16.linux-amd64.tar.gz"))
+	assert.Equal(t, "tool", downloadFilename("https://example.com/releases/tool?version=1#x"))
+	assert.Equal(t, "download", downloadFilename("https://example.com/"))
+	assert.Equal(t, "download", downloadFilename("https://example.com"))
+}
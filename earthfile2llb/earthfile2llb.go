@@ -2,6 +2,7 @@ package earthfile2llb
 
 import (
 	"context"
+	"time"
 
 	"github.com/moby/buildkit/client/llb"
 	gwclient "github.com/moby/buildkit/frontend/gateway/client"
@@ -9,17 +10,30 @@ import (
 	"github.com/pkg/errors"
 	"golang.org/x/sync/semaphore"
 
+	"github.com/earthly/earthly/analytics"
 	"github.com/earthly/earthly/ast"
+	"github.com/earthly/earthly/audit"
 	"github.com/earthly/earthly/buildcontext"
 	"github.com/earthly/earthly/buildcontext/provider"
+	"github.com/earthly/earthly/capabilities"
 	"github.com/earthly/earthly/cleanup"
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/features"
+	"github.com/earthly/earthly/imageindex"
+	"github.com/earthly/earthly/imageverify"
+	"github.com/earthly/earthly/privileged"
 	"github.com/earthly/earthly/states"
+	"github.com/earthly/earthly/stdlib"
+	"github.com/earthly/earthly/telemetry"
+	"github.com/earthly/earthly/util/retryutil"
 	"github.com/earthly/earthly/variables"
 )
 
+// ErrExecutionRequired is returned when converting with ConvertOpt.NoExecution
+// a target whose conversion requires executing some of its commands.
+var ErrExecutionRequired = errors.New("converting requires executing commands")
+
 // ConvertOpt holds conversion parameters.
 type ConvertOpt struct {
 	// GwClient is the BuildKit gateway client.
@@ -41,6 +55,15 @@ type ConvertOpt struct {
 	Visited *states.VisitedCollection
 	// Platform is the target platform of the build.
 	Platform *specs.Platform
+	// NativeBuild causes artifacts copied into the target (without an explicit --platform)
+	// to be built on the native platform, cross-compiling for Platform.
+	NativeBuild bool
+	// CrossPlatform is the platform the target's toolchains should produce binaries for,
+	// when it is built natively on behalf of a NativeBuild target. Nil otherwise.
+	CrossPlatform *specs.Platform
+	// RunTimeout is the timeout applied to RUN commands of the target which do not
+	// specify their own, as set via BUILD --timeout. Zero means no timeout.
+	RunTimeout time.Duration
 	// OverridingVars is a collection of build args used for overriding args in the build.
 	OverridingVars *variables.Scope
 	// A cache for image solves. (maybe dockerTag +) depTargetInputHash -> context containing image.tar.
@@ -49,6 +72,58 @@ type ConvertOpt struct {
 	BuildContextProvider *provider.BuildContextProvider
 	// MetaResolver is the image meta resolver to use for resolving image metadata.
 	MetaResolver llb.ImageMetaResolver
+	// RegistryRetry is the policy used to retry transient failures when resolving images.
+	RegistryRetry retryutil.Policy
+	// ImageIndex, if set, records the images resolved during the build, so that
+	// they can later be resolved in offline mode.
+	ImageIndex *imageindex.Index
+	// CachedImages, if set, are the images whose layers are in the cache of
+	// buildkitd. In offline mode, other images fail to resolve.
+	CachedImages imageindex.CachedImages
+	// Offline causes the build to fail fast on anything that requires network
+	// access, resolving images solely from ImageIndex.
+	Offline bool
+	// Mock fails the build on remote targets which are not stubbed via the
+	// workspace, as in mock mode, rather than fetching them.
+	Mock bool
+	// ImageVerifier verifies the images of FROM --verify. It is shared across
+	// the build, so that each image is verified once.
+	ImageVerifier *imageverify.Verifier
+	// Runners are the remote hosts which LOCALLY targets may execute on, via
+	// LOCALLY --runner, by name.
+	Runners map[string]Runner
+	// HasSecret, if set, returns whether a secret is available to the build,
+	// so that the secrets targets declare via REQUIRES --secret can be checked
+	// before they are built.
+	HasSecret func(name string) bool
+	// GetSecret, if set, returns the value of a secret of the build, for the
+	// secrets which earthly itself consumes on the host, such as those of
+	// LOCALLY --keychain.
+	GetSecret func(name string) ([]byte, error)
+	// BuildContexts are the additional named build contexts COPY --from-context
+	// copies from, as a map of names to absolute directories.
+	BuildContexts map[string]string
+	// CacheNamespace, if set, isolates the cache mounts of RUN --mount=type=cache
+	// from those of other builds, as used by earthly selftest.
+	CacheNamespace string
+	// SourceDateEpoch, if set, is exported as SOURCE_DATE_EPOCH to all RUN
+	// commands and used as the creation time of the images saved, so that
+	// the outputs of builds can be compared against golden files.
+	SourceDateEpoch *time.Time
+	// Hostname, if set, is the hostname of the containers of RUN commands.
+	Hostname string
+	// DefaultUser, if set, is the user the targets switch to after they start
+	// FROM an image which runs as root, unless they switch back via USER.
+	DefaultUser string
+	// DNS customizes the name resolution of RUN commands, in addition to
+	// their own --dns, --dns-search and --add-host flags.
+	DNS DNSConfig
+	// RandomSeed, if set, is exported to all RUN commands as
+	// EARTHLY_RANDOM_SEED and PYTHONHASHSEED.
+	RandomSeed string
+	// ArgOverrides are values of the ARGs of specific targets, which take
+	// precedence over the build args the targets are invoked with.
+	ArgOverrides []ArgOverride
 	// CacheImports is a set of docker tags that can be used to import cache. Note that this
 	// set is modified by the converter if InlineCache is enabled.
 	CacheImports *states.CacheImports
@@ -59,8 +134,14 @@ type ConvertOpt struct {
 	UseFakeDep bool
 	// AllowLocally is an internal feature flag for controlling if LOCALLY directives can be used.
 	AllowLocally bool
+	// LocallyGrants holds the capabilities the user has granted to LOCALLY targets.
+	// A nil value allows all LOCALLY targets, regardless of what they declare.
+	LocallyGrants *capabilities.Grants
 	// AllowInteractive is an internal feature flag for controlling if interactive sessions can be initiated.
 	AllowInteractive bool
+	// FailOnDeprecated fails the build on the use of deprecated targets and
+	// ARGs, rather than warning about it.
+	FailOnDeprecated bool
 	// HasDangling represents whether the target has dangling instructions -
 	// ie if there are any non-SAVE commands after the first SAVE command,
 	// or if the target is invoked via BUILD command (not COPY nor FROM).
@@ -82,6 +163,18 @@ type ConvertOpt struct {
 	GitLookup *buildcontext.GitLookup
 	// LocalStateCache provides a cache for local pllb.States
 	LocalStateCache *LocalStateCache
+	// AuditLog records side effects performed by the build, such as LOCALLY commands. May be nil.
+	AuditLog *audit.Log
+	// OnSideEffect is called before the build performs a side effect which
+	// cannot be undone, such as running a LOCALLY command. May be nil.
+	OnSideEffect func()
+	// NoExecution makes the conversion fail with ErrExecutionRequired rather
+	// than execute any command, as LOCALLY commands, the $(...) expressions
+	// of ARG and the conditions of IF and FOR otherwise are.
+	NoExecution bool
+	// PrivilegedApprover approves the commands which run privileged or mount
+	// paths of the host. A nil value approves all of them.
+	PrivilegedApprover *privileged.Approver
 
 	// Features is the set of enabled features
 	Features *features.Features
@@ -94,6 +187,9 @@ type ConvertOpt struct {
 	// parentDepSub is a channel informing of any new dependencies from the parent.
 	parentDepSub chan string // chan of sts IDs.
 
+	// deprecations records the deprecation warnings already printed.
+	deprecations *deprecationLog
+
 	// FeatureFlagOverride is used to override feature flags that are defined in specific Earthfiles
 	FeatureFlagOverrides string
 }
@@ -107,7 +203,25 @@ func Earthfile2LLB(ctx context.Context, target domain.Target, opt ConvertOpt, in
 		opt.Visited = states.NewVisitedCollection()
 	}
 	if opt.MetaResolver == nil {
-		opt.MetaResolver = NewCachedMetaResolver(opt.GwClient)
+		var metaResolver llb.ImageMetaResolver = opt.GwClient
+		if opt.Offline {
+			metaResolver = imageindex.NewOfflineMetaResolver(opt.ImageIndex, opt.CachedImages)
+		} else if opt.ImageIndex != nil {
+			metaResolver = imageindex.NewRecordingMetaResolver(opt.GwClient, opt.ImageIndex)
+		}
+		opt.MetaResolver = NewCachedMetaResolver(metaResolver, opt.RegistryRetry)
+	}
+	if opt.ImageVerifier == nil {
+		opt.ImageVerifier = imageverify.NewVerifier()
+	}
+	if opt.deprecations == nil {
+		opt.deprecations = newDeprecationLog()
+	}
+	if opt.Offline && target.IsRemote() && !stdlib.IsLibrary(target.GetGitURL()) && !opt.Resolver.InWorkspace(target) {
+		return nil, errors.Errorf("remote target %s cannot be resolved in --offline mode", target.String())
+	}
+	if opt.Mock && target.IsRemote() && !stdlib.IsLibrary(target.GetGitURL()) && !opt.Resolver.InWorkspace(target) {
+		return nil, errors.Errorf("remote target %s is not stubbed in the imports of the mock file", target.String())
 	}
 	// Resolve build context.
 	bc, err := opt.Resolver.Resolve(ctx, opt.GwClient, target)
@@ -124,7 +238,14 @@ func Earthfile2LLB(ctx context.Context, target domain.Target, opt ConvertOpt, in
 		return nil, errors.Wrapf(err, "failed to apply version feature overrides")
 	}
 	opt.Features = ftrs
+	telemetry.Feature("VERSION " + ftrs.Version())
+	for _, flag := range ftrs.EnabledFlags() {
+		telemetry.Feature("VERSION --" + flag)
+	}
 	if initialCall {
+		if ftrs.Project != "" {
+			analytics.SetProject(ftrs.Project)
+		}
 		// It's not possible to know if we should DoSaves until after we have parsed the target's VERSION features.
 		if ftrs.ReferencedSaveOnly {
 			opt.DoSaves = true
@@ -137,7 +258,19 @@ func Earthfile2LLB(ctx context.Context, target domain.Target, opt ConvertOpt, in
 	}
 
 	targetWithMetadata := bc.Ref.(domain.Target)
-	sts, found, err := opt.Visited.Add(ctx, targetWithMetadata, opt.Platform, opt.AllowPrivileged, opt.OverridingVars, opt.parentDepSub)
+	opt.OverridingVars, err = applyArgOverrides(opt.ArgOverrides, targetWithMetadata, bc.Earthfile, opt.OverridingVars)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDeprecations(targetWithMetadata, bc.Earthfile, opt.OverridingVars, opt)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRequires(targetWithMetadata, bc.Earthfile, opt)
+	if err != nil {
+		return nil, err
+	}
+	sts, found, err := opt.Visited.Add(ctx, targetWithMetadata, opt.Platform, opt.CrossPlatform, opt.RunTimeout, opt.AllowPrivileged, opt.OverridingVars, opt.parentDepSub)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,19 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFanOutSource(t *testing.T) {
+	registryTags := make(map[string]string)
+	assert.Equal(t, "", fanOutSource(registryTags, "ghcr.io/example/app:1.0"))
+	assert.Equal(t, "", fanOutSource(registryTags, "registry.example.com/app:1.0"))
+	assert.Equal(t, "ghcr.io/example/app:1.0", fanOutSource(registryTags, "ghcr.io/example/app:latest"))
+	assert.Equal(t, "ghcr.io/example/app:1.0", fanOutSource(registryTags, "ghcr.io/example/other:1.0"))
+	// Docker Hub names are normalized.
+	assert.Equal(t, "", fanOutSource(registryTags, "example/app:1.0"))
+	assert.Equal(t, "example/app:1.0", fanOutSource(registryTags, "docker.io/example/app:latest"))
+	assert.Equal(t, "", fanOutSource(registryTags, "not a valid name"))
+}
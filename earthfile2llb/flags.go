@@ -5,6 +5,8 @@ import (
 )
 
 type ifOpts struct {
+	Expr       bool     `long:"expr" description:"Evaluate the condition as a native expression, rather than in the build environment"`
+	Platforms  []string `long:"platform" description:"Match the platform of the target against the given platforms, instead of evaluating a condition"`
 	Privileged bool     `long:"privileged" description:"Enable privileged mode"`
 	WithSSH    bool     `long:"ssh" description:"Make available the SSH agent of the host"`
 	NoCache    bool     `long:"no-cache" description:"Always run this specific item, ignoring cache"`
@@ -32,12 +34,37 @@ type runOpts struct {
 	InteractiveKeep bool     `long:"interactive-keep" description:"Run this command with an interactive session, saving changes"`
 	Secrets         []string `long:"secret" description:"Make available a secret"`
 	Mounts          []string `long:"mount" description:"Mount a file or directory"`
+	ProcessMemory   string   `long:"process-memory" description:"Limit the memory each process of the command may allocate, e.g. 512m or 8g"`
+	Timeout         string   `long:"timeout" description:"Terminate the command if it runs for longer than this duration, e.g. 10m"`
+	Retries         int      `long:"retries" description:"The number of times to retry the command if it fails"`
+	RetryDelay      string   `long:"retry-delay" description:"How long to wait between retries, e.g. 10s"`
+	DNS             []string `long:"dns" description:"The IP of a DNS server to use instead of those of buildkitd"`
+	DNSSearch       []string `long:"dns-search" description:"A DNS search domain"`
+	AddHost         []string `long:"add-host" description:"Add an entry to /etc/hosts, as in host:ip"`
+	CapAdd          []string `long:"cap-add" description:"Grant a Linux capability in addition to the default ones"`
+	CapDrop         []string `long:"cap-drop" description:"Remove a Linux capability, or ALL"`
+	SecurityOpt     []string `long:"security-opt" description:"A security option: no-new-privileges or apparmor=<profile>"`
+	GPUs            string   `long:"gpus" description:"Make the NVIDIA GPUs of buildkitd available: all, or GPU indexes or UUIDs separated by commas"`
+	Services        []string `long:"service" description:"Run a sidecar service alongside the command, as in image=postgres:13,env=POSTGRES_PASSWORD=secret"`
+	JUnit           []string `long:"junit" description:"A JUnit report written by the command, which its failed tests are read from (can be a glob pattern, can be repeated)"`
+	RerunFailed     int      `long:"rerun-failed" description:"The number of times to rerun the failed tests of the JUnit reports"`
+	Quarantine      []string `long:"quarantine" description:"A test whose failures do not fail the command (can be a glob pattern, can be repeated)"`
+	Shell           string   `long:"shell" description:"The shell to execute the command with: sh, bash or bash-strict"`
 }
 
 type fromOpts struct {
 	AllowPrivileged bool     `long:"allow-privileged" description:"Allow commands under remote targets to enable privileged mode"`
 	BuildArgs       []string `long:"build-arg" description:"A build arg override passed on to a referenced Earthly target"`
 	Platform        string   `long:"platform" description:"The platform to use"`
+	PassArgs        bool     `long:"pass-args" description:"Pass all the args declared in the current target on to the referenced Earthly target"`
+	PassArg         []string `long:"pass-arg" description:"Pass the given args declared in the current target on to the referenced Earthly target (can be comma-separated or repeated)"`
+	Verify          string   `long:"verify" description:"Verify the signature of the image against a cosign or notation policy before building on top of it"`
+}
+
+type fromNixOpts struct {
+	DevShell string `long:"dev-shell" description:"The dev shell of the flake to provision (default by default)"`
+	Image    string `long:"image" description:"The image, with nix installed, which the dev shell is provisioned in"`
+	Platform string `long:"platform" description:"The platform to use"`
 }
 
 type fromDockerfileOpts struct {
@@ -47,8 +74,19 @@ type fromDockerfileOpts struct {
 	Path      string   `short:"f" description:"The Dockerfile location on the host, relative to the current Earthfile, or as an artifact reference"`
 }
 
+type locallyOpts struct {
+	DeclareFS        []string `long:"declare-fs" description:"Declare that the target uses a path on the host (advisory)"`
+	DeclareNetwork   bool     `long:"declare-network" description:"Declare that the target uses the network (advisory)"`
+	DeclareDocker    bool     `long:"declare-docker" description:"Declare that the target uses the host's docker daemon (advisory)"`
+	Shell            string   `long:"shell" description:"The host shell used to execute RUN commands: sh, cmd or powershell"`
+	Runner           string   `long:"runner" description:"Execute the target on the named remote runner over SSH, rather than on the host"`
+	Keychain         string   `long:"keychain" description:"Import the PKCS#12 signing identity of the secret into a keychain of the target on the runner"`
+	KeychainPassword string   `long:"keychain-password" description:"The secret holding the password of the --keychain signing identity"`
+}
+
 type copyOpts struct {
 	From            string   `long:"from" description:"Not supported"`
+	FromContext     string   `long:"from-context" description:"Copy from the named build context given via --build-context, instead of the build context of the target"`
 	IsDirCopy       bool     `long:"dir" description:"Copy entire directories, not just the contents"`
 	Chown           string   `long:"chown" description:"Apply a specific group and/or owner to the copied files and directories"`
 	KeepTs          bool     `long:"keep-ts" description:"Keep created time file timestamps"`
@@ -58,6 +96,15 @@ type copyOpts struct {
 	AllowPrivileged bool     `long:"allow-privileged" description:"Allow targets to assume privileged mode"`
 	Platform        string   `long:"platform" description:"The platform to use"`
 	BuildArgs       []string `long:"build-arg" description:"A build arg override passed on to a referenced Earthly target"`
+	Checksum        string   `long:"checksum" description:"The sha256 checksum which a file downloaded from a URL must match"`
+	AuthSecret      string   `long:"auth-secret" description:"A secret containing the Authorization header value to send when downloading from a URL"`
+	Stream          bool     `long:"stream" description:"Mount the artifact read-only into the subsequent RUN commands, instead of copying it into the image"`
+}
+
+type argOpts struct {
+	Host    bool     `long:"host" description:"Run the $(...) expression of the value on the host, rather than in a container (LOCALLY targets only)"`
+	Secrets []string `long:"secret" description:"Make available a secret to the $(...) expression of the value"`
+	Expr    bool     `long:"expr" description:"Evaluate the value as a native expression (as IF --expr), resulting in true or false"`
 }
 
 type saveArtifactOpts struct {
@@ -72,17 +119,31 @@ type saveImageOpts struct {
 	CacheHint bool     `long:"cache-hint" description:"Instruct Earthly that the current target shuold be saved entirely as part of the remote cache"`
 	Insecure  bool     `long:"insecure" description:"Use unencrypted connection for the push"`
 	CacheFrom []string `long:"cache-from" description:"Declare additional cache import as a Docker tag"`
+	MaxSize   string   `long:"max-size" description:"Fail the build if the size of the files of the image exceeds this size, e.g. 300MB"`
+	MaxFiles  int      `long:"max-files" description:"Fail the build if the number of files of the image exceeds this number"`
 }
 
 type buildOpts struct {
 	Platforms       []string `long:"platform" description:"The platform to use"`
 	BuildArgs       []string `long:"build-arg" description:"A build arg override passed on to a referenced Earthly target"`
 	AllowPrivileged bool     `long:"allow-privileged" description:"Allow targets to assume privileged mode"`
+	NativeBuild     bool     `long:"native-build" description:"Build the artifacts copied into the target on the native platform, cross-compiling for the target platform"`
+	Timeout         string   `long:"timeout" description:"Terminate any RUN command of the target which runs for longer than this duration, e.g. 10m"`
+	PassArgs        bool     `long:"pass-args" description:"Pass all the args declared in the current target on to the referenced Earthly target"`
+	PassArg         []string `long:"pass-arg" description:"Pass the given args declared in the current target on to the referenced Earthly target (can be comma-separated or repeated)"`
+	ImportArg       []string `long:"import-arg" description:"Declare the given args in the current target, with the values computed by the referenced Earthly target (can be comma-separated or repeated)"`
+	Shards          string   `long:"shards" description:"Build the referenced Earthly target the given number of times in parallel, with the build args SHARD_INDEX and SHARD_COUNT"`
+	ShardItems      []string `long:"shard-items" description:"Whitespace-separated items, such as test files or packages, to split across the shards, passed on as SHARD_ITEMS (can be repeated)"`
+	ShardWeights    string   `long:"shard-weights" description:"Whitespace-separated weights of the shard items, as in pkg/foo=1m30s, to balance the shards with"`
 }
 
 type gitCloneOpts struct {
-	Branch string `long:"branch" description:"The git ref to use when cloning"`
-	KeepTs bool   `long:"keep-ts" description:"Keep created time file timestamps"`
+	Branch            string   `long:"branch" description:"The git ref to use when cloning"`
+	KeepTs            bool     `long:"keep-ts" description:"Keep created time file timestamps"`
+	Depth             int      `long:"depth" description:"Only fetch the given number of commits of history"`
+	Sparse            []string `long:"sparse" description:"Only check out the given directories (can be comma-separated or repeated)"`
+	RecurseSubmodules bool     `long:"recurse-submodules" description:"Also clone the submodules of the repository"`
+	LFS               bool     `long:"lfs" description:"Also fetch the Git LFS objects of the checkout"`
 }
 
 type healthCheckOpts struct {
@@ -100,6 +161,16 @@ type withDockerOpts struct {
 	BuildArgs       []string `long:"build-arg" description:"A build arg override passed on to a referenced Earthly target"`
 	Pulls           []string `long:"pull" description:"An image which is pulled and made available in the docker cache"`
 	AllowPrivileged bool     `long:"allow-privileged" description:"Allow targets referenced by load to assume privileged mode"`
+	WaitForPorts    []string `long:"wait-for-port" description:"Wait for a host:port to accept connections before running the command"`
+	WaitForHTTP     []string `long:"wait-for-http" description:"Wait for a URL to respond with a non-error status before running the command"`
+	WaitTimeout     string   `long:"wait-timeout" description:"How long to wait for --wait-for-port and --wait-for-http, e.g. 2m"`
+	StreamLogs      bool     `long:"stream-logs" description:"Stream the logs of the containers into the output of the command, prefixed with their names"`
+	Volumes         []string `long:"volume" description:"A persistent named volume, as in name=<name>[,max-age=<duration>], which is kept across builds"`
+	Setup           string   `long:"setup" description:"A setup command, whose resulting docker state is snapshotted, and restored by later builds with the same inputs"`
+}
+
+type userOpts struct {
+	NonRoot bool `long:"non-root" description:"Run the remainder of the target as this non-root user, and forbid switching to root"`
 }
 
 type doOpts struct {
@@ -0,0 +1,220 @@
+package earthfile2llb
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/buildcontext"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/earthly/earthly/util/stringutil"
+	"github.com/moby/buildkit/client/llb"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/util/gitutil"
+	"github.com/pkg/errors"
+)
+
+// ConvertGitCloneOpts contains the options of a GIT CLONE command.
+type ConvertGitCloneOpts struct {
+	Branch string
+	Dest   string
+	KeepTs bool
+	// Depth limits the history fetched to the given number of commits. Zero
+	// means the full history.
+	Depth int
+	// Sparse restricts the checkout to the given directories.
+	Sparse            []string
+	RecurseSubmodules bool
+	LFS               bool
+}
+
+// scripted returns true if the clone requires options which the buildkit git
+// source does not support, and therefore needs to be performed via git itself.
+func (opts ConvertGitCloneOpts) scripted() bool {
+	return opts.Depth != 0 || len(opts.Sparse) != 0 || opts.RecurseSubmodules || opts.LFS
+}
+
+var gitCommitSHARegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// parseLsRemote returns the commit which ref points to, out of the output of
+// git ls-remote. Branches take precedence over tags, and annotated tags are
+// resolved to the commit they point to.
+func parseLsRemote(output, ref string) (string, error) {
+	candidates := []string{ref}
+	if ref != "HEAD" && !strings.HasPrefix(ref, "refs/") {
+		candidates = []string{"refs/heads/" + ref, "refs/tags/" + ref + "^{}", "refs/tags/" + ref}
+	} else if strings.HasPrefix(ref, "refs/tags/") {
+		candidates = []string{ref + "^{}", ref}
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		refs[fields[1]] = fields[0]
+	}
+	for _, c := range candidates {
+		if sha, ok := refs[c]; ok && gitCommitSHARegexp.MatchString(sha) {
+			return sha, nil
+		}
+	}
+	return "", errors.Errorf("git ref %s not found", ref)
+}
+
+// gitCredentialsPath is where the secret which serves the credentials of an
+// https remote is mounted for the git commands run via scripts.
+const gitCredentialsPath = "/run/secrets/earthly-git-credentials"
+
+// gitCredentialsHelper returns the shell code which makes the git commands
+// which follow it read the credentials of the remote's host out of the secret
+// mounted at gitCredentialsPath, via a credential helper, so that they are
+// neither part of the commands nor written to .git/config.
+func gitCredentialsHelper(gitURL string) string {
+	scope := gitURL
+	if u, err := url.Parse(gitURL); err == nil {
+		scope = u.Scheme + "://" + u.Host
+	}
+	helper := fmt.Sprintf(`!f() { test "$1" = get && cat %s; }; f`, gitCredentialsPath)
+	return fmt.Sprintf(`git() { command git -c %s "$@"; }`, shellescape.Quote("credential."+scope+".helper="+helper))
+}
+
+// gitCloneScript returns the shell script which clones the given commit into
+// the current directory.
+func gitCloneScript(gitURL, commit string, auth buildcontext.CloneAuth, opts ConvertGitCloneOpts) string {
+	var depthArg string
+	if opts.Depth != 0 {
+		depthArg = " --depth=" + strconv.Itoa(opts.Depth)
+	}
+	lines := []string{"set -e"}
+	if auth.CredentialsSecretID != "" {
+		lines = append(lines, gitCredentialsHelper(gitURL))
+	}
+	lines = append(lines,
+		"git init -q",
+		"git remote add origin "+shellescape.Quote(gitURL))
+	if opts.LFS {
+		lines = append(lines,
+			"command -v git-lfs >/dev/null || apk add --no-cache -q git-lfs",
+			// Smudging would download the LFS objects of the full tree during
+			// checkout, rather than just the ones in the sparse checkout.
+			"git lfs install --local --skip-smudge >/dev/null")
+	}
+	var filterArg string
+	if len(opts.Sparse) != 0 {
+		quoted := make([]string, 0, len(opts.Sparse))
+		for _, p := range opts.Sparse {
+			quoted = append(quoted, shellescape.Quote(p))
+		}
+		lines = append(lines,
+			"git sparse-checkout init --cone",
+			"git sparse-checkout set "+strings.Join(quoted, " "))
+		filterArg = " --filter=blob:none"
+	}
+	lines = append(lines,
+		fmt.Sprintf("git -c protocol.version=2 fetch -q --no-tags%s%s origin %s", depthArg, filterArg, commit),
+		"git checkout -q "+commit)
+	if opts.RecurseSubmodules {
+		lines = append(lines, "git submodule update -q --init --recursive"+depthArg)
+	}
+	if opts.LFS {
+		lines = append(lines, "git lfs pull")
+		if opts.RecurseSubmodules {
+			lines = append(lines, "git submodule foreach --recursive 'git lfs install --local --skip-smudge >/dev/null && git lfs pull'")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// gitRunOpts returns the run options needed for running git commands against
+// the given remote.
+func gitRunOpts(gitURL string, auth buildcontext.CloneAuth) []llb.RunOption {
+	_, protocol := gitutil.ParseProtocol(gitURL)
+	if protocol != gitutil.SSHProtocol {
+		if auth.CredentialsSecretID == "" {
+			return nil
+		}
+		return []llb.RunOption{
+			llb.AddSecret(gitCredentialsPath, llb.SecretID(auth.CredentialsSecretID), llb.SecretFileOpt(0, 0, 0400)),
+		}
+	}
+	sshID := auth.SSHSockID
+	if sshID == "" {
+		sshID = "default"
+	}
+	hostKeyChecking := "-o StrictHostKeyChecking=accept-new"
+	runOpts := []llb.RunOption{
+		llb.AddSSHSocket(llb.SSHID(sshID), llb.SSHSocketTarget("/run/earthly/ssh-agent.sock"), llb.SSHOptional),
+		llb.AddEnv("SSH_AUTH_SOCK", "/run/earthly/ssh-agent.sock"),
+	}
+	if auth.KeyScan != "" {
+		hostKeyChecking = "-o StrictHostKeyChecking=yes -o UserKnownHostsFile=/run/earthly/known_hosts"
+		knownHosts := llbutil.ScratchWithPlatform().File(
+			pllb.Mkfile("/known_hosts", 0644, []byte(auth.KeyScan)))
+		runOpts = append(runOpts,
+			pllb.AddMount("/run/earthly/known_hosts", knownHosts, llb.SourcePath("/known_hosts"), llb.Readonly))
+	}
+	return append(runOpts, llb.AddEnv("GIT_SSH_COMMAND", "ssh "+hostKeyChecking))
+}
+
+// resolveGitCommit resolves the given ref of the remote to a commit, so that
+// the clone itself can be cached by commit.
+func (c *Converter) resolveGitCommit(ctx context.Context, gitURL string, auth buildcontext.CloneAuth, ref string) (string, error) {
+	if gitCommitSHARegexp.MatchString(ref) {
+		return ref, nil
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	opImg := pllb.Image(
+		buildcontext.DefaultGitImage, llb.MarkImageInternal, llb.ResolveModePreferLocal,
+		llb.Platform(llbutil.DefaultPlatform()))
+	script := fmt.Sprintf("git ls-remote %s >/dest/ls-remote", shellescape.Quote(gitURL))
+	if auth.CredentialsSecretID != "" {
+		script = gitCredentialsHelper(gitURL) + "\n" + script
+	}
+	runOpts := []llb.RunOption{
+		llb.Args([]string{"/bin/sh", "-c", script}),
+		llb.IgnoreCache,
+		llb.WithCustomNamef("[internal] GIT LS-REMOTE %s %s", stringutil.ScrubCredentials(gitURL), ref),
+	}
+	runOpts = append(runOpts, gitRunOpts(gitURL, auth)...)
+	lsRemoteState := opImg.Run(runOpts...).AddMount("/dest", llbutil.ScratchWithPlatform())
+	lsRemoteRef, err := llbutil.StateToRef(ctx, c.opt.GwClient, lsRemoteState, nil, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "state to ref git ls-remote")
+	}
+	output, err := lsRemoteRef.ReadFile(ctx, gwclient.ReadRequest{
+		Filename: "ls-remote",
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "read git ls-remote")
+	}
+	return parseLsRemote(string(output), ref)
+}
+
+// scriptedGitClone clones the repository via git itself, for the options
+// which the buildkit git source does not support.
+func (c *Converter) scriptedGitClone(ctx context.Context, gitURL string, auth buildcontext.CloneAuth, opts ConvertGitCloneOpts) (pllb.State, error) {
+	commit, err := c.resolveGitCommit(ctx, gitURL, auth, opts.Branch)
+	if err != nil {
+		return pllb.State{}, errors.Wrapf(err, "resolve %s", stringutil.ScrubCredentials(gitURL))
+	}
+	opImg := pllb.Image(
+		buildcontext.DefaultGitImage, llb.MarkImageInternal, llb.ResolveModePreferLocal,
+		llb.Platform(llbutil.DefaultPlatform()))
+	runOpts := []llb.RunOption{
+		llb.Args([]string{"/bin/sh", "-c", gitCloneScript(gitURL, commit, auth, opts)}),
+		llb.Dir("/git-src"),
+		llb.WithCustomNamef(
+			"%sGIT CLONE (--branch %s) %s @ %s", c.vertexPrefixWithURL(gitURL), opts.Branch,
+			stringutil.ScrubCredentials(gitURL), commit),
+	}
+	runOpts = append(runOpts, gitRunOpts(gitURL, auth)...)
+	return opImg.Run(runOpts...).AddMount("/git-src", llbutil.ScratchWithPlatform()), nil
+}
@@ -0,0 +1,58 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+)
+
+const (
+	// gpusAll makes all the GPUs of buildkitd visible to the command.
+	gpusAll = "all"
+	// gpuDriverPath is where the NVIDIA driver files are mounted, as per the
+	// convention of the CUDA images.
+	gpuDriverPath = "/usr/local/nvidia"
+	// gpuDriverSourcePath is where the entrypoint of buildkitd collects the
+	// driver files injected by the NVIDIA Container Toolkit.
+	gpuDriverSourcePath = "/tmp/earthly/gpu"
+)
+
+var gpuIDRegex = regexp.MustCompile(`^([0-9]+|(GPU|MIG)-[0-9a-zA-Z/-]+)$`)
+
+// validateGPUs returns an error if the value of RUN --gpus is invalid: all,
+// or comma-separated indexes or UUIDs of GPUs.
+func validateGPUs(gpus string) error {
+	if gpus == gpusAll {
+		return nil
+	}
+	for _, id := range strings.Split(gpus, ",") {
+		if !gpuIDRegex.MatchString(id) {
+			return errors.Errorf("invalid --gpus %s: must be all, or GPU indexes or UUIDs separated by commas", gpus)
+		}
+	}
+	return nil
+}
+
+// gpuRunOpt returns the option of the RUN command which mounts the NVIDIA
+// driver files.
+func gpuRunOpt() llb.RunOption {
+	return pllb.AddMount(gpuDriverPath, pllb.Scratch(), llb.HostBind(), llb.SourcePath(gpuDriverSourcePath), llb.Readonly)
+}
+
+// gpuEnv returns the environment variables, as shell assignments, which make
+// the driver files, and the given GPUs, available to the command.
+func gpuEnv(gpus string) []string {
+	env := []string{
+		fmt.Sprintf("NVIDIA_VISIBLE_DEVICES=%s", gpus),
+		fmt.Sprintf("PATH=\"$PATH:%s/bin\"", gpuDriverPath),
+		fmt.Sprintf("LD_LIBRARY_PATH=\"%s/lib64${LD_LIBRARY_PATH:+:$LD_LIBRARY_PATH}\"", gpuDriverPath),
+	}
+	if gpus != gpusAll {
+		env = append(env, fmt.Sprintf("CUDA_VISIBLE_DEVICES=%s", gpus))
+	}
+	return env
+}
@@ -0,0 +1,28 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateGPUs(t *testing.T) {
+	assert.NoError(t, validateGPUs("all"))
+	assert.NoError(t, validateGPUs("0"))
+	assert.NoError(t, validateGPUs("0,2"))
+	assert.NoError(t, validateGPUs("GPU-3a23c669-1f69-c64e-cf85-44e9b07e7a2a"))
+	assert.NoError(t, validateGPUs("MIG-GPU-3a23c669-1f69-c64e-cf85-44e9b07e7a2a/1/0"))
+	assert.Error(t, validateGPUs(""))
+	assert.Error(t, validateGPUs("0,"))
+	assert.Error(t, validateGPUs("2 GPUs"))
+	assert.Error(t, validateGPUs("0;rm -rf /"))
+}
+
+func TestGPUEnv(t *testing.T) {
+	assert.Equal(t, []string{
+		"NVIDIA_VISIBLE_DEVICES=all",
+		`PATH="$PATH:/usr/local/nvidia/bin"`,
+		`LD_LIBRARY_PATH="/usr/local/nvidia/lib64${LD_LIBRARY_PATH:+:$LD_LIBRARY_PATH}"`,
+	}, gpuEnv("all"))
+	assert.Equal(t, "CUDA_VISIBLE_DEVICES=0,1", gpuEnv("0,1")[3])
+}
@@ -0,0 +1,115 @@
+package earthfile2llb
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+)
+
+var (
+	heredocMarkerRegexp = regexp.MustCompile(`^<<-?(["']?)([A-Za-z_][A-Za-z0-9_.-]*)(["']?)$`)
+	heredocVarRegexp    = regexp.MustCompile(`\$(\{[A-Za-z_][A-Za-z0-9_]*\}|[A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// HeredocFile is a file created from a here-document, as per COPY <<EOF.
+type HeredocFile struct {
+	Name    string
+	Content string
+}
+
+// heredocDelimiter returns the delimiter of a here-document redirection, as
+// in <<EOF, or false if the word is not one.
+func heredocDelimiter(word string) (string, bool) {
+	m := heredocMarkerRegexp.FindStringSubmatch(word)
+	if m == nil || m[1] != m[3] {
+		return "", false
+	}
+	return m[2], true
+}
+
+// withHeredocs returns the args of a RUN command in shell form, with its
+// here-documents appended after the command, so that the shell feeds them to
+// the command as usual. A RUN command consisting of a single here-document
+// executes its content as the script instead: with the shell, or, if the
+// content starts with a shebang, as an executable file.
+func withHeredocs(args []string, heredocs []spec.Heredoc) ([]string, error) {
+	script := strings.Join(args, " ")
+	if len(args) == 1 && len(heredocs) == 1 {
+		if _, ok := heredocDelimiter(args[0]); ok {
+			h := heredocs[0]
+			if !strings.HasPrefix(h.Content, "#!") {
+				return []string{h.Content}, nil
+			}
+			script = fmt.Sprintf(
+				"earthly_heredoc=\"$(mktemp)\" && cat >\"$earthly_heredoc\" %s && chmod +x \"$earthly_heredoc\" && "+
+					"{ \"$earthly_heredoc\"; earthly_code=$?; rm -f \"$earthly_heredoc\"; exit $earthly_code; }", args[0])
+		}
+	}
+	var markers int
+	for _, arg := range args {
+		if _, ok := heredocDelimiter(arg); ok {
+			markers++
+		}
+	}
+	if markers != len(heredocs) {
+		return nil, errors.New("here-document redirections need to be separate words, as in cat <<EOF")
+	}
+	var sb strings.Builder
+	sb.WriteString(script)
+	for _, h := range heredocs {
+		sb.WriteString("\n")
+		sb.WriteString(h.Content)
+		sb.WriteString(h.Delimiter)
+	}
+	return []string{sb.String()}, nil
+}
+
+// ExpandHeredoc expands the ARGs referenced in the content of a here-document,
+// as in $NAME or ${NAME}. Other variables are kept as they are.
+func (c *Converter) ExpandHeredoc(content string) string {
+	return heredocVarRegexp.ReplaceAllStringFunc(content, func(ref string) string {
+		name := strings.Trim(ref, "${}")
+		value, ok := c.varCollection.GetActive(name)
+		if !ok {
+			return ref
+		}
+		return value
+	})
+}
+
+// CopyHeredocs applies the COPY command for files created from
+// here-documents, as in COPY <<EOF /etc/app.conf. The files are cached by
+// their content.
+func (c *Converter) CopyHeredocs(ctx context.Context, files []HeredocFile, dest string, keepTs bool, keepOwn bool, chown string) error {
+	err := c.checkAllowed(copyCmd)
+	if err != nil {
+		return err
+	}
+	c.nonSaveCommand()
+	var fa *pllb.FileAction
+	srcs := make([]string, 0, len(files))
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		p := "/" + f.Name
+		if fa == nil {
+			fa = pllb.Mkfile(p, 0644, []byte(f.Content))
+		} else {
+			fa = fa.Mkfile(p, 0644, []byte(f.Content))
+		}
+		srcs = append(srcs, p)
+		names = append(names, "<<"+f.Name)
+	}
+	srcState := llbutil.ScratchWithPlatform().File(fa,
+		llb.WithCustomNamef("%sHEREDOC %s", c.vertexPrefix(false, false), strings.Join(names, " ")))
+	c.mts.Final.MainState = llbutil.CopyOp(
+		srcState, srcs, c.mts.Final.MainState, dest, false, false, keepTs, c.copyOwner(keepOwn, chown), false, false,
+		llb.WithCustomNamef("%sCOPY %s %s", c.vertexPrefix(false, false), strings.Join(names, " "), dest))
+	return nil
+}
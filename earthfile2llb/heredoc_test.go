@@ -0,0 +1,39 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeredocDelimiter(t *testing.T) {
+	for word, expected := range map[string]string{"<<EOF": "EOF", "<<-EOF": "EOF", `<<"EOF"`: "EOF", "<<'x1'": "x1", "<<nginx.conf": "nginx.conf"} {
+		d, ok := heredocDelimiter(word)
+		assert.True(t, ok, word)
+		assert.Equal(t, expected, d)
+	}
+	for _, word := range []string{"<<<EOF", `<<"EOF'`, "EOF", "<<1a"} {
+		_, ok := heredocDelimiter(word)
+		assert.False(t, ok, word)
+	}
+}
+
+func TestWithHeredocs(t *testing.T) {
+	args, err := withHeredocs([]string{"<<EOF"}, []spec.Heredoc{{Delimiter: "EOF", Content: "set -e\necho hi\n"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"set -e\necho hi\n"}, args)
+
+	args, err = withHeredocs([]string{"python3", "<<'EOF'", ">", "out.txt"}, []spec.Heredoc{{Delimiter: "EOF", Content: "print(1)\n"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"python3 <<'EOF' > out.txt\nprint(1)\nEOF"}, args)
+
+	args, err = withHeredocs([]string{"<<EOF"}, []spec.Heredoc{{Delimiter: "EOF", Content: "#!/usr/bin/env python3\nprint(1)\n"}})
+	assert.NoError(t, err)
+	assert.Len(t, args, 1)
+	assert.Contains(t, args[0], "cat >\"$earthly_heredoc\" <<EOF && chmod +x")
+	assert.Contains(t, args[0], "\n#!/usr/bin/env python3\nprint(1)\nEOF")
+
+	_, err = withHeredocs([]string{"cat", "<<EOF>out.txt"}, []spec.Heredoc{{Delimiter: "EOF", Content: "x\n"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,281 @@
+package earthfile2llb
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/semverutil"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// exprEnv is what native IF expressions are evaluated against.
+type exprEnv struct {
+	// expand expands the args within an operand.
+	expand     func(word string) string
+	platform   specs.Platform
+	fileExists func(p string) (bool, error)
+}
+
+// exprParser evaluates native IF expressions, such as
+//
+//	"$VERSION" >= 1.2.0 && ! exists ./legacy
+//
+// Operators and keywords are matched against the words as written, before any
+// arg expansion, so that an arg value can never be mistaken for an operator.
+type exprParser struct {
+	words []string
+	pos   int
+	env   exprEnv
+}
+
+// evalExpr evaluates a native IF expression, given as the words of the IF
+// command.
+func evalExpr(words []string, env exprEnv) (bool, error) {
+	if len(words) == 0 {
+		return false, errors.New("empty expression")
+	}
+	p := &exprParser{words: words, env: env}
+	ret, err := p.or()
+	if err != nil {
+		return false, err
+	}
+	if p.pos != len(p.words) {
+		return false, errors.Errorf("unexpected %s in expression", p.words[p.pos])
+	}
+	return ret, nil
+}
+
+func (p *exprParser) next() (string, error) {
+	if p.pos >= len(p.words) {
+		return "", errors.New("unexpected end of expression")
+	}
+	w := p.words[p.pos]
+	p.pos++
+	return w, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos >= len(p.words) {
+		return ""
+	}
+	return p.words[p.pos]
+}
+
+func (p *exprParser) or() (bool, error) {
+	ret, err := p.and()
+	if err != nil {
+		return false, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		v, err := p.and()
+		if err != nil {
+			return false, err
+		}
+		ret = ret || v
+	}
+	return ret, nil
+}
+
+func (p *exprParser) and() (bool, error) {
+	ret, err := p.unary()
+	if err != nil {
+		return false, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		v, err := p.unary()
+		if err != nil {
+			return false, err
+		}
+		ret = ret && v
+	}
+	return ret, nil
+}
+
+func (p *exprParser) unary() (bool, error) {
+	w, err := p.next()
+	if err != nil {
+		return false, err
+	}
+	switch w {
+	case "!":
+		v, err := p.unary()
+		return !v, err
+	case "(":
+		v, err := p.or()
+		if err != nil {
+			return false, err
+		}
+		closing, err := p.next()
+		if err != nil || closing != ")" {
+			return false, errors.New("missing ) in expression")
+		}
+		return v, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "exists":
+		operand, err := p.next()
+		if err != nil {
+			return false, err
+		}
+		return p.env.fileExists(p.env.expand(operand))
+	case "platform":
+		operand, err := p.next()
+		if err != nil {
+			return false, err
+		}
+		return platformMatches(p.env.expand(operand), p.env.platform)
+	}
+	op, err := p.next()
+	if err != nil {
+		return false, err
+	}
+	operand, err := p.next()
+	if err != nil {
+		return false, err
+	}
+	return compare(p.env.expand(w), op, p.env.expand(operand))
+}
+
+func compare(a, op, b string) (bool, error) {
+	switch op {
+	case "==":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	case "=~":
+		re, err := regexp.Compile(b)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid regular expression %s", b)
+		}
+		return re.MatchString(a), nil
+	case "<", "<=", ">", ">=":
+		va, err := semverutil.Parse(a)
+		if err != nil {
+			return false, errors.Wrapf(err, "cannot compare %q %s %q", a, op, b)
+		}
+		vb, err := semverutil.Parse(b)
+		if err != nil {
+			return false, errors.Wrapf(err, "cannot compare %q %s %q", a, op, b)
+		}
+		c := semverutil.Compare(va, vb)
+		switch op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	default:
+		return false, errors.Errorf("unknown operator %s in expression", op)
+	}
+}
+
+// platformMatches returns true if the platform p matches the given platform
+// spec, such as linux/arm64. Both are normalized first, as for --platform.
+func platformMatches(spec string, p specs.Platform) (bool, error) {
+	parsed, err := platforms.Parse(spec)
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid platform %s", spec)
+	}
+	return platforms.NewMatcher(parsed).Match(platforms.Normalize(p)), nil
+}
+
+// splitExprWords splits the value of an ARG --expr into the words of the
+// expression. Whitespace within quotes does not separate words, and the quotes
+// are kept, to be removed by the arg expansion, as for the words of IF.
+func splitExprWords(value string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	quote := rune(0)
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+		word.WriteRune(r)
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// EvalExpr evaluates a native expression (IF --expr and ARG --expr). Unlike a
+// regular IF, the expression is evaluated by earthly itself, without running
+// anything in the build environment.
+func (c *Converter) EvalExpr(ctx context.Context, words []string, expand func(word string) string) (bool, error) {
+	return evalExpr(words, exprEnv{
+		expand:     expand,
+		platform:   llbutil.PlatformWithDefault(c.opt.Platform),
+		fileExists: c.contextFileExists,
+	})
+}
+
+// PlatformMatches returns true if the target is being built for any of the
+// given platforms (IF --platform).
+func (c *Converter) PlatformMatches(ctx context.Context, platformSpecs []string) (bool, error) {
+	p := llbutil.PlatformWithDefault(c.opt.Platform)
+	for _, spec := range platformSpecs {
+		ok, err := platformMatches(spec, p)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// contextFileExists returns true if the given path exists within the build
+// context of the current target. Only local build contexts are supported.
+func (c *Converter) contextFileExists(p string) (bool, error) {
+	target := c.mts.Final.Target
+	if target.IsRemote() {
+		return false, errors.Errorf("exists is not supported for the remote target %s", target.String())
+	}
+	cleaned := path.Clean(p)
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return false, errors.Errorf("exists %s: the path must be within the build context", p)
+	}
+	_, err := os.Stat(filepath.Join(filepath.FromSlash(target.GetLocalPath()), filepath.FromSlash(cleaned)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "stat %s", p)
+	}
+	return true, nil
+}
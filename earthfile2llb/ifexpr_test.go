@@ -0,0 +1,63 @@
+package earthfile2llb
+
+import (
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalExpr(t *testing.T) {
+	env := exprEnv{
+		expand: func(word string) string {
+			word = strings.Trim(word, "\"")
+			return strings.NewReplacer("$VERSION", "1.10.0", "$BRANCH", "main", "$OP", "==").Replace(word)
+		},
+		platform: specs.Platform{OS: "linux", Architecture: "arm64"},
+		fileExists: func(p string) (bool, error) {
+			return p == "go.mod", nil
+		},
+	}
+	for _, tc := range []struct {
+		expr     string
+		expected bool
+	}{
+		{`"$BRANCH" == main`, true},
+		{`"$BRANCH" != main`, false},
+		{`"$BRANCH" =~ ^ma`, true},
+		{`"$VERSION" >= 1.9.0`, true},
+		{`"$VERSION" < v1.9`, false},
+		{`exists go.mod`, true},
+		{`! exists package.json`, true},
+		{`platform linux/arm64`, true},
+		{`platform linux/amd64 || platform linux/arm64`, true},
+		{`true && ( false || "$BRANCH" == main )`, true},
+		{`"$VERSION" > 1.0 && false`, false},
+		// Operators are matched before expansion.
+		{`"$OP" == "=="`, true},
+	} {
+		actual, err := evalExpr(strings.Fields(tc.expr), env)
+		assert.NoError(t, err, tc.expr)
+		assert.Equal(t, tc.expected, actual, tc.expr)
+	}
+	for _, expr := range []string{
+		``,
+		`"$BRANCH"`,
+		`"$BRANCH" ==`,
+		`"$BRANCH" === main`,
+		`"$BRANCH" < 1.0`,
+		`( true`,
+		`true false`,
+	} {
+		_, err := evalExpr(strings.Fields(expr), env)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestSplitExprWords(t *testing.T) {
+	assert.Equal(t, []string{`"$VERSION"`, `>=`, `1.2.0`}, splitExprWords(`"$VERSION" >= 1.2.0`))
+	assert.Equal(t, []string{`"$NAME"`, `==`, `"my app"`, `&&`, `!`, `exists`, `'./a b'`}, splitExprWords(` "$NAME"  == "my app" && ! exists './a b' `))
+	assert.Equal(t, []string{`a\ b`, `==`, `"x\"y"`}, splitExprWords(`a\ b == "x\"y"`))
+	assert.Empty(t, splitExprWords(` `))
+}
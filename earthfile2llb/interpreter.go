@@ -5,13 +5,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/earthly/earthly/analytics"
 	"github.com/earthly/earthly/ast/spec"
 	"github.com/earthly/earthly/buildcontext"
+	"github.com/earthly/earthly/capabilities"
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/imagebudget"
+	"github.com/earthly/earthly/imageverify"
 	"github.com/earthly/earthly/util/flagutil"
 	"github.com/earthly/earthly/util/llbutil"
 	"github.com/earthly/earthly/variables"
@@ -47,6 +52,10 @@ type Interpreter struct {
 	parallelErrChan    chan error
 	console            conslogging.ConsoleLogger
 	gitLookup          *buildcontext.GitLookup
+
+	// expandErr is the first error of strict expansion in the current
+	// statement, as per VERSION --strict-expansion.
+	expandErr error
 }
 
 func newInterpreter(c *Converter, t domain.Target, allowPrivileged, parallelConversion bool, parallelism *semaphore.Weighted, console conslogging.ConsoleLogger, gitLookup *buildcontext.GitLookup) *Interpreter {
@@ -97,7 +106,7 @@ func (i *Interpreter) Run(ctx context.Context, ef spec.Earthfile) (err error) {
 
 func (i *Interpreter) handleTarget(ctx context.Context, t spec.Target) error {
 	// Apply implicit FROM +base
-	err := i.converter.From(ctx, "+base", nil, i.allowPrivileged, nil)
+	err := i.converter.From(ctx, "+base", nil, i.allowPrivileged, nil, nil)
 	if err != nil {
 		return i.wrapError(err, t.SourceLocation, "apply FROM")
 	}
@@ -164,6 +173,7 @@ func (i *Interpreter) handleBlockParallel(ctx context.Context, b spec.Block, sta
 }
 
 func (i *Interpreter) handleStatement(ctx context.Context, stmt spec.Statement) error {
+	i.expandErr = nil
 	if stmt.Command != nil {
 		return i.handleCommand(ctx, *stmt.Command)
 	} else if stmt.With != nil {
@@ -177,13 +187,32 @@ func (i *Interpreter) handleStatement(ctx context.Context, stmt spec.Statement)
 	}
 }
 
+// layerCommands are the commands which write to the filesystem of the
+// target, and are recorded as its layers.
+var layerCommands = map[string]bool{
+	"RUN":       true,
+	"COPY":      true,
+	"ADD":       true,
+	"GIT CLONE": true,
+	"WORKDIR":   true,
+}
+
 func (i *Interpreter) handleCommand(ctx context.Context, cmd spec.Command) (err error) {
 	// The AST should not be modified by any operation. This is a consistency check.
 	argsCopy := getArgsCopy(cmd)
+	before := i.converter.mts.Final.MainState
 	defer func() {
 		if err != nil {
 			return
 		}
+		if layerCommands[cmd.Name] {
+			i.converter.recordLayer(strings.TrimSpace(cmd.Name+" "+strings.Join(cmd.Args, " ")), cmd.SourceLocation, before)
+		}
+		if i.expandErr != nil {
+			err = i.wrapError(i.expandErr, cmd.SourceLocation, "strict expansion")
+			i.expandErr = nil
+			return
+		}
 		if len(argsCopy) != len(cmd.Args) {
 			err = i.errorf(cmd.SourceLocation, "internal error: args were modified in command handling")
 			return
@@ -323,6 +352,34 @@ func (i *Interpreter) handleIfExpression(ctx context.Context, expression []strin
 	if err != nil {
 		return false, i.wrapError(err, sl, "invalid IF arguments %v", expression)
 	}
+	if len(opts.Platforms) != 0 {
+		if opts.Expr || opts.Privileged || opts.WithSSH || opts.NoCache || len(opts.Secrets) != 0 || len(opts.Mounts) != 0 {
+			return false, i.errorf(sl, "IF --platform cannot be combined with other IF options")
+		}
+		if len(args) != 0 {
+			return false, i.errorf(sl, "IF --platform does not take a condition")
+		}
+		ret, err := i.converter.PlatformMatches(ctx, i.expandArgsSlice(opts.Platforms, false))
+		if err != nil {
+			return false, i.wrapError(err, sl, "evaluate IF --platform")
+		}
+		return ret, nil
+	}
+	if opts.Expr {
+		if execMode {
+			return false, i.errorf(sl, "IF --expr does not support the exec form")
+		}
+		if opts.Privileged || opts.WithSSH || opts.NoCache || len(opts.Secrets) != 0 || len(opts.Mounts) != 0 {
+			return false, i.errorf(sl, "IF --expr cannot be combined with other IF options")
+		}
+		ret, err := i.converter.EvalExpr(ctx, args, func(word string) string {
+			return i.expandArgs(word, false)
+		})
+		if err != nil {
+			return false, i.wrapError(err, sl, "evaluate IF --expr")
+		}
+		return ret, nil
+	}
 	withShell := !execMode
 
 	for index, s := range opts.Secrets {
@@ -422,6 +479,9 @@ func (i *Interpreter) handleFrom(ctx context.Context, cmd spec.Command) error {
 	if i.pushOnlyAllowed {
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
+	if len(cmd.Args) > 0 && cmd.Args[0] == "NIX" {
+		return i.handleFromNix(ctx, cmd)
+	}
 	opts := fromOpts{}
 	args, err := flagutil.ParseArgs("FROM", &opts, getArgsCopy(cmd))
 	if err != nil {
@@ -448,20 +508,60 @@ func (i *Interpreter) handleFrom(ctx context.Context, cmd spec.Command) error {
 		return i.wrapError(err, cmd.SourceLocation, "parse flag args")
 	}
 	expandedBuildArgs = append(parsedFlagArgs, expandedBuildArgs...)
+	passed, err := passedArgs(i.converter.DeclaredArgs(), opts.PassArgs, i.expandArgsSlice(opts.PassArg, false), expandedBuildArgs)
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid FROM --pass-arg")
+	}
+	expandedBuildArgs = append(passed, expandedBuildArgs...)
 
 	allowPrivileged, err := i.getAllowPrivilegedTarget(imageName, opts.AllowPrivileged)
 	if err != nil {
 		return err
 	}
+	var verify *imageverify.Policy
+	if opts.Verify != "" {
+		policy, err := imageverify.ParsePolicy(i.expandArgs(opts.Verify, false))
+		if err != nil {
+			return i.wrapError(err, cmd.SourceLocation, "invalid FROM --verify")
+		}
+		verify = &policy
+	}
 
 	i.local = false
-	err = i.converter.From(ctx, imageName, platform, allowPrivileged, expandedBuildArgs)
+	err = i.converter.From(ctx, imageName, platform, allowPrivileged, expandedBuildArgs, verify)
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "apply FROM %s", imageName)
 	}
 	return nil
 }
 
+func (i *Interpreter) handleFromNix(ctx context.Context, cmd spec.Command) error {
+	opts := fromNixOpts{}
+	args, err := flagutil.ParseArgs("FROM NIX", &opts, getArgsCopy(cmd)[1:])
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid FROM NIX arguments %v", cmd.Args)
+	}
+	if len(args) != 1 {
+		return i.errorf(cmd.SourceLocation, "invalid number of arguments for FROM NIX: %s", cmd.Args)
+	}
+	flakeDir := i.expandArgs(args[0], false)
+	if strings.Contains(flakeDir, "+") {
+		return i.errorf(cmd.SourceLocation, "FROM NIX requires a directory of the build context, not %s", flakeDir)
+	}
+	opts.Platform = i.expandArgs(opts.Platform, false)
+	platform, err := llbutil.ParsePlatform(opts.Platform)
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "parse platform %s", opts.Platform)
+	}
+
+	i.local = false
+	err = i.converter.FromNix(ctx, flakeDir, i.expandArgs(opts.DevShell, false), i.expandArgs(opts.Image, false), platform)
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "apply FROM NIX %s", flakeDir)
+	}
+	return nil
+}
+
 func (i *Interpreter) getAllowPrivilegedTarget(targetName string, allowPrivileged bool) (bool, error) {
 	if !strings.Contains(targetName, "+") {
 		return false, nil
@@ -511,6 +611,15 @@ func (i *Interpreter) handleRun(ctx context.Context, cmd spec.Command) error {
 		return i.wrapError(err, cmd.SourceLocation, "invalid RUN arguments %v", cmd.Args)
 	}
 	withShell := !cmd.ExecMode
+	if len(cmd.Heredocs) != 0 {
+		if !withShell {
+			return i.errorf(cmd.SourceLocation, "here-documents cannot be used with the exec form of RUN")
+		}
+		args, err = withHeredocs(args, cmd.Heredocs)
+		if err != nil {
+			return i.wrapError(err, cmd.SourceLocation, "invalid RUN here-document")
+		}
+	}
 	if opts.WithDocker {
 		opts.Privileged = true
 	}
@@ -525,9 +634,76 @@ func (i *Interpreter) handleRun(ctx context.Context, cmd spec.Command) error {
 	for index, m := range opts.Mounts {
 		opts.Mounts[index] = i.expandArgs(m, false)
 	}
+	var processMemoryLimit int64
+	if opts.ProcessMemory != "" {
+		processMemoryLimit, err = parseMemoryLimit(i.expandArgs(opts.ProcessMemory, false))
+		if err != nil {
+			return i.wrapError(err, cmd.SourceLocation, "invalid RUN --process-memory")
+		}
+	}
+	var timeout time.Duration
+	if opts.Timeout != "" {
+		timeout, err = parseTimeout(i.expandArgs(opts.Timeout, false))
+		if err != nil {
+			return i.wrapError(err, cmd.SourceLocation, "invalid RUN --timeout")
+		}
+	}
+	var retryDelay time.Duration
+	if opts.RetryDelay != "" {
+		retryDelay, err = time.ParseDuration(i.expandArgs(opts.RetryDelay, false))
+		if err != nil {
+			return i.wrapError(err, cmd.SourceLocation, "invalid RUN --retry-delay")
+		}
+	}
+	if opts.Retries < 0 || retryDelay < 0 {
+		return i.errorf(cmd.SourceLocation, "RUN --retries and --retry-delay must not be negative")
+	}
+	if retryDelay != 0 && opts.Retries == 0 {
+		return i.errorf(cmd.SourceLocation, "RUN --retry-delay requires --retries")
+	}
+	dns := DNSConfig{
+		Nameservers:   i.expandArgsSlice(opts.DNS, false),
+		SearchDomains: i.expandArgsSlice(opts.DNSSearch, false),
+		ExtraHosts:    i.expandArgsSlice(opts.AddHost, false),
+	}
+	security, err := ParseRunSecurity(
+		i.expandArgsSlice(opts.CapAdd, false),
+		i.expandArgsSlice(opts.CapDrop, false),
+		i.expandArgsSlice(opts.SecurityOpt, false))
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid RUN security options")
+	}
+	gpus := i.expandArgs(opts.GPUs, false)
+	if gpus != "" {
+		err = validateGPUs(gpus)
+		if err != nil {
+			return i.wrapError(err, cmd.SourceLocation, "invalid RUN --gpus")
+		}
+	}
+	services, err := ParseServices(i.expandArgsSlice(opts.Services, false))
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid RUN --service")
+	}
+	tests, err := newRerunSpec(i.expandArgsSlice(opts.JUnit, false), opts.RerunFailed, i.expandArgsSlice(opts.Quarantine, false))
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid RUN --junit")
+	}
+	if len(tests.Reports) != 0 && opts.Retries != 0 {
+		return i.errorf(cmd.SourceLocation, "RUN --junit cannot be combined with --retries")
+	}
+	var shell []string
+	if opts.Shell != "" {
+		if !withShell {
+			return i.errorf(cmd.SourceLocation, "RUN --shell cannot be used with the exec form")
+		}
+		shell, err = ParseRunShell(i.expandArgs(opts.Shell, false))
+		if err != nil {
+			return i.wrapError(err, cmd.SourceLocation, "invalid RUN --shell")
+		}
+	}
 	// Note: Not expanding args for the run itself, as that will be take care of by the shell.
 
-	if opts.Privileged && !i.allowPrivileged {
+	if (opts.Privileged || security.requiresPrivileged() || gpus != "") && !i.allowPrivileged {
 		return i.errorf(cmd.SourceLocation, "Permission denied: unwilling to run privileged command; did you reference a remote Earthfile without the --allow-privileged flag?")
 	}
 
@@ -542,6 +718,7 @@ func (i *Interpreter) handleRun(ctx context.Context, cmd spec.Command) error {
 			Mounts:          opts.Mounts,
 			Secrets:         opts.Secrets,
 			WithShell:       withShell,
+			Shell:           shell,
 			WithEntrypoint:  opts.WithEntrypoint,
 			Privileged:      opts.Privileged,
 			Push:            opts.Push,
@@ -549,6 +726,15 @@ func (i *Interpreter) handleRun(ctx context.Context, cmd spec.Command) error {
 			NoCache:         opts.NoCache,
 			Interactive:     opts.Interactive,
 			InteractiveKeep: opts.InteractiveKeep,
+			ProcessMemory:   processMemoryLimit,
+			Timeout:         timeout,
+			Retries:         opts.Retries,
+			RetryDelay:      retryDelay,
+			DNS:             dns,
+			Security:        security,
+			GPUs:            gpus,
+			Services:        services,
+			Tests:           tests,
 		}
 		err = i.converter.Run(ctx, opts)
 		if err != nil {
@@ -564,13 +750,35 @@ func (i *Interpreter) handleRun(ctx context.Context, cmd spec.Command) error {
 		if opts.Push {
 			return i.errorf(cmd.SourceLocation, "RUN --push not allowed in WITH DOCKER")
 		}
+		if processMemoryLimit != 0 {
+			return i.errorf(cmd.SourceLocation, "RUN --process-memory not allowed in WITH DOCKER")
+		}
+		if timeout != 0 {
+			return i.errorf(cmd.SourceLocation, "RUN --timeout not allowed in WITH DOCKER")
+		}
+		if opts.Retries != 0 {
+			return i.errorf(cmd.SourceLocation, "RUN --retries not allowed in WITH DOCKER")
+		}
+		if !dns.Empty() {
+			return i.errorf(cmd.SourceLocation, "RUN --dns, --dns-search and --add-host not allowed in WITH DOCKER")
+		}
+		if len(services) != 0 {
+			return i.errorf(cmd.SourceLocation, "RUN --service not allowed in WITH DOCKER; use --compose or --load instead")
+		}
+		if len(tests.Reports) != 0 {
+			return i.errorf(cmd.SourceLocation, "RUN --junit not allowed in WITH DOCKER")
+		}
 		i.withDocker.Mounts = opts.Mounts
 		i.withDocker.Secrets = opts.Secrets
 		i.withDocker.WithShell = withShell
+		i.withDocker.Shell = shell
 		i.withDocker.WithEntrypoint = opts.WithEntrypoint
+		i.withDocker.Privileged = opts.Privileged
 		i.withDocker.NoCache = opts.NoCache
 		i.withDocker.Interactive = opts.Interactive
 		i.withDocker.interactiveKeep = opts.InteractiveKeep
+		i.withDocker.Security = security
+		i.withDocker.GPUs = gpus
 
 		if i.local {
 			err = i.converter.WithDockerRunLocal(ctx, args, *i.withDocker)
@@ -637,13 +845,37 @@ func (i *Interpreter) handleLocally(ctx context.Context, cmd spec.Command) error
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
 
+	opts := locallyOpts{}
+	args, err := flagutil.ParseArgs("LOCALLY", &opts, getArgsCopy(cmd))
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid LOCALLY arguments %v", cmd.Args)
+	}
+	if len(args) != 0 {
+		return i.errorf(cmd.SourceLocation, "invalid number of arguments for LOCALLY: %s", cmd.Args)
+	}
+
 	workingDir, err := filepath.Abs(filepath.Dir(cmd.SourceLocation.File))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "unable to get abs path in LOCALLY")
 	}
 
+	var declared []capabilities.Capability
+	for _, p := range opts.DeclareFS {
+		c, err := capabilities.Parse(fmt.Sprintf("%s=%s", capabilities.KindFS, i.expandArgs(p, false)), workingDir)
+		if err != nil {
+			return i.wrapError(err, cmd.SourceLocation, "invalid LOCALLY --declare-fs %s", p)
+		}
+		declared = append(declared, c)
+	}
+	if opts.DeclareNetwork {
+		declared = append(declared, capabilities.Capability{Kind: capabilities.KindNetwork})
+	}
+	if opts.DeclareDocker {
+		declared = append(declared, capabilities.Capability{Kind: capabilities.KindDocker})
+	}
+
 	i.local = true
-	err = i.converter.Locally(ctx, workingDir, nil)
+	err = i.converter.Locally(ctx, workingDir, nil, declared, i.expandArgs(opts.Shell, false), i.expandArgs(opts.Runner, false), i.expandArgs(opts.Keychain, false), i.expandArgs(opts.KeychainPassword, false))
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "apply LOCALLY")
 	}
@@ -665,6 +897,12 @@ func (i *Interpreter) handleCopy(ctx context.Context, cmd spec.Command) error {
 	if opts.From != "" {
 		return i.errorf(cmd.SourceLocation, "COPY --from not implemented. Use COPY artifacts form instead")
 	}
+	if opts.FromContext != "" {
+		return i.handleCopyFromContext(ctx, cmd, opts, args)
+	}
+	if len(cmd.Heredocs) != 0 {
+		return i.handleCopyHeredocs(ctx, cmd, opts, args)
+	}
 	srcs := args[:len(args)-1]
 	srcFlagArgs := make([][]string, len(srcs))
 	dest := i.expandArgs(args[len(args)-1], false)
@@ -675,6 +913,18 @@ func (i *Interpreter) handleCopy(ctx context.Context, cmd spec.Command) error {
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "parse platform %s", opts.Platform)
 	}
+	for _, src := range srcs {
+		if !isDownloadURL(i.expandArgs(src, false)) {
+			continue
+		}
+		if len(srcs) != 1 {
+			return i.errorf(cmd.SourceLocation, "a URL cannot be combined with other sources in a single COPY command: %v", cmd.Args)
+		}
+		return i.handleCopyDownload(ctx, cmd, opts, i.expandArgs(src, false), dest)
+	}
+	if opts.Checksum != "" || opts.AuthSecret != "" {
+		return i.errorf(cmd.SourceLocation, "COPY --checksum and --auth-secret can only be used when copying from a URL")
+	}
 
 	allClassical := true
 	allArtifacts := true
@@ -708,6 +958,14 @@ func (i *Interpreter) handleCopy(ctx context.Context, cmd spec.Command) error {
 	if !allClassical && !allArtifacts {
 		return i.errorf(cmd.SourceLocation, "combining artifacts and build context arguments in a single COPY command is not allowed: %v", srcs)
 	}
+	if opts.Stream {
+		if !allArtifacts || len(srcs) != 1 || i.local {
+			return i.errorf(cmd.SourceLocation, "COPY --stream requires a single artifact source and cannot be used in LOCALLY targets: %v", cmd.Args)
+		}
+		if opts.IsDirCopy || opts.KeepTs || opts.KeepOwn || opts.Chown != "" || opts.IfExists || opts.SymlinkNoFollow {
+			return i.errorf(cmd.SourceLocation, "COPY --stream does not support --dir, --keep-ts, --keep-own, --chown, --if-exists or --symlink-no-follow: %v", cmd.Args)
+		}
+	}
 	if allArtifacts {
 		if dest == "" || dest == "." || len(srcs) > 1 {
 			dest += string("/") // TODO needs to be the containers platform, not the earthly hosts platform. For now, this is always Linux.
@@ -725,7 +983,12 @@ func (i *Interpreter) handleCopy(ctx context.Context, cmd spec.Command) error {
 			}
 			srcBuildArgs := append(parsedFlagArgs, expandedBuildArgs...)
 
-			if i.local {
+			if opts.Stream {
+				err = i.converter.CopyArtifactStream(ctx, src, dest, platform, allowPrivileged, srcBuildArgs)
+				if err != nil {
+					return i.wrapError(err, cmd.SourceLocation, "copy artifact stream")
+				}
+			} else if i.local {
 				err = i.converter.CopyArtifactLocal(ctx, src, dest, platform, allowPrivileged, srcBuildArgs, opts.IsDirCopy)
 				if err != nil {
 					return i.wrapError(err, cmd.SourceLocation, "copy artifact locally")
@@ -745,7 +1008,7 @@ func (i *Interpreter) handleCopy(ctx context.Context, cmd spec.Command) error {
 			return i.errorf(cmd.SourceLocation, "unhandled locally artifact copy when allArtifacts is false")
 		}
 
-		err = i.converter.CopyClassical(ctx, srcs, dest, opts.IsDirCopy, opts.KeepTs, opts.KeepOwn, opts.Chown)
+		err = i.converter.CopyClassical(ctx, srcs, dest, opts.IsDirCopy, opts.KeepTs, opts.KeepOwn, opts.Chown, opts.IfExists)
 		if err != nil {
 			return i.wrapError(err, cmd.SourceLocation, "copy classical")
 		}
@@ -753,6 +1016,75 @@ func (i *Interpreter) handleCopy(ctx context.Context, cmd spec.Command) error {
 	return nil
 }
 
+func (i *Interpreter) handleCopyFromContext(ctx context.Context, cmd spec.Command, opts copyOpts, args []string) error {
+	if i.local {
+		return i.errorf(cmd.SourceLocation, "COPY --from-context is not supported in LOCALLY targets")
+	}
+	if len(opts.BuildArgs) != 0 || opts.Platform != "" || opts.Stream || opts.Checksum != "" || opts.AuthSecret != "" {
+		return i.errorf(cmd.SourceLocation, "COPY --from-context does not support --build-arg, --platform, --stream, --checksum or --auth-secret %v", cmd.Args)
+	}
+	srcs := i.expandArgsSlice(args[:len(args)-1], false)
+	dest := i.expandArgs(args[len(args)-1], false)
+	contextName := i.expandArgs(opts.FromContext, false)
+	err := i.converter.CopyFromContext(
+		ctx, contextName, srcs, dest, opts.IsDirCopy, opts.KeepTs, opts.KeepOwn, i.expandArgs(opts.Chown, false), opts.IfExists)
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "copy from context")
+	}
+	return nil
+}
+
+func (i *Interpreter) handleCopyHeredocs(ctx context.Context, cmd spec.Command, opts copyOpts, args []string) error {
+	if i.local {
+		return i.errorf(cmd.SourceLocation, "COPY of here-documents is not supported in LOCALLY targets")
+	}
+	if opts.IsDirCopy || len(opts.BuildArgs) != 0 || opts.Platform != "" || opts.IfExists || opts.SymlinkNoFollow ||
+		opts.AllowPrivileged || opts.Stream || opts.Checksum != "" || opts.AuthSecret != "" {
+		return i.errorf(cmd.SourceLocation, "COPY of here-documents only supports --chown, --keep-ts and --keep-own %v", cmd.Args)
+	}
+	srcs := args[:len(args)-1]
+	if len(srcs) != len(cmd.Heredocs) {
+		return i.errorf(cmd.SourceLocation, "here-documents cannot be combined with other sources in a single COPY command: %v", cmd.Args)
+	}
+	files := make([]HeredocFile, 0, len(srcs))
+	for index, src := range srcs {
+		h := cmd.Heredocs[index]
+		if delimiter, ok := heredocDelimiter(src); !ok || delimiter != h.Delimiter {
+			return i.errorf(cmd.SourceLocation, "here-documents cannot be combined with other sources in a single COPY command: %v", cmd.Args)
+		}
+		content := h.Content
+		if h.Expand {
+			content = i.converter.ExpandHeredoc(content)
+		}
+		files = append(files, HeredocFile{Name: h.Delimiter, Content: content})
+	}
+	dest := i.expandArgs(args[len(args)-1], false)
+	err := i.converter.CopyHeredocs(ctx, files, dest, opts.KeepTs, opts.KeepOwn, i.expandArgs(opts.Chown, false))
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "copy here-documents")
+	}
+	return nil
+}
+
+func (i *Interpreter) handleCopyDownload(ctx context.Context, cmd spec.Command, opts copyOpts, downloadURL, dest string) error {
+	if i.local {
+		return i.errorf(cmd.SourceLocation, "COPY from a URL is not supported in LOCALLY targets")
+	}
+	if opts.Checksum == "" {
+		return i.errorf(cmd.SourceLocation, "COPY from a URL requires --checksum sha256:<hex> %v", cmd.Args)
+	}
+	if opts.IsDirCopy || len(opts.BuildArgs) != 0 || opts.Platform != "" || opts.IfExists || opts.Stream {
+		return i.errorf(cmd.SourceLocation, "COPY from a URL does not support --dir, --build-arg, --platform, --if-exists or --stream %v", cmd.Args)
+	}
+	err := i.converter.CopyDownload(
+		ctx, downloadURL, i.expandArgs(opts.Checksum, false), i.expandArgs(opts.AuthSecret, false),
+		dest, opts.KeepTs, opts.KeepOwn, opts.Chown)
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "copy download")
+	}
+	return nil
+}
+
 func (i *Interpreter) handleSaveArtifact(ctx context.Context, cmd spec.Command) error {
 	opts := saveArtifactOpts{}
 	args, err := flagutil.ParseArgs("SAVE ARTIFACT", &opts, getArgsCopy(cmd))
@@ -817,6 +1149,19 @@ func (i *Interpreter) handleSaveImage(ctx context.Context, cmd spec.Command) err
 	if opts.Push && len(args) == 0 {
 		return i.errorf(cmd.SourceLocation, "invalid number of arguments for SAVE IMAGE --push: %v", cmd.Args)
 	}
+	budget := imagebudget.Budget{MaxFiles: opts.MaxFiles}
+	if opts.MaxSize != "" {
+		budget.MaxSize, err = imagebudget.ParseSize(i.expandArgs(opts.MaxSize, false))
+		if err != nil {
+			return i.wrapError(err, cmd.SourceLocation, "invalid SAVE IMAGE --max-size")
+		}
+	}
+	if opts.MaxFiles < 0 {
+		return i.errorf(cmd.SourceLocation, "invalid SAVE IMAGE --max-files %d, it must be positive", opts.MaxFiles)
+	}
+	if budget.IsSet() && len(args) == 0 {
+		return i.errorf(cmd.SourceLocation, "SAVE IMAGE --max-size and --max-files require an image name: %v", cmd.Args)
+	}
 
 	imageNames := args
 	for index, img := range imageNames {
@@ -826,7 +1171,7 @@ func (i *Interpreter) handleSaveImage(ctx context.Context, cmd spec.Command) err
 		fmt.Fprintf(os.Stderr, "Deprecation: using SAVE IMAGE with no arguments is no longer necessary and can be safely removed\n")
 		return nil
 	}
-	err = i.converter.SaveImage(ctx, imageNames, opts.Push, opts.Insecure, opts.CacheHint, opts.CacheFrom)
+	err = i.converter.SaveImage(ctx, imageNames, opts.Push, opts.Insecure, opts.CacheHint, opts.CacheFrom, budget)
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "save image")
 	}
@@ -861,6 +1206,11 @@ func (i *Interpreter) handleBuild(ctx context.Context, cmd spec.Command, async b
 	if async && !isSafeAsyncBuildArgs(opts.BuildArgs) {
 		return errCannotAsync
 	}
+	importArgs := splitNames(i.expandArgsSlice(opts.ImportArg, false))
+	if async && len(importArgs) != 0 {
+		// Subsequent commands depend on the imported values.
+		return errCannotAsync
+	}
 	expandedBuildArgs := i.expandArgsSlice(opts.BuildArgs, true)
 	expandedFlagArgs := i.expandArgsSlice(args[1:], true)
 	parsedFlagArgs, err := variables.ParseFlagArgs(expandedFlagArgs)
@@ -868,6 +1218,11 @@ func (i *Interpreter) handleBuild(ctx context.Context, cmd spec.Command, async b
 		return i.wrapError(err, cmd.SourceLocation, "parse flag args")
 	}
 	expandedBuildArgs = append(parsedFlagArgs, expandedBuildArgs...)
+	passed, err := passedArgs(i.converter.DeclaredArgs(), opts.PassArgs, i.expandArgsSlice(opts.PassArg, false), expandedBuildArgs)
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid BUILD --pass-arg")
+	}
+	expandedBuildArgs = append(passed, expandedBuildArgs...)
 	if len(platformsSlice) == 0 {
 		platformsSlice = []*specs.Platform{nil}
 	}
@@ -876,19 +1231,45 @@ func (i *Interpreter) handleBuild(ctx context.Context, cmd spec.Command, async b
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "build arg matrix")
 	}
+	if opts.Shards != "" {
+		shardArgs, err := shardBuildArgs(i.expandArgs(opts.Shards, false), splitShardItems(i.expandArgsSlice(opts.ShardItems, false)), i.expandArgs(opts.ShardWeights, false))
+		if err != nil {
+			return i.wrapError(err, cmd.SourceLocation, "invalid BUILD --shards")
+		}
+		var sharded [][]string
+		for _, bas := range crossProductBuildArgs {
+			for _, sa := range shardArgs {
+				sharded = append(sharded, append(append([]string{}, bas...), sa...))
+			}
+		}
+		crossProductBuildArgs = sharded
+	} else if len(opts.ShardItems) != 0 || opts.ShardWeights != "" {
+		return i.errorf(cmd.SourceLocation, "BUILD --shard-items and --shard-weights require --shards")
+	}
+	if len(importArgs) != 0 && len(crossProductBuildArgs)*len(platformsSlice) != 1 {
+		return i.errorf(cmd.SourceLocation, "BUILD --import-arg cannot be used when building multiple platforms or build arg values")
+	}
 
 	allowPrivileged, err := i.getAllowPrivilegedTarget(fullTargetName, opts.AllowPrivileged)
 	if err != nil {
 		return err
 	}
 
+	var timeout time.Duration
+	if opts.Timeout != "" {
+		timeout, err = parseTimeout(i.expandArgs(opts.Timeout, false))
+		if err != nil {
+			return i.wrapError(err, cmd.SourceLocation, "invalid BUILD --timeout")
+		}
+	}
+
 	for _, bas := range crossProductBuildArgs {
 		for _, platform := range platformsSlice {
 			if async {
-				errChan := i.converter.BuildAsync(ctx, fullTargetName, platform, allowPrivileged, bas, buildCmd)
+				errChan := i.converter.BuildAsync(ctx, fullTargetName, platform, allowPrivileged, opts.NativeBuild, timeout, bas, buildCmd)
 				i.monitorErrChan(ctx, errChan)
 			} else {
-				err = i.converter.Build(ctx, fullTargetName, platform, allowPrivileged, bas)
+				err = i.converter.Build(ctx, fullTargetName, platform, allowPrivileged, opts.NativeBuild, timeout, bas, importArgs)
 				if err != nil {
 					return i.wrapError(err, cmd.SourceLocation, "apply BUILD %s", fullTargetName)
 				}
@@ -917,11 +1298,19 @@ func (i *Interpreter) handleUser(ctx context.Context, cmd spec.Command) error {
 	if i.pushOnlyAllowed {
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
-	if len(cmd.Args) != 1 {
+	opts := userOpts{}
+	args, err := flagutil.ParseArgs("USER", &opts, getArgsCopy(cmd))
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid USER arguments %v", cmd.Args)
+	}
+	if len(args) != 1 && !(opts.NonRoot && len(args) == 0) {
 		return i.errorf(cmd.SourceLocation, "invalid number of arguments for USER: %v", cmd.Args)
 	}
-	user := i.expandArgs(cmd.Args[0], false)
-	err := i.converter.User(ctx, user)
+	user := ""
+	if len(args) == 1 {
+		user = i.expandArgs(args[0], false)
+	}
+	err = i.converter.User(ctx, user, opts.NonRoot)
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "apply USER")
 	}
@@ -1028,22 +1417,55 @@ func (i *Interpreter) handleArg(ctx context.Context, cmd spec.Command) error {
 	if i.pushOnlyAllowed {
 		return i.pushOnlyErr(cmd.SourceLocation)
 	}
+	opts := argOpts{}
+	args, err := flagutil.ParseArgs("ARG", &opts, getArgsCopy(cmd))
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid ARG arguments %v", cmd.Args)
+	}
+	if opts.Host && !i.local {
+		return i.errorf(cmd.SourceLocation, "ARG --host can only be used in LOCALLY targets")
+	}
+	if opts.Host && len(opts.Secrets) != 0 {
+		return i.errorf(cmd.SourceLocation, "ARG --host cannot be combined with --secret, as secrets are not available to commands run on the host")
+	}
+	if opts.Expr && (opts.Host || len(opts.Secrets) != 0) {
+		return i.errorf(cmd.SourceLocation, "ARG --expr cannot be combined with --host or --secret")
+	}
 	var key, value string
-	switch len(cmd.Args) {
+	switch len(args) {
 	case 3:
-		if cmd.Args[1] != "=" {
+		if args[1] != "=" {
 			return i.errorf(cmd.SourceLocation, "invalid syntax")
 		}
-		value = i.expandArgs(cmd.Args[2], true)
+		if opts.Expr {
+			ret, err := i.converter.EvalExpr(ctx, splitExprWords(args[2]), func(word string) string {
+				return i.expandArgs(word, false)
+			})
+			if err != nil {
+				return i.wrapError(err, cmd.SourceLocation, "evaluate ARG --expr")
+			}
+			value = strconv.FormatBool(ret)
+		} else {
+			value = i.expandArgs(args[2], true)
+		}
 		fallthrough
 	case 1:
-		key = cmd.Args[0] // Note: Not expanding args for key.
+		key = args[0] // Note: Not expanding args for key.
 	default:
 		return i.errorf(cmd.SourceLocation, "invalid syntax")
 	}
+	if opts.Expr && len(args) != 3 {
+		return i.errorf(cmd.SourceLocation, "ARG --expr requires an expression as the default value")
+	}
+	if (opts.Host || len(opts.Secrets) != 0) && !strings.HasPrefix(value, "$(") {
+		return i.errorf(cmd.SourceLocation, "ARG --host and --secret require a $(...) default value")
+	}
+	for index, s := range opts.Secrets {
+		opts.Secrets[index] = i.expandArgs(s, true)
+	}
 	// Args declared in the base target are global.
 	global := i.isBase
-	err := i.converter.Arg(ctx, key, value, global)
+	err = i.converter.Arg(ctx, key, value, global, ExpressionOpts{Host: opts.Host, Secrets: opts.Secrets})
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "apply ARG")
 	}
@@ -1103,12 +1525,34 @@ func (i *Interpreter) handleGitClone(ctx context.Context, cmd spec.Command) erro
 	gitCloneDest := i.expandArgs(args[1], false)
 	opts.Branch = i.expandArgs(opts.Branch, false)
 
-	convertedGitURL, _, err := i.gitLookup.ConvertCloneURL(gitURL)
+	if opts.Depth < 0 {
+		return i.errorf(cmd.SourceLocation, "invalid GIT CLONE --depth %d", opts.Depth)
+	}
+	var sparse []string
+	for _, s := range opts.Sparse {
+		for _, p := range strings.Split(i.expandArgs(s, false), ",") {
+			p = strings.Trim(strings.TrimSpace(p), "/")
+			if p == "" || p == "." || p == ".." || strings.HasPrefix(p, "../") {
+				return i.errorf(cmd.SourceLocation, "invalid GIT CLONE --sparse path %q; paths must be directories within the repository", s)
+			}
+			sparse = append(sparse, p)
+		}
+	}
+
+	convertedGitURL, auth, err := i.gitLookup.ConvertCloneURL(gitURL)
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "unable to use %v with configured earthly credentials from ~/.earthly/config.yml", cmd.Args)
 	}
 
-	err = i.converter.GitClone(ctx, convertedGitURL, opts.Branch, gitCloneDest, opts.KeepTs)
+	err = i.converter.GitClone(ctx, convertedGitURL, auth, ConvertGitCloneOpts{
+		Branch:            opts.Branch,
+		Dest:              gitCloneDest,
+		KeepTs:            opts.KeepTs,
+		Depth:             opts.Depth,
+		Sparse:            sparse,
+		RecurseSubmodules: opts.RecurseSubmodules,
+		LFS:               opts.LFS,
+	})
 	if err != nil {
 		return i.wrapError(err, cmd.SourceLocation, "git clone")
 	}
@@ -1190,9 +1634,36 @@ func (i *Interpreter) handleWithDocker(ctx context.Context, cmd spec.Command) er
 		opts.Pulls[index] = i.expandArgs(p, false)
 	}
 
+	waitForPorts := i.expandArgsSlice(opts.WaitForPorts, false)
+	waitForHTTP := i.expandArgsSlice(opts.WaitForHTTP, false)
+	err = validateAwait(waitForPorts, waitForHTTP)
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid WITH DOCKER arguments")
+	}
+	waitTimeout := defaultAwaitTimeout
+	if opts.WaitTimeout != "" {
+		waitTimeout, err = time.ParseDuration(i.expandArgs(opts.WaitTimeout, false))
+		if err != nil || waitTimeout <= 0 {
+			return i.errorf(cmd.SourceLocation, "invalid WITH DOCKER --wait-timeout %s", opts.WaitTimeout)
+		}
+	}
+	volumes, err := ParseVolumes(i.expandArgsSlice(opts.Volumes, false))
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid WITH DOCKER --volume")
+	}
+	if opts.Setup != "" && len(volumes) != 0 {
+		return i.errorf(cmd.SourceLocation, "WITH DOCKER --setup and --volume cannot be used together")
+	}
+
 	i.withDocker = &WithDockerOpt{
 		ComposeFiles:    opts.ComposeFiles,
 		ComposeServices: opts.ComposeServices,
+		WaitForPorts:    waitForPorts,
+		WaitForHTTP:     waitForHTTP,
+		WaitTimeout:     waitTimeout,
+		StreamLogs:      opts.StreamLogs,
+		Volumes:         volumes,
+		Setup:           i.expandArgs(opts.Setup, false),
 	}
 	for _, pullStr := range opts.Pulls {
 		i.withDocker.Pulls = append(i.withDocker.Pulls, DockerPullOpt{
@@ -1241,7 +1712,18 @@ func (i *Interpreter) handleOnbuild(ctx context.Context, cmd spec.Command) error
 }
 
 func (i *Interpreter) handleShell(ctx context.Context, cmd spec.Command) error {
-	return i.errorf(cmd.SourceLocation, "command SHELL not yet supported")
+	if i.pushOnlyAllowed {
+		return i.pushOnlyErr(cmd.SourceLocation)
+	}
+	shell, err := parseShellArgs(getArgsCopy(cmd))
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "invalid SHELL arguments %v", cmd.Args)
+	}
+	err = i.converter.Shell(ctx, i.expandArgsSlice(shell, false))
+	if err != nil {
+		return i.wrapError(err, cmd.SourceLocation, "apply SHELL")
+	}
+	return nil
 }
 
 func (i *Interpreter) handleUserCommand(ctx context.Context, cmd spec.Command) error {
@@ -1378,6 +1860,9 @@ func (i *Interpreter) pushOnlyErr(sl *spec.SourceLocation) error {
 }
 
 func (i *Interpreter) expandArgs(word string, keepPlusEscape bool) string {
+	if i.expandErr == nil {
+		i.expandErr = i.converter.CheckExpansion(word)
+	}
 	ret := i.converter.ExpandArgs(escapeSlashPlus(word))
 	if keepPlusEscape {
 		return ret
@@ -1553,6 +2038,62 @@ func parseParans(str string) (string, []string, error) {
 	return parts[0], parts[1:], nil
 }
 
+// passedArgs returns the build args passed on to a referenced target via
+// --pass-args (all the declared args) or --pass-arg (the given ones). Args
+// which are set explicitly in buildArgs take precedence and are left out. The
+// returned args carry no value, such that the value is taken as is from the
+// current target, rather than being evaluated again.
+func passedArgs(declared []string, all bool, names []string, buildArgs []string) ([]string, error) {
+	if !all && len(names) == 0 {
+		return nil, nil
+	}
+	isDeclared := make(map[string]bool)
+	for _, name := range declared {
+		isDeclared[name] = true
+	}
+	selected := declared
+	if !all {
+		selected = splitNames(names)
+		for _, name := range selected {
+			if !isDeclared[name] {
+				return nil, errors.Errorf("arg %s is not declared in the current target", name)
+			}
+		}
+	}
+	skip := make(map[string]bool)
+	for _, arg := range buildArgs {
+		k, _, err := parseKeyValue(arg)
+		if err != nil {
+			return nil, err
+		}
+		skip[k] = true
+	}
+	var ret []string
+	for _, name := range selected {
+		if skip[name] {
+			continue
+		}
+		skip[name] = true
+		ret = append(ret, name)
+	}
+	return ret, nil
+}
+
+// splitNames splits comma-separated lists of names, as accepted by flags which
+// may be repeated or comma-separated.
+func splitNames(values []string) []string {
+	var ret []string
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				ret = append(ret, name)
+			}
+		}
+	}
+	return ret
+}
+
 func isSafeAsyncBuildArgs(args []string) bool {
 	for _, arg := range args {
 		_, v, _ := variables.ParseKeyValue(arg)
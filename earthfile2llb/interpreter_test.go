@@ -29,6 +29,24 @@ func TestBuildArgMatrix(t *testing.T) {
 	}
 }
 
+func TestPassedArgs(t *testing.T) {
+	declared := []string{"A", "B", "C"}
+	ans, err := passedArgs(declared, false, nil, []string{"A=1"})
+	assert.NoError(t, err)
+	assert.Nil(t, ans)
+
+	ans, err = passedArgs(declared, true, nil, []string{"B=2", "B=3", "D=4"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A", "C"}, ans)
+
+	ans, err = passedArgs(declared, false, []string{"C,A", "C"}, []string{"A"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"C"}, ans)
+
+	_, err = passedArgs(declared, false, []string{"D"}, nil)
+	assert.Error(t, err)
+}
+
 func TestParseParans(t *testing.T) {
 	var tests = []struct {
 		in    string
@@ -0,0 +1,117 @@
+package earthfile2llb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/pkg/errors"
+)
+
+// runnerKeychainName is the file name of the keychain of a target, within
+// the directory of the target on the runner.
+const runnerKeychainName = "earthly.keychain"
+
+// runnerKeychainEnv is the environment variable which holds the path of the
+// keychain of a target, for the RUN commands of the target on the runner.
+const runnerKeychainEnv = "EARTHLY_KEYCHAIN"
+
+// importKeychainScript imports the signing identity of .earthly-keychain into
+// a keychain of its own, within the directory of the target, and adds it to
+// the search list of the user, so that codesign and xcodebuild find it. The
+// keychain is recreated with a new password on every build, locks itself
+// after an hour, and is removed by removeKeychainScript once the build is
+// done.
+const importKeychainScript = `set -e
+trap 'rm -rf .earthly-keychain' EXIT
+kc="$PWD/` + runnerKeychainName + `"
+kp="$(cat .earthly-keychain/keychain.password)"
+security delete-keychain "$kc" >/dev/null 2>&1 || true
+security create-keychain -p "$kp" "$kc"
+security set-keychain-settings -lut 3600 "$kc"
+security unlock-keychain -p "$kp" "$kc"
+security import .earthly-keychain/identity.p12 -k "$kc" -P "$(cat .earthly-keychain/identity.password)" -T /usr/bin/codesign -T /usr/bin/productsign -T /usr/bin/security
+security set-key-partition-list -S apple-tool:,apple:,codesign: -s -k "$kp" "$kc" >/dev/null
+security list-keychains -d user -s "$kc" $(security list-keychains -d user | sed -e 's/^ *"//' -e 's/"$//' | grep -vxF "$kc")
+`
+
+// removeKeychainScript removes the keychain of the target from the search
+// list of the user, leaving the other keychains of the list as they are, and
+// deletes it.
+const removeKeychainScript = `kc="$PWD/` + runnerKeychainName + `"
+IFS='
+'
+set -f
+security list-keychains -d user -s $(security list-keychains -d user | sed -e 's/^ *"//' -e 's/"$//' | grep -vxF "$kc")
+security delete-keychain "$kc" >/dev/null 2>&1 || rm -f "$kc"
+`
+
+// importRunnerKeychain imports the PKCS#12 signing identity of the secret
+// into a keychain of the target on the runner, as per LOCALLY --keychain. The
+// secrets are staged into a private local directory, and streamed to the
+// runner over SSH, so that they never appear in commands nor in logs.
+func (c *Converter) importRunnerKeychain(identitySecret, passwordSecret string) error {
+	if c.opt.GetSecret == nil {
+		return errors.New("LOCALLY --keychain is not supported in this context")
+	}
+	identity, err := c.opt.GetSecret(strings.TrimPrefix(identitySecret, "+secrets/"))
+	if err != nil {
+		return errors.Wrapf(err, "LOCALLY --keychain %s", identitySecret)
+	}
+	var password []byte
+	if passwordSecret != "" {
+		password, err = c.opt.GetSecret(strings.TrimPrefix(passwordSecret, "+secrets/"))
+		if err != nil {
+			return errors.Wrapf(err, "LOCALLY --keychain-password %s", passwordSecret)
+		}
+	}
+	keychainPassword := make([]byte, 16)
+	_, err = rand.Read(keychainPassword)
+	if err != nil {
+		return errors.Wrap(err, "generate keychain password")
+	}
+
+	dir, err := ioutil.TempDir("", "earthly-keychain")
+	if err != nil {
+		return errors.Wrap(err, "create keychain dir")
+	}
+	c.opt.CleanCollection.Add(func() error {
+		return os.RemoveAll(dir)
+	})
+	files := map[string][]byte{
+		"identity.p12":      identity,
+		"identity.password": password,
+		"keychain.password": []byte(hex.EncodeToString(keychainPassword)),
+	}
+	for name, dt := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), dt, 0600)
+		if err != nil {
+			return errors.Wrapf(err, "write %s", name)
+		}
+	}
+
+	quotedDir := shellescape.Quote(c.runnerDir)
+	remote := fmt.Sprintf("umask 077 && mkdir -p %s && cd %s && rm -rf .earthly-keychain && mkdir .earthly-keychain && tar -C .earthly-keychain -xf - && sh -c %s",
+		quotedDir, quotedDir, shellescape.Quote(importKeychainScript))
+	script := fmt.Sprintf("tar -C %s -cf - . | %s; status=$?; rm -rf %s; exit $status",
+		shellescape.Quote(dir), c.runner.sshCommand(remote), shellescape.Quote(dir))
+	c.runOnHost(script, fmt.Sprintf("IMPORT KEYCHAIN %s on runner %s", identitySecret, c.runnerName))
+	c.runnerKeychain = true
+
+	runner, runnerName := *c.runner, c.runnerName
+	c.opt.CleanCollection.Add(func() error {
+		args := runner.sshArgs(fmt.Sprintf("cd %s 2>/dev/null || exit 0; sh -c %s", quotedDir, shellescape.Quote(removeKeychainScript)))
+		output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			return errors.Wrapf(err, "remove keychain on runner %s: %s", runnerName, strings.TrimSpace(string(output)))
+		}
+		return nil
+	})
+	return nil
+}
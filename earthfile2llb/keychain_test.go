@@ -0,0 +1,58 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSecurity mimics the list-keychains and delete-keychain commands of the
+// macOS security tool, keeping the search list in the file keychains.
+const fakeSecurity = `#!/bin/sh
+list="$(dirname "$0")/keychains"
+case "$1" in
+list-keychains)
+	if [ "$4" = "-s" ]; then
+		shift 4
+		: > "$list"
+		for kc in "$@"; do echo "$kc" >> "$list"; done
+	else
+		sed -e 's/^/    "/' -e 's/$/"/' "$list"
+	fi ;;
+delete-keychain)
+	rm "$2" ;;
+esac
+`
+
+func TestRemoveKeychainScript(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	dir, err := ioutil.TempDir("", "earthly-keychain-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	binDir := filepath.Join(dir, "bin")
+	targetDir := filepath.Join(dir, "target")
+	assert.NoError(t, os.MkdirAll(binDir, 0755))
+	assert.NoError(t, os.MkdirAll(targetDir, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "security"), []byte(fakeSecurity), 0755))
+	kc := filepath.Join(targetDir, runnerKeychainName)
+	assert.NoError(t, ioutil.WriteFile(kc, nil, 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(binDir, "keychains"),
+		[]byte(kc+"\n/Users/ci/Library/Keychains/login.keychain-db\n/Users/ci/My Keychains/*.keychain-db\n"), 0644))
+
+	cmd := exec.Command("/bin/sh", "-c", removeKeychainScript)
+	cmd.Dir = targetDir
+	cmd.Env = append(os.Environ(), "PATH="+binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(out))
+	list, err := ioutil.ReadFile(filepath.Join(binDir, "keychains"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/Users/ci/Library/Keychains/login.keychain-db\n/Users/ci/My Keychains/*.keychain-db\n", string(list))
+	assert.NoFileExists(t, kc)
+}
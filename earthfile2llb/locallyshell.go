@@ -0,0 +1,171 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/moby/buildkit/session/localhost"
+	"github.com/pkg/errors"
+)
+
+const (
+	locallyShellSh         = "sh"
+	locallyShellCmd        = "cmd"
+	locallyShellPowershell = "powershell"
+)
+
+// parseLocallyShell validates the shell requested via LOCALLY --shell, picking
+// a default based on the host OS if none was requested.
+func parseLocallyShell(shell string) (string, error) {
+	switch shell {
+	case "":
+		if runtime.GOOS == "windows" {
+			return locallyShellCmd, nil
+		}
+		return locallyShellSh, nil
+	case locallyShellSh, locallyShellCmd, locallyShellPowershell:
+		return shell, nil
+	default:
+		return "", errors.Errorf("invalid LOCALLY shell %s; valid values are %s, %s and %s",
+			shell, locallyShellSh, locallyShellCmd, locallyShellPowershell)
+	}
+}
+
+// locallyScriptArgs writes a RUN command executed under LOCALLY into a script
+// for the given Windows shell, and returns the args which execute that script
+// on the host. A script is used rather than passing the command inline, as
+// cmd.exe does not follow the quoting conventions that Go uses when spawning
+// processes on Windows.
+func (c *Converter) locallyScriptArgs(shell string, args []string, envVars [][2]string, withShell bool) ([]string, error) {
+	var script, ext string
+	var runArgs []string
+	var err error
+	switch shell {
+	case locallyShellCmd:
+		ext = ".cmd"
+		script, err = cmdScript(args, envVars, withShell)
+		if err != nil {
+			return nil, err
+		}
+		runArgs = []string{"cmd.exe", "/D", "/C"}
+	case locallyShellPowershell:
+		ext = ".ps1"
+		script, err = powershellScript(args, envVars, withShell)
+		if err != nil {
+			return nil, err
+		}
+		runArgs = []string{"powershell.exe", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File"}
+	default:
+		return nil, errors.Errorf("unexpected LOCALLY shell %s", shell)
+	}
+
+	f, err := ioutil.TempFile("", "earthly-locally-*"+ext)
+	if err != nil {
+		return nil, errors.Wrap(err, "create LOCALLY script")
+	}
+	defer f.Close()
+	_, err = f.WriteString(script)
+	if err != nil {
+		return nil, errors.Wrapf(err, "write LOCALLY script %s", f.Name())
+	}
+	scriptPath := f.Name()
+	c.opt.CleanCollection.Add(func() error {
+		return os.Remove(scriptPath)
+	})
+	// buildkit-hack in order to run locally, we prepend the command with a magic UUID.
+	return append([]string{localhost.RunOnLocalHostMagicStr}, append(runArgs, scriptPath)...), nil
+}
+
+// cmdScript returns the cmd.exe script which executes the command with the
+// given environment variables. cmd.exe has no way of escaping double quotes
+// within a quoted set, nor newlines at all, so values and exec form args
+// holding them are rejected rather than left to break out of the script.
+// Shell form commands are the script itself, and are written as they are.
+func cmdScript(args []string, envVars [][2]string, withShell bool) (string, error) {
+	var script strings.Builder
+	script.WriteString("@echo off\r\n")
+	for _, kv := range envVars {
+		if !validEnvVarName(kv[0]) {
+			return "", errors.Errorf("invalid environment variable name %q for LOCALLY --shell=cmd", kv[0])
+		}
+		if strings.ContainsAny(kv[1], "\"\r\n") {
+			return "", errors.Errorf("the value of %s cannot be passed to LOCALLY --shell=cmd, as it contains double quotes or newlines", kv[0])
+		}
+		script.WriteString(fmt.Sprintf("set \"%s=%s\"\r\n", kv[0], escapeCmdPercents(kv[1])))
+	}
+	if withShell {
+		script.WriteString(strings.Join(args, " "))
+	} else {
+		quoted := make([]string, 0, len(args))
+		for _, arg := range args {
+			if strings.ContainsAny(arg, "\r\n") {
+				return "", errors.Errorf("the argument %q cannot be passed to LOCALLY --shell=cmd, as it contains newlines", arg)
+			}
+			quoted = append(quoted, fmt.Sprintf("\"%s\"", escapeCmdPercents(strings.ReplaceAll(arg, "\"", "\"\""))))
+		}
+		script.WriteString(strings.Join(quoted, " "))
+	}
+	script.WriteString("\r\nexit /b %ERRORLEVEL%\r\n")
+	return script.String(), nil
+}
+
+// powershellScript returns the PowerShell script which executes the command
+// with the given environment variables. Values and exec form args are passed
+// as single-quoted strings, which may span lines.
+func powershellScript(args []string, envVars [][2]string, withShell bool) (string, error) {
+	var script strings.Builder
+	script.WriteString("$ErrorActionPreference = 'Stop'\r\n")
+	for _, kv := range envVars {
+		if !validEnvVarName(kv[0]) {
+			return "", errors.Errorf("invalid environment variable name %q for LOCALLY --shell=powershell", kv[0])
+		}
+		script.WriteString(fmt.Sprintf("$env:%s = '%s'\r\n", kv[0], escapePowershellSingleQuotes(kv[1])))
+	}
+	if withShell {
+		script.WriteString(strings.Join(args, " "))
+	} else {
+		quoted := make([]string, 0, len(args))
+		for _, arg := range args {
+			quoted = append(quoted, fmt.Sprintf("'%s'", escapePowershellSingleQuotes(arg)))
+		}
+		script.WriteString("& " + strings.Join(quoted, " "))
+	}
+	script.WriteString("\r\nexit $LASTEXITCODE\r\n")
+	return script.String(), nil
+}
+
+// validEnvVarName returns whether name may be set by the cmd and PowerShell
+// scripts without quoting.
+func validEnvVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func escapeCmdPercents(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// escapePowershellSingleQuotes escapes arg for use within a single-quoted
+// string, in which PowerShell also treats the typographic single quotes as
+// quotes.
+func escapePowershellSingleQuotes(arg string) string {
+	var sb strings.Builder
+	for _, r := range arg {
+		switch r {
+		case '\'', '\u2018', '\u2019', '\u201a', '\u201b':
+			sb.WriteRune(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,49 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdScript(t *testing.T) {
+	script, err := cmdScript([]string{"echo", "%PATH%", `say "hi"`}, [][2]string{{"FOO", "100% & bar|baz"}}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "@echo off\r\n"+
+		"set \"FOO=100%% & bar|baz\"\r\n"+
+		"\"echo\" \"%%PATH%%\" \"say \"\"hi\"\"\"\r\n"+
+		"exit /b %ERRORLEVEL%\r\n", script)
+
+	script, err = cmdScript([]string{"dir", "/b"}, nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "@echo off\r\ndir /b\r\nexit /b %ERRORLEVEL%\r\n", script)
+
+	for _, envVars := range [][][2]string{
+		{{"FOO", `x" & calc & echo "`}},
+		{{"FOO", "x\r\ncalc"}},
+		{{"FOO", "x\ncalc"}},
+		{{"FOO=BAR", "x"}},
+		{{"", "x"}},
+	} {
+		_, err = cmdScript([]string{"dir"}, envVars, true)
+		assert.Error(t, err, envVars)
+	}
+	_, err = cmdScript([]string{"echo", "x\r\ncalc"}, nil, false)
+	assert.Error(t, err)
+}
+
+func TestPowershellScript(t *testing.T) {
+	script, err := powershellScript([]string{"Write-Output", "it's", "a’b"}, [][2]string{{"FOO", "multi\nline 'value'"}}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "$ErrorActionPreference = 'Stop'\r\n"+
+		"$env:FOO = 'multi\nline ''value'''\r\n"+
+		"& 'Write-Output' 'it''s' 'a’’b'\r\n"+
+		"exit $LASTEXITCODE\r\n", script)
+
+	script, err = powershellScript([]string{"Get-ChildItem", "|", "Select-Object", "Name"}, nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "$ErrorActionPreference = 'Stop'\r\nGet-ChildItem | Select-Object Name\r\nexit $LASTEXITCODE\r\n", script)
+
+	_, err = powershellScript([]string{"dir"}, [][2]string{{"FOO;calc", "x"}}, true)
+	assert.Error(t, err)
+}
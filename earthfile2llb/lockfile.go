@@ -0,0 +1,79 @@
+package earthfile2llb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+)
+
+// autoLockfile is the value of the lockfile option of cache mounts which
+// detects the lockfile of the target.
+const autoLockfile = "auto"
+
+// knownLockfiles are the lockfiles detected by lockfile=auto, in order of
+// precedence.
+var knownLockfiles = []string{
+	"go.sum",
+	"package-lock.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"Cargo.lock",
+	"poetry.lock",
+	"Pipfile.lock",
+	"Gemfile.lock",
+	"composer.lock",
+}
+
+// cacheKeyLockfile returns the key of a cache mount with the lockfile option.
+// Rather than being keyed on the target, such cache mounts are keyed on the
+// contents of the lockfile, so that every target with the same dependencies,
+// in any Earthfile, shares the same cache of them.
+//
+// The lockfile is read from the directory of the target, so this is only
+// possible for local targets. The cache mounts of remote targets with
+// lockfile=auto remain keyed on the target, as signaled by an empty key.
+func cacheKeyLockfile(lockfile string, target domain.Target) (string, error) {
+	if target.IsRemote() {
+		if lockfile == autoLockfile {
+			return "", nil
+		}
+		return "", errors.Errorf("mount lockfile %s of remote target %s must be auto", lockfile, target.String())
+	}
+	dir := filepath.FromSlash(target.GetLocalPath())
+	if lockfile == autoLockfile {
+		var err error
+		lockfile, err = detectLockfile(dir)
+		if err != nil {
+			return "", err
+		}
+	}
+	p := lockfile
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, filepath.FromSlash(lockfile))
+	}
+	dt, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", errors.Wrapf(err, "read mount lockfile %s", lockfile)
+	}
+	h := sha256.New()
+	h.Write([]byte(filepath.Base(p)))
+	h.Write([]byte{0})
+	h.Write(dt)
+	return "lockfile-" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// detectLockfile returns the name of the lockfile of the dir.
+func detectLockfile(dir string) (string, error) {
+	for _, name := range knownLockfiles {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if err == nil {
+			return name, nil
+		}
+	}
+	return "", errors.Errorf("mount lockfile=auto: none of %v found in %s", knownLockfiles, dir)
+}
@@ -0,0 +1,54 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheKeyLockfile(t *testing.T) {
+	root, err := ioutil.TempDir("", "lockfile-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+	for _, dir := range []string{"svc-a", "svc-b", "web"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "svc-a", "go.sum"), []byte("example.com/lib v1.0.0 h1:abc=\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "svc-b", "go.sum"), []byte("example.com/lib v1.0.0 h1:abc=\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "web", "package-lock.json"), []byte("{}\n"), 0644))
+
+	svcA := domain.Target{LocalPath: filepath.Join(root, "svc-a"), Target: "deps"}
+	svcB := domain.Target{LocalPath: filepath.Join(root, "svc-b"), Target: "build"}
+	web := domain.Target{LocalPath: filepath.Join(root, "web"), Target: "deps"}
+
+	keyA, err := cacheKeyLockfile("auto", svcA)
+	assert.NoError(t, err)
+	keyB, err := cacheKeyLockfile("go.sum", svcB)
+	assert.NoError(t, err)
+	assert.Equal(t, keyA, keyB)
+
+	keyWeb, err := cacheKeyLockfile("auto", web)
+	assert.NoError(t, err)
+	assert.NotEqual(t, keyA, keyWeb)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "svc-b", "go.sum"), []byte("example.com/lib v1.1.0 h1:def=\n"), 0644))
+	keyB, err = cacheKeyLockfile("go.sum", svcB)
+	assert.NoError(t, err)
+	assert.NotEqual(t, keyA, keyB)
+
+	_, err = cacheKeyLockfile("Cargo.lock", svcA)
+	assert.Error(t, err)
+	_, err = cacheKeyLockfile("auto", domain.Target{LocalPath: root, Target: "deps"})
+	assert.Error(t, err)
+
+	remote := domain.Target{GitURL: "github.com/org/repo", Target: "deps"}
+	key, err := cacheKeyLockfile("auto", remote)
+	assert.NoError(t, err)
+	assert.Equal(t, "", key)
+	_, err = cacheKeyLockfile("go.sum", remote)
+	assert.Error(t, err)
+}
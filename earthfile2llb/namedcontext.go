@@ -0,0 +1,61 @@
+package earthfile2llb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/llbfactory"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+)
+
+// namedContextLocalName returns the name of the local dir of a named build
+// context in the buildkit solve.
+func namedContextLocalName(name string) string {
+	return fmt.Sprintf("earthly-context-%s", name)
+}
+
+// CopyFromContext applies the COPY --from-context command, copying files from
+// one of the additional named build contexts given via --build-context, rather
+// than from the build context of the target.
+func (c *Converter) CopyFromContext(ctx context.Context, contextName string, srcs []string, dest string, isDir bool, keepTs bool, keepOwn bool, chown string, ifExists bool) error {
+	err := c.checkAllowed(copyCmd)
+	if err != nil {
+		return err
+	}
+	if c.mts.Final.Target.IsRemote() {
+		return errors.Errorf("COPY --from-context cannot be used in remote targets such as %s", c.mts.Final.Target.String())
+	}
+	dir, ok := c.opt.BuildContexts[contextName]
+	if !ok {
+		return errors.Errorf("unknown build context %s: specify it via --build-context %s=<path>", contextName, contextName)
+	}
+	localName := namedContextLocalName(contextName)
+	includePatterns := createIncludePatterns(srcs)
+	factory := llbfactory.Local(
+		localName,
+		llb.IncludePatterns(includePatterns),
+		llb.Platform(llbutil.DefaultPlatform()),
+		llb.WithCustomNamef("[context %s] named context %s", contextName, dir),
+	).WithSharedKeyHint(getSharedKeyHintFromInclude(dir, includePatterns))
+	srcState := c.opt.LocalStateCache.getOrConstruct(factory)
+	c.mts.Final.LocalDirs[localName] = dir
+
+	c.nonSaveCommand()
+	c.mts.Final.MainState = llbutil.CopyOp(
+		srcState,
+		srcs,
+		c.mts.Final.MainState, dest, true, isDir, keepTs, c.copyOwner(keepOwn, chown), ifExists, false,
+		llb.WithCustomNamef(
+			"%sCOPY --from-context %s %s%s%s %s",
+			c.vertexPrefix(false, false),
+			contextName,
+			strIf(isDir, "--dir "),
+			strIf(ifExists, "--if-exists "),
+			strings.Join(srcs, " "),
+			dest))
+	return nil
+}
@@ -0,0 +1,93 @@
+package earthfile2llb
+
+import (
+	"context"
+	"path"
+	"regexp"
+
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultNixImage is the image which FROM NIX provisions the dev shell
+	// of the flake in.
+	defaultNixImage = "nixos/nix:2.11.1"
+	// nixDir holds the flake, the environment of its dev shell and the shell
+	// which RUN commands execute with.
+	nixDir = "/earthly-nix"
+	// nixCacheDir is the cache mount which the store paths of dev shells are
+	// copied into, as a binary cache, such that they are substituted from it
+	// rather than downloaded again when the flake.lock changes.
+	nixCacheDir = "/earthly-nix-cache"
+)
+
+var nixDevShellRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// nixProvisionScript provisions the dev shell ($1) of the flake of nixDir,
+// strictly as locked by its flake.lock.
+const nixProvisionScript = `#!/bin/sh
+set -e
+cd ` + nixDir + `/flake
+if [ ! -f flake.lock ]; then
+    echo "FROM NIX: the flake has no flake.lock; lock it via nix flake lock, and commit the flake.lock" >&2
+    exit 1
+fi
+nix() {
+    command nix --extra-experimental-features 'nix-command flakes' \
+        --option extra-substituters 'file://` + nixCacheDir + `?trusted=1' "$@"
+}
+nix print-dev-env --no-update-lock-file "path:$PWD#$1" > ` + nixDir + `/env.sh
+nix copy --all --to 'file://` + nixCacheDir + `?compression=none'
+`
+
+// nixShellScript executes the shell command of a RUN command within the dev
+// shell.
+const nixShellScript = `#!/usr/bin/env bash
+bash="$BASH"
+. ` + nixDir + `/env.sh
+exec "$bash" "$@"
+`
+
+// FromNix applies the earthly FROM NIX command, which starts the target from
+// the dev shell of a Nix flake: the toolchain is provisioned strictly as
+// locked by the flake.lock, and RUN commands execute within the dev shell.
+func (c *Converter) FromNix(ctx context.Context, flakeDir, devShell, image string, platform *specs.Platform) error {
+	if devShell == "" {
+		devShell = "default"
+	}
+	if !nixDevShellRegexp.MatchString(devShell) {
+		return errors.Errorf("invalid dev shell name %q", devShell)
+	}
+	if image == "" {
+		image = defaultNixImage
+	}
+	err := c.From(ctx, image, platform, false, nil, nil)
+	if err != nil {
+		return err
+	}
+	c.mts.Final.MainState = c.mts.Final.MainState.File(
+		pllb.Mkdir(nixDir, 0755, llb.WithParents(true)).
+			Mkfile(path.Join(nixDir, "provision"), 0755, []byte(nixProvisionScript)).
+			Mkfile(path.Join(nixDir, "shell"), 0755, []byte(nixShellScript)),
+		llb.WithCustomNamef("%sFROM NIX %s", c.vertexPrefix(false, false), flakeDir))
+	err = c.CopyClassical(ctx, []string{flakeDir}, path.Join(nixDir, "flake")+"/", false, false, false, "", false)
+	if err != nil {
+		return err
+	}
+
+	cacheMount := pllb.AddMount(nixCacheDir, c.cacheContext,
+		llb.AsPersistentCacheDir(path.Join("/run/cache", c.opt.CacheNamespace, "earthly-nix-store"), llb.CacheMountLocked))
+	_, err = c.internalRun(ctx, ConvertRunOpts{
+		CommandName:  "FROM NIX",
+		Args:         []string{path.Join(nixDir, "provision"), devShell},
+		extraRunOpts: []llb.RunOption{cacheMount},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "provision dev shell %s", devShell)
+	}
+	c.mts.Final.RunShell = []string{path.Join(nixDir, "shell"), "-c"}
+	return nil
+}
@@ -0,0 +1,27 @@
+package earthfile2llb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/states"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoExecution(t *testing.T) {
+	ctx := context.Background()
+	c := &Converter{
+		opt: ConvertOpt{NoExecution: true, AllowLocally: true},
+		mts: &states.MultiTarget{Final: &states.SingleTarget{RanFromLike: true}},
+	}
+	err := c.Locally(ctx, "/work", nil, nil, "", "", "", "")
+	assert.True(t, errors.Is(err, ErrExecutionRequired), "%v", err)
+	_, err = c.RunExitCode(ctx, ConvertRunOpts{Args: []string{"test", "-f", "x"}})
+	assert.True(t, errors.Is(err, ErrExecutionRequired), "%v", err)
+	_, err = c.RunExpression(ctx, "x", ConvertRunOpts{Args: []string{"echo", "x"}})
+	assert.True(t, errors.Is(err, ErrExecutionRequired), "%v", err)
+	err = c.forceExecution(ctx, pllb.Scratch())
+	assert.True(t, errors.Is(err, ErrExecutionRequired), "%v", err)
+}
@@ -0,0 +1,113 @@
+package earthfile2llb
+
+import (
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/earthly/earthly/util/gitutil"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/stringutil"
+	"github.com/pkg/errors"
+)
+
+// imageNameTemplateData is the data available to templates within SAVE IMAGE
+// names, as in {{.Project}}.
+type imageNameTemplateData struct {
+	Project      string
+	Org          string
+	Name         string
+	Registry     string
+	Target       string
+	Tag          string
+	GitHash      string
+	GitShortHash string
+	GitBranch    string
+	GitTag       string
+}
+
+var imageNameTemplateFuncs = template.FuncMap{
+	"dockerTag": llbutil.DockerTagSafe,
+}
+
+// expandImageName expands the templates within a SAVE IMAGE name and applies
+// the project registry prefix.
+func (c *Converter) expandImageName(imageName string) (string, error) {
+	if strings.Contains(imageName, "{{") {
+		tmpl, err := template.New("SAVE IMAGE").Funcs(imageNameTemplateFuncs).Parse(imageName)
+		if err != nil {
+			return "", errors.Wrapf(err, "parse image name template %s", imageName)
+		}
+		data := imageNameTemplateData{
+			Project:  c.ftrs.Project,
+			Org:      c.ftrs.ProjectOrg(),
+			Name:     c.ftrs.ProjectName(),
+			Registry: c.ftrs.Registry,
+			Target:   c.mts.Final.Target.Target,
+			Tag:      llbutil.DockerTagSafe(c.mts.Final.Target.Tag),
+		}
+		if c.gitMeta != nil {
+			data.GitHash = c.gitMeta.Hash
+			data.GitShortHash = c.gitMeta.ShortHash
+			if len(c.gitMeta.Branch) > 0 {
+				data.GitBranch = c.gitMeta.Branch[0]
+			}
+			if len(c.gitMeta.Tags) > 0 {
+				data.GitTag = c.gitMeta.Tags[0]
+			}
+		}
+		var sb strings.Builder
+		err = tmpl.Execute(&sb, data)
+		if err != nil {
+			return "", errors.Wrapf(err, "expand image name template %s", imageName)
+		}
+		imageName = sb.String()
+		_, err = reference.ParseNormalizedNamed(imageName)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid image name %s after template expansion", imageName)
+		}
+	}
+	return c.projectImageName(imageName), nil
+}
+
+// projectImageName prefixes the image name with the registry of the project,
+// as declared via VERSION --registry, if the name does not already specify a
+// registry or a repository path.
+func (c *Converter) projectImageName(imageName string) string {
+	if imageName == "" || c.ftrs.Registry == "" || strings.Contains(imageName, "/") {
+		return imageName
+	}
+	return strings.TrimSuffix(c.ftrs.Registry, "/") + "/" + imageName
+}
+
+// ociLabels returns the standard OCI annotations derived from the git
+// metadata, for the labels which are not already set.
+func ociLabels(gitMeta *gitutil.GitMetadata, existing map[string]string) map[string]string {
+	labels := make(map[string]string)
+	if gitMeta == nil {
+		return labels
+	}
+	set := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if _, ok := existing[key]; ok {
+			return
+		}
+		labels[key] = value
+	}
+	set("org.opencontainers.image.revision", gitMeta.Hash)
+	if gitMeta.RemoteURL != "" {
+		source, err := gitutil.ParseGitRemoteURL(stringutil.ScrubCredentials(gitMeta.RemoteURL))
+		if err == nil {
+			set("org.opencontainers.image.source", "https://"+source)
+		}
+	}
+	if ts, err := strconv.ParseInt(gitMeta.Timestamp, 10, 64); err == nil && ts > 0 {
+		// The commit time, rather than the build time, keeps the image reproducible.
+		set("org.opencontainers.image.created", time.Unix(ts, 0).UTC().Format(time.RFC3339))
+	}
+	return labels
+}
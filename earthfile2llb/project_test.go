@@ -0,0 +1,54 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/features"
+	"github.com/earthly/earthly/states"
+	"github.com/earthly/earthly/util/gitutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandImageName(t *testing.T) {
+	c := &Converter{
+		ftrs: &features.Features{Project: "acme/widgets", Registry: "ghcr.io/acme"},
+		mts: &states.MultiTarget{
+			Final: &states.SingleTarget{Target: domain.Target{LocalPath: ".", Target: "api"}},
+		},
+		gitMeta: &gitutil.GitMetadata{ShortHash: "41cb566", Branch: []string{"feature/x"}},
+	}
+	for _, tc := range []struct {
+		in       string
+		expected string
+	}{
+		{"api:latest", "ghcr.io/acme/api:latest"},
+		{"docker.io/acme/api", "docker.io/acme/api"},
+		{"registry/{{.Project}}/{{.Target}}:{{.GitShortHash}}", "registry/acme/widgets/api:41cb566"},
+		{"{{.Name}}-{{.Target}}:{{dockerTag .GitBranch}}", "ghcr.io/acme/widgets-api:feature_x"},
+	} {
+		actual, err := c.expandImageName(tc.in)
+		assert.NoError(t, err, tc.in)
+		assert.Equal(t, tc.expected, actual, tc.in)
+	}
+	_, err := c.expandImageName("{{.Missing}}")
+	assert.Error(t, err)
+	_, err = c.expandImageName("{{.GitTag}}:latest")
+	assert.Error(t, err)
+}
+
+func TestOCILabels(t *testing.T) {
+	gitMeta := &gitutil.GitMetadata{
+		RemoteURL: "git@github.com:acme/widgets.git",
+		Hash:      "41cb5666ade67b29e42bef121144456d3977a67a",
+		Timestamp: "1600000000",
+	}
+	labels := ociLabels(gitMeta, map[string]string{"org.opencontainers.image.source": "https://example.com"})
+	assert.Equal(t, map[string]string{
+		"org.opencontainers.image.revision": "41cb5666ade67b29e42bef121144456d3977a67a",
+		"org.opencontainers.image.created":  "2020-09-13T12:26:40Z",
+	}, labels)
+	labels = ociLabels(gitMeta, nil)
+	assert.Equal(t, "https://github.com/acme/widgets", labels["org.opencontainers.image.source"])
+	assert.Empty(t, ociLabels(nil, nil))
+}
@@ -0,0 +1,107 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/variables"
+	"github.com/pkg/errors"
+)
+
+// requiresEnv is the environment the REQUIRES declarations of a target are
+// checked against.
+type requiresEnv struct {
+	overridingVars *variables.Scope
+	hasSecret      func(name string) bool
+	lookPath       func(file string) (string, error)
+	runners        map[string]Runner
+	runnerLookPath func(r Runner, file string) error
+}
+
+// checkRequires checks that the secrets, ARGs and tools the target declares
+// via REQUIRES are available, before any of its commands are executed. All
+// of those which are missing are reported at once.
+func checkRequires(target domain.Target, ef spec.Earthfile, opt ConvertOpt) error {
+	return requiresEnv{
+		overridingVars: opt.OverridingVars,
+		hasSecret:      opt.HasSecret,
+		lookPath:       exec.LookPath,
+		runners:        opt.Runners,
+		runnerLookPath: Runner.lookPath,
+	}.check(target, ef)
+}
+
+func (env requiresEnv) check(target domain.Target, ef spec.Earthfile) error {
+	var t *spec.Target
+	for i := range ef.Targets {
+		if ef.Targets[i].Name == target.Target {
+			t = &ef.Targets[i]
+			break
+		}
+	}
+	if t == nil || t.Requires == nil {
+		return nil
+	}
+	var missing []string
+	for _, name := range t.Requires.Secrets {
+		if env.hasSecret != nil && !env.hasSecret(name) {
+			missing = append(missing, fmt.Sprintf("secret %s (pass it via --secret %s=<value>)", name, name))
+		}
+	}
+	for _, name := range t.Requires.Args {
+		if env.overridingVars != nil {
+			if _, ok := env.overridingVars.GetAny(name); ok {
+				continue
+			}
+		}
+		missing = append(missing, fmt.Sprintf("ARG %s (pass it via --build-arg %s=<value>)", name, name))
+	}
+	if len(t.Requires.Tools) > 0 {
+		locally, runnerName := recipeLocally(t.Recipe)
+		if !locally {
+			return errors.Errorf("target %s: REQUIRES --tool is only allowed in LOCALLY targets", target.StringCanonical())
+		}
+		runner, onRunner := env.runners[runnerName]
+		for _, name := range t.Requires.Tools {
+			switch {
+			case runnerName == "":
+				if _, err := env.lookPath(name); err != nil {
+					missing = append(missing, fmt.Sprintf("tool %s (not found in PATH)", name))
+				}
+			case onRunner:
+				if err := env.runnerLookPath(runner, name); err != nil {
+					missing = append(missing, fmt.Sprintf("tool %s (not found in PATH of runner %s)", name, runnerName))
+				}
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return errors.Errorf("target %s is missing what it REQUIRES:\n\t%s", target.StringCanonical(), strings.Join(missing, "\n\t"))
+}
+
+// recipeLocally returns whether the recipe of a target executes on the host,
+// via LOCALLY, along with the runner it executes on instead, as per LOCALLY
+// --runner, if any.
+func recipeLocally(recipe spec.Block) (bool, string) {
+	for _, stmt := range recipe {
+		if stmt.Command == nil || stmt.Command.Name != "LOCALLY" {
+			continue
+		}
+		args := stmt.Command.Args
+		for i, arg := range args {
+			switch {
+			case strings.HasPrefix(arg, "--runner="):
+				return true, strings.TrimPrefix(arg, "--runner=")
+			case arg == "--runner" && i+1 < len(args):
+				return true, args[i+1]
+			}
+		}
+		return true, ""
+	}
+	return false, ""
+}
@@ -0,0 +1,72 @@
+package earthfile2llb
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/variables"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRequires(t *testing.T) {
+	ef := spec.Earthfile{
+		Targets: []spec.Target{
+			{
+				Name: "deploy",
+				Requires: &spec.Requires{
+					Secrets: []string{"KUBECONFIG", "org/REGISTRY_TOKEN"},
+					Args:    []string{"VERSION", "ENV"},
+					Tools:   []string{"kubectl", "helm"},
+				},
+				Recipe: spec.Block{{Command: &spec.Command{Name: "LOCALLY"}}},
+			},
+			{Name: "build"},
+		},
+	}
+	vars := variables.NewScope()
+	vars.AddInactive("ENV", "")
+	env := requiresEnv{
+		overridingVars: vars,
+		hasSecret:      func(name string) bool { return name == "KUBECONFIG" },
+		lookPath: func(file string) (string, error) {
+			if file == "kubectl" {
+				return "/usr/bin/kubectl", nil
+			}
+			return "", exec.ErrNotFound
+		},
+	}
+	err := env.check(domain.Target{Target: "deploy"}, ef)
+	if assert.Error(t, err) {
+		assert.Equal(t, "target +deploy is missing what it REQUIRES:\n"+
+			"\tsecret org/REGISTRY_TOKEN (pass it via --secret org/REGISTRY_TOKEN=<value>)\n"+
+			"\tARG VERSION (pass it via --build-arg VERSION=<value>)\n"+
+			"\ttool helm (not found in PATH)", err.Error())
+	}
+
+	vars.AddInactive("VERSION", "1.0")
+	env.hasSecret = func(name string) bool { return true }
+	env.lookPath = func(file string) (string, error) { return "/usr/bin/" + file, nil }
+	assert.NoError(t, env.check(domain.Target{Target: "deploy"}, ef))
+	assert.NoError(t, env.check(domain.Target{Target: "build"}, ef))
+
+	ef.Targets[0].Recipe = nil
+	err = env.check(domain.Target{Target: "deploy"}, ef)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "only allowed in LOCALLY targets")
+	}
+
+	ef.Targets[0].Recipe = spec.Block{{Command: &spec.Command{Name: "LOCALLY", Args: []string{"--runner=mac"}}}}
+	env.runners = map[string]Runner{"mac": {Host: "ci@mac"}}
+	env.runnerLookPath = func(r Runner, file string) error {
+		if file == "kubectl" {
+			return nil
+		}
+		return exec.ErrNotFound
+	}
+	err = env.check(domain.Target{Target: "deploy"}, ef)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "tool helm (not found in PATH of runner mac)")
+	}
+}
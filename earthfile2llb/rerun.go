@@ -0,0 +1,51 @@
+package earthfile2llb
+
+import (
+	"encoding/json"
+	"path"
+	"path/filepath"
+
+	"github.com/earthly/earthly/debugger/common"
+	"github.com/pkg/errors"
+)
+
+// rerunFlag makes the debugger rerun the failed tests of the command.
+const rerunFlag = "--rerun-failed"
+
+// newRerunSpec returns how the failed tests of a command are rerun, as per
+// RUN --junit <pattern>, --rerun-failed <n> and --quarantine <test>. It is
+// empty if the command does not declare JUnit reports.
+func newRerunSpec(reports []string, reruns int, quarantine []string) (common.RerunSpec, error) {
+	if reruns < 0 {
+		return common.RerunSpec{}, errors.New("--rerun-failed must not be negative")
+	}
+	if len(reports) == 0 {
+		if reruns != 0 || len(quarantine) != 0 {
+			return common.RerunSpec{}, errors.New("--rerun-failed and --quarantine require --junit")
+		}
+		return common.RerunSpec{}, nil
+	}
+	for _, r := range reports {
+		_, err := filepath.Match(r, "")
+		if err != nil || r == "" {
+			return common.RerunSpec{}, errors.Errorf("invalid --junit %q: must be a path or glob pattern", r)
+		}
+	}
+	for _, q := range quarantine {
+		_, err := path.Match(q, "")
+		if err != nil || q == "" {
+			return common.RerunSpec{}, errors.Errorf("invalid --quarantine %q: must be a test name or glob pattern", q)
+		}
+	}
+	return common.RerunSpec{Reports: reports, Reruns: reruns, Quarantine: quarantine}, nil
+}
+
+// withReruns wraps args so that the debugger reruns the failed tests of the
+// command.
+func withReruns(args []string, spec common.RerunSpec) ([]string, error) {
+	dt, err := json.Marshal(spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal rerun spec")
+	}
+	return append([]string{debuggerPath, rerunFlag, string(dt), "--"}, args...), nil
+}
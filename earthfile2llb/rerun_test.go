@@ -0,0 +1,36 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/debugger/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRerunSpec(t *testing.T) {
+	spec, err := newRerunSpec(nil, 0, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, common.RerunSpec{}, spec)
+
+	spec, err = newRerunSpec([]string{"reports/*.xml"}, 2, []string{"TestFlaky*"})
+	assert.NoError(t, err)
+	assert.Equal(t, common.RerunSpec{Reports: []string{"reports/*.xml"}, Reruns: 2, Quarantine: []string{"TestFlaky*"}}, spec)
+
+	_, err = newRerunSpec(nil, 2, nil)
+	assert.Error(t, err)
+	_, err = newRerunSpec(nil, 0, []string{"TestFlaky"})
+	assert.Error(t, err)
+	_, err = newRerunSpec([]string{"report.xml"}, -1, nil)
+	assert.Error(t, err)
+	_, err = newRerunSpec([]string{"reports/[.xml"}, 1, nil)
+	assert.Error(t, err)
+	_, err = newRerunSpec([]string{"report.xml"}, 1, []string{""})
+	assert.Error(t, err)
+}
+
+func TestWithReruns(t *testing.T) {
+	args, err := withReruns([]string{"/bin/sh", "-c", "go test"}, common.RerunSpec{Reports: []string{"report.xml"}, Reruns: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{debuggerPath, "--rerun-failed", `{"reports":["report.xml"],"reruns":1}`, "--", "/bin/sh", "-c", "go test"}, args)
+}
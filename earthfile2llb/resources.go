@@ -0,0 +1,59 @@
+package earthfile2llb
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var memoryUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// parseMemoryLimit parses a memory amount such as 512m or 8g, using the same
+// (binary) units as docker run --memory.
+func parseMemoryLimit(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	numEnd := strings.IndexFunc(lower, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if numEnd == -1 {
+		numEnd = len(lower)
+	}
+	unit := strings.TrimSuffix(lower[numEnd:], "b")
+	if lower[numEnd:] == "b" {
+		unit = "b"
+	}
+	mult, ok := memoryUnits[unit]
+	if !ok || numEnd == 0 {
+		return 0, errors.Errorf("invalid memory amount %s; expected a number followed by b, k, m, g or t", s)
+	}
+	num, err := strconv.ParseFloat(lower[:numEnd], 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse memory amount %s", s)
+	}
+	limit := int64(num * float64(mult))
+	if limit <= 0 {
+		return 0, errors.Errorf("memory amount %s must be positive", s)
+	}
+	return limit, nil
+}
+
+// parseTimeout parses a RUN or BUILD timeout, such as 90s or 10m.
+func parseTimeout(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse timeout %s", s)
+	}
+	if d <= 0 {
+		return 0, errors.Errorf("timeout %s must be positive", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,53 @@
+package earthfile2llb
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMemoryLimit(t *testing.T) {
+	cases := map[string]int64{
+		"1024":  1024,
+		"512m":  512 << 20,
+		"512MB": 512 << 20,
+		"8g":    8 << 30,
+		"1.5g":  3 << 29,
+		"100b":  100,
+	}
+	for in, expected := range cases {
+		actual, err := parseMemoryLimit(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, expected, actual, in)
+	}
+	for _, in := range []string{"", "g", "8x", "0", "-1g"} {
+		_, err := parseMemoryLimit(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestWithProcessMemoryLimit(t *testing.T) {
+	args := withProcessMemoryLimit([]string{"/bin/sh", "-c", "make"}, 512<<20+1)
+	assert.Equal(t, []string{"/bin/sh", "-c", `ulimit -d 524289 && exec "$@"`, "earthly-process-memory", "/bin/sh", "-c", "make"}, args)
+}
+
+func TestWithTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	args := withTimeout([]string{"/bin/sh", "-c", "cat; sleep 30 & sleep 30"}, time.Second)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader("input\n")
+	start := time.Now()
+	// The output is only complete once the background sleep, which holds on
+	// to stdout, has been terminated too.
+	out, err := cmd.CombinedOutput()
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(10*time.Second))
+	assert.Contains(t, string(out), "input\n")
+	assert.Contains(t, string(out), "earthly: command timed out after 1s")
+}
@@ -12,10 +12,10 @@ import (
 	"github.com/pkg/errors"
 )
 
-func parseMounts(mounts []string, target domain.Target, ti dedup.TargetInput, cacheContext pllb.State) ([]llb.RunOption, error) {
+func parseMounts(mounts []string, target domain.Target, ti dedup.TargetInput, cacheContext pllb.State, cacheNamespace string) ([]llb.RunOption, error) {
 	var runOpts []llb.RunOption
 	for _, mount := range mounts {
-		mountRunOpts, err := parseMount(mount, target, ti, cacheContext)
+		mountRunOpts, err := parseMount(mount, target, ti, cacheContext, cacheNamespace)
 		if err != nil {
 			return nil, errors.Wrap(err, "parse mount")
 		}
@@ -24,12 +24,38 @@ func parseMounts(mounts []string, target domain.Target, ti dedup.TargetInput, ca
 	return runOpts, nil
 }
 
-func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheContext pllb.State) ([]llb.RunOption, error) {
+// hostMountSources returns the host paths mounted by the bind-experimental
+// mounts.
+func hostMountSources(mounts []string) []string {
+	var sources []string
+	for _, mount := range mounts {
+		var mountType, mountSource string
+		for _, kvPair := range strings.Split(mount, ",") {
+			kvSplit := strings.SplitN(kvPair, "=", 2)
+			if len(kvSplit) != 2 {
+				continue
+			}
+			switch kvSplit[0] {
+			case "type":
+				mountType = kvSplit[1]
+			case "source":
+				mountSource = kvSplit[1]
+			}
+		}
+		if mountType == "bind-experimental" {
+			sources = append(sources, mountSource)
+		}
+	}
+	return sources
+}
+
+func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheContext pllb.State, cacheNamespace string) ([]llb.RunOption, error) {
 	var state pllb.State
 	var mountSource string
 	var mountTarget string
 	var mountID string
 	var mountType string
+	var lockfile string
 	var mountOpts []llb.MountOption
 	sharingMode := llb.CacheMountShared
 	kvPairs := strings.Split(mount, ",")
@@ -59,6 +85,11 @@ func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheC
 				return nil, errors.Errorf("invalid mount arg %s", kvPair)
 			}
 			mountTarget = kvSplit[1]
+		case "lockfile":
+			if len(kvSplit) != 2 {
+				return nil, errors.Errorf("invalid mount arg %s", kvPair)
+			}
+			lockfile = kvSplit[1]
 		case "ro", "readonly":
 			if len(kvSplit) != 1 {
 				return nil, errors.Errorf("invalid mount arg %s", kvPair)
@@ -122,6 +153,9 @@ func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheC
 	if mountID == "" {
 		mountID = path.Clean(mountTarget)
 	}
+	if lockfile != "" && mountType != "cache" {
+		return nil, errors.Errorf("lockfile is only supported for cache mounts")
+	}
 
 	switch mountType {
 	case "bind-experimental":
@@ -141,7 +175,16 @@ func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheC
 		if err != nil {
 			return nil, err
 		}
-		cachePath := path.Join("/run/cache", key, mountID)
+		if lockfile != "" {
+			lockfileKey, err := cacheKeyLockfile(lockfile, target)
+			if err != nil {
+				return nil, err
+			}
+			if lockfileKey != "" {
+				key = lockfileKey
+			}
+		}
+		cachePath := path.Join("/run/cache", cacheNamespace, key, mountID)
 		mountOpts = append(mountOpts, llb.AsPersistentCacheDir(cachePath, sharingMode))
 		state = cacheContext
 		return []llb.RunOption{pllb.AddMount(mountTarget, state, mountOpts...)}, nil
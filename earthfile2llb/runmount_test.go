@@ -0,0 +1,15 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostMountSources(t *testing.T) {
+	assert.Equal(t, []string{"/var/run/docker.sock"}, hostMountSources([]string{
+		"type=cache,target=/root/.cache",
+		"type=bind-experimental,target=/var/run/docker.sock,source=/var/run/docker.sock",
+	}))
+	assert.Nil(t, hostMountSources([]string{"type=secret,id=+secrets/key,target=/key"}))
+}
@@ -0,0 +1,195 @@
+package earthfile2llb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alessio/shellescape"
+	"github.com/earthly/earthly/domain"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/session/localhost"
+	"github.com/pkg/errors"
+)
+
+// defaultRunnerWorkdir is the directory of a runner under which targets
+// execute, relative to the home directory of the SSH user.
+const defaultRunnerWorkdir = "earthly-runner"
+
+var unsafeRunnerDirRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Runner is a remote host which LOCALLY targets may execute on, over SSH, as
+// per LOCALLY --runner: for steps which need the hardware or OS of a specific
+// host, such as macOS codesigning.
+type Runner struct {
+	// Host is the SSH destination of the runner, as in user@host.
+	Host string
+	// Port is the SSH port of the runner, if not the default one.
+	Port int
+	// IdentityFile, if set, is the private key used for connecting to the
+	// runner.
+	IdentityFile string
+	// Workdir is the directory of the runner under which targets execute,
+	// each within a directory of its own.
+	Workdir string
+}
+
+// sshArgs returns the args which execute the shell command on the runner.
+func (r Runner) sshArgs(command string) []string {
+	args := []string{"ssh", "-o", "BatchMode=yes"}
+	if r.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(r.Port))
+	}
+	if r.IdentityFile != "" {
+		args = append(args, "-i", r.IdentityFile, "-o", "IdentitiesOnly=yes")
+	}
+	return append(args, r.Host, command)
+}
+
+// lookPath checks that the executable can be found in the PATH of the
+// runner.
+func (r Runner) lookPath(file string) error {
+	args := r.sshArgs("command -v " + shellescape.Quote(file))
+	return exec.Command(args[0], args[1:]...).Run()
+}
+
+// RunnerInfo describes the platform of a runner.
+type RunnerInfo struct {
+	// OS and Arch are as reported by uname, as in Darwin and arm64.
+	OS   string
+	Arch string
+	// Xcode is the version of Xcode installed on the runner, if any, as in
+	// Xcode 15.2.
+	Xcode string
+}
+
+// Probe connects to the runner, and reports its platform.
+func (r Runner) Probe(ctx context.Context) (RunnerInfo, error) {
+	args := r.sshArgs("uname -s; uname -m; (xcodebuild -version 2>/dev/null || true) | head -n 1")
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return RunnerInfo{}, errors.Wrapf(err, "connect to %s: %s", r.Host, strings.TrimSpace(string(output)))
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) < 2 {
+		return RunnerInfo{}, errors.Errorf("unexpected platform of %s: %s", r.Host, strings.TrimSpace(string(output)))
+	}
+	info := RunnerInfo{OS: lines[0], Arch: lines[1]}
+	if len(lines) > 2 {
+		info.Xcode = lines[2]
+	}
+	return info, nil
+}
+
+// sshCommand returns sshArgs as a shell command line.
+func (r Runner) sshCommand(command string) string {
+	args := r.sshArgs(command)
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, shellescape.Quote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// targetDir returns the directory of the runner which the target executes
+// in. It is kept across builds, so that tools may cache their state there.
+func (r Runner) targetDir(target domain.Target) string {
+	workdir := r.Workdir
+	if workdir == "" {
+		workdir = defaultRunnerWorkdir
+	}
+	canonical := target.StringCanonical()
+	sum := sha256.Sum256([]byte(canonical))
+	name := strings.Trim(unsafeRunnerDirRegexp.ReplaceAllString(target.Target, "-"), "-")
+	return path.Join(workdir, fmt.Sprintf("%s-%s", name, hex.EncodeToString(sum[:])[:12]))
+}
+
+// lookupRunner returns the runner of the given name, as configured.
+func (c *Converter) lookupRunner(name string) (*Runner, error) {
+	r, ok := c.opt.Runners[name]
+	if !ok {
+		names := make([]string, 0, len(c.opt.Runners))
+		for n := range c.opt.Runners {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, errors.Errorf("runner %s is not configured; runners are configured via runners in the earthly config", name)
+		}
+		return nil, errors.Errorf("runner %s is not configured; configured runners are %s", name, strings.Join(names, ", "))
+	}
+	if r.Host == "" {
+		return nil, errors.Errorf("runner %s has no host configured", name)
+	}
+	return &r, nil
+}
+
+// runnerArgs returns the args of a RUN command of a LOCALLY target which
+// executes on a runner: the command is executed over SSH, within the
+// directory of the target on the runner, while its output is streamed into
+// the log of the target, like any other command.
+func (c *Converter) runnerArgs(args []string) []string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, shellescape.Quote(arg))
+	}
+	dir := shellescape.Quote(c.runnerDir)
+	command := strings.Join(quoted, " ")
+	if c.runnerKeychain {
+		command = fmt.Sprintf("%s=\"$PWD/%s\" %s", runnerKeychainEnv, runnerKeychainName, command)
+	}
+	return c.runner.sshArgs(fmt.Sprintf("mkdir -p %s && cd %s && %s", dir, dir, command))
+}
+
+// checkRunnerResult checks that the command, whose exit code or output is
+// needed, does not execute on a runner: the files which these are written
+// into would be written on the runner, rather than on the host.
+func (c *Converter) checkRunnerResult(opts ConvertRunOpts) error {
+	if opts.Locally && c.runner != nil {
+		return errors.Errorf("%s is not supported with LOCALLY --runner, as its result cannot be read back from the runner", opts.CommandName)
+	}
+	return nil
+}
+
+// runnerRelPath checks that a path of a LOCALLY target which executes on a
+// runner is within the directory of the target, and returns it cleaned.
+func runnerRelPath(p string) (string, error) {
+	cleaned := path.Clean(p)
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", errors.Errorf("path %s needs to be relative to the directory of the target on the runner", p)
+	}
+	return cleaned, nil
+}
+
+// pushToRunner uploads the given path of the local directory of the target
+// into the directory of the target on the runner, as needed after COPY.
+func (c *Converter) pushToRunner(p string) {
+	script := fmt.Sprintf("tar -cf - -- %s | %s",
+		shellescape.Quote(p),
+		c.runner.sshCommand(fmt.Sprintf("mkdir -p %s && tar -C %s -xf -", shellescape.Quote(c.runnerDir), shellescape.Quote(c.runnerDir))))
+	c.runOnHost(script, fmt.Sprintf("UPLOAD %s to runner %s", p, c.runnerName))
+}
+
+// pullFromRunner downloads the given path of the directory of the target on
+// the runner into the local directory of the target, as needed before SAVE
+// ARTIFACT.
+func (c *Converter) pullFromRunner(p string) {
+	script := fmt.Sprintf("%s | tar -xf -",
+		c.runner.sshCommand(fmt.Sprintf("tar -C %s -cf - -- %s", shellescape.Quote(c.runnerDir), shellescape.Quote(p))))
+	c.runOnHost(script, fmt.Sprintf("DOWNLOAD %s from runner %s", p, c.runnerName))
+}
+
+func (c *Converter) runOnHost(script, name string) {
+	c.mts.Final.MainState = c.mts.Final.MainState.Run(
+		llb.Args([]string{localhost.RunOnLocalHostMagicStr, "/bin/sh", "-c", script}),
+		llb.IgnoreCache,
+		llb.WithCustomNamef("%s%s", c.vertexPrefix(true, false), name),
+	).Root()
+}
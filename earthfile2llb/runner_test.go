@@ -0,0 +1,62 @@
+package earthfile2llb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/states"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunnerSSHArgs(t *testing.T) {
+	r := Runner{Host: "ci@mac-mini", Port: 2222, IdentityFile: "/home/me/.ssh/id_mac"}
+	assert.Equal(t, []string{"ssh", "-o", "BatchMode=yes", "-p", "2222", "-i", "/home/me/.ssh/id_mac", "-o", "IdentitiesOnly=yes", "ci@mac-mini", "true"}, r.sshArgs("true"))
+	assert.Equal(t, "ssh -o BatchMode=yes ci@mac-mini 'tar -xf -'", Runner{Host: "ci@mac-mini"}.sshCommand("tar -xf -"))
+}
+
+func TestRunnerTargetDir(t *testing.T) {
+	target := domain.Target{GitURL: "github.com/org/app", Target: "sign"}
+	dir := Runner{}.targetDir(target)
+	assert.Regexp(t, `^earthly-runner/sign-[0-9a-f]{12}$`, dir)
+	other := Runner{Workdir: "/opt/runner"}.targetDir(domain.Target{GitURL: "github.com/org/lib", Target: "sign"})
+	assert.Regexp(t, `^/opt/runner/sign-[0-9a-f]{12}$`, other)
+	assert.NotEqual(t, dir[len(dir)-12:], other[len(other)-12:])
+}
+
+func TestRunnerArgs(t *testing.T) {
+	c := &Converter{runner: &Runner{Host: "mac"}, runnerDir: "earthly-runner/sign-0123456789ab"}
+	assert.Equal(t, []string{"ssh", "-o", "BatchMode=yes", "mac",
+		"mkdir -p earthly-runner/sign-0123456789ab && cd earthly-runner/sign-0123456789ab && /bin/sh -c 'codesign --sign \"$ID\" App.app'"},
+		c.runnerArgs([]string{"/bin/sh", "-c", `codesign --sign "$ID" App.app`}))
+
+	c.runnerKeychain = true
+	assert.Equal(t, "mkdir -p earthly-runner/sign-0123456789ab && cd earthly-runner/sign-0123456789ab && EARTHLY_KEYCHAIN=\"$PWD/earthly.keychain\" /bin/sh -c 'codesign App.app'",
+		c.runnerArgs([]string{"/bin/sh", "-c", "codesign App.app"})[4])
+}
+
+func TestRunnerRelPath(t *testing.T) {
+	p, err := runnerRelPath("./build/App.app/")
+	assert.NoError(t, err)
+	assert.Equal(t, "build/App.app", p)
+	for _, p := range []string{"/tmp/App.app", "..", "../App.app", "build/../../App.app"} {
+		_, err := runnerRelPath(p)
+		assert.Error(t, err, p)
+	}
+}
+
+func TestRunnerResult(t *testing.T) {
+	ctx := context.Background()
+	c := &Converter{
+		opt:       ConvertOpt{AllowLocally: true},
+		mts:       &states.MultiTarget{Final: &states.SingleTarget{RanFromLike: true}},
+		runner:    &Runner{Host: "mac"},
+		runnerDir: "earthly-runner/sign-0123456789ab",
+	}
+	_, err := c.RunExitCode(ctx, ConvertRunOpts{CommandName: "IF", Args: []string{"test", "-f", "x"}, Locally: true})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "IF is not supported with LOCALLY --runner")
+	_, err = c.RunExpression(ctx, "x", ConvertRunOpts{CommandName: "FOR", Args: []string{"ls"}, Locally: true})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "FOR is not supported with LOCALLY --runner")
+}
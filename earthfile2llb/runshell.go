@@ -0,0 +1,113 @@
+package earthfile2llb
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	defaultRunShell = []string{"/bin/sh", "-c"}
+
+	// runShellPresets are the shells which can be selected by name, via SHELL
+	// or RUN --shell.
+	runShellPresets = map[string][]string{
+		"sh":   defaultRunShell,
+		"bash": {"/bin/bash", "-c"},
+		// bash-strict fails the command on the first failing statement (also
+		// within pipelines) and on undefined variables.
+		"bash-strict": {"/bin/bash", "-euo", "pipefail", "-c"},
+	}
+
+	safeShellWordRegexp = regexp.MustCompile(`^[A-Za-z0-9_/.,:=+@%-]+$`)
+)
+
+// ParseRunShell returns the shell of a preset name (sh, bash or bash-strict).
+func ParseRunShell(name string) ([]string, error) {
+	shell, ok := runShellPresets[name]
+	if !ok {
+		names := make([]string, 0, len(runShellPresets))
+		for n := range runShellPresets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, errors.Errorf("invalid shell %s; valid values are %s, or an exec form list such as [\"/bin/zsh\", \"-c\"]", name, strings.Join(names, ", "))
+	}
+	return append([]string{}, shell...), nil
+}
+
+// parseShellArgs parses the arguments of SHELL: either a preset name, or a
+// JSON list such as ["/bin/zsh", "-c"], the last element of which is the flag
+// which makes the shell execute the command passed after it.
+func parseShellArgs(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, errors.New("SHELL requires a shell")
+	}
+	joined := strings.Join(args, " ")
+	if strings.HasPrefix(joined, "[") {
+		var shell []string
+		err := json.Unmarshal([]byte(joined), &shell)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse SHELL exec form")
+		}
+		if len(shell) == 0 {
+			return nil, errors.New("SHELL requires a shell")
+		}
+		return shell, nil
+	}
+	if len(args) != 1 {
+		return nil, errors.New("SHELL takes a single shell name, or an exec form list")
+	}
+	return ParseRunShell(args[0])
+}
+
+// Shell applies the SHELL command, which sets the shell of the remaining RUN
+// commands of the target in shell form. The shell is passed on to targets
+// which build FROM this one, and so a SHELL in the base target sets the
+// default for the Earthfile.
+func (c *Converter) Shell(ctx context.Context, shell []string) error {
+	err := c.checkAllowed(shellCmd)
+	if err != nil {
+		return err
+	}
+	if c.locallyShell != "" {
+		return errors.New("SHELL is not supported with LOCALLY; use LOCALLY --shell instead")
+	}
+	if len(shell) == 0 {
+		return errors.New("SHELL requires a shell")
+	}
+	c.nonSaveCommand()
+	c.mts.Final.RunShell = shell
+	return nil
+}
+
+// runShell returns the shell which a RUN command in shell form is executed
+// with.
+func (c *Converter) runShell(opts ConvertRunOpts) []string {
+	switch {
+	case len(opts.Shell) != 0:
+		return opts.Shell
+	case len(c.mts.Final.RunShell) != 0 && !opts.Locally:
+		return c.mts.Final.RunShell
+	default:
+		return defaultRunShell
+	}
+}
+
+// quoteShellCommand quotes the words of a shell command, so that they can be
+// used as part of a /bin/sh script.
+func quoteShellCommand(shell []string) []string {
+	quoted := make([]string, 0, len(shell))
+	for _, word := range shell {
+		if safeShellWordRegexp.MatchString(word) {
+			quoted = append(quoted, word)
+			continue
+		}
+		quoted = append(quoted, "'"+escapeShellSingleQuotes(word)+"'")
+	}
+	return quoted
+}
@@ -0,0 +1,35 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseShellArgs(t *testing.T) {
+	shell, err := parseShellArgs([]string{"bash-strict"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/bin/bash", "-euo", "pipefail", "-c"}, shell)
+	shell, err = parseShellArgs([]string{`["/bin/zsh",`, `"-c"]`})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/bin/zsh", "-c"}, shell)
+	_, err = parseShellArgs([]string{"fish"})
+	assert.Error(t, err)
+	_, err = parseShellArgs([]string{"bash", "-c"})
+	assert.Error(t, err)
+	_, err = parseShellArgs([]string{"[]"})
+	assert.Error(t, err)
+	_, err = parseShellArgs(nil)
+	assert.Error(t, err)
+}
+
+func TestStrWithEnvVarsAndDockerShell(t *testing.T) {
+	assert.Equal(t, "A=1 /bin/sh -c 'echo hi'",
+		strWithEnvVarsAndDocker([]string{"echo", "hi"}, []string{"A=1"}, defaultRunShell, false, false, false, "", ""))
+	assert.Equal(t, " /bin/bash -euo pipefail -c 'echo '\"'\"'hi'\"'\"''",
+		strWithEnvVarsAndDocker([]string{"echo", "'hi'"}, nil, runShellPresets["bash-strict"], false, false, false, "", ""))
+	assert.Equal(t, " '/opt/my shell' -c 'true'",
+		strWithEnvVarsAndDocker([]string{"true"}, nil, []string{"/opt/my shell", "-c"}, false, false, false, "", ""))
+	assert.Equal(t, " echo hi",
+		strWithEnvVarsAndDocker([]string{"echo", "hi"}, nil, nil, false, false, false, "", ""))
+}
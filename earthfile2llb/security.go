@@ -0,0 +1,173 @@
+package earthfile2llb
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/earthly/earthly/debugger/common"
+	"github.com/pkg/errors"
+)
+
+// sandboxFlag makes the debugger apply the security options of a RUN command,
+// and then execute it.
+const sandboxFlag = "--sandbox"
+
+// RunSecurity tightens, or selectively loosens, the sandbox of a RUN command,
+// beyond what buildkitd supports natively. The options are applied from within
+// the container, by the debugger, right before the command is executed.
+type RunSecurity struct {
+	// CapAdd are capabilities granted in addition to the default ones. The
+	// command then runs privileged, minus the capabilities which are neither
+	// default nor added.
+	CapAdd []string
+	// CapDrop are capabilities removed from the command. ALL removes all of
+	// them, except those which are added.
+	CapDrop []string
+	// NoNewPrivileges prevents the command from gaining privileges, such as
+	// via setuid binaries.
+	NoNewPrivileges bool
+	// AppArmorProfile is the AppArmor profile the command is confined by. It
+	// must be loaded on the host of buildkitd.
+	AppArmorProfile string
+}
+
+// ParseRunSecurity parses the --cap-add, --cap-drop and --security-opt flags
+// of RUN.
+func ParseRunSecurity(capAdd, capDrop, securityOpts []string) (RunSecurity, error) {
+	s := RunSecurity{}
+	for _, c := range capAdd {
+		n, err := normalizeCapability(c, false)
+		if err != nil {
+			return RunSecurity{}, errors.Wrap(err, "invalid --cap-add")
+		}
+		s.CapAdd = append(s.CapAdd, n)
+	}
+	for _, c := range capDrop {
+		n, err := normalizeCapability(c, true)
+		if err != nil {
+			return RunSecurity{}, errors.Wrap(err, "invalid --cap-drop")
+		}
+		s.CapDrop = append(s.CapDrop, n)
+	}
+	for _, opt := range securityOpts {
+		key, value := opt, ""
+		if i := strings.IndexAny(opt, "=:"); i != -1 {
+			key, value = opt[:i], opt[i+1:]
+		}
+		switch key {
+		case "no-new-privileges":
+			if value != "" && value != "true" && value != "false" {
+				return RunSecurity{}, errors.Errorf("invalid --security-opt %s: must be true or false", opt)
+			}
+			s.NoNewPrivileges = value != "false"
+		case "apparmor":
+			if value == "" {
+				return RunSecurity{}, errors.Errorf("invalid --security-opt %s: missing profile", opt)
+			}
+			s.AppArmorProfile = value
+		case "seccomp":
+			return RunSecurity{}, errors.New("--security-opt seccomp is not supported: buildkitd applies its default seccomp profile, unless the command is --privileged")
+		default:
+			return RunSecurity{}, errors.Errorf("invalid --security-opt %s: must be no-new-privileges or apparmor=<profile>", opt)
+		}
+	}
+	return s, nil
+}
+
+func normalizeCapability(name string, allowAll bool) (string, error) {
+	if allowAll && strings.ToUpper(name) == "ALL" {
+		return "ALL", nil
+	}
+	n, err := common.ParseCapability(name)
+	if err != nil {
+		return "", err
+	}
+	return common.Capabilities[n], nil
+}
+
+// Empty returns whether the options leave the sandbox as is.
+func (s RunSecurity) Empty() bool {
+	return len(s.CapAdd) == 0 && len(s.CapDrop) == 0 && !s.NoNewPrivileges && s.AppArmorProfile == ""
+}
+
+// requiresPrivileged returns whether the command needs to run privileged for
+// the options to be applied.
+func (s RunSecurity) requiresPrivileged() bool {
+	return len(s.CapAdd) != 0
+}
+
+// sandboxArgs returns the args of the debugger which apply the options, given
+// whether the command runs privileged otherwise.
+func (s RunSecurity) sandboxArgs(privileged bool) []string {
+	var args []string
+	if s.NoNewPrivileges {
+		args = append(args, "--no-new-privileges")
+	}
+	if s.AppArmorProfile != "" {
+		args = append(args, "--apparmor="+s.AppArmorProfile)
+	}
+	dropAll := containsString(s.CapDrop, "ALL")
+	switch {
+	case len(s.CapAdd) != 0 && !privileged:
+		// The command runs privileged only so that the added capabilities
+		// are available.
+		keep := make(map[string]bool)
+		if !dropAll {
+			for _, c := range common.DefaultCapabilities {
+				keep[c] = true
+			}
+		}
+		for _, c := range s.CapAdd {
+			keep[c] = true
+		}
+		for _, c := range s.CapDrop {
+			delete(keep, c)
+		}
+		args = append(args, "--cap-keep="+joinCapabilities(keep))
+	case dropAll:
+		keep := make(map[string]bool)
+		for _, c := range s.CapAdd {
+			keep[c] = true
+		}
+		args = append(args, "--cap-keep="+joinCapabilities(keep))
+	case len(s.CapDrop) != 0:
+		drop := make(map[string]bool)
+		for _, c := range s.CapDrop {
+			drop[c] = true
+		}
+		args = append(args, "--cap-drop="+joinCapabilities(drop))
+	}
+	return args
+}
+
+// joinCapabilities returns the capabilities, comma-separated, in the order of
+// their number.
+func joinCapabilities(caps map[string]bool) string {
+	var names []string
+	for c := range caps {
+		names = append(names, c)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ni, _ := common.ParseCapability(names[i])
+		nj, _ := common.ParseCapability(names[j])
+		return ni < nj
+	})
+	return strings.Join(names, ",")
+}
+
+// withSandbox wraps args so that the security options are applied to the
+// command by the debugger.
+func withSandbox(args []string, s RunSecurity, privileged bool) []string {
+	wrapped := append([]string{debuggerPath, sandboxFlag}, s.sandboxArgs(privileged)...)
+	wrapped = append(wrapped, "--")
+	return append(wrapped, args...)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRunSecurity(t *testing.T) {
+	s, err := ParseRunSecurity([]string{"cap_sys_admin"}, []string{"NET_RAW", "all"}, []string{"no-new-privileges", "apparmor=build-strict"})
+	assert.NoError(t, err)
+	assert.Equal(t, RunSecurity{
+		CapAdd:          []string{"SYS_ADMIN"},
+		CapDrop:         []string{"NET_RAW", "ALL"},
+		NoNewPrivileges: true,
+		AppArmorProfile: "build-strict",
+	}, s)
+
+	s, err = ParseRunSecurity(nil, nil, []string{"no-new-privileges=false"})
+	assert.NoError(t, err)
+	assert.True(t, s.Empty())
+
+	_, err = ParseRunSecurity([]string{"ALL"}, nil, nil)
+	assert.Error(t, err)
+	_, err = ParseRunSecurity(nil, []string{"NOPE"}, nil)
+	assert.Error(t, err)
+	_, err = ParseRunSecurity(nil, nil, []string{"seccomp=profile.json"})
+	assert.Error(t, err)
+	_, err = ParseRunSecurity(nil, nil, []string{"apparmor="})
+	assert.Error(t, err)
+	_, err = ParseRunSecurity(nil, nil, []string{"label=disable"})
+	assert.Error(t, err)
+}
+
+func TestRunSecuritySandboxArgs(t *testing.T) {
+	tests := []struct {
+		s          RunSecurity
+		privileged bool
+		expected   []string
+	}{
+		{RunSecurity{NoNewPrivileges: true}, false, []string{"--no-new-privileges"}},
+		{RunSecurity{AppArmorProfile: "strict"}, false, []string{"--apparmor=strict"}},
+		{RunSecurity{CapDrop: []string{"NET_RAW", "CHOWN"}}, false, []string{"--cap-drop=CHOWN,NET_RAW"}},
+		{RunSecurity{CapDrop: []string{"ALL"}}, false, []string{"--cap-keep="}},
+		{RunSecurity{CapAdd: []string{"NET_ADMIN"}, CapDrop: []string{"ALL"}}, false, []string{"--cap-keep=NET_ADMIN"}},
+		{
+			RunSecurity{CapAdd: []string{"NET_ADMIN"}, CapDrop: []string{"MKNOD"}},
+			false,
+			[]string{"--cap-keep=CHOWN,DAC_OVERRIDE,FOWNER,FSETID,KILL,SETGID,SETUID,SETPCAP,NET_BIND_SERVICE,NET_ADMIN,NET_RAW,SYS_CHROOT,AUDIT_WRITE,SETFCAP"},
+		},
+		// Privileged commands already have all capabilities.
+		{RunSecurity{CapAdd: []string{"NET_ADMIN"}}, true, nil},
+		{RunSecurity{CapDrop: []string{"SYS_ADMIN"}}, true, []string{"--cap-drop=SYS_ADMIN"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, tt.s.sandboxArgs(tt.privileged))
+	}
+	assert.True(t, RunSecurity{CapAdd: []string{"NET_ADMIN"}}.requiresPrivileged())
+	assert.False(t, RunSecurity{CapDrop: []string{"NET_ADMIN"}}.requiresPrivileged())
+
+	assert.Equal(t,
+		[]string{debuggerPath, "--sandbox", "--no-new-privileges", "--", "/bin/sh", "-c", "make"},
+		withSandbox([]string{"/bin/sh", "-c", "make"}, RunSecurity{NoNewPrivileges: true}, false))
+}
@@ -0,0 +1,206 @@
+package earthfile2llb
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/earthly/earthly/debugger/common"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+)
+
+const (
+	// servicesFlag makes the debugger run the command alongside services.
+	servicesFlag = "--services"
+	// servicesDir is where the root filesystems of the services are mounted.
+	servicesDir = "/run/earthly/services"
+	// defaultServiceReadyTimeout is how long services are waited for to
+	// accept connections on their ports, unless specified otherwise.
+	defaultServiceReadyTimeout = time.Minute
+)
+
+var serviceNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// Service is a sidecar container started alongside a RUN command, as per
+// RUN --service. It shares the network of the command, which can reach it via
+// localhost.
+type Service struct {
+	// Name is the name of the service, which its logs are prefixed with.
+	Name string
+	// Image is the image the service runs.
+	Image string
+	// Env are variables set in addition to those of the image, as in KEY=value.
+	Env []string
+	// Ports are the TCP ports the service is ready once it accepts
+	// connections on. If empty, the TCP ports exposed by the image are used.
+	Ports []int
+	// ReadyTimeout is how long the service is waited for to be ready.
+	ReadyTimeout time.Duration
+}
+
+// ParseService parses a service, as in
+// image=postgres:13,name=db,env=POSTGRES_PASSWORD=secret,port=5432.
+// The name defaults to the last component of the repository of the image.
+func ParseService(s string) (Service, error) {
+	svc := Service{ReadyTimeout: defaultServiceReadyTimeout}
+	for _, kvPair := range strings.Split(s, ",") {
+		kvSplit := strings.SplitN(kvPair, "=", 2)
+		if len(kvSplit) != 2 || kvSplit[1] == "" {
+			return Service{}, errors.Errorf("invalid service arg %s", kvPair)
+		}
+		switch kvSplit[0] {
+		case "image":
+			svc.Image = kvSplit[1]
+		case "name":
+			svc.Name = kvSplit[1]
+		case "env":
+			if !strings.Contains(kvSplit[1], "=") {
+				return Service{}, errors.Errorf("invalid service env %s: must be KEY=value", kvSplit[1])
+			}
+			svc.Env = append(svc.Env, kvSplit[1])
+		case "port":
+			port, err := strconv.Atoi(kvSplit[1])
+			if err != nil || port <= 0 || port > 65535 {
+				return Service{}, errors.Errorf("invalid service port %s", kvSplit[1])
+			}
+			svc.Ports = append(svc.Ports, port)
+		case "ready-timeout":
+			d, err := time.ParseDuration(kvSplit[1])
+			if err != nil || d <= 0 {
+				return Service{}, errors.Errorf("invalid service ready-timeout %s", kvSplit[1])
+			}
+			svc.ReadyTimeout = d
+		default:
+			return Service{}, errors.Errorf("invalid service arg %s", kvPair)
+		}
+	}
+	if svc.Image == "" {
+		return Service{}, errors.Errorf("service %s does not specify an image", s)
+	}
+	if svc.Name == "" {
+		svc.Name = defaultServiceName(svc.Image)
+	}
+	if !serviceNameRegexp.MatchString(svc.Name) {
+		return Service{}, errors.Errorf("invalid service name %s", svc.Name)
+	}
+	return svc, nil
+}
+
+// ParseServices parses the services of a RUN command, whose names must be
+// unique.
+func ParseServices(ss []string) ([]Service, error) {
+	var services []Service
+	names := make(map[string]bool)
+	for _, s := range ss {
+		svc, err := ParseService(s)
+		if err != nil {
+			return nil, err
+		}
+		if names[svc.Name] {
+			return nil, errors.Errorf("duplicate service name %s: services of the same image require a name", svc.Name)
+		}
+		names[svc.Name] = true
+		services = append(services, svc)
+	}
+	return services, nil
+}
+
+// defaultServiceName returns the last component of the repository of the
+// image, as in postgres for docker.io/library/postgres:13.
+func defaultServiceName(image string) string {
+	name := image
+	if i := strings.IndexByte(name, '@'); i != -1 {
+		name = name[:i]
+	}
+	name = path.Base(name)
+	if i := strings.IndexByte(name, ':'); i != -1 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
+
+// serviceSpec returns how the debugger runs the service, given the config of
+// its image.
+func serviceSpec(svc Service, entrypoint, cmd, env []string, workingDir, user string, exposedPorts map[string]struct{}) (common.ServiceSpec, error) {
+	args := append(append([]string{}, entrypoint...), cmd...)
+	if len(args) == 0 {
+		return common.ServiceSpec{}, errors.Errorf("image %s of service %s has neither an entrypoint nor a command", svc.Image, svc.Name)
+	}
+	ports := svc.Ports
+	if len(ports) == 0 {
+		for p := range exposedPorts {
+			number, protocol := p, "tcp"
+			if i := strings.IndexByte(p, '/'); i != -1 {
+				number, protocol = p[:i], p[i+1:]
+			}
+			n, err := strconv.Atoi(number)
+			if err == nil && protocol == "tcp" {
+				ports = append(ports, n)
+			}
+		}
+		sort.Ints(ports)
+	}
+	return common.ServiceSpec{
+		Name:         svc.Name,
+		Root:         path.Join(servicesDir, svc.Name),
+		Args:         args,
+		Env:          append(append([]string{}, env...), svc.Env...),
+		WorkingDir:   workingDir,
+		User:         user,
+		Ports:        ports,
+		ReadyTimeout: svc.ReadyTimeout,
+	}, nil
+}
+
+// services resolves the images of the services, and returns how the debugger
+// runs them, along with the run options which mount them.
+func (c *Converter) services(ctx context.Context, services []Service) ([]common.ServiceSpec, []llb.RunOption, error) {
+	plat := llbutil.PlatformWithDefault(c.opt.Platform)
+	var specs []common.ServiceSpec
+	var roots []pllb.State
+	for _, svc := range services {
+		state, img, _, err := c.internalFromClassical(
+			ctx, svc.Image, plat, nil,
+			llb.WithCustomNamef("%sSERVICE %s %s", c.vertexPrefix(false, false), svc.Name, svc.Image))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "service %s", svc.Name)
+		}
+		spec, err := serviceSpec(
+			svc, img.Config.Entrypoint, img.Config.Cmd, img.Config.Env,
+			img.Config.WorkingDir, img.Config.User, img.Config.ExposedPorts)
+		if err != nil {
+			return nil, nil, err
+		}
+		specs = append(specs, spec)
+		roots = append(roots, state)
+	}
+	return specs, serviceRunOpts(specs, roots), nil
+}
+
+// serviceRunOpts mounts the root filesystems of the services. Their changes
+// are discarded.
+func serviceRunOpts(specs []common.ServiceSpec, roots []pllb.State) []llb.RunOption {
+	runOpts := []llb.RunOption{llb.AddMount(servicesDir, llb.Scratch(), llb.Tmpfs())}
+	for i, spec := range specs {
+		runOpts = append(runOpts, pllb.AddMount(spec.Root, roots[i], llb.ForceNoOutput))
+	}
+	return runOpts
+}
+
+// withServices wraps args so that the debugger runs the command alongside
+// the services.
+func withServices(args []string, specs []common.ServiceSpec) ([]string, error) {
+	dt, err := json.Marshal(specs)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal services")
+	}
+	return append([]string{debuggerPath, servicesFlag, string(dt), "--"}, args...), nil
+}
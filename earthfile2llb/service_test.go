@@ -0,0 +1,65 @@
+package earthfile2llb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseService(t *testing.T) {
+	svc, err := ParseService("image=postgres:13,env=POSTGRES_PASSWORD=secret,env=POSTGRES_DB=test")
+	assert.NoError(t, err)
+	assert.Equal(t, Service{
+		Name:         "postgres",
+		Image:        "postgres:13",
+		Env:          []string{"POSTGRES_PASSWORD=secret", "POSTGRES_DB=test"},
+		ReadyTimeout: defaultServiceReadyTimeout,
+	}, svc)
+
+	svc, err = ParseService("image=ghcr.io/org/cache@sha256:abc,name=cache-1,port=6379,ready-timeout=10s")
+	assert.NoError(t, err)
+	assert.Equal(t, "cache-1", svc.Name)
+	assert.Equal(t, []int{6379}, svc.Ports)
+	assert.Equal(t, 10*time.Second, svc.ReadyTimeout)
+
+	assert.Equal(t, "redis", defaultServiceName("docker.io/library/redis:6-alpine"))
+	assert.Equal(t, "cache", defaultServiceName("localhost:5000/cache"))
+
+	for _, s := range []string{
+		"name=db",
+		"image=postgres,port=http",
+		"image=postgres,env=NOVALUE",
+		"image=postgres,name=Db",
+		"image=postgres,ready-timeout=-1s",
+		"image=postgres,target=/db",
+	} {
+		_, err = ParseService(s)
+		assert.Error(t, err, s)
+	}
+
+	_, err = ParseServices([]string{"image=postgres:12", "image=postgres:13"})
+	assert.Error(t, err)
+	services, err := ParseServices([]string{"image=postgres:12", "image=postgres:13,name=postgres13"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(services))
+}
+
+func TestServiceSpec(t *testing.T) {
+	svc := Service{Name: "db", Image: "postgres:13", Env: []string{"POSTGRES_PASSWORD=secret"}, ReadyTimeout: time.Minute}
+	spec, err := serviceSpec(
+		svc, []string{"docker-entrypoint.sh"}, []string{"postgres"}, []string{"PATH=/usr/bin"},
+		"", "", map[string]struct{}{"5432/tcp": {}, "53/udp": {}, "8080": {}})
+	assert.NoError(t, err)
+	assert.Equal(t, "/run/earthly/services/db", spec.Root)
+	assert.Equal(t, []string{"docker-entrypoint.sh", "postgres"}, spec.Args)
+	assert.Equal(t, []string{"PATH=/usr/bin", "POSTGRES_PASSWORD=secret"}, spec.Env)
+	assert.Equal(t, []int{5432, 8080}, spec.Ports)
+
+	_, err = serviceSpec(svc, nil, nil, nil, "", "", nil)
+	assert.Error(t, err)
+
+	args, err := withServices([]string{"/bin/sh", "-c", "psql"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{debuggerPath, servicesFlag, "null", "--", "/bin/sh", "-c", "psql"}, args)
+}
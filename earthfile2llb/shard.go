@@ -0,0 +1,144 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The build args each shard of a BUILD --shards is built with.
+const (
+	shardIndexArg = "SHARD_INDEX"
+	shardCountArg = "SHARD_COUNT"
+	shardItemsArg = "SHARD_ITEMS"
+)
+
+// shardBuildArgs returns the build args of each shard of BUILD --shards, as
+// in SHARD_INDEX=0. The items, such as test files or packages, are split
+// across the shards, balanced by their weights (as in pkg/foo=1m30s) if any,
+// and passed on as a space-separated SHARD_ITEMS.
+func shardBuildArgs(shards string, items []string, weights string) ([][]string, error) {
+	n, err := strconv.Atoi(shards)
+	if err != nil || n < 1 {
+		return nil, errors.Errorf("invalid number of shards %s: must be a positive integer", shards)
+	}
+	w, err := parseShardWeights(weights)
+	if err != nil {
+		return nil, err
+	}
+	var partition [][]string
+	if len(items) != 0 {
+		partition = partitionShards(items, w, n)
+	}
+	ret := make([][]string, 0, n)
+	for i := 0; i < n; i++ {
+		args := []string{
+			fmt.Sprintf("%s=%d", shardIndexArg, i),
+			fmt.Sprintf("%s=%d", shardCountArg, n),
+		}
+		if partition != nil {
+			args = append(args, fmt.Sprintf("%s=%s", shardItemsArg, strings.Join(partition[i], " ")))
+		}
+		ret = append(ret, args)
+	}
+	return ret, nil
+}
+
+// splitShardItems splits the values of BUILD --shard-items, which are lists of
+// whitespace-separated items, removing duplicates.
+func splitShardItems(values []string) []string {
+	seen := make(map[string]bool)
+	var items []string
+	for _, v := range values {
+		for _, item := range strings.Fields(v) {
+			if !seen[item] {
+				seen[item] = true
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// parseShardWeights parses the whitespace-separated weights of BUILD
+// --shard-weights, as in "pkg/foo=1m30s pkg/bar=12s", typically the durations
+// of the items as recorded by a previous run. Plain numbers are seconds.
+func parseShardWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, kv := range strings.Fields(s) {
+		eq := strings.LastIndexByte(kv, '=')
+		if eq <= 0 {
+			return nil, errors.Errorf("invalid shard weight %s: must be <item>=<duration>", kv)
+		}
+		item, value := kv[:eq], kv[eq+1:]
+		var w float64
+		if d, err := time.ParseDuration(value); err == nil {
+			w = d.Seconds()
+		} else {
+			w, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, errors.Errorf("invalid shard weight %s: must be <item>=<duration>", kv)
+			}
+		}
+		if w < 0 {
+			return nil, errors.Errorf("invalid shard weight %s: must not be negative", kv)
+		}
+		weights[item] = w
+	}
+	return weights, nil
+}
+
+// partitionShards splits the items into n shards of about the same total
+// weight, by assigning the heaviest items first, each to the lightest shard.
+// Items without a weight are assumed to weigh the average of the known ones.
+// The items of each shard keep their original order, and the partition only
+// depends on the items and their weights, so that it is stable across runs.
+func partitionShards(items []string, weights map[string]float64, n int) [][]string {
+	var known float64
+	var numKnown int
+	for _, item := range items {
+		if w, ok := weights[item]; ok {
+			known += w
+			numKnown++
+		}
+	}
+	defaultWeight := 1.0
+	if numKnown != 0 {
+		defaultWeight = known / float64(numKnown)
+	}
+	weightOf := func(item string) float64 {
+		if w, ok := weights[item]; ok {
+			return w
+		}
+		return defaultWeight
+	}
+
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return weightOf(items[order[a]]) > weightOf(items[order[b]])
+	})
+	totals := make([]float64, n)
+	shardOf := make([]int, len(items))
+	for _, idx := range order {
+		lightest := 0
+		for s := 1; s < n; s++ {
+			if totals[s] < totals[lightest] {
+				lightest = s
+			}
+		}
+		totals[lightest] += weightOf(items[idx])
+		shardOf[idx] = lightest
+	}
+	shards := make([][]string, n)
+	for idx, item := range items {
+		shards[shardOf[idx]] = append(shards[shardOf[idx]], item)
+	}
+	return shards
+}
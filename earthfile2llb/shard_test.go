@@ -0,0 +1,60 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardBuildArgs(t *testing.T) {
+	args, err := shardBuildArgs("2", nil, "")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"SHARD_INDEX=0", "SHARD_COUNT=2"},
+		{"SHARD_INDEX=1", "SHARD_COUNT=2"},
+	}, args)
+
+	args, err = shardBuildArgs("2", []string{"a", "b", "c"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"SHARD_INDEX=0", "SHARD_COUNT=2", "SHARD_ITEMS=a c"},
+		{"SHARD_INDEX=1", "SHARD_COUNT=2", "SHARD_ITEMS=b"},
+	}, args)
+
+	// More shards than items.
+	args, err = shardBuildArgs("3", []string{"a"}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "SHARD_ITEMS=", args[2][2])
+
+	for _, shards := range []string{"0", "-1", "two", ""} {
+		_, err = shardBuildArgs(shards, nil, "")
+		assert.Error(t, err, shards)
+	}
+	_, err = shardBuildArgs("2", []string{"a"}, "a")
+	assert.Error(t, err)
+}
+
+func TestSplitShardItems(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, splitShardItems([]string{"a b\n", " c\ta"}))
+	assert.Nil(t, splitShardItems([]string{" "}))
+}
+
+func TestParseShardWeights(t *testing.T) {
+	weights, err := parseShardWeights("pkg/foo=1m30s pkg/bar=12 a=b=0.5")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"pkg/foo": 90, "pkg/bar": 12, "a=b": 0.5}, weights)
+
+	for _, s := range []string{"foo", "=1s", "foo=", "foo=fast", "foo=-1s"} {
+		_, err = parseShardWeights(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestPartitionShards(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+	weights := map[string]float64{"a": 10, "b": 1, "c": 4, "d": 5}
+	// e weighs the average, 5.
+	assert.Equal(t, [][]string{{"a", "c"}, {"b", "d", "e"}}, partitionShards(items, weights, 2))
+	assert.Equal(t, [][]string{{"a"}, {"c", "d"}, {"b", "e"}}, partitionShards(items, weights, 3))
+	assert.Equal(t, [][]string{items}, partitionShards(items, nil, 1))
+}
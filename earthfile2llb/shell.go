@@ -2,9 +2,11 @@ package earthfile2llb
 
 import (
 	"fmt"
+	"math"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const debuggerPath = "/usr/bin/earth_debugger"
@@ -37,7 +39,9 @@ func withShell(args []string, withShell bool) []string {
 	return args
 }
 
-func strWithEnvVarsAndDocker(args []string, envVars []string, withShell, withDebugger, forceDebugger, withDocker bool, exitCodeFile string, outputFile string) string {
+// strWithEnvVarsAndDocker returns the script which executes args, with the
+// given shell (as in /bin/sh -c), or directly if shell is empty.
+func strWithEnvVarsAndDocker(args []string, envVars []string, shell []string, withDebugger, forceDebugger, withDocker bool, exitCodeFile string, outputFile string) string {
 	var cmdParts []string
 	cmdParts = append(cmdParts, strings.Join(envVars, " "))
 	if withDocker {
@@ -50,7 +54,7 @@ func strWithEnvVarsAndDocker(args []string, envVars []string, withShell, withDeb
 			cmdParts = append(cmdParts, "--force")
 		}
 	}
-	if withShell {
+	if len(shell) != 0 {
 		var escapedArgs []string
 		for _, arg := range args {
 			escapedArgs = append(escapedArgs, escapeShellSingleQuotes(arg))
@@ -64,7 +68,7 @@ func strWithEnvVarsAndDocker(args []string, envVars []string, withShell, withDeb
 			escapedArgs = append(escapedArgs,
 				fmt.Sprintf("; echo $? >'\"'\"%s\"'\"'", escapeShellSingleQuotes(exitCodeFile)))
 		}
-		cmdParts = append(cmdParts, "/bin/sh", "-c")
+		cmdParts = append(cmdParts, quoteShellCommand(shell)...)
 		cmdParts = append(cmdParts, fmt.Sprintf("'%s'", strings.Join(escapedArgs, " ")))
 	} else {
 		cmdParts = append(cmdParts, args...)
@@ -72,39 +76,91 @@ func strWithEnvVarsAndDocker(args []string, envVars []string, withShell, withDeb
 	return strings.Join(cmdParts, " ")
 }
 
-type shellWrapFun func(args []string, envVars []string, withShell, withDebugger, forceDebugger bool) []string
+type shellWrapFun func(args []string, envVars []string, shell []string, withDebugger, forceDebugger bool) []string
 
-func withShellAndEnvVars(args []string, envVars []string, withShell, withDebugger, forceDebugger bool) []string {
+func withShellAndEnvVars(args []string, envVars []string, shell []string, withDebugger, forceDebugger bool) []string {
 	return []string{
 		"/bin/sh", "-c",
-		strWithEnvVarsAndDocker(args, envVars, withShell, withDebugger, forceDebugger, false, "", ""),
+		strWithEnvVarsAndDocker(args, envVars, shell, withDebugger, forceDebugger, false, "", ""),
 	}
 }
 
 func withShellAndEnvVarsExitCode(exitCodeFile string) shellWrapFun {
-	return func(args []string, envVars []string, withShell, withDebugger, forceDebugger bool) []string {
-		if !withShell {
+	return func(args []string, envVars []string, shell []string, withDebugger, forceDebugger bool) []string {
+		if len(shell) == 0 {
 			panic("unexpected exec mode")
 		}
 		return []string{
 			"/bin/sh", "-c",
-			strWithEnvVarsAndDocker(args, envVars, true, withDebugger, false, false, exitCodeFile, ""),
+			strWithEnvVarsAndDocker(args, envVars, shell, withDebugger, false, false, exitCodeFile, ""),
 		}
 	}
 }
 
 func withShellAndEnvVarsOutput(outputFile string) shellWrapFun {
-	return func(args []string, envVars []string, withShell, withDebugger, forceDebugger bool) []string {
-		if !withShell {
+	return func(args []string, envVars []string, shell []string, withDebugger, forceDebugger bool) []string {
+		if len(shell) == 0 {
 			panic("unexpected exec mode")
 		}
 		return []string{
 			"/bin/sh", "-c",
-			strWithEnvVarsAndDocker(args, envVars, true, withDebugger, false, false, "", outputFile),
+			strWithEnvVarsAndDocker(args, envVars, shell, withDebugger, false, false, "", outputFile),
 		}
 	}
 }
 
+// withProcessMemoryLimit wraps args so that the memory which each process of the
+// command may allocate is limited to the given number of bytes. The data
+// segment limit is used rather than the address space one, as it does not
+// count the address space which runtimes such as Go or the JVM reserve
+// without using it.
+func withProcessMemoryLimit(args []string, limit int64) []string {
+	kb := (limit + 1023) / 1024
+	return append([]string{"/bin/sh", "-c", fmt.Sprintf(`ulimit -d %d && exec "$@"`, kb), "earthly-process-memory"}, args...)
+}
+
+// withTimeout wraps args so that the command is terminated if it runs for
+// longer than the given timeout. A message is printed when that happens, so
+// that it is clear from the (partial) output of the command why it failed.
+// The command is run in its own process group, where setsid is available, so
+// that the processes it started are terminated along with it. Its stdin is
+// passed via another file descriptor, as the stdin of background commands is
+// /dev/null otherwise.
+func withTimeout(args []string, timeout time.Duration) []string {
+	script := fmt.Sprintf(`exec 3<&0
+if command -v setsid >/dev/null 2>&1; then
+	setsid "$@" <&3 3<&- &
+else
+	"$@" <&3 3<&- &
+fi
+pid=$!
+exec 3<&-
+( sleep %d >/dev/null 2>&1; echo "earthly: command timed out after %s" >&2; kill -s TERM -- -$pid 2>/dev/null || kill -s TERM $pid 2>/dev/null; sleep 10 >/dev/null 2>&1; kill -s KILL -- -$pid 2>/dev/null || kill -s KILL $pid 2>/dev/null ) &
+watcher=$!
+wait $pid
+code=$?
+kill $watcher 2>/dev/null
+exit $code`, int64(math.Ceil(timeout.Seconds())), timeout)
+	return append([]string{"/bin/sh", "-c", script, "earthly-timeout"}, args...)
+}
+
+// withRetries wraps args so that the command is executed again if it fails,
+// up to the given number of retries.
+func withRetries(args []string, retries int, delay time.Duration) []string {
+	script := fmt.Sprintf(`attempt=1
+while true; do
+	"$@" && exit 0
+	code=$?
+	if [ "$attempt" -gt %d ]; then
+		exit "$code"
+	fi
+	echo "earthly: command failed with exit code $code (attempt $attempt of %d); retrying in %s" >&2
+	sleep %d
+	attempt=$((attempt + 1))
+done`, retries, retries+1, delay, int64(math.Ceil(delay.Seconds())))
+	return append([]string{"/bin/sh", "-c", script, "earthly-retry"}, args...)
+}
+
 func escapeShellSingleQuotes(arg string) string {
 	return strings.Replace(arg, "'", "'\"'\"'", -1)
 }
@@ -0,0 +1,50 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+)
+
+// snapshotDir is where the snapshot of the docker state taken after the setup
+// command of WITH DOCKER --setup is mounted.
+const snapshotDir = "/var/earthly/snapshot"
+
+// setupSnapshot runs the setup command of WITH DOCKER --setup in a separate
+// op, which snapshots the resulting state of dockerd (images, containers and
+// volumes). Being an op of its own, the snapshot is cached by buildkit as per
+// the hash of its inputs (the state of the target, the loaded images, the
+// compose files and the setup command), and so it is reused by later builds
+// and by parallel builds with the same inputs.
+func (wdr *withDockerRun) setupSnapshot(dindID string, tarPaths []string, loadRunOpts []llb.RunOption, opt WithDockerOpt) pllb.State {
+	params := []string{
+		fmt.Sprintf("EARTHLY_DOCKERD_DATA_ROOT=\"%s\"", path.Join("/var/earthly/dind", dindID+"-setup")),
+		fmt.Sprintf("EARTHLY_DOCKER_LOAD_FILES=\"%s\"", strings.Join(tarPaths, " ")),
+		fmt.Sprintf("EARTHLY_DOCKERD_SNAPSHOT=\"%s\"", snapshotDir),
+	}
+	params = append(params, composeParams(opt)...)
+	params = append(params, awaitParams(opt)...)
+	runOpts := []llb.RunOption{
+		llb.Args(snapshotArgs(params, opt.Setup)),
+		llb.Security(llb.SecurityModeInsecure),
+		pllb.AddMount("/var/earthly/dind", pllb.Scratch(), llb.HostBind(), llb.SourcePath("/tmp/earthly/dind")),
+		pllb.AddMount(dockerdWrapperPath, pllb.Scratch(), llb.HostBind(), llb.SourcePath(dockerdWrapperPath)),
+		awaitRunOpt(),
+		llb.WithCustomNamef("%sWITH DOCKER --setup %s", wdr.c.vertexPrefix(false, false), opt.Setup),
+	}
+	runOpts = append(runOpts, loadRunOpts...)
+	return wdr.c.mts.Final.MainState.Run(runOpts...).AddMount(snapshotDir, pllb.Scratch())
+}
+
+// snapshotArgs returns the args of the op running the setup command, via the
+// snapshot command of the dockerd wrapper.
+func snapshotArgs(params []string, setup string) []string {
+	return []string{
+		"/bin/sh", "-c",
+		fmt.Sprintf("%s %s snapshot /bin/sh -c '%s'",
+			strings.Join(params, " "), dockerdWrapperPath, escapeShellSingleQuotes(setup)),
+	}
+}
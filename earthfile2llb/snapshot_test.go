@@ -0,0 +1,15 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotArgs(t *testing.T) {
+	args := snapshotArgs([]string{`EARTHLY_DOCKERD_SNAPSHOT="/var/earthly/snapshot"`}, "psql -c 'select 1' && ./seed.sh")
+	assert.Equal(t, []string{
+		"/bin/sh", "-c",
+		`EARTHLY_DOCKERD_SNAPSHOT="/var/earthly/snapshot" /var/earthly/dockerd-wrapper.sh snapshot /bin/sh -c 'psql -c '"'"'select 1'"'"' && ./seed.sh'`,
+	}, args)
+}
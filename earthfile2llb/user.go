@@ -0,0 +1,61 @@
+package earthfile2llb
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultNonRootUser is the user of USER --non-root, if none is given nor
+// configured.
+const defaultNonRootUser = "1000:1000"
+
+// isRootUser returns whether the user, as in the USER of an image, is root.
+// An empty user is root.
+func isRootUser(user string) bool {
+	name := user
+	if i := strings.IndexByte(user, ':'); i != -1 {
+		name = user[:i]
+	}
+	return name == "" || name == "root" || name == "0"
+}
+
+// ValidateDefaultUser returns an error if the user cannot be the default user
+// of RUN commands.
+func ValidateDefaultUser(user string) error {
+	if user != "" && isRootUser(user) {
+		return errors.Errorf("invalid default user %s: must not be root", user)
+	}
+	return nil
+}
+
+// nonRootUserOf returns the user of USER --non-root, given the user it is
+// invoked with, if any.
+func (c *Converter) nonRootUserOf(user string) (string, error) {
+	if user == "" {
+		user = c.opt.DefaultUser
+	}
+	if user == "" {
+		user = defaultNonRootUser
+	}
+	if isRootUser(user) {
+		return "", errors.Errorf("USER --non-root %s: the user is root", user)
+	}
+	return user, nil
+}
+
+// applyUserPolicy switches to the non-root user of the target, if its base
+// runs as root. The default user only applies to the images the target
+// starts FROM, so that targets which switch back to root via USER, such as
+// to install packages, keep doing so for the targets which build FROM them.
+func (c *Converter) applyUserPolicy(fromImage bool) {
+	user := c.nonRootUser
+	if user == "" && fromImage {
+		user = c.opt.DefaultUser
+	}
+	if user == "" || !isRootUser(c.mts.Final.MainImage.Config.User) {
+		return
+	}
+	c.mts.Final.MainState = c.mts.Final.MainState.User(user)
+	c.mts.Final.MainImage.Config.User = user
+}
@@ -0,0 +1,38 @@
+package earthfile2llb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRootUser(t *testing.T) {
+	assert.True(t, isRootUser(""))
+	assert.True(t, isRootUser("root"))
+	assert.True(t, isRootUser("0:0"))
+	assert.True(t, isRootUser("root:staff"))
+	assert.False(t, isRootUser("1000"))
+	assert.False(t, isRootUser("app:root"))
+
+	assert.NoError(t, ValidateDefaultUser(""))
+	assert.NoError(t, ValidateDefaultUser("1000:1000"))
+	assert.Error(t, ValidateDefaultUser("0"))
+}
+
+func TestNonRootUserOf(t *testing.T) {
+	c := &Converter{}
+	user, err := c.nonRootUserOf("")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultNonRootUser, user)
+
+	c.opt.DefaultUser = "app"
+	user, err = c.nonRootUserOf("")
+	assert.NoError(t, err)
+	assert.Equal(t, "app", user)
+	user, err = c.nonRootUserOf("2000:2000")
+	assert.NoError(t, err)
+	assert.Equal(t, "2000:2000", user)
+
+	_, err = c.nonRootUserOf("root")
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/pkg/errors"
+)
+
+// volumesDir is where the persistent volumes of WITH DOCKER are mounted, for
+// the dockerd wrapper to create the docker volumes out of them.
+const volumesDir = "/var/earthly/volumes"
+
+var volumeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Volume is a named docker volume of WITH DOCKER which persists across
+// builds, as per WITH DOCKER --volume, such as for the data of a database
+// which takes long to seed.
+type Volume struct {
+	// Name is the name of the docker volume.
+	Name string
+	// MaxAge is how long the volume is kept unused before its contents are
+	// discarded. Zero means the volume is only discarded by the garbage
+	// collection of the cache of buildkitd.
+	MaxAge time.Duration
+}
+
+// ParseVolume parses a volume spec, as in name=pgdata,max-age=168h.
+func ParseVolume(s string) (Volume, error) {
+	var v Volume
+	for _, kvPair := range strings.Split(s, ",") {
+		kvSplit := strings.SplitN(kvPair, "=", 2)
+		if len(kvSplit) != 2 || kvSplit[1] == "" {
+			return Volume{}, errors.Errorf("invalid volume arg %s", kvPair)
+		}
+		switch kvSplit[0] {
+		case "name":
+			v.Name = kvSplit[1]
+		case "max-age":
+			d, err := time.ParseDuration(kvSplit[1])
+			if err != nil || d <= 0 {
+				return Volume{}, errors.Errorf("invalid volume max-age %s", kvSplit[1])
+			}
+			v.MaxAge = d
+		default:
+			return Volume{}, errors.Errorf("invalid volume arg %s", kvPair)
+		}
+	}
+	if v.Name == "" {
+		return Volume{}, errors.Errorf("volume %s does not specify a name", s)
+	}
+	if !volumeNameRegexp.MatchString(v.Name) {
+		return Volume{}, errors.Errorf("invalid volume name %s", v.Name)
+	}
+	return v, nil
+}
+
+// ParseVolumes parses the specs of the volumes of a WITH DOCKER command, whose
+// names need to be unique.
+func ParseVolumes(specs []string) ([]Volume, error) {
+	var volumes []Volume
+	seen := make(map[string]bool)
+	for _, s := range specs {
+		v, err := ParseVolume(s)
+		if err != nil {
+			return nil, err
+		}
+		if seen[v.Name] {
+			return nil, errors.Errorf("duplicate volume %s", v.Name)
+		}
+		seen[v.Name] = true
+		volumes = append(volumes, v)
+	}
+	return volumes, nil
+}
+
+// volumeRunOpts mounts the volumes, which are cache mounts of the cache
+// namespace, so that they are shared by all the targets which use them.
+// Each volume is locked for the duration of the command.
+func volumeRunOpts(volumes []Volume, cacheNamespace string) []llb.RunOption {
+	var runOpts []llb.RunOption
+	for _, v := range volumes {
+		cachePath := path.Join("/run/cache", cacheNamespace, "volumes", v.Name)
+		runOpts = append(runOpts, pllb.AddMount(
+			path.Join(volumesDir, v.Name), pllb.Scratch(),
+			llb.AsPersistentCacheDir(cachePath, llb.CacheMountLocked)))
+	}
+	return runOpts
+}
+
+// volumeParams returns the settings of the dockerd wrapper for the volumes,
+// as in name:max-age-in-seconds.
+func volumeParams(volumes []Volume) []string {
+	specs := make([]string, 0, len(volumes))
+	for _, v := range volumes {
+		specs = append(specs, fmt.Sprintf("%s:%d", v.Name, int64(v.MaxAge.Seconds())))
+	}
+	return []string{fmt.Sprintf("EARTHLY_VOLUMES=\"%s\"", strings.Join(specs, " "))}
+}
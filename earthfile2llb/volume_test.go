@@ -0,0 +1,37 @@
+package earthfile2llb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVolume(t *testing.T) {
+	v, err := ParseVolume("name=pgdata")
+	assert.NoError(t, err)
+	assert.Equal(t, Volume{Name: "pgdata"}, v)
+
+	v, err = ParseVolume("name=seed.db_1,max-age=168h")
+	assert.NoError(t, err)
+	assert.Equal(t, Volume{Name: "seed.db_1", MaxAge: 168 * time.Hour}, v)
+
+	for _, s := range []string{"", "pgdata", "name=", "max-age=1h", "name=pg data", "name=-pg", "name=pg,max-age=0s", "name=pg,max-age=7d", "name=pg,size=1g"} {
+		_, err = ParseVolume(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseVolumes(t *testing.T) {
+	volumes, err := ParseVolumes([]string{"name=pgdata", "name=redis,max-age=24h"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Volume{{Name: "pgdata"}, {Name: "redis", MaxAge: 24 * time.Hour}}, volumes)
+
+	_, err = ParseVolumes([]string{"name=pgdata", "name=pgdata,max-age=1h"})
+	assert.Error(t, err)
+}
+
+func TestVolumeParams(t *testing.T) {
+	assert.Equal(t, []string{`EARTHLY_VOLUMES="pgdata:0 redis:86400"`}, volumeParams([]Volume{{Name: "pgdata"}, {Name: "redis", MaxAge: 24 * time.Hour}}))
+	assert.Equal(t, []string{`EARTHLY_VOLUMES=""`}, volumeParams(nil))
+}
@@ -0,0 +1,97 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/earthly/earthly/util/llbutil"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// windowsImagePrefixes are the well-known repositories of Windows base images.
+var windowsImagePrefixes = []string{
+	"mcr.microsoft.com/windows",
+	"mcr.microsoft.com/powershell:nanoserver",
+	"mcr.microsoft.com/dotnet/framework",
+}
+
+func looksLikeWindowsImage(imageName string) bool {
+	for _, prefix := range windowsImagePrefixes {
+		if strings.HasPrefix(imageName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWindowsBackend ensures that at least one of the buildkit workers is
+// able to run Windows containers for the given platform.
+func (c *Converter) checkWindowsBackend(platform *specs.Platform) error {
+	if !llbutil.IsWindows(platform) {
+		return nil
+	}
+	var available []string
+	for _, w := range c.opt.GwClient.BuildOpts().Workers {
+		for _, wp := range w.Platforms {
+			if wp.OS == "windows" {
+				return nil
+			}
+			available = append(available, platforms.Format(wp))
+		}
+	}
+	return errors.Errorf(
+		"platform %s requires a buildkit backend with Windows workers, but the connected backend only supports %s; "+
+			"connect to a Windows buildkitd via --buildkit-host",
+		llbutil.PlatformToString(platform), strings.Join(available, ", "))
+}
+
+// checkWindowsRunOpts returns an error for any RUN option that relies on
+// Linux-only facilities when the target platform is Windows.
+func checkWindowsRunOpts(opts ConvertRunOpts) error {
+	unsupported := func(what string) error {
+		return errors.Errorf("%s is not supported when building Windows images", what)
+	}
+	switch {
+	case opts.Privileged:
+		return unsupported("--privileged")
+	case opts.WithSSH:
+		return unsupported("--ssh")
+	case len(opts.Secrets) != 0:
+		return unsupported("--secret")
+	case len(opts.Mounts) != 0:
+		return unsupported("--mount")
+	case opts.Interactive || opts.InteractiveKeep:
+		return unsupported("interactive mode")
+	case opts.ProcessMemory != 0:
+		return unsupported("--process-memory")
+	case opts.Timeout != 0:
+		return unsupported("--timeout")
+	case opts.Retries != 0:
+		return unsupported("--retries")
+	case len(opts.DNS.Nameservers) != 0 || len(opts.DNS.SearchDomains) != 0:
+		return unsupported("--dns and --dns-search")
+	case !opts.Security.Empty():
+		return unsupported("--cap-add, --cap-drop and --security-opt")
+	case opts.GPUs != "":
+		return unsupported("--gpus")
+	case len(opts.Services) != 0:
+		return unsupported("--service")
+	case len(opts.Tests.Reports) != 0:
+		return unsupported("--junit")
+	case len(opts.Shell) != 0:
+		return unsupported("--shell")
+	case opts.shellWrap != nil:
+		return unsupported(fmt.Sprintf("%s with a command expression", opts.CommandName))
+	}
+	return nil
+}
+
+// withWindowsShell is the Windows counterpart of withShell.
+func withWindowsShell(args []string, withShell bool) []string {
+	if withShell {
+		return []string{"cmd", "/S", "/C", strings.Join(args, " ")}
+	}
+	return args
+}
@@ -10,6 +10,7 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/containerd/containerd/platforms"
 	"github.com/earthly/earthly/dockertar"
@@ -50,7 +51,9 @@ type WithDockerOpt struct {
 	Mounts          []string
 	Secrets         []string
 	WithShell       bool
+	Shell           []string
 	WithEntrypoint  bool
+	Privileged      bool
 	NoCache         bool
 	Interactive     bool
 	interactiveKeep bool
@@ -58,6 +61,14 @@ type WithDockerOpt struct {
 	Loads           []DockerLoadOpt
 	ComposeFiles    []string
 	ComposeServices []string
+	Security        RunSecurity
+	GPUs            string
+	WaitForPorts    []string
+	WaitForHTTP     []string
+	WaitTimeout     time.Duration
+	StreamLogs      bool
+	Volumes         []Volume
+	Setup           string
 }
 
 type withDockerRun struct {
@@ -137,19 +148,29 @@ func (wdr *withDockerRun) Run(ctx context.Context, args []string, opt WithDocker
 		Secrets:         opt.Secrets,
 		WithEntrypoint:  opt.WithEntrypoint,
 		WithShell:       opt.WithShell,
+		Shell:           opt.Shell,
 		Privileged:      true, // needed for dockerd
 		NoCache:         opt.NoCache,
 		Interactive:     opt.Interactive,
 		InteractiveKeep: opt.interactiveKeep,
+		Security:        opt.Security,
+		GPUs:            opt.GPUs,
+
+		// dockerd needs privileged mode, which only needs approval if the
+		// command asks for it too.
+		privilegedImplied: !opt.Privileged,
 	}
 	crOpts.extraRunOpts = append(crOpts.extraRunOpts, pllb.AddMount(
 		"/var/earthly/dind", pllb.Scratch(), llb.HostBind(), llb.SourcePath("/tmp/earthly/dind")))
 	crOpts.extraRunOpts = append(crOpts.extraRunOpts, pllb.AddMount(
 		dockerdWrapperPath, pllb.Scratch(), llb.HostBind(), llb.SourcePath(dockerdWrapperPath)))
+	crOpts.extraRunOpts = append(crOpts.extraRunOpts, awaitRunOpt())
+	crOpts.extraRunOpts = append(crOpts.extraRunOpts, volumeRunOpts(opt.Volumes, wdr.c.opt.CacheNamespace)...)
 	var tarPaths []string
+	var loadRunOpts []llb.RunOption
 	for index, tarContext := range wdr.tarLoads {
 		loadDir := fmt.Sprintf("/var/earthly/load-%d", index)
-		crOpts.extraRunOpts = append(crOpts.extraRunOpts, pllb.AddMount(loadDir, tarContext, llb.Readonly))
+		loadRunOpts = append(loadRunOpts, pllb.AddMount(loadDir, tarContext, llb.Readonly))
 		tarPaths = append(tarPaths, path.Join(loadDir, "image.tar"))
 	}
 
@@ -157,6 +178,14 @@ func (wdr *withDockerRun) Run(ctx context.Context, args []string, opt WithDocker
 	if err != nil {
 		return errors.Wrap(err, "compute dind id")
 	}
+	if opt.Setup != "" {
+		// The images are loaded by the setup, and restored from its snapshot.
+		snapshot := wdr.setupSnapshot(dindID, tarPaths, loadRunOpts, opt)
+		crOpts.extraRunOpts = append(crOpts.extraRunOpts, pllb.AddMount(snapshotDir, snapshot, llb.Readonly))
+		tarPaths = nil
+	} else {
+		crOpts.extraRunOpts = append(crOpts.extraRunOpts, loadRunOpts...)
+	}
 	crOpts.shellWrap = makeWithDockerdWrapFun(dindID, tarPaths, opt)
 
 	_, err = wdr.c.internalRun(ctx, crOpts)
@@ -246,7 +275,7 @@ func (wdr *withDockerRun) getComposePulls(ctx context.Context, opt WithDockerOpt
 func (wdr *withDockerRun) pull(ctx context.Context, opt DockerPullOpt) error {
 	plat := llbutil.PlatformWithDefault(opt.Platform)
 	state, image, _, err := wdr.c.internalFromClassical(
-		ctx, opt.ImageName, plat,
+		ctx, opt.ImageName, plat, nil,
 		llb.WithCustomNamef("%sDOCKER PULL %s", wdr.c.imageVertexPrefix(opt.ImageName), opt.ImageName),
 	)
 	if err != nil {
@@ -352,16 +381,26 @@ func makeWithDockerdWrapFun(dindID string, tarPaths []string, opt WithDockerOpt)
 		fmt.Sprintf("EARTHLY_DOCKER_LOAD_FILES=\"%s\"", strings.Join(tarPaths, " ")),
 	}
 	params = append(params, composeParams(opt)...)
-	return func(args []string, envVars []string, isWithShell, withDebugger, forceDebugger bool) []string {
+	params = append(params, awaitParams(opt)...)
+	params = append(params, fmt.Sprintf("EARTHLY_STREAM_LOGS=\"%t\"", opt.StreamLogs))
+	params = append(params, volumeParams(opt.Volumes)...)
+	if opt.Setup != "" {
+		params = append(params, fmt.Sprintf("EARTHLY_DOCKERD_RESTORE=\"%s\"", snapshotDir))
+	}
+	return func(args []string, envVars []string, shell []string, withDebugger, forceDebugger bool) []string {
 		envVars2 := append(params, envVars...)
 		return []string{
 			"/bin/sh", "-c",
-			strWithEnvVarsAndDocker(args, envVars2, isWithShell, withDebugger, forceDebugger, true, "", ""),
+			strWithEnvVarsAndDocker(args, envVars2, shell, withDebugger, forceDebugger, true, "", ""),
 		}
 	}
 }
 
 func (wdr *withDockerRun) getComposeConfig(ctx context.Context, opt WithDockerOpt) ([]byte, error) {
+	err := wdr.c.checkExecution("reading the compose config")
+	if err != nil {
+		return nil, err
+	}
 	// Add the right run to fetch the docker compose config.
 	params := composeParams(opt)
 	args := []string{
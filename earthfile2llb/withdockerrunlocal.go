@@ -24,6 +24,15 @@ func (wdrl *withDockerRunLocal) Run(ctx context.Context, args []string, opt With
 		return err
 	}
 	wdrl.c.nonSaveCommand()
+	if len(opt.WaitForPorts) != 0 || len(opt.WaitForHTTP) != 0 {
+		return errors.New("--wait-for-port and --wait-for-http not supported with LOCALLY")
+	}
+	if len(opt.Volumes) != 0 {
+		return errors.New("--volume not supported with LOCALLY")
+	}
+	if opt.Setup != "" {
+		return errors.New("--setup not supported with LOCALLY")
+	}
 
 	for _, loadOpt := range opt.Loads {
 		// Load.
@@ -47,6 +56,7 @@ func (wdrl *withDockerRunLocal) Run(ctx context.Context, args []string, opt With
 		Secrets:         opt.Secrets,
 		WithEntrypoint:  opt.WithEntrypoint,
 		WithShell:       opt.WithShell,
+		Shell:           opt.Shell,
 		NoCache:         opt.NoCache,
 		Interactive:     opt.Interactive,
 		InteractiveKeep: opt.interactiveKeep,
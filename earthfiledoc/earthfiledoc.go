@@ -0,0 +1,247 @@
+// Package earthfiledoc extracts the documentation of the targets, ARGs and
+// user commands of an Earthfile from their doc comments, and renders it for
+// earthly doc.
+package earthfiledoc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/earthly/earthly/ast/spec"
+)
+
+// Arg is a documented ARG.
+type Arg struct {
+	Name string
+	// Default is the default value of the ARG, as written in the Earthfile.
+	Default    string
+	HasDefault bool
+	Docs       string
+}
+
+// Entry is a documented target or user command.
+type Entry struct {
+	// Name is the name of the target (as in +build) or user command (as in
+	// BUILD_GO).
+	Name string
+	Docs string
+	Args []Arg
+}
+
+// Doc is the documentation of an Earthfile.
+type Doc struct {
+	GlobalArgs []Arg
+	Targets    []Entry
+	Commands   []Entry
+}
+
+// New extracts the documentation of the Earthfile. The ARGs declared in the
+// base target are the global ones.
+func New(ef spec.Earthfile) Doc {
+	doc := Doc{GlobalArgs: blockArgs(ef.BaseRecipe)}
+	for _, t := range ef.Targets {
+		doc.Targets = append(doc.Targets, Entry{Name: "+" + t.Name, Docs: t.Docs, Args: blockArgs(t.Recipe)})
+	}
+	for _, uc := range ef.UserCommands {
+		doc.Commands = append(doc.Commands, Entry{Name: uc.Name, Docs: uc.Docs, Args: blockArgs(uc.Recipe)})
+	}
+	return doc
+}
+
+// Target returns the documentation of the target with the given name (as in
+// build), along with the global ARGs, or false if there is no such target.
+func (d Doc) Target(name string) (Doc, bool) {
+	for _, t := range d.Targets {
+		if t.Name == "+"+name {
+			return Doc{GlobalArgs: d.GlobalArgs, Targets: []Entry{t}}, true
+		}
+	}
+	return Doc{}, false
+}
+
+// blockArgs returns the ARGs declared by the block, including within nested
+// blocks, in order and without duplicates.
+func blockArgs(block spec.Block) []Arg {
+	ret := []Arg{}
+	seen := make(map[string]bool)
+	var walk func(block spec.Block)
+	walk = func(block spec.Block) {
+		for _, stmt := range block {
+			switch {
+			case stmt.Command != nil:
+				arg, ok := parseArg(*stmt.Command)
+				if ok && !seen[arg.Name] {
+					seen[arg.Name] = true
+					ret = append(ret, arg)
+				}
+			case stmt.With != nil:
+				walk(stmt.With.Body)
+			case stmt.If != nil:
+				walk(stmt.If.IfBody)
+				for _, elseIf := range stmt.If.ElseIf {
+					walk(elseIf.Body)
+				}
+				if stmt.If.ElseBody != nil {
+					walk(*stmt.If.ElseBody)
+				}
+			case stmt.For != nil:
+				walk(stmt.For.Body)
+			}
+		}
+	}
+	walk(block)
+	return ret
+}
+
+// parseArg parses an ARG command, whose args are of the form
+// [--host] [--secret <secret>] <name> [= <default>].
+func parseArg(cmd spec.Command) (Arg, bool) {
+	if cmd.Name != "ARG" {
+		return Arg{}, false
+	}
+	arg := Arg{Docs: cmd.Docs}
+	i := 0
+	for ; i < len(cmd.Args) && strings.HasPrefix(cmd.Args[i], "--"); i++ {
+		if cmd.Args[i] == "--secret" {
+			// Skip the value of the flag.
+			i++
+		}
+	}
+	rest := cmd.Args[i:]
+	if len(rest) == 0 {
+		return Arg{}, false
+	}
+	parts := strings.SplitN(rest[0], "=", 2)
+	arg.Name = parts[0]
+	switch {
+	case len(parts) == 2:
+		arg.Default, arg.HasDefault = parts[1], true
+	case len(rest) >= 3 && rest[1] == "=":
+		arg.Default, arg.HasDefault = strings.Join(rest[2:], " "), true
+	}
+	return arg, true
+}
+
+// WriteText writes the documentation in a form suitable for terminals.
+func (d Doc) WriteText(w io.Writer) error {
+	sections := []struct {
+		title   string
+		entries []Entry
+	}{
+		{"TARGETS", d.Targets},
+		{"COMMANDS", d.Commands},
+	}
+	first := true
+	if len(d.GlobalArgs) != 0 {
+		fmt.Fprintln(w, "GLOBAL ARGS")
+		err := writeTextArgs(w, "  ", d.GlobalArgs)
+		if err != nil {
+			return err
+		}
+		first = false
+	}
+	for _, section := range sections {
+		if len(section.entries) == 0 {
+			continue
+		}
+		if !first {
+			fmt.Fprintln(w)
+		}
+		first = false
+		fmt.Fprintln(w, section.title)
+		for i, e := range section.entries {
+			if i != 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "  %s\n", e.Name)
+			for _, line := range strings.Split(e.Docs, "\n") {
+				if line != "" {
+					fmt.Fprintf(w, "      %s\n", line)
+				}
+			}
+			err := writeTextArgs(w, "      ", e.Args)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeTextArgs(w io.Writer, indent string, args []Arg) error {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	for _, arg := range args {
+		fmt.Fprintf(tw, "%s%s\t%s\n", indent, argSignature(arg), strings.Join(strings.Fields(arg.Docs), " "))
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	// Args without docs would otherwise be padded with trailing spaces.
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line != "" {
+			fmt.Fprintln(w, strings.TrimRight(line, " \n"))
+		}
+	}
+	return nil
+}
+
+func argSignature(arg Arg) string {
+	if arg.HasDefault {
+		return "--" + arg.Name + "=" + arg.Default
+	}
+	return "--" + arg.Name
+}
+
+// WriteMarkdown writes the documentation as Markdown, under a heading with
+// the given title.
+func (d Doc) WriteMarkdown(w io.Writer, title string) error {
+	fmt.Fprintf(w, "# %s\n", title)
+	if len(d.GlobalArgs) != 0 {
+		fmt.Fprintf(w, "\n## Global args\n\n")
+		writeMarkdownArgs(w, d.GlobalArgs)
+	}
+	if len(d.Targets) != 0 {
+		fmt.Fprintf(w, "\n## Targets\n")
+		writeMarkdownEntries(w, d.Targets)
+	}
+	if len(d.Commands) != 0 {
+		fmt.Fprintf(w, "\n## Commands\n")
+		writeMarkdownEntries(w, d.Commands)
+	}
+	return nil
+}
+
+func writeMarkdownEntries(w io.Writer, entries []Entry) {
+	for _, e := range entries {
+		fmt.Fprintf(w, "\n### `%s`\n", e.Name)
+		if e.Docs != "" {
+			fmt.Fprintf(w, "\n%s\n", e.Docs)
+		}
+		if len(e.Args) != 0 {
+			fmt.Fprintln(w)
+			writeMarkdownArgs(w, e.Args)
+		}
+	}
+}
+
+func writeMarkdownArgs(w io.Writer, args []Arg) {
+	fmt.Fprintln(w, "| Arg | Default | Description |")
+	fmt.Fprintln(w, "| --- | --- | --- |")
+	for _, arg := range args {
+		def := ""
+		if arg.HasDefault && arg.Default != "" {
+			def = "`" + markdownCell(arg.Default) + "`"
+		}
+		fmt.Fprintf(w, "| `%s` | %s | %s |\n", arg.Name, def, markdownCell(arg.Docs))
+	}
+}
+
+// markdownCell escapes the text for use within a cell of a Markdown table.
+func markdownCell(s string) string {
+	return strings.ReplaceAll(strings.Join(strings.Fields(s), " "), "|", "\\|")
+}
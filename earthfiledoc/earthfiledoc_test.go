@@ -0,0 +1,135 @@
+package earthfiledoc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func argStmt(docs string, args ...string) spec.Statement {
+	return spec.Statement{Command: &spec.Command{Name: "ARG", Args: args, Docs: docs}}
+}
+
+func TestParseArg(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected Arg
+	}{
+		{[]string{"VERSION"}, Arg{Name: "VERSION"}},
+		{[]string{"VERSION=1.0"}, Arg{Name: "VERSION", Default: "1.0", HasDefault: true}},
+		{[]string{"VERSION", "=", "1.0"}, Arg{Name: "VERSION", Default: "1.0", HasDefault: true}},
+		{[]string{"VERSION="}, Arg{Name: "VERSION", HasDefault: true}},
+		{[]string{"--host", "USER=$(whoami)"}, Arg{Name: "USER", Default: "$(whoami)", HasDefault: true}},
+		{[]string{"--secret", "TOKEN=+secrets/token", "TAGS=$(./tags.sh)"}, Arg{Name: "TAGS", Default: "$(./tags.sh)", HasDefault: true}},
+	}
+	for _, tt := range tests {
+		arg, ok := parseArg(spec.Command{Name: "ARG", Args: tt.args})
+		assert.True(t, ok)
+		assert.Equal(t, tt.expected, arg)
+	}
+	_, ok := parseArg(spec.Command{Name: "RUN", Args: []string{"true"}})
+	assert.False(t, ok)
+}
+
+func testEarthfile() spec.Earthfile {
+	return spec.Earthfile{
+		BaseRecipe: spec.Block{
+			argStmt("The version of Go.", "GO_VERSION=1.16"),
+		},
+		Targets: []spec.Target{
+			{
+				Name: "build",
+				Docs: "Builds the binary.",
+				Recipe: spec.Block{
+					argStmt("Flags passed to go build.", "GOFLAGS", "=", "-race"),
+					{If: &spec.IfStatement{
+						IfBody:   spec.Block{argStmt("", "TARGETOS")},
+						ElseBody: &spec.Block{argStmt("", "GOFLAGS")},
+					}},
+				},
+			},
+			{Name: "test"},
+		},
+		UserCommands: []spec.UserCommand{
+			{
+				Name:   "GO_BUILD",
+				Docs:   "Runs go build.\nSee +build.",
+				Recipe: spec.Block{argStmt("The output | path.", "OUT=bin/app")},
+			},
+		},
+	}
+}
+
+func TestNew(t *testing.T) {
+	doc := New(testEarthfile())
+	assert.Equal(t, []Arg{{Name: "GO_VERSION", Default: "1.16", HasDefault: true, Docs: "The version of Go."}}, doc.GlobalArgs)
+	assert.Equal(t, []Entry{
+		{
+			Name: "+build",
+			Docs: "Builds the binary.",
+			Args: []Arg{
+				{Name: "GOFLAGS", Default: "-race", HasDefault: true, Docs: "Flags passed to go build."},
+				{Name: "TARGETOS"},
+			},
+		},
+		{Name: "+test", Args: []Arg{}},
+	}, doc.Targets)
+	assert.Equal(t, 1, len(doc.Commands))
+
+	build, ok := doc.Target("build")
+	assert.True(t, ok)
+	assert.Equal(t, doc.GlobalArgs, build.GlobalArgs)
+	assert.Equal(t, []Entry{doc.Targets[0]}, build.Targets)
+	_, ok = doc.Target("missing")
+	assert.False(t, ok)
+}
+
+func TestWriteText(t *testing.T) {
+	var buf bytes.Buffer
+	err := New(testEarthfile()).WriteText(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, `GLOBAL ARGS
+  --GO_VERSION=1.16  The version of Go.
+
+TARGETS
+  +build
+      Builds the binary.
+      --GOFLAGS=-race  Flags passed to go build.
+      --TARGETOS
+
+  +test
+
+COMMANDS
+  GO_BUILD
+      Runs go build.
+      See +build.
+      --OUT=bin/app  The output | path.
+`, buf.String())
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	err := New(testEarthfile()).WriteMarkdown(&buf, "Earthfile")
+	assert.NoError(t, err)
+	assert.Equal(t, "# Earthfile\n"+
+		"\n## Global args\n\n"+
+		"| Arg | Default | Description |\n"+
+		"| --- | --- | --- |\n"+
+		"| `GO_VERSION` | `1.16` | The version of Go. |\n"+
+		"\n## Targets\n"+
+		"\n### `+build`\n"+
+		"\nBuilds the binary.\n\n"+
+		"| Arg | Default | Description |\n"+
+		"| --- | --- | --- |\n"+
+		"| `GOFLAGS` | `-race` | Flags passed to go build. |\n"+
+		"| `TARGETOS` |  |  |\n"+
+		"\n### `+test`\n"+
+		"\n## Commands\n"+
+		"\n### `GO_BUILD`\n"+
+		"\nRuns go build.\nSee +build.\n\n"+
+		"| Arg | Default | Description |\n"+
+		"| --- | --- | --- |\n"+
+		"| `OUT` | `bin/app` | The output \\| path. |\n", buf.String())
+}
@@ -19,6 +19,12 @@ type Features struct {
 	ReferencedSaveOnly     bool `long:"referenced-save-only" description:"only save artifacts that are directly referenced"`
 	UseCopyIncludePatterns bool `long:"use-copy-include-patterns" description:"specify an include pattern to buildkit when performing copies"`
 	ForIn                  bool `long:"for-in" description:"allow the use of the FOR command"`
+	StrictExpansion        bool `long:"strict-expansion" description:"fail on references to undefined ARGs and ENVs, instead of expanding them to empty strings"`
+
+	// Project metadata. These are not feature flags, but are declared alongside them, as in
+	// VERSION --project=<org>/<name> 0.5
+	Project  string `long:"project" description:"the <org>/<name> of the project which the Earthfile belongs to"`
+	Registry string `long:"registry" description:"the registry prefix of SAVE IMAGE names which do not specify one"`
 
 	Major int
 	Minor int
@@ -64,6 +70,9 @@ func (f *Features) String() string {
 			if boolVal, ok := ifaceVal.(bool); ok && boolVal {
 				flags = append(flags, fmt.Sprintf("--%v", flagName))
 			}
+			if strVal, ok := ifaceVal.(string); ok && strVal != "" {
+				flags = append(flags, fmt.Sprintf("--%v=%v", flagName, strVal))
+			}
 		}
 	}
 	sort.Strings(flags)
@@ -75,6 +84,25 @@ func (f *Features) String() string {
 	return strings.Join(args, " ")
 }
 
+// EnabledFlags returns the names of the boolean feature flags which are
+// enabled, without the -- prefix, in order.
+func (f *Features) EnabledFlags() []string {
+	v := reflect.ValueOf(*f)
+	typeOf := v.Type()
+	var flags []string
+	for i := 0; i < typeOf.NumField(); i++ {
+		flagName, ok := typeOf.Field(i).Tag.Lookup("long")
+		if !ok {
+			continue
+		}
+		if boolVal, ok := v.Field(i).Interface().(bool); ok && boolVal {
+			flags = append(flags, flagName)
+		}
+	}
+	sort.Strings(flags)
+	return flags
+}
+
 // ApplyFlagOverrides parses a comma separated list of feature flag overrides (without the -- flag name prefix)
 // and sets them in the referenced features.
 func ApplyFlagOverrides(ftrs *Features, envOverrides string) error {
@@ -97,9 +125,7 @@ func ApplyFlagOverrides(ftrs *Features, envOverrides string) error {
 			return fmt.Errorf("unable to set %s: invalid flag", key)
 		}
 		fv := ftrsStruct.Field(i)
-		if fv.IsValid() && fv.CanSet() {
-			fv.SetBool(true)
-		} else {
+		if !fv.IsValid() || !fv.CanSet() {
 			return fmt.Errorf("unable to set %s: field is invalid or cant be set", key)
 		}
 		ifaceVal := fv.Interface()
@@ -148,5 +174,31 @@ func GetFeatures(version *spec.Version) (*Features, error) {
 		return nil, errors.Wrapf(err, "failed to parse minor version %q", majorAndMinor[1])
 	}
 
+	if ftrs.Project != "" {
+		if _, _, ok := splitProject(ftrs.Project); !ok {
+			return nil, errors.Errorf("invalid VERSION --project %s; should be <org>/<name>", ftrs.Project)
+		}
+	}
+
 	return &ftrs, nil
 }
+
+// ProjectOrg returns the org of the project declared via VERSION --project, if any.
+func (f *Features) ProjectOrg() string {
+	org, _, _ := splitProject(f.Project)
+	return org
+}
+
+// ProjectName returns the name of the project declared via VERSION --project, if any.
+func (f *Features) ProjectName() string {
+	_, name, _ := splitProject(f.Project)
+	return name
+}
+
+func splitProject(project string) (string, string, bool) {
+	parts := strings.Split(project, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
@@ -3,6 +3,7 @@ package features
 import (
 	"testing"
 
+	"github.com/earthly/earthly/ast/spec"
 	. "github.com/stretchr/testify/assert"
 )
 
@@ -26,6 +27,18 @@ func TestFeaturesStringDisabled(t *testing.T) {
 	Equal(t, "VERSION 1.1", s)
 }
 
+func TestFeaturesEnabledFlags(t *testing.T) {
+	fts := &Features{
+		Major:                  0,
+		Minor:                  6,
+		UseCopyIncludePatterns: true,
+		ForIn:                  true,
+		Project:                "acme/widgets",
+	}
+	Equal(t, []string{"for-in", "use-copy-include-patterns"}, fts.EnabledFlags())
+	Equal(t, 0, len((&Features{}).EnabledFlags()))
+}
+
 func TestApplyFlagOverrides(t *testing.T) {
 	fts := &Features{}
 	err := ApplyFlagOverrides(fts, "referenced-save-only")
@@ -53,3 +66,29 @@ func TestApplyFlagOverridesEmptyString(t *testing.T) {
 	err := ApplyFlagOverrides(fts, "")
 	Nil(t, err)
 }
+
+func TestFeaturesStringProject(t *testing.T) {
+	fts := &Features{
+		Major:    0,
+		Minor:    5,
+		Project:  "acme/widgets",
+		Registry: "ghcr.io/acme",
+	}
+	Equal(t, "VERSION --project=acme/widgets --registry=ghcr.io/acme 0.5", fts.String())
+	Equal(t, "acme", fts.ProjectOrg())
+	Equal(t, "widgets", fts.ProjectName())
+}
+
+func TestApplyFlagOverridesProject(t *testing.T) {
+	fts := &Features{}
+	err := ApplyFlagOverrides(fts, "project=acme/widgets")
+	Error(t, err)
+}
+
+func TestGetFeaturesProject(t *testing.T) {
+	fts, err := GetFeatures(&spec.Version{Args: []string{"--project=acme/widgets", "0.5"}})
+	NoError(t, err)
+	Equal(t, "acme/widgets", fts.Project)
+	_, err = GetFeatures(&spec.Version{Args: []string{"--project=acme", "0.5"}})
+	Error(t, err)
+}
@@ -0,0 +1,520 @@
+package frontend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/states/image"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// converter converts the targets of an Earthfile to LLB.
+type converter struct {
+	gwClient     gwclient.Client
+	ef           spec.Earthfile
+	buildArgs    map[string]string
+	platform     specs.Platform
+	buildContext pllb.State
+	// visiting holds the targets being converted, to detect cycles of FROM.
+	visiting map[string]bool
+}
+
+// targetState is the state of a target being converted.
+type targetState struct {
+	state pllb.State
+	img   *image.Image
+	// args holds the values of the ARGs declared so far.
+	args map[string]string
+}
+
+// target converts the target with the given name, after the base recipe of
+// the Earthfile.
+func (c *converter) target(ctx context.Context, name string) (*targetState, error) {
+	t, err := targetOf(c.ef, name)
+	if err != nil {
+		return nil, err
+	}
+	if c.visiting[name] {
+		return nil, errors.Errorf("circular FROM +%s", name)
+	}
+	c.visiting[name] = true
+	defer delete(c.visiting, name)
+
+	img := image.NewImage()
+	img.OS = c.platform.OS
+	img.Architecture = c.platform.Architecture
+	ts := &targetState{
+		state: pllb.Scratch().Platform(c.platform),
+		img:   img,
+		args:  make(map[string]string),
+	}
+	for _, block := range []spec.Block{c.ef.BaseRecipe, t.Recipe} {
+		for _, stmt := range block {
+			err := c.statement(ctx, ts, stmt)
+			if err != nil {
+				return nil, errors.Wrapf(err, "+%s", name)
+			}
+		}
+	}
+	return ts, nil
+}
+
+func (c *converter) statement(ctx context.Context, ts *targetState, stmt spec.Statement) error {
+	var cmd spec.Command
+	switch {
+	case stmt.Command != nil:
+		cmd = *stmt.Command
+	case stmt.With != nil:
+		return unsupported("WITH DOCKER", stmt.SourceLocation)
+	case stmt.If != nil:
+		return unsupported("IF", stmt.SourceLocation)
+	case stmt.For != nil:
+		return unsupported("FOR", stmt.SourceLocation)
+	default:
+		return errors.New("unexpected statement")
+	}
+	err := c.command(ctx, ts, cmd)
+	if err != nil {
+		return withLocation(err, cmd.SourceLocation)
+	}
+	return nil
+}
+
+func (c *converter) command(ctx context.Context, ts *targetState, cmd spec.Command) error {
+	switch cmd.Name {
+	case "FROM":
+		return c.from(ctx, ts, cmd)
+	case "ARG":
+		return c.arg(ts, cmd)
+	case "ENV":
+		return c.env(ts, cmd)
+	case "RUN":
+		return c.run(ts, cmd)
+	case "COPY":
+		return c.copy(ts, cmd)
+	case "WORKDIR":
+		return c.workdir(ts, cmd)
+	case "USER":
+		return c.user(ts, cmd)
+	case "ENTRYPOINT":
+		return c.entrypoint(ts, cmd)
+	case "CMD":
+		return c.cmd(ts, cmd)
+	case "EXPOSE":
+		return c.expose(ts, cmd)
+	case "VOLUME":
+		return c.volume(ts, cmd)
+	case "LABEL":
+		return c.label(ts, cmd)
+	case "SAVE IMAGE", "SAVE ARTIFACT":
+		// The image is exported by docker buildx, under the names it is
+		// given, and artifacts are not outputs of image builds.
+		return nil
+	default:
+		return unsupported(cmd.Name, nil)
+	}
+}
+
+func (c *converter) from(ctx context.Context, ts *targetState, cmd spec.Command) error {
+	if len(cmd.Args) > 0 && cmd.Args[0] == "NIX" {
+		return errors.New("FROM NIX is not supported by the Earthfile frontend")
+	}
+	flags, args, err := parseFlags(cmd.Args, map[string]bool{"platform": true})
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("invalid number of arguments for FROM")
+	}
+	name, err := c.expand(ts, args[0])
+	if err != nil {
+		return err
+	}
+	if flags["platform"] != "" {
+		platform, err := c.expand(ts, flags["platform"])
+		if err != nil {
+			return err
+		}
+		p, err := llbutil.ParsePlatform(platform)
+		if err != nil {
+			return err
+		}
+		if p.OS != c.platform.OS || p.Architecture != c.platform.Architecture {
+			return errors.Errorf("FROM --platform=%s differs from the platform of the build, which is not supported by the Earthfile frontend", platform)
+		}
+	}
+	if strings.HasPrefix(name, "+") {
+		other, err := c.target(ctx, strings.TrimPrefix(name, "+"))
+		if err != nil {
+			return err
+		}
+		ts.state, ts.img = other.state, other.img.Clone()
+		return nil
+	}
+	if strings.Contains(name, "+") {
+		return errors.Errorf("FROM %s: only targets of the same Earthfile are supported by the Earthfile frontend", name)
+	}
+	if name == "scratch" {
+		ts.state = pllb.Scratch().Platform(c.platform)
+		img := image.NewImage()
+		img.OS = c.platform.OS
+		img.Architecture = c.platform.Architecture
+		ts.img = img
+		return nil
+	}
+	ref, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return errors.Wrapf(err, "parse normalized named %s", name)
+	}
+	ref = reference.TagNameOnly(ref)
+	dgst, dt, err := c.gwClient.ResolveImageConfig(ctx, ref.String(), llb.ResolveImageConfigOpt{
+		Platform:    &c.platform,
+		ResolveMode: llb.ResolveModeDefault.String(),
+		LogName:     fmt.Sprintf("[internal] load metadata for %s", ref.String()),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "resolve image config for %s", name)
+	}
+	img := image.NewImage()
+	err = json.Unmarshal(dt, img)
+	if err != nil {
+		return errors.Wrapf(err, "unmarshal image config for %s", name)
+	}
+	img.Created = nil
+	if dgst != "" {
+		ref, err = reference.WithDigest(ref, dgst)
+		if err != nil {
+			return errors.Wrapf(err, "reference add digest %v for %s", dgst, name)
+		}
+	}
+	state := pllb.Image(ref.String(), llb.Platform(c.platform))
+	for _, kv := range img.Config.Env {
+		k, v := splitEnv(kv)
+		state = state.AddEnv(k, v)
+	}
+	if img.Config.WorkingDir != "" {
+		state = state.Dir(img.Config.WorkingDir)
+	}
+	if img.Config.User != "" {
+		state = state.User(img.Config.User)
+	}
+	if img.Config.ExposedPorts == nil {
+		img.Config.ExposedPorts = make(map[string]struct{})
+	}
+	if img.Config.Volumes == nil {
+		img.Config.Volumes = make(map[string]struct{})
+	}
+	if img.Config.Labels == nil {
+		img.Config.Labels = make(map[string]string)
+	}
+	ts.state, ts.img = state, img
+	return nil
+}
+
+func (c *converter) arg(ts *targetState, cmd spec.Command) error {
+	key, value, hasValue, err := parseKeyValueArgs(cmd.Args)
+	if err != nil {
+		return err
+	}
+	if override, ok := c.buildArgs[key]; ok {
+		ts.args[key] = override
+		return nil
+	}
+	if strings.HasPrefix(value, "$(") {
+		return unsupported("ARG $(...) expressions", nil)
+	}
+	if hasValue {
+		value, err = c.expand(ts, value)
+		if err != nil {
+			return err
+		}
+	}
+	ts.args[key] = value
+	return nil
+}
+
+func (c *converter) env(ts *targetState, cmd spec.Command) error {
+	key, value, hasValue, err := parseKeyValueArgs(cmd.Args)
+	if err != nil {
+		return err
+	}
+	if hasValue {
+		value, err = c.expand(ts, value)
+		if err != nil {
+			return err
+		}
+	}
+	ts.state = ts.state.AddEnv(key, value)
+	env := make([]string, 0, len(ts.img.Config.Env)+1)
+	for _, kv := range ts.img.Config.Env {
+		if k, _ := splitEnv(kv); k != key {
+			env = append(env, kv)
+		}
+	}
+	ts.img.Config.Env = append(env, key+"="+value)
+	return nil
+}
+
+func (c *converter) run(ts *targetState, cmd spec.Command) error {
+	if len(cmd.Heredocs) != 0 {
+		return errors.New("RUN: here-documents are not supported by the Earthfile frontend")
+	}
+	flags, args, err := parseFlags(cmd.Args, map[string]bool{"no-cache": false})
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("invalid number of arguments for RUN")
+	}
+	if !cmd.ExecMode {
+		args = []string{"/bin/sh", "-c", strings.Join(args, " ")}
+	}
+	opts := []llb.RunOption{
+		llb.Args(args),
+		llb.WithCustomNamef("RUN %s", strings.Join(cmd.Args, " ")),
+	}
+	// As in earthly, ARGs are available to RUN as env vars, without being
+	// persisted in the image.
+	for _, k := range sortedKeys(ts.args) {
+		opts = append(opts, llb.AddEnv(k, ts.args[k]))
+	}
+	if _, ok := flags["no-cache"]; ok {
+		opts = append(opts, llb.IgnoreCache)
+	}
+	ts.state = ts.state.Run(opts...).Root()
+	return nil
+}
+
+func (c *converter) copy(ts *targetState, cmd spec.Command) error {
+	if len(cmd.Heredocs) != 0 {
+		return errors.New("COPY: here-documents are not supported by the Earthfile frontend")
+	}
+	flags, args, err := parseFlags(cmd.Args, map[string]bool{"dir": false, "if-exists": false, "keep-ts": false, "chown": true})
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return errors.New("not enough COPY arguments")
+	}
+	srcs := make([]string, 0, len(args)-1)
+	for _, arg := range args[:len(args)-1] {
+		src, err := c.expand(ts, arg)
+		if err != nil {
+			return err
+		}
+		if _, err := domain.ParseArtifact(src); err == nil {
+			return errors.Errorf("COPY %s: copying artifacts is not supported by the Earthfile frontend", src)
+		}
+		srcs = append(srcs, src)
+	}
+	dest, err := c.expand(ts, args[len(args)-1])
+	if err != nil {
+		return err
+	}
+	chown, err := c.expand(ts, flags["chown"])
+	if err != nil {
+		return err
+	}
+	_, isDir := flags["dir"]
+	_, keepTs := flags["keep-ts"]
+	_, ifExists := flags["if-exists"]
+	ts.state = llbutil.CopyOp(
+		c.buildContext, srcs, ts.state, dest, true, isDir, keepTs, chown, ifExists, false,
+		llb.WithCustomNamef("COPY %s", strings.Join(cmd.Args, " ")))
+	return nil
+}
+
+func (c *converter) workdir(ts *targetState, cmd spec.Command) error {
+	if len(cmd.Args) != 1 {
+		return errors.New("invalid number of arguments for WORKDIR")
+	}
+	dir, err := c.expand(ts, cmd.Args[0])
+	if err != nil {
+		return err
+	}
+	if !path.IsAbs(dir) {
+		dir = path.Join("/", ts.img.Config.WorkingDir, dir)
+	}
+	ts.img.Config.WorkingDir = dir
+	ts.state = ts.state.File(
+		pllb.Mkdir(dir, 0755, llb.WithParents(true)),
+		llb.WithCustomNamef("WORKDIR %s", dir),
+	).Dir(dir)
+	return nil
+}
+
+func (c *converter) user(ts *targetState, cmd spec.Command) error {
+	if len(cmd.Args) != 1 {
+		return errors.New("invalid number of arguments for USER")
+	}
+	user, err := c.expand(ts, cmd.Args[0])
+	if err != nil {
+		return err
+	}
+	ts.img.Config.User = user
+	ts.state = ts.state.User(user)
+	return nil
+}
+
+func (c *converter) entrypoint(ts *targetState, cmd spec.Command) error {
+	ts.img.Config.Entrypoint = withShell(cmd)
+	return nil
+}
+
+func (c *converter) cmd(ts *targetState, cmd spec.Command) error {
+	ts.img.Config.Cmd = withShell(cmd)
+	return nil
+}
+
+func (c *converter) expose(ts *targetState, cmd spec.Command) error {
+	for _, arg := range cmd.Args {
+		port, err := c.expand(ts, arg)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(port, "/") {
+			port += "/tcp"
+		}
+		ts.img.Config.ExposedPorts[port] = struct{}{}
+	}
+	return nil
+}
+
+func (c *converter) volume(ts *targetState, cmd spec.Command) error {
+	for _, arg := range cmd.Args {
+		v, err := c.expand(ts, arg)
+		if err != nil {
+			return err
+		}
+		ts.img.Config.Volumes[v] = struct{}{}
+	}
+	return nil
+}
+
+func (c *converter) label(ts *targetState, cmd spec.Command) error {
+	args := cmd.Args
+	for len(args) > 0 {
+		if len(args) < 3 || args[1] != "=" {
+			return errors.New("invalid syntax")
+		}
+		key, err := c.expand(ts, args[0])
+		if err != nil {
+			return err
+		}
+		value, err := c.expand(ts, args[2])
+		if err != nil {
+			return err
+		}
+		ts.img.Config.Labels[key] = value
+		args = args[3:]
+	}
+	return nil
+}
+
+// expand expands the ARGs and env vars in word, as a shell would.
+func (c *converter) expand(ts *targetState, word string) (string, error) {
+	env := make([]string, 0, len(ts.img.Config.Env)+len(ts.args))
+	env = append(env, ts.img.Config.Env...)
+	for _, k := range sortedKeys(ts.args) {
+		env = append(env, k+"="+ts.args[k])
+	}
+	expanded, err := shell.NewLex('\\').ProcessWord(word, env)
+	if err != nil {
+		return "", errors.Wrapf(err, "expand %s", word)
+	}
+	return expanded, nil
+}
+
+// parseFlags splits the leading flags from the args of a command. The allowed
+// flags map to whether they take a value; other flags are rejected.
+func parseFlags(args []string, allowed map[string]bool) (map[string]string, []string, error) {
+	flags := make(map[string]string)
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		name, value := args[0][2:], ""
+		args = args[1:]
+		if name == "" {
+			break
+		}
+		hasValue := false
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value, hasValue = name[:i], name[i+1:], true
+		}
+		takesValue, ok := allowed[name]
+		if !ok {
+			return nil, nil, errors.Errorf("flag --%s is not supported by the Earthfile frontend", name)
+		}
+		if takesValue && !hasValue {
+			if len(args) == 0 {
+				return nil, nil, errors.Errorf("flag --%s requires a value", name)
+			}
+			value, args = args[0], args[1:]
+		}
+		flags[name] = value
+	}
+	return flags, args, nil
+}
+
+// parseKeyValueArgs parses the args of ARG and ENV, as in KEY=value or KEY.
+func parseKeyValueArgs(args []string) (string, string, bool, error) {
+	switch len(args) {
+	case 3:
+		if args[1] != "=" {
+			return "", "", false, errors.New("invalid syntax")
+		}
+		return args[0], args[2], true, nil
+	case 1:
+		return args[0], "", false, nil
+	default:
+		return "", "", false, errors.New("invalid syntax")
+	}
+}
+
+// withShell returns the args of ENTRYPOINT or CMD, wrapped in a shell unless
+// in exec mode.
+func withShell(cmd spec.Command) []string {
+	if cmd.ExecMode {
+		return cmd.Args
+	}
+	return []string{"/bin/sh", "-c", strings.Join(cmd.Args, " ")}
+}
+
+func splitEnv(kv string) (string, string) {
+	parts := strings.SplitN(kv, "=", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func unsupported(what string, sl *spec.SourceLocation) error {
+	return withLocation(errors.Errorf("%s is not supported by the Earthfile frontend; build this target with earthly instead", what), sl)
+}
+
+func withLocation(err error, sl *spec.SourceLocation) error {
+	if sl == nil {
+		return err
+	}
+	return errors.Wrapf(err, "line %d:%d", sl.StartLine, sl.StartColumn)
+}
@@ -0,0 +1,60 @@
+package frontend
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/states/image"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseFlags(t *testing.T) {
+	allowed := map[string]bool{"dir": false, "chown": true}
+	flags, args, err := parseFlags([]string{"--dir", "--chown", "1000", "src", "dest"}, allowed)
+	NoError(t, err)
+	Equal(t, map[string]string{"dir": "", "chown": "1000"}, flags)
+	Equal(t, []string{"src", "dest"}, args)
+
+	flags, args, err = parseFlags([]string{"--chown=root", "--", "--src", "dest"}, allowed)
+	NoError(t, err)
+	Equal(t, map[string]string{"chown": "root"}, flags)
+	Equal(t, []string{"--src", "dest"}, args)
+
+	_, _, err = parseFlags([]string{"--push", "echo"}, allowed)
+	Error(t, err)
+	_, _, err = parseFlags([]string{"--chown"}, allowed)
+	Error(t, err)
+}
+
+func TestParseKeyValueArgs(t *testing.T) {
+	key, value, hasValue, err := parseKeyValueArgs([]string{"FOO", "=", "bar"})
+	NoError(t, err)
+	Equal(t, "FOO", key)
+	Equal(t, "bar", value)
+	True(t, hasValue)
+
+	key, _, hasValue, err = parseKeyValueArgs([]string{"FOO"})
+	NoError(t, err)
+	Equal(t, "FOO", key)
+	False(t, hasValue)
+
+	_, _, _, err = parseKeyValueArgs([]string{"FOO", "bar"})
+	Error(t, err)
+}
+
+func TestWithShell(t *testing.T) {
+	Equal(t, []string{"/bin/sh", "-c", "echo hello"}, withShell(spec.Command{Args: []string{"echo", "hello"}}))
+	Equal(t, []string{"echo", "hello"}, withShell(spec.Command{Args: []string{"echo", "hello"}, ExecMode: true}))
+}
+
+func TestArg(t *testing.T) {
+	c := &converter{buildArgs: map[string]string{"TAG": "v1"}}
+	ts := &targetState{img: image.NewImage(), args: make(map[string]string)}
+	NoError(t, c.arg(ts, spec.Command{Args: []string{"NAME", "=", "app"}}))
+	NoError(t, c.arg(ts, spec.Command{Args: []string{"TAG", "=", "$(git describe)"}}))
+	Equal(t, map[string]string{"NAME": "app", "TAG": "v1"}, ts.args)
+
+	err := c.arg(ts, spec.Command{Args: []string{"USER", "=", "$(whoami)"}})
+	Error(t, err)
+	Contains(t, err.Error(), "not supported by the Earthfile frontend")
+}
@@ -0,0 +1,184 @@
+// Package frontend implements a buildkit gateway frontend for Earthfiles, so
+// that simple Earthfile targets can be built via docker buildx, by starting
+// the Earthfile with
+//
+//	# syntax=earthly/earthfile
+//
+// Only the commands which map directly onto a single image build are
+// supported; the others fail with an error suggesting to use earthly instead.
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/earthly/earthly/ast"
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/earthly/earthly/buildcontext"
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/earthly/earthly/util/llbutil/pllb"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/pkg/errors"
+)
+
+// The options passed by docker buildx to the frontend.
+const (
+	keyFilename    = "filename"
+	keyTarget      = "target"
+	keyPlatform    = "platform"
+	buildArgPrefix = "build-arg:"
+
+	localNameEarthfile = "dockerfile"
+	localNameContext   = "context"
+)
+
+const (
+	// DefaultFilename is the name of the Earthfile, unless docker buildx is
+	// passed -f.
+	DefaultFilename = "Earthfile"
+	// DefaultTarget is the target built, unless docker buildx is passed
+	// --target.
+	DefaultTarget = "build"
+)
+
+// Build is the gateway build function of the frontend. It builds the target
+// of the Earthfile, and returns its image.
+func Build(ctx context.Context, c gwclient.Client) (*gwclient.Result, error) {
+	opts := c.BuildOpts().Opts
+	filename := opts[keyFilename]
+	if filename == "" {
+		filename = DefaultFilename
+	}
+	targetName := strings.TrimPrefix(opts[keyTarget], "+")
+	if targetName == "" {
+		targetName = DefaultTarget
+	}
+	platform := llbutil.DefaultPlatform()
+	if opts[keyPlatform] != "" {
+		if strings.Contains(opts[keyPlatform], ",") {
+			return nil, errors.New("building multiple platforms at once is not supported by the Earthfile frontend")
+		}
+		p, err := llbutil.ParsePlatform(opts[keyPlatform])
+		if err != nil {
+			return nil, err
+		}
+		platform = *p
+	}
+	buildArgs := make(map[string]string)
+	for k, v := range opts {
+		if strings.HasPrefix(k, buildArgPrefix) {
+			buildArgs[strings.TrimPrefix(k, buildArgPrefix)] = v
+		}
+	}
+
+	sessionID := c.BuildOpts().SessionID
+	dt, err := readLocalFile(ctx, c, localNameEarthfile, filename, sessionID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", filename)
+	}
+	ef, err := parse(ctx, filepath.Base(filename), dt)
+	if err != nil {
+		return nil, err
+	}
+	excludes, err := readExcludes(ctx, c, targetName, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := &converter{
+		gwClient:  c,
+		ef:        ef,
+		buildArgs: buildArgs,
+		platform:  platform,
+		buildContext: pllb.Local(
+			localNameContext,
+			llb.SessionID(sessionID),
+			llb.ExcludePatterns(excludes),
+			llb.SharedKeyHint(localNameContext),
+			llb.WithCustomName("[internal] load build context"),
+		),
+		visiting: make(map[string]bool),
+	}
+	ts, err := conv.target(ctx, targetName)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := llbutil.StateToRef(ctx, c, ts.state, &platform, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "build +%s", targetName)
+	}
+	config, err := json.Marshal(ts.img)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal image config")
+	}
+	res := gwclient.NewResult()
+	res.SetRef(ref)
+	res.AddMeta(exptypes.ExporterImageConfigKey, config)
+	return res, nil
+}
+
+// readLocalFile reads a file of a local dir shared by the client.
+func readLocalFile(ctx context.Context, c gwclient.Client, localName, filename, sessionID string) ([]byte, error) {
+	st := pllb.Local(
+		localName,
+		llb.SessionID(sessionID),
+		llb.FollowPaths([]string{filename}),
+		llb.SharedKeyHint(localName+"/"+filename),
+		llb.WithCustomNamef("[internal] load %s", filename),
+	)
+	ref, err := llbutil.StateToRef(ctx, c, st, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ref.ReadFile(ctx, gwclient.ReadRequest{Filename: filename})
+}
+
+// readExcludes returns the patterns of the ignore file of the build context
+// which apply to the target, if any.
+func readExcludes(ctx context.Context, c gwclient.Client, targetName, sessionID string) ([]string, error) {
+	for _, name := range []string{".earthlyignore", ".earthignore"} {
+		dt, err := readLocalFile(ctx, c, localNameContext, name, sessionID)
+		if err != nil {
+			// The ignore file does not exist.
+			continue
+		}
+		excludes, err := buildcontext.ExcludesFromReader(bytes.NewReader(dt), targetName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %s", name)
+		}
+		return excludes, nil
+	}
+	return buildcontext.ImplicitExcludes, nil
+}
+
+// parse parses the Earthfile, which the parser can only read from disk.
+func parse(ctx context.Context, filename string, dt []byte) (spec.Earthfile, error) {
+	dir, err := ioutil.TempDir("", "earthfile-frontend")
+	if err != nil {
+		return spec.Earthfile{}, errors.Wrap(err, "create temp dir")
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, filename)
+	err = ioutil.WriteFile(path, dt, 0644)
+	if err != nil {
+		return spec.Earthfile{}, errors.Wrapf(err, "write %s", path)
+	}
+	return ast.Parse(ctx, path, false)
+}
+
+// targetOf returns the target of the Earthfile with the given name.
+func targetOf(ef spec.Earthfile, name string) (spec.Target, error) {
+	for _, t := range ef.Targets {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return spec.Target{}, errors.Errorf("target +%s not found", name)
+}
@@ -0,0 +1,221 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/util/stringutil"
+	"github.com/pkg/errors"
+)
+
+// GitHubAPI is the base URL of the GitHub API, used for opening pull requests.
+var GitHubAPI = "https://api.github.com"
+
+// Opt contains the settings of a GitOps update.
+type Opt struct {
+	// CloneURL is the URL of the GitOps repository, including any credentials.
+	CloneURL string
+	// Branch is the branch which is updated, or which the pull request targets.
+	Branch string
+	// Files are the paths, relative to the repository, of the files to update.
+	Files []string
+	// PullRequest pushes the update to a new branch and opens a pull request
+	// against Branch, rather than pushing to Branch directly. Only GitHub
+	// repositories are supported.
+	PullRequest bool
+	// Token is the token used for opening the pull request.
+	Token string
+	// Env are additional environment variables for git, such as
+	// GIT_SSH_COMMAND.
+	Env []string
+	// Source describes what triggered the update, for the commit message.
+	Source string
+}
+
+// Update points the references to the given images in the files of the GitOps
+// repository to their new digests, and commits and pushes the change.
+func Update(ctx context.Context, console conslogging.ConsoleLogger, opt Opt, images map[string]string) error {
+	if len(images) == 0 {
+		return nil
+	}
+	dir, err := ioutil.TempDir("", "earthly-gitops")
+	if err != nil {
+		return errors.Wrap(err, "create temp dir")
+	}
+	defer os.RemoveAll(dir)
+	scrubbedURL := stringutil.ScrubCredentials(opt.CloneURL)
+	g := &git{dir: dir, env: opt.Env}
+	_, err = g.run(ctx, "clone", "-q", "--depth=1", "--branch", opt.Branch, opt.CloneURL, ".")
+	if err != nil {
+		return errors.Wrapf(err, "clone %s", scrubbedURL)
+	}
+	var updated []string
+	for _, f := range opt.Files {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		dt, err := ioutil.ReadFile(p)
+		if err != nil {
+			return errors.Wrapf(err, "read %s", f)
+		}
+		newDt, repos, err := UpdateFile(f, dt, images)
+		if err != nil {
+			return err
+		}
+		if len(repos) == 0 || bytes.Equal(dt, newDt) {
+			continue
+		}
+		err = ioutil.WriteFile(p, newDt, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "write %s", f)
+		}
+		for _, repo := range repos {
+			updated = append(updated, fmt.Sprintf("%s@%s (%s)", repo, images[repo], f))
+		}
+	}
+	if len(updated) == 0 {
+		console.Printf("GitOps: %s is already up to date\n", scrubbedURL)
+		return nil
+	}
+	sort.Strings(updated)
+	title := "Update " + strings.Join(imageNames(images), ", ")
+	body := "Updated by earthly"
+	if opt.Source != "" {
+		body += " from " + opt.Source
+	}
+	body += ":\n\n* " + strings.Join(updated, "\n* ") + "\n"
+	out, _ := g.run(ctx, "config", "user.email")
+	identity := []string{}
+	if strings.TrimSpace(out) == "" {
+		identity = []string{"-c", "user.name=earthly", "-c", "user.email=earthly@localhost"}
+	}
+	_, err = g.run(ctx, append(identity, "commit", "-q", "-a", "-m", title, "-m", body)...)
+	if err != nil {
+		return errors.Wrap(err, "git commit")
+	}
+	pushBranch := opt.Branch
+	if opt.PullRequest {
+		out, err := g.run(ctx, "rev-parse", "--short", "HEAD")
+		if err != nil {
+			return errors.Wrap(err, "git rev-parse")
+		}
+		pushBranch = "earthly/update-images-" + strings.TrimSpace(out)
+	}
+	_, err = g.run(ctx, "push", "-q", "origin", "HEAD:refs/heads/"+pushBranch)
+	if err != nil {
+		return errors.Wrapf(err, "push to %s of %s", pushBranch, scrubbedURL)
+	}
+	if !opt.PullRequest {
+		console.Printf("GitOps: pushed %s to %s of %s\n", strings.Join(updated, ", "), pushBranch, scrubbedURL)
+		return nil
+	}
+	prURL, err := openGitHubPullRequest(ctx, opt.CloneURL, opt.Token, pushBranch, opt.Branch, title, body)
+	if err != nil {
+		return err
+	}
+	console.Printf("GitOps: opened pull request %s\n", prURL)
+	return nil
+}
+
+func imageNames(images map[string]string) []string {
+	names := make([]string, 0, len(images))
+	for repo := range images {
+		names = append(names, repo)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type git struct {
+	dir string
+	env []string
+}
+
+func (g *git) run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = g.dir
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), g.env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s: %s", args[0], stringutil.ScrubCredentials(strings.TrimSpace(stderr.String())))
+	}
+	return string(out), nil
+}
+
+// gitHubRepo returns the owner and name of a GitHub repository, out of its
+// clone URL.
+func gitHubRepo(cloneURL string) (string, string, error) {
+	var p string
+	switch {
+	case strings.HasPrefix(cloneURL, "git@github.com:"):
+		p = strings.TrimPrefix(cloneURL, "git@github.com:")
+	default:
+		u, err := url.Parse(cloneURL)
+		if err != nil || u.Host != "github.com" {
+			return "", "", errors.Errorf("pull requests are only supported for github.com repositories, not %s", stringutil.ScrubCredentials(cloneURL))
+		}
+		p = strings.TrimPrefix(u.Path, "/")
+	}
+	parts := strings.Split(strings.TrimSuffix(p, ".git"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.Errorf("invalid GitHub repository %s", stringutil.ScrubCredentials(cloneURL))
+	}
+	return parts[0], parts[1], nil
+}
+
+func openGitHubPullRequest(ctx context.Context, cloneURL, token, head, base, title, body string) (string, error) {
+	if token == "" {
+		return "", errors.New("a token is needed for opening a pull request")
+	}
+	owner, repo, err := gitHubRepo(cloneURL)
+	if err != nil {
+		return "", err
+	}
+	reqDt, err := json.Marshal(map[string]string{
+		"title": title,
+		"head":  head,
+		"base":  base,
+		"body":  body,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "marshal pull request")
+	}
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/pulls", GitHubAPI, owner, repo)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, bytes.NewReader(reqDt))
+	if err != nil {
+		return "", errors.Wrap(err, "new pull request")
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", "token "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "open pull request")
+	}
+	defer resp.Body.Close()
+	respDt, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "read pull request response")
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", errors.Errorf("open pull request: unexpected status %s: %s", resp.Status, strings.TrimSpace(string(respDt)))
+	}
+	var pr struct {
+		HTMLURL string `json:"html_url"`
+	}
+	err = json.Unmarshal(respDt, &pr)
+	if err != nil {
+		return "", errors.Wrap(err, "parse pull request response")
+	}
+	return pr.HTMLURL, nil
+}
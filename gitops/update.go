@@ -0,0 +1,195 @@
+package gitops
+
+import (
+	"bytes"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// UpdateFile points the references to the given images within a kustomization
+// or a Helm values file to the given digests. The images are keyed by their
+// repository (e.g. ghcr.io/org/app) and the digests are of the form
+// sha256:<hex>. Files named kustomization.yaml are treated as kustomizations,
+// and any other file as Helm values. It returns the updated file and the
+// repositories which were updated.
+func UpdateFile(name string, dt []byte, images map[string]string) ([]byte, []string, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(dt, &doc)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "parse %s", name)
+	}
+	if len(doc.Content) == 0 {
+		return dt, nil, nil
+	}
+	normalized := make(map[string]string)
+	for repo, digest := range images {
+		normalized[repositoryName(repo)] = digest
+	}
+	u := &updater{images: normalized, seen: make(map[string]bool)}
+	base := strings.ToLower(path.Base(name))
+	if base == "kustomization.yaml" || base == "kustomization.yml" {
+		u.kustomization(doc.Content[0])
+	} else {
+		u.values(doc.Content[0])
+	}
+	if len(u.updated) == 0 {
+		return dt, nil, nil
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err = enc.Encode(&doc)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "encode %s", name)
+	}
+	err = enc.Close()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "encode %s", name)
+	}
+	return buf.Bytes(), u.updated, nil
+}
+
+type updater struct {
+	images  map[string]string
+	updated []string
+	seen    map[string]bool
+}
+
+func (u *updater) markUpdated(repo string) {
+	if !u.seen[repo] {
+		u.seen[repo] = true
+		u.updated = append(u.updated, repo)
+	}
+}
+
+// kustomization updates the digest of the matching entries of the images list.
+func (u *updater) kustomization(root *yaml.Node) {
+	imagesNode := mappingValue(root, "images")
+	if imagesNode == nil || imagesNode.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, entry := range imagesNode.Content {
+		if entry.Kind != yaml.MappingNode {
+			continue
+		}
+		nameNode := mappingValue(entry, "newName")
+		if nameNode == nil {
+			nameNode = mappingValue(entry, "name")
+		}
+		if nameNode == nil {
+			continue
+		}
+		repo := repositoryName(nameNode.Value)
+		digest, ok := u.images[repo]
+		if !ok {
+			continue
+		}
+		removeMappingKey(entry, "newTag")
+		setMappingValue(entry, "digest", digest)
+		u.markUpdated(repo)
+	}
+}
+
+// values walks a Helm values file, updating mappings of the form
+// {repository, tag} (optionally with registry and digest keys), as well as
+// image: <ref> entries.
+func (u *updater) values(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		if repoNode := mappingValue(n, "repository"); repoNode != nil && repoNode.Kind == yaml.ScalarNode {
+			repo := repoNode.Value
+			if registryNode := mappingValue(n, "registry"); registryNode != nil && registryNode.Value != "" {
+				repo = registryNode.Value + "/" + repo
+			}
+			repo = repositoryName(repo)
+			if digest, ok := u.images[repo]; ok {
+				if digestNode := mappingValue(n, "digest"); digestNode != nil {
+					digestNode.Value = digest
+					digestNode.Style = 0
+				} else if tagNode := mappingValue(n, "tag"); tagNode != nil {
+					tagNode.Value = strings.SplitN(tagNode.Value, "@", 2)[0] + "@" + digest
+					tagNode.Style = yaml.DoubleQuotedStyle
+				} else {
+					setMappingValue(n, "digest", digest)
+				}
+				u.markUpdated(repo)
+			}
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Value == "image" && v.Kind == yaml.ScalarNode {
+				u.imageRef(v)
+				continue
+			}
+			u.values(v)
+		}
+	case yaml.SequenceNode, yaml.DocumentNode:
+		for _, c := range n.Content {
+			u.values(c)
+		}
+	}
+}
+
+// imageRef updates a scalar image reference, such as ghcr.io/org/app:v1.
+func (u *updater) imageRef(n *yaml.Node) {
+	named, err := reference.ParseNormalizedNamed(n.Value)
+	if err != nil {
+		return
+	}
+	repo := reference.FamiliarName(named)
+	digest, ok := u.images[repo]
+	if !ok {
+		return
+	}
+	ref := n.Value
+	if i := strings.Index(ref, "@"); i != -1 {
+		ref = ref[:i]
+	}
+	n.Value = ref + "@" + digest
+	u.markUpdated(repo)
+}
+
+// repositoryName returns the repository of the image reference, in its
+// familiar form, without any tag or digest.
+func repositoryName(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+	return reference.FamiliarName(named)
+}
+
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setMappingValue(n *yaml.Node, key, value string) {
+	if v := mappingValue(n, key); v != nil {
+		v.Value = value
+		return
+	}
+	n.Content = append(n.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Value: value})
+}
+
+func removeMappingKey(n *yaml.Node, key string) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content = append(n.Content[:i], n.Content[i+2:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// Package imagebudget enforces the budgets which images declare via
+// SAVE IMAGE --max-size and --max-files, and explains what grew compared to
+// the last build in which the image stayed within its budget.
+package imagebudget
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// breakdownDepth is the depth of the directories which usage is broken
+	// down by, as in /usr/lib.
+	breakdownDepth = 2
+	// breakdownLen is the number of directories listed when explaining an
+	// image which exceeds its budget.
+	breakdownLen = 10
+)
+
+// Budget is the size and number of files which an image may not exceed. A
+// zero value means no limit.
+type Budget struct {
+	MaxSize  int64
+	MaxFiles int
+}
+
+// IsSet returns whether the budget limits anything.
+func (b Budget) IsSet() bool {
+	return b.MaxSize != 0 || b.MaxFiles != 0
+}
+
+// Check returns how the usage exceeds the budget, if at all.
+func (b Budget) Check(u *Usage) []string {
+	var exceeded []string
+	if b.MaxSize != 0 && u.Size > b.MaxSize {
+		exceeded = append(exceeded, fmt.Sprintf("its size of %s exceeds --max-size=%s", FormatSize(u.Size), FormatSize(b.MaxSize)))
+	}
+	if b.MaxFiles != 0 && u.Files > b.MaxFiles {
+		exceeded = append(exceeded, fmt.Sprintf("its %d files exceed --max-files=%d", u.Files, b.MaxFiles))
+	}
+	return exceeded
+}
+
+var sizeRegexp = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([a-zA-Z]*)$`)
+
+var sizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1e3,
+	"kb":  1e3,
+	"m":   1e6,
+	"mb":  1e6,
+	"g":   1e9,
+	"gb":  1e9,
+	"t":   1e12,
+	"tb":  1e12,
+	"ki":  1 << 10,
+	"kib": 1 << 10,
+	"mi":  1 << 20,
+	"mib": 1 << 20,
+	"gi":  1 << 30,
+	"gib": 1 << 30,
+	"ti":  1 << 40,
+	"tib": 1 << 40,
+}
+
+// ParseSize parses a size such as 300MB or 1.5GiB, into bytes. Units are
+// case-insensitive; KB, MB, GB and TB are powers of 1000, and KiB, MiB, GiB
+// and TiB powers of 1024.
+func ParseSize(s string) (int64, error) {
+	m := sizeRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, errors.Errorf("invalid size %q, expected a number followed by a unit, as in 300MB", s)
+	}
+	unit, ok := sizeUnits[strings.ToLower(m[2])]
+	if !ok {
+		return 0, errors.Errorf("invalid size %q: unknown unit %s", s, m[2])
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid size %q", s)
+	}
+	size := int64(math.Round(n * unit))
+	if size <= 0 {
+		return 0, errors.Errorf("invalid size %q, it must be positive", s)
+	}
+	return size, nil
+}
+
+// FormatSize formats a size in bytes, as in 312.5 MB.
+func FormatSize(n int64) string {
+	abs := math.Abs(float64(n))
+	for _, u := range []struct {
+		name string
+		size float64
+	}{{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"kB", 1e3}} {
+		if abs >= u.size {
+			return strconv.FormatFloat(float64(n)/u.size, 'f', 1, 64) + " " + u.name
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// Dir is the usage of the files within a directory.
+type Dir struct {
+	Size  int64 `json:"size"`
+	Files int   `json:"files"`
+}
+
+// Usage is the size and number of the regular files of an image, broken down
+// by directory. The size is that of the files, before compression.
+type Usage struct {
+	Size  int64          `json:"size"`
+	Files int            `json:"files"`
+	Dirs  map[string]Dir `json:"dirs"`
+}
+
+// NewUsage returns an empty usage.
+func NewUsage() *Usage {
+	return &Usage{Dirs: make(map[string]Dir)}
+}
+
+// Add adds the regular file at the absolute path p.
+func (u *Usage) Add(p string, size int64) {
+	u.Size += size
+	u.Files++
+	dir := breakdownDir(p)
+	d := u.Dirs[dir]
+	d.Size += size
+	d.Files++
+	u.Dirs[dir] = d
+}
+
+// breakdownDir returns the directory of the file at p which usage is broken
+// down by, as in /usr/lib for /usr/lib/x86_64-linux-gnu/libc.so.6.
+func breakdownDir(p string) string {
+	parts := strings.Split(strings.Trim(filepath.ToSlash(filepath.Dir(p)), "/"), "/")
+	if len(parts) > breakdownDepth {
+		parts = parts[:breakdownDepth]
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// Explain explains the usage of an image which exceeds its budget: what grew
+// compared to the last usage within budget, if known, or else which
+// directories are the largest.
+func Explain(u *Usage, last *Usage) string {
+	var sb strings.Builder
+	type dirDelta struct {
+		name string
+		Dir
+	}
+	var dirs []dirDelta
+	if last == nil {
+		sb.WriteString("largest directories:")
+		for name, d := range u.Dirs {
+			dirs = append(dirs, dirDelta{name, d})
+		}
+	} else {
+		fmt.Fprintf(&sb, "grew by %s and %s files since the last build within budget (%s, %d files), mostly in:",
+			signedSize(u.Size-last.Size), signedInt(u.Files-last.Files), FormatSize(last.Size), last.Files)
+		for name, d := range u.Dirs {
+			delta := dirDelta{name, Dir{Size: d.Size - last.Dirs[name].Size, Files: d.Files - last.Dirs[name].Files}}
+			if delta.Size > 0 || delta.Files > 0 {
+				dirs = append(dirs, delta)
+			}
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].Size != dirs[j].Size {
+			return dirs[i].Size > dirs[j].Size
+		}
+		if dirs[i].Files != dirs[j].Files {
+			return dirs[i].Files > dirs[j].Files
+		}
+		return dirs[i].name < dirs[j].name
+	})
+	if len(dirs) > breakdownLen {
+		dirs = dirs[:breakdownLen]
+	}
+	for _, d := range dirs {
+		if last == nil {
+			fmt.Fprintf(&sb, "\n\t%10s %8d files  %s", FormatSize(d.Size), d.Files, d.name)
+		} else {
+			fmt.Fprintf(&sb, "\n\t%10s %8s files  %s", signedSize(d.Size), signedInt(d.Files), d.name)
+		}
+	}
+	return sb.String()
+}
+
+func signedSize(n int64) string {
+	if n >= 0 {
+		return "+" + FormatSize(n)
+	}
+	return FormatSize(n)
+}
+
+func signedInt(n int) string {
+	if n >= 0 {
+		return "+" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+// History records the usage of images in the last build in which they were
+// within their budget, by image name and platform.
+type History struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*Usage
+	dirty   bool
+}
+
+// Load reads the history stored at the given path. A missing file results in
+// an empty history.
+func Load(path string) (*History, error) {
+	h := &History{
+		path:    path,
+		entries: make(map[string]*Usage),
+	}
+	dt, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "read image budget history %s", path)
+	}
+	err = json.Unmarshal(dt, &h.entries)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse image budget history %s", path)
+	}
+	return h, nil
+}
+
+// Get returns the last usage within budget of the image, or nil.
+func (h *History) Get(image, platform string) *Usage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.entries[key(image, platform)]
+}
+
+// Put records the usage of the image, which is within its budget.
+func (h *History) Put(image, platform string, u *Usage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries[key(image, platform)] = u
+	h.dirty = true
+}
+
+// Save writes the history back to disk, if it has changed. Entries written by
+// other processes in the meantime are preserved.
+func (h *History) Save() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.dirty {
+		return nil
+	}
+	onDisk, err := Load(h.path)
+	if err != nil {
+		return err
+	}
+	for k, u := range onDisk.entries {
+		if _, ok := h.entries[k]; !ok {
+			h.entries[k] = u
+		}
+	}
+	dt, err := json.Marshal(h.entries)
+	if err != nil {
+		return errors.Wrap(err, "marshal image budget history")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(h.path), ".image-budgets-*")
+	if err != nil {
+		return errors.Wrap(err, "create image budget history")
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(dt)
+	tmp.Close()
+	if err != nil {
+		return errors.Wrapf(err, "write image budget history %s", tmp.Name())
+	}
+	err = os.Rename(tmp.Name(), h.path)
+	if err != nil {
+		return errors.Wrapf(err, "rename image budget history to %s", h.path)
+	}
+	h.dirty = false
+	return nil
+}
+
+func key(image, platform string) string {
+	return image + "|" + platform
+}
@@ -0,0 +1,95 @@
+package imagebudget
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"300MB":   300000000,
+		"300mb":   300000000,
+		"1.5GiB":  1610612736,
+		"512 KiB": 524288,
+		"1024":    1024,
+		"2g":      2000000000,
+	} {
+		size, err := ParseSize(s)
+		NoError(t, err, s)
+		Equal(t, expected, size, s)
+	}
+	for _, bad := range []string{"", "MB", "300XB", "-1MB", "0", "1.2.3MB"} {
+		_, err := ParseSize(bad)
+		Error(t, err, bad)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	Equal(t, "999 B", FormatSize(999))
+	Equal(t, "312.5 MB", FormatSize(312500000))
+	Equal(t, "-1.2 GB", FormatSize(-1200000000))
+}
+
+func TestCheck(t *testing.T) {
+	u := NewUsage()
+	u.Add("/app/main", 200000000)
+	u.Add("/etc/passwd", 1000)
+	u.Add("/usr/lib/x86_64-linux-gnu/libc.so.6", 2000000)
+	Equal(t, int64(202001000), u.Size)
+	Equal(t, 3, u.Files)
+	Equal(t, map[string]Dir{
+		"/app":     {Size: 200000000, Files: 1},
+		"/etc":     {Size: 1000, Files: 1},
+		"/usr/lib": {Size: 2000000, Files: 1},
+	}, u.Dirs)
+
+	Empty(t, Budget{MaxSize: 300000000, MaxFiles: 3}.Check(u))
+	Equal(t, []string{
+		"its size of 202.0 MB exceeds --max-size=100.0 MB",
+		"its 3 files exceed --max-files=2",
+	}, Budget{MaxSize: 100000000, MaxFiles: 2}.Check(u))
+}
+
+func TestExplain(t *testing.T) {
+	last := NewUsage()
+	last.Add("/app/main", 20000000)
+	last.Add("/usr/lib/libc.so", 2000000)
+	u := NewUsage()
+	u.Add("/app/main", 20000000)
+	u.Add("/app/node_modules/left-pad/index.js", 300000000)
+	u.Add("/usr/lib/libc.so", 2000000)
+	u.Add("/tmp/cache", 1000)
+
+	Equal(t, "grew by +300.0 MB and +2 files since the last build within budget (22.0 MB, 2 files), mostly in:\n"+
+		"\t +300.0 MB       +1 files  /app/node_modules\n"+
+		"\t   +1.0 kB       +1 files  /tmp", Explain(u, last))
+	Equal(t, "largest directories:\n"+
+		"\t  300.0 MB        1 files  /app/node_modules\n"+
+		"\t   20.0 MB        1 files  /app\n"+
+		"\t    2.0 MB        1 files  /usr/lib\n"+
+		"\t    1.0 kB        1 files  /tmp", Explain(u, nil))
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imagebudget")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "image-budgets.json")
+
+	h, err := Load(p)
+	NoError(t, err)
+	Nil(t, h.Get("app:latest", "linux/amd64"))
+	u := NewUsage()
+	u.Add("/app/main", 1000)
+	h.Put("app:latest", "linux/amd64", u)
+	NoError(t, h.Save())
+
+	h, err = Load(p)
+	NoError(t, err)
+	Equal(t, u, h.Get("app:latest", "linux/amd64"))
+	Nil(t, h.Get("app:latest", "linux/arm64"))
+}
@@ -0,0 +1,51 @@
+package imageindex
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// pulledFromPrefix is the prefix of the description buildkitd gives the cache
+// records of the layers it pulled, followed by the image they were pulled for.
+const pulledFromPrefix = "pulled from "
+
+// CachedImages is the set of the digests of the images whose layers are held
+// in the cache of buildkitd.
+type CachedImages map[digest.Digest]bool
+
+// LoadCachedImages lists the images whose layers are held in the cache of
+// buildkitd, as per the descriptions of its cache records. Images are pulled
+// by digest, so the digests are those which the index resolves images to.
+func LoadCachedImages(ctx context.Context, bkClient *client.Client) (CachedImages, error) {
+	records, err := bkClient.DiskUsage(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get buildkit disk usage")
+	}
+	descriptions := make([]string, 0, len(records))
+	for _, r := range records {
+		descriptions = append(descriptions, r.Description)
+	}
+	return cachedImagesFromDescriptions(descriptions), nil
+}
+
+func cachedImagesFromDescriptions(descriptions []string) CachedImages {
+	cached := make(CachedImages)
+	for _, d := range descriptions {
+		if !strings.HasPrefix(d, pulledFromPrefix) {
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(d, pulledFromPrefix))
+		if err != nil {
+			continue
+		}
+		if canonical, ok := named.(reference.Canonical); ok {
+			cached[canonical.Digest()] = true
+		}
+	}
+	return cached
+}
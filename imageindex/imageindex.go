@@ -0,0 +1,230 @@
+package imageindex
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/earthly/earthly/util/llbutil"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// ErrNotIndexed is returned by the offline meta resolver for images which have
+// never been resolved while online.
+var ErrNotIndexed = errors.New("image has not been resolved before; run earthly prefetch while online first")
+
+// ErrNotCached is returned by the offline meta resolver for images whose layers
+// are not held in the cache of buildkitd, such as after it was pruned.
+var ErrNotCached = errors.New("the layers of the image are not in the buildkit cache; run earthly prefetch while online first")
+
+// defaultMaxEntries is the number of entries beyond which the least recently
+// used ones are dropped when the index is saved.
+const defaultMaxEntries = 1000
+
+// Entry is the resolved metadata of a single image.
+type Entry struct {
+	Digest digest.Digest `json:"digest"`
+	Config []byte        `json:"config"`
+	// Used is the day (as in 2021-06-01) on which the image was last resolved.
+	Used string `json:"used,omitempty"`
+}
+
+// Index records the digests and configs of the images resolved while online,
+// so that they can be resolved again without network access.
+type Index struct {
+	mu         sync.Mutex
+	path       string
+	entries    map[string]Entry
+	dirty      bool
+	maxEntries int
+	now        func() time.Time
+}
+
+// Load reads the index stored at the given path. A missing file results in an
+// empty index.
+func Load(path string) (*Index, error) {
+	idx := &Index{
+		path:       path,
+		entries:    make(map[string]Entry),
+		maxEntries: defaultMaxEntries,
+		now:        time.Now,
+	}
+	dt, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "read image index %s", path)
+	}
+	err = json.Unmarshal(dt, &idx.entries)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse image index %s", path)
+	}
+	return idx, nil
+}
+
+// Get returns the entry for the given image ref and platform, and marks it as
+// used.
+func (idx *Index) Get(ref, platform string) (Entry, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	k := key(ref, platform)
+	e, ok := idx.entries[k]
+	if ok && e.Used != idx.today() {
+		e.Used = idx.today()
+		idx.entries[k] = e
+		idx.dirty = true
+	}
+	return e, ok
+}
+
+// Put records the entry for the given image ref and platform. The index only
+// changes if the image resolves to a different digest, or was last used on
+// another day.
+func (idx *Index) Put(ref, platform string, e Entry) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	k := key(ref, platform)
+	e.Used = idx.today()
+	if existing, ok := idx.entries[k]; ok && existing.Digest == e.Digest && existing.Used == e.Used {
+		return
+	}
+	idx.entries[k] = e
+	idx.dirty = true
+}
+
+func (idx *Index) today() string {
+	return idx.now().UTC().Format("2006-01-02")
+}
+
+// Len returns the number of entries in the index.
+func (idx *Index) Len() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return len(idx.entries)
+}
+
+// Save writes the index back to disk, if it has changed. Entries written by
+// other processes in the meantime are preserved, unless the index exceeds its
+// maximum number of entries, in which case the least recently used ones are
+// dropped.
+func (idx *Index) Save() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.dirty {
+		return nil
+	}
+	onDisk, err := Load(idx.path)
+	if err != nil {
+		return err
+	}
+	for k, e := range onDisk.entries {
+		if _, ok := idx.entries[k]; !ok {
+			idx.entries[k] = e
+		}
+	}
+	idx.evict()
+	dt, err := json.Marshal(idx.entries)
+	if err != nil {
+		return errors.Wrap(err, "marshal image index")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(idx.path), ".image-index-*")
+	if err != nil {
+		return errors.Wrap(err, "create image index")
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(dt)
+	tmp.Close()
+	if err != nil {
+		return errors.Wrapf(err, "write image index %s", tmp.Name())
+	}
+	err = os.Rename(tmp.Name(), idx.path)
+	if err != nil {
+		return errors.Wrapf(err, "rename image index to %s", idx.path)
+	}
+	idx.dirty = false
+	return nil
+}
+
+// evict drops the least recently used entries beyond the maximum number of
+// entries.
+func (idx *Index) evict() {
+	if len(idx.entries) <= idx.maxEntries {
+		return
+	}
+	keys := make([]string, 0, len(idx.entries))
+	for k := range idx.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ui, uj := idx.entries[keys[i]].Used, idx.entries[keys[j]].Used
+		if ui != uj {
+			return ui < uj
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys[:len(keys)-idx.maxEntries] {
+		delete(idx.entries, k)
+	}
+}
+
+func key(ref, platform string) string {
+	return ref + "|" + platform
+}
+
+type recordingMetaResolver struct {
+	metaResolver llb.ImageMetaResolver
+	idx          *Index
+}
+
+// NewRecordingMetaResolver returns a meta resolver which records every image
+// resolved by the underlying meta resolver into the index.
+func NewRecordingMetaResolver(metaResolver llb.ImageMetaResolver, idx *Index) llb.ImageMetaResolver {
+	return &recordingMetaResolver{
+		metaResolver: metaResolver,
+		idx:          idx,
+	}
+}
+
+func (r *recordingMetaResolver) ResolveImageConfig(ctx context.Context, ref string, opt llb.ResolveImageConfigOpt) (digest.Digest, []byte, error) {
+	dgst, config, err := r.metaResolver.ResolveImageConfig(ctx, ref, opt)
+	if err != nil {
+		return "", nil, err
+	}
+	if dgst != "" {
+		r.idx.Put(ref, llbutil.PlatformToString(opt.Platform), Entry{Digest: dgst, Config: config})
+	}
+	return dgst, config, nil
+}
+
+type offlineMetaResolver struct {
+	idx    *Index
+	cached CachedImages
+}
+
+// NewOfflineMetaResolver returns a meta resolver which resolves images solely
+// from the index, without network access. If cached is not nil, images whose
+// layers are not in it fail to resolve, rather than failing to pull later on.
+func NewOfflineMetaResolver(idx *Index, cached CachedImages) llb.ImageMetaResolver {
+	return &offlineMetaResolver{
+		idx:    idx,
+		cached: cached,
+	}
+}
+
+func (r *offlineMetaResolver) ResolveImageConfig(ctx context.Context, ref string, opt llb.ResolveImageConfigOpt) (digest.Digest, []byte, error) {
+	e, ok := r.idx.Get(ref, llbutil.PlatformToString(opt.Platform))
+	if !ok {
+		return "", nil, errors.Wrapf(ErrNotIndexed, "offline: %s (%s)", ref, llbutil.PlatformToString(opt.Platform))
+	}
+	if r.cached != nil && !r.cached[e.Digest] {
+		return "", nil, errors.Wrapf(ErrNotCached, "offline: %s (%s)", ref, llbutil.PlatformToString(opt.Platform))
+	}
+	return e.Digest, e.Config, nil
+}
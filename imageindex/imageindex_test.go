@@ -0,0 +1,126 @@
+package imageindex
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imageindex")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "image-index.json")
+
+	idx, err := Load(path)
+	NoError(t, err)
+	Equal(t, 0, idx.Len())
+	idx.Put("docker.io/library/alpine:3.13", "linux/amd64", Entry{Digest: "sha256:aaa", Config: []byte("{}")})
+	NoError(t, idx.Save())
+
+	// Another process records a different image in the meantime.
+	other, err := Load(path)
+	NoError(t, err)
+	other.Put("docker.io/library/golang:1.16", "linux/arm64", Entry{Digest: "sha256:bbb", Config: []byte("{}")})
+	NoError(t, other.Save())
+
+	idx.Put("docker.io/library/alpine:3.13", "linux/arm64", Entry{Digest: "sha256:ccc", Config: []byte("{}")})
+	NoError(t, idx.Save())
+
+	reloaded, err := Load(path)
+	NoError(t, err)
+	Equal(t, 3, reloaded.Len())
+	e, ok := reloaded.Get("docker.io/library/golang:1.16", "linux/arm64")
+	True(t, ok)
+	Equal(t, "sha256:bbb", string(e.Digest))
+	_, ok = reloaded.Get("docker.io/library/golang:1.16", "linux/amd64")
+	False(t, ok)
+}
+
+func TestSaveOnlyWhenChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imageindex")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "image-index.json")
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	idx, err := Load(path)
+	NoError(t, err)
+	idx.now = func() time.Time { return now }
+	idx.Put("docker.io/library/alpine:3.13", "linux/amd64", Entry{Digest: "sha256:aaa", Config: []byte("{}")})
+	True(t, idx.dirty)
+	NoError(t, idx.Save())
+
+	// Resolving the same image again on the same day changes nothing.
+	now = now.Add(time.Hour)
+	idx.Put("docker.io/library/alpine:3.13", "linux/amd64", Entry{Digest: "sha256:aaa", Config: []byte("{}")})
+	_, ok := idx.Get("docker.io/library/alpine:3.13", "linux/amd64")
+	True(t, ok)
+	False(t, idx.dirty)
+
+	now = now.Add(24 * time.Hour)
+	e, ok := idx.Get("docker.io/library/alpine:3.13", "linux/amd64")
+	True(t, ok)
+	Equal(t, "2021-06-02", e.Used)
+	True(t, idx.dirty)
+}
+
+func TestEvict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imageindex")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "image-index.json")
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	idx, err := Load(path)
+	NoError(t, err)
+	idx.now = func() time.Time { return now }
+	idx.maxEntries = 2
+	for _, ref := range []string{"a:1", "b:1", "c:1"} {
+		idx.Put(ref, "linux/amd64", Entry{Digest: "sha256:aaa", Config: []byte("{}")})
+		now = now.Add(24 * time.Hour)
+	}
+	// a:1 is used again, which leaves b:1 as the least recently used.
+	_, ok := idx.Get("a:1", "linux/amd64")
+	True(t, ok)
+	NoError(t, idx.Save())
+
+	reloaded, err := Load(path)
+	NoError(t, err)
+	Equal(t, 2, reloaded.Len())
+	_, ok = reloaded.Get("b:1", "linux/amd64")
+	False(t, ok)
+	_, ok = reloaded.Get("c:1", "linux/amd64")
+	True(t, ok)
+}
+
+func TestOfflineMetaResolver(t *testing.T) {
+	idx, err := Load(filepath.Join(os.TempDir(), "does-not-exist", "image-index.json"))
+	NoError(t, err)
+	platform := platforms.MustParse("linux/amd64")
+	dgst := digest.FromString("alpine")
+	idx.Put("docker.io/library/alpine:3.13", platforms.Format(platform), Entry{Digest: dgst, Config: []byte("{}")})
+	opt := llb.ResolveImageConfigOpt{Platform: &platform}
+
+	cached := cachedImagesFromDescriptions([]string{
+		"pulled from docker.io/library/alpine:3.13@" + dgst.String(),
+		"local source for context",
+	})
+	actual, _, err := NewOfflineMetaResolver(idx, cached).ResolveImageConfig(context.Background(), "docker.io/library/alpine:3.13", opt)
+	NoError(t, err)
+	Equal(t, dgst, actual)
+
+	_, _, err = NewOfflineMetaResolver(idx, CachedImages{}).ResolveImageConfig(context.Background(), "docker.io/library/alpine:3.13", opt)
+	True(t, errors.Is(err, ErrNotCached))
+	_, _, err = NewOfflineMetaResolver(idx, cached).ResolveImageConfig(context.Background(), "docker.io/library/golang:1.16", opt)
+	True(t, errors.Is(err, ErrNotIndexed))
+}
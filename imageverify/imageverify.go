@@ -0,0 +1,233 @@
+// Package imageverify verifies the signatures and attestations of base
+// images against a policy, via the cosign or notation CLIs, as requested by
+// FROM --verify.
+package imageverify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ToolCosign verifies images signed via sigstore cosign.
+	ToolCosign = "cosign"
+	// ToolNotation verifies images signed via notation (Notary v2).
+	ToolNotation = "notation"
+)
+
+// Policy describes how an image must be signed.
+type Policy struct {
+	// Tool is either ToolCosign or ToolNotation.
+	Tool string
+	// Key is the public key (a path or a KMS URI) the image must be signed
+	// with. If empty, cosign verifies keyless signatures instead.
+	Key string
+	// Identity and Issuer are the certificate identity and OIDC issuer which
+	// keyless cosign signatures must match.
+	Identity string
+	Issuer   string
+	// Attestation is the predicate type of an attestation which the image
+	// must carry in addition to its signature, as in slsaprovenance.
+	Attestation string
+}
+
+// ParsePolicy parses the value of FROM --verify, which has the form
+// <tool>[:<key>=<value>,...], as in cosign:key=cosign.pub or
+// cosign:identity=me@example.com,issuer=https://accounts.google.com.
+func ParsePolicy(s string) (Policy, error) {
+	tool, opts := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		tool, opts = s[:i], s[i+1:]
+	}
+	p := Policy{Tool: tool}
+	if tool != ToolCosign && tool != ToolNotation {
+		return Policy{}, errors.Errorf("invalid verification policy %q: the tool must be %s or %s", s, ToolCosign, ToolNotation)
+	}
+	if opts == "" {
+		return p, nil
+	}
+	for _, kv := range strings.Split(opts, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return Policy{}, errors.Errorf("invalid verification policy %q: expected <key>=<value>, got %q", s, kv)
+		}
+		switch parts[0] {
+		case "key":
+			p.Key = parts[1]
+		case "identity":
+			p.Identity = parts[1]
+		case "issuer":
+			p.Issuer = parts[1]
+		case "attestation":
+			p.Attestation = parts[1]
+		default:
+			return Policy{}, errors.Errorf("invalid verification policy %q: unknown option %s", s, parts[0])
+		}
+	}
+	if p.Tool == ToolNotation && (p.Key != "" || p.Identity != "" || p.Issuer != "" || p.Attestation != "") {
+		return Policy{}, errors.Errorf("invalid verification policy %q: notation is configured via its trust policy and takes no options", s)
+	}
+	if p.Key != "" && (p.Identity != "" || p.Issuer != "") {
+		return Policy{}, errors.Errorf("invalid verification policy %q: key cannot be combined with identity or issuer", s)
+	}
+	return p, nil
+}
+
+// String returns the policy in the form accepted by ParsePolicy.
+func (p Policy) String() string {
+	var opts []string
+	for _, kv := range [][2]string{{"key", p.Key}, {"identity", p.Identity}, {"issuer", p.Issuer}, {"attestation", p.Attestation}} {
+		if kv[1] != "" {
+			opts = append(opts, kv[0]+"="+kv[1])
+		}
+	}
+	if len(opts) == 0 {
+		return p.Tool
+	}
+	return p.Tool + ":" + strings.Join(opts, ",")
+}
+
+// commands returns the CLI invocations which verify ref as per the policy.
+func (p Policy) commands(ref string) [][]string {
+	if p.Tool == ToolNotation {
+		return [][]string{{ToolNotation, "verify", ref}}
+	}
+	var flags []string
+	if p.Key != "" {
+		flags = append(flags, "--key", p.Key)
+	} else {
+		flags = append(flags, "--certificate-identity-regexp", orAny(p.Identity), "--certificate-oidc-issuer-regexp", orAny(p.Issuer))
+	}
+	cmds := [][]string{append(append([]string{ToolCosign, "verify"}, flags...), ref)}
+	if p.Attestation != "" {
+		cmds = append(cmds, append(append([]string{ToolCosign, "verify-attestation", "--type", p.Attestation}, flags...), ref))
+	}
+	return cmds
+}
+
+func orAny(s string) string {
+	if s == "" {
+		return ".*"
+	}
+	return "^" + regexp.QuoteMeta(s) + "$"
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// RunFunc runs a command, returning its combined output.
+type RunFunc func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// Verifier verifies images, remembering the outcome, so that images used
+// by several targets are only verified once per build.
+type Verifier struct {
+	run RunFunc
+
+	mu      sync.Mutex
+	results map[string]error
+}
+
+// NewVerifier returns a verifier which invokes the cosign and notation CLIs
+// found in the PATH.
+func NewVerifier() *Verifier {
+	return NewVerifierWithRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		if _, err := exec.LookPath(name); err != nil {
+			return nil, errors.Errorf("%s is required to verify images, but it was not found in the PATH", name)
+		}
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		err := cmd.Run()
+		return out.Bytes(), err
+	})
+}
+
+// NewVerifierWithRunner returns a verifier which runs commands via run.
+func NewVerifierWithRunner(run RunFunc) *Verifier {
+	return &Verifier{run: run, results: make(map[string]error)}
+}
+
+// Verify checks that the image ref, which must be pinned by digest, satisfies
+// the policy.
+func (v *Verifier) Verify(ctx context.Context, ref string, p Policy) error {
+	if !strings.Contains(ref, "@sha256:") {
+		return errors.Errorf("cannot verify %s: the image must be resolved to a digest first", ref)
+	}
+	key := ref + " " + p.String()
+	v.mu.Lock()
+	err, ok := v.results[key]
+	v.mu.Unlock()
+	if ok {
+		return err
+	}
+	err = v.verify(ctx, ref, p)
+	v.mu.Lock()
+	v.results[key] = err
+	v.mu.Unlock()
+	return err
+}
+
+func (v *Verifier) verify(ctx context.Context, ref string, p Policy) error {
+	for _, args := range p.commands(ref) {
+		out, err := v.run(ctx, args[0], args[1:]...)
+		if err == nil {
+			continue
+		}
+		reason := describeFailure(string(out), args[1] == "verify-attestation", p)
+		if reason == "" {
+			reason = err.Error()
+		}
+		return &VerificationError{Ref: ref, Policy: p, Reason: reason, Output: strings.TrimSpace(string(out))}
+	}
+	return nil
+}
+
+// describeFailure turns the output of the CLIs into the reason the image
+// failed verification, if it is a well-known one.
+func describeFailure(out string, attestation bool, p Policy) string {
+	lower := strings.ToLower(out)
+	switch {
+	case attestation && (strings.Contains(lower, "no matching attestations") || strings.Contains(lower, "none of the attestations matched")):
+		return fmt.Sprintf("missing attestation of type %s", p.Attestation)
+	case strings.Contains(lower, "no matching signatures") || strings.Contains(lower, "no signatures found") ||
+		strings.Contains(lower, "no signature is associated"):
+		if p.Key != "" {
+			return fmt.Sprintf("missing signature by key %s", p.Key)
+		}
+		if p.Identity != "" || p.Issuer != "" {
+			return fmt.Sprintf("missing signature by identity %s (issuer %s)", orDefault(p.Identity, "any"), orDefault(p.Issuer, "any"))
+		}
+		return "missing signature"
+	case strings.Contains(lower, "trust policy"):
+		return "no notation trust policy applies to the image"
+	}
+	return ""
+}
+
+// VerificationError is returned for images which fail verification.
+type VerificationError struct {
+	Ref    string
+	Policy Policy
+	Reason string
+	Output string
+}
+
+func (e *VerificationError) Error() string {
+	msg := fmt.Sprintf("image %s failed verification against policy %s: %s", e.Ref, e.Policy, e.Reason)
+	if e.Output != "" {
+		msg += "\n" + e.Output
+	}
+	return msg
+}
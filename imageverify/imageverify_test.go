@@ -0,0 +1,64 @@
+package imageverify
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("cosign")
+	NoError(t, err)
+	Equal(t, Policy{Tool: ToolCosign}, p)
+	p, err = ParsePolicy("cosign:key=cosign.pub,attestation=slsaprovenance")
+	NoError(t, err)
+	Equal(t, Policy{Tool: ToolCosign, Key: "cosign.pub", Attestation: "slsaprovenance"}, p)
+	Equal(t, "cosign:key=cosign.pub,attestation=slsaprovenance", p.String())
+	p, err = ParsePolicy("cosign:identity=ci@example.com,issuer=https://token.actions.githubusercontent.com")
+	NoError(t, err)
+	Equal(t, "https://token.actions.githubusercontent.com", p.Issuer)
+	p, err = ParsePolicy("notation")
+	NoError(t, err)
+	Equal(t, ToolNotation, p.Tool)
+
+	for _, s := range []string{"", "gpg", "cosign:key", "cosign:foo=bar", "notation:key=a.pub", "cosign:key=a.pub,identity=me"} {
+		_, err = ParsePolicy(s)
+		Error(t, err, s)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const ref = "docker.io/library/alpine:3.15@sha256:21a3deaa0d32a8057914f36584b5288d2e5ecc984380bc0118285c70fa8c9300"
+	var calls []string
+	v := NewVerifierWithRunner(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		if args[0] == "verify-attestation" {
+			return []byte("Error: no matching attestations: predicate type mismatch"), errors.New("exit status 1")
+		}
+		for _, a := range args {
+			if a == "other.pub" {
+				return []byte("Error: no matching signatures: invalid signature"), errors.New("exit status 1")
+			}
+		}
+		return nil, nil
+	})
+	ctx := context.Background()
+
+	NoError(t, v.Verify(ctx, ref, Policy{Tool: ToolCosign, Key: "cosign.pub"}))
+	NoError(t, v.Verify(ctx, ref, Policy{Tool: ToolCosign, Key: "cosign.pub"}))
+	Equal(t, []string{"cosign verify --key cosign.pub " + ref}, calls)
+
+	err := v.Verify(ctx, ref, Policy{Tool: ToolCosign, Key: "other.pub"})
+	Error(t, err)
+	True(t, strings.Contains(err.Error(), "missing signature by key other.pub"), err.Error())
+
+	err = v.Verify(ctx, ref, Policy{Tool: ToolCosign, Identity: "ci@example.com", Attestation: "slsaprovenance"})
+	Error(t, err)
+	True(t, strings.Contains(err.Error(), "missing attestation of type slsaprovenance"), err.Error())
+	Equal(t, "cosign verify --certificate-identity-regexp ^ci@example\\.com$ --certificate-oidc-issuer-regexp .* "+ref, calls[2])
+
+	Error(t, v.Verify(ctx, "alpine:3.15", Policy{Tool: ToolNotation}))
+}
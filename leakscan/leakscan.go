@@ -0,0 +1,256 @@
+// Package leakscan detects secrets which leaked into the layers of images
+// before they are pushed, as requested by earthly --scan-leaks: the values of
+// the secrets of the build, well-known credential formats, and high-entropy
+// values assigned to names such as token or password.
+package leakscan
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// minSecretLen is the length under which the values of secrets are not
+	// looked for, as they would be found by chance.
+	minSecretLen = 8
+	// maxFileSize is the number of bytes of each file which are scanned.
+	maxFileSize = 4 * 1024 * 1024
+	// minEntropy is the Shannon entropy, in bits per character, above which
+	// a value assigned to a credential-like name is considered a secret,
+	// rather than a placeholder.
+	minEntropy = 3.5
+)
+
+// rules are well-known credential formats.
+var rules = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"private key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )?PRIVATE KEY(?: BLOCK)?-----`)},
+	{"AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{82})\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{"Stripe secret key", regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,}`)},
+	{"npm token", regexp.MustCompile(`\bnpm_[A-Za-z0-9]{36}\b`)},
+}
+
+// assignmentRegexp matches values assigned to credential-like names, as in
+// API_TOKEN=..., "password": "..." or _authToken=....
+var assignmentRegexp = regexp.MustCompile(`(?i)([a-z0-9_.-]*(?:secret|token|passw(?:or)?d|api[_-]?key|access[_-]?key|private[_-]?key|credentials?)[a-z0-9_.-]*)["']?\s*[:=]\s*["']?([A-Za-z0-9+/=_.~-]{16,})`)
+
+// Finding is a secret found in a file.
+type Finding struct {
+	Path string
+	// Line is the line of the file the secret is on, or 0 for binary files.
+	Line int
+	// Rule describes what was found, as in value of secret NPM_TOKEN.
+	Rule string
+}
+
+func (f Finding) String() string {
+	if f.Line == 0 {
+		return fmt.Sprintf("%s: %s", f.Path, f.Rule)
+	}
+	return fmt.Sprintf("%s line %d: %s", f.Path, f.Line, f.Rule)
+}
+
+// Scanner scans files for secrets. It is safe for concurrent use.
+type Scanner struct {
+	// AllowPaths are patterns, as per path.Match, of the absolute paths of
+	// files which are not scanned, such as test fixtures.
+	AllowPaths []string
+
+	mu      sync.Mutex
+	secrets map[string]string // value -> name
+}
+
+// NewScanner returns a scanner which looks for the given patterns and the
+// values of the secrets added to it.
+func NewScanner(allowPaths []string) *Scanner {
+	return &Scanner{AllowPaths: allowPaths, secrets: make(map[string]string)}
+}
+
+// AddSecret adds the value of a secret of the build to look for. A trailing
+// newline is ignored, and values shorter than 8 characters are not looked
+// for.
+func (s *Scanner) AddSecret(name string, value []byte) {
+	value = bytes.TrimRight(value, "\r\n")
+	if len(value) < minSecretLen {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.secrets[string(value)]; !ok {
+		s.secrets[string(value)] = name
+	}
+}
+
+// Scan returns the secrets found in the contents of the file at p. Each rule
+// is reported once per file, at its first match.
+func (s *Scanner) Scan(p string, dt []byte) []Finding {
+	binary := bytes.IndexByte(dt[:minInt(len(dt), 8000)], 0) != -1
+	var findings []Finding
+	add := func(offset int, rule string) {
+		line := 0
+		if !binary {
+			line = bytes.Count(dt[:offset], []byte("\n")) + 1
+		}
+		findings = append(findings, Finding{Path: p, Line: line, Rule: rule})
+	}
+
+	s.mu.Lock()
+	var values []string
+	for v := range s.secrets {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	names := make([]string, len(values))
+	for i, v := range values {
+		names[i] = s.secrets[v]
+	}
+	s.mu.Unlock()
+	for i, v := range values {
+		if offset := bytes.Index(dt, []byte(v)); offset != -1 {
+			add(offset, "value of secret "+names[i])
+		}
+	}
+
+	for _, r := range rules {
+		if loc := r.re.FindIndex(dt); loc != nil {
+			add(loc[0], r.name)
+		}
+	}
+
+	// Values assigned to credential-like names are only considered when
+	// nothing more specific was found, as they are the least certain.
+	if !binary && len(findings) == 0 {
+		for _, m := range assignmentRegexp.FindAllSubmatchIndex(dt, -1) {
+			if entropy(dt[m[4]:m[5]]) >= minEntropy {
+				add(m[0], "high-entropy value assigned to "+string(dt[m[2]:m[3]]))
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// entropy returns the Shannon entropy of the value, in bits per character.
+func entropy(v []byte) float64 {
+	var counts [256]int
+	for _, c := range v {
+		counts[c]++
+	}
+	var e float64
+	for _, n := range counts {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / float64(len(v))
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Entry is a file or directory of a filesystem.
+type Entry struct {
+	Name    string
+	Mode    os.FileMode
+	Size    int64
+	ModTime int64
+}
+
+// FS is a read-only filesystem, such as a layer of an image.
+type FS interface {
+	// ReadDir returns the entries of the directory at the absolute path dir.
+	ReadDir(ctx context.Context, dir string) ([]Entry, error)
+	// ReadFile returns up to limit bytes of the file at the absolute path p.
+	ReadFile(ctx context.Context, p string, limit int) ([]byte, error)
+}
+
+// Index lists the regular files of the filesystem, by absolute path.
+func Index(ctx context.Context, fs FS) (map[string]Entry, error) {
+	files := make(map[string]Entry)
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := fs.ReadDir(ctx, dir)
+		if err != nil {
+			return errors.Wrapf(err, "read dir %s", dir)
+		}
+		for _, e := range entries {
+			p := path.Join(dir, e.Name)
+			switch {
+			case e.Mode.IsDir():
+				err = walk(p)
+				if err != nil {
+					return err
+				}
+			case e.Mode.IsRegular():
+				files[p] = e
+			}
+		}
+		return nil
+	}
+	err := walk("/")
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Changed returns the files of after which are not in before, or which
+// differ from those of before, sorted.
+func Changed(before, after map[string]Entry) []string {
+	var changed []string
+	for p, e := range after {
+		b, ok := before[p]
+		if !ok || b.Size != e.Size || b.ModTime != e.ModTime || b.Mode != e.Mode {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// ScanFiles scans the given files of the filesystem, skipping those of
+// AllowPaths.
+func (s *Scanner) ScanFiles(ctx context.Context, fs FS, paths []string) ([]Finding, error) {
+	var findings []Finding
+	for _, p := range paths {
+		if s.allowed(p) {
+			continue
+		}
+		dt, err := fs.ReadFile(ctx, p, maxFileSize)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", p)
+		}
+		findings = append(findings, s.Scan(p, dt)...)
+	}
+	return findings, nil
+}
+
+func (s *Scanner) allowed(p string) bool {
+	for _, pattern := range s.AllowPaths {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/earthly/earthly/ast/spec"
+)
+
+var (
+	pipeToShellRegexp = regexp.MustCompile(`\b(curl|wget)\b[^|;&]*\|\s*(sudo\s+)?(sh|bash|zsh|dash|ash)\b`)
+	timestampRegexp   = regexp.MustCompile("(\\$\\(|`)\\s*date\\b")
+	shellSplitRegexp  = regexp.MustCompile(`&&|\|\||[;|\n]`)
+)
+
+// cacheRules report the RUN commands and images whose results are cached even
+// though they depend on the state of the network or on the time of the build:
+// rebuilding them from scratch yields something else than the cache holds.
+var cacheRules = []Rule{
+	{
+		Name:        "unpinned-packages",
+		Description: "apt-get install and apk add of packages without version pins, or apt-get update in a RUN of its own",
+		Check:       checkUnpinnedPackages,
+	},
+	{
+		Name:        "pipe-to-shell",
+		Description: "scripts downloaded via curl or wget and piped into a shell",
+		Check:       checkPipeToShell,
+	},
+	{
+		Name:        "latest-tag",
+		Description: "images used via the latest tag, explicitly or implicitly",
+		Check:       checkLatestTag,
+	},
+	{
+		Name:        "timestamp",
+		Description: "the current time embedded in outputs or ARGs via date",
+		Check:       checkTimestamp,
+	},
+}
+
+func runArgs(cmd spec.Command) []string {
+	args := cmd.Args
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		args = args[1:]
+	}
+	return args
+}
+
+// isBareHeredoc returns whether the RUN command consists of a single
+// here-document, as in RUN <<EOF.
+func isBareHeredoc(cmd spec.Command) bool {
+	args := runArgs(cmd)
+	return len(args) == 1 && len(cmd.Heredocs) == 1 && strings.HasPrefix(args[0], "<<")
+}
+
+// runScript returns the script of a RUN command, without its flags, and
+// with its here-documents, if any.
+func runScript(cmd spec.Command) string {
+	args := runArgs(cmd)
+	if isBareHeredoc(cmd) {
+		// RUN <<EOF executes the content of the here-document.
+		return cmd.Heredocs[0].Content
+	}
+	script := strings.Join(args, " ")
+	for _, h := range cmd.Heredocs {
+		script += "\n" + h.Content + h.Delimiter
+	}
+	return script
+}
+
+func checkUnpinnedPackages(cmd spec.Command) []string {
+	if cmd.Name != "RUN" {
+		return nil
+	}
+	var msgs []string
+	hasUpdate := false
+	hasInstall := false
+	for _, part := range shellSplitRegexp.Split(runScript(cmd), -1) {
+		fields := strings.Fields(part)
+		for len(fields) > 0 && (fields[0] == "sudo" || strings.Contains(fields[0], "=")) {
+			fields = fields[1:]
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		var tool string
+		var pkgs []string
+		switch {
+		case (fields[0] == "apt-get" || fields[0] == "apt") && containsWord(fields[1:], "update"):
+			hasUpdate = true
+			continue
+		case fields[0] == "apt-get" || fields[0] == "apt":
+			pkgs = wordsAfter(fields[1:], "install")
+			tool = fields[0] + " install"
+		case fields[0] == "apk":
+			pkgs = wordsAfter(fields[1:], "add")
+			tool = "apk add"
+		}
+		if pkgs == nil {
+			continue
+		}
+		hasInstall = true
+		var unpinned []string
+		for _, pkg := range pkgs {
+			if strings.HasPrefix(pkg, "-") || strings.Contains(pkg, "=") || strings.Contains(pkg, "$") {
+				continue
+			}
+			unpinned = append(unpinned, pkg)
+		}
+		if len(unpinned) > 0 {
+			msgs = append(msgs, fmt.Sprintf(
+				"%s of packages without version pins (%s): the cached layer keeps the versions current when it was first built, "+
+					"while a rebuild from scratch installs newer ones; pin them, as in %s=<version>",
+				tool, strings.Join(unpinned, ", "), unpinned[0]))
+		}
+	}
+	if hasUpdate && !hasInstall {
+		msgs = append(msgs,
+			"apt-get update in a RUN of its own: its result is cached, so the installs of later RUN commands use a stale package index; "+
+				"run it in the same RUN as apt-get install")
+	}
+	return msgs
+}
+
+func containsWord(words []string, w string) bool {
+	for _, word := range words {
+		if word == w {
+			return true
+		}
+	}
+	return false
+}
+
+// wordsAfter returns the words after the subcommand, or nil if the
+// subcommand is not present.
+func wordsAfter(words []string, subcommand string) []string {
+	for i, word := range words {
+		if word == subcommand {
+			return append([]string{}, words[i+1:]...)
+		}
+	}
+	return nil
+}
+
+func checkPipeToShell(cmd spec.Command) []string {
+	if cmd.Name != "RUN" {
+		return nil
+	}
+	m := pipeToShellRegexp.FindStringSubmatch(runScript(cmd))
+	if m == nil {
+		return nil
+	}
+	return []string{fmt.Sprintf(
+		"a script downloaded via %s is piped into %s: the RUN is cached by its text rather than by the script, "+
+			"which may change at any time; download it via COPY --checksum instead", m[1], m[3])}
+}
+
+func checkLatestTag(cmd spec.Command) []string {
+	var images []string
+	switch cmd.Name {
+	case "FROM":
+		if len(cmd.Args) > 0 && cmd.Args[0] == "NIX" {
+			// The last argument of FROM NIX is the flake.
+			images = flagValues(cmd.Args, "--image")
+		} else if len(cmd.Args) > 0 {
+			images = append(images, cmd.Args[len(cmd.Args)-1])
+		}
+	case "DOCKER":
+		images = flagValues(cmd.Args, "--pull")
+	}
+	var msgs []string
+	for _, image := range images {
+		if isLatest(image) {
+			msgs = append(msgs, fmt.Sprintf(
+				"image %s uses the latest tag: the cached build keeps the image current when it was first built; "+
+					"pin a version, or a digest (@sha256:...)", image))
+		}
+	}
+	return msgs
+}
+
+// flagValues returns the values of the flag in args, in either the --flag=value
+// or the --flag value form.
+func flagValues(args []string, flag string) []string {
+	var values []string
+	for i, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, flag+"="):
+			values = append(values, strings.TrimPrefix(arg, flag+"="))
+		case arg == flag && i+1 < len(args):
+			values = append(values, args[i+1])
+		}
+	}
+	return values
+}
+
+// isLatest returns whether the image reference uses the latest tag. Target
+// references, and references containing ARGs, are not images.
+func isLatest(ref string) bool {
+	if ref == "scratch" || strings.Contains(ref, "+") || strings.Contains(ref, "$") || strings.Contains(ref, "@") {
+		return false
+	}
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	i := strings.LastIndex(name, ":")
+	return i == -1 || name[i+1:] == "latest"
+}
+
+func checkTimestamp(cmd spec.Command) []string {
+	if cmd.Name != "RUN" && cmd.Name != "ARG" {
+		return nil
+	}
+	if !timestampRegexp.MatchString(strings.Join(cmd.Args, " ")) {
+		return nil
+	}
+	return []string{
+		"the current time is embedded via date: the output differs on every rebuild from scratch, " +
+			"while cached builds keep the time of the first one; use SOURCE_DATE_EPOCH, or pass the time via a build arg"}
+}
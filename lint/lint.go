@@ -0,0 +1,91 @@
+// Package lint checks Earthfiles for common mistakes, such as RUN commands
+// whose results are cached even though they depend on the state of the
+// network or on the time of the build, as reported by earthly lint.
+package lint
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/earthly/earthly/ast/spec"
+)
+
+// Issue is a problem found in an Earthfile.
+type Issue struct {
+	// Rule is the name of the rule which found the issue.
+	Rule string
+	// Target is the target (as in +build) or user command the issue was found
+	// in, or empty for the base recipe.
+	Target  string
+	Line    int
+	Message string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%d: [%s] %s", i.Line, i.Rule, i.Message)
+}
+
+// Rule checks the commands of an Earthfile.
+type Rule struct {
+	Name        string
+	Description string
+	// Check returns the messages of the issues found in the command, if any.
+	Check func(cmd spec.Command) []string
+}
+
+// Rules returns all the rules, in the order of their names.
+func Rules() []Rule {
+	rules := append([]Rule(nil), cacheRules...)
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// Lint checks the Earthfile with the given rules, returning the issues found
+// in the order of their lines.
+func Lint(ef spec.Earthfile, rules []Rule) []Issue {
+	var issues []Issue
+	check := func(target string, cmd spec.Command) {
+		for _, r := range rules {
+			for _, msg := range r.Check(cmd) {
+				issue := Issue{Rule: r.Name, Target: target, Message: msg}
+				if cmd.SourceLocation != nil {
+					issue.Line = cmd.SourceLocation.StartLine
+				}
+				issues = append(issues, issue)
+			}
+		}
+	}
+	walkBlock(ef.BaseRecipe, func(cmd spec.Command) { check("", cmd) })
+	for _, t := range ef.Targets {
+		walkBlock(t.Recipe, func(cmd spec.Command) { check("+"+t.Name, cmd) })
+	}
+	for _, uc := range ef.UserCommands {
+		walkBlock(uc.Recipe, func(cmd spec.Command) { check(uc.Name, cmd) })
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	return issues
+}
+
+// walkBlock calls f for every command of the block, including those nested
+// in WITH, IF and FOR statements.
+func walkBlock(block spec.Block, f func(cmd spec.Command)) {
+	for _, stmt := range block {
+		switch {
+		case stmt.Command != nil:
+			f(*stmt.Command)
+		case stmt.With != nil:
+			f(stmt.With.Command)
+			walkBlock(stmt.With.Body, f)
+		case stmt.If != nil:
+			walkBlock(stmt.If.IfBody, f)
+			for _, elseIf := range stmt.If.ElseIf {
+				walkBlock(elseIf.Body, f)
+			}
+			if stmt.If.ElseBody != nil {
+				walkBlock(*stmt.If.ElseBody, f)
+			}
+		case stmt.For != nil:
+			walkBlock(stmt.For.Body, f)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	. "github.com/stretchr/testify/assert"
+)
+
+func cmdStmt(line int, name string, args ...string) spec.Statement {
+	return spec.Statement{Command: &spec.Command{
+		Name: name, Args: args, SourceLocation: &spec.SourceLocation{StartLine: line}}}
+}
+
+func TestLint(t *testing.T) {
+	ef := spec.Earthfile{
+		BaseRecipe: spec.Block{
+			cmdStmt(2, "FROM", "alpine"),
+		},
+		Targets: []spec.Target{{
+			Name: "build",
+			Recipe: spec.Block{
+				cmdStmt(5, "FROM", "--platform", "linux/amd64", "golang:1.16"),
+				cmdStmt(6, "RUN", "apt-get update && apt-get install -y curl git=1:2.30.2-1"),
+				{If: &spec.IfStatement{IfBody: spec.Block{
+					cmdStmt(8, "RUN", "curl -fsSL https://example.com/install.sh | sh"),
+				}}},
+				{With: &spec.WithStatement{
+					Command: spec.Command{Name: "DOCKER", Args: []string{"--pull", "redis:latest"},
+						SourceLocation: &spec.SourceLocation{StartLine: 10}},
+					Body: spec.Block{cmdStmt(11, "RUN", "echo $(date) > build-time")},
+				}},
+			},
+		}},
+	}
+	issues := Lint(ef, Rules())
+	var rules []string
+	var lines []int
+	for _, issue := range issues {
+		rules = append(rules, issue.Rule)
+		lines = append(lines, issue.Line)
+	}
+	Equal(t, []string{"latest-tag", "unpinned-packages", "pipe-to-shell", "latest-tag", "timestamp"}, rules)
+	Equal(t, []int{2, 6, 8, 10, 11}, lines)
+	Equal(t, "+build", issues[1].Target)
+	Contains(t, issues[1].Message, "(curl)")
+}
+
+func TestUnpinnedPackages(t *testing.T) {
+	Len(t, checkUnpinnedPackages(spec.Command{Name: "RUN", Args: []string{"apk add --no-cache curl=7.79.1-r0"}}), 0)
+	Len(t, checkUnpinnedPackages(spec.Command{Name: "RUN", Args: []string{"apk add --no-cache curl"}}), 1)
+	Len(t, checkUnpinnedPackages(spec.Command{Name: "RUN", Args: []string{"--mount=type=cache,target=/var/cache/apt", "apt-get update"}}), 1)
+	Len(t, checkUnpinnedPackages(spec.Command{Name: "RUN", Args: []string{"DEBIAN_FRONTEND=noninteractive apt-get install -y $PKGS"}}), 0)
+}
+
+func TestIsLatest(t *testing.T) {
+	True(t, isLatest("alpine"))
+	True(t, isLatest("localhost:5000/app"))
+	True(t, isLatest("docker.io/library/alpine:latest"))
+	False(t, isLatest("alpine:3.13"))
+	False(t, isLatest("localhost:5000/app:1.0"))
+	False(t, isLatest("alpine@sha256:abc"))
+	False(t, isLatest("+build"))
+	False(t, isLatest("$IMAGE"))
+	False(t, isLatest("scratch"))
+}
+
+func TestLatestTagFromNix(t *testing.T) {
+	Len(t, checkLatestTag(spec.Command{Name: "FROM", Args: []string{"NIX", "./nix"}}), 0)
+	Len(t, checkLatestTag(spec.Command{Name: "FROM", Args: []string{"NIX", "--image", "nixos/nix", "./nix"}}), 1)
+	Len(t, checkLatestTag(spec.Command{Name: "FROM", Args: []string{"NIX", "--image=nixos/nix:2.11.1", "./nix"}}), 0)
+}
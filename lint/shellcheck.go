@@ -0,0 +1,193 @@
+package lint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/pkg/errors"
+)
+
+// ShellCheckRule is the name of the rule of the issues found by ShellCheck.
+const ShellCheckRule = "shellcheck"
+
+// ErrShellCheckNotInstalled is returned when shellcheck cannot be found.
+var ErrShellCheckNotInstalled = errors.New("shellcheck not found: install shellcheck (e.g. apt-get install shellcheck or brew install shellcheck) to check RUN scripts")
+
+// shellCheckExcluded are the shellcheck codes which do not apply to RUN
+// scripts: SC2154 (variable referenced but not assigned) is reported for every
+// ARG used by the script.
+var shellCheckExcluded = []string{"SC2154"}
+
+type shellCheckComment struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Level   string `json:"level"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// ShellCheck runs shellcheck over the scripts of the RUN commands of the
+// Earthfile, in the dialect of the shell they are executed with, as per SHELL
+// and RUN --shell. RUN commands in exec form, or executed with a shell which
+// shellcheck does not support, are skipped.
+func ShellCheck(ctx context.Context, ef spec.Earthfile) ([]Issue, error) {
+	if _, err := exec.LookPath("shellcheck"); err != nil {
+		return nil, ErrShellCheckNotInstalled
+	}
+	var issues []Issue
+	var checkErr error
+	checkBlock := func(target string, block spec.Block, dialect string) string {
+		walkBlock(block, func(cmd spec.Command) {
+			if checkErr != nil {
+				return
+			}
+			switch cmd.Name {
+			case "SHELL":
+				dialect = shellDialect(shellOf(cmd.Args))
+			case "RUN":
+				runDialect := dialect
+				if s := runShellFlag(cmd); s != "" {
+					runDialect = shellDialect(shellOf([]string{s}))
+				}
+				if s := shebangShell(runScript(cmd)); s != "" {
+					// RUN <<EOF with a shebang executes the script as a file.
+					runDialect = shellDialect(s)
+				}
+				if cmd.ExecMode || runDialect == "" {
+					return
+				}
+				var found []Issue
+				found, checkErr = shellCheckRun(ctx, target, cmd, runDialect)
+				issues = append(issues, found...)
+			}
+		})
+		return dialect
+	}
+	baseDialect := checkBlock("", ef.BaseRecipe, "sh")
+	for _, t := range ef.Targets {
+		checkBlock("+"+t.Name, t.Recipe, baseDialect)
+	}
+	for _, uc := range ef.UserCommands {
+		checkBlock(uc.Name, uc.Recipe, "sh")
+	}
+	if checkErr != nil {
+		return nil, checkErr
+	}
+	return issues, nil
+}
+
+func shellCheckRun(ctx context.Context, target string, cmd spec.Command, dialect string) ([]Issue, error) {
+	c := exec.CommandContext(ctx, "shellcheck",
+		"--shell="+dialect, "--format=json", "--exclude="+strings.Join(shellCheckExcluded, ","), "-")
+	c.Stdin = strings.NewReader(runScript(cmd) + "\n")
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	output, err := c.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		// shellcheck exits with 1 when it finds issues.
+		err = nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "run shellcheck: %s", strings.TrimSpace(stderr.String()))
+	}
+	line := 0
+	if cmd.SourceLocation != nil {
+		line = cmd.SourceLocation.StartLine
+		if isBareHeredoc(cmd) {
+			// The script starts on the line after the command.
+			line++
+		}
+	}
+	return parseShellCheckOutput(output, target, line)
+}
+
+// parseShellCheckOutput returns the issues of the JSON output of shellcheck
+// for the script of the RUN command at the given line.
+func parseShellCheckOutput(output []byte, target string, line int) ([]Issue, error) {
+	var comments []shellCheckComment
+	err := json.Unmarshal(output, &comments)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse shellcheck output")
+	}
+	issues := make([]Issue, 0, len(comments))
+	for _, c := range comments {
+		issueLine := line
+		if issueLine > 0 && c.Line > 1 {
+			issueLine += c.Line - 1
+		}
+		issues = append(issues, Issue{
+			Rule:    ShellCheckRule,
+			Target:  target,
+			Line:    issueLine,
+			Message: fmt.Sprintf("SC%d (%s, column %d): %s", c.Code, c.Level, c.Column, c.Message),
+		})
+	}
+	return issues, nil
+}
+
+// runShellFlag returns the value of the --shell flag of a RUN command, if any.
+func runShellFlag(cmd spec.Command) string {
+	for i, arg := range cmd.Args {
+		switch {
+		case !strings.HasPrefix(arg, "--"):
+			return ""
+		case strings.HasPrefix(arg, "--shell="):
+			return strings.TrimPrefix(arg, "--shell=")
+		case arg == "--shell" && i+1 < len(cmd.Args):
+			return cmd.Args[i+1]
+		}
+	}
+	return ""
+}
+
+// shellOf returns the shell executable of the arguments of SHELL, or of the
+// value of RUN --shell: either a preset name, or an exec form list.
+func shellOf(args []string) string {
+	joined := strings.Join(args, " ")
+	var shell []string
+	if json.Unmarshal([]byte(joined), &shell) == nil {
+		if len(shell) == 0 {
+			return ""
+		}
+		return path.Base(shell[0])
+	}
+	if joined == "bash-strict" {
+		return "bash"
+	}
+	return joined
+}
+
+// shebangShell returns the executable of the shebang of a script, as in bash
+// for #!/bin/bash or #!/usr/bin/env bash, if any.
+func shebangShell(script string) string {
+	if !strings.HasPrefix(script, "#!") {
+		return ""
+	}
+	fields := strings.Fields(strings.SplitN(script[2:], "\n", 2)[0])
+	if len(fields) == 0 {
+		return ""
+	}
+	if path.Base(fields[0]) == "env" && len(fields) > 1 {
+		return fields[1]
+	}
+	return path.Base(fields[0])
+}
+
+// shellDialect returns the shellcheck dialect of a shell executable, or empty
+// if shellcheck does not support it.
+func shellDialect(shell string) string {
+	switch shell {
+	case "sh", "ash":
+		return "sh"
+	case "bash", "dash", "ksh":
+		return shell
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,46 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseShellCheckOutput(t *testing.T) {
+	output := []byte(`[{"file":"-","line":1,"endLine":1,"column":6,"endColumn":10,"level":"info","code":2086,"message":"Double quote to prevent globbing and word splitting."},` +
+		`{"file":"-","line":3,"endLine":3,"column":1,"endColumn":5,"level":"warning","code":2164,"message":"Use 'cd ... || exit' in case cd fails."}]`)
+	issues, err := parseShellCheckOutput(output, "+build", 10)
+	NoError(t, err)
+	Equal(t, []Issue{
+		{Rule: "shellcheck", Target: "+build", Line: 10, Message: "SC2086 (info, column 6): Double quote to prevent globbing and word splitting."},
+		{Rule: "shellcheck", Target: "+build", Line: 12, Message: "SC2164 (warning, column 1): Use 'cd ... || exit' in case cd fails."},
+	}, issues)
+	issues, err = parseShellCheckOutput([]byte("[]"), "", 3)
+	NoError(t, err)
+	Equal(t, []Issue{}, issues)
+	_, err = parseShellCheckOutput([]byte("In - line 1"), "", 3)
+	Error(t, err)
+}
+
+func TestShellDialect(t *testing.T) {
+	Equal(t, "bash", shellDialect(shellOf([]string{"bash-strict"})))
+	Equal(t, "sh", shellDialect(shellOf([]string{"sh"})))
+	Equal(t, "sh", shellDialect(shellOf([]string{`["/sbin/ash",`, `"-c"]`})))
+	Equal(t, "", shellDialect(shellOf([]string{`["/bin/zsh",`, `"-c"]`})))
+	Equal(t, "bash", runShellFlag(spec.Command{Name: "RUN", Args: []string{"--no-cache", "--shell=bash", "echo"}}))
+	Equal(t, "bash", runShellFlag(spec.Command{Name: "RUN", Args: []string{"--shell", "bash", "echo"}}))
+	Equal(t, "", runShellFlag(spec.Command{Name: "RUN", Args: []string{"echo", "--shell=bash"}}))
+}
+
+func TestShebangShell(t *testing.T) {
+	Equal(t, "bash", shebangShell("#!/bin/bash\necho hi\n"))
+	Equal(t, "python3", shebangShell("#!/usr/bin/env python3\nprint(1)\n"))
+	Equal(t, "", shebangShell("echo hi\n"))
+	Equal(t, "#!/bin/sh\nset -e\n", runScript(spec.Command{
+		Name: "RUN", Args: []string{"--no-cache", "<<EOF"},
+		Heredocs: []spec.Heredoc{{Delimiter: "EOF", Content: "#!/bin/sh\nset -e\n", Expand: true}}}))
+	Equal(t, "python3 <<'EOF'\nprint(1)\nEOF", runScript(spec.Command{
+		Name: "RUN", Args: []string{"python3", "<<'EOF'"},
+		Heredocs: []spec.Heredoc{{Delimiter: "EOF", Content: "print(1)\n"}}}))
+}
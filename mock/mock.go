@@ -0,0 +1,156 @@
+// Package mock implements the mock mode of earthly (earthly --mock), which
+// stubs the external dependencies of a build, so that the targets of build
+// libraries can be tested quickly and deterministically, without credentials
+// or network access to their remote imports. Remote imports are replaced by
+// local stubs, secrets by fake values, and pushes are recorded rather than
+// performed.
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the mock file.
+type Config struct {
+	// Imports maps remote repositories to the local directories of their
+	// stubs, relative to the mock file.
+	Imports map[string]string `yaml:"imports"`
+	// Secrets are the values of the secrets. Secrets which are not listed
+	// get a fake value, as returned by FakeSecret.
+	Secrets map[string]string `yaml:"secrets"`
+
+	// Dir is the directory of the mock file.
+	Dir string `yaml:"-"`
+}
+
+// Load reads the mock file at the given path.
+func Load(path string) (*Config, error) {
+	dt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read mock file %s", path)
+	}
+	cfg := &Config{}
+	err = yaml.Unmarshal(dt, cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse mock file %s", path)
+	}
+	cfg.Dir = filepath.Dir(path)
+	return cfg, nil
+}
+
+// FakeSecret returns the deterministic value handed out for secrets which
+// the mock file does not list.
+func FakeSecret(name string) []byte {
+	return []byte(fmt.Sprintf("mock-secret-%s", name))
+}
+
+// Secret returns the value of the secret, and records that it was requested.
+func (cfg *Config) Secret(rec *Recorder, name string) []byte {
+	rec.secret(name)
+	if v, ok := cfg.Secrets[name]; ok {
+		return []byte(v)
+	}
+	return FakeSecret(name)
+}
+
+// Record is the set of side effects which a build would have performed.
+type Record struct {
+	// Images are the images which would have been pushed, sorted.
+	Images []Push `json:"images"`
+	// Commands are the RUN --push commands which would have been executed, in
+	// the order of the targets.
+	Commands []Push `json:"commands"`
+	// Secrets are the names of the secrets which were requested, sorted.
+	Secrets []string `json:"secrets"`
+}
+
+// Push is an image push or a RUN --push command of a target.
+type Push struct {
+	Target string `json:"target"`
+	Value  string `json:"value"`
+}
+
+// Recorder records the side effects of a build.
+type Recorder struct {
+	mu      sync.Mutex
+	images  []Push
+	cmds    []Push
+	secrets map[string]bool
+}
+
+// NewRecorder returns an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{secrets: make(map[string]bool)}
+}
+
+// PushImage records that the target would have pushed the image.
+func (r *Recorder) PushImage(target, tag string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.images {
+		if p.Value == tag {
+			return
+		}
+	}
+	r.images = append(r.images, Push{Target: target, Value: tag})
+}
+
+// RunPush records that the target would have executed the RUN --push command.
+func (r *Recorder) RunPush(target, command string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cmds = append(r.cmds, Push{Target: target, Value: command})
+}
+
+func (r *Recorder) secret(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets[name] = true
+}
+
+// Record returns what has been recorded so far.
+func (r *Recorder) Record() Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := Record{
+		Images:   append([]Push{}, r.images...),
+		Commands: append([]Push{}, r.cmds...),
+		Secrets:  []string{},
+	}
+	sort.Slice(rec.Images, func(i, j int) bool { return rec.Images[i].Value < rec.Images[j].Value })
+	for name := range r.secrets {
+		rec.Secrets = append(rec.Secrets, name)
+	}
+	sort.Strings(rec.Secrets)
+	return rec
+}
+
+// WriteJSON writes the record as indented JSON, so that it can be compared
+// against an expected record (a golden file) by tests.
+func (rec Record) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rec)
+}
+
+// Print writes a human readable summary of the record.
+func (rec Record) Print(w io.Writer) {
+	for _, p := range rec.Images {
+		fmt.Fprintf(w, "Mock: would have pushed %s (%s)\n", p.Value, p.Target)
+	}
+	for _, p := range rec.Commands {
+		fmt.Fprintf(w, "Mock: would have executed %s (%s)\n", p.Value, p.Target)
+	}
+	for _, name := range rec.Secrets {
+		fmt.Fprintf(w, "Mock: stubbed secret %s\n", name)
+	}
+}
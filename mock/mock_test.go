@@ -0,0 +1,77 @@
+package mock
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mock-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "earthly.mock.yml")
+	NoError(t, ioutil.WriteFile(p, []byte("imports:\n  github.com/org/lib: ./stubs/lib\nsecrets:\n  NPM_TOKEN: test-token\n"), 0644))
+
+	cfg, err := Load(p)
+	NoError(t, err)
+	Equal(t, map[string]string{"github.com/org/lib": "./stubs/lib"}, cfg.Imports)
+	Equal(t, dir, cfg.Dir)
+
+	rec := NewRecorder()
+	Equal(t, []byte("test-token"), cfg.Secret(rec, "NPM_TOKEN"))
+	Equal(t, []byte("mock-secret-org/DEPLOY_KEY"), cfg.Secret(rec, "org/DEPLOY_KEY"))
+
+	_, err = Load(filepath.Join(dir, "missing.yml"))
+	Error(t, err)
+}
+
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder()
+	Equal(t, Record{Images: []Push{}, Commands: []Push{}, Secrets: []string{}}, rec.Record())
+
+	rec.PushImage("./app+docker", "registry.example.com/app:latest")
+	rec.PushImage("./app+docker", "registry.example.com/app:1.0")
+	rec.PushImage("./app+docker", "registry.example.com/app:latest")
+	rec.RunPush("./app+deploy", "kubectl apply -f app.yaml")
+	cfg := &Config{}
+	cfg.Secret(rec, "TOKEN")
+	cfg.Secret(rec, "TOKEN")
+
+	var buf bytes.Buffer
+	NoError(t, rec.Record().WriteJSON(&buf))
+	Equal(t, `{
+  "images": [
+    {
+      "target": "./app+docker",
+      "value": "registry.example.com/app:1.0"
+    },
+    {
+      "target": "./app+docker",
+      "value": "registry.example.com/app:latest"
+    }
+  ],
+  "commands": [
+    {
+      "target": "./app+deploy",
+      "value": "kubectl apply -f app.yaml"
+    }
+  ],
+  "secrets": [
+    "TOKEN"
+  ]
+}
+`, buf.String())
+
+	buf.Reset()
+	rec.Record().Print(&buf)
+	Equal(t, `Mock: would have pushed registry.example.com/app:1.0 (./app+docker)
+Mock: would have pushed registry.example.com/app:latest (./app+docker)
+Mock: would have executed kubectl apply -f app.yaml (./app+deploy)
+Mock: stubbed secret TOKEN
+`, buf.String())
+}
@@ -0,0 +1,131 @@
+// Package monorepo discovers the services of a repository which contains many
+// Earthfiles, and detects which of them changed, for earthly multi run.
+package monorepo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Service is a directory containing an Earthfile.
+type Service struct {
+	// Dir is the directory of the Earthfile, relative to the current
+	// directory, in slash form.
+	Dir string
+}
+
+// Discover returns the services whose Earthfile matches the given glob
+// pattern, such as ./services/*/Earthfile, sorted by directory.
+func Discover(pattern string) ([]Service, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern %s", pattern)
+	}
+	var services []Service
+	for _, m := range matches {
+		if filepath.Base(m) != "Earthfile" {
+			continue
+		}
+		services = append(services, Service{Dir: path.Clean(filepath.ToSlash(filepath.Dir(m)))})
+	}
+	if len(services) == 0 {
+		return nil, errors.Errorf("no Earthfiles match %s", pattern)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Dir < services[j].Dir })
+	return services, nil
+}
+
+// ChangedFiles returns the files, relative to the current directory, which
+// differ from the given git ref, including uncommitted and untracked files.
+func ChangedFiles(ctx context.Context, since string) ([]string, error) {
+	diff, err := git(ctx, "diff", "--name-only", "--relative", since)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git(ctx, "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range strings.Split(diff+"\n"+untracked, "\n") {
+		if f = strings.TrimSpace(f); f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// Changed returns whether each of the services is affected by the changed
+// files: either a file within its directory changed, or one of the shared
+// paths did (in which case every service is affected).
+func Changed(services []Service, files []string, shared []string) map[string]bool {
+	changed := make(map[string]bool)
+	for _, f := range files {
+		for _, s := range shared {
+			if within(f, path.Clean(filepath.ToSlash(s))) {
+				for _, svc := range services {
+					changed[svc.Dir] = true
+				}
+				return changed
+			}
+		}
+		for _, svc := range services {
+			if within(f, svc.Dir) {
+				changed[svc.Dir] = true
+			}
+		}
+	}
+	return changed
+}
+
+func within(file, dir string) bool {
+	return dir == "." || file == dir || strings.HasPrefix(file, dir+"/")
+}
+
+// Result is the outcome of running a target of a service.
+type Result struct {
+	Service  Service
+	Target   string
+	Skipped  bool
+	Err      error
+	Duration time.Duration
+}
+
+// PrintMatrix writes a table of the results, one service per line.
+func PrintMatrix(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tTARGET\tRESULT\tDURATION")
+	for _, r := range results {
+		status, duration := "ok", r.Duration.Round(100*time.Millisecond).String()
+		switch {
+		case r.Skipped:
+			status, duration = "skipped (unchanged)", "-"
+		case r.Err != nil:
+			status = "FAILED"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Service.Dir, r.Target, status, duration)
+	}
+	return tw.Flush()
+}
+
+func git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
@@ -0,0 +1,55 @@
+package monorepo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestDiscover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monorepo-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, svc := range []string{"web", "api"} {
+		NoError(t, os.MkdirAll(filepath.Join(dir, "services", svc), 0755))
+		NoError(t, ioutil.WriteFile(filepath.Join(dir, "services", svc, "Earthfile"), nil, 0644))
+	}
+	NoError(t, os.MkdirAll(filepath.Join(dir, "services", "docs"), 0755))
+
+	services, err := Discover(filepath.Join(dir, "services", "*", "Earthfile"))
+	NoError(t, err)
+	Equal(t, 2, len(services))
+	Equal(t, filepath.ToSlash(filepath.Join(dir, "services", "api")), services[0].Dir)
+
+	_, err = Discover(filepath.Join(dir, "other", "*", "Earthfile"))
+	Error(t, err)
+}
+
+func TestChanged(t *testing.T) {
+	services := []Service{{Dir: "services/api"}, {Dir: "services/apigw"}, {Dir: "services/web"}}
+	Equal(t, map[string]bool{"services/api": true},
+		Changed(services, []string{"services/api/main.go", "README.md"}, []string{"libs"}))
+	Equal(t, map[string]bool{"services/api": true, "services/apigw": true, "services/web": true},
+		Changed(services, []string{"libs/log/log.go"}, []string{"./libs"}))
+	Equal(t, map[string]bool{}, Changed(services, nil, nil))
+}
+
+func TestPrintMatrix(t *testing.T) {
+	var buf bytes.Buffer
+	NoError(t, PrintMatrix(&buf, []Result{
+		{Service: Service{Dir: "services/api"}, Target: "+test", Duration: 1500 * time.Millisecond},
+		{Service: Service{Dir: "services/web"}, Target: "+test", Err: errors.New("failed")},
+		{Service: Service{Dir: "services/docs"}, Target: "+test", Skipped: true},
+	}))
+	Equal(t, `SERVICE        TARGET  RESULT               DURATION
+services/api   +test   ok                   1.5s
+services/web   +test   FAILED               0s
+services/docs  +test   skipped (unchanged)  -
+`, buf.String())
+}
@@ -0,0 +1,116 @@
+// Package preview manages ephemeral preview environments, as run by earthly
+// preview: the images of the preview target of a pull request are pushed, and
+// its manifest template is rendered and applied to a dedicated namespace of a
+// Kubernetes cluster, which is deleted once the pull request is closed.
+package preview
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultTarget is the name of the target which declares the preview
+	// deployment. Its SAVE IMAGE --push commands are expected to tag the
+	// images with the ID build arg.
+	DefaultTarget = "preview"
+	// DefaultManifest is the path, relative to the directory of the Earthfile,
+	// of the manifest template of the preview deployment.
+	DefaultManifest = "preview.yaml.tmpl"
+	// IDArg is the build arg the preview target is built with, set to the ID
+	// of the preview environment (as in pr-123).
+	IDArg = "PREVIEW_ID"
+	// DefaultNamespacePrefix is the prefix of the namespaces of the preview
+	// environments, unless configured otherwise.
+	DefaultNamespacePrefix = "preview-"
+)
+
+// Opt are the settings of the cluster the preview environments are deployed
+// to.
+type Opt struct {
+	// KubeContext is the kubectl context of the cluster. The current context
+	// is used if it is empty.
+	KubeContext string
+	// NamespacePrefix is the prefix of the namespaces of the environments.
+	NamespacePrefix string
+}
+
+// Data is what the manifest template is rendered with.
+type Data struct {
+	// PR is the number of the pull request.
+	PR int
+	// ID identifies the environment, as in pr-123. It is the value of the
+	// PREVIEW_ID build arg the preview target is built with.
+	ID string
+	// Namespace is the namespace the environment is deployed to.
+	Namespace string
+}
+
+// ID returns the ID of the environment of the pull request.
+func ID(pr int) string {
+	return fmt.Sprintf("pr-%d", pr)
+}
+
+// Namespace returns the namespace of the environment of the pull request.
+func (opt Opt) Namespace(pr int) string {
+	prefix := opt.NamespacePrefix
+	if prefix == "" {
+		prefix = DefaultNamespacePrefix
+	}
+	return prefix + ID(pr)
+}
+
+// DataOf returns the data the manifest template of the environment of the
+// pull request is rendered with.
+func (opt Opt) DataOf(pr int) Data {
+	return Data{PR: pr, ID: ID(pr), Namespace: opt.Namespace(pr)}
+}
+
+// Render renders the manifest template, which is a Go template of Kubernetes
+// manifests, and prepends the namespace of the environment to it.
+func Render(name string, tmpl []byte, data Data) ([]byte, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(string(tmpl))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse manifest template %s", name)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: %s\n  labels:\n    app.kubernetes.io/managed-by: earthly-preview\n---\n", data.Namespace)
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "render manifest template %s", name)
+	}
+	return buf.Bytes(), nil
+}
+
+// Apply applies the rendered manifests to the namespace of the environment of
+// the pull request, creating it if need be.
+func Apply(ctx context.Context, opt Opt, pr int, manifests []byte) error {
+	return opt.kubectl(ctx, manifests, "apply", "--namespace", opt.Namespace(pr), "--filename", "-")
+}
+
+// Destroy deletes the namespace of the environment of the pull request, along
+// with everything deployed to it. It is not an error if there is none.
+func Destroy(ctx context.Context, opt Opt, pr int) error {
+	return opt.kubectl(ctx, nil, "delete", "namespace", opt.Namespace(pr), "--ignore-not-found", "--wait=false")
+}
+
+func (opt Opt) kubectl(ctx context.Context, stdin []byte, args ...string) error {
+	if opt.KubeContext != "" {
+		args = append([]string{"--context", opt.KubeContext}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "kubectl %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package preview
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	opt := Opt{NamespacePrefix: "review-"}
+	data := opt.DataOf(123)
+	Equal(t, Data{PR: 123, ID: "pr-123", Namespace: "review-pr-123"}, data)
+	Equal(t, "preview-pr-7", Opt{}.Namespace(7))
+
+	tmpl := "kind: Deployment\nspec:\n  image: registry.example.com/app:{{ .ID }}\n"
+	dt, err := Render("preview.yaml.tmpl", []byte(tmpl), data)
+	NoError(t, err)
+	Equal(t, "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: review-pr-123\n  labels:\n    app.kubernetes.io/managed-by: earthly-preview\n---\n"+
+		"kind: Deployment\nspec:\n  image: registry.example.com/app:pr-123\n", string(dt))
+
+	_, err = Render("preview.yaml.tmpl", []byte("image: {{ .Tag }}"), data)
+	Error(t, err)
+	_, err = Render("preview.yaml.tmpl", []byte("image: {{ .ID"), data)
+	Error(t, err)
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := `{"action":"closed"}`
+	NoError(t, VerifySignature([]byte("s3cret"), []byte(body), sign("s3cret", body)))
+	Error(t, VerifySignature([]byte("s3cret"), []byte(body), sign("other", body)))
+	Error(t, VerifySignature([]byte("s3cret"), []byte(body), ""))
+	Error(t, VerifySignature([]byte("s3cret"), []byte(body), "sha256=zz"))
+	Error(t, VerifySignature(nil, []byte(body), sign("", body)))
+}
+
+func TestWebhookHandler(t *testing.T) {
+	var destroyed []int
+	h := &WebhookHandler{
+		Secret: []byte("s3cret"),
+		Destroy: func(ctx context.Context, pr int) error {
+			destroyed = append(destroyed, pr)
+			return nil
+		},
+		Logf: func(string, ...interface{}) {},
+	}
+	deliver := func(event, body, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature-256", signature)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	body := `{"action":"synchronize","number":12}`
+	Equal(t, http.StatusNoContent, deliver("pull_request", body, sign("s3cret", body)))
+	body = `{"zen":"Keep it simple."}`
+	Equal(t, http.StatusNoContent, deliver("ping", body, sign("s3cret", body)))
+	body = `{"action":"closed","number":12}`
+	Equal(t, http.StatusUnauthorized, deliver("pull_request", body, sign("other", body)))
+	Empty(t, destroyed)
+	Equal(t, http.StatusNoContent, deliver("pull_request", body, sign("s3cret", body)))
+	Equal(t, []int{12}, destroyed)
+}
@@ -0,0 +1,98 @@
+package preview
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxPayloadSize is the size above which webhook payloads are rejected.
+const maxPayloadSize = 25 << 20
+
+// WebhookHandler listens for the pull_request webhooks of GitHub, and destroys
+// the preview environment of pull requests as they are closed (whether merged
+// or not).
+type WebhookHandler struct {
+	// Secret is the secret of the webhook, which its payloads are signed with.
+	Secret []byte
+	// Destroy destroys the environment of the pull request.
+	Destroy func(ctx context.Context, pr int) error
+	// Logf logs the handled events.
+	Logf func(format string, args ...interface{})
+}
+
+type pullRequestEvent struct {
+	Action string `json:"action"`
+	Number int    `json:"number"`
+}
+
+// ServeHTTP handles a webhook delivery.
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, "cannot read payload", http.StatusBadRequest)
+		return
+	}
+	err = VerifySignature(h.Secret, body, r.Header.Get("X-Hub-Signature-256"))
+	if err != nil {
+		h.Logf("Rejected webhook delivery %s: %v\n", r.Header.Get("X-GitHub-Delivery"), err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if r.Header.Get("X-GitHub-Event") != "pull_request" {
+		// Such as the ping sent when the webhook is created.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var ev pullRequestEvent
+	err = json.Unmarshal(body, &ev)
+	if err != nil || ev.Number <= 0 {
+		http.Error(w, "invalid pull_request payload", http.StatusBadRequest)
+		return
+	}
+	if ev.Action != "closed" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	err = h.Destroy(r.Context(), ev.Number)
+	if err != nil {
+		h.Logf("Failed to destroy the preview environment of pull request #%d: %v\n", ev.Number, err)
+		http.Error(w, "failed to destroy the preview environment", http.StatusInternalServerError)
+		return
+	}
+	h.Logf("Destroyed the preview environment of pull request #%d\n", ev.Number)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifySignature verifies the X-Hub-Signature-256 header of a webhook
+// delivery, which is the HMAC of its payload, keyed with the secret of the
+// webhook.
+func VerifySignature(secret, body []byte, header string) error {
+	if len(secret) == 0 {
+		return errors.New("no webhook secret configured")
+	}
+	if !strings.HasPrefix(header, "sha256=") {
+		return errors.New("missing sha256 signature")
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil {
+		return errors.Wrap(err, "decode signature")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// Package privileged keeps track of the commands of a build which need to run
+// privileged, or to mount paths of the host of buildkitd, and requires each of
+// them to be approved, either via a signed allowlist or interactively, as per
+// earthly --approve-privileged.
+package privileged
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Request is a command which needs approval.
+type Request struct {
+	// Target is the canonical name of the target of the command.
+	Target string
+	// Command is the command, as in RUN --privileged ./test.sh.
+	Command string
+	// Reason is why the command needs approval, as in --privileged.
+	Reason string
+}
+
+// String returns the request as shown to the user.
+func (r Request) String() string {
+	return fmt.Sprintf("%s: %s (%s)", r.Target, r.Command, r.Reason)
+}
+
+type entry struct {
+	target  string
+	command string
+}
+
+// Allowlist lists the targets, and optionally the commands of these targets,
+// which are approved to run privileged.
+type Allowlist struct {
+	entries []entry
+}
+
+// ParseAllowlist parses an allowlist. Each line is a target, which may
+// contain * wildcards as in github.com/org/*+*, optionally followed by a
+// single command of the target. Lines starting with # are comments.
+func ParseAllowlist(dt []byte) (*Allowlist, error) {
+	a := &Allowlist{}
+	s := bufio.NewScanner(bytes.NewReader(dt))
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e := entry{target: line}
+		if i := strings.IndexAny(line, " \t"); i != -1 {
+			e.target, e.command = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		_, err := path.Match(e.target, "")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid target pattern %s on line %d", e.target, lineNum)
+		}
+		a.entries = append(a.entries, e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "read allowlist")
+	}
+	return a, nil
+}
+
+// LoadAllowlist reads the allowlist at the path, and verifies its signature,
+// which is the base64 encoded ed25519 signature of the file, stored next to
+// it with the .sig extension.
+func LoadAllowlist(p string, publicKey ed25519.PublicKey) (*Allowlist, error) {
+	dt, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read privileged allowlist %s", p)
+	}
+	sig, err := ioutil.ReadFile(p + ".sig")
+	if err != nil {
+		return nil, errors.Wrapf(err, "read signature of privileged allowlist %s", p)
+	}
+	err = VerifySignature(dt, sig, publicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "privileged allowlist %s", p)
+	}
+	return ParseAllowlist(dt)
+}
+
+// ParsePublicKey parses a base64 encoded ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	dt, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode privileged allowlist key")
+	}
+	if len(dt) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("invalid privileged allowlist key: expected %d bytes, got %d", ed25519.PublicKeySize, len(dt))
+	}
+	return ed25519.PublicKey(dt), nil
+}
+
+// VerifySignature verifies the base64 encoded ed25519 signature of the
+// allowlist.
+func VerifySignature(dt, sig []byte, publicKey ed25519.PublicKey) error {
+	sigDt, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return errors.Wrap(err, "decode signature")
+	}
+	if !ed25519.Verify(publicKey, dt, sigDt) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// Allows returns whether the request is approved by the allowlist. It is safe
+// to call Allows on a nil Allowlist, which approves nothing.
+func (a *Allowlist) Allows(r Request) bool {
+	if a == nil {
+		return false
+	}
+	for _, e := range a.entries {
+		ok, _ := path.Match(e.target, r.Target)
+		if ok && (e.command == "" || e.command == r.Command) {
+			return true
+		}
+	}
+	return false
+}
+
+// PromptFun asks the user whether the request should be approved.
+type PromptFun func(r Request) (bool, error)
+
+// Approver approves the requests of a build.
+type Approver struct {
+	mu        sync.Mutex
+	allowlist *Allowlist
+	prompt    PromptFun
+	approved  []Request
+	seen      map[Request]bool
+}
+
+// NewApprover returns an approver which approves the requests allowed by the
+// allowlist, and prompts for the others. The allowlist and the prompt fun may
+// be nil, in which case the requests are denied, unless allowed otherwise.
+func NewApprover(allowlist *Allowlist, prompt PromptFun) *Approver {
+	return &Approver{
+		allowlist: allowlist,
+		prompt:    prompt,
+		seen:      make(map[Request]bool),
+	}
+}
+
+// Approve returns an error if the request is not approved. Approving via the
+// prompt is remembered for the remainder of the session. It is safe to call
+// Approve on a nil Approver, in which case everything is approved.
+func (a *Approver) Approve(r Request) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.seen[r] {
+		return nil
+	}
+	ok := a.allowlist.Allows(r)
+	if !ok && a.prompt != nil {
+		var err error
+		ok, err = a.prompt(r)
+		if err != nil {
+			return err
+		}
+	}
+	if !ok {
+		return errors.Errorf(
+			"%s requires %s, which has not been approved; approve it interactively, or add it to the privileged allowlist",
+			r.Command, r.Reason)
+	}
+	a.seen[r] = true
+	a.approved = append(a.approved, r)
+	return nil
+}
+
+// Approved returns the requests approved so far, in order.
+func (a *Approver) Approved() []Request {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Request{}, a.approved...)
+}
@@ -0,0 +1,73 @@
+package privileged
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+var (
+	testRun = Request{Target: "github.com/org/repo:main+test", Command: "RUN --privileged ./test.sh", Reason: "privileged mode"}
+	testDev = Request{Target: "github.com/org/repo:main+dev", Command: "RUN ./dev.sh", Reason: "a host mount of /dev"}
+)
+
+func TestAllowlist(t *testing.T) {
+	a, err := ParseAllowlist([]byte(`
+# Integration tests need to mount cgroups.
+github.com/org/repo:*+test
+github.com/org/*+dev RUN ./other.sh
+`))
+	NoError(t, err)
+	True(t, a.Allows(testRun))
+	False(t, a.Allows(testDev))
+	False(t, (*Allowlist)(nil).Allows(testRun))
+
+	_, err = ParseAllowlist([]byte("github.com/org/[repo+test"))
+	Error(t, err)
+}
+
+func TestApprover(t *testing.T) {
+	a, err := ParseAllowlist([]byte("github.com/org/repo:*+test"))
+	NoError(t, err)
+	prompts := 0
+	approver := NewApprover(a, func(r Request) (bool, error) {
+		prompts++
+		return r == testDev, nil
+	})
+	NoError(t, approver.Approve(testRun))
+	NoError(t, approver.Approve(testDev))
+	NoError(t, approver.Approve(testDev))
+	Equal(t, 1, prompts)
+	Error(t, approver.Approve(Request{Target: "+other", Command: "RUN --privileged ./x.sh", Reason: "privileged mode"}))
+	Equal(t, []Request{testRun, testDev}, approver.Approved())
+
+	NoError(t, (*Approver)(nil).Approve(testRun))
+	Error(t, NewApprover(nil, nil).Approve(testRun))
+}
+
+func TestLoadAllowlist(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	NoError(t, err)
+	dt := []byte("github.com/org/repo:*+test\n")
+	p := filepath.Join(t.TempDir(), "privileged-allowlist")
+	NoError(t, ioutil.WriteFile(p, dt, 0644))
+
+	_, err = LoadAllowlist(p, publicKey)
+	Error(t, err)
+
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, dt))
+	NoError(t, ioutil.WriteFile(p+".sig", []byte(sig+"\n"), 0644))
+	key, err := ParsePublicKey(base64.StdEncoding.EncodeToString(publicKey))
+	NoError(t, err)
+	a, err := LoadAllowlist(p, key)
+	NoError(t, err)
+	True(t, a.Allows(testRun))
+
+	NoError(t, ioutil.WriteFile(p, append(dt, []byte("*\n")...), 0644))
+	_, err = LoadAllowlist(p, key)
+	Error(t, err)
+}
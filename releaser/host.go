@@ -0,0 +1,245 @@
+package releaser
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/earthly/earthly/util/gitutil"
+	"github.com/pkg/errors"
+)
+
+// Host is a git hosting service on which releases are created.
+type Host interface {
+	// CreateRelease creates the release of the given (already pushed) tag,
+	// returning its ID and URL.
+	CreateRelease(ctx context.Context, tag, name, notes string) (id, htmlURL string, err error)
+	// UploadAsset attaches the file to the release with the given ID.
+	UploadAsset(ctx context.Context, id, tag, path string) error
+}
+
+// NewHost returns the host of the repository with the given remote URL.
+// provider is either github, gitlab, or empty for detecting it out of the
+// URL.
+func NewHost(remoteURL, provider, token string) (Host, error) {
+	gitURL, err := gitutil.ParseGitRemoteURL(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(gitURL, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errors.Errorf("unable to determine the repository of %s", gitURL)
+	}
+	host, project := parts[0], parts[1]
+	if provider == "" {
+		switch {
+		case strings.Contains(host, "github"):
+			provider = "github"
+		case strings.Contains(host, "gitlab"):
+			provider = "gitlab"
+		default:
+			return nil, errors.Errorf("unable to detect whether %s is a GitHub or a GitLab host; use --provider", host)
+		}
+	}
+	if token == "" {
+		return nil, errors.Errorf("a %s token is needed for creating the release", provider)
+	}
+	switch provider {
+	case "github":
+		api, uploads := "https://api.github.com", "https://uploads.github.com"
+		if host != "github.com" {
+			// GitHub Enterprise.
+			api, uploads = "https://"+host+"/api/v3", "https://"+host+"/api/uploads"
+		}
+		return &GitHub{API: api, Uploads: uploads, Repo: project, Token: token}, nil
+	case "gitlab":
+		return &GitLab{API: "https://" + host + "/api/v4", Project: project, Token: token}, nil
+	default:
+		return nil, errors.Errorf("unsupported provider %s", provider)
+	}
+}
+
+// GitHub creates releases on GitHub.
+type GitHub struct {
+	// API is the base URL of the GitHub API.
+	API string
+	// Uploads is the base URL for uploading release assets.
+	Uploads string
+	// Repo is the repository, as in owner/name.
+	Repo  string
+	Token string
+}
+
+// CreateRelease implements Host.
+func (g *GitHub) CreateRelease(ctx context.Context, tag, name, notes string) (string, string, error) {
+	reqDt, err := json.Marshal(map[string]string{
+		"tag_name": tag,
+		"name":     name,
+		"body":     notes,
+	})
+	if err != nil {
+		return "", "", errors.Wrap(err, "marshal release")
+	}
+	var release struct {
+		ID      int64  `json:"id"`
+		HTMLURL string `json:"html_url"`
+	}
+	err = g.do(ctx, http.MethodPost, fmt.Sprintf("%s/repos/%s/releases", g.API, g.Repo), "application/json", bytes.NewReader(reqDt), &release)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "create release %s", tag)
+	}
+	return fmt.Sprint(release.ID), release.HTMLURL, nil
+}
+
+// UploadAsset implements Host.
+func (g *GitHub) UploadAsset(ctx context.Context, id, tag, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", path)
+	}
+	defer f.Close()
+	uploadURL := fmt.Sprintf("%s/repos/%s/releases/%s/assets?name=%s", g.Uploads, g.Repo, id, url.QueryEscape(filepath.Base(path)))
+	err = g.do(ctx, http.MethodPost, uploadURL, "application/octet-stream", f, nil)
+	return errors.Wrapf(err, "upload %s", path)
+}
+
+func (g *GitHub) do(ctx context.Context, method, u, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	if f, ok := body.(*os.File); ok {
+		fi, err := f.Stat()
+		if err != nil {
+			return errors.Wrapf(err, "stat %s", f.Name())
+		}
+		req.ContentLength = fi.Size()
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", "token "+g.Token)
+	return doJSON(req, out)
+}
+
+// GitLab creates releases on GitLab.
+type GitLab struct {
+	// API is the base URL of the GitLab API, as in https://gitlab.com/api/v4.
+	API string
+	// Project is the path of the project, as in group/name.
+	Project string
+	Token   string
+}
+
+// CreateRelease implements Host. GitLab identifies releases by their tag,
+// which is returned as the ID.
+func (g *GitLab) CreateRelease(ctx context.Context, tag, name, notes string) (string, string, error) {
+	reqDt, err := json.Marshal(map[string]string{
+		"tag_name":    tag,
+		"name":        name,
+		"description": notes,
+	})
+	if err != nil {
+		return "", "", errors.Wrap(err, "marshal release")
+	}
+	var release struct {
+		Links struct {
+			Self string `json:"self"`
+		} `json:"_links"`
+	}
+	err = g.do(ctx, http.MethodPost, g.projectURL("releases"), "application/json", bytes.NewReader(reqDt), &release)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "create release %s", tag)
+	}
+	return tag, release.Links.Self, nil
+}
+
+// UploadAsset implements Host. The file is uploaded to the project, and then
+// linked from the release.
+func (g *GitLab) UploadAsset(ctx context.Context, id, tag, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", path)
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, err := w.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return errors.Wrap(err, "create form file")
+	}
+	_, err = io.Copy(part, f)
+	if err != nil {
+		return errors.Wrapf(err, "read %s", path)
+	}
+	err = w.Close()
+	if err != nil {
+		return errors.Wrap(err, "close multipart writer")
+	}
+	var upload struct {
+		FullPath string `json:"full_path"`
+		URL      string `json:"url"`
+	}
+	err = g.do(ctx, http.MethodPost, g.projectURL("uploads"), w.FormDataContentType(), &buf, &upload)
+	if err != nil {
+		return errors.Wrapf(err, "upload %s", path)
+	}
+	linkURL := upload.FullPath
+	if linkURL == "" {
+		linkURL = "/" + g.Project + upload.URL
+	}
+	u, err := url.Parse(g.API)
+	if err != nil {
+		return errors.Wrapf(err, "parse %s", g.API)
+	}
+	linkDt, err := json.Marshal(map[string]string{
+		"name": filepath.Base(path),
+		"url":  u.Scheme + "://" + u.Host + linkURL,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal asset link")
+	}
+	err = g.do(ctx, http.MethodPost, g.projectURL("releases/"+url.PathEscape(id)+"/assets/links"), "application/json", bytes.NewReader(linkDt), nil)
+	return errors.Wrapf(err, "link %s to release %s", path, tag)
+}
+
+func (g *GitLab) projectURL(p string) string {
+	return fmt.Sprintf("%s/projects/%s/%s", g.API, url.PathEscape(g.Project), p)
+}
+
+func (g *GitLab) do(ctx context.Context, method, u, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("PRIVATE-TOKEN", g.Token)
+	return doJSON(req, out)
+}
+
+func doJSON(req *http.Request, out interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respDt, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "read response")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(respDt)))
+	}
+	if out == nil {
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(respDt, out), "parse response")
+}
@@ -0,0 +1,294 @@
+package releaser
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/earthly/earthly/conslogging"
+	"github.com/earthly/earthly/util/gitutil"
+	"github.com/earthly/earthly/util/semverutil"
+	"github.com/pkg/errors"
+)
+
+// Opt contains the settings of a release.
+type Opt struct {
+	// Dir is a directory within the repository being released.
+	Dir string
+	// Version overrides the version computed out of the conventional commits
+	// since the previous release.
+	Version string
+	// Remote is the git remote the tag is pushed to.
+	Remote string
+	// Assets are glob patterns of the files attached to the release, relative
+	// to Dir. They are expanded after the build, so they may match artifacts
+	// which it outputs.
+	Assets []string
+	// Host is where the release is created. Nil skips creating the release
+	// and attaching the assets.
+	Host Host
+	// Build builds and pushes the release. The changelog lists the changes
+	// since the previous release, in Markdown.
+	Build func(ctx context.Context, version, tag, changelog string) error
+	// DryRun only prints what would be released.
+	DryRun bool
+	// Restart discards the progress of a previous, failed attempt.
+	Restart bool
+}
+
+// Run performs the release, resuming a previous attempt of releasing the same
+// commit, if any.
+func Run(ctx context.Context, console conslogging.ConsoleLogger, opt Opt) error {
+	g := &git{dir: opt.Dir}
+	gitDir, err := g.output(ctx, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return err
+	}
+	head, err := g.output(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	state, err := LoadState(filepath.Join(gitDir, "earthly-release.json"))
+	if err != nil {
+		return err
+	}
+	resuming := !opt.Restart && state.Commit == head && state.Version != "" &&
+		(opt.Version == "" || strings.TrimPrefix(opt.Version, "v") == state.Version)
+	if resuming {
+		console.Printf("Resuming the release of %s\n", state.Tag)
+	} else {
+		status, err := g.output(ctx, "status", "--porcelain")
+		if err != nil {
+			return err
+		}
+		if status != "" {
+			return errors.New("the working tree has uncommitted changes")
+		}
+		prevTag, prev, err := gitutil.LatestVersionTag(ctx, opt.Dir)
+		if err != nil {
+			return err
+		}
+		version, err := nextVersion(ctx, opt, prevTag, prev)
+		if err != nil {
+			return err
+		}
+		tag := version.String()
+		if prevTag == "" || strings.HasPrefix(prevTag, "v") {
+			tag = "v" + tag
+		}
+		if opt.DryRun {
+			notes, err := releaseNotes(ctx, opt.Dir, prevTag, head)
+			if err != nil {
+				return err
+			}
+			console.Printf("Would release %s (previous release: %s)\n\n%s", tag, orNone(prevTag), notes)
+			return nil
+		}
+		err = state.Reset(version.String(), tag, head, prevTag)
+		if err != nil {
+			return err
+		}
+	}
+	if opt.DryRun {
+		console.Printf("Would resume the release of %s; completed steps: %s\n", state.Tag, strings.Join(doneSteps(state), ", "))
+		return nil
+	}
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{StepTag, func() error { return g.tag(ctx, state.Tag, head) }},
+		{StepBuild, func() error {
+			changelog, err := releaseNotes(ctx, opt.Dir, state.PreviousTag, state.Commit)
+			if err != nil {
+				return err
+			}
+			return opt.Build(ctx, state.Version, state.Tag, changelog)
+		}},
+		{StepPushTag, func() error {
+			_, err := g.output(ctx, "push", "-q", opt.Remote, "refs/tags/"+state.Tag)
+			return err
+		}},
+	}
+	for _, step := range steps {
+		if state.Done[step.name] {
+			continue
+		}
+		console.Printf("Release %s: %s\n", state.Tag, step.name)
+		err := step.run()
+		if err != nil {
+			return errors.Wrapf(err, "release step %s failed; re-run earthly release to resume", step.name)
+		}
+		err = state.MarkDone(step.name)
+		if err != nil {
+			return err
+		}
+	}
+	if opt.Host != nil {
+		err = publish(ctx, console, opt, state)
+		if err != nil {
+			return err
+		}
+	}
+	console.Printf("Released %s\n", state.Tag)
+	return state.Remove()
+}
+
+func nextVersion(ctx context.Context, opt Opt, prevTag string, prev semverutil.Version) (semverutil.Version, error) {
+	if opt.Version != "" {
+		return semverutil.Parse(opt.Version)
+	}
+	commits, err := gitutil.Log(ctx, opt.Dir, prevTag, "HEAD")
+	if err != nil {
+		return semverutil.Version{}, err
+	}
+	next, ok := gitutil.NextVersion(prev, commits)
+	if !ok {
+		return semverutil.Version{}, errors.Errorf("there are no commits since %s; nothing to release", prevTag)
+	}
+	return next, nil
+}
+
+func publish(ctx context.Context, console conslogging.ConsoleLogger, opt Opt, state *State) error {
+	if !state.Done[StepPublish] {
+		console.Printf("Release %s: %s\n", state.Tag, StepPublish)
+		notes, err := releaseNotes(ctx, opt.Dir, state.PreviousTag, state.Commit)
+		if err != nil {
+			return err
+		}
+		id, htmlURL, err := opt.Host.CreateRelease(ctx, state.Tag, state.Tag, notes)
+		if err != nil {
+			return errors.Wrapf(err, "release step %s failed; re-run earthly release to resume", StepPublish)
+		}
+		state.ReleaseID, state.ReleaseURL = id, htmlURL
+		err = state.MarkDone(StepPublish)
+		if err != nil {
+			return err
+		}
+	}
+	assets, err := expandAssets(opt.Dir, opt.Assets)
+	if err != nil {
+		return err
+	}
+	for _, asset := range assets {
+		step := AssetStep(filepath.Base(asset))
+		if state.Done[step] {
+			continue
+		}
+		console.Printf("Release %s: attaching %s\n", state.Tag, filepath.Base(asset))
+		err := opt.Host.UploadAsset(ctx, state.ReleaseID, state.Tag, asset)
+		if err != nil {
+			return errors.Wrapf(err, "attaching %s failed; re-run earthly release to resume", filepath.Base(asset))
+		}
+		err = state.MarkDone(step)
+		if err != nil {
+			return err
+		}
+	}
+	if state.ReleaseURL != "" {
+		console.Printf("Release %s: %s\n", state.Tag, state.ReleaseURL)
+	}
+	return nil
+}
+
+func expandAssets(dir string, patterns []string) ([]string, error) {
+	var assets []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "expand %s", pattern)
+		}
+		if len(matches) == 0 {
+			return nil, errors.Errorf("no assets match %s", pattern)
+		}
+		for _, m := range matches {
+			name := filepath.Base(m)
+			if seen[name] {
+				return nil, errors.Errorf("more than one asset is named %s", name)
+			}
+			seen[name] = true
+			assets = append(assets, m)
+		}
+	}
+	return assets, nil
+}
+
+// releaseNotes returns the changelog since the previous release.
+func releaseNotes(ctx context.Context, dir, from, to string) (string, error) {
+	commits, err := gitutil.Log(ctx, dir, from, to)
+	if err != nil {
+		return "", err
+	}
+	notes := gitutil.NewChangelog(commits).Markdown()
+	if notes == "" {
+		notes = "No notable changes.\n"
+	}
+	return notes, nil
+}
+
+func doneSteps(state *State) []string {
+	var steps []string
+	for _, step := range []string{StepTag, StepBuild, StepPushTag, StepPublish} {
+		if state.Done[step] {
+			steps = append(steps, step)
+		}
+	}
+	if len(steps) == 0 {
+		return []string{"none"}
+	}
+	return steps
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// RemoteURL returns the URL of the given git remote of the repository.
+func RemoteURL(ctx context.Context, dir, remote string) (string, error) {
+	g := &git{dir: dir}
+	u, err := g.output(ctx, "remote", "get-url", remote)
+	if err != nil {
+		return "", errors.Wrapf(err, "get the URL of the git remote %s", remote)
+	}
+	return u, nil
+}
+
+type git struct {
+	dir string
+}
+
+func (g *git) output(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = g.dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// tag creates the annotated tag of the release, unless it already points to
+// the released commit.
+func (g *git) tag(ctx context.Context, tag, commit string) error {
+	existing, err := g.output(ctx, "rev-parse", "-q", "--verify", "refs/tags/"+tag+"^{commit}")
+	if err == nil {
+		if existing != commit {
+			return errors.Errorf("tag %s already exists and points to %s", tag, existing)
+		}
+		return nil
+	}
+	_, err = g.output(ctx, "tag", "-a", tag, "-m", "Release "+tag, commit)
+	return err
+}
@@ -0,0 +1,90 @@
+package releaser
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "releaser-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "release.json")
+
+	s, err := LoadState(path)
+	NoError(t, err)
+	Equal(t, "", s.Version)
+	NoError(t, s.Reset("1.2.0", "v1.2.0", "abc", "v1.1.0"))
+	NoError(t, s.MarkDone(StepTag))
+
+	s, err = LoadState(path)
+	NoError(t, err)
+	Equal(t, "v1.2.0", s.Tag)
+	Equal(t, "v1.1.0", s.PreviousTag)
+	True(t, s.Done[StepTag])
+	False(t, s.Done[StepBuild])
+
+	NoError(t, s.Remove())
+	_, err = os.Stat(path)
+	True(t, os.IsNotExist(err))
+}
+
+func TestNewHost(t *testing.T) {
+	h, err := NewHost("git@github.com:org/app.git", "", "token")
+	NoError(t, err)
+	Equal(t, &GitHub{API: "https://api.github.com", Uploads: "https://uploads.github.com", Repo: "org/app", Token: "token"}, h)
+
+	h, err = NewHost("https://gitlab.example.com/group/sub/app.git", "", "token")
+	NoError(t, err)
+	Equal(t, &GitLab{API: "https://gitlab.example.com/api/v4", Project: "group/sub/app", Token: "token"}, h)
+
+	_, err = NewHost("https://git.example.com/org/app.git", "", "token")
+	Error(t, err)
+	_, err = NewHost("https://github.com/org/app.git", "", "")
+	Error(t, err)
+}
+
+func TestGitHubRelease(t *testing.T) {
+	var uploaded string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "token secret", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/repos/org/app/releases":
+			var req map[string]string
+			NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			Equal(t, "v1.0.0", req["tag_name"])
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": 42, "html_url": "https://github.com/org/app/releases/v1.0.0"}`))
+		case "/repos/org/app/releases/42/assets":
+			dt, _ := ioutil.ReadAll(r.Body)
+			uploaded = r.URL.Query().Get("name") + ":" + string(dt)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "releaser-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	asset := filepath.Join(dir, "app-linux-amd64")
+	NoError(t, ioutil.WriteFile(asset, []byte("binary"), 0644))
+
+	g := &GitHub{API: srv.URL, Uploads: srv.URL, Repo: "org/app", Token: "secret"}
+	id, u, err := g.CreateRelease(context.Background(), "v1.0.0", "v1.0.0", "notes")
+	NoError(t, err)
+	Equal(t, "42", id)
+	Equal(t, "https://github.com/org/app/releases/v1.0.0", u)
+	NoError(t, g.UploadAsset(context.Background(), id, "v1.0.0", asset))
+	Equal(t, "app-linux-amd64:binary", uploaded)
+}
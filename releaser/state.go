@@ -0,0 +1,118 @@
+// Package releaser implements the steps of earthly release: tagging, creating
+// the GitHub or GitLab release and attaching its artifacts, keeping track of
+// the completed steps so that a failed release can be resumed.
+package releaser
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// The steps of a release, in order. Assets are recorded individually, as
+// AssetStep(name).
+const (
+	StepTag       = "tag"
+	StepBuild     = "build"
+	StepPushTag   = "push-tag"
+	StepPublish   = "publish"
+	assetStepPref = "asset:"
+)
+
+// AssetStep returns the step of uploading the given asset.
+func AssetStep(name string) string {
+	return assetStepPref + name
+}
+
+// State is the progress of a release, persisted between attempts.
+type State struct {
+	// Version is the version being released, without the v prefix.
+	Version string `json:"version"`
+	// Tag is the git tag of the release.
+	Tag string `json:"tag"`
+	// Commit is the commit being released.
+	Commit string `json:"commit"`
+	// PreviousTag is the tag of the previous release, if any, which the
+	// release notes start from.
+	PreviousTag string `json:"previousTag,omitempty"`
+	// ReleaseID identifies the release created on the git host, for
+	// attaching assets to it.
+	ReleaseID string `json:"releaseId,omitempty"`
+	// ReleaseURL is the URL of the release created on the git host.
+	ReleaseURL string `json:"releaseUrl,omitempty"`
+	// Done are the completed steps.
+	Done map[string]bool `json:"done"`
+
+	path string
+}
+
+// LoadState loads the state saved at path, if any. A new, empty state is
+// returned otherwise.
+func LoadState(path string) (*State, error) {
+	s := &State{path: path, Done: make(map[string]bool)}
+	dt, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read release state %s", path)
+	}
+	err = json.Unmarshal(dt, s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse release state %s", path)
+	}
+	if s.Done == nil {
+		s.Done = make(map[string]bool)
+	}
+	return s, nil
+}
+
+// MarkDone records the step as completed and saves the state.
+func (s *State) MarkDone(step string) error {
+	s.Done[step] = true
+	return s.Save()
+}
+
+// Save writes the state to disk.
+func (s *State) Save() error {
+	dt, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal release state")
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "create dir for %s", s.path)
+	}
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, dt, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "write release state %s", tmp)
+	}
+	return errors.Wrapf(os.Rename(tmp, s.path), "rename release state to %s", s.path)
+}
+
+// Reset discards the progress, starting a release of the given version from
+// scratch.
+func (s *State) Reset(version, tag, commit, previousTag string) error {
+	*s = State{
+		Version:     version,
+		Tag:         tag,
+		Commit:      commit,
+		PreviousTag: previousTag,
+		Done:        make(map[string]bool),
+		path:        s.path,
+	}
+	return s.Save()
+}
+
+// Remove deletes the saved state, once the release is complete.
+func (s *State) Remove() error {
+	err := os.Remove(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "remove release state %s", s.path)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package remotesource
+
+import (
+	"context"
+	"strings"
+
+	"github.com/earthly/earthly/util/registryutil"
+	"github.com/pkg/errors"
+)
+
+// fetchOCI pulls the manifest of the artifact, verifying that its digest is
+// sum, and extracts the first layer of the artifact into dir.
+func (f *Fetcher) fetchOCI(ctx context.Context, s, sum, dir string) error {
+	ref, err := registryutil.ParseRef(s)
+	if err != nil {
+		return err
+	}
+	c := registryutil.NewClient(f.client())
+	m, err := c.GetManifest(ctx, ref, registryutil.ImageMediaTypes)
+	if err != nil {
+		return err
+	}
+	if m.Digest != "sha256:"+sum {
+		return errors.Errorf("checksum mismatch: expected manifest digest sha256:%s, got %s", sum, m.Digest)
+	}
+	if len(m.Layers) == 0 {
+		return errors.New("artifact has no layers")
+	}
+	layerSum := strings.TrimPrefix(m.Layers[0].Digest, "sha256:")
+	if !sha256Regexp.MatchString(layerSum) {
+		return errors.Errorf("unsupported layer digest %s", m.Layers[0].Digest)
+	}
+	body, err := c.GetBlob(ctx, ref, m.Layers[0].Digest)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	// The manifest is pinned, and it pins the layer in turn.
+	return downloadAndExtract(body, layerSum, dir)
+}
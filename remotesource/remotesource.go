@@ -0,0 +1,226 @@
+// Package remotesource fetches Earthfile trees distributed as HTTPS tarballs
+// or OCI artifacts, rather than via git, verifying them against a pinned
+// checksum and caching them locally.
+package remotesource
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var sha256Regexp = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// Source is a tree of Earthfiles, which replaces a remote repository.
+type Source struct {
+	// Repo is the repository which the source replaces, as in
+	// github.com/org/build-lib.
+	Repo string
+	// URL is either the https:// URL of a tarball (optionally gzipped), or an
+	// OCI artifact, as in oci://ghcr.io/org/build-lib:1.2.0, whose first layer
+	// is such a tarball.
+	URL string
+	// SHA256 pins the checksum of the tarball or, for OCI artifacts, the
+	// digest of the manifest.
+	SHA256 string
+}
+
+// Fetcher downloads sources into a local cache directory.
+type Fetcher struct {
+	// Dir is the cache directory. Sources are extracted into Dir/<sha256>.
+	Dir string
+	// Client is the HTTP client used for downloading sources.
+	Client *http.Client
+	// Offline fails the fetch of any source which is not cached yet.
+	Offline bool
+}
+
+// Fetch returns the directory into which the source is extracted, downloading
+// and verifying it first, unless it is already cached.
+func (f *Fetcher) Fetch(ctx context.Context, src Source) (string, error) {
+	sum := strings.TrimPrefix(strings.ToLower(src.SHA256), "sha256:")
+	if !sha256Regexp.MatchString(sum) {
+		return "", errors.Errorf("source %s of %s must be pinned via a sha256 checksum", src.URL, src.Repo)
+	}
+	dir := filepath.Join(f.Dir, sum)
+	_, err := os.Stat(dir)
+	if err == nil {
+		return dir, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "stat %s", dir)
+	}
+	if f.Offline {
+		return "", errors.Errorf("source %s of %s is not cached and cannot be fetched in offline mode", src.URL, src.Repo)
+	}
+	err = os.MkdirAll(f.Dir, 0755)
+	if err != nil {
+		return "", errors.Wrapf(err, "create %s", f.Dir)
+	}
+	tmpDir, err := ioutil.TempDir(f.Dir, "fetch-")
+	if err != nil {
+		return "", errors.Wrap(err, "create temp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+	switch {
+	case strings.HasPrefix(src.URL, "https://"):
+		err = f.fetchTarball(ctx, src.URL, sum, tmpDir)
+	case strings.HasPrefix(src.URL, "oci://"):
+		err = f.fetchOCI(ctx, strings.TrimPrefix(src.URL, "oci://"), sum, tmpDir)
+	default:
+		err = errors.New("only https:// and oci:// URLs are supported")
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "fetch %s for %s", src.URL, src.Repo)
+	}
+	err = os.Rename(tmpDir, dir)
+	if err != nil && !os.IsExist(err) {
+		if _, statErr := os.Stat(dir); statErr == nil {
+			// Fetched concurrently by another build.
+			return dir, nil
+		}
+		return "", errors.Wrapf(err, "rename %s to %s", tmpDir, dir)
+	}
+	return dir, nil
+}
+
+func (f *Fetcher) client() *http.Client {
+	if f.Client != nil {
+		return f.Client
+	}
+	return http.DefaultClient
+}
+
+// fetchTarball downloads the tarball, verifying that its checksum is sum,
+// and extracts it into dir.
+func (f *Fetcher) fetchTarball(ctx context.Context, url, sum, dir string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return downloadAndExtract(resp.Body, sum, dir)
+}
+
+// downloadAndExtract spools r into a temporary file while verifying its
+// checksum, and only then extracts it, so that nothing unverified is ever
+// extracted.
+func downloadAndExtract(r io.Reader, sum, dir string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(dir), "download-")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return errors.Wrap(err, "download")
+	}
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != sum {
+		return errors.Errorf("checksum mismatch: expected sha256 %s, got %s", sum, actual)
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return errors.Wrap(err, "seek")
+	}
+	return extract(tmp, dir)
+}
+
+// extract extracts the (optionally gzipped) tar stream into dir. Entries
+// which would end up outside of dir are rejected.
+func extract(r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil {
+		return errors.Wrap(err, "read archive")
+	}
+	var tr *tar.Reader
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "read gzip")
+		}
+		defer gz.Close()
+		tr = tar.NewReader(gz)
+	} else {
+		tr = tar.NewReader(br)
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "create %s", dir)
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read tar")
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return errors.Errorf("archive entry %s is outside of the archive", hdr.Name)
+		}
+		p := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(p, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(p, tr, os.FileMode(hdr.Mode).Perm())
+		case tar.TypeSymlink:
+			target := filepath.FromSlash(hdr.Linkname)
+			resolved := filepath.Join(filepath.Dir(name), target)
+			if filepath.IsAbs(target) || resolved == ".." || strings.HasPrefix(resolved, ".."+string(filepath.Separator)) {
+				return errors.Errorf("archive symlink %s points outside of the archive", hdr.Name)
+			}
+			err = os.MkdirAll(filepath.Dir(p), 0755)
+			if err == nil {
+				err = os.Symlink(target, p)
+			}
+		default:
+			// Other entry types are not relevant to build definitions.
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "extract %s", hdr.Name)
+		}
+	}
+}
+
+func writeFile(p string, r io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode|0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
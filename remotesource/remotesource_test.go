@@ -0,0 +1,132 @@
+package remotesource
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func tarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		NoError(t, err)
+	}
+	NoError(t, tw.Close())
+	NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func sha(dt []byte) string {
+	h := sha256.Sum256(dt)
+	return hex.EncodeToString(h[:])
+}
+
+func TestFetchTarball(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotesource-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	good := tarball(t, map[string]string{"Earthfile": "VERSION 0.6\n", "go/Earthfile": "VERSION 0.6\n"})
+	evil := tarball(t, map[string]string{"../escape": "x"})
+	requests := 0
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/lib.tar.gz":
+			w.Write(good)
+		case "/evil.tar.gz":
+			w.Write(evil)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	f := &Fetcher{Dir: dir, Client: ts.Client()}
+	ctx := context.Background()
+
+	src := Source{Repo: "github.com/org/lib", URL: ts.URL + "/lib.tar.gz", SHA256: "sha256:" + sha(good)}
+	out, err := f.Fetch(ctx, src)
+	NoError(t, err)
+	Equal(t, filepath.Join(dir, sha(good)), out)
+	dt, err := ioutil.ReadFile(filepath.Join(out, "go", "Earthfile"))
+	NoError(t, err)
+	Equal(t, "VERSION 0.6\n", string(dt))
+
+	// Cached sources are not downloaded again, even in offline mode.
+	f.Offline = true
+	_, err = f.Fetch(ctx, src)
+	NoError(t, err)
+	Equal(t, 1, requests)
+	f.Offline = false
+
+	_, err = f.Fetch(ctx, Source{Repo: "github.com/org/lib", URL: ts.URL + "/lib.tar.gz", SHA256: strings.Repeat("0", 64)})
+	Error(t, err)
+	True(t, strings.Contains(err.Error(), "checksum mismatch"))
+	_, err = f.Fetch(ctx, Source{Repo: "github.com/org/lib", URL: ts.URL + "/lib.tar.gz"})
+	Error(t, err)
+	_, err = f.Fetch(ctx, Source{Repo: "github.com/org/evil", URL: ts.URL + "/evil.tar.gz", SHA256: sha(evil)})
+	Error(t, err)
+	_, err = os.Stat(filepath.Join(dir, "escape"))
+	True(t, os.IsNotExist(err))
+
+	entries, err := ioutil.ReadDir(dir)
+	NoError(t, err)
+	Equal(t, 1, len(entries))
+}
+
+func TestFetchOCI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotesource-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	layer := tarball(t, map[string]string{"Earthfile": "VERSION 0.6\n"})
+	manifest := []byte(fmt.Sprintf(`{"schemaVersion":2,"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"sha256:%s"}]}`, sha(layer)))
+	var ts *httptest.Server
+	ts = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			Equal(t, "repository:org/lib:pull", r.URL.Query().Get("scope"))
+			w.Write([]byte(`{"token":"secret"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:org/lib:pull"`, ts.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/org/lib/manifests/1.0.0":
+			w.Write(manifest)
+		case "/v2/org/lib/blobs/sha256:" + sha(layer):
+			w.Write(layer)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	f := &Fetcher{Dir: dir, Client: ts.Client()}
+	ref := "oci://" + strings.TrimPrefix(ts.URL, "https://") + "/org/lib:1.0.0"
+
+	out, err := f.Fetch(context.Background(), Source{Repo: "github.com/org/lib", URL: ref, SHA256: sha(manifest)})
+	NoError(t, err)
+	dt, err := ioutil.ReadFile(filepath.Join(out, "Earthfile"))
+	NoError(t, err)
+	Equal(t, "VERSION 0.6\n", string(dt))
+
+	_, err = f.Fetch(context.Background(), Source{Repo: "github.com/org/lib", URL: ref, SHA256: sha(layer)})
+	Error(t, err)
+}
@@ -0,0 +1,135 @@
+// Package selftest discovers and evaluates the self-test targets of
+// Earthfiles, as run by earthly selftest. This allows shared build libraries
+// to be tested in CI like any other code.
+package selftest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/earthly/earthly/ast"
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+)
+
+const (
+	// TargetPrefix is the prefix of the names of self-test targets. A self-test
+	// passes when its target builds successfully.
+	TargetPrefix = "selftest"
+	// FailPrefix is the prefix of the names of self-test targets which are
+	// expected to fail, such as to check that a command rejects invalid input.
+	FailPrefix = "selftest-fail-"
+)
+
+// Case is a single self-test target.
+type Case struct {
+	Target     domain.Target
+	ExpectFail bool
+}
+
+// IsSelfTest returns whether the target name denotes a self-test.
+func IsSelfTest(name string) bool {
+	return name == TargetPrefix || strings.HasPrefix(name, TargetPrefix+"-")
+}
+
+// Discover returns the self-tests of the Earthfiles in the given directories,
+// in the order in which they are declared. Only the targets whose name
+// contains filter are returned, if it is set.
+func Discover(ctx context.Context, dirs []string, filter string) ([]Case, error) {
+	var cases []Case
+	for _, dir := range dirs {
+		ef, err := ast.Parse(ctx, filepath.Join(dir, "Earthfile"), false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse Earthfile in %s", dir)
+		}
+		ref := filepath.ToSlash(dir)
+		if !filepath.IsAbs(dir) && !strings.HasPrefix(ref, ".") {
+			ref = "./" + ref
+		}
+		for _, t := range ef.Targets {
+			if !IsSelfTest(t.Name) || (filter != "" && !strings.Contains(t.Name, filter)) {
+				continue
+			}
+			target, err := domain.ParseTarget(ref + "+" + t.Name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse target %s+%s", ref, t.Name)
+			}
+			cases = append(cases, Case{Target: target, ExpectFail: strings.HasPrefix(t.Name, FailPrefix)})
+		}
+	}
+	if len(cases) == 0 {
+		return nil, errors.Errorf("no %s targets found in %s", TargetPrefix, strings.Join(dirs, ", "))
+	}
+	return cases, nil
+}
+
+// Result is the outcome of a self-test.
+type Result struct {
+	Case     Case
+	Err      error // the error of the build of the target, if any
+	Duration time.Duration
+}
+
+// Passed returns whether the outcome of the build matches the expectation
+// of the self-test.
+func (r Result) Passed() bool {
+	return (r.Err != nil) == r.Case.ExpectFail
+}
+
+// Failure describes why the self-test failed, if it did.
+func (r Result) Failure() string {
+	switch {
+	case r.Passed():
+		return ""
+	case r.Case.ExpectFail:
+		return "expected the target to fail, but it succeeded"
+	default:
+		return r.Err.Error()
+	}
+}
+
+// PrintReport writes a summary of the results, one self-test per line,
+// followed by the failures.
+func PrintReport(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	passed := 0
+	for _, r := range results {
+		status := "FAIL"
+		if r.Passed() {
+			status = "ok"
+			passed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", status, r.Case.Target.String(), r.Duration.Round(100*time.Millisecond))
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if !r.Passed() {
+			fmt.Fprintf(w, "\n--- FAIL: %s\n%s\n", r.Case.Target.String(), r.Failure())
+		}
+	}
+	_, err = fmt.Fprintf(w, "\n%d passed, %d failed\n", passed, len(results)-passed)
+	return err
+}
+
+// NewCacheNamespace returns a fresh namespace for the cache mounts of a
+// self-test run, so that the tests neither see nor pollute the caches of
+// regular builds, nor those of previous runs.
+func NewCacheNamespace() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return fmt.Sprintf("selftest-%d-%d", os.Getpid(), time.Now().UnixNano())
+	}
+	return "selftest-" + hex.EncodeToString(b)
+}
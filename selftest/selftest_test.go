@@ -0,0 +1,83 @@
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+const earthfile = `VERSION 0.6
+FROM alpine:3.15
+
+build:
+    RUN echo hello > hello.txt
+    SAVE ARTIFACT hello.txt
+
+selftest-build:
+    COPY +build/hello.txt ./
+    DO std/test+ASSERT_EXISTS --path=hello.txt
+
+selftest-fail-missing-arg:
+    DO std/pkg+APT_INSTALL
+
+selftests-are-not-this:
+    RUN true
+`
+
+func TestDiscover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "selftest-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "Earthfile"), []byte(earthfile), 0644))
+
+	cases, err := Discover(context.Background(), []string{dir}, "")
+	NoError(t, err)
+	Equal(t, 2, len(cases))
+	Equal(t, "selftest-build", cases[0].Target.Target)
+	Equal(t, filepath.ToSlash(dir), cases[0].Target.LocalPath)
+	False(t, cases[0].ExpectFail)
+	Equal(t, "selftest-fail-missing-arg", cases[1].Target.Target)
+	True(t, cases[1].ExpectFail)
+
+	cases, err = Discover(context.Background(), []string{dir}, "missing")
+	NoError(t, err)
+	Equal(t, 1, len(cases))
+	_, err = Discover(context.Background(), []string{dir}, "nothing")
+	Error(t, err)
+}
+
+func TestPrintReport(t *testing.T) {
+	ok := Case{Target: domain.Target{LocalPath: "./lib", Target: "selftest-build"}}
+	fail := Case{Target: domain.Target{LocalPath: "./lib", Target: "selftest-fail-bad-input"}, ExpectFail: true}
+	var buf bytes.Buffer
+	NoError(t, PrintReport(&buf, []Result{
+		{Case: ok, Duration: 1200 * time.Millisecond},
+		{Case: fail},
+		{Case: ok, Err: errors.New("build failed")},
+		{Case: fail, Err: errors.New("--packages is required")},
+	}))
+	Equal(t, `ok    ./lib+selftest-build           1.2s
+FAIL  ./lib+selftest-fail-bad-input  0s
+FAIL  ./lib+selftest-build           0s
+ok    ./lib+selftest-fail-bad-input  0s
+
+--- FAIL: ./lib+selftest-fail-bad-input
+expected the target to fail, but it succeeded
+
+--- FAIL: ./lib+selftest-build
+build failed
+
+2 passed, 2 failed
+`, buf.String())
+	True(t, strings.HasPrefix(NewCacheNamespace(), "selftest-"))
+	NotEqual(t, NewCacheNamespace(), NewCacheNamespace())
+}
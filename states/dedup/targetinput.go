@@ -17,6 +17,11 @@ type TargetInput struct {
 	BuildArgs []BuildArgInput `json:"buildArgs"`
 	// Platform is the target platform of the target.
 	Platform string `json:"platform"`
+	// CrossPlatform is the platform being cross-compiled for, if the target is
+	// built natively on behalf of another platform.
+	CrossPlatform string `json:"crossPlatform,omitempty"`
+	// RunTimeout is the default timeout of the RUN commands of the target.
+	RunTimeout string `json:"runTimeout,omitempty"`
 	// AllowPrivileged is true if the target will allow priviledged access
 	AllowPrivileged bool `json:"allowPrivileged"`
 }
@@ -57,6 +62,12 @@ func (ti TargetInput) Equals(other TargetInput) bool {
 	if ti.Platform != other.Platform {
 		return false
 	}
+	if ti.CrossPlatform != other.CrossPlatform {
+		return false
+	}
+	if ti.RunTimeout != other.RunTimeout {
+		return false
+	}
 	if ti.AllowPrivileged != other.AllowPrivileged {
 		return false
 	}
@@ -76,6 +87,8 @@ func (ti TargetInput) clone() TargetInput {
 		TargetCanonical: ti.TargetCanonical,
 		BuildArgs:       make([]BuildArgInput, 0, len(ti.BuildArgs)),
 		Platform:        ti.Platform,
+		CrossPlatform:   ti.CrossPlatform,
+		RunTimeout:      ti.RunTimeout,
 		AllowPrivileged: ti.AllowPrivileged,
 	}
 	for _, bai := range ti.BuildArgs {
@@ -98,6 +111,8 @@ func (ti TargetInput) cloneNoTag() (TargetInput, error) {
 		TargetCanonical: targetStr,
 		BuildArgs:       make([]BuildArgInput, 0, len(ti.BuildArgs)),
 		Platform:        ti.Platform,
+		CrossPlatform:   ti.CrossPlatform,
+		RunTimeout:      ti.RunTimeout,
 		AllowPrivileged: ti.AllowPrivileged,
 	}
 	for _, bai := range ti.BuildArgs {
@@ -156,6 +171,10 @@ var BuiltinVariables = map[string]bool{
 	"EARTHLY_GIT_ORIGIN_URL_SCRUBBED": true,
 	"EARTHLY_GIT_PROJECT_NAME":        true,
 	"EARTHLY_GIT_COMMIT_TIMESTAMP":    true,
+	"EARTHLY_PROJECT":                 true,
+	"EARTHLY_PROJECT_ORG":             true,
+	"EARTHLY_PROJECT_NAME":            true,
+	"EARTHLY_PROJECT_REGISTRY":        true,
 	"TARGETPLATFORM":                  true,
 	"TARGETOS":                        true,
 	"TARGETARCH":                      true,
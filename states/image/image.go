@@ -1,6 +1,8 @@
 package image
 
 import (
+	"time"
+
 	"github.com/earthly/earthly/util/llbutil"
 	"github.com/moby/buildkit/frontend/dockerfile/dockerfile2llb"
 	specs "github.com/opencontainers/image-spec/specs-go/v1"
@@ -12,6 +14,9 @@ type Image struct {
 	Architecture string `json:"architecture"`
 	OS           string `json:"os"`
 	Config       Config `json:"config"`
+	// Created is the creation time of the image. If unset, it is the time
+	// of the export.
+	Created *time.Time `json:"created,omitempty"`
 }
 
 // NewImage returns a new image.
@@ -37,6 +42,7 @@ func (img *Image) Clone() *Image {
 	clone := &Image{
 		Architecture: img.Architecture,
 		OS:           img.OS,
+		Created:      img.Created,
 		Config: Config{
 			ImageConfig: specs.ImageConfig{
 				User:         img.Config.User,
@@ -3,8 +3,10 @@ package states
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/imagebudget"
 	"github.com/earthly/earthly/states/dedup"
 	"github.com/earthly/earthly/states/image"
 	"github.com/earthly/earthly/util/llbutil"
@@ -61,6 +63,16 @@ type SingleTarget struct {
 	RanFromLike bool
 	// RanInteractive represents whether we have encountered an --interactive command.
 	RanInteractive bool
+	// RunShell is the shell which RUN commands in shell form are executed
+	// with, as per SHELL. If empty, /bin/sh -c is used.
+	RunShell []string
+	// Layers are the commands which wrote to the filesystem of MainState,
+	// in order, including those of the targets it is FROM. Those of base
+	// images are not included.
+	Layers []Layer
+	// GitCommit is the commit which the ref of a remote target was resolved
+	// to. It is empty for local targets.
+	GitCommit string
 
 	// doneCh is a channel that is closed when the sts is complete.
 	doneCh chan struct{}
@@ -76,7 +88,7 @@ type SingleTarget struct {
 	incomingNewSubscriptions chan string
 }
 
-func newSingleTarget(ctx context.Context, target domain.Target, platform *specs.Platform, allowPrivileged bool, overridingVars *variables.Scope, parentDepSub chan string) (*SingleTarget, error) {
+func newSingleTarget(ctx context.Context, target domain.Target, platform, crossPlatform *specs.Platform, runTimeout time.Duration, allowPrivileged bool, overridingVars *variables.Scope, parentDepSub chan string) (*SingleTarget, error) {
 	targetStr := target.StringCanonical()
 	sts := &SingleTarget{
 		ID:       uuid.New().String(),
@@ -85,6 +97,8 @@ func newSingleTarget(ctx context.Context, target domain.Target, platform *specs.
 		targetInput: dedup.TargetInput{
 			TargetCanonical: targetStr,
 			Platform:        llbutil.PlatformWithDefaultToString(platform),
+			CrossPlatform:   llbutil.PlatformToString(crossPlatform),
+			RunTimeout:      durationToString(runTimeout),
 			AllowPrivileged: allowPrivileged,
 		},
 		MainState:                llbutil.ScratchWithPlatform(),
@@ -161,8 +175,9 @@ func (sts *SingleTarget) LastSaveImage() SaveImage {
 	if len(sts.SaveImages) == 0 {
 		// Use main state / image if no save image exists.
 		return SaveImage{
-			State: sts.MainState,
-			Image: sts.MainImage,
+			State:  sts.MainState,
+			Image:  sts.MainImage,
+			Layers: sts.Layers,
 		}
 	}
 	return sts.SaveImages[len(sts.SaveImages)-1]
@@ -246,6 +261,28 @@ type SaveImage struct {
 	HasPushDependencies bool
 	// DoSave indicates whether the image should be saved and (possibly pushed).
 	DoSave bool
+	// FanOutFrom, if set, is another tag of the same SAVE IMAGE command, in
+	// the same registry. If push_fan_out is enabled, the image is then pushed
+	// by copying it from that tag once it has been pushed, which mounts its
+	// layers rather than uploading them again.
+	FanOutFrom string
+	// Layers are the layers of State which the build produced.
+	Layers []Layer
+	// Budget is the size and number of files which the image may not
+	// exceed, as per SAVE IMAGE --max-size and --max-files.
+	Budget imagebudget.Budget
+}
+
+// Layer is a command of the build which wrote to the filesystem of a target,
+// along with the states before and after it, such that the files it wrote
+// can be told apart, as when scanning images for leaked secrets.
+type Layer struct {
+	// Command is the command, as in RUN npm ci.
+	Command string
+	// SourceLocation is where the command is, as in ./Earthfile line 12:4.
+	SourceLocation string
+	Before         pllb.State
+	After          pllb.State
 }
 
 // RunPush is a series of RUN --push commands to be run after the build has been deemed as
@@ -277,3 +314,10 @@ type InteractiveSession struct {
 	Initialized bool
 	Kind        InteractiveSessionKind
 }
+
+func durationToString(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
@@ -3,6 +3,7 @@ package states
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/states/dedup"
@@ -36,7 +37,7 @@ func (vc *VisitedCollection) All() []*SingleTarget {
 
 // Add adds a target to the collection, if it hasn't yet been visited. The returned sts is
 // either the previously visited one or a brand new one.
-func (vc *VisitedCollection) Add(ctx context.Context, target domain.Target, platform *specs.Platform, allowPrivileged bool, overridingVars *variables.Scope, parentDepSub chan string) (*SingleTarget, bool, error) {
+func (vc *VisitedCollection) Add(ctx context.Context, target domain.Target, platform, crossPlatform *specs.Platform, runTimeout time.Duration, allowPrivileged bool, overridingVars *variables.Scope, parentDepSub chan string) (*SingleTarget, bool, error) {
 	dependents, err := vc.waitAllDoneAndLock(ctx, target, parentDepSub)
 	if err != nil {
 		return nil, false, err
@@ -47,7 +48,7 @@ func (vc *VisitedCollection) Add(ctx context.Context, target domain.Target, plat
 	}
 	defer vc.mu.Unlock()
 	for _, sts := range vc.visited[target.StringCanonical()] {
-		same, err := CompareTargetInputs(target, platform, allowPrivileged, overridingVars, sts.TargetInput())
+		same, err := CompareTargetInputs(target, platform, crossPlatform, runTimeout, allowPrivileged, overridingVars, sts.TargetInput())
 		if err != nil {
 			return nil, false, err
 		}
@@ -71,7 +72,7 @@ func (vc *VisitedCollection) Add(ctx context.Context, target domain.Target, plat
 		}
 	}
 	// None are the same. Create new sts.
-	sts, err := newSingleTarget(ctx, target, platform, allowPrivileged, overridingVars, parentDepSub)
+	sts, err := newSingleTarget(ctx, target, platform, crossPlatform, runTimeout, allowPrivileged, overridingVars, parentDepSub)
 	if err != nil {
 		return nil, false, err
 	}
@@ -127,7 +128,7 @@ func (vc *VisitedCollection) waitAllDoneAndLock(ctx context.Context, target doma
 }
 
 // CompareTargetInputs compares two targets and their inputs to check if they are the same.
-func CompareTargetInputs(target domain.Target, platform *specs.Platform, allowPrivileged bool, overridingVars *variables.Scope, other dedup.TargetInput) (bool, error) {
+func CompareTargetInputs(target domain.Target, platform, crossPlatform *specs.Platform, runTimeout time.Duration, allowPrivileged bool, overridingVars *variables.Scope, other dedup.TargetInput) (bool, error) {
 	if target.StringCanonical() != other.TargetCanonical {
 		return false, nil
 	}
@@ -141,6 +142,12 @@ func CompareTargetInputs(target domain.Target, platform *specs.Platform, allowPr
 	if !llbutil.PlatformEquals(stsPlat, platform) {
 		return false, nil
 	}
+	if llbutil.PlatformToString(crossPlatform) != other.CrossPlatform {
+		return false, nil
+	}
+	if durationToString(runTimeout) != other.RunTimeout {
+		return false, nil
+	}
 	for _, bai := range other.BuildArgs {
 		variable, found := overridingVars.GetAny(bai.Name)
 		if found {
@@ -0,0 +1,58 @@
+// Package stdlib contains the standard library of Earthfiles, which is
+// embedded in the earthly binary and is referenced as std/<library>, for
+// example std/pkg+APT_INSTALL.
+package stdlib
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Prefix is the prefix of all references to the standard library.
+const Prefix = "std/"
+
+//go:embed */Earthfile
+var libraries embed.FS
+
+// IsLibrary returns true if the git URL of a reference points to the
+// standard library, rather than to an actual repository.
+func IsLibrary(gitURL string) bool {
+	return strings.HasPrefix(gitURL, Prefix)
+}
+
+// Names returns the names of all the libraries, sorted.
+func Names() []string {
+	entries, err := fs.ReadDir(libraries, ".")
+	if err != nil {
+		panic(err) // embedded
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Earthfile returns the Earthfile of the library with the given git URL, as in
+// std/pkg.
+func Earthfile(gitURL string) ([]byte, error) {
+	name := strings.TrimPrefix(gitURL, Prefix)
+	if name == "" || strings.Contains(name, "/") {
+		return nil, errors.Errorf("invalid standard library reference %s", gitURL)
+	}
+	dt, err := libraries.ReadFile(path.Join(name, "Earthfile"))
+	if err != nil {
+		return nil, errors.Errorf(
+			"standard library %s not found; available libraries: %s",
+			gitURL, strings.Join(Names(), ", "))
+	}
+	return dt, nil
+}
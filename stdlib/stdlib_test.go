@@ -0,0 +1,47 @@
+package stdlib
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/ast"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestEarthfile(t *testing.T) {
+	True(t, IsLibrary("std/pkg"))
+	False(t, IsLibrary("github.com/earthly/earthly"))
+
+	dt, err := Earthfile("std/pkg")
+	NoError(t, err)
+	Contains(t, string(dt), "APT_INSTALL:")
+	dt, err = Earthfile("std/go")
+	NoError(t, err)
+	Contains(t, string(dt), "GO_BUILD:")
+
+	_, err = Earthfile("std/missing")
+	Error(t, err)
+	_, err = Earthfile("std/pkg/sub")
+	Error(t, err)
+}
+
+// TestLibrariesParse makes sure that every library shipped with this version
+// of earthly is valid for the parser of this version.
+func TestLibrariesParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stdlib-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	Equal(t, []string{"docker", "go", "helm", "license", "migrate", "pkg", "publish", "release", "terraform", "test"}, Names())
+	for _, name := range Names() {
+		dt, err := Earthfile(Prefix + name)
+		NoError(t, err, name)
+		path := filepath.Join(dir, name+".earth")
+		NoError(t, ioutil.WriteFile(path, dt, 0600), name)
+		ef, err := ast.Parse(context.Background(), path, true)
+		NoError(t, err, name)
+		NotEmpty(t, ef.UserCommands, name)
+	}
+}
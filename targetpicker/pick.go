@@ -0,0 +1,199 @@
+package targetpicker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+)
+
+// maxShown is the maximum number of matching entries shown at once.
+const maxShown = 10
+
+// IsInteractive returns whether both stdin and stdout are terminals, so that
+// the user can be asked to pick a target.
+func IsInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// Pick lets the user pick one of the entries in the terminal: typing filters
+// the entries, the arrow keys move the selection and enter picks it. It
+// returns false if the user cancels, via Ctrl-C.
+func Pick(in *os.File, out io.Writer, entries []Entry) (Entry, bool, error) {
+	if len(entries) == 0 {
+		return Entry{}, false, errors.New("no targets to pick from")
+	}
+	fd := int(in.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return Entry{}, false, errors.Wrap(err, "set terminal to raw mode")
+	}
+	defer term.Restore(fd, oldState)
+	width, _, err := term.GetSize(fd)
+	if err != nil || width <= 0 {
+		width = 80
+	}
+
+	r := bufio.NewReader(in)
+	var query string
+	matches := entries
+	selected := 0
+	drawn := 0
+	for {
+		lines := render(query, matches, len(entries), selected, width)
+		erase(out, drawn)
+		fmt.Fprint(out, strings.Join(lines, "\r\n")+"\r\n")
+		drawn = len(lines)
+
+		k, err := readKey(r)
+		if err != nil {
+			erase(out, drawn)
+			return Entry{}, false, errors.Wrap(err, "read key")
+		}
+		switch k.kind {
+		case keyRune:
+			query += string(k.r)
+		case keyBackspace:
+			if query != "" {
+				query = query[:len(query)-1]
+			}
+		case keyUp:
+			if selected > 0 {
+				selected--
+			}
+		case keyDown:
+			if selected < len(matches)-1 && selected < maxShown-1 {
+				selected++
+			}
+		case keyEnter:
+			if len(matches) == 0 {
+				continue
+			}
+			erase(out, drawn)
+			return matches[selected], true, nil
+		case keyCancel:
+			erase(out, drawn)
+			return Entry{}, false, nil
+		}
+		if k.kind == keyRune || k.kind == keyBackspace {
+			matches = entries
+			if query != "" {
+				matches = Filter(entries, query)
+			}
+			selected = 0
+		}
+	}
+}
+
+// erase erases the given number of lines above the cursor.
+func erase(out io.Writer, lines int) {
+	if lines > 0 {
+		fmt.Fprintf(out, "\x1b[%dA\r\x1b[J", lines)
+	}
+}
+
+// render returns the lines showing the query and the matching entries, each
+// truncated to the width of the terminal.
+func render(query string, matches []Entry, total, selected, width int) []string {
+	lines := []string{fmt.Sprintf("Pick a target (type to filter, %d/%d): %s", len(matches), total, query)}
+	targetWidth := 0
+	for i, m := range matches {
+		if i == maxShown {
+			break
+		}
+		if len(m.Target) > targetWidth {
+			targetWidth = len(m.Target)
+		}
+	}
+	for i, m := range matches {
+		if i == maxShown {
+			lines = append(lines, fmt.Sprintf("  ... %d more", len(matches)-maxShown))
+			break
+		}
+		marker := "  "
+		if i == selected {
+			marker = "> "
+		}
+		line := marker + m.Target
+		if m.Doc != "" {
+			line += strings.Repeat(" ", targetWidth-len(m.Target)) + "  " + m.Doc
+		}
+		lines = append(lines, truncate(line, width-1))
+	}
+	return lines
+}
+
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if width <= 0 || len(r) <= width {
+		return s
+	}
+	if width <= 3 {
+		return string(r[:width])
+	}
+	return string(r[:width-3]) + "..."
+}
+
+// readKey reads a key press from the terminal in raw mode.
+func readKey(r *bufio.Reader) (key, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return key{}, err
+	}
+	switch b {
+	case '\r', '\n':
+		return key{kind: keyEnter}, nil
+	case 3, 4: // Ctrl-C, Ctrl-D.
+		return key{kind: keyCancel}, nil
+	case 127, 8:
+		return key{kind: keyBackspace}, nil
+	case 16: // Ctrl-P.
+		return key{kind: keyUp}, nil
+	case 14, '\t': // Ctrl-N.
+		return key{kind: keyDown}, nil
+	case 27:
+		b, err = r.ReadByte()
+		if err != nil {
+			return key{}, err
+		}
+		if b != '[' {
+			return key{kind: keyCancel}, nil
+		}
+		b, err = r.ReadByte()
+		if err != nil {
+			return key{}, err
+		}
+		switch b {
+		case 'A':
+			return key{kind: keyUp}, nil
+		case 'B':
+			return key{kind: keyDown}, nil
+		}
+		return key{kind: keyNone}, nil
+	}
+	if b < 32 {
+		return key{kind: keyNone}, nil
+	}
+	return key{kind: keyRune, r: rune(b)}, nil
+}
+
+type keyKind int
+
+const (
+	keyNone keyKind = iota
+	keyRune
+	keyBackspace
+	keyUp
+	keyDown
+	keyEnter
+	keyCancel
+)
+
+type key struct {
+	kind keyKind
+	r    rune
+}
@@ -0,0 +1,137 @@
+// Package targetpicker lets users pick the target to build from a list, when
+// earthly is run without one.
+package targetpicker
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/earthly/earthly/ast"
+	"github.com/earthly/earthly/ast/spec"
+	"github.com/pkg/errors"
+)
+
+// Entry is a target which can be picked.
+type Entry struct {
+	// Target is the reference of the target, as passed on the command line
+	// (e.g. +build, or ./lib+build for the targets of imported Earthfiles).
+	Target string
+	// Doc is the doc comment of the target, on a single line.
+	Doc string
+}
+
+// List returns the targets of the Earthfile in dir, followed by those of the
+// local Earthfiles which it imports globally. The targets of remote imports
+// are not listed, as that would require fetching them.
+func List(ctx context.Context, dir string) ([]Entry, error) {
+	ef, err := parse(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := targetEntries("", ef)
+	for _, importDir := range localImports(ef) {
+		importEf, err := parse(ctx, filepath.Join(dir, filepath.FromSlash(importDir)))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, targetEntries(importDir, importEf)...)
+	}
+	return entries, nil
+}
+
+func parse(ctx context.Context, dir string) (spec.Earthfile, error) {
+	ef, err := ast.Parse(ctx, filepath.Join(dir, "Earthfile"), false)
+	if err != nil {
+		return spec.Earthfile{}, errors.Wrapf(err, "parse %s", filepath.Join(dir, "Earthfile"))
+	}
+	return ef, nil
+}
+
+func targetEntries(refPrefix string, ef spec.Earthfile) []Entry {
+	entries := make([]Entry, 0, len(ef.Targets))
+	for _, t := range ef.Targets {
+		entries = append(entries, Entry{
+			Target: refPrefix + "+" + t.Name,
+			Doc:    strings.Join(strings.Fields(t.Docs), " "),
+		})
+	}
+	return entries
+}
+
+// localImports returns the paths of the Earthfiles imported by the base
+// recipe of the Earthfile which are local, as in IMPORT ./lib.
+func localImports(ef spec.Earthfile) []string {
+	var dirs []string
+	for _, stmt := range ef.BaseRecipe {
+		if stmt.Command == nil || stmt.Command.Name != "IMPORT" {
+			continue
+		}
+		for _, arg := range stmt.Command.Args {
+			if strings.HasPrefix(arg, "--") {
+				continue
+			}
+			if strings.HasPrefix(arg, "./") || strings.HasPrefix(arg, "../") || strings.HasPrefix(arg, "/") {
+				dir := path.Clean(arg)
+				if !strings.HasPrefix(dir, ".") && !strings.HasPrefix(dir, "/") {
+					dir = "./" + dir
+				}
+				dirs = append(dirs, dir)
+			}
+			break
+		}
+	}
+	return dirs
+}
+
+// Filter returns the entries which fuzzily match the query, best matches
+// first. The query matches an entry if its characters appear in order within
+// the target reference or doc of the entry.
+func Filter(entries []Entry, query string) []Entry {
+	type scored struct {
+		entry Entry
+		score int
+	}
+	var matches []scored
+	for _, e := range entries {
+		s, ok := score(query, e.Target)
+		if !ok {
+			s, ok = score(query, e.Doc)
+			s -= len(query) * 4 // Prefer matches of the target reference.
+		}
+		if ok {
+			matches = append(matches, scored{entry: e, score: s})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	ret := make([]Entry, 0, len(matches))
+	for _, m := range matches {
+		ret = append(ret, m.entry)
+	}
+	return ret
+}
+
+// score returns how well the query matches s, the higher the better, and
+// whether it matches at all. Consecutive characters, and characters at the
+// start of words, score higher.
+func score(query, s string) (int, bool) {
+	query, s = strings.ToLower(query), strings.ToLower(s)
+	total, qi, prev := 0, 0, -2
+	for i := 0; i < len(s) && qi < len(query); i++ {
+		if s[i] != query[qi] {
+			continue
+		}
+		total++
+		if i == prev+1 {
+			total += 2
+		}
+		if i == 0 || strings.ContainsRune("+-_./ ", rune(s[i-1])) {
+			total += 3
+		}
+		prev = i
+		qi++
+	}
+	return total, qi == len(query)
+}
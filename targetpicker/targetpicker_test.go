@@ -0,0 +1,55 @@
+package targetpicker
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/ast/spec"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestTargetEntries(t *testing.T) {
+	ef := spec.Earthfile{Targets: []spec.Target{
+		{Name: "build", Docs: "build compiles the binary\nfor the host platform."},
+		{Name: "test"},
+	}}
+	Equal(t, []Entry{
+		{Target: "./lib+build", Doc: "build compiles the binary for the host platform."},
+		{Target: "./lib+test"},
+	}, targetEntries("./lib", ef))
+}
+
+func TestLocalImports(t *testing.T) {
+	ef := spec.Earthfile{BaseRecipe: spec.Block{
+		{Command: &spec.Command{Name: "IMPORT", Args: []string{"./lib/", "AS", "lib"}}},
+		{Command: &spec.Command{Name: "IMPORT", Args: []string{"--allow-privileged", "../shared"}}},
+		{Command: &spec.Command{Name: "IMPORT", Args: []string{"github.com/org/repo:main"}}},
+		{Command: &spec.Command{Name: "FROM", Args: []string{"./not-an-import"}}},
+	}}
+	Equal(t, []string{"./lib", "../shared"}, localImports(ef))
+}
+
+func TestFilter(t *testing.T) {
+	entries := []Entry{
+		{Target: "+build"},
+		{Target: "+test-unit", Doc: "runs the unit tests"},
+		{Target: "./lib+build-docs"},
+		{Target: "+lint", Doc: "checks the build scripts"},
+	}
+	Equal(t, []Entry{entries[0], entries[2], entries[3]}, Filter(entries, "bui"))
+	Equal(t, []Entry{entries[1]}, Filter(entries, "tun"))
+	Equal(t, []Entry{entries[2]}, Filter(entries, "bdocs"))
+	Equal(t, 0, len(Filter(entries, "xyz")))
+}
+
+func TestRender(t *testing.T) {
+	entries := []Entry{
+		{Target: "+build", Doc: "compiles the binary"},
+		{Target: "+test-unit"},
+	}
+	Equal(t, []string{
+		"Pick a target (type to filter, 2/3): b",
+		"  +build      compiles the binary",
+		"> +test-unit",
+	}, render("b", entries, 3, 1, 80))
+	Equal(t, "  +build      compiles...", render("", entries, 2, 1, 26)[1])
+}
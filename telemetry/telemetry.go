@@ -0,0 +1,199 @@
+// Package telemetry records opt-in, anonymous usage metrics of earthly: the
+// commands run, the features used and the categories of the errors which
+// occurred. Metrics are aggregated locally, and only the counts are sent, at
+// most once per flush interval, to an endpoint which may be self-hosted. No
+// paths, args, target names or identifiers are ever recorded.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultFlushInterval is how long metrics are aggregated locally before
+// being sent.
+const DefaultFlushInterval = 24 * time.Hour
+
+// Report is the aggregate of the metrics recorded since Since. It is exactly
+// what is sent to the endpoint.
+type Report struct {
+	Version  string         `json:"version"`
+	Platform string         `json:"platform"`
+	Since    time.Time      `json:"since"`
+	Commands map[string]int `json:"commands"`
+	Features map[string]int `json:"features"`
+	Errors   map[string]int `json:"errors"`
+}
+
+// Run is the metrics of a single run of earthly.
+type Run struct {
+	Command string
+	// ErrorCategory is the category of the error the run failed with, or
+	// empty if it succeeded.
+	ErrorCategory string
+}
+
+var (
+	features   = make(map[string]bool)
+	featuresMu sync.Mutex
+)
+
+// Feature records that the feature with the given name (such as a CLI flag,
+// as in --push, or an Earthfile feature flag, as in VERSION --for-in) is
+// used by the current run. Names must not contain user data.
+func Feature(name string) {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	features[name] = true
+}
+
+func usedFeatures() []string {
+	featuresMu.Lock()
+	defer featuresMu.Unlock()
+	ret := make([]string, 0, len(features))
+	for name := range features {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Add adds the metrics of the run, along with the features recorded via
+// Feature, to the report.
+func (r *Report) Add(run Run) {
+	if r.Commands == nil {
+		r.Commands = make(map[string]int)
+	}
+	if r.Features == nil {
+		r.Features = make(map[string]int)
+	}
+	if r.Errors == nil {
+		r.Errors = make(map[string]int)
+	}
+	if run.Command != "" {
+		r.Commands[run.Command]++
+	}
+	for _, name := range usedFeatures() {
+		r.Features[name]++
+	}
+	if run.ErrorCategory != "" {
+		r.Errors[run.ErrorCategory]++
+	}
+}
+
+// Load reads the report stored at path, or returns an empty report starting
+// now if there is none.
+func Load(path string) (*Report, error) {
+	dt, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Report{Since: time.Now().UTC()}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", path)
+	}
+	var r Report
+	err = json.Unmarshal(dt, &r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %s", path)
+	}
+	return &r, nil
+}
+
+// Save writes the report to path.
+func (r *Report) Save(path string) error {
+	dt, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal telemetry report")
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "create dir of %s", path)
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, dt, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "write %s", tmp)
+	}
+	return errors.Wrapf(os.Rename(tmp, path), "rename %s", tmp)
+}
+
+// Due returns whether the report has been aggregating for longer than the
+// interval, and should be sent.
+func (r *Report) Due(now time.Time, interval time.Duration) bool {
+	return !now.Before(r.Since.Add(interval))
+}
+
+// Send sends the report to the endpoint, as JSON.
+func (r *Report) Send(ctx context.Context, endpoint string) error {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "marshal telemetry report")
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "create telemetry request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "send telemetry report")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("send telemetry report: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// RecordOpt holds the options of Record.
+type RecordOpt struct {
+	// Path is the file the report is aggregated in.
+	Path string
+	// Endpoint is the URL the report is sent to, once due.
+	Endpoint      string
+	FlushInterval time.Duration
+	Version       string
+	Platform      string
+}
+
+// Record adds the metrics of the run to the report stored locally, and sends
+// the report to the endpoint if it is due. The report is reset once sent, and
+// kept to be sent later if sending fails.
+func Record(ctx context.Context, run Run, opt RecordOpt) error {
+	r, err := Load(opt.Path)
+	if err != nil {
+		// Start over rather than being stuck on a corrupt report.
+		r = &Report{Since: time.Now().UTC()}
+	}
+	r.Version = opt.Version
+	r.Platform = opt.Platform
+	r.Add(run)
+	interval := opt.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	now := time.Now().UTC()
+	if opt.Endpoint != "" && r.Due(now, interval) {
+		sendErr := r.Send(ctx, opt.Endpoint)
+		if sendErr == nil {
+			r = &Report{Since: now, Version: opt.Version, Platform: opt.Platform}
+		}
+		err = r.Save(opt.Path)
+		if err != nil {
+			return err
+		}
+		return sendErr
+	}
+	return r.Save(opt.Path)
+}
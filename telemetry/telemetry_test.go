@@ -0,0 +1,85 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestReportAdd(t *testing.T) {
+	Feature("--push")
+	Feature("--push")
+	r := &Report{}
+	r.Add(Run{Command: "build"})
+	r.Add(Run{Command: "build", ErrorCategory: "build"})
+	Equal(t, map[string]int{"build": 2}, r.Commands)
+	Equal(t, 2, r.Features["--push"])
+	Equal(t, map[string]int{"build": 1}, r.Errors)
+}
+
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telemetry")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	var received []Report
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report Report
+		err := json.NewDecoder(r.Body).Decode(&report)
+		NoError(t, err)
+		received = append(received, report)
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+	opt := RecordOpt{
+		Path:          filepath.Join(dir, "telemetry.json"),
+		Endpoint:      ts.URL,
+		FlushInterval: time.Hour,
+		Version:       "v0.6.0",
+		Platform:      "linux/amd64",
+	}
+
+	// Metrics are aggregated locally until the report is due.
+	err = Record(ctx, Run{Command: "build"}, opt)
+	NoError(t, err)
+	err = Record(ctx, Run{Command: "prune"}, opt)
+	NoError(t, err)
+	Equal(t, 0, len(received))
+	r, err := Load(opt.Path)
+	NoError(t, err)
+	Equal(t, map[string]int{"build": 1, "prune": 1}, r.Commands)
+	False(t, r.Due(r.Since.Add(time.Minute), opt.FlushInterval))
+	True(t, r.Due(r.Since.Add(time.Hour), opt.FlushInterval))
+
+	// Once due, the report is sent and reset.
+	r.Since = r.Since.Add(-2 * time.Hour)
+	err = r.Save(opt.Path)
+	NoError(t, err)
+	err = Record(ctx, Run{Command: "build", ErrorCategory: "canceled"}, opt)
+	NoError(t, err)
+	Equal(t, 1, len(received))
+	Equal(t, map[string]int{"build": 2, "prune": 1}, received[0].Commands)
+	Equal(t, map[string]int{"canceled": 1}, received[0].Errors)
+	Equal(t, "linux/amd64", received[0].Platform)
+	r, err = Load(opt.Path)
+	NoError(t, err)
+	Equal(t, 0, len(r.Commands))
+
+	// The report is kept if it cannot be sent.
+	r.Since = r.Since.Add(-2 * time.Hour)
+	err = r.Save(opt.Path)
+	NoError(t, err)
+	ts.Config.Handler = http.NotFoundHandler()
+	err = Record(ctx, Run{Command: "build"}, opt)
+	Error(t, err)
+	r, err = Load(opt.Path)
+	NoError(t, err)
+	Equal(t, map[string]int{"build": 1}, r.Commands)
+}
@@ -0,0 +1,296 @@
+// Package upgrade implements earthly upgrade: it finds the release of the
+// requested channel or version, verifies the signature of its checksums and
+// replaces the running binary with it.
+package upgrade
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/earthly/earthly/util/semverutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ChannelStable selects the latest release which is not a prerelease.
+	ChannelStable = "stable"
+	// ChannelPrerelease selects the latest release, including prereleases.
+	ChannelPrerelease = "prerelease"
+
+	// ChecksumsAsset is the name of the release asset listing the SHA256
+	// checksums of the other assets, in the format of sha256sum.
+	ChecksumsAsset = "SHA256SUMS"
+	// SignatureAsset is the name of the release asset holding the ed25519
+	// signature of ChecksumsAsset, base64 encoded.
+	SignatureAsset = "SHA256SUMS.sig"
+
+	// DefaultAPIURL is the URL of the releases of earthly, in the GitHub API.
+	DefaultAPIURL = "https://api.github.com/repos/earthly/earthly/releases"
+)
+
+// Release is a release of earthly.
+type Release struct {
+	Tag        string
+	Prerelease bool
+	// Assets maps the names of the assets of the release to their download
+	// URLs.
+	Assets map[string]string
+}
+
+// Client finds and downloads releases.
+type Client struct {
+	HTTP *http.Client
+	// APIURL is the URL listing the releases, in the format of the GitHub API.
+	APIURL string
+}
+
+// NewClient returns a client for the releases of earthly on GitHub.
+func NewClient() *Client {
+	return &Client{HTTP: http.DefaultClient, APIURL: DefaultAPIURL}
+}
+
+type githubRelease struct {
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	Assets     []struct {
+		Name               string `json:"name"`
+		BrowserDownloadURL string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// ListReleases returns the published releases, most recent first.
+func (c *Client) ListReleases(ctx context.Context) ([]Release, error) {
+	dt, err := c.get(ctx, c.APIURL+"?per_page=100")
+	if err != nil {
+		return nil, errors.Wrap(err, "list releases")
+	}
+	var grs []githubRelease
+	err = json.Unmarshal(dt, &grs)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal releases")
+	}
+	releases := make([]Release, 0, len(grs))
+	for _, gr := range grs {
+		if gr.Draft {
+			continue
+		}
+		r := Release{Tag: gr.TagName, Prerelease: gr.Prerelease, Assets: make(map[string]string)}
+		for _, a := range gr.Assets {
+			r.Assets[a.Name] = a.BrowserDownloadURL
+		}
+		releases = append(releases, r)
+	}
+	return releases, nil
+}
+
+// Select returns the release of the given version (as in v0.6.1) if it is
+// not empty, or otherwise the most recent release of the channel.
+func Select(releases []Release, channel, version string) (Release, error) {
+	if version != "" {
+		want, err := semverutil.Parse(version)
+		if err != nil {
+			return Release{}, err
+		}
+		for _, r := range releases {
+			v, err := semverutil.Parse(r.Tag)
+			if err == nil && semverutil.Compare(v, want) == 0 {
+				return r, nil
+			}
+		}
+		return Release{}, errors.Errorf("release %s not found", version)
+	}
+	switch channel {
+	case ChannelStable, ChannelPrerelease:
+	default:
+		return Release{}, errors.Errorf("invalid channel %s: expected %s or %s", channel, ChannelStable, ChannelPrerelease)
+	}
+	var latest *Release
+	var latestVersion semverutil.Version
+	for i, r := range releases {
+		if r.Prerelease && channel == ChannelStable {
+			continue
+		}
+		v, err := semverutil.Parse(r.Tag)
+		if err != nil {
+			continue
+		}
+		if latest == nil || semverutil.Compare(v, latestVersion) > 0 {
+			latest, latestVersion = &releases[i], v
+		}
+	}
+	if latest == nil {
+		return Release{}, errors.Errorf("no %s release found", channel)
+	}
+	return *latest, nil
+}
+
+// AssetName returns the name of the release asset of the binary for the
+// given platform, as in earthly-linux-amd64.
+func AssetName(goos, goarch string) string {
+	name := fmt.Sprintf("earthly-%s-%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// DownloadBinary downloads the binary of the release for the current
+// platform, and verifies it against the checksums of the release, whose
+// signature is verified against the public key.
+func (c *Client) DownloadBinary(ctx context.Context, r Release, publicKey ed25519.PublicKey) ([]byte, error) {
+	name := AssetName(runtime.GOOS, runtime.GOARCH)
+	var assets [3][]byte
+	for i, assetName := range []string{ChecksumsAsset, SignatureAsset, name} {
+		url, ok := r.Assets[assetName]
+		if !ok {
+			return nil, errors.Errorf("release %s has no asset %s", r.Tag, assetName)
+		}
+		dt, err := c.get(ctx, url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "download %s of release %s", assetName, r.Tag)
+		}
+		assets[i] = dt
+	}
+	checksums, sig, binary := assets[0], assets[1], assets[2]
+	err := VerifySignature(checksums, sig, publicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "verify release %s", r.Tag)
+	}
+	err = VerifyChecksum(checksums, name, binary)
+	if err != nil {
+		return nil, errors.Wrapf(err, "verify release %s", r.Tag)
+	}
+	return binary, nil
+}
+
+func (c *Client) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create request for %s", url)
+	}
+	req = req.WithContext(ctx)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get %s", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("get %s: unexpected status %s", url, resp.Status)
+	}
+	dt, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", url)
+	}
+	return dt, nil
+}
+
+// ParsePublicKey parses a base64 encoded ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	dt, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode release public key")
+	}
+	if len(dt) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("invalid release public key: expected %d bytes, got %d", ed25519.PublicKeySize, len(dt))
+	}
+	return ed25519.PublicKey(dt), nil
+}
+
+// VerifySignature verifies the base64 encoded ed25519 signature of the
+// checksums.
+func VerifySignature(checksums, sig []byte, publicKey ed25519.PublicKey) error {
+	dt, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return errors.Wrap(err, "decode signature")
+	}
+	if !ed25519.Verify(publicKey, checksums, dt) {
+		return errors.New("invalid signature of the checksums")
+	}
+	return nil
+}
+
+// VerifyChecksum verifies that the checksum of the file with the given name,
+// as listed in checksums (in the format of sha256sum), matches dt.
+func VerifyChecksum(checksums []byte, name string, dt []byte) error {
+	s := bufio.NewScanner(bytes.NewReader(checksums))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum := sha256.Sum256(dt)
+		if hex.EncodeToString(sum[:]) != strings.ToLower(fields[0]) {
+			return errors.Errorf("checksum mismatch for %s", name)
+		}
+		return nil
+	}
+	return errors.Errorf("no checksum for %s", name)
+}
+
+// ReplaceBinary atomically replaces the binary at path with dt, by writing it
+// next to the binary and renaming it over the binary.
+func ReplaceBinary(path string, dt []byte) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "stat %s", path)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".new-")
+	if err != nil {
+		return errors.Wrapf(err, "create temp file next to %s", path)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(dt)
+	if err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "write %s", tmp.Name())
+	}
+	err = tmp.Close()
+	if err != nil {
+		return errors.Wrapf(err, "close %s", tmp.Name())
+	}
+	err = os.Chmod(tmp.Name(), fi.Mode().Perm()|0111)
+	if err != nil {
+		return errors.Wrapf(err, "chmod %s", tmp.Name())
+	}
+	if runtime.GOOS == "windows" {
+		// A running binary cannot be replaced on Windows, but it can be
+		// renamed.
+		old := path + ".old"
+		os.Remove(old)
+		err = os.Rename(path, old)
+		if err != nil {
+			return errors.Wrapf(err, "rename %s", path)
+		}
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return errors.Wrapf(err, "replace %s", path)
+	}
+	return nil
+}
+
+// BuildkitdImage returns the buildkitd image pinned to the given version of
+// earthly, if image is pinned to the current version, as in
+// earthly/buildkitd:v0.6.0. Otherwise, it returns false, as the user chose the
+// image deliberately.
+func BuildkitdImage(image, currentTag, newTag string) (string, bool) {
+	repo := strings.TrimSuffix(image, ":"+currentTag)
+	if repo == image || !strings.HasSuffix(repo, "earthly/buildkitd") {
+		return "", false
+	}
+	return repo + ":" + newTag, true
+}
@@ -0,0 +1,140 @@
+package upgrade
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestSelect(t *testing.T) {
+	releases := []Release{
+		{Tag: "v0.7.0-rc.1", Prerelease: true},
+		{Tag: "v0.6.2"},
+		{Tag: "v0.6.10"},
+		{Tag: "v0.5.0"},
+	}
+	r, err := Select(releases, ChannelStable, "")
+	NoError(t, err)
+	Equal(t, "v0.6.10", r.Tag)
+	r, err = Select(releases, ChannelPrerelease, "")
+	NoError(t, err)
+	Equal(t, "v0.7.0-rc.1", r.Tag)
+	r, err = Select(releases, ChannelStable, "v0.5.0")
+	NoError(t, err)
+	Equal(t, "v0.5.0", r.Tag)
+	r, err = Select(releases, ChannelStable, "0.7.0-rc.1")
+	NoError(t, err)
+	Equal(t, "v0.7.0-rc.1", r.Tag)
+	_, err = Select(releases, ChannelStable, "v0.4.0")
+	Error(t, err)
+	_, err = Select(releases, "nightly", "")
+	Error(t, err)
+	_, err = Select(releases[:1], ChannelStable, "")
+	Error(t, err)
+}
+
+func signedChecksums(t *testing.T, priv ed25519.PrivateKey, files map[string][]byte) ([]byte, []byte) {
+	var checksums []byte
+	for name, dt := range files {
+		sum := sha256.Sum256(dt)
+		checksums = append(checksums, []byte(fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name))...)
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, checksums))
+	return checksums, []byte(sig + "\n")
+}
+
+func TestDownloadBinary(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	NoError(t, err)
+	name := AssetName(runtime.GOOS, runtime.GOARCH)
+	binary := []byte("new earthly")
+	checksums, sig := signedChecksums(t, priv, map[string][]byte{name: binary})
+	assets := map[string][]byte{ChecksumsAsset: checksums, SignatureAsset: sig, name: binary}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dt, ok := assets[r.URL.Path[1:]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(dt)
+	}))
+	defer ts.Close()
+	r := Release{Tag: "v0.6.1", Assets: map[string]string{}}
+	for n := range assets {
+		r.Assets[n] = ts.URL + "/" + n
+	}
+	c := &Client{HTTP: ts.Client()}
+	ctx := context.Background()
+
+	dt, err := c.DownloadBinary(ctx, r, pub)
+	NoError(t, err)
+	Equal(t, binary, dt)
+
+	// The binary must match the checksums.
+	assets[name] = []byte("tampered earthly")
+	_, err = c.DownloadBinary(ctx, r, pub)
+	Error(t, err)
+
+	// The checksums must be signed by the release key.
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	NoError(t, err)
+	assets[name] = binary
+	_, err = c.DownloadBinary(ctx, r, otherPub)
+	Error(t, err)
+
+	delete(r.Assets, SignatureAsset)
+	_, err = c.DownloadBinary(ctx, r, pub)
+	Error(t, err)
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	NoError(t, err)
+	parsed, err := ParsePublicKey(base64.StdEncoding.EncodeToString(pub) + "\n")
+	NoError(t, err)
+	Equal(t, pub, parsed)
+	_, err = ParsePublicKey("")
+	Error(t, err)
+}
+
+func TestReplaceBinary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upgrade")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "earthly")
+	err = ioutil.WriteFile(path, []byte("old"), 0755)
+	NoError(t, err)
+	err = ReplaceBinary(path, []byte("new"))
+	NoError(t, err)
+	dt, err := ioutil.ReadFile(path)
+	NoError(t, err)
+	Equal(t, "new", string(dt))
+	fi, err := os.Stat(path)
+	NoError(t, err)
+	Equal(t, os.FileMode(0755), fi.Mode().Perm())
+	entries, err := ioutil.ReadDir(dir)
+	NoError(t, err)
+	Equal(t, 1, len(entries))
+}
+
+func TestBuildkitdImage(t *testing.T) {
+	image, ok := BuildkitdImage("earthly/buildkitd:v0.6.0", "v0.6.0", "v0.6.1")
+	True(t, ok)
+	Equal(t, "earthly/buildkitd:v0.6.1", image)
+	_, ok = BuildkitdImage("earthly/buildkitd:custom", "v0.6.0", "v0.6.1")
+	False(t, ok)
+	_, ok = BuildkitdImage("example.com/buildkitd:v0.6.0", "v0.6.0", "v0.6.1")
+	False(t, ok)
+}
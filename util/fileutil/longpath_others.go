@@ -0,0 +1,10 @@
+// +build !windows
+
+package fileutil
+
+// LongPath returns a path which can be passed to the os package regardless of
+// its length. Only Windows imposes a limit, so on other platforms the path is
+// returned unchanged.
+func LongPath(p string) string {
+	return p
+}
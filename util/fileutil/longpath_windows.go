@@ -0,0 +1,26 @@
+// +build windows
+
+package fileutil
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// LongPath returns a path which can be passed to the os package regardless of
+// its length. Windows limits regular paths to MAX_PATH (260) characters, unless
+// they are absolute and carry the \\?\ prefix.
+func LongPath(p string) string {
+	if strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		// UNC path: \\server\share -> \\?\UNC\server\share
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
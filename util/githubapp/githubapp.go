@@ -0,0 +1,134 @@
+package githubapp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultAPIURL is the URL of the public GitHub API.
+const DefaultAPIURL = "https://api.github.com"
+
+// App is a GitHub App which has been installed into an organization or user
+// account, and which can therefore mint short-lived installation tokens for
+// accessing the repositories of that installation.
+type App struct {
+	// APIURL is the base URL of the GitHub API. It defaults to DefaultAPIURL.
+	APIURL         string
+	AppID          int64
+	InstallationID int64
+	key            *rsa.PrivateKey
+}
+
+// New returns a new App, which signs its requests with the given PEM encoded
+// private key.
+func New(appID, installationID int64, keyPEM []byte) (*App, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("github app private key is not PEM encoded")
+	}
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse github app private key")
+		}
+		key = k
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse github app private key")
+		}
+		rsaKey, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("github app private key is not an RSA key")
+		}
+		key = rsaKey
+	default:
+		return nil, errors.Errorf("unexpected PEM block %s in github app private key", block.Type)
+	}
+	return &App{
+		APIURL:         DefaultAPIURL,
+		AppID:          appID,
+		InstallationID: installationID,
+		key:            key,
+	}, nil
+}
+
+// InstallationToken mints a new installation access token, which is valid for
+// one hour and can be used as the password for cloning via https.
+func (a *App) InstallationToken(ctx context.Context) (string, error) {
+	jwt, err := a.jwt(time.Now())
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", strings.TrimSuffix(a.APIURL, "/"), a.InstallationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "new request")
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "request github app installation token")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "read github app installation token response")
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", errors.Errorf("request github app installation token: unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var tokenResp struct {
+		Token string `json:"token"`
+	}
+	err = json.Unmarshal(body, &tokenResp)
+	if err != nil {
+		return "", errors.Wrap(err, "parse github app installation token response")
+	}
+	if tokenResp.Token == "" {
+		return "", errors.New("github app installation token response does not contain a token")
+	}
+	return tokenResp.Token, nil
+}
+
+// jwt returns a JSON web token which authenticates as the app itself. The
+// issue time is backdated to allow for clock drift, as GitHub recommends.
+func (a *App) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", errors.Wrap(err, "marshal jwt header")
+	}
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.AppID,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "marshal jwt claims")
+	}
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", errors.Wrap(err, "sign jwt")
+	}
+	return signingInput + "." + enc.EncodeToString(sig), nil
+}
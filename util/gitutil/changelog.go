@@ -0,0 +1,99 @@
+package gitutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChangelogEntry is a change listed in a changelog.
+type ChangelogEntry struct {
+	Scope       string
+	Description string
+	Hash        string
+}
+
+// Changelog groups the changes of a range of commits according to their
+// conventional commit type.
+type Changelog struct {
+	Breaking []ChangelogEntry
+	Features []ChangelogEntry
+	Fixes    []ChangelogEntry
+	Other    []ChangelogEntry
+}
+
+// changelogOmittedTypes are the conventional commit types which are not
+// relevant to the users of a release, and are left out of changelogs (unless
+// they are breaking changes).
+var changelogOmittedTypes = map[string]bool{
+	"build": true,
+	"chore": true,
+	"ci":    true,
+	"style": true,
+	"test":  true,
+}
+
+// NewChangelog groups the given commits, as returned by Log. Commits which do
+// not follow the conventional commits specification are listed as other
+// changes.
+func NewChangelog(commits []Commit) *Changelog {
+	c := &Changelog{}
+	for _, commit := range commits {
+		entry := ChangelogEntry{Description: commit.Subject, Hash: commit.Hash}
+		cc, ok := ParseConventionalCommit(commit)
+		if !ok {
+			c.Other = append(c.Other, entry)
+			continue
+		}
+		entry.Scope, entry.Description = cc.Scope, cc.Description
+		switch {
+		case cc.Breaking:
+			c.Breaking = append(c.Breaking, entry)
+		case cc.Type == "feat":
+			c.Features = append(c.Features, entry)
+		case cc.Type == "fix":
+			c.Fixes = append(c.Fixes, entry)
+		case !changelogOmittedTypes[cc.Type]:
+			c.Other = append(c.Other, entry)
+		}
+	}
+	return c
+}
+
+// Markdown renders the changelog as Markdown sections, omitting the empty
+// ones. It returns an empty string if there are no changes.
+func (c *Changelog) Markdown() string {
+	var b strings.Builder
+	for _, section := range []struct {
+		title   string
+		entries []ChangelogEntry
+	}{
+		{"Breaking changes", c.Breaking},
+		{"Features", c.Features},
+		{"Fixes", c.Fixes},
+		{"Other changes", c.Other},
+	} {
+		if len(section.entries) == 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "### %s\n\n", section.title)
+		for _, e := range section.entries {
+			b.WriteString("* ")
+			if e.Scope != "" {
+				fmt.Fprintf(&b, "**%s:** ", e.Scope)
+			}
+			b.WriteString(e.Description)
+			if e.Hash != "" {
+				hash := e.Hash
+				if len(hash) > 8 {
+					hash = hash[:8]
+				}
+				fmt.Fprintf(&b, " (%s)", hash)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,37 @@
+package gitutil
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestChangelog(t *testing.T) {
+	c := NewChangelog([]Commit{
+		{Hash: "1111111111", Subject: "feat(cli): add --foo"},
+		{Hash: "2222222222", Subject: "fix: crash on empty input"},
+		{Hash: "3333333333", Subject: "chore: bump deps"},
+		{Hash: "4444444444", Subject: "refactor!: rename the config keys"},
+		{Hash: "5555555555", Subject: "Update README"},
+		{Hash: "6666666666", Subject: "perf(parser): faster lexing"},
+	})
+	Equal(t, `### Breaking changes
+
+* rename the config keys (44444444)
+
+### Features
+
+* **cli:** add --foo (11111111)
+
+### Fixes
+
+* crash on empty input (22222222)
+
+### Other changes
+
+* Update README (55555555)
+* **parser:** faster lexing (66666666)
+`, c.Markdown())
+
+	Equal(t, "", NewChangelog([]Commit{{Subject: "ci: fix the pipeline"}}).Markdown())
+}
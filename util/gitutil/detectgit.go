@@ -255,6 +255,31 @@ func detectGitTimestamp(ctx context.Context, dir string) (string, error) {
 	return strings.SplitN(outStr, "\n", 2)[0], nil
 }
 
+// IsDirty returns true if the working tree of the repository of the provided
+// directory has uncommitted changes, including untracked files.
+func IsDirty(ctx context.Context, dir string) (bool, error) {
+	cmd := exec.CommandContext(ctx, "git", "status", "--porcelain")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return false, errors.Wrap(err, "detect git dirty")
+	}
+	return len(strings.TrimSpace(string(out))) != 0, nil
+}
+
+// ConvertsToCRLF returns true if git is configured to convert line endings to
+// CRLF on checkout (core.autocrlf=true) in the provided directory. Any failure
+// to read the setting is treated as false.
+func ConvertsToCRLF(ctx context.Context, dir string) bool {
+	cmd := exec.CommandContext(ctx, "git", "config", "--get", "core.autocrlf")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(out)) == "true"
+}
+
 func gitRelDir(basePath string, path string) (string, bool, error) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
@@ -1,6 +1,11 @@
 package gitutil
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
 	. "github.com/stretchr/testify/assert"
@@ -50,3 +55,35 @@ func TestParseGitRemoteURL(t *testing.T) {
 		Equal(t, test.expectedGitURL, gitURL)
 	}
 }
+
+func TestIsDirty(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "isdirty")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		NoError(t, err, string(out))
+	}
+	git("init", "-q")
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "Earthfile"), []byte("VERSION 0.6\n"), 0644))
+	dirty, err := IsDirty(ctx, dir)
+	NoError(t, err)
+	True(t, dirty)
+
+	git("add", "Earthfile")
+	git("commit", "-q", "-m", "init")
+	dirty, err = IsDirty(ctx, dir)
+	NoError(t, err)
+	False(t, dirty)
+
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "Earthfile"), []byte("VERSION 0.7\n"), 0644))
+	dirty, err = IsDirty(ctx, dir)
+	NoError(t, err)
+	True(t, dirty)
+}
@@ -0,0 +1,149 @@
+package gitutil
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/earthly/earthly/util/semverutil"
+	"github.com/pkg/errors"
+)
+
+// Commit is a commit of the git history.
+type Commit struct {
+	Hash    string
+	Subject string
+	Body    string
+}
+
+// Log returns the commits reachable from to, but not from from, newest first.
+// An empty from means the whole history of to.
+func Log(ctx context.Context, dir, from, to string) ([]Commit, error) {
+	rev := to
+	if from != "" {
+		rev = from + ".." + to
+	}
+	// Fields are separated by the unit separator and commits by the record
+	// separator, neither of which appears in commit messages in practice.
+	out, err := runGit(ctx, dir, "log", "--no-merges", "--format=%H%x1f%s%x1f%b%x1e", rev)
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, rec := range strings.Split(out, "\x1e") {
+		rec = strings.TrimLeft(rec, "\n")
+		if rec == "" {
+			continue
+		}
+		fields := strings.SplitN(rec, "\x1f", 3)
+		if len(fields) != 3 {
+			return nil, errors.Errorf("unexpected git log output %q", rec)
+		}
+		commits = append(commits, Commit{
+			Hash:    fields[0],
+			Subject: fields[1],
+			Body:    strings.TrimSpace(fields[2]),
+		})
+	}
+	return commits, nil
+}
+
+// LatestVersionTag returns the tag of the highest released semantic version
+// (i.e. not a pre-release) which is reachable from HEAD. It returns an empty
+// tag if there is none.
+func LatestVersionTag(ctx context.Context, dir string) (string, semverutil.Version, error) {
+	out, err := runGit(ctx, dir, "tag", "--merged", "HEAD")
+	if err != nil {
+		return "", semverutil.Version{}, err
+	}
+	var latestTag string
+	var latest semverutil.Version
+	for _, tag := range strings.Split(out, "\n") {
+		tag = strings.TrimSpace(tag)
+		v, err := semverutil.Parse(tag)
+		if err != nil || v.Prerelease != "" {
+			continue
+		}
+		if latestTag == "" || semverutil.Compare(v, latest) > 0 {
+			latestTag, latest = tag, v
+		}
+	}
+	return latestTag, latest, nil
+}
+
+var conventionalRegexp = regexp.MustCompile(`^([a-zA-Z]+)(?:\(([^)]*)\))?(!)?: *(.+)$`)
+
+// ConventionalCommit is a commit message following the conventional commits
+// specification, as in feat(parser)!: support heredocs.
+type ConventionalCommit struct {
+	Type        string
+	Scope       string
+	Breaking    bool
+	Description string
+}
+
+// ParseConventionalCommit parses the message of the commit, returning false
+// if it does not follow the conventional commits specification.
+func ParseConventionalCommit(c Commit) (ConventionalCommit, bool) {
+	m := conventionalRegexp.FindStringSubmatch(c.Subject)
+	if m == nil {
+		return ConventionalCommit{}, false
+	}
+	cc := ConventionalCommit{
+		Type:        strings.ToLower(m[1]),
+		Scope:       m[2],
+		Breaking:    m[3] == "!",
+		Description: m[4],
+	}
+	for _, line := range strings.Split(c.Body, "\n") {
+		if strings.HasPrefix(line, "BREAKING CHANGE:") || strings.HasPrefix(line, "BREAKING-CHANGE:") {
+			cc.Breaking = true
+		}
+	}
+	return cc, true
+}
+
+// NextVersion returns the version following v, according to the conventional
+// commits made since: a breaking change bumps the major version (or the minor
+// version, before 1.0.0), a feature the minor version, and anything else the
+// patch version. It returns false if there are no commits.
+func NextVersion(v semverutil.Version, commits []Commit) (semverutil.Version, bool) {
+	if len(commits) == 0 {
+		return v, false
+	}
+	breaking, feature := false, false
+	for _, c := range commits {
+		cc, ok := ParseConventionalCommit(c)
+		if !ok {
+			continue
+		}
+		breaking = breaking || cc.Breaking
+		feature = feature || cc.Type == "feat"
+	}
+	next := semverutil.Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	switch {
+	case breaking && v.Major > 0:
+		next.Major++
+		next.Minor, next.Patch = 0, 0
+	case breaking || feature:
+		next.Minor++
+		next.Patch = 0
+	default:
+		next.Patch++
+	}
+	return next, true
+}
+
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
@@ -0,0 +1,48 @@
+package gitutil
+
+import (
+	"testing"
+
+	"github.com/earthly/earthly/util/semverutil"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseConventionalCommit(t *testing.T) {
+	cc, ok := ParseConventionalCommit(Commit{Subject: "feat(parser)!: support heredocs"})
+	True(t, ok)
+	Equal(t, ConventionalCommit{Type: "feat", Scope: "parser", Breaking: true, Description: "support heredocs"}, cc)
+
+	cc, ok = ParseConventionalCommit(Commit{Subject: "Fix: typo", Body: "Some context.\n\nBREAKING CHANGE: the flag is gone"})
+	True(t, ok)
+	Equal(t, ConventionalCommit{Type: "fix", Breaking: true, Description: "typo"}, cc)
+
+	_, ok = ParseConventionalCommit(Commit{Subject: "Merge branch 'main'"})
+	False(t, ok)
+}
+
+func TestNextVersion(t *testing.T) {
+	for _, tc := range []struct {
+		current  string
+		subjects []string
+		expected string
+	}{
+		{"1.2.3", []string{"docs: readme", "Tidy up"}, "1.2.4"},
+		{"1.2.3", []string{"fix: crash", "feat: new flag"}, "1.3.0"},
+		{"1.2.3", []string{"feat!: drop the old flag"}, "2.0.0"},
+		{"0.4.1", []string{"refactor!: new config format"}, "0.5.0"},
+		{"0.0.0", []string{"initial commit"}, "0.0.1"},
+	} {
+		v, err := semverutil.Parse(tc.current)
+		NoError(t, err)
+		var commits []Commit
+		for _, s := range tc.subjects {
+			commits = append(commits, Commit{Subject: s})
+		}
+		next, ok := NextVersion(v, commits)
+		True(t, ok, tc.current)
+		Equal(t, tc.expected, next.String(), "%s %v", tc.current, tc.subjects)
+	}
+	_, ok := NextVersion(semverutil.Version{Major: 1}, nil)
+	False(t, ok)
+}
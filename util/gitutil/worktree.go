@@ -0,0 +1,51 @@
+package gitutil
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// AddWorktree checks out ref into a new, temporary worktree of the repository
+// containing dir. It returns the path within the worktree which corresponds
+// to dir, and a function which removes the worktree.
+func AddWorktree(ctx context.Context, dir, ref string) (string, func() error, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "abs path of %s", dir)
+	}
+	top, err := detectGitBaseDir(ctx, absDir)
+	if err != nil {
+		return "", nil, err
+	}
+	// The top level reported by git has its symlinks resolved.
+	resolvedDir, err := filepath.EvalSymlinks(absDir)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "resolve %s", absDir)
+	}
+	rel, err := filepath.Rel(top, resolvedDir)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "relative path of %s", dir)
+	}
+	wt, err := ioutil.TempDir("", "earthly-worktree")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "create worktree dir")
+	}
+	_, err = runGit(ctx, top, "worktree", "add", "--detach", wt, ref)
+	if err != nil {
+		os.RemoveAll(wt)
+		return "", nil, err
+	}
+	remove := func() error {
+		_, err := runGit(context.Background(), top, "worktree", "remove", "--force", wt)
+		if err != nil {
+			os.RemoveAll(wt)
+			return err
+		}
+		return nil
+	}
+	return filepath.Join(wt, rel), remove, nil
+}
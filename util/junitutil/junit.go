@@ -0,0 +1,77 @@
+// Package junitutil reads the outcome of tests from JUnit XML reports.
+package junitutil
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// TestCase is a test case of a JUnit report.
+type TestCase struct {
+	// ClassName is the class, or package, of the test case, if any.
+	ClassName string
+	// Name is the name of the test case, as in TestFoo.
+	Name string
+}
+
+// ID returns the name of the test case, qualified by its class name if any.
+func (tc TestCase) ID() string {
+	if tc.ClassName == "" {
+		return tc.Name
+	}
+	return tc.ClassName + "." + tc.Name
+}
+
+// Failures returns the test cases which failed or errored in the JUnit XML
+// report, whose root element may be testsuites or testsuite. Each test case is
+// returned once, even if it was run several times.
+func Failures(dt []byte) ([]TestCase, error) {
+	dec := xml.NewDecoder(bytes.NewReader(dt))
+	seen := make(map[TestCase]bool)
+	var failures []TestCase
+	var current *TestCase
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "parse junit report")
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case t.Name.Local == "testcase":
+				tc := TestCase{}
+				for _, attr := range t.Attr {
+					switch attr.Name.Local {
+					case "classname":
+						tc.ClassName = attr.Value
+					case "name":
+						tc.Name = attr.Value
+					}
+				}
+				current = &tc
+			case current != nil && (t.Name.Local == "failure" || t.Name.Local == "error"):
+				if !seen[*current] {
+					seen[*current] = true
+					failures = append(failures, *current)
+				}
+			}
+		case xml.EndElement:
+			depth--
+			if t.Name.Local == "testcase" {
+				current = nil
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("parse junit report: unexpected end of document")
+	}
+	return failures, nil
+}
@@ -0,0 +1,41 @@
+package junitutil
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestFailures(t *testing.T) {
+	report := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+	<testsuite name="pkg/api" tests="4">
+		<testcase classname="pkg/api" name="TestOK" time="0.1"></testcase>
+		<testcase classname="pkg/api" name="TestFails" time="0.2">
+			<failure message="Failed">expected 1, got 2</failure>
+		</testcase>
+		<testcase classname="pkg/api" name="TestSkipped"><skipped/></testcase>
+		<testcase classname="pkg/api" name="TestPanics"><error message="panic"/></testcase>
+		<testcase classname="pkg/api" name="TestFails"><failure/></testcase>
+	</testsuite>
+	<testsuite name="db">
+		<testcase name="migrations"><failure/></testcase>
+	</testsuite>
+</testsuites>`
+	failures, err := Failures([]byte(report))
+	NoError(t, err)
+	Equal(t, []TestCase{
+		{ClassName: "pkg/api", Name: "TestFails"},
+		{ClassName: "pkg/api", Name: "TestPanics"},
+		{Name: "migrations"},
+	}, failures)
+	Equal(t, "pkg/api.TestFails", failures[0].ID())
+	Equal(t, "migrations", failures[2].ID())
+
+	failures, err = Failures([]byte(`<testsuite><testcase name="TestOK"/></testsuite>`))
+	NoError(t, err)
+	Nil(t, failures)
+
+	_, err = Failures([]byte(`<testsuite><testcase name="TestOK">`))
+	Error(t, err)
+}
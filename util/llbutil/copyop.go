@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,7 +33,11 @@ func CopyOp(srcState pllb.State, srcs []string, destState pllb.State, dest strin
 			// underlying wildcard matching and allow empty wildcards. The matching uses
 			// the filepath.Match syntax, so by simply creating a wildcard where the
 			// first letter needs to match the current first letter gets us the single
-			// match; and no error if it is missing.
+			// match; and no error if it is missing. A leading ./ is dropped, so that
+			// the wildcard applies to the first letter of the file name itself.
+			if strings.HasPrefix(src, "./") && len(src) > 2 {
+				src = src[2:]
+			}
 			src = fmt.Sprintf("[%s]%s", string(src[0]), string(src[1:]))
 		}
 		copyOpts := append([]llb.CopyOption{
@@ -40,7 +40,7 @@ func (f *PreconstructedFactory) Construct() pllb.State {
 }
 
 // Local eventually creates a llb.Local
-func Local(name string, opts ...llb.LocalOption) Factory {
+func Local(name string, opts ...llb.LocalOption) *LocalFactory {
 	return &LocalFactory{
 		name: name,
 		opts: opts,
@@ -55,8 +55,9 @@ func (f *LocalFactory) Copy() *LocalFactory {
 	}
 
 	return &LocalFactory{
-		name: f.name,
-		opts: newOpts,
+		name:          f.name,
+		sharedKeyHint: f.sharedKeyHint,
+		opts:          newOpts,
 	}
 }
 
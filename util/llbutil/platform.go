@@ -32,6 +32,11 @@ func DefaultPlatform() specs.Platform {
 	return platforms.Normalize(p)
 }
 
+// IsWindows returns true if the platform (or the default platform, if nil) targets Windows containers.
+func IsWindows(p *specs.Platform) bool {
+	return PlatformWithDefault(p).OS == "windows"
+}
+
 // ScratchWithPlatform is the scratch state with the default platform readily set.
 func ScratchWithPlatform() pllb.State {
 	return pllb.Scratch().Platform(DefaultPlatform())
@@ -55,6 +55,13 @@ func Git(remote, ref string, opts ...llb.GitOption) State {
 	return State{st: llb.Git(remote, ref, opts...)}
 }
 
+// HTTP is a wrapper around llb.HTTP.
+func HTTP(url string, opts ...llb.HTTPOption) State {
+	gmu.Lock()
+	defer gmu.Unlock()
+	return State{st: llb.HTTP(url, opts...)}
+}
+
 // RawState returns the wrapped llb.State, but requires an unlock from the caller.
 func (s State) RawState() (llb.State, func()) {
 	gmu.Lock()
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/earthly/earthly/audit"
+	debuggercommon "github.com/earthly/earthly/debugger/common"
 	"github.com/earthly/earthly/secretsclient"
 
 	"github.com/moby/buildkit/session"
@@ -16,9 +18,16 @@ import (
 // ErrNoSecretsClient occurs when the secrets client is referenced but was never provided
 var ErrNoSecretsClient = errors.Errorf("no secrets client provided")
 
+// ErrOfflineSecret occurs when a shared secret is referenced in offline mode.
+var ErrOfflineSecret = errors.Errorf("shared secrets cannot be fetched in --offline mode")
+
 type secretProvider struct {
-	store  secrets.SecretStore
-	client secretsclient.Client
+	store    secrets.SecretStore
+	client   secretsclient.Client
+	auditLog *audit.Log
+	offline  bool
+	onSecret func(name string, data []byte)
+	internal func(id string) ([]byte, bool)
 }
 
 // Register registers the secret provider
@@ -27,6 +36,9 @@ func (sp *secretProvider) Register(server *grpc.Server) {
 }
 
 func (sp *secretProvider) getSecretFromServer(path string) ([]byte, error) {
+	if sp.offline {
+		return nil, errors.Wrapf(ErrOfflineSecret, "lookup secret %q", path)
+	}
 	if sp.client == nil {
 		return nil, ErrNoSecretsClient
 	}
@@ -42,6 +54,14 @@ func (sp *secretProvider) getSecretFromServer(path string) ([]byte, error) {
 // however by the time GetSecret is called, the "+secret/" prefix is removed.
 // if the name contains a /, then we can infer that it references the shared secret service.
 func (sp *secretProvider) GetSecret(ctx context.Context, req *secrets.GetSecretRequest) (*secrets.GetSecretResponse, error) {
+	if sp.internal != nil {
+		if dt, ok := sp.internal(req.ID); ok {
+			return &secrets.GetSecretResponse{
+				Data: dt,
+			}, nil
+		}
+	}
+
 	isSharedSecret := false
 	secretName := req.ID
 	if strings.Contains(req.ID, "/") {
@@ -64,16 +84,32 @@ func (sp *secretProvider) GetSecret(ctx context.Context, req *secrets.GetSecretR
 		}
 	}
 
+	if req.ID != debuggercommon.DebuggerSettingsSecretsKey {
+		err = sp.auditLog.Record(audit.KindSecret, "", req.ID)
+		if err != nil {
+			return nil, err
+		}
+		if sp.onSecret != nil {
+			sp.onSecret(req.ID, dt)
+		}
+	}
+
 	return &secrets.GetSecretResponse{
 		Data: dt,
 	}, nil
 }
 
-// NewSecretProvider returns a new secrets provider
-func NewSecretProvider(client secretsclient.Client, overrides map[string][]byte) session.Attachable {
+// NewSecretProvider returns a new secrets provider. Every secret handed out is
+// recorded in auditLog, which may be nil, and passed to onSecret, if not nil.
+// The secrets which earthly itself provides, such as git credentials, are
+// looked up via internal first, if not nil, and are not recorded.
+func NewSecretProvider(client secretsclient.Client, overrides map[string][]byte, auditLog *audit.Log, onSecret func(name string, data []byte), internal func(id string) ([]byte, bool)) session.Attachable {
 	return &secretProvider{
-		store:  mapStore(overrides),
-		client: client,
+		store:    mapStore(overrides),
+		client:   client,
+		auditLog: auditLog,
+		onSecret: onSecret,
+		internal: internal,
 	}
 }
 
@@ -87,3 +123,40 @@ func (m mapStore) GetSecret(ctx context.Context, id string) ([]byte, error) {
 	}
 	return v, nil
 }
+
+// NewOfflineSecretProvider returns a new secrets provider which only hands out
+// the given overrides, and those looked up via internal, failing for secrets
+// which would need to be fetched from the secrets server.
+func NewOfflineSecretProvider(overrides map[string][]byte, auditLog *audit.Log, onSecret func(name string, data []byte), internal func(id string) ([]byte, bool)) session.Attachable {
+	return &secretProvider{
+		store:    mapStore(overrides),
+		auditLog: auditLog,
+		offline:  true,
+		onSecret: onSecret,
+		internal: internal,
+	}
+}
+
+// NewMockSecretProvider returns a new secrets provider for mock mode, which
+// never contacts the secrets server: secrets which are not among the overrides
+// are handed out as returned by mock instead.
+func NewMockSecretProvider(overrides map[string][]byte, mock func(name string) []byte, auditLog *audit.Log) session.Attachable {
+	return &secretProvider{
+		store:    mockStore{overrides: overrides, mock: mock},
+		auditLog: auditLog,
+		offline:  true,
+	}
+}
+
+type mockStore struct {
+	overrides map[string][]byte
+	mock      func(name string) []byte
+}
+
+// GetSecret gets a secret from the overrides, falling back to the mock.
+func (m mockStore) GetSecret(ctx context.Context, id string) ([]byte, error) {
+	if v, ok := m.overrides[id]; ok {
+		return v, nil
+	}
+	return m.mock(strings.TrimPrefix(id, "/")), nil
+}
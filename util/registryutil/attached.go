@@ -0,0 +1,66 @@
+package registryutil
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// attachedSuffixes are the suffixes of the tags under which cosign stores
+// the signatures, attestations and SBOMs of an image, in the repository of
+// the image.
+var attachedSuffixes = []string{".sig", ".att", ".sbom"}
+
+// AttachedTags returns the tags under which the signatures, attestations and
+// SBOMs of the image with the given digest are stored, as per the cosign
+// conventions.
+func AttachedTags(dgst string) []string {
+	tags := make([]string, 0, len(attachedSuffixes))
+	for _, suffix := range attachedSuffixes {
+		tags = append(tags, strings.Replace(dgst, ":", "-", 1)+suffix)
+	}
+	return tags
+}
+
+// CopyAttached copies the signatures, attestations and SBOMs attached to the
+// image with the given digest from the repository of src to that of dst, and
+// verifies that they arrived intact. It returns the tags copied. Attachments
+// which already exist at dst with different contents are only overwritten if
+// force is set.
+func (c *Client) CopyAttached(ctx context.Context, src, dst Ref, dgst string, force bool) ([]string, error) {
+	var copied []string
+	for _, tag := range AttachedTags(dgst) {
+		srcDgst, ok, err := c.HeadManifest(ctx, src.WithReference(tag))
+		if err != nil {
+			return nil, errors.Wrapf(err, "check %s", tag)
+		}
+		if !ok {
+			continue
+		}
+		dstDgst, ok, err := c.HeadManifest(ctx, dst.WithReference(tag))
+		if err != nil {
+			return nil, errors.Wrapf(err, "check %s", tag)
+		}
+		if ok && dstDgst == srcDgst {
+			copied = append(copied, tag)
+			continue
+		}
+		if ok && !force {
+			return nil, errors.Errorf("%s already exists with different contents", dst.WithReference(tag).String())
+		}
+		_, err = c.Copy(ctx, src.WithReference(tag), dst.WithReference(tag))
+		if err != nil {
+			return nil, errors.Wrapf(err, "copy %s", tag)
+		}
+		dstDgst, ok, err = c.HeadManifest(ctx, dst.WithReference(tag))
+		if err != nil {
+			return nil, errors.Wrapf(err, "check %s", tag)
+		}
+		if !ok || dstDgst != srcDgst {
+			return nil, errors.Errorf("%s did not arrive intact", dst.WithReference(tag).String())
+		}
+		copied = append(copied, tag)
+	}
+	return copied, nil
+}
@@ -0,0 +1,230 @@
+package registryutil
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ListTags returns the tags of the repository of the reference.
+func (c *Client) ListTags(ctx context.Context, ref Ref) ([]string, error) {
+	var tags []string
+	p := "tags/list?n=1000"
+	for p != "" {
+		resp, err := c.do(ctx, request{
+			method: http.MethodGet,
+			ref:    ref,
+			path:   p,
+			ok:     []int{http.StatusOK},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "decode tag list")
+		}
+		tags = append(tags, list.Tags...)
+		p = ""
+		if next := nextLink(resp.Header.Get("Link")); next != "" {
+			u, err := resp.Request.URL.Parse(next)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse link %s", next)
+			}
+			p = u.String()
+		}
+	}
+	return tags, nil
+}
+
+// nextLink returns the URL of the next page, as per a Link header of the
+// form <url>; rel="next".
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 || !strings.Contains(parts[1], `rel="next"`) {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+	}
+	return ""
+}
+
+// DeleteManifest deletes the manifest with the given digest from the
+// repository of the reference, along with every tag which refers to it.
+func (c *Client) DeleteManifest(ctx context.Context, ref Ref, dgst string) error {
+	resp, err := c.do(ctx, request{
+		method: http.MethodDelete,
+		ref:    ref,
+		path:   "manifests/" + dgst,
+		push:   true,
+		ok:     []int{http.StatusAccepted, http.StatusOK},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// created returns the creation time of the image of the manifest, or the
+// zero time if it is unknown, as for artifacts which are not images.
+func (c *Client) created(ctx context.Context, ref Ref, m Manifest) (time.Time, error) {
+	if m.IsIndex() {
+		if len(m.Manifests) == 0 {
+			return time.Time{}, nil
+		}
+		child, err := c.GetManifest(ctx, ref.WithReference(m.Manifests[0].Digest), ImageMediaTypes)
+		if err != nil {
+			return time.Time{}, err
+		}
+		m = child
+	}
+	if m.Config == nil {
+		return time.Time{}, nil
+	}
+	body, err := c.GetBlob(ctx, ref, m.Config.Digest)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer body.Close()
+	var cfg struct {
+		Created *time.Time `json:"created"`
+	}
+	if json.NewDecoder(io.LimitReader(body, maxManifestSize)).Decode(&cfg) != nil || cfg.Created == nil {
+		return time.Time{}, nil
+	}
+	return *cfg.Created, nil
+}
+
+// GCOpt configures the garbage collection of the tags of a repository.
+type GCOpt struct {
+	// Match are the glob patterns (as in dev-*) of the tags to collect.
+	Match []string
+	// KeepLast is the number of the most recent matching tags to keep.
+	KeepLast int
+	// DryRun reports what would be deleted, without deleting anything.
+	DryRun bool
+}
+
+// GCResult is the outcome of a garbage collection.
+type GCResult struct {
+	// Deleted are the tags deleted, or which would have been deleted in a dry
+	// run.
+	Deleted []string
+	// Kept are the matching tags which are kept, as they are recent.
+	Kept []string
+	// Shared are the matching tags which are not deleted, as the image they
+	// refer to is also referred to by a tag which is kept.
+	Shared []string
+}
+
+type gcTag struct {
+	name    string
+	digest  string
+	created time.Time
+}
+
+// GC deletes the matching tags of the repository of the reference, except
+// for the most recent ones. As the registry API deletes images by digest, an
+// image is only deleted if none of the tags which are kept (matching or not)
+// refers to it. The signatures, attestations and SBOMs attached to the images
+// deleted are deleted along with them.
+func (c *Client) GC(ctx context.Context, ref Ref, opt GCOpt) (GCResult, error) {
+	if len(opt.Match) == 0 {
+		return GCResult{}, errors.New("at least one tag pattern to match is required")
+	}
+	tags, err := c.ListTags(ctx, ref)
+	if err != nil {
+		return GCResult{}, errors.Wrapf(err, "list tags of %s/%s", ref.Host, ref.Repo)
+	}
+	protected := make(map[string]bool)
+	var matched []gcTag
+	for _, tag := range tags {
+		m, err := c.GetManifest(ctx, ref.WithReference(tag), AllMediaTypes)
+		if IsNotFound(err) {
+			continue // Deleted concurrently.
+		}
+		if err != nil {
+			return GCResult{}, errors.Wrapf(err, "get manifest of %s", tag)
+		}
+		if !matchAny(opt.Match, tag) {
+			protected[m.Digest] = true
+			continue
+		}
+		created, err := c.created(ctx, ref, m)
+		if err != nil {
+			return GCResult{}, errors.Wrapf(err, "get creation time of %s", tag)
+		}
+		matched = append(matched, gcTag{name: tag, digest: m.Digest, created: created})
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if !matched[i].created.Equal(matched[j].created) {
+			return matched[i].created.After(matched[j].created)
+		}
+		return matched[i].name > matched[j].name
+	})
+	var res GCResult
+	for i, t := range matched {
+		if i < opt.KeepLast {
+			res.Kept = append(res.Kept, t.name)
+			protected[t.digest] = true
+		}
+	}
+	deleted := make(map[string]bool)
+	for _, t := range matched[min(opt.KeepLast, len(matched)):] {
+		if protected[t.digest] {
+			res.Shared = append(res.Shared, t.name)
+			continue
+		}
+		res.Deleted = append(res.Deleted, t.name)
+		if opt.DryRun || deleted[t.digest] {
+			continue
+		}
+		deleted[t.digest] = true
+		err := c.DeleteManifest(ctx, ref, t.digest)
+		if err != nil {
+			return res, errors.Wrapf(err, "delete %s", t.name)
+		}
+		for _, attached := range AttachedTags(t.digest) {
+			dgst, ok, err := c.HeadManifest(ctx, ref.WithReference(attached))
+			if err != nil {
+				return res, errors.Wrapf(err, "check %s", attached)
+			}
+			if ok {
+				err = c.DeleteManifest(ctx, ref, dgst)
+				if err != nil {
+					return res, errors.Wrapf(err, "delete %s", attached)
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+func matchAny(patterns []string, tag string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
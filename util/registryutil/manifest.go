@@ -0,0 +1,299 @@
+package registryutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+const maxManifestSize = 4 << 20
+
+// Media types of manifests.
+const (
+	MediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// ImageMediaTypes are the media types of the manifests of single images.
+var ImageMediaTypes = []string{MediaTypeOCIManifest, MediaTypeDockerManifest}
+
+// AllMediaTypes are the media types of the manifests of single images and of
+// multi-platform images.
+var AllMediaTypes = []string{MediaTypeOCIManifest, MediaTypeDockerManifest, MediaTypeOCIIndex, MediaTypeDockerList}
+
+// Descriptor refers to a blob or a manifest.
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// Manifest is a raw manifest, along with the fields relevant to copying it.
+type Manifest struct {
+	Raw       []byte `json:"-"`
+	MediaType string `json:"mediaType"`
+	// Digest is the digest of Raw.
+	Digest string `json:"-"`
+
+	Config    *Descriptor  `json:"config,omitempty"`
+	Layers    []Descriptor `json:"layers,omitempty"`
+	Manifests []Descriptor `json:"manifests,omitempty"`
+}
+
+// IsIndex returns whether the manifest refers to other manifests, as for
+// multi-platform images.
+func (m Manifest) IsIndex() bool {
+	return m.MediaType == MediaTypeOCIIndex || m.MediaType == MediaTypeDockerList
+}
+
+// Blobs returns the blobs which the manifest refers to.
+func (m Manifest) Blobs() []Descriptor {
+	var blobs []Descriptor
+	if m.Config != nil {
+		blobs = append(blobs, *m.Config)
+	}
+	return append(blobs, m.Layers...)
+}
+
+// GetManifest fetches the manifest of the reference, accepting the given
+// media types.
+func (c *Client) GetManifest(ctx context.Context, ref Ref, accept []string) (Manifest, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodGet,
+		ref:    ref,
+		path:   "manifests/" + ref.Reference,
+		header: http.Header{"Accept": accept},
+		ok:     []int{http.StatusOK},
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer resp.Body.Close()
+	dt, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return Manifest{}, errors.Wrap(err, "read manifest")
+	}
+	m, err := ParseManifest(dt, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return Manifest{}, err
+	}
+	if ref.IsDigest() && m.Digest != ref.Reference {
+		return Manifest{}, errors.Errorf("manifest %s has digest %s", ref.String(), m.Digest)
+	}
+	return m, nil
+}
+
+// ParseManifest parses a raw manifest. The media type within the manifest, if
+// any, takes precedence over contentType.
+func ParseManifest(dt []byte, contentType string) (Manifest, error) {
+	var m Manifest
+	err := json.Unmarshal(dt, &m)
+	if err != nil {
+		return Manifest{}, errors.Wrap(err, "parse manifest")
+	}
+	if m.MediaType == "" {
+		m.MediaType = contentType
+	}
+	m.Raw = dt
+	h := sha256.Sum256(dt)
+	m.Digest = "sha256:" + hex.EncodeToString(h[:])
+	return m, nil
+}
+
+// HeadManifest returns the digest of the manifest of the reference, and false
+// if there is none.
+func (c *Client) HeadManifest(ctx context.Context, ref Ref) (string, bool, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodHead,
+		ref:    ref,
+		path:   "manifests/" + ref.Reference,
+		header: http.Header{"Accept": AllMediaTypes},
+		ok:     []int{http.StatusOK},
+	})
+	if IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	resp.Body.Close()
+	dgst := resp.Header.Get("Docker-Content-Digest")
+	if dgst == "" {
+		// Not all registries return the digest on HEAD requests.
+		m, err := c.GetManifest(ctx, ref, AllMediaTypes)
+		if err != nil {
+			return "", false, err
+		}
+		dgst = m.Digest
+	}
+	return dgst, true, nil
+}
+
+// PutManifest uploads the manifest under the reference.
+func (c *Client) PutManifest(ctx context.Context, ref Ref, m Manifest) error {
+	resp, err := c.do(ctx, request{
+		method: http.MethodPut,
+		ref:    ref,
+		path:   "manifests/" + ref.Reference,
+		header: http.Header{"Content-Type": []string{m.MediaType}},
+		body:   m.Raw,
+		push:   true,
+		ok:     []int{http.StatusCreated, http.StatusOK},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetBlob fetches a blob of the repository of the reference.
+func (c *Client) GetBlob(ctx context.Context, ref Ref, dgst string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodGet,
+		ref:    ref,
+		path:   "blobs/" + dgst,
+		ok:     []int{http.StatusOK},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// BlobExists returns whether the repository of the reference has the blob.
+func (c *Client) BlobExists(ctx context.Context, ref Ref, dgst string) (bool, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodHead,
+		ref:    ref,
+		path:   "blobs/" + dgst,
+		push:   true,
+		ok:     []int{http.StatusOK},
+	})
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// CopyBlob copies a blob from the repository of src to that of dst. Within
+// the same registry, the blob is mounted rather than uploaded, if the
+// registry supports it.
+func (c *Client) CopyBlob(ctx context.Context, src, dst Ref, blob Descriptor) error {
+	q := url.Values{}
+	if src.Host == dst.Host {
+		q.Set("mount", blob.Digest)
+		q.Set("from", src.Repo)
+	}
+	resp, err := c.do(ctx, request{
+		method: http.MethodPost,
+		ref:    dst,
+		path:   "blobs/uploads/?" + q.Encode(),
+		body:   []byte{},
+		push:   true,
+		ok:     []int{http.StatusCreated, http.StatusAccepted},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		return nil // Mounted.
+	}
+	loc, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return errors.Errorf("invalid upload location %q", resp.Header.Get("Location"))
+	}
+	lq := loc.Query()
+	lq.Set("digest", blob.Digest)
+	loc.RawQuery = lq.Encode()
+
+	body, err := c.GetBlob(ctx, src, blob.Digest)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	resp, err = c.do(ctx, request{
+		method: http.MethodPut,
+		ref:    dst,
+		path:   loc.String(),
+		header: http.Header{"Content-Type": []string{"application/octet-stream"}},
+		stream: body,
+		push:   true,
+		ok:     []int{http.StatusCreated},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Copy copies the manifest of src, along with everything it refers to, to the
+// repository of dst, and tags it as per dst. It returns the digest of the
+// manifest, which is the same at both ends.
+func (c *Client) Copy(ctx context.Context, src, dst Ref) (string, error) {
+	m, err := c.GetManifest(ctx, src, AllMediaTypes)
+	if err != nil {
+		return "", errors.Wrapf(err, "get manifest of %s", src.String())
+	}
+	err = c.copyContents(ctx, src, dst, m)
+	if err != nil {
+		return "", err
+	}
+	if dst.Reference != m.Digest {
+		err = c.PutManifest(ctx, dst, m)
+		if err != nil {
+			return "", errors.Wrapf(err, "put manifest %s", dst.String())
+		}
+	}
+	return m.Digest, nil
+}
+
+// copyContents copies what the manifest refers to, and the manifest itself,
+// by digest.
+func (c *Client) copyContents(ctx context.Context, src, dst Ref, m Manifest) error {
+	if m.IsIndex() {
+		for _, desc := range m.Manifests {
+			child, err := c.GetManifest(ctx, src.WithReference(desc.Digest), []string{desc.MediaType})
+			if err != nil {
+				return errors.Wrapf(err, "get manifest %s", desc.Digest)
+			}
+			err = c.copyContents(ctx, src, dst, child)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, blob := range m.Blobs() {
+		ok, err := c.BlobExists(ctx, dst, blob.Digest)
+		if err != nil {
+			return errors.Wrapf(err, "check blob %s", blob.Digest)
+		}
+		if ok {
+			continue
+		}
+		err = c.CopyBlob(ctx, src, dst, blob)
+		if err != nil {
+			return errors.Wrapf(err, "copy blob %s", blob.Digest)
+		}
+	}
+	err := c.PutManifest(ctx, dst.WithReference(m.Digest), m)
+	if err != nil {
+		return errors.Wrapf(err, "put manifest %s", m.Digest)
+	}
+	return nil
+}
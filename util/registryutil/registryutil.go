@@ -0,0 +1,299 @@
+// Package registryutil is a minimal client of the registry HTTP API, as used
+// to fetch OCI artifacts and to copy images between registries without
+// pulling them into a local daemon.
+package registryutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/pkg/errors"
+)
+
+// Ref is a parsed image or artifact reference, as in ghcr.io/org/lib:1.2.0 or
+// ghcr.io/org/lib@sha256:....
+type Ref struct {
+	Host string
+	Repo string
+	// Reference is the tag or the digest.
+	Reference string
+}
+
+// ParseRef parses a reference. The registry host must be explicit.
+func ParseRef(s string) (Ref, error) {
+	slash := strings.Index(s, "/")
+	if slash <= 0 {
+		return Ref{}, errors.Errorf("invalid reference %s: missing registry host", s)
+	}
+	ref := Ref{Host: s[:slash], Reference: "latest"}
+	rest := s[slash+1:]
+	if at := strings.Index(rest, "@"); at >= 0 {
+		ref.Repo, ref.Reference = rest[:at], rest[at+1:]
+	} else if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		ref.Repo, ref.Reference = rest[:colon], rest[colon+1:]
+	} else {
+		ref.Repo = rest
+	}
+	if ref.Repo == "" || ref.Reference == "" {
+		return Ref{}, errors.Errorf("invalid reference %s", s)
+	}
+	return ref, nil
+}
+
+// String returns the reference in its canonical form.
+func (r Ref) String() string {
+	if r.IsDigest() {
+		return r.Host + "/" + r.Repo + "@" + r.Reference
+	}
+	return r.Host + "/" + r.Repo + ":" + r.Reference
+}
+
+// IsDigest returns whether the reference is a digest rather than a tag.
+func (r Ref) IsDigest() bool {
+	return strings.Contains(r.Reference, ":")
+}
+
+// WithReference returns the reference to another tag or digest of the same
+// repository.
+func (r Ref) WithReference(reference string) Ref {
+	r.Reference = reference
+	return r
+}
+
+func (r Ref) apiHost() string {
+	if r.Host == "docker.io" {
+		return "registry-1.docker.io"
+	}
+	return r.Host
+}
+
+// Client performs requests against registries, authenticating with the
+// credentials of the docker config, if the registry asks for them.
+type Client struct {
+	http *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string // host/repo -> authorization header
+}
+
+// NewClient returns a client which uses the given HTTP client, or the default
+// one if nil.
+func NewClient(httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{http: httpClient, tokens: make(map[string]string)}
+}
+
+// request is a request against the API of the repository of a reference.
+type request struct {
+	method string
+	ref    Ref
+	path   string // relative to /v2/<repo>/, or an absolute URL
+	header http.Header
+	body   []byte
+	stream io.Reader // used instead of body; the request cannot be retried
+	push   bool
+	// ok are the expected status codes.
+	ok []int
+}
+
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	u := req.path
+	if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+		u = "https://" + req.ref.apiHost() + "/v2/" + req.ref.Repo + "/" + req.path
+	}
+	for attempt := 0; ; attempt++ {
+		body := req.stream
+		if req.body != nil {
+			body = bytes.NewReader(req.body)
+		}
+		hr, err := http.NewRequestWithContext(ctx, req.method, u, body)
+		if err != nil {
+			return nil, errors.Wrap(err, "new request")
+		}
+		for k, v := range req.header {
+			hr.Header[k] = v
+		}
+		if req.body != nil {
+			hr.ContentLength = int64(len(req.body))
+		}
+		if auth := c.authorization(req.ref, req.push); auth != "" {
+			hr.Header.Set("Authorization", auth)
+		}
+		resp, err := c.http.Do(hr)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && req.stream == nil {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			err = c.authenticate(ctx, req.ref, req.push, challenge)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, code := range req.ok {
+			if resp.StatusCode == code {
+				return resp, nil
+			}
+		}
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return resp, &StatusError{Method: req.method, URL: u, StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(msg))}
+	}
+}
+
+// StatusError is returned when the registry responds with an unexpected
+// status.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body != "" {
+		return e.Method + " " + e.URL + ": unexpected status " + e.Status + ": " + e.Body
+	}
+	return e.Method + " " + e.URL + ": unexpected status " + e.Status
+}
+
+// IsNotFound returns whether the error is a 404 response of the registry.
+func IsNotFound(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.StatusCode == http.StatusNotFound
+}
+
+func tokenKey(ref Ref, push bool) string {
+	key := ref.Host + "/" + ref.Repo
+	if push {
+		key += "#push"
+	}
+	return key
+}
+
+func (c *Client) authorization(ref Ref, push bool) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens[tokenKey(ref, push)]
+}
+
+// authenticate obtains the authorization for the repository as per the given
+// WWW-Authenticate challenge.
+func (c *Client) authenticate(ctx context.Context, ref Ref, push bool, challenge string) error {
+	auth, err := dockerAuth(ref.Host)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(challenge, "Basic ") {
+		if auth == "" {
+			return errors.Errorf("%s requires credentials; log in via docker login", ref.Host)
+		}
+		c.setAuthorization(ref, push, "Basic "+auth)
+		return nil
+	}
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return errors.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	params := make(map[string]string)
+	for _, kv := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		parts := strings.SplitN(strings.TrimSpace(kv), "=", 2)
+		if len(parts) == 2 {
+			params[parts[0]] = strings.Trim(parts[1], `"`)
+		}
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme != "https" {
+		return errors.Errorf("invalid authentication realm %q", params["realm"])
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	actions := "pull"
+	if push {
+		actions = "pull,push"
+	}
+	scope := "repository:" + ref.Repo + ":" + actions
+	if params["scope"] != "" && !push {
+		scope = params["scope"]
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", "Basic "+auth)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("authenticate with %s: unexpected status %s", ref.Host, resp.Status)
+	}
+	var tr struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tr)
+	if err != nil {
+		return errors.Wrap(err, "decode token")
+	}
+	token := tr.Token
+	if token == "" {
+		token = tr.AccessToken
+	}
+	c.setAuthorization(ref, push, "Bearer "+token)
+	return nil
+}
+
+func (c *Client) setAuthorization(ref Ref, push bool, auth string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[tokenKey(ref, push)] = auth
+}
+
+// dockerAuth returns the base64 encoded credentials for host, as per the
+// docker config in $DOCKER_CONFIG (or ~/.docker), or an empty string if there
+// are none. As with buildkit's auth provider, credentials kept by a credsStore
+// or by the credHelpers of the host are used as well.
+func dockerAuth(host string) (string, error) {
+	cfg, err := config.Load(config.Dir())
+	if err != nil {
+		return "", errors.Wrap(err, "load docker config")
+	}
+	return configAuth(cfg, host)
+}
+
+// configAuth returns the base64 encoded credentials for host, as per cfg.
+func configAuth(cfg *configfile.ConfigFile, host string) (string, error) {
+	if host == "docker.io" {
+		host = "https://index.docker.io/v1/"
+	}
+	ac, err := cfg.GetAuthConfig(host)
+	if err != nil {
+		return "", errors.Wrapf(err, "get credentials of %s", host)
+	}
+	if ac.Username == "" && ac.Password == "" {
+		return "", nil
+	}
+	return base64.StdEncoding.EncodeToString([]byte(ac.Username + ":" + ac.Password)), nil
+}
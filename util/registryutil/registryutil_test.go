@@ -0,0 +1,304 @@
+package registryutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/cli/cli/config"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseRef(t *testing.T) {
+	ref, err := ParseRef("ghcr.io/org/lib:1.2.0")
+	NoError(t, err)
+	Equal(t, Ref{Host: "ghcr.io", Repo: "org/lib", Reference: "1.2.0"}, ref)
+	False(t, ref.IsDigest())
+	ref, err = ParseRef("localhost:5000/lib@sha256:abc")
+	NoError(t, err)
+	Equal(t, Ref{Host: "localhost:5000", Repo: "lib", Reference: "sha256:abc"}, ref)
+	True(t, ref.IsDigest())
+	Equal(t, "localhost:5000/lib@sha256:abc", ref.String())
+	ref, err = ParseRef("ghcr.io/org/lib")
+	NoError(t, err)
+	Equal(t, "ghcr.io/org/lib:latest", ref.String())
+	_, err = ParseRef("lib")
+	Error(t, err)
+}
+
+func digestOf(dt []byte) string {
+	h := sha256.Sum256(dt)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+// fakeRegistry is an in-memory registry, which supports cross-repository
+// blob mounts unless noMount is set, and counts the blobs uploaded.
+type fakeRegistry struct {
+	mu        sync.Mutex
+	noMount   bool
+	blobs     map[string][]byte // repo@digest -> content
+	manifests map[string][]byte // repo:reference -> manifest
+	uploads   int
+	mounts    int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{blobs: make(map[string][]byte), manifests: make(map[string][]byte)}
+}
+
+func (fr *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	p := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(p, "/tags/list"):
+		repo := strings.TrimSuffix(p, "/tags/list")
+		tags := []string{}
+		for key := range fr.manifests {
+			if strings.HasPrefix(key, repo+":") && !strings.HasPrefix(key, repo+":sha256:") {
+				tags = append(tags, strings.TrimPrefix(key, repo+":"))
+			}
+		}
+		sort.Strings(tags)
+		// Two tags per page.
+		start := 0
+		if last := r.URL.Query().Get("last"); last != "" {
+			start = sort.SearchStrings(tags, last) + 1
+		}
+		end := start + 2
+		if end < len(tags) {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?last=%s>; rel="next"`, repo, tags[end-1]))
+		} else {
+			end = len(tags)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": repo, "tags": tags[start:end]})
+	case strings.Contains(p, "/manifests/"):
+		parts := strings.SplitN(p, "/manifests/", 2)
+		key := parts[0] + ":" + parts[1]
+		switch r.Method {
+		case http.MethodDelete:
+			for k, dt := range fr.manifests {
+				if strings.HasPrefix(k, parts[0]+":") && digestOf(dt) == parts[1] {
+					delete(fr.manifests, k)
+				}
+			}
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPut:
+			dt, _ := ioutil.ReadAll(r.Body)
+			fr.manifests[key] = dt
+			fr.manifests[parts[0]+":"+digestOf(dt)] = dt
+			w.WriteHeader(http.StatusCreated)
+		default:
+			dt, ok := fr.manifests[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", digestOf(dt))
+			w.Header().Set("Content-Type", MediaTypeOCIManifest)
+			if r.Method == http.MethodGet {
+				w.Write(dt)
+			}
+		}
+	case strings.HasSuffix(p, "/blobs/uploads/"):
+		repo := strings.TrimSuffix(p, "/blobs/uploads/")
+		if from, dgst := r.URL.Query().Get("from"), r.URL.Query().Get("mount"); from != "" && !fr.noMount {
+			if dt, ok := fr.blobs[from+"@"+dgst]; ok {
+				fr.blobs[repo+"@"+dgst] = dt
+				fr.mounts++
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		w.Header().Set("Location", "/upload/"+repo+"?session=1")
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(r.URL.Path, "/upload/"):
+		repo := strings.TrimPrefix(r.URL.Path, "/upload/")
+		dt, _ := ioutil.ReadAll(r.Body)
+		if digestOf(dt) != r.URL.Query().Get("digest") || r.URL.Query().Get("session") != "1" {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		fr.blobs[repo+"@"+digestOf(dt)] = dt
+		fr.uploads++
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/blobs/"):
+		parts := strings.SplitN(p, "/blobs/", 2)
+		dt, ok := fr.blobs[parts[0]+"@"+parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Write(dt)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (fr *fakeRegistry) addImage(repo, tag string, layers ...string) string {
+	return fr.addImageCreated(repo, tag, "2021-01-01T00:00:00Z", layers...)
+}
+
+func (fr *fakeRegistry) addImageCreated(repo, tag, created string, layers ...string) string {
+	config := []byte(`{"architecture":"amd64","os":"linux","created":"` + created + `"}`)
+	fr.blobs[repo+"@"+digestOf(config)] = config
+	var descs []string
+	for _, l := range layers {
+		fr.blobs[repo+"@"+digestOf([]byte(l))] = []byte(l)
+		descs = append(descs, fmt.Sprintf(`{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"%s","size":%d}`, digestOf([]byte(l)), len(l)))
+	}
+	m := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"%s","size":%d},"layers":[%s]}`,
+		MediaTypeOCIManifest, digestOf(config), len(config), strings.Join(descs, ",")))
+	fr.manifests[repo+":"+tag] = m
+	fr.manifests[repo+":"+digestOf(m)] = m
+	return digestOf(m)
+}
+
+func TestCopy(t *testing.T) {
+	fr := newFakeRegistry()
+	ts := httptest.NewTLSServer(fr)
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "https://")
+	dgst := fr.addImage("staging/app", "1.0", "layer-a", "layer-b")
+	c := NewClient(ts.Client())
+	ctx := context.Background()
+
+	// Within the same registry, blobs are mounted.
+	src := Ref{Host: host, Repo: "staging/app", Reference: "1.0"}
+	dst := Ref{Host: host, Repo: "prod/app", Reference: "1.0"}
+	copied, err := c.Copy(ctx, src, dst)
+	NoError(t, err)
+	Equal(t, dgst, copied)
+	Equal(t, 3, fr.mounts)
+	Equal(t, 0, fr.uploads)
+	actual, ok, err := c.HeadManifest(ctx, dst)
+	NoError(t, err)
+	True(t, ok)
+	Equal(t, dgst, actual)
+
+	// Blobs which already exist are skipped, and others are uploaded.
+	delete(fr.blobs, "prod/app@"+digestOf([]byte("layer-b")))
+	fr.mounts = 0
+	fr.noMount = true
+	_, err = c.Copy(ctx, src, dst)
+	NoError(t, err)
+	Equal(t, 0, fr.mounts)
+	Equal(t, 1, fr.uploads)
+
+	_, ok, err = c.HeadManifest(ctx, dst.WithReference("missing"))
+	NoError(t, err)
+	False(t, ok)
+	_, err = c.GetManifest(ctx, src.WithReference("sha256:"+strings.Repeat("0", 64)), AllMediaTypes)
+	Error(t, err)
+}
+
+func TestCopyAttached(t *testing.T) {
+	fr := newFakeRegistry()
+	ts := httptest.NewTLSServer(fr)
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "https://")
+	dgst := fr.addImage("staging/app", "1.0", "layer-a")
+	Equal(t, []string{
+		strings.Replace(dgst, ":", "-", 1) + ".sig",
+		strings.Replace(dgst, ":", "-", 1) + ".att",
+		strings.Replace(dgst, ":", "-", 1) + ".sbom",
+	}, AttachedTags(dgst))
+	sigTag := AttachedTags(dgst)[0]
+	fr.addImage("staging/app", sigTag, "signature")
+	c := NewClient(ts.Client())
+	ctx := context.Background()
+
+	src := Ref{Host: host, Repo: "staging/app", Reference: dgst}
+	dst := Ref{Host: host, Repo: "prod/app", Reference: dgst}
+	copied, err := c.CopyAttached(ctx, src, dst, dgst, false)
+	NoError(t, err)
+	Equal(t, []string{sigTag}, copied)
+
+	// Existing attachments are not overwritten, unless forced.
+	fr.addImage("staging/app", sigTag, "another signature")
+	_, err = c.CopyAttached(ctx, src, dst, dgst, false)
+	Error(t, err)
+	copied, err = c.CopyAttached(ctx, src, dst, dgst, true)
+	NoError(t, err)
+	Equal(t, []string{sigTag}, copied)
+}
+
+func TestGC(t *testing.T) {
+	fr := newFakeRegistry()
+	ts := httptest.NewTLSServer(fr)
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "https://")
+	fr.addImageCreated("org/app", "dev-a", "2021-01-01T00:00:00Z", "a")
+	old := fr.addImageCreated("org/app", "dev-b", "2021-01-02T00:00:00Z", "b")
+	fr.addImage("org/app", AttachedTags(old)[0], "signature of b")
+	fr.addImageCreated("org/app", "dev-c", "2021-01-03T00:00:00Z", "c")
+	fr.addImageCreated("org/app", "dev-d", "2021-01-04T00:00:00Z", "d")
+	fr.addImageCreated("org/app", "1.0", "2021-01-01T00:00:00Z", "a")
+	c := NewClient(ts.Client())
+	ctx := context.Background()
+	ref := Ref{Host: host, Repo: "org/app"}
+
+	tags, err := c.ListTags(ctx, ref)
+	NoError(t, err)
+	Equal(t, 6, len(tags))
+
+	opt := GCOpt{Match: []string{"dev-*"}, KeepLast: 2, DryRun: true}
+	res, err := c.GC(ctx, ref, opt)
+	NoError(t, err)
+	Equal(t, GCResult{Deleted: []string{"dev-b"}, Kept: []string{"dev-d", "dev-c"}, Shared: []string{"dev-a"}}, res)
+	tags, err = c.ListTags(ctx, ref)
+	NoError(t, err)
+	Equal(t, 6, len(tags))
+
+	opt.DryRun = false
+	_, err = c.GC(ctx, ref, opt)
+	NoError(t, err)
+	tags, err = c.ListTags(ctx, ref)
+	NoError(t, err)
+	Equal(t, []string{"1.0", "dev-a", "dev-c", "dev-d"}, tags)
+
+	_, err = c.GC(ctx, ref, GCOpt{})
+	Error(t, err)
+}
+
+func TestConfigAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registryutil")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	// A credential helper which knows only of the credentials of helped.example.com.
+	helper := "#!/bin/sh\nread host\n[ \"$host\" = helped.example.com ] || { echo 'credentials not found in native keychain'; exit 1; }\necho '{\"Username\":\"helper\",\"Secret\":\"s3cret\"}'\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(helper), 0755)
+	NoError(t, err)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	inline := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	cfgJSON := fmt.Sprintf(`{"auths":{"https://index.docker.io/v1/":{"auth":"%s"}},"credHelpers":{"helped.example.com":"fake"}}`, inline)
+	err = ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(cfgJSON), 0644)
+	NoError(t, err)
+	cfg, err := config.Load(dir)
+	NoError(t, err)
+
+	auth, err := configAuth(cfg, "docker.io")
+	NoError(t, err)
+	Equal(t, inline, auth)
+	auth, err = configAuth(cfg, "helped.example.com")
+	NoError(t, err)
+	Equal(t, base64.StdEncoding.EncodeToString([]byte("helper:s3cret")), auth)
+	auth, err = configAuth(cfg, "other.example.com")
+	NoError(t, err)
+	Equal(t, "", auth)
+}
@@ -0,0 +1,55 @@
+package retryutil
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Policy describes how often, and how patiently, an operation is retried.
+type Policy struct {
+	// Retries is the number of times the operation is retried after the first
+	// failed attempt.
+	Retries int
+	// Delay is the wait before the first retry. It doubles for each subsequent retry.
+	Delay time.Duration
+	// OnRetry, if set, is called before waiting for each retry.
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// Do executes fn, retrying it according to the policy for as long as it fails
+// with an error for which retryable returns true. A nil retryable retries all
+// errors. The error of the last attempt is returned.
+func (p Policy) Do(ctx context.Context, retryable func(error) bool, fn func(context.Context) error) error {
+	delay := p.Delay
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt > p.Retries || ctx.Err() != nil {
+			return err
+		}
+		if retryable != nil && !retryable(err) {
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, delay)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// IsTransientRegistryError returns false for registry errors which are
+// certain to occur again when retried.
+func IsTransientRegistryError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, permanent := range []string{"not found", "manifest unknown", "unauthorized", "denied", "invalid reference"} {
+		if strings.Contains(msg, permanent) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package retryutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestDoRetriesUntilSuccess(t *testing.T) {
+	var delays []time.Duration
+	p := Policy{
+		Retries: 3,
+		Delay:   time.Millisecond,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			delays = append(delays, delay)
+		},
+	}
+	calls := 0
+	err := p.Do(context.Background(), nil, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+	NoError(t, err)
+	Equal(t, 3, calls)
+	Equal(t, []time.Duration{time.Millisecond, 2 * time.Millisecond}, delays)
+}
+
+func TestDoGivesUp(t *testing.T) {
+	p := Policy{Retries: 2, Delay: time.Millisecond}
+	calls := 0
+	err := p.Do(context.Background(), nil, func(ctx context.Context) error {
+		calls++
+		return errors.New("transient")
+	})
+	Error(t, err)
+	Equal(t, 3, calls)
+}
+
+func TestDoNotRetryable(t *testing.T) {
+	p := Policy{Retries: 5, Delay: time.Millisecond}
+	calls := 0
+	permanent := errors.New("permanent")
+	err := p.Do(context.Background(), func(err error) bool { return err != permanent }, func(ctx context.Context) error {
+		calls++
+		return permanent
+	})
+	Equal(t, permanent, err)
+	Equal(t, 1, calls)
+}
+
+func TestIsTransientRegistryError(t *testing.T) {
+	True(t, IsTransientRegistryError(errors.New("failed to do request: Put https://reg.example.com/v2/app/blobs/uploads/: EOF")))
+	True(t, IsTransientRegistryError(errors.New("unexpected status: 503 Service Unavailable")))
+	False(t, IsTransientRegistryError(errors.New("server message: insufficient_scope: authorization failed: denied")))
+	False(t, IsTransientRegistryError(errors.New("docker.io/library/nope:latest: not found")))
+}
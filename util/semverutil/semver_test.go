@@ -0,0 +1,38 @@
+package semverutil
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.2.3", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0-alpha", "1.0.0-1", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0+build.5", "1.0.0", 0},
+	} {
+		a, err := Parse(tc.a)
+		NoError(t, err, tc.a)
+		b, err := Parse(tc.b)
+		NoError(t, err, tc.b)
+		Equal(t, tc.expected, Compare(a, b), "%s vs %s", tc.a, tc.b)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{"", "latest", "1.2.3.4", "v", "1.x"} {
+		_, err := Parse(s)
+		Error(t, err, s)
+	}
+}
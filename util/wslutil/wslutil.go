@@ -0,0 +1,118 @@
+// Package wslutil detects WSL2 setups, and translates paths between Windows
+// and WSL2 distributions.
+package wslutil
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+var (
+	windowsPathRegexp = regexp.MustCompile(`^([a-zA-Z]):[\\/]`)
+	mountPathRegexp   = regexp.MustCompile(`^/mnt/([a-z])(/|$)`)
+	uncPathRegexp     = regexp.MustCompile(`(?i)^(\\\\|//)(wsl\$|wsl\.localhost)[\\/]`)
+)
+
+// IsWSL returns whether this process runs in a WSL2 distribution.
+func IsWSL() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	dt, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	return err == nil && isWSLKernel(string(dt))
+}
+
+func isWSLKernel(release string) bool {
+	return strings.Contains(strings.ToLower(release), "microsoft")
+}
+
+// ToWSLPath translates a Windows path, as in C:\Users\me, to the path of the
+// same file in WSL2 distributions, as in /mnt/c/Users/me. Other paths are
+// returned as they are, along with false.
+func ToWSLPath(p string) (string, bool) {
+	m := windowsPathRegexp.FindStringSubmatch(p)
+	if m == nil {
+		return p, false
+	}
+	rest := strings.ReplaceAll(p[len(m[0]):], `\`, "/")
+	return "/mnt/" + strings.ToLower(m[1]) + "/" + rest, true
+}
+
+// ToWindowsPath translates the path of a Windows drive in WSL2 distributions,
+// as in /mnt/c/Users/me, to the corresponding Windows path, as in
+// C:\Users\me. Other paths are returned as they are, along with false.
+func ToWindowsPath(p string) (string, bool) {
+	m := mountPathRegexp.FindStringSubmatch(p)
+	if m == nil {
+		return p, false
+	}
+	rest := strings.ReplaceAll(strings.TrimPrefix(p[len("/mnt/")+1:], "/"), "/", `\`)
+	return strings.ToUpper(m[1]) + `:\` + rest, true
+}
+
+// HostPath translates a path given in the convention of the other side of a
+// WSL2 setup to the one of this process: Windows paths in WSL2 distributions,
+// and paths of Windows drives in WSL2 distributions on Windows. Other paths
+// are returned as they are.
+func HostPath(p string) string {
+	switch {
+	case runtime.GOOS == "windows":
+		p, _ = ToWindowsPath(p)
+	case IsWSL():
+		p, _ = ToWSLPath(p)
+	}
+	return p
+}
+
+// HostRef translates the path of a target or artifact reference, as in
+// C:\project+build, as per HostPath.
+func HostRef(ref string) string {
+	i := strings.Index(ref, "+")
+	if i <= 0 {
+		return ref
+	}
+	return HostPath(ref[:i]) + ref[i:]
+}
+
+// IsWindowsMount returns whether a path of a WSL2 distribution is on a
+// Windows drive, which is shared with the distribution via a slow network
+// filesystem, and whose files are typically checked out by Windows tools.
+func IsWindowsMount(p string) bool {
+	return mountPathRegexp.MatchString(p)
+}
+
+// IsWSLSharePath returns whether a Windows path is in the filesystem of a WSL2
+// distribution, as in \\wsl$\Ubuntu\home\me, which is shared with Windows via
+// a slow network filesystem.
+func IsWSLSharePath(p string) bool {
+	return uncPathRegexp.MatchString(p)
+}
+
+// DaemonIsWSL returns whether docker talks to a docker daemon running in a
+// WSL2 distribution directly, rather than via Docker Desktop (which
+// translates Windows paths itself). The bind mounts of such a daemon need the
+// paths of Windows files in the distribution.
+func DaemonIsWSL(ctx context.Context) bool {
+	cmd := exec.CommandContext(ctx, "docker", "info", "--format={{.KernelVersion}}|{{.OperatingSystem}}")
+	output, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+	return isWSLDaemon(string(output))
+}
+
+func isWSLDaemon(info string) bool {
+	parts := strings.SplitN(strings.TrimSpace(info), "|", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	return isWSLKernel(parts[0]) && !strings.Contains(parts[1], "Docker Desktop")
+}
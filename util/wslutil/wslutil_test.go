@@ -0,0 +1,54 @@
+package wslutil
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestToWSLPath(t *testing.T) {
+	p, ok := ToWSLPath(`C:\Users\me\project`)
+	True(t, ok)
+	Equal(t, "/mnt/c/Users/me/project", p)
+
+	p, ok = ToWSLPath("d:/out")
+	True(t, ok)
+	Equal(t, "/mnt/d/out", p)
+
+	p, ok = ToWSLPath("./out")
+	False(t, ok)
+	Equal(t, "./out", p)
+}
+
+func TestToWindowsPath(t *testing.T) {
+	p, ok := ToWindowsPath("/mnt/c/Users/me/project")
+	True(t, ok)
+	Equal(t, `C:\Users\me\project`, p)
+
+	p, ok = ToWindowsPath("/mnt/d")
+	True(t, ok)
+	Equal(t, `D:\`, p)
+
+	p, ok = ToWindowsPath("/mnt/data/project")
+	False(t, ok)
+	Equal(t, "/mnt/data/project", p)
+}
+
+func TestDetection(t *testing.T) {
+	True(t, IsWindowsMount("/mnt/c/Users/me"))
+	False(t, IsWindowsMount("/home/me"))
+	True(t, IsWSLSharePath(`\\wsl$\Ubuntu\home\me`))
+	True(t, IsWSLSharePath(`\\wsl.localhost\Ubuntu\home\me`))
+	False(t, IsWSLSharePath(`C:\Users\me`))
+
+	True(t, isWSLKernel("5.15.90.1-microsoft-standard-WSL2"))
+	False(t, isWSLKernel("5.15.0-76-generic"))
+	True(t, isWSLDaemon("5.15.90.1-microsoft-standard-WSL2|Ubuntu 22.04.2 LTS\n"))
+	False(t, isWSLDaemon("5.15.90.1-microsoft-standard-WSL2|Docker Desktop\n"))
+	False(t, isWSLDaemon("5.15.0-76-generic|Ubuntu 22.04.2 LTS"))
+}
+
+func TestHostRef(t *testing.T) {
+	Equal(t, "+build", HostRef("+build"))
+	Equal(t, "github.com/earthly/earthly+build", HostRef("github.com/earthly/earthly+build"))
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/containerd/containerd/platforms"
 	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/features"
 	"github.com/earthly/earthly/states/dedup"
 	"github.com/earthly/earthly/util/gitutil"
 	"github.com/earthly/earthly/util/llbutil"
@@ -14,7 +15,7 @@ import (
 )
 
 // BuiltinArgs returns a scope containing the builtin args.
-func BuiltinArgs(target domain.Target, platform specs.Platform, gitMeta *gitutil.GitMetadata) *Scope {
+func BuiltinArgs(target domain.Target, platform specs.Platform, gitMeta *gitutil.GitMetadata, ftrs *features.Features) *Scope {
 	ret := NewScope()
 	ret.AddInactive("EARTHLY_TARGET", target.StringCanonical())
 	ret.AddInactive("EARTHLY_TARGET_PROJECT", target.ProjectCanonical())
@@ -44,6 +45,14 @@ func BuiltinArgs(target domain.Target, platform specs.Platform, gitMeta *gitutil
 		ret.AddInactive("EARTHLY_GIT_PROJECT_NAME", getProjectName(gitMeta.RemoteURL))
 		ret.AddInactive("EARTHLY_GIT_COMMIT_TIMESTAMP", gitMeta.Timestamp)
 	}
+	if ftrs != nil && ftrs.Project != "" {
+		ret.AddInactive("EARTHLY_PROJECT", ftrs.Project)
+		ret.AddInactive("EARTHLY_PROJECT_ORG", ftrs.ProjectOrg())
+		ret.AddInactive("EARTHLY_PROJECT_NAME", ftrs.ProjectName())
+	}
+	if ftrs != nil && ftrs.Registry != "" {
+		ret.AddInactive("EARTHLY_PROJECT_REGISTRY", ftrs.Registry)
+	}
 	// Note: Please update targetinput.go BuiltinVariables if adding more builtin variables.
 	for _, key := range ret.SortedAny() {
 		if !dedup.BuiltinVariables[key] {
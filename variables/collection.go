@@ -6,6 +6,7 @@ import (
 
 	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/features"
 	"github.com/earthly/earthly/util/gitutil"
 
 	dfShell "github.com/moby/buildkit/frontend/dockerfile/shell"
@@ -42,9 +43,9 @@ type Collection struct {
 }
 
 // NewCollection creates a new Collection to be used in the context of a target.
-func NewCollection(console conslogging.ConsoleLogger, target domain.Target, platform specs.Platform, gitMeta *gitutil.GitMetadata, overridingVars *Scope, globalImports map[string]domain.ImportTrackerVal) *Collection {
+func NewCollection(console conslogging.ConsoleLogger, target domain.Target, platform specs.Platform, gitMeta *gitutil.GitMetadata, ftrs *features.Features, overridingVars *Scope, globalImports map[string]domain.ImportTrackerVal) *Collection {
 	return &Collection{
-		builtin: BuiltinArgs(target, platform, gitMeta),
+		builtin: BuiltinArgs(target, platform, gitMeta, ftrs),
 		envs:    NewScope(),
 		stack: []*stackFrame{{
 			frameName:  target.StringCanonical(),
@@ -110,6 +111,19 @@ func (c *Collection) SortedActiveVariables() []string {
 	return c.effective().SortedActive()
 }
 
+// SortedDeclaredArgs returns the names of the args and global args declared
+// in the current frame, in a sorted slice. Builtin args are not included.
+func (c *Collection) SortedDeclaredArgs() []string {
+	var ret []string
+	for _, name := range CombineScopes(c.args(), c.globals()).SortedActive() {
+		if _, isBuiltin := c.builtin.GetAny(name); isBuiltin {
+			continue
+		}
+		ret = append(ret, name)
+	}
+	return ret
+}
+
 // SortedOverridingVariables returns the overriding variable names in a sorted slice.
 func (c *Collection) SortedOverridingVariables() []string {
 	return c.overriding().SortedAny()
@@ -58,3 +58,40 @@ func ParseFlagArgsWithNonFlags(args []string) ([]string, []string, error) {
 	}
 	return flags, nonFlags, nil
 }
+
+// ParseFlagArgsPerNonFlag is like ParseFlagArgsWithNonFlags, except that the flag args are
+// grouped with the non-flag arg which precedes them. e.g. "arg1 --flag1=value arg2 --flag2=value"
+// results in the groups ["flag1=value"] and ["flag2=value"]. Any flag args preceding the first
+// non-flag arg are grouped with it. If there are no non-flag args, a single group is returned.
+func ParseFlagArgsPerNonFlag(args []string) ([]string, [][]string, error) {
+	var segments [][]string
+	start := 0
+	seenNonFlag := false
+	valueFromNext := false
+	for i, arg := range args {
+		switch {
+		case valueFromNext:
+			valueFromNext = false
+		case strings.HasPrefix(arg, "-"):
+			_, _, hasValue := ParseKeyValue(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"))
+			valueFromNext = !hasValue
+		case seenNonFlag:
+			segments = append(segments, args[start:i])
+			start = i
+		default:
+			seenNonFlag = true
+		}
+	}
+	segments = append(segments, args[start:])
+	nonFlags := make([]string, 0, len(segments))
+	groups := make([][]string, 0, len(segments))
+	for _, segment := range segments {
+		flags, segmentNonFlags, err := ParseFlagArgsWithNonFlags(segment)
+		if err != nil {
+			return nil, nil, err
+		}
+		nonFlags = append(nonFlags, segmentNonFlags...)
+		groups = append(groups, flags)
+	}
+	return nonFlags, groups, nil
+}
@@ -70,3 +70,29 @@ func TestParseFlagArgsWithNonFlags(t *testing.T) {
 		Equal(t, nonFlags, tt.nonFlags)
 	}
 }
+
+func TestParseFlagArgsPerNonFlag(t *testing.T) {
+	var tests = []struct {
+		kvFlag   []string
+		nonFlags []string
+		groups   [][]string
+	}{
+		{[]string{}, []string{}, [][]string{{}}},
+		{[]string{"--flag=foo"}, []string{}, [][]string{{"flag=foo"}}},
+		{[]string{"arg", "--flag=foo"}, []string{"arg"}, [][]string{{"flag=foo"}}},
+		{[]string{"--flag=foo", "arg", "--flag2=bar"}, []string{"arg"}, [][]string{{"flag=foo", "flag2=bar"}}},
+		{[]string{"arg", "--flag=foo", "arg2", "--flag2=bar"}, []string{"arg", "arg2"}, [][]string{{"flag=foo"}, {"flag2=bar"}}},
+		{[]string{"arg", "--flag", "arg2", "arg3"}, []string{"arg", "arg3"}, [][]string{{"flag=arg2"}, {}}},
+		{[]string{"arg", "arg2", "-flag", "foo"}, []string{"arg", "arg2"}, [][]string{{}, {"flag=foo"}}},
+	}
+
+	for _, tt := range tests {
+		nonFlags, groups, err := ParseFlagArgsPerNonFlag(tt.kvFlag)
+		NoError(t, err)
+		Equal(t, tt.nonFlags, nonFlags)
+		Equal(t, tt.groups, groups)
+	}
+
+	_, _, err := ParseFlagArgsPerNonFlag([]string{"arg", "--flag"})
+	Error(t, err)
+}
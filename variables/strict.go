@@ -0,0 +1,150 @@
+package variables
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// References returns the names of the variables referenced in the word, as in
+// $NAME or ${NAME}, in the order they appear. References with a default or
+// alternative value, as in ${NAME:-default}, are not returned, as they
+// handle undefined variables explicitly. As when expanding the word, nothing
+// within single quotes or escaped via \ is a reference.
+func References(word string) []string {
+	var refs []string
+	inSingle := false
+	for i := 0; i < len(word); i++ {
+		ch := word[i]
+		switch {
+		case inSingle:
+			if ch == '\'' {
+				inSingle = false
+			}
+		case ch == '\'':
+			inSingle = true
+		case ch == '\\':
+			i++
+		case ch == '$' && i+1 < len(word) && word[i+1] == '{':
+			end := strings.IndexByte(word[i+2:], '}')
+			if end == -1 {
+				return refs
+			}
+			inner := word[i+2 : i+2+end]
+			if isName(inner) {
+				refs = append(refs, inner)
+			}
+			i += 2 + end
+		case ch == '$':
+			j := i + 1
+			for j < len(word) && isNameChar(word[j]) {
+				j++
+			}
+			if j > i+1 {
+				refs = append(refs, word[i+1:j])
+			}
+			i = j - 1
+		}
+	}
+	return refs
+}
+
+func isNameChar(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
+
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckDefined returns an error listing the variables referenced in the word
+// which are not defined in the current scope (ARGs which have not been
+// declared, or ENVs which have not been set), along with suggestions of
+// defined variables with similar names.
+func (c *Collection) CheckDefined(word string) error {
+	active := c.effective().ActiveValueMap()
+	var undefined []string
+	seen := make(map[string]bool)
+	for _, ref := range References(word) {
+		if _, ok := active[ref]; ok || seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		undefined = append(undefined, ref)
+	}
+	if len(undefined) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(active))
+	for name := range active {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, 0, len(undefined))
+	for _, name := range undefined {
+		msg := fmt.Sprintf("$%s", name)
+		if suggestion := suggest(name, names); suggestion != "" {
+			msg = fmt.Sprintf("%s (did you mean $%s?)", msg, suggestion)
+		}
+		msgs = append(msgs, msg)
+	}
+	return errors.Errorf("undefined ARG or ENV referenced in %q: %s", word, strings.Join(msgs, ", "))
+}
+
+// suggest returns the name closest to the given one, if it is close enough to
+// be a likely typo.
+func suggest(name string, names []string) string {
+	best := ""
+	bestDist := len(name)/3 + 1
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return n
+		}
+		d := editDistance(n, name)
+		if d <= bestDist && (best == "" || d < editDistance(best, name)) {
+			best = n
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,32 @@
+package variables
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestReferences(t *testing.T) {
+	var tests = []struct {
+		word string
+		refs []string
+	}{
+		{"plain", nil},
+		{"$FOO", []string{"FOO"}},
+		{"${FOO}/bar-$BAR_2.txt", []string{"FOO", "BAR_2"}},
+		{"${FOO:-default} ${BAR:+alt}", nil},
+		{`'$FOO' "$BAR" \$BAZ`, []string{"BAR"}},
+		{"$(echo hi) $ 5$", nil},
+	}
+	for _, tt := range tests {
+		Equal(t, tt.refs, References(tt.word), tt.word)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	names := []string{"GO_VERSION", "TAG", "VERSION"}
+	Equal(t, "VERSION", suggest("VERISON", names))
+	Equal(t, "GO_VERSION", suggest("GOVERSION", names))
+	Equal(t, "TAG", suggest("tag", names))
+	Equal(t, "", suggest("PLATFORM", names))
+}
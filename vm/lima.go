@@ -0,0 +1,107 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+type limaImage struct {
+	Location string `yaml:"location"`
+	Arch     string `yaml:"arch"`
+}
+
+type limaMount struct {
+	Location string `yaml:"location"`
+	Writable bool   `yaml:"writable,omitempty"`
+}
+
+type limaContainerd struct {
+	System bool `yaml:"system"`
+	User   bool `yaml:"user"`
+}
+
+type limaScript struct {
+	Mode   string `yaml:"mode,omitempty"`
+	Script string `yaml:"script"`
+	Hint   string `yaml:"hint,omitempty"`
+}
+
+type limaPortForward struct {
+	GuestSocket string `yaml:"guestSocket"`
+	HostSocket  string `yaml:"hostSocket"`
+}
+
+type limaConfig struct {
+	VMType       string            `yaml:"vmType"`
+	MountType    string            `yaml:"mountType,omitempty"`
+	CPUs         int               `yaml:"cpus"`
+	Memory       string            `yaml:"memory"`
+	Disk         string            `yaml:"disk"`
+	Images       []limaImage       `yaml:"images"`
+	Mounts       []limaMount       `yaml:"mounts"`
+	Containerd   limaContainerd    `yaml:"containerd"`
+	Provision    []limaScript      `yaml:"provision"`
+	Probes       []limaScript      `yaml:"probes"`
+	PortForwards []limaPortForward `yaml:"portForwards"`
+}
+
+// provisionScript installs docker in the VM, and lets the user of lima use
+// its socket, so that it can be forwarded to the host.
+const provisionScript = `#!/bin/sh
+set -eux
+if ! command -v docker >/dev/null 2>&1; then
+    export DEBIAN_FRONTEND=noninteractive
+    curl -fsSL https://get.docker.com | sh
+fi
+usermod -aG docker "${LIMA_CIDATA_USER}"
+systemctl enable --now docker
+`
+
+const probeScript = `#!/bin/bash
+set -eux -o pipefail
+if ! timeout 300s bash -c "until docker version >/dev/null 2>&1; do sleep 3; done"; then
+    echo >&2 "docker is not running yet"
+    exit 1
+fi
+`
+
+// limaConfigYAML returns the lima config the VM is created with. The home
+// directory of the user is shared read-only, except for ~/.earthly, whose
+// certificates are mounted into buildkitd. Build contexts need no sharing, as
+// they are sent to buildkitd via its session. The cache of buildkitd is kept
+// in a docker volume, on the disk of the VM.
+func limaConfigYAML(cfg Config) ([]byte, error) {
+	lc := limaConfig{
+		VMType: cfg.Type,
+		CPUs:   cfg.CPUs,
+		Memory: fmt.Sprintf("%dGiB", cfg.MemoryGB),
+		Disk:   fmt.Sprintf("%dGiB", cfg.DiskGB),
+		Images: []limaImage{
+			{Location: "https://cloud-images.ubuntu.com/releases/22.04/release/ubuntu-22.04-server-cloudimg-amd64.img", Arch: "x86_64"},
+			{Location: "https://cloud-images.ubuntu.com/releases/22.04/release/ubuntu-22.04-server-cloudimg-arm64.img", Arch: "aarch64"},
+		},
+		Mounts: []limaMount{
+			{Location: "~"},
+			{Location: "~/.earthly", Writable: true},
+		},
+		Provision: []limaScript{{Mode: "system", Script: provisionScript}},
+		Probes: []limaScript{{
+			Script: probeScript,
+			Hint:   "See /var/log/cloud-init-output.log in the VM (limactl shell earthly)",
+		}},
+		PortForwards: []limaPortForward{{
+			GuestSocket: "/var/run/docker.sock",
+			HostSocket:  "{{.Dir}}/sock/docker.sock",
+		}},
+	}
+	if cfg.Type == TypeVZ {
+		lc.MountType = "virtiofs"
+	}
+	dt, err := yaml.Marshal(lc)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal vm config")
+	}
+	return dt, nil
+}
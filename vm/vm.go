@@ -0,0 +1,257 @@
+// Package vm manages the Linux VM which runs docker, and in turn buildkitd,
+// on macOS hosts without Docker Desktop. The VM is a lima instance, using
+// Virtualization.framework (vz) or qemu, whose docker socket is forwarded to
+// the host.
+package vm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Name is the name of the lima instance of the VM.
+const Name = "earthly"
+
+// The statuses of the VM.
+const (
+	StatusRunning = "Running"
+	StatusStopped = "Stopped"
+	// StatusMissing is the status of a VM which has not been created yet.
+	StatusMissing = "Missing"
+)
+
+// The types of VMs.
+const (
+	TypeVZ   = "vz"
+	TypeQEMU = "qemu"
+)
+
+// ErrLimaNotInstalled is returned when limactl cannot be found.
+var ErrLimaNotInstalled = errors.New("limactl not found: install lima (e.g. brew install lima) to use the earthly VM")
+
+// Config is the configuration of the VM.
+type Config struct {
+	// Type is the type of the VM: vz (Virtualization.framework, macOS 13 and
+	// later) or qemu.
+	Type     string
+	CPUs     int
+	MemoryGB int
+	DiskGB   int
+}
+
+// DefaultConfig returns the configuration of the VM when none is set: half of
+// the CPUs of the host, 8GB of memory and a 100GB disk.
+func DefaultConfig() Config {
+	cpus := runtime.NumCPU() / 2
+	if cpus < 2 {
+		cpus = 2
+	}
+	return Config{Type: TypeVZ, CPUs: cpus, MemoryGB: 8, DiskGB: 100}
+}
+
+// WithDefaults returns the configuration, with the settings which are not set
+// taken from DefaultConfig.
+func (c Config) WithDefaults() Config {
+	d := DefaultConfig()
+	if c.Type == "" {
+		c.Type = d.Type
+	}
+	if c.CPUs <= 0 {
+		c.CPUs = d.CPUs
+	}
+	if c.MemoryGB <= 0 {
+		c.MemoryGB = d.MemoryGB
+	}
+	if c.DiskGB <= 0 {
+		c.DiskGB = d.DiskGB
+	}
+	return c
+}
+
+// Validate returns an error if the configuration is invalid.
+func (c Config) Validate() error {
+	if c.Type != TypeVZ && c.Type != TypeQEMU {
+		return errors.Errorf("invalid vm type %s: expected %s or %s", c.Type, TypeVZ, TypeQEMU)
+	}
+	return nil
+}
+
+// Instance is the state of the VM, as listed by limactl.
+type Instance struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Dir    string `json:"dir"`
+	VMType string `json:"vmType"`
+	CPUs   int    `json:"cpus"`
+	// Memory and Disk are in bytes.
+	Memory int64 `json:"memory"`
+	Disk   int64 `json:"disk"`
+}
+
+// DockerHost returns the address of the docker socket of the VM, as forwarded
+// to the host.
+func (i *Instance) DockerHost() string {
+	return fmt.Sprintf("unix://%s", filepath.Join(i.Dir, "sock", "docker.sock"))
+}
+
+// Get returns the state of the VM. Its status is StatusMissing if it has not
+// been created.
+func Get(ctx context.Context) (*Instance, error) {
+	output, err := limactl(ctx, "list", "--json")
+	if err != nil {
+		return nil, err
+	}
+	return parseInstances(output)
+}
+
+func parseInstances(output []byte) (*Instance, error) {
+	dec := json.NewDecoder(bytes.NewReader(output))
+	for dec.More() {
+		var i Instance
+		err := dec.Decode(&i)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse limactl list output")
+		}
+		if i.Name == Name {
+			return &i, nil
+		}
+	}
+	return &Instance{Name: Name, Status: StatusMissing}, nil
+}
+
+// Start starts the VM, creating it first with the given configuration if it
+// does not exist yet.
+func Start(ctx context.Context, cfg Config) (*Instance, error) {
+	i, err := Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch i.Status {
+	case StatusRunning:
+		return i, nil
+	case StatusMissing:
+		cfg = cfg.WithDefaults()
+		err = cfg.Validate()
+		if err != nil {
+			return nil, err
+		}
+		dt, err := limaConfigYAML(cfg)
+		if err != nil {
+			return nil, err
+		}
+		f, err := ioutil.TempFile("", "earthly-vm-*.yaml")
+		if err != nil {
+			return nil, errors.Wrap(err, "create vm config")
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(dt)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "write vm config")
+		}
+		err = limactlStream(ctx, "start", "--tty=false", fmt.Sprintf("--name=%s", Name), f.Name())
+	default:
+		err = limactlStream(ctx, "start", "--tty=false", Name)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "start vm")
+	}
+	return Get(ctx)
+}
+
+// Stop stops the VM, if it is running. Its disk, and therefore the cache of
+// buildkitd, is kept.
+func Stop(ctx context.Context) error {
+	i, err := Get(ctx)
+	if err != nil {
+		return err
+	}
+	if i.Status != StatusRunning {
+		return nil
+	}
+	return errors.Wrap(limactlStream(ctx, "stop", Name), "stop vm")
+}
+
+// Resize changes the CPUs, memory and disk of the VM (the settings which are
+// zero are kept), restarting it if it is running. Disks can only grow.
+func Resize(ctx context.Context, cfg Config) error {
+	i, err := Get(ctx)
+	if err != nil {
+		return err
+	}
+	if i.Status == StatusMissing {
+		return errors.New("the vm does not exist yet: run earthly vm start")
+	}
+	if cfg.DiskGB > 0 && int64(cfg.DiskGB)<<30 < i.Disk {
+		return errors.Errorf("cannot shrink the disk of the vm from %dGB to %dGB", i.Disk>>30, cfg.DiskGB)
+	}
+	expr := resizeExpr(cfg)
+	if expr == "" {
+		return errors.New("nothing to resize: specify --cpus, --memory or --disk")
+	}
+	wasRunning := i.Status == StatusRunning
+	if wasRunning {
+		err = Stop(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	err = limactlStream(ctx, "edit", "--tty=false", fmt.Sprintf("--set=%s", expr), Name)
+	if err != nil {
+		return errors.Wrap(err, "resize vm")
+	}
+	if wasRunning {
+		_, err = Start(ctx, cfg)
+		return err
+	}
+	return nil
+}
+
+// resizeExpr returns the yq expression editing the config of the VM.
+func resizeExpr(cfg Config) string {
+	var exprs []string
+	if cfg.CPUs > 0 {
+		exprs = append(exprs, fmt.Sprintf(".cpus = %d", cfg.CPUs))
+	}
+	if cfg.MemoryGB > 0 {
+		exprs = append(exprs, fmt.Sprintf(".memory = \"%dGiB\"", cfg.MemoryGB))
+	}
+	if cfg.DiskGB > 0 {
+		exprs = append(exprs, fmt.Sprintf(".disk = \"%dGiB\"", cfg.DiskGB))
+	}
+	return strings.Join(exprs, " | ")
+}
+
+func limactl(ctx context.Context, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath("limactl"); err != nil {
+		return nil, ErrLimaNotInstalled
+	}
+	output, err := exec.CommandContext(ctx, "limactl", args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, errors.Wrapf(err, "limactl %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, errors.Wrapf(err, "limactl %s", args[0])
+	}
+	return output, nil
+}
+
+func limactlStream(ctx context.Context, args ...string) error {
+	if _, err := exec.LookPath("limactl"); err != nil {
+		return ErrLimaNotInstalled
+	}
+	cmd := exec.CommandContext(ctx, "limactl", args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
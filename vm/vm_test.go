@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseInstances(t *testing.T) {
+	output := []byte(`{"name":"default","status":"Running","dir":"/Users/me/.lima/default"}
+{"name":"earthly","status":"Stopped","dir":"/Users/me/.lima/earthly","vmType":"vz","cpus":4,"memory":8589934592,"disk":107374182400}
+`)
+	i, err := parseInstances(output)
+	NoError(t, err)
+	Equal(t, &Instance{
+		Name:   "earthly",
+		Status: StatusStopped,
+		Dir:    "/Users/me/.lima/earthly",
+		VMType: TypeVZ,
+		CPUs:   4,
+		Memory: 8 << 30,
+		Disk:   100 << 30,
+	}, i)
+	Equal(t, "unix:///Users/me/.lima/earthly/sock/docker.sock", i.DockerHost())
+
+	i, err = parseInstances([]byte(output[:strings.IndexByte(string(output), '\n')+1]))
+	NoError(t, err)
+	Equal(t, StatusMissing, i.Status)
+
+	_, err = parseInstances([]byte("{"))
+	Error(t, err)
+}
+
+func TestConfig(t *testing.T) {
+	cfg := Config{MemoryGB: 16}.WithDefaults()
+	Equal(t, TypeVZ, cfg.Type)
+	True(t, cfg.CPUs >= 2)
+	Equal(t, 16, cfg.MemoryGB)
+	Equal(t, 100, cfg.DiskGB)
+	NoError(t, cfg.Validate())
+	Error(t, Config{Type: "hyperkit"}.Validate())
+}
+
+func TestResizeExpr(t *testing.T) {
+	Equal(t, `.cpus = 4 | .disk = "200GiB"`, resizeExpr(Config{CPUs: 4, DiskGB: 200}))
+	Equal(t, `.memory = "16GiB"`, resizeExpr(Config{MemoryGB: 16}))
+	Equal(t, "", resizeExpr(Config{}))
+}
+
+func TestLimaConfigYAML(t *testing.T) {
+	dt, err := limaConfigYAML(Config{Type: TypeVZ, CPUs: 4, MemoryGB: 8, DiskGB: 100})
+	NoError(t, err)
+	s := string(dt)
+	True(t, strings.Contains(s, "vmType: vz\nmountType: virtiofs\ncpus: 4\nmemory: 8GiB\ndisk: 100GiB\n"), s)
+	True(t, strings.Contains(s, "hostSocket: '{{.Dir}}/sock/docker.sock'"), s)
+	True(t, strings.Contains(s, "- location: ~/.earthly\n      writable: true\n"), s)
+
+	dt, err = limaConfigYAML(Config{Type: TypeQEMU, CPUs: 2, MemoryGB: 4, DiskGB: 50})
+	NoError(t, err)
+	False(t, strings.Contains(string(dt), "mountType"))
+}